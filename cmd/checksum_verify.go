@@ -0,0 +1,267 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+)
+
+// ChecksumVerifyResult is the outcome of RunChecksumVerify.
+type ChecksumVerifyResult int
+
+const (
+	// ChecksumMatches means the checksum recomputed from the state matches the checksum stored by the snapshot.
+	ChecksumMatches ChecksumVerifyResult = iota
+	// ChecksumMismatch means the checksum recomputed from the state differs from the checksum stored by the snapshot.
+	ChecksumMismatch
+	// ChecksumNoSnapshot means the data directory has no snapshot, e.g. because the node ran without HyperSync, so
+	// there's no stored checksum to verify the state against.
+	ChecksumNoSnapshot
+)
+
+func (result ChecksumVerifyResult) String() string {
+	switch result {
+	case ChecksumMatches:
+		return "MATCHES"
+	case ChecksumMismatch:
+		return "MISMATCH"
+	case ChecksumNoSnapshot:
+		return "NO SNAPSHOT"
+	default:
+		return fmt.Sprintf("ChecksumVerifyResult(%d)", int(result))
+	}
+}
+
+// ExitCode returns the exit status of the checksum-verify command for the result, i.e. 0 if the checksums match, 1 if
+// they don't, and 2 if there's no snapshot. The command exits with 3 if the checksum can't be verified at all.
+func (result ChecksumVerifyResult) ExitCode() int {
+	return int(result)
+}
+
+// checksumVerifyErrorExitCode is the exit status of the checksum-verify command when RunChecksumVerify fails.
+const checksumVerifyErrorExitCode = 3
+
+// PrefixChecksum is the checksum of the records under a single state prefix.
+type PrefixChecksum struct {
+	Prefix   byte
+	Name     string
+	Checksum []byte
+}
+
+// ChecksumReport is the report of RunChecksumVerify. The checksum of the state is the combination of the prefix
+// checksums, which are reported on their own so that the reports of two data directories can be diffed to find the
+// prefixes that differ.
+type ChecksumReport struct {
+	DataDirectory string
+	Result        ChecksumVerifyResult
+	// BlockHeight is the height that the records were encoded at to compute the checksum, see RunChecksumVerify.
+	BlockHeight uint64
+	// SnapshotBlockHeight is the height of the last snapshot epoch.
+	SnapshotBlockHeight uint64
+	// Interrupted is true if the node was stopped in the middle of a snapshot flush, in which case the stored checksum
+	// can't be trusted until the node is started again and recovers its snapshot.
+	Interrupted      bool
+	StoredChecksum   []byte
+	ComputedChecksum []byte
+	Prefixes         []PrefixChecksum
+}
+
+// Print writes the report to the writer, with a line per prefix.
+func (report *ChecksumReport) Print(writer io.Writer) {
+	fmt.Fprintf(writer, "Data directory: %v\n", report.DataDirectory)
+	if report.Result == ChecksumNoSnapshot {
+		fmt.Fprintf(writer, "Result: %v\n", report.Result)
+		return
+	}
+	fmt.Fprintf(writer, "Block height: %v, snapshot block height: %v\n", report.BlockHeight,
+		report.SnapshotBlockHeight)
+	for _, prefix := range report.Prefixes {
+		fmt.Fprintf(writer, "  %-45v %v\n", prefix.Name, hex.EncodeToString(prefix.Checksum))
+	}
+	fmt.Fprintf(writer, "Stored checksum:   %v\n", hex.EncodeToString(report.StoredChecksum))
+	fmt.Fprintf(writer, "Computed checksum: %v\n", hex.EncodeToString(report.ComputedChecksum))
+	if report.Interrupted {
+		fmt.Fprintf(writer, "The node was stopped in the middle of a snapshot flush, start it to recover the "+
+			"snapshot before verifying it\n")
+	}
+	fmt.Fprintf(writer, "Result: %v\n", report.Result)
+}
+
+// RunChecksumVerify verifies the state of the node's data directory against the checksum stored by its snapshot,
+// without starting the node. It opens the chain db and the snapshot db, see openStoppedDB, recomputes the checksum of
+// every state prefix, see lib.ComputeStateChecksum, and compares their combination with the stored checksum. The
+// records are encoded at blockHeight, or at the snapshot's current block height if it's zero, which is the height the
+// stored checksum was maintained at. The encoding depends on lib.GlobalDeSoParams, which must be the params of the data
+// directory, as checked against its version stamp, see DataDirectoryVersion. The node must be stopped, since its dbs
+// can't be opened while it runs, but it may have crashed, in which case the report tells if it was interrupted in the
+// middle of a snapshot flush. Mismatches are reported in the result rather than as an error.
+func RunChecksumVerify(dataDir string, blockHeight uint64) (ChecksumReport, error) {
+	report := ChecksumReport{DataDirectory: dataDir}
+
+	stamp, err := ReadDataDirectoryVersion(dataDir)
+	if err != nil {
+		return report, fmt.Errorf("RunChecksumVerify: %v", err)
+	}
+	if stamp != nil && stamp.ParamsHash != paramsHash(&lib.GlobalDeSoParams) {
+		return report, fmt.Errorf("RunChecksumVerify: Data directory (%v) was written for different network params "+
+			"than lib.GlobalDeSoParams, e.g. another network or regtest", dataDir)
+	}
+
+	dbDir := lib.GetBadgerDbPath(dataDir)
+	snapshotDir := filepath.Join(dbDir, "snapshot")
+	if _, err := os.Stat(dbDir); err != nil {
+		return report, fmt.Errorf("RunChecksumVerify: Problem finding chain db (%v): %v", dbDir, err)
+	}
+	if _, err := os.Stat(snapshotDir); os.IsNotExist(err) {
+		report.Result = ChecksumNoSnapshot
+		return report, nil
+	}
+
+	chainDB, err := openStoppedDB(dbDir, dbDir)
+	if err != nil {
+		return report, fmt.Errorf("RunChecksumVerify: Problem opening chain db (%v): %v", dbDir, err)
+	}
+	defer chainDB.Close()
+	snapshotDB, err := openStoppedDB(snapshotDir, lib.GetBadgerDbPath(snapshotDir))
+	if err != nil {
+		return report, fmt.Errorf("RunChecksumVerify: Problem opening snapshot db (%v): %v", snapshotDir, err)
+	}
+	defer snapshotDB.Close()
+
+	var snapshotDBMutex sync.Mutex
+	storedChecksum := &lib.StateChecksum{}
+	if err := storedChecksum.Initialize(snapshotDB, &snapshotDBMutex); err != nil {
+		return report, fmt.Errorf("RunChecksumVerify: Problem reading stored checksum: %v", err)
+	}
+	metadata := &lib.SnapshotEpochMetadata{}
+	if err := metadata.Initialize(snapshotDB, &snapshotDBMutex); err != nil {
+		return report, fmt.Errorf("RunChecksumVerify: Problem reading snapshot metadata: %v", err)
+	}
+	status := &lib.SnapshotStatus{}
+	if err := status.Initialize(snapshotDB, &snapshotDBMutex); err != nil {
+		return report, fmt.Errorf("RunChecksumVerify: Problem reading snapshot status: %v", err)
+	}
+	if report.StoredChecksum, err = storedChecksum.ToBytes(); err != nil {
+		return report, fmt.Errorf("RunChecksumVerify: Problem encoding stored checksum: %v", err)
+	}
+	report.SnapshotBlockHeight = metadata.SnapshotBlockHeight
+	report.Interrupted = status.IsFlushing()
+	report.BlockHeight = blockHeight
+	if report.BlockHeight == 0 {
+		report.BlockHeight = status.CurrentBlockHeight
+	}
+
+	// The checksum of the state is the sum of the checksums of its prefixes, so the state is only walked once.
+	computedChecksum := &lib.StateChecksum{}
+	computedChecksum.Initialize(nil, nil)
+	for _, prefix := range lib.StatePrefixes.StatePrefixesList {
+		prefixChecksumBytes, err := lib.ComputeStateChecksum(chainDB, [][]byte{prefix}, report.BlockHeight, 0, nil)
+		if err != nil {
+			return report, fmt.Errorf("RunChecksumVerify: Problem computing checksum of prefix %v: %v",
+				lib.StatePrefixName(prefix), err)
+		}
+		prefixChecksum := &lib.StateChecksum{}
+		prefixChecksum.Initialize(nil, nil)
+		if err := prefixChecksum.FromBytes(prefixChecksumBytes); err != nil {
+			return report, fmt.Errorf("RunChecksumVerify: Problem decoding checksum of prefix %v: %v",
+				lib.StatePrefixName(prefix), err)
+		}
+		element, err := prefixChecksum.GetChecksum()
+		if err != nil {
+			return report, fmt.Errorf("RunChecksumVerify: Problem decoding checksum of prefix %v: %v",
+				lib.StatePrefixName(prefix), err)
+		}
+		computedChecksum.AddToChecksum(element)
+		report.Prefixes = append(report.Prefixes, PrefixChecksum{
+			Prefix:   prefix[0],
+			Name:     lib.StatePrefixName(prefix),
+			Checksum: prefixChecksumBytes,
+		})
+	}
+	if report.ComputedChecksum, err = computedChecksum.ToBytes(); err != nil {
+		return report, fmt.Errorf("RunChecksumVerify: Problem encoding computed checksum: %v", err)
+	}
+
+	report.Result = ChecksumMatches
+	if !bytes.Equal(report.StoredChecksum, report.ComputedChecksum) {
+		report.Result = ChecksumMismatch
+	}
+	return report, nil
+}
+
+// openStoppedDB opens the badger db of a stopped node in the directories, with the options that the node opens it
+// with. The db is opened read-only if possible. Badger refuses to open a db read-only if it wasn't closed cleanly, e.g.
+// because the node crashed, since its logs have to be replayed first. In that case, the db is opened normally, which
+// replays the logs like the node does when it starts again, and the records are read as they are after the replay.
+func openStoppedDB(dir string, valueDir string) (*badger.DB, error) {
+	opts := lib.PerformanceBadgerOptions(dir)
+	opts.ValueDir = valueDir
+	db, readOnlyErr := badger.Open(opts.WithReadOnly(true))
+	if readOnlyErr == nil {
+		return db, nil
+	}
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("openStoppedDB: Problem opening db (%v) read-only (%v), and for replay: %v", dir,
+			readOnlyErr, err)
+	}
+	glog.Infof("openStoppedDB: Opened db (%v) for replay, since it couldn't be opened read-only: %v", dir,
+		readOnlyErr)
+	return db, nil
+}
+
+var checksumVerifyCmd = &cobra.Command{
+	Use:   "checksum-verify",
+	Short: "Verify the state of a stopped node against its snapshot checksum",
+	Long: `Recomputes the state checksum of the node's data directory and compares it with the checksum stored by
+its snapshot, without starting the node. Exits with 0 if the checksums match, 1 if they don't, 2 if the data
+directory has no snapshot, and 3 if the checksum can't be verified.`,
+	Run: ChecksumVerify,
+}
+
+func init() {
+	checksumVerifyCmd.Flags().String("data-dir", "",
+		"The data directory of the node, as passed to run. When unset, defaults to the system's configuration "+
+			"directory.")
+	checksumVerifyCmd.Flags().Bool("testnet", false, "The node runs on the DeSo testnet")
+	checksumVerifyCmd.Flags().Bool("regtest", false, "The node runs in regtest, with --testnet")
+	checksumVerifyCmd.Flags().Uint64("block-height", 0,
+		"The block height to encode the records at. Defaults to the snapshot's current block height.")
+	rootCmd.AddCommand(checksumVerifyCmd)
+}
+
+func ChecksumVerify(cmd *cobra.Command, args []string) {
+	testnet, _ := cmd.Flags().GetBool("testnet")
+	regtest, _ := cmd.Flags().GetBool("regtest")
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+	blockHeight, _ := cmd.Flags().GetUint64("block-height")
+
+	params := &lib.DeSoMainnetParams
+	if testnet {
+		params = &lib.DeSoTestnetParams
+	}
+	if regtest {
+		params = lib.NewDeSoRegtestParams()
+	}
+	lib.GlobalDeSoParams = *params
+	if dataDir == "" {
+		dataDir = lib.GetDataDir(params)
+	}
+
+	report, err := RunChecksumVerify(filepath.Join(dataDir, lib.DBVersionString), blockHeight)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(checksumVerifyErrorExitCode)
+	}
+	report.Print(os.Stdout)
+	os.Exit(report.Result.ExitCode())
+}
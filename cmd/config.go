@@ -1,22 +1,37 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
 	"github.com/deso-protocol/core/lib"
 	"github.com/golang/glog"
 	"github.com/spf13/viper"
+	"io"
+	"net"
 	"os"
 	"path/filepath"
+	"strconv"
+	"time"
 )
 
+// Config is the configuration of a node. It's loaded from flags by LoadConfig, or from a file by LoadConfigFile, in
+// which case the fields that can't be set in a file are excluded from JSON.
 type Config struct {
 	// Core
-	Params               *lib.DeSoParams
+	Params               *lib.DeSoParams `json:"-"`
 	ProtocolPort         uint16
 	DataDirectory        string
 	MempoolDumpDirectory string
 	TXIndex              bool
 	Regtest              bool
 	PostgresURI          string
+	// ListenAddrs are the "host:port" addresses the node listens on for peers, e.g. "127.0.0.1:18000" or "[::1]:0",
+	// where a zero port lets the OS pick a free one. When set, they replace listening on ProtocolPort on every
+	// interface.
+	ListenAddrs []string
+	// PruneBlocksOlderThan makes the node keep the bodies of its most recent PruneBlocksOlderThan blocks only, see
+	// lib.Blockchain.SetPruneBlocksOlderThan. All blocks are kept if it's zero.
+	PruneBlocksOlderThan uint32
 
 	// Peers
 	ConnectIPs          []string
@@ -32,6 +47,9 @@ type Config struct {
 	IgnoreInboundInvs bool
 	MaxInboundPeers   uint32
 	OneInboundPerIp   bool
+	// Standalone means the node is expected to run without peers, e.g. a regtest node that mines its own blocks, so
+	// it's ready without any, see Node.Healthz.
+	Standalone bool
 
 	// Snapshot
 	HyperSync                 bool
@@ -64,6 +82,31 @@ type Config struct {
 	LogDBSummarySnapshots bool
 	DatadogProfiler       bool
 	TimeEvents            bool
+	// MetricsPort is the port on which the node serves its metrics in the Prometheus text format, at /metrics, and its
+	// health probes, at /healthz and /readyz. Nothing is served if it's zero, but the metrics and health can still be
+	// read with Node.Metrics and Node.Healthz.
+	MetricsPort uint16
+
+	// Testing
+	// TimeOffset skews the node's clock from the machine clock. It's used to simulate clock skew between nodes in
+	// integration tests, so it can't be set with a flag.
+	TimeOffset time.Duration
+	// DBFaultInjector makes the node's db writes fail on demand, to simulate I/O errors in integration tests. It
+	// requires HyperSync, since faults are injected through the node's snapshot, and can't be set with a flag.
+	DBFaultInjector *lib.DBFaultInjector `json:"-"`
+	// LogName and LogOutput make the node write its own log lines, prefixed with LogName, to LogOutput in addition to
+	// glog, so that tests running several nodes in one process can tell the nodes' logs apart. Log lines from the lib
+	// package only go to glog. They can't be set with a flag.
+	LogName   string
+	LogOutput io.Writer `json:"-"`
+	// LogLevels are the node's log verbosities by module pattern, on top of GlogV and GlogVmodule, see
+	// Node.SetLogLevel. They can't be set with a flag.
+	LogLevels map[string]int
+	// InMemory makes the node keep its chain, snapshot, and txindex dbs in memory rather than under DataDirectory,
+	// which isn't needed then, so that tests don't pay for disk I/O or leave data directories behind. The dbs are kept
+	// when the node is restarted with Node.Restart, or by the engine, but discarded when it's stopped, see Node.Stop,
+	// or erased. It can't be set with a flag.
+	InMemory bool
 }
 
 func LoadConfig() *Config {
@@ -81,6 +124,7 @@ func LoadConfig() *Config {
 	if config.ProtocolPort <= 0 {
 		config.ProtocolPort = config.Params.DefaultSocketPort
 	}
+	config.ListenAddrs = viper.GetStringSlice("listen-addrs")
 
 	dataDir := viper.GetString("data-dir")
 	if dataDir == "" {
@@ -95,6 +139,7 @@ func LoadConfig() *Config {
 	config.TXIndex = viper.GetBool("txindex")
 	config.Regtest = viper.GetBool("regtest")
 	config.PostgresURI = viper.GetString("postgres-uri")
+	config.PruneBlocksOlderThan = viper.GetUint32("prune-blocks-older-than")
 	config.HyperSync = viper.GetBool("hypersync")
 	config.ForceChecksum = viper.GetBool("force-checksum")
 	config.SyncType = lib.NodeSyncType(viper.GetString("sync-type"))
@@ -116,6 +161,7 @@ func LoadConfig() *Config {
 	config.IgnoreInboundInvs = viper.GetBool("ignore-inbound-invs")
 	config.MaxInboundPeers = viper.GetUint32("max-inbound-peers")
 	config.OneInboundPerIp = viper.GetBool("one-inbound-per-ip")
+	config.Standalone = viper.GetBool("standalone")
 
 	// Mining + Admin
 	config.MinerPublicKeys = viper.GetStringSlice("miner-public-keys")
@@ -143,14 +189,131 @@ func LoadConfig() *Config {
 	config.LogDBSummarySnapshots = viper.GetBool("log-db-summary-snapshots")
 	config.DatadogProfiler = viper.GetBool("datadog-profiler")
 	config.TimeEvents = viper.GetBool("time-events")
+	config.MetricsPort = uint16(viper.GetUint64("metrics-port"))
 
 	return &config
 }
 
+// Validate checks that the config's fields are in range and consistent with each other, so that a bad config fails
+// before the node starts, rather than with confusing behavior far from the root cause. It returns all violations joined
+// into a single error, each naming the fields and flags to fix, or nil if the config is valid. A zero ProtocolPort is
+// valid, and makes the node listen on a free port, see Node.ListeningPort.
+func (config *Config) Validate() error {
+	var violations []error
+	violate := func(format string, args ...interface{}) {
+		violations = append(violations, fmt.Errorf("Config.Validate: "+format, args...))
+	}
+
+	// Core
+	if config.Params == nil {
+		violate("Params must be set, e.g. with --testnet")
+	} else if config.Regtest && config.Params.NetworkType != lib.NetworkType_TESTNET {
+		violate("Regtest requires testnet Params, got (%v) Params, set --testnet", config.Params.NetworkType)
+	}
+	if config.DataDirectory == "" && !config.InMemory {
+		violate("DataDirectory must be set, set --data-dir")
+	}
+	if config.TXIndex && config.PostgresURI != "" {
+		violate("TXIndex isn't supported with Postgres, unset --txindex or --postgres-uri")
+	}
+	if config.PruneBlocksOlderThan > 0 {
+		if config.TXIndex {
+			violate("TXIndex needs all blocks, so it isn't supported with PruneBlocksOlderThan, unset --txindex or " +
+				"--prune-blocks-older-than")
+		}
+		if config.PostgresURI != "" {
+			violate("PruneBlocksOlderThan isn't supported with Postgres, unset --prune-blocks-older-than or " +
+				"--postgres-uri")
+		}
+		if config.HyperSync && lib.IsNodeArchival(config.SyncType) {
+			violate("SyncType (%v) downloads all historical blocks after HyperSync, so it isn't supported with "+
+				"PruneBlocksOlderThan, set --sync-type=hypersync or unset --prune-blocks-older-than", config.SyncType)
+		}
+		// Recovering the snapshot after a crash disconnects the blocks after the last snapshot height.
+		if config.HyperSync && uint64(config.PruneBlocksOlderThan) < config.snapshotBlockHeightPeriod() {
+			violate("PruneBlocksOlderThan (%v) is below SnapshotBlockHeightPeriod (%v), so the node couldn't "+
+				"recover its snapshot, raise --prune-blocks-older-than or lower --snapshot-block-height-period",
+				config.PruneBlocksOlderThan, config.snapshotBlockHeightPeriod())
+		}
+	}
+	for _, addr := range config.ListenAddrs {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			violate("ListenAddrs has an invalid address (%v), fix --listen-addrs: %v", addr, err)
+			continue
+		}
+		if host != "" && net.ParseIP(host) == nil {
+			violate("ListenAddrs has an address (%v) whose host isn't an IP, fix --listen-addrs", addr)
+		}
+		if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+			violate("ListenAddrs has an address (%v) with an invalid port, fix --listen-addrs: %v", addr, err)
+		}
+	}
+
+	// Peers
+	if config.DisableNetworking && len(config.ConnectIPs) > 0 {
+		violate("ConnectIPs (%v) can't be connected with DisableNetworking, unset --connect-ips or "+
+			"--disable-networking", config.ConnectIPs)
+	}
+
+	// Snapshot
+	if err := lib.ValidateHyperSyncFlags(config.HyperSync, config.SyncType); err != nil {
+		violate("%v", err)
+	}
+	if config.HyperSync && config.PostgresURI != "" {
+		violate("HyperSync isn't supported with Postgres, unset --hypersync or --postgres-uri")
+	}
+	if config.HyperSync && config.MaxSyncBlockHeight > 0 &&
+		uint64(config.MaxSyncBlockHeight) < config.snapshotBlockHeightPeriod() {
+		violate("MaxSyncBlockHeight (%v) is below SnapshotBlockHeightPeriod (%v), so the node would never take a "+
+			"snapshot, raise --max-sync-block-height or lower --snapshot-block-height-period",
+			config.MaxSyncBlockHeight, config.snapshotBlockHeightPeriod())
+	}
+
+	// Mining
+	for _, publicKey := range config.MinerPublicKeys {
+		if _, _, err := lib.Base58CheckDecode(publicKey); err != nil {
+			violate("MinerPublicKeys has an invalid public key (%v), fix --miner-public-keys: %v", publicKey, err)
+		}
+	}
+
+	// BlockProducer
+	for _, publicKey := range config.TrustedBlockProducerPublicKeys {
+		if _, _, err := lib.Base58CheckDecode(publicKey); err != nil {
+			violate("TrustedBlockProducerPublicKeys has an invalid public key (%v), fix "+
+				"--trusted-block-producer-public-keys: %v", publicKey, err)
+		}
+	}
+
+	// Logging
+	if config.MetricsPort != 0 && config.MetricsPort == config.ProtocolPort {
+		violate("MetricsPort (%v) is the ProtocolPort, change --metrics-port or --protocol-port", config.MetricsPort)
+	}
+
+	// Testing
+	if config.DBFaultInjector != nil && !config.HyperSync {
+		violate("DBFaultInjector requires HyperSync")
+	}
+	return errors.Join(violations...)
+}
+
+// snapshotBlockHeightPeriod returns the snapshot period the node runs with, which defaults to
+// lib.SnapshotBlockHeightPeriod when SnapshotBlockHeightPeriod is zero, like lib.NewSnapshot does.
+func (config *Config) snapshotBlockHeightPeriod() uint64 {
+	if config.SnapshotBlockHeightPeriod == 0 {
+		return lib.SnapshotBlockHeightPeriod
+	}
+	return config.SnapshotBlockHeightPeriod
+}
+
 func (config *Config) Print() {
 	glog.Infof("Logging to directory %s", config.LogDirectory)
 	glog.Infof("Running node in %s mode", config.Params.NetworkType)
-	glog.Infof("Data Directory: %s", config.DataDirectory)
+	if config.InMemory {
+		glog.Infof("Data Directory: IN MEMORY")
+	} else {
+		glog.Infof("Data Directory: %s", config.DataDirectory)
+	}
 
 	if config.MempoolDumpDirectory != "" {
 		glog.Infof("Mempool Dump Directory: %s", config.MempoolDumpDirectory)
@@ -186,6 +349,10 @@ func (config *Config) Print() {
 		glog.Infof("MaxSyncBlockHeight: %v", config.MaxSyncBlockHeight)
 	}
 
+	if config.PruneBlocksOlderThan > 0 {
+		glog.Infof("PruneBlocksOlderThan: %v", config.PruneBlocksOlderThan)
+	}
+
 	if len(config.ConnectIPs) > 0 {
 		glog.Infof("Connect IPs: %s", config.ConnectIPs)
 	}
@@ -210,8 +377,20 @@ func (config *Config) Print() {
 		glog.Infof("IGNORING INBOUND INVS")
 	}
 
+	if config.Standalone {
+		glog.Infof("STANDALONE MODE")
+	}
+
 	glog.Infof("Max Inbound Peers: %d", config.MaxInboundPeers)
-	glog.Infof("Protocol listening on port %d", config.ProtocolPort)
+	if len(config.ListenAddrs) > 0 {
+		glog.Infof("Protocol listening on addresses: %s", config.ListenAddrs)
+	} else {
+		glog.Infof("Protocol listening on port %d", config.ProtocolPort)
+	}
+
+	if config.MetricsPort != 0 {
+		glog.Infof("Metrics listening on port %d", config.MetricsPort)
+	}
 
 	if len(config.MinerPublicKeys) > 0 {
 		glog.Infof("Mining with public keys: %s", config.MinerPublicKeys)
@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/deso-protocol/core/lib"
+	"gopkg.in/yaml.v3"
+)
+
+// configDocument is the format of a config file, and of the config overrides. It has the fields of Config, with the
+// same names, and the network that selects the Params by name, since the Params themselves can't be set in a file.
+type configDocument struct {
+	// Network is "mainnet" or "testnet".
+	Network string
+	*Config
+}
+
+// ConfigOverride changes a config loaded by LoadConfigFile, after the file is applied.
+type ConfigOverride func(config *Config) error
+
+// LoadConfigFile loads a config from a YAML file, or a JSON file if the path has the .json extension. The file has the
+// fields of Config with the same names, e.g. HyperSync or MaxSyncBlockHeight, and a Network field, mainnet or
+// testnet, that selects the Params. Fields that aren't set keep their zero value, and unknown fields are rejected, so
+// that a misspelled field doesn't get ignored. The overrides are applied in order on top of the file, e.g. overrides
+// from the environment with EnvConfigOverride, or from code. The config isn't validated, see Config.Validate. It's not
+// named LoadConfig, since LoadConfig loads the config from the flags.
+func LoadConfigFile(path string, overrides ...ConfigOverride) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("LoadConfigFile: Problem reading config file: %v", err)
+	}
+	if filepath.Ext(path) != ".json" {
+		if data, err = yamlToJSON(data); err != nil {
+			return nil, fmt.Errorf("LoadConfigFile: Problem parsing YAML config file (%v): %v", path, err)
+		}
+	}
+
+	config := &Config{}
+	network, err := decodeConfigDocument(data, config)
+	if err != nil {
+		return nil, fmt.Errorf("LoadConfigFile: Problem decoding config file (%v): %v", path, err)
+	}
+	if network == "" {
+		return nil, fmt.Errorf("LoadConfigFile: Config file (%v) must set the Network, mainnet or testnet", path)
+	}
+	for _, override := range overrides {
+		if err := override(config); err != nil {
+			return nil, fmt.Errorf("LoadConfigFile: Problem overriding config file (%v): %v", path, err)
+		}
+	}
+	return config, nil
+}
+
+// EnvConfigOverride overrides the config fields that are set in the environment, with variables named by the prefix
+// followed by the field name, e.g. DESO_CONFIG_MaxSyncBlockHeight=2000 with the DESO_CONFIG_ prefix. The values are
+// parsed as YAML, so that lists can be set as e.g. [a, b]. The Network can be overridden as well. Variables with the
+// prefix that don't name a field are rejected.
+func EnvConfigOverride(prefix string) ConfigOverride {
+	return func(config *Config) error {
+		fields := make(map[string]interface{})
+		for _, variable := range os.Environ() {
+			name, value, _ := strings.Cut(variable, "=")
+			if !strings.HasPrefix(name, prefix) {
+				continue
+			}
+			var fieldValue interface{}
+			if err := yaml.Unmarshal([]byte(value), &fieldValue); err != nil {
+				return fmt.Errorf("EnvConfigOverride: Problem parsing environment variable (%v): %v", name, err)
+			}
+			fields[strings.TrimPrefix(name, prefix)] = fieldValue
+		}
+		if len(fields) == 0 {
+			return nil
+		}
+		data, err := json.Marshal(fields)
+		if err != nil {
+			return fmt.Errorf("EnvConfigOverride: Problem encoding environment variables: %v", err)
+		}
+		if _, err := decodeConfigDocument(data, config); err != nil {
+			return fmt.Errorf("EnvConfigOverride: Problem decoding environment variables: %v", err)
+		}
+		return nil
+	}
+}
+
+// decodeConfigDocument sets the fields of the config that are set in the JSON config document, and the Params if the
+// document sets the Network. It returns the Network.
+func decodeConfigDocument(data []byte, config *Config) (_network string, _err error) {
+	document := configDocument{Config: config}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&document); err != nil {
+		return "", err
+	}
+
+	// Every config gets its own deep copy of the Params, since starting the node can modify them, e.g. in regtest mode.
+	switch document.Network {
+	case "":
+	case "mainnet":
+		config.Params = lib.DeSoMainnetParams.Copy()
+	case "testnet":
+		config.Params = lib.DeSoTestnetParams.Copy()
+	default:
+		return "", fmt.Errorf("unknown Network (%v), should be mainnet or testnet", document.Network)
+	}
+	return document.Network, nil
+}
+
+// yamlToJSON converts a YAML document to JSON, so that it's decoded with the field names and the unknown field checks
+// of encoding/json.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var document interface{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	if document == nil {
+		document = map[string]interface{}{}
+	}
+	return json.Marshal(document)
+}
@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// DataDirectorySchemaVersion is the version of the data directory format written by this binary, before any
+// registered migration. Data directories written before the version was stamped are at this version.
+const DataDirectorySchemaVersion uint32 = 1
+
+// dataDirectoryVersionFileName is the name of the version stamp in the data directory, see DataDirectoryVersion.
+const dataDirectoryVersionFileName = "version.json"
+
+// DataDirectoryVersion is the version stamp of a data directory. It's written when the node first starts on the data
+// directory, and checked every time the node starts on it again, so that a data directory written by an incompatible
+// binary, or for another network, fails with a clear error rather than with misreads deep in decoding.
+type DataDirectoryVersion struct {
+	// SchemaVersion is the version of the data directory format, see CurrentSchemaVersion.
+	SchemaVersion uint32
+	// EncoderVersion is the latest encoder migration version of the binary that wrote the data directory. A binary
+	// can read the entries of older encoder versions, but not of newer ones.
+	EncoderVersion byte
+	// ParamsHash identifies the network params the data directory was written with, see paramsHash.
+	ParamsHash string
+}
+
+// MigrationFunc migrates the data directory of the node with the config from one schema version to the next, see
+// RegisterMigration. It's called with the chain db open, before the node reads from it.
+type MigrationFunc func(chainDB *badger.DB, config *Config) error
+
+type dataDirectoryMigration struct {
+	fromVersion uint32
+	toVersion   uint32
+	migrate     MigrationFunc
+}
+
+var (
+	dataDirectoryMigrationsMtx sync.Mutex
+	// dataDirectoryMigrations are the registered migrations by the schema version they migrate from.
+	dataDirectoryMigrations = make(map[uint32]*dataDirectoryMigration)
+)
+
+// RegisterMigration registers a migration of data directories from fromVersion to toVersion, which the node runs when
+// it starts on a data directory at fromVersion. Registering a migration raises the CurrentSchemaVersion to toVersion,
+// if it's higher. Migrations run in a chain from the data directory's version up to the current version, and the
+// version stamp is updated after each of them, so that every migration runs exactly once. It panics if toVersion isn't
+// above fromVersion, or if a migration from fromVersion is already registered. The returned function unregisters the
+// migration, which is mainly used in testing.
+func RegisterMigration(fromVersion uint32, toVersion uint32, migrate MigrationFunc) (_unregister func()) {
+	if toVersion <= fromVersion {
+		panic(fmt.Sprintf("RegisterMigration: toVersion (%v) must be above fromVersion (%v)", toVersion,
+			fromVersion))
+	}
+	dataDirectoryMigrationsMtx.Lock()
+	defer dataDirectoryMigrationsMtx.Unlock()
+	if _, exists := dataDirectoryMigrations[fromVersion]; exists {
+		panic(fmt.Sprintf("RegisterMigration: A migration from version (%v) is already registered", fromVersion))
+	}
+	migration := &dataDirectoryMigration{fromVersion: fromVersion, toVersion: toVersion, migrate: migrate}
+	dataDirectoryMigrations[fromVersion] = migration
+	return func() {
+		dataDirectoryMigrationsMtx.Lock()
+		defer dataDirectoryMigrationsMtx.Unlock()
+		if dataDirectoryMigrations[fromVersion] == migration {
+			delete(dataDirectoryMigrations, fromVersion)
+		}
+	}
+}
+
+// CurrentSchemaVersion returns the data directory schema version of this binary, which is the highest version that a
+// registered migration migrates to, or DataDirectorySchemaVersion if there's none above it.
+func CurrentSchemaVersion() uint32 {
+	dataDirectoryMigrationsMtx.Lock()
+	defer dataDirectoryMigrationsMtx.Unlock()
+	version := DataDirectorySchemaVersion
+	for _, migration := range dataDirectoryMigrations {
+		if migration.toVersion > version {
+			version = migration.toVersion
+		}
+	}
+	return version
+}
+
+// getDataDirectoryMigration returns the migration registered from the version, or nil if there's none.
+func getDataDirectoryMigration(fromVersion uint32) *dataDirectoryMigration {
+	dataDirectoryMigrationsMtx.Lock()
+	defer dataDirectoryMigrationsMtx.Unlock()
+	return dataDirectoryMigrations[fromVersion]
+}
+
+// NewDataDirectoryVersion returns the version stamp that this binary writes for a node with the params.
+func NewDataDirectoryVersion(params *lib.DeSoParams) *DataDirectoryVersion {
+	version := &DataDirectoryVersion{
+		SchemaVersion: CurrentSchemaVersion(),
+		ParamsHash:    paramsHash(params),
+	}
+	for _, migration := range params.EncoderMigrationHeightsList {
+		if migration.Version > version.EncoderVersion {
+			version.EncoderVersion = migration.Version
+		}
+	}
+	return version
+}
+
+// paramsHash returns the hex encoded hash of what identifies the network of the params, i.e. the network type, the
+// genesis block, and whether regtest is enabled, since regtest keeps the testnet genesis block. Fork and encoder
+// migration heights are left out on purpose, since they change with node upgrades, which are handled by the
+// EncoderVersion of the stamp and the registered migrations instead.
+func paramsHash(params *lib.DeSoParams) string {
+	hash := sha256.New()
+	isRegtest := len(params.ExtraRegtestParamUpdaterKeys) > 0
+	fmt.Fprintf(hash, "%v|%v|%v", params.NetworkType, params.GenesisBlockHashHex, isRegtest)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// ReadDataDirectoryVersion reads the version stamp of the data directory. It returns nil without an error if the data
+// directory has no stamp, e.g. because it's new.
+func ReadDataDirectoryVersion(dataDirectory string) (*DataDirectoryVersion, error) {
+	versionBytes, err := os.ReadFile(filepath.Join(dataDirectory, dataDirectoryVersionFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ReadDataDirectoryVersion: Problem reading version of (%v): %v", dataDirectory, err)
+	}
+	version := &DataDirectoryVersion{}
+	if err := json.Unmarshal(versionBytes, version); err != nil {
+		return nil, fmt.Errorf("ReadDataDirectoryVersion: Problem decoding version of (%v): %v", dataDirectory, err)
+	}
+	return version, nil
+}
+
+// WriteDataDirectoryVersion stamps the data directory with the version. The stamp is written to a temporary file
+// first, so that a crash doesn't leave a truncated stamp behind.
+func WriteDataDirectoryVersion(dataDirectory string, version *DataDirectoryVersion) error {
+	versionBytes, err := json.MarshalIndent(version, "", "  ")
+	if err != nil {
+		return fmt.Errorf("WriteDataDirectoryVersion: Problem encoding version: %v", err)
+	}
+	path := filepath.Join(dataDirectory, dataDirectoryVersionFileName)
+	if err := os.WriteFile(path+".tmp", versionBytes, 0644); err != nil {
+		return fmt.Errorf("WriteDataDirectoryVersion: Problem writing version of (%v): %v", dataDirectory, err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("WriteDataDirectoryVersion: Problem writing version of (%v): %v", dataDirectory, err)
+	}
+	return nil
+}
+
+// migrateDataDirectory checks the version stamp of the node's data directory against this binary, and runs the
+// registered migrations from the data directory's schema version up to the current one. A data directory without a
+// stamp is stamped with the current version if it's new, and treated as DataDirectorySchemaVersion otherwise, since it
+// was written before stamps existed. It returns an error, without touching the data directory, if the data directory
+// was written for other network params, or by a newer binary, or if there's no migration from its schema version.
+func (node *Node) migrateDataDirectory(chainDB *badger.DB, isNewDataDirectory bool) error {
+	dataDirectory := node.Config.DataDirectory
+	current := NewDataDirectoryVersion(node.Params)
+	stamp, err := ReadDataDirectoryVersion(dataDirectory)
+	if err != nil {
+		return err
+	}
+	isStamped := stamp != nil
+	if !isStamped {
+		if isNewDataDirectory {
+			return WriteDataDirectoryVersion(dataDirectory, current)
+		}
+		stamp = &DataDirectoryVersion{
+			SchemaVersion:  DataDirectorySchemaVersion,
+			EncoderVersion: current.EncoderVersion,
+			ParamsHash:     current.ParamsHash,
+		}
+	}
+
+	if stamp.ParamsHash != current.ParamsHash {
+		return fmt.Errorf("Node.migrateDataDirectory: Data directory (%v) was written for different network "+
+			"params, e.g. another network or regtest, use another --data-dir", dataDirectory)
+	}
+	if stamp.EncoderVersion > current.EncoderVersion {
+		return fmt.Errorf("Node.migrateDataDirectory: Data directory (%v) was written by a newer version with "+
+			"encoder version (%v), this version supports up to encoder version (%v), upgrade the node",
+			dataDirectory, stamp.EncoderVersion, current.EncoderVersion)
+	}
+	if stamp.SchemaVersion > current.SchemaVersion {
+		return fmt.Errorf("Node.migrateDataDirectory: Data directory (%v) was written by a newer version with "+
+			"schema version (%v), this version supports up to schema version (%v), upgrade the node",
+			dataDirectory, stamp.SchemaVersion, current.SchemaVersion)
+	}
+
+	// Find the chain of migrations before running any of them, so that a missing one leaves the data directory as is.
+	var migrations []*dataDirectoryMigration
+	for version := stamp.SchemaVersion; version < current.SchemaVersion; {
+		migration := getDataDirectoryMigration(version)
+		if migration == nil {
+			return fmt.Errorf("Node.migrateDataDirectory: Data directory (%v) has schema version (%v), but no "+
+				"migration is registered from version (%v) towards the current schema version (%v)", dataDirectory,
+				stamp.SchemaVersion, version, current.SchemaVersion)
+		}
+		migrations = append(migrations, migration)
+		version = migration.toVersion
+	}
+	for _, migration := range migrations {
+		node.log.Infof("Node.migrateDataDirectory: Migrating data directory (%v) from schema version (%v) to (%v)",
+			dataDirectory, migration.fromVersion, migration.toVersion)
+		if err := migration.migrate(chainDB, node.Config); err != nil {
+			return fmt.Errorf("Node.migrateDataDirectory: Problem migrating data directory (%v) from schema "+
+				"version (%v) to (%v): %v", dataDirectory, migration.fromVersion, migration.toVersion, err)
+		}
+		stamp.SchemaVersion = migration.toVersion
+		if err := WriteDataDirectoryVersion(dataDirectory, stamp); err != nil {
+			return err
+		}
+		isStamped = true
+	}
+
+	// Entries of older encoder versions are migrated by the encoder migrations as they're read.
+	if !isStamped || stamp.EncoderVersion != current.EncoderVersion {
+		stamp.EncoderVersion = current.EncoderVersion
+		return WriteDataDirectoryVersion(dataDirectory, stamp)
+	}
+	return nil
+}
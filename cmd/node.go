@@ -1,10 +1,13 @@
 package cmd
 
 import (
+	"context"
 	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"sync"
@@ -34,9 +37,9 @@ type Node struct {
 	Config   *Config
 	Postgres *lib.Postgres
 
-	// IsRunning is false when a NewNode is created, set to true on Start(), set to false
-	// after Stop() is called. Mainly used in testing.
-	IsRunning bool
+	// state is the NodeState of the node, see State. It's only changed with the runningMutex held, but it's read
+	// atomically, so that it can be read while the node starts or stops.
+	state int32
 	// runningMutex is held whenever we call Start() or Stop() on the node.
 	runningMutex sync.Mutex
 
@@ -46,38 +49,109 @@ type Node struct {
 	nodeMessageChan chan lib.NodeMessage
 	// stopWaitGroup allows us to wait for the node to fully close.
 	stopWaitGroup sync.WaitGroup
+	// listeners are the protocol listeners bound by Start. When Config.ProtocolPort is zero, they're bound to a free
+	// port picked by the OS, which is only known through the listeners. boundPort is the port they were bound to, which
+	// the node binds again when it's started again, so that its peers can find it after a restart.
+	listeners []net.Listener
+	boundPort uint16
+	// boundAddrs maps every address of Config.ListenAddrs to the address it was bound to, which the node binds again
+	// when it's started again, like boundPort.
+	boundAddrs map[string]string
+	// restarting is 1 while the node is restarting, see RestartWithConfig.
+	restarting int32
+	// restartErr is the error of the last restart requested by the engine, see RestartError.
+	restartErr      error
+	restartErrMutex sync.Mutex
+	// inMemoryDBs are the dbs of the node if it's in memory, see Config.InMemory. They're kept across restarts.
+	inMemoryDBs inMemoryDBs
+	// log is the node's logger, which also writes to Config.LogOutput if it's set.
+	log *nodeLogger
+	// statusTracker tracks the status of the running node, see Status. It's nil while the node isn't running, and
+	// it's protected by statusMutex rather than runningMutex, so that Status doesn't wait for Start or Stop.
+	statusTracker *nodeStatusTracker
+	statusMutex   sync.RWMutex
+	// events fans the node's events out to the subscribers from Subscribe. stopEvents stops forwarding the events of
+	// the running node's subsystems, and it's nil while the node isn't running.
+	events     nodeEventBus
+	stopEvents func()
+	// metrics is the registry of the node's metrics, see Metrics. The metrics are served on metricsListener by
+	// metricsServer while the node runs, if Config.MetricsPort is set.
+	metrics         *lib.MetricsRegistry
+	metricsListener net.Listener
+	metricsServer   *http.Server
 }
 
 func NewNode(config *Config) *Node {
 	result := Node{}
 	result.Config = config
 	result.Params = config.Params
+	result.log = newNodeLogger(config)
 	result.internalExitChan = make(chan struct{})
 	result.nodeMessageChan = make(chan lib.NodeMessage)
+	result.metrics = lib.NewMetricsRegistry()
+	result.metrics.AddCollector(result.collectMetrics)
 
 	return &result
 }
 
 // Start is the main function used to kick off the node. The exitChannels are optionally passed by the caller to receive
 // signals from the node. In particular, exitChannels will be closed by the node when the node is shutting down for good.
-func (node *Node) Start(exitChannels ...*chan struct{}) {
+// Start returns once the node is serving, i.e. it's listening on the protocol port and its blockchain is initialized,
+// or with an error if the node couldn't get there, e.g. because the port is already in use or the db can't be opened.
+// In that case, the resources acquired so far are released, and the node is stopped. If the node is already running,
+// Start returns ErrNodeAlreadyRunning without doing anything. If the node is stopping, Start waits for it to stop.
+func (node *Node) Start(exitChannels ...*chan struct{}) error {
+	node.runningMutex.Lock()
+	defer node.runningMutex.Unlock()
+
+	if node.State() == NodeStateRunning {
+		return ErrNodeAlreadyRunning
+	}
 	// TODO: Replace glog with logrus so we can also get rid of flag library
 	flag.Set("log_dir", node.Config.LogDirectory)
 	flag.Set("v", fmt.Sprintf("%d", node.Config.GlogV))
-	flag.Set("vmodule", node.Config.GlogVmodule)
+	flag.Set("vmodule", glogVmodule(node.Config.GlogVmodule))
 	flag.Set("alsologtostderr", "true")
 	flag.Parse()
 	glog.CopyStandardLogTo("INFO")
-	node.runningMutex.Lock()
-	defer node.runningMutex.Unlock()
-
+	node.setState(NodeStateStarting)
 	node.internalExitChan = make(chan struct{})
 	node.nodeMessageChan = make(chan lib.NodeMessage)
 
 	// listenToNodeMessages handles the messages received from the engine through the nodeMessageChan.
-	go node.listenToNodeMessages()
+	go node.listenToNodeMessages(exitChannels...)
+
+	// abortStart releases what was set up before the node failed to start, so that it can be started again.
+	var listeners []net.Listener
+	var metricsListener net.Listener
+	var desoAddrMgr *addrmgr.AddrManager
+	var chainDB *badger.DB
+	abortStart := func(err error) error {
+		for _, listener := range listeners {
+			listener.Close()
+		}
+		if metricsListener != nil {
+			metricsListener.Close()
+		}
+		if desoAddrMgr != nil {
+			desoAddrMgr.Stop()
+		}
+		if node.Config.InMemory {
+			node.discardInMemoryDBs()
+		} else if chainDB != nil {
+			chainDB.Close()
+		}
+		close(node.internalExitChan)
+		node.internalExitChan = nil
+		node.setState(NodeStateStopped)
+		node.log.Errorf(lib.CLog(lib.Red, err.Error()))
+		return err
+	}
 
-	// Print config
+	// Validate and print config
+	if err := node.Config.Validate(); err != nil {
+		return abortStart(fmt.Errorf("Node.Start: Invalid config: %v", err))
+	}
 	node.Config.Print()
 
 	// Check for regtest mode
@@ -86,7 +160,9 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 	}
 
 	// Validate params
-	validateParams(node.Params)
+	if err := validateParams(node.Params); err != nil {
+		return abortStart(fmt.Errorf("Node.Start: Invalid params: %v", err))
+	}
 	// This is a bit of a hack, and we should deprecate this. We rely on GlobalDeSoParams static variable in only one
 	// place in the core code, namely in encoder migrations. Encoder migrations allow us to update the core database
 	// schema without requiring a resync. GlobalDeSoParams is used so that encoders know if we're on mainnet or testnet.
@@ -97,7 +173,7 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 		tracer.Start()
 		err := profiler.Start(profiler.WithProfileTypes(profiler.CPUProfile, profiler.BlockProfile, profiler.MutexProfile, profiler.GoroutineProfile, profiler.HeapProfile))
 		if err != nil {
-			glog.Fatal(err)
+			return abortStart(fmt.Errorf("Node.Start: Problem starting Datadog profiler: %v", err))
 		}
 	}
 
@@ -108,64 +184,111 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 	// Setup statsd
 	statsdClient, err := statsd.New(fmt.Sprintf("%s:%d", os.Getenv("DD_AGENT_HOST"), 8125))
 	if err != nil {
-		glog.Fatal(err)
+		return abortStart(fmt.Errorf("Node.Start: Problem setting up statsd client: %v", err))
 	}
 
-	// Setup listeners and peers
-	desoAddrMgr := addrmgr.New(node.Config.DataDirectory, net.LookupIP)
-	desoAddrMgr.Start()
-
-	// This just gets localhost listening addresses on the protocol port.
-	// Such as [{127.0.0.1 18000 } {::1 18000 }], and associated listener structs.
-	listeningAddrs, listeners := GetAddrsToListenOn(node.Config.ProtocolPort)
-	_ = listeningAddrs
+	// Setup listeners and peers. If no ListenAddrs are configured, this just gets localhost listening addresses on
+	// the protocol port. Such as [{127.0.0.1 18000 } {::1 18000 }], and associated listener structs.
+	if len(node.Config.ListenAddrs) > 0 {
+		listeners, err = node.listenOnConfiguredAddrs()
+		if err != nil {
+			return abortStart(fmt.Errorf("Node.Start: Problem listening on addresses (%v): %v",
+				node.Config.ListenAddrs, err))
+		}
+	} else {
+		protocolPort := node.Config.ProtocolPort
+		if protocolPort == 0 {
+			protocolPort = node.boundPort
+		}
+		_, listeners, err = GetAddrsToListenOn(protocolPort)
+		if err != nil {
+			return abortStart(fmt.Errorf("Node.Start: Problem listening on protocol port (%v): %v", protocolPort, err))
+		}
+		if len(listeners) > 0 {
+			node.boundPort = uint16(listeners[0].Addr().(*net.TCPAddr).Port)
+		}
+	}
+	node.listeners = listeners
+	metricsListener, err = node.listenMetrics()
+	if err != nil {
+		return abortStart(fmt.Errorf("Node.Start: Problem listening on metrics port (%v): %v",
+			node.Config.MetricsPort, err))
+	}
+	desoAddrMgr = addrmgr.New(node.Config.DataDirectory, net.LookupIP)
+	// Starting the address manager loads and periodically saves the known peers in the data directory, which an
+	// in-memory node doesn't have, so the peers of an in-memory node are only kept in memory.
+	if !node.Config.InMemory {
+		desoAddrMgr.Start()
+	}
 
 	// If --connect-ips is not passed, we will connect the addresses from
 	// --add-ips, DNSSeeds, and DNSSeedGenerators.
 	if len(node.Config.ConnectIPs) == 0 {
-		glog.Infof("Looking for AddIPs: %v", len(node.Config.AddIPs))
+		node.log.Infof("Looking for AddIPs: %v", len(node.Config.AddIPs))
 		for _, host := range node.Config.AddIPs {
-			addIPsForHost(desoAddrMgr, host, node.Params)
+			addIPsForHost(node.log, desoAddrMgr, host, node.Params)
 		}
 
-		glog.Infof("Looking for DNSSeeds: %v", len(node.Params.DNSSeeds))
+		node.log.Infof("Looking for DNSSeeds: %v", len(node.Params.DNSSeeds))
 		for _, host := range node.Params.DNSSeeds {
-			addIPsForHost(desoAddrMgr, host, node.Params)
+			addIPsForHost(node.log, desoAddrMgr, host, node.Params)
 		}
 
 		// This is where we connect to addresses from DNSSeeds.
 		if !node.Config.PrivateMode {
-			go addSeedAddrsFromPrefixes(desoAddrMgr, node.Params)
+			go addSeedAddrsFromPrefixes(node.log, desoAddrMgr, node.Params)
 		}
 	}
 
-	// Setup chain database
-	dbDir := lib.GetBadgerDbPath(node.Config.DataDirectory)
-	opts := lib.PerformanceBadgerOptions(dbDir)
-	opts.ValueDir = dbDir
-	node.ChainDB, err = badger.Open(opts)
-	if err != nil {
-		panic(err)
+	// Setup chain database. An in-memory node has no data directory, so there's no version to check either.
+	var snapshotDB, txIndexDB *badger.DB
+	if node.Config.InMemory {
+		chainDB, err = openInMemoryDB(&node.inMemoryDBs.chain, "chain")
+		if err != nil {
+			return abortStart(fmt.Errorf("Node.Start: Problem opening chain db: %v", err))
+		}
+		if node.Config.HyperSync {
+			snapshotDB, err = openInMemoryDB(&node.inMemoryDBs.snapshot, "snapshot")
+			if err != nil {
+				return abortStart(fmt.Errorf("Node.Start: Problem opening snapshot db: %v", err))
+			}
+		}
+		if node.Config.TXIndex {
+			txIndexDB, err = openInMemoryDB(&node.inMemoryDBs.txIndex, "txindex")
+			if err != nil {
+				return abortStart(fmt.Errorf("Node.Start: Problem opening txindex db: %v", err))
+			}
+		}
+	} else {
+		dbDir := lib.GetBadgerDbPath(node.Config.DataDirectory)
+		_, statErr := os.Stat(dbDir)
+		isNewDataDirectory := os.IsNotExist(statErr)
+		opts := lib.PerformanceBadgerOptions(dbDir)
+		opts.ValueDir = dbDir
+		chainDB, err = badger.Open(opts)
+		if err != nil {
+			return abortStart(fmt.Errorf("Node.Start: Problem opening chain db (%v): %v", dbDir, err))
+		}
+		// Check that the data directory was written by a compatible version, and migrate it if needed, before
+		// reading it.
+		if err := node.migrateDataDirectory(chainDB, isNewDataDirectory); err != nil {
+			return abortStart(fmt.Errorf("Node.Start: Problem checking data directory version: %v", err))
+		}
 	}
+	node.ChainDB = chainDB
 
 	// Setup snapshot logger
 	if node.Config.LogDBSummarySnapshots {
 		lib.StartDBSummarySnapshots(node.ChainDB)
 	}
 
-	// Validate that we weren't passed incompatible Hypersync flags
-	lib.ValidateHyperSyncFlags(node.Config.HyperSync, node.Config.SyncType)
-
-	// Setup postgres using a remote URI. Postgres is not currently supported when we're in hypersync mode.
-	if node.Config.HyperSync && node.Config.PostgresURI != "" {
-		glog.Fatal("--postgres-uri is not supported when --hypersync=true. We're " +
-			"working on Hypersync support for Postgres though!")
-	}
+	// Setup postgres using a remote URI. Postgres is not currently supported when we're in hypersync mode, which the
+	// config validation checks.
 	var db *pg.DB
 	if node.Config.PostgresURI != "" {
 		options, err := pg.ParseURL(node.Config.PostgresURI)
 		if err != nil {
-			panic(err)
+			return abortStart(fmt.Errorf("Node.Start: Problem parsing --postgres-uri: %v", err))
 		}
 
 		db = pg.Connect(options)
@@ -179,7 +302,8 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 		// to running "go run migrate.go migrate". See migrate.go for a migrations CLI tool
 		err = migrations.Run(db, "migrate", []string{"", "migrate"})
 		if err != nil {
-			panic(err)
+			db.Close()
+			return abortStart(fmt.Errorf("Node.Start: Problem running postgres migrations: %v", err))
 		}
 	}
 
@@ -225,38 +349,68 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 		node.Config.TrustedBlockProducerStartHeight,
 		eventManager,
 		node.nodeMessageChan,
-		node.Config.ForceChecksum)
+		node.Config.ForceChecksum,
+		node.Config.TimeOffset,
+		snapshotDB)
 	if err != nil {
 		// shouldRestart can be true if, on the previous run, we did not finish flushing all ancestral
 		// records to the DB. In this case, the snapshot is corrupted and needs to be computed. See the
-		// comment at the top of snapshot.go for more information on how this works.
+		// comment at the top of snapshot.go for more information on how this works. If that fails too, the
+		// data directory has to be erased, which is left to the caller, see ErrNodeNeedsResync.
 		if shouldRestart {
-			glog.Infof(lib.CLog(lib.Red, fmt.Sprintf("Start: Got en error while starting server and shouldRestart "+
-				"is true. Node will be erased and resynced. Error: (%v)", err)))
-			node.nodeMessageChan <- lib.NodeErase
-			return
+			return abortStart(fmt.Errorf("%w: Problem initializing server: %v", ErrNodeNeedsResync, err))
+		}
+		return abortStart(fmt.Errorf("Node.Start: Problem initializing server: %v", err))
+	}
+
+	if node.Config.DBFaultInjector != nil {
+		snap := node.Server.GetBlockchain().Snapshot()
+		if snap == nil {
+			return abortStart(fmt.Errorf("Node.Start: DBFaultInjector requires HyperSync"))
 		}
-		panic(err)
+		snap.SetFaultInjector(node.Config.DBFaultInjector)
 	}
 
 	if !shouldRestart {
+		node.Server.SetMetricsRecorder(node.metrics)
+		node.Server.GetBlockchain().SetPruneBlocksOlderThan(node.Config.PruneBlocksOlderThan)
+		node.startStatusTracker()
+		node.startEventForwarder()
 		node.Server.Start()
+		if metricsListener != nil {
+			node.serveMetrics(metricsListener)
+		}
 
-		// Setup TXIndex - not compatible with postgres
+		// Setup TXIndex - not compatible with postgres. Drop the TXIndex of the previous run first, in case the node
+		// was restarted with TXIndex disabled.
+		node.TXIndex = nil
 		if node.Config.TXIndex && node.Postgres == nil {
-			node.TXIndex, err = lib.NewTXIndex(node.Server.GetBlockchain(), node.Params, node.Config.DataDirectory)
+			node.TXIndex, err = lib.NewTXIndex(node.Server.GetBlockchain(), node.Params, node.Config.DataDirectory,
+				txIndexDB)
 			if err != nil {
-				glog.Fatal(err)
+				node.stopMetrics()
+				node.stopStatusTracker()
+				node.stopEventForwarder()
+				node.Server.Stop()
+				listeners = nil
+				metricsListener = nil
+				node.TXIndex = nil
+				return abortStart(fmt.Errorf("Node.Start: Problem initializing TXIndex: %v", err))
 			}
 			node.Server.TxIndex = node.TXIndex
+			node.setStatusTXIndex(node.TXIndex)
 			if !shouldRestart {
 				node.TXIndex.Start()
 			}
 		}
 	}
-	node.IsRunning = true
+	node.setState(NodeStateRunning)
 
 	if shouldRestart {
+		// The node is restarted right away, so the metrics are only served once it's back up.
+		if metricsListener != nil {
+			metricsListener.Close()
+		}
 		if node.nodeMessageChan != nil {
 			node.nodeMessageChan <- lib.NodeRestart
 		}
@@ -275,74 +429,224 @@ func (node *Node) Start(exitChannels ...*chan struct{}) {
 			}
 		case <-syscallChannel:
 		}
+		signal.Stop(syscallChannel)
 
-		node.Stop()
-		for _, channel := range exitChannels {
-			if *channel != nil {
-				close(*channel)
-				*channel = nil
-			}
+		if err := node.Stop(); err != nil {
+			node.log.Errorf("%v", err)
 		}
-		glog.Info(lib.CLog(lib.Yellow, "Core node shutdown complete"))
+		closeExitChannels(exitChannels)
+		node.log.Infof(lib.CLog(lib.Yellow, "Core node shutdown complete"))
 	}()
+	return nil
 }
 
-func (node *Node) Stop() {
-	node.runningMutex.Lock()
-	defer node.runningMutex.Unlock()
+// Listeners returns the protocol listeners of the running node.
+func (node *Node) Listeners() []net.Listener {
+	return node.listeners
+}
 
-	if !node.IsRunning {
-		return
+// ListenAddrs returns the concrete addresses on which the running node accepts peer connections, i.e. the addresses of
+// Config.ListenAddrs with the ports picked by the OS filled in, or the protocol port on every interface if no
+// ListenAddrs are configured. Any of them can be passed to another node's Config.ConnectIPs.
+func (node *Node) ListenAddrs() []net.Addr {
+	addrs := make([]net.Addr, 0, len(node.listeners))
+	for _, listener := range node.listeners {
+		addrs = append(addrs, listener.Addr())
+	}
+	return addrs
+}
+
+// ListeningPort returns the port on which the node accepts peer connections. This is the port picked by the OS when
+// the node was first started with a zero Config.ProtocolPort, which the node keeps when it's started again. It's only
+// known once the node is started, before which the configured port is returned. With several Config.ListenAddrs, it's
+// the port of the first one, see ListenAddrs.
+func (node *Node) ListeningPort() uint16 {
+	if len(node.listeners) == 0 {
+		return node.Config.ProtocolPort
+	}
+	return uint16(node.listeners[0].Addr().(*net.TCPAddr).Port)
+}
+
+// nodeShutdownTimeout bounds how long Stop waits for each part of the node to release its resources, i.e. for the
+// listeners and peers to exit, and for the databases to close.
+const nodeShutdownTimeout = time.Minute
+
+// nodeStopTimeout bounds how long Stop waits for the whole node to shut down, see StopWithContext.
+const nodeStopTimeout = 5 * time.Minute
+
+// Stop gracefully shuts down the node. It returns once the node released all of its resources, i.e. the listening
+// ports are closed, all peer goroutines have exited, and the databases are closed, so that a new node can be started
+// on the same config right away. The dbs of an in-memory node are discarded, so it starts from scratch when it's
+// started again, unlike when it's restarted with Restart. It returns an error if the teardown didn't finish within
+// nodeShutdownTimeout, or within nodeStopTimeout overall, in which case some resources might still be held.
+func (node *Node) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), nodeStopTimeout)
+	defer cancel()
+	return node.StopWithContext(ctx)
+}
+
+// StopWithContext is Stop, but bounded by the context. The subsystems are shut down in order: the server, which stops
+// accepting peers, disconnects the connected peers, and persists the mempool, then the snapshot, which flushes its
+// pending operations, then the TXIndex, and finally the databases. If the context is done before the node shut down,
+// it returns an error that wraps ctx.Err() and names the subsystem the shutdown is stuck on. The shutdown carries on
+// in the background in that case, and the node can't be started again until it's done. Stopping a node that isn't
+// running has no effect, so Stop can be called any number of times.
+func (node *Node) StopWithContext(ctx context.Context) error {
+	node.runningMutex.Lock()
+	if node.State() != NodeStateRunning {
+		node.runningMutex.Unlock()
+		return nil
 	}
-	node.IsRunning = false
-	glog.Infof(lib.CLog(lib.Yellow, "Node is shutting down. This might take a minute. Please don't "+
+	node.setState(NodeStateStopping)
+	node.log.Infof(lib.CLog(lib.Yellow, "Node is shutting down. This might take a minute. Please don't "+
 		"close the node now or else you might corrupt the state."))
 
+	// The shutdown releases the running mutex once it's done, even if the context is done first, so that Start waits
+	// for the resources to be released.
+	var subsystemMutex sync.Mutex
+	subsystem := ""
+	setSubsystem := func(name string) {
+		subsystemMutex.Lock()
+		defer subsystemMutex.Unlock()
+		subsystem = name
+	}
+	stopped := make(chan error, 1)
+	go func() {
+		defer node.runningMutex.Unlock()
+		stopped <- node.shutdownSubsystems(setSubsystem)
+	}()
+
+	select {
+	case err := <-stopped:
+		return err
+	case <-ctx.Done():
+		subsystemMutex.Lock()
+		defer subsystemMutex.Unlock()
+		err := fmt.Errorf("Node.StopWithContext: Shutdown is stuck stopping the %v: %w", subsystem, ctx.Err())
+		node.log.Errorf(lib.CLog(lib.Red, err.Error()))
+		return err
+	}
+}
+
+// shutdownSubsystems shuts down the subsystems of the node in order, see StopWithContext. The name of every subsystem
+// is passed to setSubsystem before the subsystem is stopped.
+func (node *Node) shutdownSubsystems(setSubsystem func(name string)) error {
 	// Server
-	glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: Stopping server..."))
+	setSubsystem("server")
+	node.stopMetrics()
+	node.stopStatusTracker()
+	node.stopEventForwarder()
+	node.log.Infof(lib.CLog(lib.Yellow, "Node.Stop: Stopping server..."))
 	node.Server.Stop()
-	glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: Server successfully stopped."))
+	var stopErr error
+	if err := node.Server.GetConnectionManager().WaitForShutdown(nodeShutdownTimeout); err != nil {
+		node.log.Errorf(lib.CLog(lib.Red, fmt.Sprintf("Node.Stop: Problem stopping server: %v", err)))
+		stopErr = err
+	}
+	node.log.Infof(lib.CLog(lib.Yellow, "Node.Stop: Server successfully stopped."))
 
 	// Snapshot
 	snap := node.Server.GetBlockchain().Snapshot()
 	if snap != nil {
-		glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: Stopping snapshot..."))
+		setSubsystem("snapshot")
+		node.log.Infof(lib.CLog(lib.Yellow, "Node.Stop: Stopping snapshot..."))
 		snap.Stop()
 		node.closeDb(snap.SnapshotDb, "snapshot")
-		glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: Snapshot successfully stopped."))
+		node.log.Infof(lib.CLog(lib.Yellow, "Node.Stop: Snapshot successfully stopped."))
 	}
 
 	// TXIndex
 	if node.TXIndex != nil {
-		glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: Stopping TXIndex..."))
+		setSubsystem("TXIndex")
+		node.log.Infof(lib.CLog(lib.Yellow, "Node.Stop: Stopping TXIndex..."))
 		node.TXIndex.Stop()
 		node.closeDb(node.TXIndex.TXIndexChain.DB(), "txindex")
-		glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: TXIndex successfully stopped."))
+		node.log.Infof(lib.CLog(lib.Yellow, "Node.Stop: TXIndex successfully stopped."))
 	}
 
 	// Databases
-	glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: Closing all databases..."))
+	setSubsystem("databases")
+	node.log.Infof(lib.CLog(lib.Yellow, "Node.Stop: Closing all databases..."))
+	node.closeDb(node.ChainDB, "chain")
+	if !node.keepInMemoryDBs() {
+		node.discardInMemoryDBs()
+	}
+	if !lib.WaitWithTimeout(&node.stopWaitGroup, nodeShutdownTimeout) {
+		stopErr = fmt.Errorf("Node.Stop: Databases weren't closed within (%v)", nodeShutdownTimeout)
+		node.log.Errorf(lib.CLog(lib.Red, stopErr.Error()))
+	} else {
+		node.log.Infof(lib.CLog(lib.Yellow, "Node.Stop: Databases successfully closed."))
+	}
+
+	if node.internalExitChan != nil {
+		close(node.internalExitChan)
+		node.internalExitChan = nil
+	}
+	node.setState(NodeStateStopped)
+	return stopErr
+}
+
+// Crash simulates an ungraceful shutdown, e.g. the node's process getting killed, without killing the process. It stops
+// the server so that the node drops its peers and stops processing messages, but unlike Stop, it kills the snapshot
+// without processing its pending operations, such as ancestral record flushes and checksum updates. The databases are
+// then closed underneath the partial state. The node should detect and repair that state when it's started again from
+// the same data directory. Mainly used in testing.
+func (node *Node) Crash() {
+	node.runningMutex.Lock()
+	defer node.runningMutex.Unlock()
+
+	if node.State() != NodeStateRunning {
+		return
+	}
+	node.setState(NodeStateStopping)
+	node.log.Infof(lib.CLog(lib.Red, "Node.Crash: Crashing the node without a graceful shutdown"))
+	node.stopMetrics()
+	node.stopStatusTracker()
+	node.stopEventForwarder()
+
+	node.Server.Stop()
+	if err := node.Server.GetConnectionManager().WaitForShutdown(nodeShutdownTimeout); err != nil {
+		node.log.Errorf(lib.CLog(lib.Red, fmt.Sprintf("Node.Crash: Problem stopping server: %v", err)))
+	}
+
+	snap := node.Server.GetBlockchain().Snapshot()
+	if snap != nil {
+		snap.Kill()
+		node.closeDb(snap.SnapshotDb, "snapshot")
+	}
+
+	if node.TXIndex != nil {
+		node.TXIndex.Stop()
+		node.closeDb(node.TXIndex.TXIndexChain.DB(), "txindex")
+	}
+
 	node.closeDb(node.ChainDB, "chain")
+	node.discardInMemoryDBs()
 	node.stopWaitGroup.Wait()
-	glog.Infof(lib.CLog(lib.Yellow, "Node.Stop: Databases successfully closed."))
 
 	if node.internalExitChan != nil {
 		close(node.internalExitChan)
 		node.internalExitChan = nil
 	}
+	node.setState(NodeStateStopped)
+	node.log.Infof(lib.CLog(lib.Red, "Node.Crash: Node crashed"))
 }
 
 // Close a database and handle the stopWaitGroup accordingly. We close databases in a go routine to speed up the process.
+// The dbs of an in-memory node are owned by the node instead, and closed by discardInMemoryDBs.
 func (node *Node) closeDb(db *badger.DB, dbName string) {
+	if node.Config.InMemory {
+		return
+	}
 	node.stopWaitGroup.Add(1)
 
-	glog.Infof("Node.closeDb: Preparing to close %v db", dbName)
+	node.log.Infof("Node.closeDb: Preparing to close %v db", dbName)
 	go func() {
 		defer node.stopWaitGroup.Done()
 		if err := db.Close(); err != nil {
 			glog.Fatalf(lib.CLog(lib.Red, fmt.Sprintf("Node.Stop: Problem closing %v db: err: (%v)", dbName, err)))
 		} else {
-			glog.Infof(lib.CLog(lib.Yellow, fmt.Sprintf("Node.closeDb: Closed %v Db", dbName)))
+			node.log.Infof(lib.CLog(lib.Yellow, fmt.Sprintf("Node.closeDb: Closed %v Db", dbName)))
 		}
 	}()
 }
@@ -350,102 +654,164 @@ func (node *Node) closeDb(db *badger.DB, dbName string) {
 // listenToNodeMessages listens to the communication from the engine through the nodeMessageChan. There are currently
 // two main operations that the engine can request. These are a regular node restart, and a restart with a database
 // erase. The latter may seem a little harsh, but it is only triggered when the node is really broken and there's
-// no way we can recover.
+// no way we can recover. Both go through restartForEngine, so an in-memory node keeps its dbs across a regular
+// restart, like it does across Restart, and only loses them when it's erased.
 func (node *Node) listenToNodeMessages(exitChannels ...*chan struct{}) {
 	select {
 	case <-node.internalExitChan:
 		break
 	case operation := <-node.nodeMessageChan:
-		if !node.IsRunning {
+		if !node.IsRunning() {
 			panic("Node.listenToNodeMessages: Node is currently not running, nodeMessageChan should've not been called!")
 		}
-		glog.Infof("Node.listenToNodeMessages: Stopping node")
-		node.Stop()
-		glog.Infof("Node.listenToNodeMessages: Finished stopping node")
-		switch operation {
-		case lib.NodeErase:
-			if err := os.RemoveAll(node.Config.DataDirectory); err != nil {
-				glog.Fatal(lib.CLog(lib.Red, fmt.Sprintf("IMPORTANT: Problem removing the directory (%v), you "+
-					"should run `rm -rf %v` to delete it manually. Error: (%v)", node.Config.DataDirectory,
-					node.Config.DataDirectory, err)))
-				return
-			}
+		node.log.Infof("Node.listenToNodeMessages: Restarting node")
+		err := node.restartForEngine(operation == lib.NodeErase, exitChannels...)
+		if errors.Is(err, ErrNodeNotRunning) {
+			// The node was stopped before it could be restarted, so there's nothing left to do.
+			node.log.Infof("Node.listenToNodeMessages: Node was stopped, not restarting it")
+			return
 		}
+		if err != nil {
+			node.failRestart(fmt.Errorf("Node.listenToNodeMessages: Problem restarting node: %v", err),
+				exitChannels)
+			return
+		}
+		node.setRestartError(nil)
+	}
+}
 
-		glog.Infof("Node.listenToNodeMessages: Restarting node")
-		// Wait a few seconds so that all peer messages we've sent while closing the node get propagated in the network.
-		go node.Start(exitChannels...)
-		break
+// failRestart records the error of a restart requested by the engine that failed, see RestartError. The node is left
+// stopped, so the exitChannels are closed, as the node shut down for good.
+func (node *Node) failRestart(err error, exitChannels []*chan struct{}) {
+	node.log.Errorf(lib.CLog(lib.Red, err.Error()))
+	node.setRestartError(err)
+	closeExitChannels(exitChannels)
+}
+
+// closeExitChannels signals the exitChannels passed to Start that the node shut down for good.
+func closeExitChannels(exitChannels []*chan struct{}) {
+	for _, channel := range exitChannels {
+		if *channel != nil {
+			close(*channel)
+			*channel = nil
+		}
+	}
+}
+
+// EraseDataDirectory deletes the node's data directory, so that the node resyncs from scratch when it's started again,
+// e.g. after Start failed with ErrNodeNeedsResync. An in-memory node has no data directory, so its dbs are discarded
+// instead, in case they were kept across a restart. The node must not be running.
+func (node *Node) EraseDataDirectory() error {
+	if state := node.State(); state != NodeStateCreated && state != NodeStateStopped {
+		return fmt.Errorf("Node.EraseDataDirectory: Node must be stopped, but it's (%v)", state)
 	}
+	if node.Config.InMemory {
+		node.discardInMemoryDBs()
+		return nil
+	}
+	node.log.Infof(lib.CLog(lib.Red, fmt.Sprintf("Node.EraseDataDirectory: Erasing data directory (%v)",
+		node.Config.DataDirectory)))
+	if err := os.RemoveAll(node.Config.DataDirectory); err != nil {
+		return fmt.Errorf("Node.EraseDataDirectory: Problem removing the directory (%v): %v",
+			node.Config.DataDirectory, err)
+	}
+	return nil
 }
 
-func validateParams(params *lib.DeSoParams) {
+// validateParams returns an error if the params are inconsistent, e.g. if the genesis block doesn't match its hash.
+func validateParams(params *lib.DeSoParams) error {
 	if params.BitcoinBurnAddress == "" {
-		glog.Fatalf("The DeSoParams being used are missing the BitcoinBurnAddress field.")
+		return fmt.Errorf("The DeSoParams being used are missing the BitcoinBurnAddress field.")
 	}
 
 	// Check that TimeBetweenDifficultyRetargets is evenly divisible
 	// by TimeBetweenBlocks.
 	if params.TimeBetweenBlocks == 0 {
-		glog.Fatalf("The DeSoParams being used have TimeBetweenBlocks=0")
+		return fmt.Errorf("The DeSoParams being used have TimeBetweenBlocks=0")
 	}
 	numBlocks := params.TimeBetweenDifficultyRetargets / params.TimeBetweenBlocks
 	truncatedTime := params.TimeBetweenBlocks * numBlocks
 	if truncatedTime != params.TimeBetweenDifficultyRetargets {
-		glog.Fatalf("TimeBetweenDifficultyRetargets (%v) should be evenly divisible by "+
+		return fmt.Errorf("TimeBetweenDifficultyRetargets (%v) should be evenly divisible by "+
 			"TimeBetweenBlocks (%v)", params.TimeBetweenDifficultyRetargets,
 			params.TimeBetweenBlocks)
 	}
 
 	if params.GenesisBlock == nil || params.GenesisBlockHashHex == "" {
-		glog.Fatalf("The DeSoParams are missing genesis block info.")
+		return fmt.Errorf("The DeSoParams are missing genesis block info.")
 	}
 
 	// Compute the merkle root for the genesis block and make sure it matches.
 	merkle, _, err := lib.ComputeMerkleRoot(params.GenesisBlock.Txns)
 	if err != nil {
-		glog.Fatalf("Could not compute a merkle root for the genesis block: %v", err)
+		return fmt.Errorf("Could not compute a merkle root for the genesis block: %v", err)
 	}
 	if *merkle != *params.GenesisBlock.Header.TransactionMerkleRoot {
-		glog.Fatalf("Genesis block merkle root (%s) not equal to computed merkle root (%s)",
+		return fmt.Errorf("Genesis block merkle root (%s) not equal to computed merkle root (%s)",
 			hex.EncodeToString(params.GenesisBlock.Header.TransactionMerkleRoot[:]),
 			hex.EncodeToString(merkle[:]))
 	}
 
 	genesisHash, err := params.GenesisBlock.Header.Hash()
 	if err != nil {
-		glog.Fatalf("Problem hashing header for the GenesisBlock in "+
+		return fmt.Errorf("Problem hashing header for the GenesisBlock in "+
 			"the DeSoParams (%+v): %v", params.GenesisBlock.Header, err)
 	}
 	genesisHashHex := hex.EncodeToString(genesisHash[:])
 	if genesisHashHex != params.GenesisBlockHashHex {
-		glog.Fatalf("GenesisBlockHash in DeSoParams (%s) does not match the block "+
+		return fmt.Errorf("GenesisBlockHash in DeSoParams (%s) does not match the block "+
 			"hash computed (%s) %d %d", params.GenesisBlockHashHex, genesisHashHex, len(params.GenesisBlockHashHex), len(genesisHashHex))
 	}
 
 	if params.MinDifficultyTargetHex == "" {
-		glog.Fatalf("The DeSoParams MinDifficultyTargetHex (%s) should be non-empty",
+		return fmt.Errorf("The DeSoParams MinDifficultyTargetHex (%s) should be non-empty",
 			params.MinDifficultyTargetHex)
 	}
 
 	// Check to ensure the genesis block hash meets the initial difficulty target.
 	hexBytes, err := hex.DecodeString(params.MinDifficultyTargetHex)
 	if err != nil || len(hexBytes) != 32 {
-		glog.Fatalf("The DeSoParams MinDifficultyTargetHex (%s) with length (%d) is "+
+		return fmt.Errorf("The DeSoParams MinDifficultyTargetHex (%s) with length (%d) is "+
 			"invalid: %v", params.MinDifficultyTargetHex, len(params.MinDifficultyTargetHex), err)
 	}
 
 	if params.MaxDifficultyRetargetFactor == 0 {
-		glog.Fatalf("The DeSoParams MaxDifficultyRetargetFactor is unset")
+		return fmt.Errorf("The DeSoParams MaxDifficultyRetargetFactor is unset")
+	}
+	return nil
+}
+
+// listenOnConfiguredAddrs listens on every address of Config.ListenAddrs, in order. An address with a zero port is
+// bound to a free port picked by the OS the first time, and to the same port when the node is started again. If any
+// address can't be listened on, the listeners bound so far are closed.
+func (node *Node) listenOnConfiguredAddrs() ([]net.Listener, error) {
+	var listeners []net.Listener
+	boundAddrs := make(map[string]string, len(node.Config.ListenAddrs))
+	for _, addr := range node.Config.ListenAddrs {
+		listenAddr := addr
+		if boundAddr, exists := node.boundAddrs[addr]; exists {
+			listenAddr = boundAddr
+		}
+		listener, err := net.Listen("tcp", listenAddr)
+		if err != nil {
+			for _, listener := range listeners {
+				listener.Close()
+			}
+			return nil, fmt.Errorf("Node.listenOnConfiguredAddrs: Problem listening on (%v): %w", listenAddr, err)
+		}
+		listeners = append(listeners, listener)
+		boundAddrs[addr] = listener.Addr().String()
 	}
+	node.boundAddrs = boundAddrs
+	return listeners, nil
 }
 
-func GetAddrsToListenOn(protocolPort uint16) ([]net.TCPAddr, []net.Listener) {
+func GetAddrsToListenOn(protocolPort uint16) ([]net.TCPAddr, []net.Listener, error) {
 	listeningAddrs := []net.TCPAddr{}
 	listeners := []net.Listener{}
 	ifaceAddrs, err := net.InterfaceAddrs()
 	if err != nil {
-		return nil, nil
+		return nil, nil, nil
 	}
 
 	for _, iAddr := range ifaceAddrs {
@@ -458,6 +824,8 @@ func GetAddrsToListenOn(protocolPort uint16) ([]net.TCPAddr, []net.Listener) {
 			continue
 		}
 
+		// A zero port lets the OS pick a free port for the first listener, which the other listeners then reuse, so
+		// that the node listens on the same port on every interface.
 		netAddr := net.TCPAddr{
 			IP:   ifaceIP,
 			Port: int(protocolPort),
@@ -465,40 +833,52 @@ func GetAddrsToListenOn(protocolPort uint16) ([]net.TCPAddr, []net.Listener) {
 
 		listener, err := net.Listen(netAddr.Network(), netAddr.String())
 		if err != nil {
+			// Some interfaces can't be listened on, which is fine, but another process holding the port means the
+			// node won't be reachable, so we report it.
+			if errors.Is(err, syscall.EADDRINUSE) {
+				for _, listener := range listeners {
+					listener.Close()
+				}
+				return nil, nil, err
+			}
 			continue
 		}
 
+		if protocolPort == 0 {
+			protocolPort = uint16(listener.Addr().(*net.TCPAddr).Port)
+			netAddr.Port = int(protocolPort)
+		}
 		listeners = append(listeners, listener)
 		listeningAddrs = append(listeningAddrs, netAddr)
 	}
 
-	return listeningAddrs, listeners
+	return listeningAddrs, listeners, nil
 }
 
-func addIPsForHost(desoAddrMgr *addrmgr.AddrManager, host string, params *lib.DeSoParams) {
+func addIPsForHost(logger *nodeLogger, desoAddrMgr *addrmgr.AddrManager, host string, params *lib.DeSoParams) {
 	ipAddrs, err := net.LookupIP(host)
 	if err != nil {
-		glog.V(2).Infof("_addSeedAddrs: DNS discovery failed on seed host (continuing on): %s %v\n", host, err)
+		logger.Verbosef("node", 2, "_addSeedAddrs: DNS discovery failed on seed host (continuing on): %s %v\n", host, err)
 		return
 	}
 	if len(ipAddrs) == 0 {
-		glog.V(2).Infof("_addSeedAddrs: No IPs found for host: %s\n", host)
+		logger.Verbosef("node", 2, "_addSeedAddrs: No IPs found for host: %s\n", host)
 		return
 	}
 
 	// Don't take more than 5 IPs per host.
 	ipsPerHost := 5
 	if len(ipAddrs) > ipsPerHost {
-		glog.V(1).Infof("_addSeedAddrs: Truncating IPs found from %d to %d\n", len(ipAddrs), ipsPerHost)
+		logger.Verbosef("node", 1, "_addSeedAddrs: Truncating IPs found from %d to %d\n", len(ipAddrs), ipsPerHost)
 		ipAddrs = ipAddrs[:ipsPerHost]
 	}
 
-	glog.V(1).Infof("_addSeedAddrs: Adding seed IPs from seed %s: %v\n", host, ipAddrs)
+	logger.Verbosef("node", 1, "_addSeedAddrs: Adding seed IPs from seed %s: %v\n", host, ipAddrs)
 
 	// Convert addresses to NetAddress'es.
 	netAddrs, err := lib.SafeMakeSliceWithLength[*wire.NetAddress](uint64(len(ipAddrs)))
 	if err != nil {
-		glog.V(2).Infof("_addSeedAddrs: Problem creating netAddrs slice with length %d", len(ipAddrs))
+		logger.Verbosef("node", 2, "_addSeedAddrs: Problem creating netAddrs slice with length %d", len(ipAddrs))
 		return
 	}
 	for ii, ip := range ipAddrs {
@@ -512,7 +892,7 @@ func addIPsForHost(desoAddrMgr *addrmgr.AddrManager, host string, params *lib.De
 			ip,
 			params.DefaultSocketPort)
 	}
-	glog.V(1).Infof("_addSeedAddrs: Computed the following wire.NetAddress'es: %s", spew.Sdump(netAddrs))
+	logger.Verbosef("node", 1, "_addSeedAddrs: Computed the following wire.NetAddress'es: %s", spew.Sdump(netAddrs))
 
 	// Normally the second argument is the source who told us about the
 	// addresses we're adding. In this case since the source is a DNS seed
@@ -523,7 +903,7 @@ func addIPsForHost(desoAddrMgr *addrmgr.AddrManager, host string, params *lib.De
 // Must be run in a goroutine. This function continuously adds IPs from a DNS seed
 // prefix+suffix by iterating up through all of the possible numeric values, which are typically
 // [0, 10]
-func addSeedAddrsFromPrefixes(desoAddrMgr *addrmgr.AddrManager, params *lib.DeSoParams) {
+func addSeedAddrsFromPrefixes(logger *nodeLogger, desoAddrMgr *addrmgr.AddrManager, params *lib.DeSoParams) {
 	MaxIterations := 20
 
 	go func() {
@@ -533,8 +913,8 @@ func addSeedAddrsFromPrefixes(desoAddrMgr *addrmgr.AddrManager, params *lib.DeSo
 				wg.Add(1)
 				go func(dnsGenerator []string) {
 					dnsString := fmt.Sprintf("%s%d%s", dnsGenerator[0], dnsNumber, dnsGenerator[1])
-					glog.V(2).Infof("_addSeedAddrsFromPrefixes: Querying DNS seed: %s", dnsString)
-					addIPsForHost(desoAddrMgr, dnsString, params)
+					logger.Verbosef("node", 2, "_addSeedAddrsFromPrefixes: Querying DNS seed: %s", dnsString)
+					addIPsForHost(logger, desoAddrMgr, dnsString, params)
 					wg.Done()
 				}(dnsGeneratorOuter)
 			}
@@ -0,0 +1,298 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+// EventType is the type of a NodeEvent.
+type EventType uint8
+
+const (
+	// EventTypeBlockConnected is fired when a block is connected to the main chain, including during reorgs.
+	EventTypeBlockConnected EventType = iota
+	// EventTypeBlockDisconnected is fired when a block is disconnected from the main chain.
+	EventTypeBlockDisconnected
+	// EventTypeHeaderConnected is fired when a header extends the best header chain.
+	EventTypeHeaderConnected
+	// EventTypeTransactionAccepted is fired when a transaction is accepted into the mempool.
+	EventTypeTransactionAccepted
+	// EventTypePeerConnected is fired when a peer completes version negotiation.
+	EventTypePeerConnected
+	// EventTypePeerDisconnected is fired when a connected peer is removed.
+	EventTypePeerDisconnected
+	// EventTypeSyncStateChanged is fired when the chain state changes, e.g. from syncing headers to syncing blocks.
+	EventTypeSyncStateChanged
+	// EventTypeHyperSyncProgress is fired when hypersync starts downloading a prefix or receives a chunk for it.
+	EventTypeHyperSyncProgress
+	// EventTypeSnapshotEpochCompleted is fired when a snapshot epoch completes, after which the snapshot can be
+	// served to hypersyncing peers.
+	EventTypeSnapshotEpochCompleted
+	// EventTypeBlockRejected is fired when the node rejects a header or a block from a peer, e.g. because its
+	// timestamp is too far in the future, and disconnects the peer.
+	EventTypeBlockRejected
+)
+
+func (eventType EventType) String() string {
+	switch eventType {
+	case EventTypeBlockConnected:
+		return "BLOCK_CONNECTED"
+	case EventTypeBlockDisconnected:
+		return "BLOCK_DISCONNECTED"
+	case EventTypeHeaderConnected:
+		return "HEADER_CONNECTED"
+	case EventTypeTransactionAccepted:
+		return "TRANSACTION_ACCEPTED"
+	case EventTypePeerConnected:
+		return "PEER_CONNECTED"
+	case EventTypePeerDisconnected:
+		return "PEER_DISCONNECTED"
+	case EventTypeSyncStateChanged:
+		return "SYNC_STATE_CHANGED"
+	case EventTypeHyperSyncProgress:
+		return "HYPERSYNC_PROGRESS"
+	case EventTypeSnapshotEpochCompleted:
+		return "SNAPSHOT_EPOCH_COMPLETED"
+	case EventTypeBlockRejected:
+		return "BLOCK_REJECTED"
+	default:
+		return fmt.Sprintf("UNRECOGNIZED(%d)", eventType)
+	}
+}
+
+// NodeEvent is an event fired by a running node, see Node.Subscribe. Only the fields of the event's type are set.
+type NodeEvent struct {
+	Type EventType
+	Time time.Time
+	// Dropped is the number of events the subscription dropped so far, up to when this event was published, because
+	// the subscriber fell behind. A subscriber that sees it grow knows that it missed events right before this one.
+	Dropped uint64
+
+	// BlockHash and BlockHeight are set for the block and header events, including BlockRejected, and for
+	// SnapshotEpochCompleted, in which case they identify the epoch's snapshot block.
+	BlockHash   string
+	BlockHeight uint64
+	// TxnHash is set for TransactionAccepted.
+	TxnHash string
+	// PeerID and PeerAddress are set for the peer events, and for BlockRejected, in which case they identify the peer
+	// that sent the block.
+	PeerID      uint64
+	PeerAddress string
+	// RejectReason is the error with which the block was rejected, set for BlockRejected.
+	RejectReason string
+	// OldState and NewState are set for SyncStateChanged.
+	OldState lib.SyncState
+	NewState lib.SyncState
+	// HyperSyncPrefix is set for HyperSyncProgress, see HyperSyncPrefixStatus.
+	HyperSyncPrefix HyperSyncPrefixStatus
+	// SnapshotChecksum is the hex encoded checksum of the epoch's state, set for SnapshotEpochCompleted.
+	SnapshotChecksum string
+}
+
+// nodeEventBufferSize is the number of events buffered for every subscription, see Node.Subscribe.
+const nodeEventBufferSize = 1000
+
+// Subscribe returns a channel that receives the node's events of the provided types, or of every type if no type is
+// provided, from now on. The subscription lasts across restarts of the node, and it can be made before the node
+// starts so that no early event is missed. Events of the same type are received in the order in which they were
+// fired. Publishing never blocks the node: when the channel is full, the oldest event is dropped to make room for the
+// new one, which is reported in NodeEvent.Dropped. The returned function unsubscribes. The channel is never closed.
+func (node *Node) Subscribe(eventTypes ...EventType) (_events <-chan NodeEvent, _unsubscribe func()) {
+	return node.events.subscribe(nodeEventBufferSize, eventTypes)
+}
+
+// nodeEventBus fans the node's events out to its subscribers. The zero value is an empty bus that's ready to use.
+type nodeEventBus struct {
+	mtx         sync.Mutex
+	subscribers map[*nodeEventSubscriber]struct{}
+}
+
+type nodeEventSubscriber struct {
+	events chan NodeEvent
+	// eventTypes are the types the subscriber receives, or nil for every type.
+	eventTypes map[EventType]bool
+	dropped    uint64
+}
+
+func (bus *nodeEventBus) subscribe(bufferSize int, eventTypes []EventType) (<-chan NodeEvent, func()) {
+	subscriber := &nodeEventSubscriber{events: make(chan NodeEvent, bufferSize)}
+	if len(eventTypes) > 0 {
+		subscriber.eventTypes = make(map[EventType]bool)
+		for _, eventType := range eventTypes {
+			subscriber.eventTypes[eventType] = true
+		}
+	}
+
+	bus.mtx.Lock()
+	defer bus.mtx.Unlock()
+	if bus.subscribers == nil {
+		bus.subscribers = make(map[*nodeEventSubscriber]struct{})
+	}
+	bus.subscribers[subscriber] = struct{}{}
+
+	return subscriber.events, func() {
+		bus.mtx.Lock()
+		defer bus.mtx.Unlock()
+		delete(bus.subscribers, subscriber)
+	}
+}
+
+// publish sends the event to the subscribers of its type, dropping the oldest buffered event of subscribers that
+// fell behind.
+func (bus *nodeEventBus) publish(event NodeEvent) {
+	event.Time = time.Now()
+
+	bus.mtx.Lock()
+	defer bus.mtx.Unlock()
+	for subscriber := range bus.subscribers {
+		if subscriber.eventTypes != nil && !subscriber.eventTypes[event.Type] {
+			continue
+		}
+		for sent := false; !sent; {
+			event.Dropped = subscriber.dropped
+			select {
+			case subscriber.events <- event:
+				sent = true
+			default:
+				// The channel is full, so drop the oldest event. The subscriber could have emptied the channel in
+				// the meantime, which is why this receive can't block either.
+				select {
+				case <-subscriber.events:
+					subscriber.dropped++
+				default:
+				}
+			}
+		}
+	}
+}
+
+// startEventForwarder forwards the events of the node's subsystems to the node's subscribers, until the forwarder is
+// stopped with stopEventForwarder. It must be called before the server starts, so that no event is missed.
+func (node *Node) startEventForwarder() {
+	chain := node.Server.GetBlockchain()
+	headers, unsubscribeHeaders := chain.SubscribeHeaderConnected()
+	connectedBlocks, unsubscribeConnectedBlocks := chain.SubscribeBlockConnected()
+	disconnectedBlocks, unsubscribeDisconnectedBlocks := chain.SubscribeBlockDisconnected()
+	states, unsubscribeStates := chain.SubscribeChainState()
+	txns, unsubscribeTxns := node.Server.GetMempool().SubscribeTransactionAccepted()
+	peers, unsubscribePeers := node.Server.GetConnectionManager().SubscribePeerEvents()
+	progress, unsubscribeProgress := node.Server.SubscribeHyperSyncProgress()
+	rejectedBlocks, unsubscribeRejectedBlocks := node.Server.SubscribeBlockRejected()
+	// Nodes without hypersync have no snapshot, in which case the nil epochs channel never fires.
+	var epochs <-chan lib.SnapshotEpochEvent
+	unsubscribeEpochs := func() {}
+	if snap := chain.Snapshot(); snap != nil {
+		epochs, unsubscribeEpochs = snap.SubscribeEpochCompleted()
+	}
+
+	// Read the current state after subscribing, so that no change is missed in between.
+	chain.ChainLock.RLock()
+	lastState := chain.ChainState()
+	chain.ChainLock.RUnlock()
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			case header := <-headers:
+				event := NodeEvent{Type: EventTypeHeaderConnected, BlockHeight: header.Height}
+				if hash, err := header.Hash(); err == nil {
+					event.BlockHash = hash.String()
+				}
+				node.events.publish(event)
+			case blockEvent := <-connectedBlocks:
+				node.events.publish(newBlockNodeEvent(EventTypeBlockConnected, blockEvent.Block))
+			case blockEvent := <-disconnectedBlocks:
+				node.events.publish(newBlockNodeEvent(EventTypeBlockDisconnected, blockEvent.Block))
+			case state := <-states:
+				if state == lastState {
+					continue
+				}
+				node.events.publish(NodeEvent{Type: EventTypeSyncStateChanged, OldState: lastState, NewState: state})
+				lastState = state
+			case mempoolTx := <-txns:
+				node.events.publish(NodeEvent{Type: EventTypeTransactionAccepted, TxnHash: mempoolTx.Hash.String()})
+			case peerEvent := <-peers:
+				eventType := EventTypePeerConnected
+				if !peerEvent.Connected {
+					eventType = EventTypePeerDisconnected
+				}
+				node.events.publish(NodeEvent{
+					Type:        eventType,
+					PeerID:      peerEvent.Peer.ID,
+					PeerAddress: peerEvent.Peer.Address(),
+				})
+			case prefixProgress := <-progress:
+				node.events.publish(NodeEvent{
+					Type: EventTypeHyperSyncProgress,
+					HyperSyncPrefix: HyperSyncPrefixStatus{
+						Prefix:          hex.EncodeToString(prefixProgress.Prefix),
+						LastReceivedKey: hex.EncodeToString(prefixProgress.LastReceivedKey),
+						ReceivedBytes:   prefixProgress.ReceivedBytes,
+						Completed:       prefixProgress.Completed,
+					},
+				})
+			case epoch := <-epochs:
+				event := NodeEvent{
+					Type:             EventTypeSnapshotEpochCompleted,
+					BlockHeight:      epoch.SnapshotBlockHeight,
+					SnapshotChecksum: hex.EncodeToString(epoch.ChecksumBytes),
+				}
+				if epoch.BlockHash != nil {
+					event.BlockHash = epoch.BlockHash.String()
+				}
+				node.events.publish(event)
+			case rejectedBlock := <-rejectedBlocks:
+				event := NodeEvent{
+					Type:         EventTypeBlockRejected,
+					BlockHeight:  rejectedBlock.Height,
+					RejectReason: rejectedBlock.Err.Error(),
+				}
+				if rejectedBlock.BlockHash != nil {
+					event.BlockHash = rejectedBlock.BlockHash.String()
+				}
+				if rejectedBlock.Peer != nil {
+					event.PeerID = rejectedBlock.Peer.ID
+					event.PeerAddress = rejectedBlock.Peer.Address()
+				}
+				node.events.publish(event)
+			}
+		}
+	}()
+
+	node.stopEvents = func() {
+		close(done)
+		<-stopped
+		for _, unsubscribe := range []func(){unsubscribeHeaders, unsubscribeConnectedBlocks,
+			unsubscribeDisconnectedBlocks, unsubscribeStates, unsubscribeTxns, unsubscribePeers, unsubscribeProgress,
+			unsubscribeEpochs, unsubscribeRejectedBlocks} {
+			unsubscribe()
+		}
+	}
+}
+
+// stopEventForwarder stops forwarding the events of the node's subsystems. The subscriptions stay open, so that they
+// receive the events of the node once it's restarted.
+func (node *Node) stopEventForwarder() {
+	if node.stopEvents == nil {
+		return
+	}
+	node.stopEvents()
+	node.stopEvents = nil
+}
+
+func newBlockNodeEvent(eventType EventType, block *lib.MsgDeSoBlock) NodeEvent {
+	event := NodeEvent{Type: eventType, BlockHeight: block.Header.Height}
+	if hash, err := block.Hash(); err == nil {
+		event.BlockHash = hash.String()
+	}
+	return event
+}
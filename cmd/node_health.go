@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// HealthProbe is the probe a health check belongs to. Liveness checks fail when the node is stuck or broken and needs
+// to be restarted, and readiness checks fail when the node can't serve yet.
+type HealthProbe string
+
+const (
+	HealthProbeLiveness  HealthProbe = "liveness"
+	HealthProbeReadiness HealthProbe = "readiness"
+)
+
+// nodeLivenessTimeout is how long the server's main loop can go without ticking before the node isn't live anymore.
+const nodeLivenessTimeout = time.Minute
+
+// HealthReport is the result of the node's health checks, returned by Node.Healthz. It marshals to JSON.
+type HealthReport struct {
+	// Live is true if all the liveness checks passed, and Ready is true if all the checks passed, i.e. a node that
+	// isn't live isn't ready either.
+	Live   bool
+	Ready  bool
+	Checks []HealthCheck
+}
+
+// HealthCheck is the result of a single health check.
+type HealthCheck struct {
+	Name   string
+	Probe  HealthProbe
+	Passed bool
+	// Reason explains why the check failed, and is empty if it passed.
+	Reason string `json:",omitempty"`
+}
+
+// Failing returns the checks that failed.
+func (report HealthReport) Failing() []HealthCheck {
+	var failing []HealthCheck
+	for _, check := range report.Checks {
+		if !check.Passed {
+			failing = append(failing, check)
+		}
+	}
+	return failing
+}
+
+// Healthz runs the node's health checks. The node is live if it's running, its server's main loop ticked within
+// nodeLivenessTimeout, and no subsystem was flagged fatal. It's ready if it's live, its databases are open, it's
+// listening for peers, and it has at least one peer, unless it's in Config.Standalone mode or its networking is
+// disabled. Like Status, it's safe to call concurrently with the node running, starting, or stopping.
+func (node *Node) Healthz() HealthReport {
+	node.statusMutex.RLock()
+	tracker := node.statusTracker
+	node.statusMutex.RUnlock()
+	if tracker == nil {
+		return HealthReport{Checks: []HealthCheck{
+			{Name: "running", Probe: HealthProbeLiveness, Reason: "The node isn't running"},
+		}}
+	}
+	server := tracker.server
+
+	var checks []HealthCheck
+	check := func(name string, probe HealthProbe, reason string) {
+		checks = append(checks, HealthCheck{Name: name, Probe: probe, Passed: reason == "", Reason: reason})
+	}
+
+	// Liveness
+	check("running", HealthProbeLiveness, "")
+	reason := ""
+	if sinceTick := time.Since(server.LastMessageHandlerTick()); sinceTick > nodeLivenessTimeout {
+		reason = fmt.Sprintf("The server's main loop didn't tick for (%v)", sinceTick.Round(time.Second))
+	}
+	check("server-loop", HealthProbeLiveness, reason)
+	fatalErrors := server.FatalErrors()
+	subsystems := make([]string, 0, len(fatalErrors))
+	for subsystem := range fatalErrors {
+		subsystems = append(subsystems, subsystem)
+	}
+	sort.Strings(subsystems)
+	reason = ""
+	for _, subsystem := range subsystems {
+		if reason != "" {
+			reason += "; "
+		}
+		reason += fmt.Sprintf("The %v is broken: %v", subsystem, fatalErrors[subsystem])
+	}
+	check("subsystems", HealthProbeLiveness, reason)
+
+	// Readiness
+	reason = ""
+	if server.GetBlockchain().DB().IsClosed() {
+		reason = "The chain db is closed"
+	} else if snap := server.GetBlockchain().Snapshot(); snap != nil && snap.SnapshotDb.IsClosed() {
+		reason = "The snapshot db is closed"
+	}
+	check("databases", HealthProbeReadiness, reason)
+	reason = ""
+	if len(tracker.listeners) == 0 && !node.Config.DisableNetworking {
+		reason = fmt.Sprintf("The node isn't listening on the protocol port (%v)", node.Config.ProtocolPort)
+	}
+	check("listeners", HealthProbeReadiness, reason)
+	reason = ""
+	if server.GetConnectionManager().NumConnectedPeers() == 0 && !node.Config.Standalone &&
+		!node.Config.DisableNetworking {
+		reason = "The node has no peers, and it isn't in standalone mode"
+	}
+	check("peers", HealthProbeReadiness, reason)
+
+	report := HealthReport{Live: true, Ready: true, Checks: checks}
+	for _, check := range checks {
+		if !check.Passed {
+			report.Ready = false
+			if check.Probe == HealthProbeLiveness {
+				report.Live = false
+			}
+		}
+	}
+	return report
+}
+
+// HealthHandler returns an HTTP handler that serves the liveness probe at /healthz and the readiness probe at
+// /readyz, e.g. for Kubernetes. The probes respond with the JSON HealthReport, and with status 200 if the node is
+// live, or ready, respectively, and 503 otherwise. The node serves the handler on Config.MetricsPort.
+func (node *Node) HealthHandler() http.Handler {
+	serveProbe := func(isHealthy func(report HealthReport) bool) http.HandlerFunc {
+		return func(writer http.ResponseWriter, request *http.Request) {
+			report := node.Healthz()
+			writer.Header().Set("Content-Type", "application/json")
+			if isHealthy(report) {
+				writer.WriteHeader(http.StatusOK)
+			} else {
+				writer.WriteHeader(http.StatusServiceUnavailable)
+			}
+			if err := json.NewEncoder(writer).Encode(report); err != nil {
+				node.log.Errorf("Node.HealthHandler: Problem writing health report: %v", err)
+			}
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/healthz", serveProbe(func(report HealthReport) bool { return report.Live }))
+	mux.Handle("/readyz", serveProbe(func(report HealthReport) bool { return report.Ready }))
+	return mux
+}
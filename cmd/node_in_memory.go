@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/dgraph-io/badger/v3"
+)
+
+// inMemoryDBs are the dbs of a node with Config.InMemory. The node owns them, rather than its subsystems, so that they
+// outlive the subsystems when the node is restarted, since closing an in-memory db discards its content.
+type inMemoryDBs struct {
+	chain    *badger.DB
+	snapshot *badger.DB
+	txIndex  *badger.DB
+}
+
+// openInMemoryDB returns the in-memory db, and opens it first if it isn't open yet, i.e. when the node is first started
+// with it, or after the node was stopped.
+func openInMemoryDB(db **badger.DB, dbName string) (*badger.DB, error) {
+	if *db != nil {
+		return *db, nil
+	}
+	opts := lib.PerformanceBadgerOptions("").WithInMemory(true)
+	openedDB, err := badger.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("openInMemoryDB: Problem opening in-memory %v db: %v", dbName, err)
+	}
+	*db = openedDB
+	return openedDB, nil
+}
+
+// keepInMemoryDBs returns true if the node's in-memory dbs are kept when the node stops, i.e. if the node is in memory
+// and it's stopping to be restarted, see RestartWithConfig. The process owns the dbs, so the node comes back with its
+// state, like an on-disk node would.
+func (node *Node) keepInMemoryDBs() bool {
+	return node.Config.InMemory && atomic.LoadInt32(&node.restarting) == 1
+}
+
+// discardInMemoryDBs closes the node's in-memory dbs, which discards their content, so that the node starts from
+// scratch the next time it's started. It has no effect on a node that isn't in memory.
+func (node *Node) discardInMemoryDBs() {
+	dbs := node.inMemoryDBs
+	node.inMemoryDBs = inMemoryDBs{}
+	for _, db := range []*badger.DB{dbs.chain, dbs.snapshot, dbs.txIndex} {
+		if db == nil || db.IsClosed() {
+			continue
+		}
+		if err := db.Close(); err != nil {
+			node.log.Errorf(lib.CLog(lib.Red, fmt.Sprintf("Node.discardInMemoryDBs: Problem closing db: %v", err)))
+		}
+	}
+}
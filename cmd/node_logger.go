@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// nodeLogger is the logging seam of a Node. Every line is logged through glog as before, and, if the node's
+// Config.LogOutput is set, also written to it with the node's Config.LogName as a prefix. This lets tests that run
+// several nodes in one process attribute the node's log lines to the node, which isn't possible with glog's
+// process-wide output alone. Verbose lines are filtered by the node's own levels, see Node.SetLogLevel, rather than by
+// glog's process-wide verbosity.
+type nodeLogger struct {
+	mtx    sync.Mutex
+	name   string
+	output io.Writer
+	// defaultLevel is the verbosity of the modules that no level was set for, and levels are the verbosities set per
+	// module pattern.
+	defaultLevel int
+	levels       map[string]int
+}
+
+func newNodeLogger(config *Config) *nodeLogger {
+	logger := &nodeLogger{}
+	logger.configure(config)
+	return logger
+}
+
+// configure sets the logger's name, output, and levels from the config, e.g. when the node is restarted with a new
+// config.
+func (logger *nodeLogger) configure(config *Config) {
+	logger.mtx.Lock()
+	defer logger.mtx.Unlock()
+	logger.name = config.LogName
+	logger.output = config.LogOutput
+	logger.defaultLevel = int(config.GlogV)
+	logger.levels = make(map[string]int)
+	for module, level := range config.LogLevels {
+		logger.levels[module] = level
+	}
+}
+
+func (logger *nodeLogger) Infof(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	glog.InfoDepth(1, message)
+	logger.write("I", message)
+}
+
+func (logger *nodeLogger) Errorf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	glog.ErrorDepth(1, message)
+	logger.write("E", message)
+}
+
+// Verbosef logs the message like Infof, but only if the node's verbosity for the module is at least the level.
+func (logger *nodeLogger) Verbosef(module string, level int, format string, args ...interface{}) {
+	if !logger.V(module, level) {
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+	glog.InfoDepth(1, message)
+	logger.write("I", message)
+}
+
+// V returns true if the node's verbosity for the module is at least the level. The module's verbosity is the highest
+// level set for a pattern that matches it, or the default level if there's none.
+func (logger *nodeLogger) V(module string, level int) bool {
+	logger.mtx.Lock()
+	defer logger.mtx.Unlock()
+	moduleLevel, matched := 0, false
+	for pattern, patternLevel := range logger.levels {
+		if ok, _ := filepath.Match(pattern, module); ok && (!matched || patternLevel > moduleLevel) {
+			moduleLevel, matched = patternLevel, true
+		}
+	}
+	if !matched {
+		moduleLevel = logger.defaultLevel
+	}
+	return moduleLevel >= level
+}
+
+// setLevel sets the verbosity of the modules that match the pattern.
+func (logger *nodeLogger) setLevel(module string, level int) {
+	logger.mtx.Lock()
+	defer logger.mtx.Unlock()
+	logger.levels[module] = level
+}
+
+// write writes the message to the node's LogOutput in a glog-like format, e.g.
+// "I 15:04:05.000000 [node1] Node.Stop: Stopping server...".
+func (logger *nodeLogger) write(severity string, message string) {
+	if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	logger.mtx.Lock()
+	defer logger.mtx.Unlock()
+	if logger.output == nil {
+		return
+	}
+	fmt.Fprintf(logger.output, "%s %s [%s] %s", severity, time.Now().Format("15:04:05.000000"), logger.name,
+		message)
+}
+
+// SetLogLevel sets the node's log verbosity for the modules that match the module pattern, e.g. "server" or "*peer*",
+// or "*" for all modules, and takes effect immediately. The modules are the source file names without the .go
+// extension, as in Config.GlogVmodule. The node's own log lines are filtered by its levels alone. The lib package logs
+// through glog, whose verbosity is process-wide, so its modules log at the highest level that any node in the process
+// set for them. The levels carry over across restarts, and to new nodes created from the same config.
+func (node *Node) SetLogLevel(module string, level int) {
+	node.log.setLevel(module, level)
+
+	// Copy the levels, rather than modifying the map in place, since it can be shared by several configs.
+	logLevels := make(map[string]int, len(node.Config.LogLevels)+1)
+	for existingModule, existingLevel := range node.Config.LogLevels {
+		logLevels[existingModule] = existingLevel
+	}
+	logLevels[module] = level
+	node.Config.LogLevels = logLevels
+
+	setGlogModuleLevel(node.log, module, level)
+	flag.Set("vmodule", glogVmodule(node.Config.GlogVmodule))
+}
+
+var (
+	glogModuleLevelsMtx sync.Mutex
+	// glogModuleLevels are the levels that the nodes of the process set with SetLogLevel, by module pattern and by
+	// node logger.
+	glogModuleLevels = make(map[string]map[*nodeLogger]int)
+)
+
+// setGlogModuleLevel records the level that the node logger set for the module pattern.
+func setGlogModuleLevel(logger *nodeLogger, module string, level int) {
+	glogModuleLevelsMtx.Lock()
+	defer glogModuleLevelsMtx.Unlock()
+	if glogModuleLevels[module] == nil {
+		glogModuleLevels[module] = make(map[*nodeLogger]int)
+	}
+	glogModuleLevels[module][logger] = level
+}
+
+// glogVmodule returns glog's vmodule setting, with the highest level set by any node for every module pattern ahead of
+// the base setting, e.g. Config.GlogVmodule, since glog applies the first pattern that matches a file.
+func glogVmodule(base string) string {
+	glogModuleLevelsMtx.Lock()
+	defer glogModuleLevelsMtx.Unlock()
+	modules := make([]string, 0, len(glogModuleLevels))
+	for module := range glogModuleLevels {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+
+	var patterns []string
+	for _, module := range modules {
+		maxLevel := 0
+		for _, level := range glogModuleLevels[module] {
+			if level > maxLevel {
+				maxLevel = level
+			}
+		}
+		patterns = append(patterns, fmt.Sprintf("%v=%v", module, maxLevel))
+	}
+	if base != "" {
+		patterns = append(patterns, base)
+	}
+	return strings.Join(patterns, ",")
+}
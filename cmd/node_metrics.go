@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+// Metrics returns the registry of the node's metrics. The registry outlives the node's restarts, so its counters keep
+// adding up across them, and it can be read whether or not the metrics are served on Config.MetricsPort.
+func (node *Node) Metrics() *lib.MetricsRegistry {
+	return node.metrics
+}
+
+// MetricsAddr returns the address the metrics are served on, or nil if they aren't served.
+func (node *Node) MetricsAddr() net.Addr {
+	if node.metricsListener == nil {
+		return nil
+	}
+	return node.metricsListener.Addr()
+}
+
+// collectMetrics sets the gauges of the running node's server. It's the registry's collector, and the gauges keep their
+// values while the node isn't running, see stopMetrics.
+func (node *Node) collectMetrics(recorder lib.MetricsRecorder) {
+	node.statusMutex.RLock()
+	tracker := node.statusTracker
+	node.statusMutex.RUnlock()
+	if tracker == nil {
+		return
+	}
+	tracker.server.CollectMetrics(recorder)
+}
+
+// listenMetrics binds the metrics listener on Config.MetricsPort, if it's set. The metrics are served once
+// serveMetrics is called.
+func (node *Node) listenMetrics() (net.Listener, error) {
+	if node.Config.MetricsPort == 0 {
+		return nil, nil
+	}
+	return net.Listen("tcp", fmt.Sprintf(":%d", node.Config.MetricsPort))
+}
+
+// serveMetrics serves the metrics in the Prometheus text format at /metrics on the listener, along with the health
+// probes of HealthHandler.
+func (node *Node) serveMetrics(listener net.Listener) {
+	mux := http.NewServeMux()
+	healthHandler := node.HealthHandler()
+	mux.Handle("/healthz", healthHandler)
+	mux.Handle("/readyz", healthHandler)
+	mux.HandleFunc("/metrics", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := node.metrics.WriteText(writer); err != nil {
+			node.log.Errorf("Node.serveMetrics: Problem writing metrics: %v", err)
+		}
+	})
+	node.metricsListener = listener
+	node.metricsServer = &http.Server{Handler: mux}
+	go func(server *http.Server) {
+		if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			node.log.Errorf("Node.serveMetrics: Problem serving metrics: %v", err)
+		}
+	}(node.metricsServer)
+}
+
+// stopMetrics sets the gauges to their final values, so that they can still be read once the node stopped, then it
+// stops serving the metrics and closes the metrics listener. It must be called before the status tracker is stopped.
+func (node *Node) stopMetrics() {
+	node.collectMetrics(node.metrics)
+	if node.metricsServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), nodeShutdownTimeout)
+	defer cancel()
+	if err := node.metricsServer.Shutdown(ctx); err != nil {
+		node.log.Errorf("Node.stopMetrics: Problem stopping metrics server: %v", err)
+	}
+	node.metricsServer = nil
+	node.metricsListener = nil
+}
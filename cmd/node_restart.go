@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// ErrNodeRestarting is returned by Restart when the node is already restarting.
+	ErrNodeRestarting = errors.New("Node.Restart: Node is already restarting")
+	// ErrNodeNotRunning is returned by Restart when the node isn't running.
+	ErrNodeNotRunning = errors.New("Node.Restart: Node isn't running")
+)
+
+// Restart stops the node gracefully and starts it again with the same config. See RestartWithConfig.
+func (node *Node) Restart() error {
+	return node.RestartWithConfig(nil)
+}
+
+// RestartWithConfig stops the node gracefully, applies mutate to a copy of the node's config, unless it's nil, and
+// starts the node again with the mutated config. The node reopens its databases, creates a new server, and binds the
+// same protocol port, even if the port was picked by the OS. The node is restarted in place, so references to it stay
+// valid, but its Server, ChainDB and TXIndex are replaced. An in-memory node keeps its dbs, and so its state, across
+// the restart, see Config.InMemory. The network params, the data directory, and InMemory can't change across a
+// restart. Restarting a node that isn't running fails with ErrNodeNotRunning, and restarting a node that's
+// already restarting fails with ErrNodeRestarting. If the node fails to start again, it's left stopped.
+func (node *Node) RestartWithConfig(mutate func(config *Config)) error {
+	if !atomic.CompareAndSwapInt32(&node.restarting, 0, 1) {
+		return ErrNodeRestarting
+	}
+	defer atomic.StoreInt32(&node.restarting, 0)
+	return node.restart(mutate, false)
+}
+
+// restartPollInterval is how often a restart requested by the engine checks if the restart in progress is done.
+const restartPollInterval = 10 * time.Millisecond
+
+// restartForEngine restarts the node like Restart does, when the engine requests it through the nodeMessageChan, so
+// that an in-memory node keeps its dbs. If erase is true, the data directory is erased while the node is stopped, and
+// the dbs of an in-memory node are discarded. The engine can request a restart while the node is being restarted, e.g.
+// when the snapshot has to be recovered on the restart's Start, so restartForEngine waits for that restart to be done
+// rather than failing with ErrNodeRestarting. The exitChannels are passed to Start.
+func (node *Node) restartForEngine(erase bool, exitChannels ...*chan struct{}) error {
+	for !atomic.CompareAndSwapInt32(&node.restarting, 0, 1) {
+		time.Sleep(restartPollInterval)
+	}
+	defer atomic.StoreInt32(&node.restarting, 0)
+	return node.restart(nil, erase, exitChannels...)
+}
+
+// restart does the work of RestartWithConfig and restartForEngine, the caller must have set restarting.
+func (node *Node) restart(mutate func(config *Config), erase bool, exitChannels ...*chan struct{}) error {
+	if !node.IsRunning() {
+		return ErrNodeNotRunning
+	}
+
+	newConfig := *node.Config
+	if mutate != nil {
+		mutate(&newConfig)
+	}
+	if newConfig.Params != node.Config.Params {
+		return fmt.Errorf("Node.RestartWithConfig: Params can't change across a restart")
+	}
+	if newConfig.DataDirectory != node.Config.DataDirectory {
+		return fmt.Errorf("Node.RestartWithConfig: DataDirectory can't change across a restart, was (%v), got (%v)",
+			node.Config.DataDirectory, newConfig.DataDirectory)
+	}
+	if newConfig.InMemory != node.Config.InMemory {
+		return fmt.Errorf("Node.RestartWithConfig: InMemory can't change across a restart")
+	}
+
+	node.log.Infof("Node.RestartWithConfig: Restarting node")
+	if err := node.Stop(); err != nil {
+		return fmt.Errorf("Node.RestartWithConfig: Problem stopping node: %v", err)
+	}
+	if erase {
+		if err := node.EraseDataDirectory(); err != nil {
+			return fmt.Errorf("Node.RestartWithConfig: %v, you should run `rm -rf %v` to delete it manually", err,
+				node.Config.DataDirectory)
+		}
+	}
+	node.Config = &newConfig
+	node.log.configure(node.Config)
+	if err := node.Start(exitChannels...); err != nil {
+		return fmt.Errorf("Node.RestartWithConfig: Problem starting node: %w", err)
+	}
+	return nil
+}
+
+// RestartError returns the error of the last restart that the engine requested, e.g. to recover a snapshot that wasn't
+// flushed, or nil if it succeeded or the engine never requested one. If the restart failed, the node is left stopped,
+// and the exitChannels passed to Start are closed.
+func (node *Node) RestartError() error {
+	node.restartErrMutex.Lock()
+	defer node.restartErrMutex.Unlock()
+	return node.restartErr
+}
+
+func (node *Node) setRestartError(err error) {
+	node.restartErrMutex.Lock()
+	defer node.restartErrMutex.Unlock()
+	node.restartErr = err
+}
@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// NodeState is the lifecycle state of a node, returned by Node.State. A node goes from NodeStateCreated, through
+// NodeStateStarting, to NodeStateRunning when it's started, and through NodeStateStopping to NodeStateStopped when it's
+// stopped or crashed, or when it fails to start. A stopped node can be started again.
+type NodeState int32
+
+const (
+	NodeStateCreated NodeState = iota
+	NodeStateStarting
+	NodeStateRunning
+	NodeStateStopping
+	NodeStateStopped
+)
+
+func (state NodeState) String() string {
+	switch state {
+	case NodeStateCreated:
+		return "CREATED"
+	case NodeStateStarting:
+		return "STARTING"
+	case NodeStateRunning:
+		return "RUNNING"
+	case NodeStateStopping:
+		return "STOPPING"
+	case NodeStateStopped:
+		return "STOPPED"
+	default:
+		return fmt.Sprintf("UNKNOWN(%d)", int32(state))
+	}
+}
+
+// ErrNodeAlreadyRunning is returned by Start when the node is already running, in which case Start has no effect.
+var ErrNodeAlreadyRunning = errors.New("Node.Start: Node is already running")
+
+// ErrNodeNeedsResync is wrapped in the error returned by Start when the node's data directory can't be recovered, e.g.
+// because the node was stopped in the middle of a snapshot flush, and rolling back to the last snapshot epoch failed.
+// The node is left stopped with its data directory as is, which can be erased with EraseDataDirectory, so that the
+// node resyncs from scratch when it's started again.
+var ErrNodeNeedsResync = errors.New("Node.Start: Node's data directory can't be recovered, it has to be erased " +
+	"and resynced")
+
+// State returns the lifecycle state of the node. It's safe to call concurrently with the node starting or stopping,
+// and it doesn't wait for Start or Stop to finish.
+func (node *Node) State() NodeState {
+	return NodeState(atomic.LoadInt32(&node.state))
+}
+
+// IsRunning returns true if the node is in NodeStateRunning, i.e. it's started and it's not stopping. Mainly used in
+// testing.
+func (node *Node) IsRunning() bool {
+	return node.State() == NodeStateRunning
+}
+
+// setState moves the node to the state. It must be called with the runningMutex held.
+func (node *Node) setState(state NodeState) {
+	atomic.StoreInt32(&node.state, int32(state))
+}
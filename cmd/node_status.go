@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"encoding/hex"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+// NodeStatus is a snapshot of the basic facts about a node, returned by Node.Status. It's a plain value, so it can be
+// kept after the node moved on, and it marshals to JSON.
+type NodeStatus struct {
+	// Running is false if the node isn't started, in which case the other fields are zero.
+	Running bool
+	// StartTime is when the node was last started, and Uptime is how long ago that was.
+	StartTime time.Time
+	Uptime    time.Duration
+
+	ChainState      lib.SyncState
+	HeaderTipHeight uint32
+	HeaderTipHash   string
+	BlockTipHeight  uint32
+	BlockTipHash    string
+
+	// NumPeers is the number of connected peers that completed version negotiation.
+	NumPeers       int
+	NumMempoolTxns int
+	HyperSync      HyperSyncStatus
+	TXIndex        TXIndexStatus
+}
+
+// HyperSyncStatus summarizes the progress of hypersync. It's empty if the node didn't hypersync since it started.
+type HyperSyncStatus struct {
+	// CompletedPrefixes is the number of the TotalPrefixes state prefixes that were fully downloaded.
+	CompletedPrefixes int
+	TotalPrefixes     int
+	// Prefixes has the progress of every prefix that hypersync started downloading, ordered by prefix.
+	Prefixes []HyperSyncPrefixStatus
+}
+
+// HyperSyncPrefixStatus is the progress of hypersync on a single state prefix.
+type HyperSyncPrefixStatus struct {
+	// Prefix is the hex encoded prefix, and LastReceivedKey is the hex encoded last key received for it.
+	Prefix          string
+	LastReceivedKey string
+	ReceivedBytes   uint64
+	Completed       bool
+}
+
+// TXIndexStatus is the sync state of the TXIndex. It's empty if the TXIndex is disabled.
+type TXIndexStatus struct {
+	Enabled   bool
+	TipHeight uint32
+	// Synced is true if the TXIndex indexed the block tip of the node.
+	Synced bool
+}
+
+// nodeStatusTracker keeps the subsystems of a started node that Status reads from, and the parts of the status that
+// can't be read safely from the server while it runs, i.e. the hypersync progress, which is followed through the
+// server's progress events.
+type nodeStatusTracker struct {
+	server    *lib.Server
+	listeners []net.Listener
+	startTime time.Time
+
+	// mtx protects the TXIndex, which is set once it's initialized, and the hypersync progress.
+	mtx               sync.RWMutex
+	txIndex           *lib.TXIndex
+	hyperSyncPrefixes map[string]HyperSyncPrefixStatus
+
+	done        chan struct{}
+	unsubscribe func()
+}
+
+// startStatusTracker starts tracking the status of the node's server. It must be called before the server starts, so
+// that no hypersync progress is missed.
+func (node *Node) startStatusTracker() {
+	progress, unsubscribe := node.Server.SubscribeHyperSyncProgress()
+	tracker := &nodeStatusTracker{
+		server:            node.Server,
+		listeners:         node.listeners,
+		startTime:         time.Now(),
+		hyperSyncPrefixes: make(map[string]HyperSyncPrefixStatus),
+		done:              make(chan struct{}),
+		unsubscribe:       unsubscribe,
+	}
+	go func() {
+		for {
+			select {
+			case <-tracker.done:
+				return
+			case prefixProgress := <-progress:
+				tracker.recordHyperSyncProgress(prefixProgress)
+			}
+		}
+	}()
+
+	node.statusMutex.Lock()
+	defer node.statusMutex.Unlock()
+	node.statusTracker = tracker
+}
+
+// stopStatusTracker stops tracking the status of the node, after which Status reports the node as not running.
+func (node *Node) stopStatusTracker() {
+	node.statusMutex.Lock()
+	defer node.statusMutex.Unlock()
+	if node.statusTracker == nil {
+		return
+	}
+	close(node.statusTracker.done)
+	node.statusTracker.unsubscribe()
+	node.statusTracker = nil
+}
+
+// setStatusTXIndex makes Status report the sync state of the TXIndex.
+func (node *Node) setStatusTXIndex(txIndex *lib.TXIndex) {
+	node.statusMutex.RLock()
+	defer node.statusMutex.RUnlock()
+	if node.statusTracker == nil {
+		return
+	}
+	node.statusTracker.mtx.Lock()
+	defer node.statusTracker.mtx.Unlock()
+	node.statusTracker.txIndex = txIndex
+}
+
+func (tracker *nodeStatusTracker) recordHyperSyncProgress(prefixProgress lib.SyncPrefixProgress) {
+	tracker.mtx.Lock()
+	defer tracker.mtx.Unlock()
+	prefix := hex.EncodeToString(prefixProgress.Prefix)
+	tracker.hyperSyncPrefixes[prefix] = HyperSyncPrefixStatus{
+		Prefix:          prefix,
+		LastReceivedKey: hex.EncodeToString(prefixProgress.LastReceivedKey),
+		ReceivedBytes:   prefixProgress.ReceivedBytes,
+		Completed:       prefixProgress.Completed,
+	}
+}
+
+func (tracker *nodeStatusTracker) hyperSyncStatus() HyperSyncStatus {
+	tracker.mtx.RLock()
+	defer tracker.mtx.RUnlock()
+	if len(tracker.hyperSyncPrefixes) == 0 {
+		return HyperSyncStatus{}
+	}
+	status := HyperSyncStatus{TotalPrefixes: len(lib.StatePrefixes.StatePrefixesList)}
+	for _, prefixStatus := range tracker.hyperSyncPrefixes {
+		status.Prefixes = append(status.Prefixes, prefixStatus)
+		if prefixStatus.Completed {
+			status.CompletedPrefixes++
+		}
+	}
+	sort.Slice(status.Prefixes, func(ii, jj int) bool {
+		return status.Prefixes[ii].Prefix < status.Prefixes[jj].Prefix
+	})
+	return status
+}
+
+// Status returns a snapshot of the node's status. It's safe to call concurrently with the node running, starting, or
+// stopping, and it doesn't wait for Start or Stop to finish. The chain fields are read under the chain lock, so they
+// are consistent with each other.
+func (node *Node) Status() NodeStatus {
+	node.statusMutex.RLock()
+	tracker := node.statusTracker
+	node.statusMutex.RUnlock()
+	if tracker == nil {
+		return NodeStatus{}
+	}
+	server := tracker.server
+	tracker.mtx.RLock()
+	txIndex := tracker.txIndex
+	tracker.mtx.RUnlock()
+
+	status := NodeStatus{
+		Running:        true,
+		StartTime:      tracker.startTime,
+		Uptime:         time.Since(tracker.startTime),
+		NumPeers:       server.GetConnectionManager().NumConnectedPeers(),
+		NumMempoolTxns: server.GetMempool().Count(),
+		HyperSync:      tracker.hyperSyncStatus(),
+	}
+
+	chain := server.GetBlockchain()
+	chain.ChainLock.RLock()
+	status.ChainState = chain.ChainState()
+	if headerTip := chain.HeaderTip(); headerTip != nil {
+		status.HeaderTipHeight = headerTip.Height
+		status.HeaderTipHash = headerTip.Hash.String()
+	}
+	blockTip := chain.BlockTip()
+	if blockTip != nil {
+		status.BlockTipHeight = blockTip.Height
+		status.BlockTipHash = blockTip.Hash.String()
+	}
+	chain.ChainLock.RUnlock()
+
+	if txIndex != nil {
+		status.TXIndex.Enabled = true
+		txIndex.TXIndexChain.ChainLock.RLock()
+		if txIndexTip := txIndex.TXIndexChain.BlockTip(); txIndexTip != nil {
+			status.TXIndex.TipHeight = txIndexTip.Height
+			status.TXIndex.Synced = blockTip != nil && txIndexTip.Hash.IsEqual(blockTip.Hash)
+		}
+		txIndex.TXIndexChain.ChainLock.RUnlock()
+	}
+	return status
+}
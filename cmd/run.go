@@ -1,6 +1,10 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+
+	"github.com/deso-protocol/core/lib"
 	"github.com/golang/glog"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -26,13 +30,29 @@ func Run(cmd *cobra.Command, args []string) {
 	// Start the deso node
 	shutdownListener := make(chan struct{})
 	node := NewNode(config)
-	node.Start(&shutdownListener)
+	err := node.Start(&shutdownListener)
+	if errors.Is(err, ErrNodeNeedsResync) {
+		glog.Error(lib.CLog(lib.Red, fmt.Sprintf("%v, the node will be erased and resynced", err)))
+		if err := node.EraseDataDirectory(); err != nil {
+			glog.Fatal(err)
+		}
+		err = node.Start(&shutdownListener)
+	}
+	if err != nil {
+		glog.Fatal(err)
+	}
 
 	defer func() {
-		node.Stop()
+		if err := node.Stop(); err != nil {
+			glog.Error(err)
+		}
 		glog.Info("Shutdown complete")
 	}()
 	<-shutdownListener
+	// The node is stopped for good if a restart requested by the engine failed, so exit with an error.
+	if err := node.RestartError(); err != nil {
+		glog.Fatal(err)
+	}
 }
 
 func SetupRunFlags(cmd *cobra.Command) {
@@ -59,6 +79,12 @@ func SetupRunFlags(cmd *cobra.Command) {
 		"Postgres instance on the same machine as your node for optimal performance.")
 	cmd.PersistentFlags().Uint32("max-sync-block-height", 0,
 		"Max sync block height")
+	cmd.PersistentFlags().Uint32("prune-blocks-older-than", 0,
+		"When set, the node only keeps the bodies of this many of its most recent blocks, and deletes "+
+			"older ones, while keeping their headers and the state. A pruned node can't serve old blocks "+
+			"to syncing peers, nor follow a reorg deeper than this. Not compatible with --txindex, "+
+			"or --postgres-uri, and requires --sync-type=hypersync with --hypersync. Defaults to 0, "+
+			"which keeps all blocks.")
 	// Hyper Sync
 	cmd.PersistentFlags().Bool("hypersync", true, "Use hyper sync protocol for faster block syncing")
 	cmd.PersistentFlags().Bool("force-checksum", true, "When true, the node will panic if the "+
@@ -113,6 +139,8 @@ func SetupRunFlags(cmd *cobra.Command) {
 			"our connections and potentially make onerous requests as well. Useful to "+
 			"disable this flag when testing locally to allow multiple inbound connections "+
 			"from test servers")
+	cmd.PersistentFlags().Bool("standalone", false, "The node is expected to run without peers, so it reports "+
+		"itself as ready on /readyz without any.")
 
 	// Listeners
 	cmd.PersistentFlags().Uint64("protocol-port", 0,
@@ -120,6 +148,11 @@ func SetupRunFlags(cmd *cobra.Command) {
 			"messages. If unset, the port will default to what is present in the DeSoParams set. "+
 			"Note also that even though the node will listen on this port, its outbound "+
 			"connections will not be determined by this flag.")
+	cmd.PersistentFlags().StringSlice("listen-addrs", []string{},
+		"A comma-separated list of host:port addresses on which this node will listen for "+
+			"protocol-related messages, e.g. 127.0.0.1:17000,[::1]:17000. The hosts must be IPs, and "+
+			"a zero port picks a free port. When set, it replaces listening on the protocol port on "+
+			"every interface.")
 
 	// Mining + Admin
 	cmd.PersistentFlags().StringSlice("miner-public-keys", []string{},
@@ -193,6 +226,8 @@ func SetupRunFlags(cmd *cobra.Command) {
 	cmd.PersistentFlags().Bool("log-db-summary-snapshots", false, "The node will log a snapshot of all DB keys every 30s.")
 	cmd.PersistentFlags().Bool("datadog-profiler", false, "Enable the DataDog profiler for performance testing")
 	cmd.PersistentFlags().Bool("time-events", false, "Enable simple event timer, helpful in hands-on performance testing")
+	cmd.PersistentFlags().Uint64("metrics-port", 0, "When set, the node serves Prometheus metrics on this port "+
+		"at /metrics, and its liveness and readiness probes at /healthz and /readyz. Nothing is served by default.")
 
 	cmd.PersistentFlags().VisitAll(func(flag *pflag.Flag) {
 		viper.BindPFlag(flag.Name, flag)
@@ -0,0 +1,353 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/spf13/cobra"
+)
+
+// SnapshotFileFormatVersion is the version of the snapshot file format written by ExportSnapshot.
+const SnapshotFileFormatVersion uint32 = 1
+
+// SnapshotManifest describes the snapshot in a snapshot file, see ExportSnapshot. It's the first record of the file,
+// so that the snapshot can be checked before its state is read.
+type SnapshotManifest struct {
+	// FormatVersion is the version of the snapshot file format, see SnapshotFileFormatVersion.
+	FormatVersion uint32
+	// ParamsHash identifies the network params of the node that the snapshot was exported from, see paramsHash.
+	ParamsHash string
+	// EncoderVersion is the latest encoder migration version of the binary that exported the snapshot, which the
+	// state records are encoded with.
+	EncoderVersion byte
+	// SnapshotBlockHeight is the height of the snapshot epoch, i.e. the height of the block that the state is at.
+	SnapshotBlockHeight uint64
+	// SnapshotBlockHash is the hex encoded hash of the block at SnapshotBlockHeight.
+	SnapshotBlockHash string
+	// Checksum is the hex encoded state checksum of the snapshot epoch, see lib.StateChecksum.
+	Checksum string
+}
+
+// The records of a snapshot file. Every record is a record type byte, followed by the length of the record's payload
+// as a uvarint, and the payload. The file starts with the manifest, followed by the block nodes of the best chain from
+// height 1 up to the snapshot height, and the state chunks of every state prefix in StatePrefixesList order, and it
+// ends with an end record, so that a truncated file is detected.
+const (
+	snapshotRecordEnd byte = iota
+	// snapshotRecordManifest's payload is the JSON encoded SnapshotManifest.
+	snapshotRecordManifest
+	// snapshotRecordBlockNode's payload is a block node, encoded like in the block index, see lib.SerializeBlockNode.
+	snapshotRecordBlockNode
+	// snapshotRecordChunk's payload is a lib.MsgDeSoSnapshotData, encoded like hypersync sends it on the wire. Unlike
+	// the chunks sent on the wire, a chunk doesn't repeat the last record of the previous chunk of its prefix, and
+	// prefixes without records have no chunks.
+	snapshotRecordChunk
+)
+
+// ExportSnapshot writes the state of the node's data directory at its last snapshot epoch to a snapshot file at
+// outPath, which ImportSnapshot can load into a fresh data directory to bootstrap another node without syncing it over
+// the network. The state is read like a hypersync peer serves it, i.e. the records of the chain db merged with the
+// snapshot's ancestral records, so the node can be past the epoch, and its checksum is verified against the epoch's
+// checksum before the file is complete. The block nodes of the best chain up to the snapshot height are exported along
+// with the state, so that the imported node knows the header chain of the snapshot. The node must run with HyperSync
+// and be stopped, see RunChecksumVerify, and lib.GlobalDeSoParams must be the params of the data directory. The file
+// is written to a temporary file first, so that a failed export doesn't leave a partial snapshot behind.
+func ExportSnapshot(dataDir string, outPath string) (*SnapshotManifest, error) {
+	stamp, err := ReadDataDirectoryVersion(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("ExportSnapshot: %v", err)
+	}
+	if stamp != nil && stamp.ParamsHash != paramsHash(&lib.GlobalDeSoParams) {
+		return nil, fmt.Errorf("ExportSnapshot: Data directory (%v) was written for different network params "+
+			"than lib.GlobalDeSoParams, e.g. another network or regtest", dataDir)
+	}
+
+	dbDir := lib.GetBadgerDbPath(dataDir)
+	snapshotDir := filepath.Join(dbDir, "snapshot")
+	if _, err := os.Stat(dbDir); err != nil {
+		return nil, fmt.Errorf("ExportSnapshot: Problem finding chain db (%v): %v", dbDir, err)
+	}
+	if _, err := os.Stat(snapshotDir); err != nil {
+		return nil, fmt.Errorf("ExportSnapshot: Problem finding snapshot db (%v), the node must run with "+
+			"HyperSync: %v", snapshotDir, err)
+	}
+	chainDB, err := openStoppedDB(dbDir, dbDir)
+	if err != nil {
+		return nil, fmt.Errorf("ExportSnapshot: Problem opening chain db (%v): %v", dbDir, err)
+	}
+	defer chainDB.Close()
+	snapshotDB, err := openStoppedDB(snapshotDir, lib.GetBadgerDbPath(snapshotDir))
+	if err != nil {
+		return nil, fmt.Errorf("ExportSnapshot: Problem opening snapshot db (%v): %v", snapshotDir, err)
+	}
+	defer snapshotDB.Close()
+
+	var snapshotDBMutex sync.Mutex
+	metadata := &lib.SnapshotEpochMetadata{}
+	if err := metadata.Initialize(snapshotDB, &snapshotDBMutex); err != nil {
+		return nil, fmt.Errorf("ExportSnapshot: Problem reading snapshot metadata: %v", err)
+	}
+	status := &lib.SnapshotStatus{}
+	if err := status.Initialize(snapshotDB, &snapshotDBMutex); err != nil {
+		return nil, fmt.Errorf("ExportSnapshot: Problem reading snapshot status: %v", err)
+	}
+	if status.IsFlushing() {
+		return nil, fmt.Errorf("ExportSnapshot: The node was stopped in the middle of a snapshot flush, start it " +
+			"to recover the snapshot before exporting it")
+	}
+	if metadata.SnapshotBlockHeight == 0 {
+		return nil, fmt.Errorf("ExportSnapshot: The node hasn't completed a snapshot epoch yet")
+	}
+	snapshotHeight := metadata.SnapshotBlockHeight
+
+	bestChain, err := readBestChain(chainDB)
+	if err != nil {
+		return nil, fmt.Errorf("ExportSnapshot: %v", err)
+	}
+	if uint64(len(bestChain)) <= snapshotHeight ||
+		*bestChain[snapshotHeight].Hash != *metadata.CurrentEpochBlockHash {
+		return nil, fmt.Errorf("ExportSnapshot: Snapshot block (%v) at height (%v) isn't in the best chain",
+			metadata.CurrentEpochBlockHash, snapshotHeight)
+	}
+
+	manifest := &SnapshotManifest{
+		FormatVersion:       SnapshotFileFormatVersion,
+		ParamsHash:          paramsHash(&lib.GlobalDeSoParams),
+		EncoderVersion:      NewDataDirectoryVersion(&lib.GlobalDeSoParams).EncoderVersion,
+		SnapshotBlockHeight: snapshotHeight,
+		SnapshotBlockHash:   hex.EncodeToString(metadata.CurrentEpochBlockHash[:]),
+		Checksum:            hex.EncodeToString(metadata.CurrentEpochChecksumBytes),
+	}
+
+	if err := os.MkdirAll(filepath.Dir(outPath), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("ExportSnapshot: Problem creating directory for (%v): %v", outPath, err)
+	}
+	tempPath := outPath + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return nil, fmt.Errorf("ExportSnapshot: Problem creating file (%v): %v", tempPath, err)
+	}
+	defer os.Remove(tempPath)
+
+	// The snapshot only reads the ancestral records of the epoch, which the node already flushed before it stopped.
+	snap := &lib.Snapshot{
+		SnapshotDb:                   snapshotDB,
+		SnapshotDbMutex:              &snapshotDBMutex,
+		CurrentEpochSnapshotMetadata: metadata,
+		Status:                       status,
+	}
+	writer := bufio.NewWriter(file)
+	if err := writeSnapshotFile(writer, manifest, snap, chainDB, bestChain[1:snapshotHeight+1]); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("ExportSnapshot: Problem writing snapshot to (%v): %v", tempPath, err)
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("ExportSnapshot: Problem writing snapshot to (%v): %v", tempPath, err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("ExportSnapshot: Problem closing file (%v): %v", tempPath, err)
+	}
+	if err := os.Rename(tempPath, outPath); err != nil {
+		return nil, fmt.Errorf("ExportSnapshot: Problem renaming (%v) to (%v): %v", tempPath, outPath, err)
+	}
+	return manifest, nil
+}
+
+// readBestChain reads the best chain of the chain db, from the genesis block up to the best block.
+func readBestChain(chainDB *badger.DB) ([]*lib.BlockNode, error) {
+	bestHash := lib.DbGetBestHash(chainDB, nil, lib.ChainTypeDeSoBlock)
+	if bestHash == nil {
+		return nil, fmt.Errorf("readBestChain: Chain db has no best block")
+	}
+	blockIndex, err := lib.GetBlockIndex(chainDB, false /*bitcoinNodes*/)
+	if err != nil {
+		return nil, fmt.Errorf("readBestChain: Problem reading block index: %v", err)
+	}
+	tipNode, exists := blockIndex[*bestHash]
+	if !exists {
+		return nil, fmt.Errorf("readBestChain: Best block (%v) isn't in the block index", bestHash)
+	}
+	bestChain, err := lib.GetBestChain(tipNode, blockIndex)
+	if err != nil {
+		return nil, fmt.Errorf("readBestChain: Problem reading best chain: %v", err)
+	}
+	return bestChain, nil
+}
+
+// writeSnapshotFile writes the snapshot file with the manifest, the block nodes, and the state of snap's epoch. The
+// checksum of the state is computed as it's written, and writing fails if it doesn't match the manifest's checksum.
+func writeSnapshotFile(writer io.Writer, manifest *SnapshotManifest, snap *lib.Snapshot, chainDB *badger.DB,
+	blockNodes []*lib.BlockNode) error {
+
+	manifestBytes, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("writeSnapshotFile: Problem encoding manifest: %v", err)
+	}
+	if err := writeSnapshotRecord(writer, snapshotRecordManifest, manifestBytes); err != nil {
+		return err
+	}
+	for _, blockNode := range blockNodes {
+		blockNodeBytes, err := lib.SerializeBlockNode(blockNode)
+		if err != nil {
+			return fmt.Errorf("writeSnapshotFile: Problem encoding block node at height (%v): %v",
+				blockNode.Height, err)
+		}
+		if err := writeSnapshotRecord(writer, snapshotRecordBlockNode, blockNodeBytes); err != nil {
+			return err
+		}
+	}
+
+	checksum := &lib.StateChecksum{}
+	checksum.Initialize(nil, nil)
+	for _, prefix := range lib.StatePrefixes.StatePrefixesList {
+		var lastKey []byte
+		for startKey, isChunkFull := prefix, true; isChunkFull; {
+			var entries []*lib.DBEntry
+			var concurrencyFault bool
+			entries, isChunkFull, concurrencyFault, err = snap.GetSnapshotChunk(chainDB, prefix, startKey)
+			if err != nil {
+				return fmt.Errorf("writeSnapshotFile: Problem reading chunk of prefix %v: %v",
+					lib.StatePrefixName(prefix), err)
+			}
+			// The node is stopped, so nothing can flush to the dbs while they're read.
+			if concurrencyFault {
+				return fmt.Errorf("writeSnapshotFile: Snapshot of prefix %v changed while it was read",
+					lib.StatePrefixName(prefix))
+			}
+			// A chunk starts at the last record of the previous chunk, which was already written.
+			if len(entries) > 0 && lastKey != nil && bytes.Equal(entries[0].Key, lastKey) {
+				entries = entries[1:]
+			}
+			if len(entries) == 0 || entries[0].IsEmpty() {
+				break
+			}
+			for _, entry := range entries {
+				if err := checksum.AddOrRemoveBytesWithMigrations(entry.Key, entry.Value,
+					manifest.SnapshotBlockHeight, nil, true); err != nil {
+					return fmt.Errorf("writeSnapshotFile: Problem computing checksum: %v", err)
+				}
+			}
+			chunkBytes, err := (&lib.MsgDeSoSnapshotData{
+				SnapshotMetadata:  snap.CurrentEpochSnapshotMetadata,
+				SnapshotChunk:     entries,
+				SnapshotChunkFull: isChunkFull,
+				Prefix:            prefix,
+			}).ToBytes(false)
+			if err != nil {
+				return fmt.Errorf("writeSnapshotFile: Problem encoding chunk of prefix %v: %v",
+					lib.StatePrefixName(prefix), err)
+			}
+			if err := writeSnapshotRecord(writer, snapshotRecordChunk, chunkBytes); err != nil {
+				return err
+			}
+			lastKey = entries[len(entries)-1].Key
+			startKey = lastKey
+		}
+	}
+
+	checksumBytes, err := checksum.ToBytes()
+	if err != nil {
+		return fmt.Errorf("writeSnapshotFile: Problem computing checksum: %v", err)
+	}
+	if hex.EncodeToString(checksumBytes) != manifest.Checksum {
+		return fmt.Errorf("writeSnapshotFile: Checksum of the exported state (%v) doesn't match the snapshot "+
+			"checksum (%v)", hex.EncodeToString(checksumBytes), manifest.Checksum)
+	}
+	return writeSnapshotRecord(writer, snapshotRecordEnd, nil)
+}
+
+// writeSnapshotRecord writes a record of a snapshot file.
+func writeSnapshotRecord(writer io.Writer, recordType byte, payload []byte) error {
+	record := append([]byte{recordType}, lib.UintToBuf(uint64(len(payload)))...)
+	if _, err := writer.Write(append(record, payload...)); err != nil {
+		return fmt.Errorf("writeSnapshotRecord: Problem writing record: %v", err)
+	}
+	return nil
+}
+
+// readSnapshotRecord reads a record of a snapshot file.
+func readSnapshotRecord(reader *bufio.Reader) (_recordType byte, _payload []byte, _err error) {
+	recordType, err := reader.ReadByte()
+	if err != nil {
+		return 0, nil, fmt.Errorf("readSnapshotRecord: Problem reading record type: %v", err)
+	}
+	payloadLen, err := lib.ReadUvarint(reader)
+	if err != nil {
+		return 0, nil, fmt.Errorf("readSnapshotRecord: Problem reading record length: %v", err)
+	}
+	payload, err := lib.SafeMakeSliceWithLength[byte](payloadLen)
+	if err != nil {
+		return 0, nil, fmt.Errorf("readSnapshotRecord: Problem allocating record: %v", err)
+	}
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		return 0, nil, fmt.Errorf("readSnapshotRecord: Problem reading record: %v", err)
+	}
+	return recordType, payload, nil
+}
+
+var snapshotExportCmd = &cobra.Command{
+	Use:   "snapshot-export",
+	Short: "Export the state of a stopped node at its last snapshot epoch to a file",
+	Long: `Writes the state of the node's data directory at its last snapshot epoch to a snapshot file, in the
+chunk encoding that hypersync uses on the wire, which snapshot-import can load into a fresh data directory. The node
+must run with --hypersync and be stopped.`,
+	Run: SnapshotExport,
+}
+
+func init() {
+	snapshotExportCmd.Flags().String("data-dir", "",
+		"The data directory of the node, as passed to run. When unset, defaults to the system's configuration "+
+			"directory.")
+	snapshotExportCmd.Flags().Bool("testnet", false, "The node runs on the DeSo testnet")
+	snapshotExportCmd.Flags().Bool("regtest", false, "The node runs in regtest, with --testnet")
+	snapshotExportCmd.Flags().String("out", "", "The path of the snapshot file to write.")
+	rootCmd.AddCommand(snapshotExportCmd)
+}
+
+func SnapshotExport(cmd *cobra.Command, args []string) {
+	dataDir := snapshotCommandDataDir(cmd)
+	outPath, _ := cmd.Flags().GetString("out")
+	if outPath == "" {
+		fmt.Fprintln(os.Stderr, "SnapshotExport: --out is required")
+		os.Exit(1)
+	}
+
+	manifest, err := ExportSnapshot(dataDir, outPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Exported snapshot at height (%v) with checksum (%v) to (%v)\n", manifest.SnapshotBlockHeight,
+		manifest.Checksum, outPath)
+}
+
+// snapshotCommandDataDir sets lib.GlobalDeSoParams from the network flags of the snapshot command, and returns the
+// versioned data directory of the node, like checksum-verify.
+func snapshotCommandDataDir(cmd *cobra.Command) string {
+	testnet, _ := cmd.Flags().GetBool("testnet")
+	regtest, _ := cmd.Flags().GetBool("regtest")
+	dataDir, _ := cmd.Flags().GetString("data-dir")
+
+	params := &lib.DeSoMainnetParams
+	if testnet {
+		params = &lib.DeSoTestnetParams
+	}
+	if regtest {
+		params = lib.NewDeSoRegtestParams()
+	}
+	lib.GlobalDeSoParams = *params
+	if dataDir == "" {
+		dataDir = lib.GetDataDir(params)
+	}
+	return filepath.Join(dataDir, lib.DBVersionString)
+}
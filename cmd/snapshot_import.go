@@ -0,0 +1,339 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/deso-protocol/go-deadlock"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/spf13/cobra"
+)
+
+// ImportSnapshot loads the snapshot file at inPath, written by ExportSnapshot, into the fresh data directory dataDir,
+// so that a node started on it is at the snapshot height as if it had just hypersynced the snapshot from a peer, and
+// syncs the remaining blocks from its peers. A node with the hypersync-archival sync type then downloads the blocks up
+// to the snapshot height too. The whole file is read and checked before anything is written, i.e. the manifest must
+// match lib.GlobalDeSoParams, the block nodes must chain up to the snapshot block, and the checksum of the state must
+// match the manifest's checksum. The data directory is stamped with its version at the end, and a failed import removes
+// the dbs it started writing, so the data directory can be imported into again.
+func ImportSnapshot(dataDir string, inPath string) (*SnapshotManifest, error) {
+	dbDir := lib.GetBadgerDbPath(dataDir)
+	if _, err := os.Stat(dbDir); !os.IsNotExist(err) {
+		return nil, fmt.Errorf("ImportSnapshot: Data directory (%v) already has a chain db, a snapshot can only be "+
+			"imported into a fresh data directory", dataDir)
+	}
+
+	// Check the whole file first, so that nothing is written for a snapshot that can't be imported.
+	params := &lib.GlobalDeSoParams
+	var blockNodes []*lib.BlockNode
+	checksum := &lib.StateChecksum{}
+	checksum.Initialize(nil, nil)
+	lastKeys := make(map[byte][]byte)
+	manifest, err := readSnapshotFile(inPath, func(manifest *SnapshotManifest) error {
+		return checkSnapshotManifest(manifest, params)
+	}, func(blockNode *lib.BlockNode) error {
+		parentHash := lib.MustDecodeHexBlockHash(params.GenesisBlockHashHex)
+		if len(blockNodes) > 0 {
+			parentHash = blockNodes[len(blockNodes)-1].Hash
+		}
+		if err := checkSnapshotBlockNode(blockNode, uint32(len(blockNodes)+1), parentHash); err != nil {
+			return err
+		}
+		blockNodes = append(blockNodes, blockNode)
+		return nil
+	}, func(chunk *lib.MsgDeSoSnapshotData, manifest *SnapshotManifest) error {
+		if err := checkSnapshotChunk(chunk, lastKeys); err != nil {
+			return err
+		}
+		for _, entry := range chunk.SnapshotChunk {
+			if err := checksum.AddOrRemoveBytesWithMigrations(entry.Key, entry.Value,
+				manifest.SnapshotBlockHeight, nil, true); err != nil {
+				return fmt.Errorf("ImportSnapshot: Problem computing checksum: %v", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("ImportSnapshot: Problem reading snapshot (%v): %v", inPath, err)
+	}
+	if uint64(len(blockNodes)) != manifest.SnapshotBlockHeight ||
+		hex.EncodeToString(blockNodes[len(blockNodes)-1].Hash[:]) != manifest.SnapshotBlockHash {
+		return nil, fmt.Errorf("ImportSnapshot: Block nodes of snapshot (%v) don't end at the snapshot block (%v) "+
+			"at height (%v)", inPath, manifest.SnapshotBlockHash, manifest.SnapshotBlockHeight)
+	}
+	checksumBytes, err := checksum.ToBytes()
+	if err != nil {
+		return nil, fmt.Errorf("ImportSnapshot: Problem computing checksum: %v", err)
+	}
+	if hex.EncodeToString(checksumBytes) != manifest.Checksum {
+		return nil, fmt.Errorf("ImportSnapshot: Checksum of the state in snapshot (%v) is (%v), but its manifest "+
+			"checksum is (%v)", inPath, hex.EncodeToString(checksumBytes), manifest.Checksum)
+	}
+
+	if err := os.MkdirAll(dbDir, os.ModePerm); err != nil {
+		return nil, fmt.Errorf("ImportSnapshot: Problem creating chain db directory (%v): %v", dbDir, err)
+	}
+	if err := writeSnapshotImport(dataDir, inPath, manifest, blockNodes); err != nil {
+		os.RemoveAll(dbDir)
+		return nil, fmt.Errorf("ImportSnapshot: Problem importing snapshot (%v): %v", inPath, err)
+	}
+	if err := WriteDataDirectoryVersion(dataDir, NewDataDirectoryVersion(params)); err != nil {
+		os.RemoveAll(dbDir)
+		return nil, fmt.Errorf("ImportSnapshot: %v", err)
+	}
+	return manifest, nil
+}
+
+// checkSnapshotManifest checks if a snapshot with the manifest can be imported by a node with the params.
+func checkSnapshotManifest(manifest *SnapshotManifest, params *lib.DeSoParams) error {
+	if manifest.FormatVersion != SnapshotFileFormatVersion {
+		return fmt.Errorf("checkSnapshotManifest: Snapshot has format version (%v), this version supports format "+
+			"version (%v)", manifest.FormatVersion, SnapshotFileFormatVersion)
+	}
+	if manifest.ParamsHash != paramsHash(params) {
+		return fmt.Errorf("checkSnapshotManifest: Snapshot was exported for different network params, e.g. " +
+			"another network or regtest")
+	}
+	current := NewDataDirectoryVersion(params)
+	if manifest.EncoderVersion > current.EncoderVersion {
+		return fmt.Errorf("checkSnapshotManifest: Snapshot was exported by a newer version with encoder version "+
+			"(%v), this version supports up to encoder version (%v), upgrade the node", manifest.EncoderVersion,
+			current.EncoderVersion)
+	}
+	if manifest.SnapshotBlockHeight == 0 {
+		return fmt.Errorf("checkSnapshotManifest: Snapshot has no snapshot height")
+	}
+	return nil
+}
+
+// checkSnapshotBlockNode checks if the block node is the block at the height, with the parent hash.
+func checkSnapshotBlockNode(blockNode *lib.BlockNode, height uint32, parentHash *lib.BlockHash) error {
+	if blockNode.Height != height {
+		return fmt.Errorf("checkSnapshotBlockNode: Expected block node at height (%v), got height (%v)", height,
+			blockNode.Height)
+	}
+	headerHash, err := blockNode.Header.Hash()
+	if err != nil {
+		return fmt.Errorf("checkSnapshotBlockNode: Problem hashing header at height (%v): %v", height, err)
+	}
+	if *headerHash != *blockNode.Hash {
+		return fmt.Errorf("checkSnapshotBlockNode: Header at height (%v) has hash (%v), not (%v)", height,
+			headerHash, blockNode.Hash)
+	}
+	if *blockNode.Header.PrevBlockHash != *parentHash {
+		return fmt.Errorf("checkSnapshotBlockNode: Block at height (%v) doesn't build on (%v)", height, parentHash)
+	}
+	return nil
+}
+
+// checkSnapshotChunk checks if the chunk only has records of its prefix, which is a state prefix, sorted after the
+// last key of the previous chunk of the prefix, which lastKeys holds by prefix.
+func checkSnapshotChunk(chunk *lib.MsgDeSoSnapshotData, lastKeys map[byte][]byte) error {
+	if len(chunk.Prefix) != 1 || !lib.StatePrefixes.StatePrefixesMap[chunk.Prefix[0]] {
+		return fmt.Errorf("checkSnapshotChunk: Chunk prefix (%v) isn't a state prefix", chunk.Prefix)
+	}
+	lastKey := lastKeys[chunk.Prefix[0]]
+	for _, entry := range chunk.SnapshotChunk {
+		if !bytes.HasPrefix(entry.Key, chunk.Prefix) {
+			return fmt.Errorf("checkSnapshotChunk: Chunk of prefix %v has a record of another prefix",
+				lib.StatePrefixName(chunk.Prefix))
+		}
+		if lastKey != nil && bytes.Compare(lastKey, entry.Key) != -1 {
+			return fmt.Errorf("checkSnapshotChunk: Records of prefix %v aren't sorted",
+				lib.StatePrefixName(chunk.Prefix))
+		}
+		lastKey = entry.Key
+	}
+	lastKeys[chunk.Prefix[0]] = lastKey
+	return nil
+}
+
+// readSnapshotFile reads the snapshot file at path, and calls the callbacks with its manifest, every block node, and
+// every chunk, in the file's order. A nil callback skips the records. It fails if a callback fails, or if the file is
+// malformed, e.g. truncated.
+func readSnapshotFile(path string, onManifest func(manifest *SnapshotManifest) error,
+	onBlockNode func(blockNode *lib.BlockNode) error,
+	onChunk func(chunk *lib.MsgDeSoSnapshotData, manifest *SnapshotManifest) error) (*SnapshotManifest, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("readSnapshotFile: Problem opening file: %v", err)
+	}
+	defer file.Close()
+	reader := bufio.NewReader(file)
+
+	recordType, payload, err := readSnapshotRecord(reader)
+	if err != nil {
+		return nil, err
+	}
+	if recordType != snapshotRecordManifest {
+		return nil, fmt.Errorf("readSnapshotFile: File doesn't start with a manifest, it's likely not a snapshot")
+	}
+	manifest := &SnapshotManifest{}
+	if err := json.Unmarshal(payload, manifest); err != nil {
+		return nil, fmt.Errorf("readSnapshotFile: Problem decoding manifest: %v", err)
+	}
+	if onManifest != nil {
+		if err := onManifest(manifest); err != nil {
+			return nil, err
+		}
+	}
+
+	for {
+		recordType, payload, err = readSnapshotRecord(reader)
+		if err != nil {
+			return nil, err
+		}
+		switch recordType {
+		case snapshotRecordEnd:
+			if _, err := reader.ReadByte(); err == nil {
+				return nil, fmt.Errorf("readSnapshotFile: File continues after its end record")
+			}
+			return manifest, nil
+		case snapshotRecordBlockNode:
+			if onBlockNode == nil {
+				continue
+			}
+			blockNode, err := lib.DeserializeBlockNode(payload)
+			if err != nil {
+				return nil, fmt.Errorf("readSnapshotFile: Problem decoding block node: %v", err)
+			}
+			if err := onBlockNode(blockNode); err != nil {
+				return nil, err
+			}
+		case snapshotRecordChunk:
+			if onChunk == nil {
+				continue
+			}
+			chunk := &lib.MsgDeSoSnapshotData{}
+			if err := chunk.FromBytes(payload); err != nil {
+				return nil, fmt.Errorf("readSnapshotFile: Problem decoding chunk: %v", err)
+			}
+			if err := onChunk(chunk, manifest); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("readSnapshotFile: Unknown record type (%v)", recordType)
+		}
+	}
+}
+
+// writeSnapshotImport writes the checked snapshot into the data directory's dbs in the same way that the node does at
+// the end of hypersync, see Server._handleSnapshot: the chain db is initialized with the genesis block, its seed state
+// is replaced by the snapshot's state, the block nodes are marked processed up to the snapshot block, which becomes
+// the best block, and the snapshot db gets the epoch metadata and checksum of the snapshot.
+func writeSnapshotImport(dataDir string, inPath string, manifest *SnapshotManifest,
+	blockNodes []*lib.BlockNode) error {
+
+	params := &lib.GlobalDeSoParams
+	dbDir := lib.GetBadgerDbPath(dataDir)
+	opts := lib.PerformanceBadgerOptions(dbDir)
+	opts.ValueDir = dbDir
+	chainDB, err := badger.Open(opts)
+	if err != nil {
+		return fmt.Errorf("writeSnapshotImport: Problem opening chain db (%v): %v", dbDir, err)
+	}
+	defer chainDB.Close()
+
+	snap, err, _ := lib.NewSnapshot(chainDB, dataDir, 0, false, false, params, false, nil)
+	if err != nil {
+		return fmt.Errorf("writeSnapshotImport: Problem opening snapshot: %v", err)
+	}
+	defer snap.SnapshotDb.Close()
+	defer snap.Stop()
+	snap.Migrations.CleanupMigrations(manifest.SnapshotBlockHeight)
+
+	if err := lib.InitDbWithDeSoGenesisBlock(params, chainDB, nil, nil, nil); err != nil {
+		return fmt.Errorf("writeSnapshotImport: Problem initializing chain db: %v", err)
+	}
+	if _, err := lib.DBDeleteAllStateRecords(chainDB); err != nil {
+		return fmt.Errorf("writeSnapshotImport: Problem deleting seed state: %v", err)
+	}
+
+	var chainDBMutex deadlock.RWMutex
+	_, err = readSnapshotFile(inPath, nil, nil, func(chunk *lib.MsgDeSoSnapshotData, manifest *SnapshotManifest) error {
+		return snap.SetSnapshotChunk(chainDB, &chainDBMutex, chunk.SnapshotChunk, manifest.SnapshotBlockHeight)
+	})
+	if err != nil {
+		return fmt.Errorf("writeSnapshotImport: Problem writing state: %v", err)
+	}
+	checksumBytes, err := snap.Checksum.ToBytes()
+	if err != nil {
+		return fmt.Errorf("writeSnapshotImport: Problem computing checksum: %v", err)
+	}
+	if hex.EncodeToString(checksumBytes) != manifest.Checksum {
+		return fmt.Errorf("writeSnapshotImport: Checksum of the written state (%v) doesn't match the manifest "+
+			"checksum (%v)", hex.EncodeToString(checksumBytes), manifest.Checksum)
+	}
+
+	// The block bodies aren't part of the snapshot, so the blocks aren't marked stored, like after hypersync.
+	snapshotBlockHash := blockNodes[len(blockNodes)-1].Hash
+	err = chainDB.Update(func(txn *badger.Txn) error {
+		for _, blockNode := range blockNodes {
+			blockNode.Status = lib.StatusHeaderValidated | lib.StatusBlockProcessed | lib.StatusBlockValidated
+			if err := lib.PutHeightHashToNodeInfoWithTxn(txn, nil, blockNode, false /*bitcoinNodes*/); err != nil {
+				return err
+			}
+		}
+		return lib.PutBestHashWithTxn(txn, nil, snapshotBlockHash, lib.ChainTypeDeSoBlock)
+	})
+	if err != nil {
+		return fmt.Errorf("writeSnapshotImport: Problem writing block nodes: %v", err)
+	}
+
+	err = snap.SetCurrentEpochSnapshotMetadata(&lib.SnapshotEpochMetadata{
+		SnapshotBlockHeight:       manifest.SnapshotBlockHeight,
+		FirstSnapshotBlockHeight:  manifest.SnapshotBlockHeight,
+		CurrentEpochChecksumBytes: checksumBytes,
+		CurrentEpochBlockHash:     snapshotBlockHash,
+	})
+	if err != nil {
+		return fmt.Errorf("writeSnapshotImport: %v", err)
+	}
+	snap.Status.CurrentBlockHeight = manifest.SnapshotBlockHeight
+	snap.Status.SaveStatus()
+	if err := snap.PersistChecksumAndMigration(); err != nil {
+		return fmt.Errorf("writeSnapshotImport: %v", err)
+	}
+	return nil
+}
+
+var snapshotImportCmd = &cobra.Command{
+	Use:   "snapshot-import",
+	Short: "Import a snapshot file into a fresh data directory",
+	Long: `Loads a snapshot file written by snapshot-export into a fresh data directory, after checking it against
+its manifest, so that a node started on the data directory is at the snapshot height as if it had hypersynced.`,
+	Run: SnapshotImport,
+}
+
+func init() {
+	snapshotImportCmd.Flags().String("data-dir", "",
+		"The data directory of the node, as passed to run. When unset, defaults to the system's configuration "+
+			"directory.")
+	snapshotImportCmd.Flags().Bool("testnet", false, "The node runs on the DeSo testnet")
+	snapshotImportCmd.Flags().Bool("regtest", false, "The node runs in regtest, with --testnet")
+	snapshotImportCmd.Flags().String("in", "", "The path of the snapshot file to import.")
+	rootCmd.AddCommand(snapshotImportCmd)
+}
+
+func SnapshotImport(cmd *cobra.Command, args []string) {
+	dataDir := snapshotCommandDataDir(cmd)
+	inPath, _ := cmd.Flags().GetString("in")
+	if inPath == "" {
+		fmt.Fprintln(os.Stderr, "SnapshotImport: --in is required")
+		os.Exit(1)
+	}
+
+	manifest, err := ImportSnapshot(dataDir, inPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Imported snapshot at height (%v) with checksum (%v) into (%v)\n", manifest.SnapshotBlockHeight,
+		manifest.Checksum, dataDir)
+}
@@ -24,9 +24,9 @@ func TestSimpleBlockSync(t *testing.T) {
 	defer os.RemoveAll(dbDir1)
 	defer os.RemoveAll(dbDir2)
 
-	config1 := generateConfig(t, 18000, dbDir1, 10)
+	config1 := generateConfig(t, dbDir1, 10)
 	config1.SyncType = lib.NodeSyncTypeBlockSync
-	config2 := generateConfig(t, 18001, dbDir2, 10)
+	config2 := generateConfig(t, dbDir2, 10)
 	config2.SyncType = lib.NodeSyncTypeBlockSync
 
 	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
@@ -36,18 +36,20 @@ func TestSimpleBlockSync(t *testing.T) {
 
 	node1 = startNode(t, node1)
 	node2 = startNode(t, node2)
+	metrics2 := NewSyncMetrics(t, node2)
 
 	// wait for node1 to sync blocks
-	waitForNodeToFullySync(node1)
+	waitForNodeToFullySync(t, node1)
 
 	// bridge the nodes together.
 	bridge := NewConnectionBridge(node1, node2)
 	require.NoError(bridge.Start())
 
 	// wait for node2 to sync blocks.
-	waitForNodeToFullySync(node2)
+	waitForNodeToFullySync(t, node2)
+	metrics2.AssertSyncFasterThan(defaultSyncTimeout)
 
-	compareNodesByDB(t, node1, node2, 0)
+	compareNodesByDB(t, node1, node2, Summary)
 	fmt.Println("Databases match!")
 	node1.Stop()
 	node2.Stop()
@@ -62,45 +64,25 @@ func TestSimpleBlockSync(t *testing.T) {
 //  6. node2 reconnects with node1 and syncs remaining blocks.
 //  7. compare node1 db matches node2 db.
 func TestSimpleSyncRestart(t *testing.T) {
-	require := require.New(t)
-	_ = require
-
-	dbDir1 := getDirectory(t)
-	dbDir2 := getDirectory(t)
-	defer os.RemoveAll(dbDir1)
-	defer os.RemoveAll(dbDir2)
-
-	config1 := generateConfig(t, 18000, dbDir1, 10)
-	config1.SyncType = lib.NodeSyncTypeBlockSync
-	config2 := generateConfig(t, 18001, dbDir2, 10)
-	config2.SyncType = lib.NodeSyncTypeBlockSync
-
-	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
-
-	node1 := cmd.NewNode(config1)
-	node2 := cmd.NewNode(config2)
-
-	node1 = startNode(t, node1)
-	node2 = startNode(t, node2)
-
-	// wait for node1 to sync blocks
-	waitForNodeToFullySync(node1)
-
-	// bridge the nodes together.
-	bridge := NewConnectionBridge(node1, node2)
-	require.NoError(bridge.Start())
-
-	randomHeight := randomUint32Between(t, 10, config2.MaxSyncBlockHeight)
-	fmt.Println("Random height for a restart (re-use if test failed):", randomHeight)
-	// Reboot node2 at a specific height and reconnect it with node1
-	node2, bridge = restartAtHeightAndReconnectNode(t, node2, node1, bridge, randomHeight)
-	waitForNodeToFullySync(node2)
+	RunSyncScenario(t, SyncScenario{
+		Steps:      []SyncStep{{Trigger: AtRandomHeight(10, 0), Action: Restart}},
+		Assertions: []SyncAssertion{ByDB},
+	})
+}
 
-	compareNodesByDB(t, node1, node2, 0)
-	fmt.Println("Random restart successful! Random height was", randomHeight)
-	fmt.Println("Databases match!")
-	node1.Stop()
-	node2.Stop()
+// TestSimpleSyncCrashRecovery test if a node can recover from crashing while syncing blocks:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator, and serves as the control node.
+//  3. bridge node1 and node2.
+//  4. node2 syncs between 10 and MaxSyncBlockHeight blocks from node1.
+//  5. node2 crashes without a graceful shutdown, and starts again from the same data directory.
+//  6. node2 reconnects with node1, repairs any partial state, and syncs remaining blocks.
+//  7. compare node1 best chain and checksum match node2.
+func TestSimpleSyncCrashRecovery(t *testing.T) {
+	RunSyncScenario(t, SyncScenario{
+		Steps:      []SyncStep{{Trigger: AtRandomHeight(10, 0), Action: Crash}},
+		Assertions: []SyncAssertion{ByBlockIndex, ByChecksum},
+	})
 }
 
 // TestSimpleSyncDisconnectWithSwitchingToNewPeer tests if a node can successfully restart while syncing blocks, and
@@ -124,11 +106,11 @@ func TestSimpleSyncDisconnectWithSwitchingToNewPeer(t *testing.T) {
 	defer os.RemoveAll(dbDir2)
 	defer os.RemoveAll(dbDir3)
 
-	config1 := generateConfig(t, 18000, dbDir1, 10)
+	config1 := generateConfig(t, dbDir1, 10)
 	config1.SyncType = lib.NodeSyncTypeBlockSync
-	config2 := generateConfig(t, 18001, dbDir2, 10)
+	config2 := generateConfig(t, dbDir2, 10)
 	config2.SyncType = lib.NodeSyncTypeBlockSync
-	config3 := generateConfig(t, 18002, dbDir3, 10)
+	config3 := generateConfig(t, dbDir3, 10)
 	config3.SyncType = lib.NodeSyncTypeBlockSync
 
 	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
@@ -143,9 +125,9 @@ func TestSimpleSyncDisconnectWithSwitchingToNewPeer(t *testing.T) {
 	node3 = startNode(t, node3)
 
 	// wait for node1 to sync blocks
-	waitForNodeToFullySync(node1)
+	waitForNodeToFullySync(t, node1)
 	// wait for node3 to sync blocks
-	waitForNodeToFullySync(node3)
+	waitForNodeToFullySync(t, node3)
 
 	// bridge the nodes together.
 	bridge12 := NewConnectionBridge(node1, node2)
@@ -160,14 +142,75 @@ func TestSimpleSyncDisconnectWithSwitchingToNewPeer(t *testing.T) {
 	require.NoError(bridge23.Start())
 
 	// Reboot node2 at a specific height and reconnect it with node1
-	//node2, bridge12 = restartAtHeightAndReconnectNode(t, node2, node1, bridge12, randomHeight)
-	waitForNodeToFullySync(node2)
+	//node2, bridge12 = restartAtHeightAndReconnectNode(t, node2, bridge12, randomHeight)
+	waitForNodeToFullySync(t, node2)
 
-	compareNodesByDB(t, node1, node2, 0)
-	compareNodesByDB(t, node3, node2, 0)
+	compareNodesByDB(t, node1, node2, Summary)
+	compareNodesByDB(t, node3, node2, Summary)
 	fmt.Println("Random restart successful! Random height was", randomHeight)
 	fmt.Println("Databases match!")
 	node1.Stop()
 	node2.Stop()
 	node3.Stop()
 }
+
+// TestBlockSyncRestartWithHeadersOnly test if a node restarted after syncing headers, but before downloading the
+// blocks, resumes the block download:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
+//  3. bridge node1 and node2, blocking all blocks, so that node2 can only sync headers.
+//  4. disconnect node2 once its header tip matches node1, while its block tip is still behind.
+//  5. restart node2, and bridge it with node1 again, this time without blocking blocks.
+//  6. node2 should keep its headers, download the blocks, and end up with the same db as node1.
+func TestBlockSyncRestartWithHeadersOnly(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeBlockSync
+
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+	headerHeight := node1.Server.GetBlockchain().HeaderTip().Height
+
+	// bridge the nodes together, and only let the headers through.
+	bridge := NewConnectionBridge(node1, node2)
+	bridge.BlockMessageType(lib.MsgTypeBlock)
+	require.NoError(bridge.Start())
+
+	// disconnect node2 once it has all headers, but none of the blocks.
+	disconnectAtHeaderHeight(t, node2, bridge, headerHeight)
+	require.Equal(uint32(0), node2.Server.GetBlockchain().BlockTip().Height)
+	require.Greater(bridge.Stats().BlockedMessages[lib.MsgTypeBlock], uint64(0))
+
+	// restart node2, it should still have the headers.
+	node2 = restartNode(t, node2)
+	require.Equal(headerHeight, node2.Server.GetBlockchain().HeaderTip().Height)
+
+	// bridge the nodes together again, this time letting the blocks through.
+	bridge = NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+
+	compareNodesByDB(t, node1, node2, Summary)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
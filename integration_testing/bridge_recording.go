@@ -0,0 +1,383 @@
+package integration_testing
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/golang/glog"
+	"github.com/pkg/errors"
+	"io"
+	"math"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// A bridge recording is a file containing all messages relayed by a ConnectionBridge. The file starts with a header
+// consisting of bridgeRecordingMagic followed by the uvarint format version. The header is followed by a sequence of
+// records, one per relayed message, each encoded as:
+//
+//	<direction uvarint> <outbound byte> <msg type uvarint> <timestamp unix nanos varint> <payload length uvarint> <payload>
+//
+// The file is append-only. Every record is written with a single write, so if recording is interrupted, e.g. because
+// the test crashed, only the last record can be incomplete and it is ignored when the recording is read.
+var bridgeRecordingMagic = []byte("DESOBRIDGE")
+
+// bridgeRecordingVersion is the current version of the recording format. Bump it whenever the record encoding changes.
+const bridgeRecordingVersion = uint64(1)
+
+// RecordedMessage is a single message read from a bridge recording.
+type RecordedMessage struct {
+	// Direction is the direction in which the message was relayed.
+	Direction Direction
+	// Outbound indicates whether the message was delivered over the receiving node's outbound connection, as opposed
+	// to its inbound connection.
+	Outbound bool
+	// MsgType is the type of the message.
+	MsgType lib.MsgType
+	// Timestamp is the time at which the bridge relayed the message.
+	Timestamp time.Time
+	// Payload is the serialized message.
+	Payload []byte
+}
+
+// Message deserializes the recorded payload into a DeSoMessage.
+func (recorded *RecordedMessage) Message() (lib.DeSoMessage, error) {
+	msg := lib.NewMessage(recorded.MsgType)
+	if msg == nil {
+		return nil, fmt.Errorf("RecordedMessage.Message: Unknown message type (%v)", recorded.MsgType)
+	}
+	if err := msg.FromBytes(recorded.Payload); err != nil {
+		return nil, errors.Wrapf(err, "RecordedMessage.Message: Problem decoding message of type (%v)", recorded.MsgType)
+	}
+	return msg, nil
+}
+
+func (recorded *RecordedMessage) toBytes() []byte {
+	var data []byte
+	data = append(data, lib.UintToBuf(uint64(recorded.Direction))...)
+	if recorded.Outbound {
+		data = append(data, 1)
+	} else {
+		data = append(data, 0)
+	}
+	data = append(data, lib.UintToBuf(uint64(recorded.MsgType))...)
+	data = append(data, lib.IntToBuf(recorded.Timestamp.UnixNano())...)
+	data = append(data, lib.UintToBuf(uint64(len(recorded.Payload)))...)
+	data = append(data, recorded.Payload...)
+	return data
+}
+
+func (recorded *RecordedMessage) fromBytes(rr io.Reader) error {
+	direction, err := lib.ReadUvarint(rr)
+	if err != nil {
+		return err
+	}
+	recorded.Direction = Direction(direction)
+
+	outbound := make([]byte, 1)
+	if _, err = io.ReadFull(rr, outbound); err != nil {
+		return err
+	}
+	recorded.Outbound = outbound[0] != 0
+
+	msgType, err := lib.ReadUvarint(rr)
+	if err != nil {
+		return err
+	}
+	recorded.MsgType = lib.MsgType(msgType)
+
+	timestamp, err := lib.ReadVarint(rr)
+	if err != nil {
+		return err
+	}
+	recorded.Timestamp = time.Unix(0, timestamp)
+
+	payloadLen, err := lib.ReadUvarint(rr)
+	if err != nil {
+		return err
+	}
+	if payloadLen > lib.MaxMessagePayload {
+		return fmt.Errorf("payload length (%v) exceeds the maximum message payload", payloadLen)
+	}
+	recorded.Payload = make([]byte, payloadLen)
+	if _, err = io.ReadFull(rr, recorded.Payload); err != nil {
+		return err
+	}
+	return nil
+}
+
+// bridgeRecorder appends relayed messages to a bridge recording.
+type bridgeRecorder struct {
+	mtx  sync.Mutex
+	file *os.File
+}
+
+// newBridgeRecorder opens the recording at the provided path. New recordings are initialized with the header, while
+// existing recordings are appended to, as long as they were written in the current format version.
+func newBridgeRecorder(path string) (*bridgeRecorder, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "newBridgeRecorder: Problem opening recording file (%v)", path)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, errors.Wrapf(err, "newBridgeRecorder: Problem reading recording file (%v)", path)
+	}
+
+	if info.Size() == 0 {
+		header := append([]byte{}, bridgeRecordingMagic...)
+		header = append(header, lib.UintToBuf(bridgeRecordingVersion)...)
+		if _, err = file.Write(header); err != nil {
+			file.Close()
+			return nil, errors.Wrapf(err, "newBridgeRecorder: Problem writing recording header")
+		}
+	} else if err = readBridgeRecordingHeader(bufio.NewReader(file)); err != nil {
+		file.Close()
+		return nil, errors.Wrapf(err, "newBridgeRecorder: Can't append to recording file (%v)", path)
+	}
+	return &bridgeRecorder{file: file}, nil
+}
+
+func (recorder *bridgeRecorder) record(msg lib.DeSoMessage, direction Direction, outbound bool) error {
+	payload, err := msg.ToBytes(false)
+	if err != nil {
+		return errors.Wrapf(err, "bridgeRecorder.record: Problem serializing message of type (%v)", msg.GetMsgType())
+	}
+	recorded := &RecordedMessage{
+		Direction: direction,
+		Outbound:  outbound,
+		MsgType:   msg.GetMsgType(),
+		Timestamp: time.Now(),
+		Payload:   payload,
+	}
+
+	recorder.mtx.Lock()
+	defer recorder.mtx.Unlock()
+	if _, err = recorder.file.Write(recorded.toBytes()); err != nil {
+		return errors.Wrapf(err, "bridgeRecorder.record: Problem writing record")
+	}
+	return nil
+}
+
+func (recorder *bridgeRecorder) close() error {
+	recorder.mtx.Lock()
+	defer recorder.mtx.Unlock()
+	return recorder.file.Close()
+}
+
+func readBridgeRecordingHeader(rr io.Reader) error {
+	magic := make([]byte, len(bridgeRecordingMagic))
+	if _, err := io.ReadFull(rr, magic); err != nil {
+		return errors.Wrapf(err, "readBridgeRecordingHeader: Problem reading magic")
+	}
+	if !bytes.Equal(magic, bridgeRecordingMagic) {
+		return fmt.Errorf("readBridgeRecordingHeader: File is not a bridge recording")
+	}
+	version, err := lib.ReadUvarint(rr)
+	if err != nil {
+		return errors.Wrapf(err, "readBridgeRecordingHeader: Problem reading version")
+	}
+	if version != bridgeRecordingVersion {
+		return fmt.Errorf("readBridgeRecordingHeader: Unsupported recording version (%v), expected (%v)",
+			version, bridgeRecordingVersion)
+	}
+	return nil
+}
+
+// ReadBridgeRecording reads all messages from the bridge recording at the provided path. An incomplete record at the
+// end of the file is ignored.
+func ReadBridgeRecording(path string) ([]*RecordedMessage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ReadBridgeRecording: Problem opening recording file (%v)", path)
+	}
+	defer file.Close()
+
+	rr := bufio.NewReader(file)
+	if err = readBridgeRecordingHeader(rr); err != nil {
+		return nil, err
+	}
+	var messages []*RecordedMessage
+	for {
+		recorded := &RecordedMessage{}
+		if err = recorded.fromBytes(rr); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			}
+			return nil, errors.Wrapf(err, "ReadBridgeRecording: Problem reading record (%v)", len(messages))
+		}
+		messages = append(messages, recorded)
+	}
+	return messages, nil
+}
+
+// ReplayBridge feeds a previously recorded bridge stream into a single node, without a live peer on the other end.
+// The replay bridge connects to the node the same way a ConnectionBridge would, through an inbound and an outbound
+// connection, and delivers the recorded messages over the same kind of connection over which they were originally
+// received, preserving their order and the original gaps between them. Everything the node sends is discarded.
+type ReplayBridge struct {
+	node *cmd.Node
+	// bridge is a helper used to create and negotiate the connections to the node.
+	bridge *ConnectionBridge
+	// messages are the recorded messages that will be replayed, in order.
+	messages []*RecordedMessage
+
+	connectionInbound  *lib.Peer
+	connectionOutbound *lib.Peer
+	outboundListener   net.Listener
+
+	// doneChan is closed once all messages were replayed, or the replay was stopped.
+	doneChan chan struct{}
+	exitChan chan struct{}
+	err      error
+}
+
+// NewReplayBridge creates a ReplayBridge that will feed the node all messages recorded at the provided path that
+// were relayed in the provided direction. For example, to replay the traffic received by nodeB of the recorded
+// bridge, pass DirectionAToB.
+func NewReplayBridge(node *cmd.Node, path string, direction Direction) (*ReplayBridge, error) {
+	recorded, err := ReadBridgeRecording(path)
+	if err != nil {
+		return nil, err
+	}
+	var messages []*RecordedMessage
+	for _, msg := range recorded {
+		if msg.Direction == direction {
+			messages = append(messages, msg)
+		}
+	}
+
+	return &ReplayBridge{
+		node:     node,
+		bridge:   NewConnectionBridge(node, node),
+		messages: messages,
+		doneChan: make(chan struct{}),
+		exitChan: make(chan struct{}),
+	}, nil
+}
+
+// getVersionMessage returns the first recorded version message sent over the provided kind of connection. If the
+// handshake wasn't recorded, it falls back to a version message advertising a full node.
+func (replay *ReplayBridge) getVersionMessage(outbound bool) *lib.MsgDeSoVersion {
+	for _, recorded := range replay.messages {
+		if recorded.MsgType != lib.MsgTypeVersion || recorded.Outbound != outbound {
+			continue
+		}
+		msg, err := recorded.Message()
+		if err != nil {
+			glog.Errorf("ReplayBridge.getVersionMessage: %v", err)
+			break
+		}
+		version := msg.(*lib.MsgDeSoVersion)
+		version.TstampSecs = time.Now().Unix()
+		version.Nonce = uint64(lib.RandInt64(math.MaxInt64))
+		return version
+	}
+
+	version := replay.bridge.getVersionMessage(replay.node)
+	version.Services = lib.SFFullNodeDeprecated | lib.SFHyperSync | lib.SFArchivalNode
+	return version
+}
+
+// Start connects the replay bridge to the node and starts replaying the recorded messages.
+func (replay *ReplayBridge) Start() error {
+	var err error
+	replay.outboundListener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+
+	if replay.connectionInbound, err = replay.bridge.createInboundConnection(replay.node); err != nil {
+		return err
+	}
+	if err = replay.bridge.startConnectionWithVersion(
+		replay.connectionInbound, replay.getVersionMessage(false)); err != nil {
+		return err
+	}
+	replay.bridge.createOutboundConnection(replay.node, replay.node, replay.outboundListener)
+	if replay.connectionOutbound, err = replay.bridge.waitForConnection(); err != nil {
+		return err
+	}
+	if err = replay.bridge.startConnectionWithVersion(
+		replay.connectionOutbound, replay.getVersionMessage(true)); err != nil {
+		return err
+	}
+
+	go replay.discardTraffic(replay.connectionInbound)
+	go replay.discardTraffic(replay.connectionOutbound)
+	go replay.replayTraffic()
+	return nil
+}
+
+// discardTraffic reads and ignores all messages sent by the node over the provided connection.
+func (replay *ReplayBridge) discardTraffic(connection *lib.Peer) {
+	for {
+		if _, err := connection.ReadDeSoMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// replayTraffic writes the recorded messages to the node, keeping the original time gaps between them.
+func (replay *ReplayBridge) replayTraffic() {
+	defer close(replay.doneChan)
+
+	var startTime, firstTimestamp time.Time
+	for _, recorded := range replay.messages {
+		// The handshake was already performed while starting the replay bridge.
+		if recorded.MsgType == lib.MsgTypeVersion || recorded.MsgType == lib.MsgTypeVerack {
+			continue
+		}
+		if startTime.IsZero() {
+			startTime = time.Now()
+			firstTimestamp = recorded.Timestamp
+		}
+		if delay := time.Until(startTime.Add(recorded.Timestamp.Sub(firstTimestamp))); delay > 0 {
+			select {
+			case <-replay.exitChan:
+				return
+			case <-time.After(delay):
+			}
+		}
+
+		msg, err := recorded.Message()
+		if err != nil {
+			replay.err = err
+			return
+		}
+		connection := replay.connectionInbound
+		if recorded.Outbound {
+			connection = replay.connectionOutbound
+		}
+		if err = connection.WriteDeSoMessage(msg); err != nil {
+			replay.err = errors.Wrapf(err, "ReplayBridge.replayTraffic: Problem writing message of type (%v)",
+				recorded.MsgType)
+			return
+		}
+	}
+}
+
+// Wait blocks until all recorded messages were replayed and returns the error that interrupted the replay, if any.
+func (replay *ReplayBridge) Wait() error {
+	<-replay.doneChan
+	return replay.err
+}
+
+// Disconnect stops the replay and closes the connections to the node.
+func (replay *ReplayBridge) Disconnect() {
+	close(replay.exitChan)
+	if replay.connectionInbound != nil {
+		replay.connectionInbound.Disconnect()
+	}
+	if replay.connectionOutbound != nil {
+		replay.connectionOutbound.Disconnect()
+	}
+	if replay.outboundListener != nil {
+		replay.outboundListener.Close()
+	}
+}
@@ -0,0 +1,159 @@
+package integration_testing
+
+import (
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"sync"
+)
+
+// ByzantineBehavior is a scripted misbehavior of a ByzantinePeer.
+type ByzantineBehavior uint8
+
+const (
+	// ByzantineFakeTip makes the peer advertise a chain tip it doesn't have, both in its version message and in the
+	// header bundles it serves. The fake tip height is set with ByzantinePeer.SetFakeTipHeight.
+	ByzantineFakeTip ByzantineBehavior = iota
+	// ByzantineWithholdBlocks makes the peer serve headers, but never send the blocks backing them.
+	ByzantineWithholdBlocks
+	// ByzantineInvalidProofOfWork makes the peer send blocks whose headers don't satisfy the proof of work.
+	ByzantineInvalidProofOfWork
+	// ByzantineWrongSnapshotChecksums makes the peer send snapshot chunks with tampered records, so that the
+	// state checksum computed from the chunks doesn't match the snapshot's checksum.
+	ByzantineWrongSnapshotChecksums
+)
+
+// byzantineFakeTipOffset is how many blocks past the source node's tip the peer claims to be, unless the fake
+// tip height is set explicitly.
+const byzantineFakeTipOffset = 1000
+
+// ByzantinePeer is a peer that speaks the DeSo wire protocol, but can be scripted to misbehave. The peer serves the
+// data of an honest source node to the target node over a ConnectionBridge, and tampers with the relayed messages
+// according to its behaviors. This allows testing that the target node rejects the peer's lies, e.g. by connecting
+// it to a ByzantinePeer and an honest node, and checking that it ends up on the honest chain.
+type ByzantinePeer struct {
+	target *cmd.Node
+	source *cmd.Node
+	bridge *ConnectionBridge
+
+	mtx           sync.RWMutex
+	behaviors     map[ByzantineBehavior]bool
+	fakeTipHeight uint32
+}
+
+// NewByzantinePeer creates a ByzantinePeer that will connect to the target node, serving the source node's data
+// with the provided behaviors. This function is usually followed by ByzantinePeer.Start().
+func NewByzantinePeer(target *cmd.Node, source *cmd.Node, behaviors ...ByzantineBehavior) *ByzantinePeer {
+	peer := &ByzantinePeer{
+		target:    target,
+		source:    source,
+		bridge:    NewConnectionBridge(source, target),
+		behaviors: make(map[ByzantineBehavior]bool),
+	}
+	for _, behavior := range behaviors {
+		peer.behaviors[behavior] = true
+	}
+	peer.bridge.versionHook = peer.tamperVersion
+	peer.bridge.SetMessageHook(peer.tamperMessage)
+	return peer
+}
+
+// SetFakeTipHeight sets the tip height advertised with ByzantineFakeTip.
+func (peer *ByzantinePeer) SetFakeTipHeight(height uint32) {
+	peer.mtx.Lock()
+	defer peer.mtx.Unlock()
+	peer.fakeTipHeight = height
+}
+
+// EnableBehavior makes the peer start misbehaving in the provided way. It is safe to call while the peer is running.
+func (peer *ByzantinePeer) EnableBehavior(behavior ByzantineBehavior) {
+	peer.mtx.Lock()
+	defer peer.mtx.Unlock()
+	peer.behaviors[behavior] = true
+}
+
+// DisableBehavior makes the peer stop misbehaving in the provided way. It is safe to call while the peer is running.
+func (peer *ByzantinePeer) DisableBehavior(behavior ByzantineBehavior) {
+	peer.mtx.Lock()
+	defer peer.mtx.Unlock()
+	delete(peer.behaviors, behavior)
+}
+
+func (peer *ByzantinePeer) hasBehavior(behavior ByzantineBehavior) bool {
+	peer.mtx.RLock()
+	defer peer.mtx.RUnlock()
+	return peer.behaviors[behavior]
+}
+
+// getFakeTipHeight returns the tip height the peer should advertise.
+func (peer *ByzantinePeer) getFakeTipHeight() uint32 {
+	peer.mtx.RLock()
+	defer peer.mtx.RUnlock()
+	if peer.fakeTipHeight != 0 {
+		return peer.fakeTipHeight
+	}
+	return uint32(peer.source.Server.GetBlockchain().BlockTip().Height) + byzantineFakeTipOffset
+}
+
+// tamperVersion modifies the version message the peer introduces itself with to the target node.
+func (peer *ByzantinePeer) tamperVersion(version *lib.MsgDeSoVersion, direction Direction) {
+	if direction != DirectionAToB {
+		return
+	}
+	if peer.hasBehavior(ByzantineFakeTip) {
+		version.StartBlockHeight = peer.getFakeTipHeight()
+	}
+}
+
+// tamperMessage modifies the messages sent to the target node according to the peer's behaviors.
+func (peer *ByzantinePeer) tamperMessage(msg lib.DeSoMessage, direction Direction) (lib.DeSoMessage, bool) {
+	if direction != DirectionAToB {
+		return msg, true
+	}
+
+	switch typedMsg := msg.(type) {
+	case *lib.MsgDeSoHeaderBundle:
+		if peer.hasBehavior(ByzantineFakeTip) {
+			typedMsg.TipHeight = peer.getFakeTipHeight()
+		}
+	case *lib.MsgDeSoBlock:
+		if peer.hasBehavior(ByzantineWithholdBlocks) {
+			return nil, false
+		}
+		if peer.hasBehavior(ByzantineInvalidProofOfWork) {
+			// Changing the nonces makes the header hash essentially random, which won't meet the difficulty target.
+			typedMsg.Header.Nonce++
+			typedMsg.Header.ExtraNonce++
+		}
+	case *lib.MsgDeSoSnapshotData:
+		if peer.hasBehavior(ByzantineWrongSnapshotChecksums) {
+			for _, entry := range typedMsg.SnapshotChunk {
+				if len(entry.Value) > 0 {
+					entry.Value[len(entry.Value)-1] ^= 0xff
+					break
+				}
+			}
+		}
+	}
+	return msg, true
+}
+
+// Start connects the peer to the target node.
+func (peer *ByzantinePeer) Start() error {
+	return peer.bridge.Start()
+}
+
+// Disconnect disconnects the peer from the target node.
+func (peer *ByzantinePeer) Disconnect() {
+	peer.bridge.Disconnect()
+}
+
+// Bridge returns the bridge over which the peer talks to the target node, which can be used to further degrade the
+// connection or to inspect its Stats().
+func (peer *ByzantinePeer) Bridge() *ConnectionBridge {
+	return peer.bridge
+}
+
+// WasDisconnected returns true if the target node dropped the connection to the peer at least once.
+func (peer *ByzantinePeer) WasDisconnected() bool {
+	return peer.bridge.Stats().LinkFailures > 0
+}
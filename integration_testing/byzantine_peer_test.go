@@ -0,0 +1,70 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestBlockSyncByzantinePeer test if a node rejects a lying peer and syncs from an honest one:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
+//  3. connect node2 to a byzantine peer that advertises a fake tip and serves blocks with invalid proofs of work.
+//  4. wait for node2 to disconnect the byzantine peer.
+//  5. bridge node1 and node2, and node2 syncs MaxSyncBlockHeight blocks from node1.
+//  6. once done, compare node1 checksum matches node2.
+func TestBlockSyncByzantinePeer(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeBlockSync
+
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// connect node2 to the liar first, so that it's picked as the sync peer.
+	liar := NewByzantinePeer(node2, node1, ByzantineFakeTip, ByzantineInvalidProofOfWork)
+	require.NoError(liar.Start())
+	deadline := time.After(2 * time.Minute)
+	for !liar.WasDisconnected() {
+		select {
+		case <-deadline:
+			t.Fatalf("node2 didn't disconnect the byzantine peer")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	liar.Disconnect()
+
+	// bridge node2 with the honest node1.
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+	require.Equal(node1.Server.GetBlockchain().BlockTip().Height, node2.Server.GetBlockchain().BlockTip().Height)
+
+	compareNodesByChecksum(t, node1, node2)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
@@ -0,0 +1,309 @@
+package integration_testing
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A chain fixture is an archived data directory of a node, i.e. its blocks and state, so that tests can start nodes
+// that are already at the fixture height, instead of syncing the chain from a source node first. The archive is a
+// gzipped tar with the chainFixtureManifestName manifest first, followed by the files of the data directory under
+// chainFixtureDataDir.
+const (
+	chainFixtureManifestName = "fixture.json"
+	chainFixtureDataDir      = "data"
+)
+
+// chainFixtureVersion is the version of the chain fixture format. Bump it whenever the format changes.
+const chainFixtureVersion = 1
+
+// chainFixtureManifest describes the node that a fixture was saved from. A fixture can only be used by nodes with the
+// same network and encoder migrations, since the data directory would otherwise be read with a different schema.
+type chainFixtureManifest struct {
+	Version             int
+	NetworkType         lib.NetworkType
+	GenesisBlockHashHex string
+	Regtest             bool
+	EncoderMigrations   []lib.MigrationHeight
+	BlockHeight         uint32
+	HyperSync           bool
+	SyncType            lib.NodeSyncType
+	TXIndex             bool
+}
+
+// newChainFixtureManifest describes a node with the config, whose block tip is at blockHeight.
+func newChainFixtureManifest(config *cmd.Config, blockHeight uint32) *chainFixtureManifest {
+	params := nodeParams(config)
+	manifest := &chainFixtureManifest{
+		Version:             chainFixtureVersion,
+		NetworkType:         params.NetworkType,
+		GenesisBlockHashHex: params.GenesisBlockHashHex,
+		Regtest:             config.Regtest,
+		BlockHeight:         blockHeight,
+		HyperSync:           config.HyperSync,
+		SyncType:            config.SyncType,
+		TXIndex:             config.TXIndex,
+	}
+	for _, migration := range params.EncoderMigrationHeightsList {
+		manifest.EncoderMigrations = append(manifest.EncoderMigrations, *migration)
+	}
+	return manifest
+}
+
+// nodeParams returns the params that a node with the config runs with, which differ from config.Params in regtest.
+func nodeParams(config *cmd.Config) *lib.DeSoParams {
+	params := config.Params.Copy()
+	if config.Regtest {
+		params.EnableRegtest()
+	}
+	return params
+}
+
+// checkChainFixtureManifest returns an error if a node with the config can't use the fixture described by manifest.
+func checkChainFixtureManifest(manifest *chainFixtureManifest, config *cmd.Config) error {
+	if manifest.Version != chainFixtureVersion {
+		return fmt.Errorf("checkChainFixtureManifest: Fixture has version (%v), expected (%v), regenerate it",
+			manifest.Version, chainFixtureVersion)
+	}
+	expected := newChainFixtureManifest(config, manifest.BlockHeight)
+	if manifest.NetworkType != expected.NetworkType || manifest.GenesisBlockHashHex != expected.GenesisBlockHashHex ||
+		manifest.Regtest != expected.Regtest {
+		return fmt.Errorf("checkChainFixtureManifest: Fixture is for network (%v) with genesis (%v) and regtest "+
+			"(%v), but the node runs on network (%v) with genesis (%v) and regtest (%v)", manifest.NetworkType,
+			manifest.GenesisBlockHashHex, manifest.Regtest, expected.NetworkType, expected.GenesisBlockHashHex,
+			expected.Regtest)
+	}
+	if !reflect.DeepEqual(manifest.EncoderMigrations, expected.EncoderMigrations) {
+		return fmt.Errorf("checkChainFixtureManifest: Encoder migrations changed since the fixture was saved, "+
+			"regenerate it; fixture migrations (%v), current migrations (%v)", manifest.EncoderMigrations,
+			expected.EncoderMigrations)
+	}
+	return nil
+}
+
+// saveChainFixture archives the node's data directory into a chain fixture at path. Badger's files can only be copied
+// consistently while the db is closed, so a running node is stopped first. The returned node isn't running, and
+// starting it reopens the node's data directory, like the node returned by shutdownNode. In-memory nodes have no data
+// directory to archive.
+func saveChainFixture(t testing.TB, node *cmd.Node, path string) *cmd.Node {
+	if node.Config.InMemory {
+		t.Fatalf("saveChainFixture: Can't save a fixture of an in-memory node")
+	}
+	blockHeight := node.Server.GetBlockchain().BlockTip().Height
+	if node.IsRunning() {
+		node = shutdownNode(t, node)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatalf("saveChainFixture: Problem creating directory for (%v): %v", path, err)
+	}
+	// Write to a temporary file first, so that a failed save doesn't leave a truncated fixture behind.
+	tempPath := path + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		t.Fatalf("saveChainFixture: Problem creating file (%v): %v", tempPath, err)
+	}
+	defer os.Remove(tempPath)
+
+	manifest := newChainFixtureManifest(node.Config, blockHeight)
+	if err := writeChainFixture(file, manifest, node.Config.DataDirectory); err != nil {
+		file.Close()
+		t.Fatalf("saveChainFixture: Problem writing fixture to (%v): %v", tempPath, err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("saveChainFixture: Problem closing file (%v): %v", tempPath, err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		t.Fatalf("saveChainFixture: Problem renaming (%v) to (%v): %v", tempPath, path, err)
+	}
+	fmt.Printf("Saved chain fixture at height (%v) to (%v)\n", blockHeight, path)
+	return node
+}
+
+func writeChainFixture(writer io.Writer, manifest *chainFixtureManifest, dataDir string) error {
+	gzipWriter := gzip.NewWriter(writer)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	manifestBytes, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "writeChainFixture: Problem encoding manifest")
+	}
+	if err := tarWriter.WriteHeader(&tar.Header{
+		Name: chainFixtureManifestName,
+		Mode: 0644,
+		Size: int64(len(manifestBytes)),
+	}); err != nil {
+		return errors.Wrapf(err, "writeChainFixture: Problem writing manifest header")
+	}
+	if _, err := tarWriter.Write(manifestBytes); err != nil {
+		return errors.Wrapf(err, "writeChainFixture: Problem writing manifest")
+	}
+
+	err = filepath.Walk(dataDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dataDir, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join(chainFixtureDataDir, relPath))
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(tarWriter, file)
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "writeChainFixture: Problem archiving data directory (%v)", dataDir)
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return errors.Wrapf(err, "writeChainFixture: Problem closing tar")
+	}
+	return gzipWriter.Close()
+}
+
+// startNodeFromFixture unpacks the chain fixture at path into a fresh temporary data directory, and starts a node on
+// it, which is then already at the fixture height. The node's config is the default config from generateConfig, with
+// the network and sync settings of the node that saved the fixture, and then modified by configMutations. The test
+// fails if the fixture doesn't match the node's network or encoder migrations, e.g. because it's stale. The data
+// directory is removed when the test finishes.
+func startNodeFromFixture(t testing.TB, path string, configMutations ...func(config *cmd.Config)) *cmd.Node {
+	dataDir := getDirectory(t)
+	// Cleanups run in reverse order, so the directory is removed after the node is stopped.
+	t.Cleanup(func() {
+		os.RemoveAll(dataDir)
+	})
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("startNodeFromFixture: Problem opening fixture (%v): %v", path, err)
+	}
+	defer file.Close()
+	manifest, err := readChainFixture(file, dataDir)
+	if err != nil {
+		t.Fatalf("startNodeFromFixture: Problem reading fixture (%v): %v", path, err)
+	}
+
+	var config *cmd.Config
+	switch {
+	case manifest.Regtest:
+		config = generateRegtestConfig(t, dataDir, 10)
+	case manifest.NetworkType == lib.NetworkType_TESTNET:
+		config = newTestConfig(t, dataDir, 0, WithParams(&lib.DeSoTestnetParams))
+	default:
+		config = generateConfig(t, dataDir, 10)
+	}
+	config.HyperSync = manifest.HyperSync
+	config.SyncType = manifest.SyncType
+	config.TXIndex = manifest.TXIndex
+	for _, mutate := range configMutations {
+		mutate(config)
+	}
+	if config.DataDirectory != dataDir {
+		t.Fatalf("startNodeFromFixture: DataDirectory can't be changed")
+	}
+	if config.InMemory {
+		t.Fatalf("startNodeFromFixture: The node can't be in memory, since it's started from its data directory")
+	}
+	if err := checkChainFixtureManifest(manifest, config); err != nil {
+		t.Fatalf("startNodeFromFixture: Fixture (%v) can't be used: %v", path, err)
+	}
+
+	node := startNode(t, cmd.NewNode(config))
+	if height := node.Server.GetBlockchain().BlockTip().Height; height != manifest.BlockHeight {
+		t.Fatalf("startNodeFromFixture: Node started at height (%v), but fixture (%v) was saved at height (%v)",
+			height, path, manifest.BlockHeight)
+	}
+	return node
+}
+
+// readChainFixture unpacks the data directory of the fixture into dataDir, and returns the fixture's manifest.
+func readChainFixture(reader io.Reader, dataDir string) (*chainFixtureManifest, error) {
+	gzipReader, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "readChainFixture: Problem opening gzip stream")
+	}
+	defer gzipReader.Close()
+	tarReader := tar.NewReader(gzipReader)
+
+	header, err := tarReader.Next()
+	if err != nil || header.Name != chainFixtureManifestName {
+		return nil, fmt.Errorf("readChainFixture: Not a chain fixture, the manifest is missing")
+	}
+	manifest := &chainFixtureManifest{}
+	if err := json.NewDecoder(tarReader).Decode(manifest); err != nil {
+		return nil, errors.Wrapf(err, "readChainFixture: Problem decoding manifest")
+	}
+
+	dataPrefix := chainFixtureDataDir + "/"
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "readChainFixture: Problem reading archive")
+		}
+		if header.Name != chainFixtureDataDir && !strings.HasPrefix(header.Name, dataPrefix) {
+			return nil, fmt.Errorf("readChainFixture: Unexpected file (%v) in archive", header.Name)
+		}
+		relPath := filepath.FromSlash(strings.TrimPrefix(header.Name, chainFixtureDataDir))
+		target := filepath.Join(dataDir, relPath)
+		// Reject paths like data/../../x, which would be unpacked outside of the data directory.
+		if target != dataDir && !strings.HasPrefix(target, filepath.Clean(dataDir)+string(os.PathSeparator)) {
+			return nil, fmt.Errorf("readChainFixture: File (%v) is outside of the data directory", header.Name)
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.ModePerm); err != nil {
+				return nil, errors.Wrapf(err, "readChainFixture: Problem creating directory (%v)", target)
+			}
+		case tar.TypeReg:
+			if err := unpackChainFixtureFile(tarReader, target, os.FileMode(header.Mode)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return manifest, nil
+}
+
+func unpackChainFixtureFile(reader io.Reader, target string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+		return errors.Wrapf(err, "unpackChainFixtureFile: Problem creating directory for (%v)", target)
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return errors.Wrapf(err, "unpackChainFixtureFile: Problem creating file (%v)", target)
+	}
+	defer file.Close()
+	if _, err := io.Copy(file, reader); err != nil {
+		return errors.Wrapf(err, "unpackChainFixtureFile: Problem writing file (%v)", target)
+	}
+	return nil
+}
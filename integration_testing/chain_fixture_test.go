@@ -0,0 +1,67 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRegtestChainFixture test if a node started from a chain fixture has the chain of the node that saved it:
+//  1. Spawn a regtest node node1, and mine a few blocks on it.
+//  2. save node1's chain to a fixture.
+//  3. start node2 from the fixture, which should be at node1's block height without syncing.
+//  4. compare node1 and node2 dbs match.
+func TestRegtestChainFixture(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+
+	node1 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir1, 10)))
+	mineBlocks(t, node1, 5)
+	height := node1.Server.GetBlockchain().BlockTip().Height
+
+	fixturePath := filepath.Join(getDirectory(t), "regtest.fixture")
+	defer os.RemoveAll(filepath.Dir(fixturePath))
+	node1 = startNode(t, saveChainFixture(t, node1, fixturePath))
+
+	node2 := startNodeFromFixture(t, fixturePath)
+	require.Equal(height, node2.Server.GetBlockchain().BlockTip().Height)
+	require.Equal(*node1.Server.GetBlockchain().BlockTip().Hash, *node2.Server.GetBlockchain().BlockTip().Hash)
+
+	compareNodesByDB(t, node1, node2, Summary)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestChainFixtureManifestMismatch test if fixtures saved with other params are rejected.
+func TestChainFixtureManifestMismatch(t *testing.T) {
+	require := require.New(t)
+
+	dbDir := getDirectory(t)
+	defer os.RemoveAll(dbDir)
+	config := generateRegtestConfig(t, dbDir, 10)
+	manifest := newChainFixtureManifest(config, 10)
+	require.NoError(checkChainFixtureManifest(manifest, config))
+
+	// A fixture from a mainnet node can't be used in regtest.
+	mainnetManifest := newChainFixtureManifest(generateConfig(t, dbDir, 10), 10)
+	require.Error(checkChainFixtureManifest(mainnetManifest, config))
+
+	// A fixture saved before an encoder migration changed is stale.
+	staleManifest := newChainFixtureManifest(config, 10)
+	staleManifest.EncoderMigrations = append([]lib.MigrationHeight{}, staleManifest.EncoderMigrations...)
+	staleManifest.EncoderMigrations[len(staleManifest.EncoderMigrations)-1].Version++
+	require.Error(checkChainFixtureManifest(staleManifest, config))
+
+	// So is a fixture in an older format.
+	oldManifest := newChainFixtureManifest(config, 10)
+	oldManifest.Version = chainFixtureVersion - 1
+	require.Error(checkChainFixtureManifest(oldManifest, config))
+}
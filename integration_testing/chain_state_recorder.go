@@ -0,0 +1,109 @@
+package integration_testing
+
+import (
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"sync"
+	"testing"
+)
+
+// ChainStateRecorder records the ordered sequence of chain states a node goes through, e.g. syncing headers, syncing
+// the snapshot, syncing blocks, and finally fully current. Many sync bugs live at the transitions between states, so
+// tests can use the recorder to assert that a node went through the expected states, and never went back to syncing
+// once it became fully current. The recorder follows the node's chain state events, so it sees even short-lived
+// states. Consecutive duplicates are collapsed, i.e. each recorded state differs from the previous one.
+type ChainStateRecorder struct {
+	mtx    sync.Mutex
+	states []lib.SyncState
+
+	unsubscribe func()
+	done        chan struct{}
+	stopOnce    sync.Once
+}
+
+// recordChainStates starts recording the node's chain states. The recorder is stopped when the test finishes.
+func recordChainStates(t testing.TB, node *cmd.Node) *ChainStateRecorder {
+	events, unsubscribe := node.Subscribe(cmd.EventTypeSyncStateChanged)
+	recorder := &ChainStateRecorder{
+		unsubscribe: unsubscribe,
+		done:        make(chan struct{}),
+	}
+	// Record the initial state after subscribing, so that no change is missed in between.
+	recorder.record(node.Status().ChainState)
+	go func() {
+		for {
+			select {
+			case <-recorder.done:
+				return
+			case event := <-events:
+				recorder.record(event.NewState)
+			}
+		}
+	}()
+	t.Cleanup(recorder.Stop)
+	return recorder
+}
+
+func (recorder *ChainStateRecorder) record(state lib.SyncState) {
+	recorder.mtx.Lock()
+	defer recorder.mtx.Unlock()
+	if len(recorder.states) > 0 && recorder.states[len(recorder.states)-1] == state {
+		return
+	}
+	recorder.states = append(recorder.states, state)
+}
+
+// Stop stops recording. It is safe to call Stop more than once.
+func (recorder *ChainStateRecorder) Stop() {
+	recorder.stopOnce.Do(func() {
+		close(recorder.done)
+		recorder.unsubscribe()
+	})
+}
+
+// States returns the states recorded so far, in the order in which the node went through them.
+func (recorder *ChainStateRecorder) States() []lib.SyncState {
+	recorder.mtx.Lock()
+	defer recorder.mtx.Unlock()
+	return append([]lib.SyncState{}, recorder.states...)
+}
+
+// AssertSequence fails the test unless the expected states were recorded in the provided order. Other states can be
+// recorded in between the expected ones.
+func (recorder *ChainStateRecorder) AssertSequence(t testing.TB, expected ...lib.SyncState) {
+	states := recorder.States()
+	next := 0
+	for _, state := range states {
+		if next < len(expected) && state == expected[next] {
+			next++
+		}
+	}
+	if next < len(expected) {
+		t.Fatalf("AssertSequence: Expected states (%v) in order, but state (%v) wasn't seen in time; recorded "+
+			"states (%v)", expected, expected[next], states)
+	}
+}
+
+// AssertNoSyncingAfter fails the test if the node went back to one of the syncing states, i.e. syncing headers, the
+// snapshot, blocks, or historical blocks, after it first reached the provided state. Moving between fully current and
+// needing blocks is fine, since that's how a current node processes new blocks.
+func (recorder *ChainStateRecorder) AssertNoSyncingAfter(t testing.TB, state lib.SyncState) {
+	states := recorder.States()
+	reached := false
+	for _, recordedState := range states {
+		if recordedState == state {
+			reached = true
+			continue
+		}
+		if reached && isSyncingState(recordedState) {
+			t.Fatalf("AssertNoSyncingAfter: Node went back to state (%v) after reaching state (%v); recorded "+
+				"states (%v)", recordedState, state, states)
+		}
+	}
+}
+
+// isSyncingState returns true if the node is still catching up with the network in the provided state.
+func isSyncingState(state lib.SyncState) bool {
+	return state == lib.SyncStateSyncingHeaders || state == lib.SyncStateSyncingSnapshot ||
+		state == lib.SyncStateSyncingBlocks || state == lib.SyncStateSyncingHistoricalBlocks
+}
@@ -0,0 +1,244 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// ChaosConfig configures the faults injected by a ChaosRunner. The fault probabilities are relative weights, e.g.
+// RestartWeight 1 and DisconnectWeight 3 make every fault a disconnect with a 75% chance. A zero weight disables the
+// fault.
+type ChaosConfig struct {
+	// Duration is how long faults are injected for.
+	Duration time.Duration
+	// MinInterval and MaxInterval bound the random wait between consecutive faults.
+	MinInterval time.Duration
+	MaxInterval time.Duration
+
+	// RestartWeight is the weight of restarting a random node.
+	RestartWeight float64
+	// DisconnectWeight is the weight of disconnecting a random bridge, or reconnecting it if it's disconnected.
+	DisconnectWeight float64
+	// PauseWeight is the weight of pausing a random bridge, or resuming it if it's paused.
+	PauseWeight float64
+
+	// FaultLogLevel, if positive, is the log verbosity of all modules that's set on the nodes a fault is about to be
+	// injected into, i.e. the restarted node, or both ends of the bridge, so that their logs cover the fault. It
+	// stays set for the rest of the run, see cmd.Node.SetLogLevel.
+	FaultLogLevel int
+
+	// Seed seeds the choice of faults, so that a run can be replayed. A zero Seed derives the seed from the test's
+	// TestRand, so the run can also be replayed with the test's seed.
+	Seed int64
+}
+
+// ChaosRunner injects random faults into a network of nodes bridged together, i.e. it restarts nodes, disconnects
+// bridges and pauses them, and then checks that the nodes still end up with the same state. It generalizes tests that
+// restart or disconnect a node at a specific height into a soak test that can be run with different seeds. The seed is
+// printed at the start of every run, and the same seed produces the same sequence of faults. The timing of the faults
+// relative to the sync isn't deterministic though, so a replay isn't guaranteed to hit the exact same state.
+type ChaosRunner struct {
+	t       testing.TB
+	nodes   []*cmd.Node
+	bridges []*ConnectionBridge
+	config  ChaosConfig
+
+	seed int64
+	rng  *rand.Rand
+	// disconnected and paused are the bridges that the runner disconnected or paused, and has to heal at the end.
+	disconnected map[*ConnectionBridge]bool
+	paused       map[*ConnectionBridge]bool
+}
+
+// NewChaosRunner creates a ChaosRunner for the nodes and the bridges between them.
+func NewChaosRunner(t testing.TB, nodes []*cmd.Node, bridges []*ConnectionBridge, config ChaosConfig) *ChaosRunner {
+	seed := config.Seed
+	if seed == 0 {
+		seed = getTestRand(t).Int63()
+	}
+	if config.MaxInterval < config.MinInterval {
+		config.MaxInterval = config.MinInterval
+	}
+
+	return &ChaosRunner{
+		t:            t,
+		nodes:        append([]*cmd.Node{}, nodes...),
+		bridges:      bridges,
+		config:       config,
+		seed:         seed,
+		rng:          rand.New(rand.NewSource(seed)),
+		disconnected: make(map[*ConnectionBridge]bool),
+		paused:       make(map[*ConnectionBridge]bool),
+	}
+}
+
+// Nodes returns the current nodes. Replaced nodes keep their position.
+func (runner *ChaosRunner) Nodes() []*cmd.Node {
+	return runner.nodes
+}
+
+// Seed returns the seed of the run.
+func (runner *ChaosRunner) Seed() int64 {
+	return runner.seed
+}
+
+// Run injects faults for the configured duration. Once done, it heals all the bridges, waits for the nodes to fully
+// sync, and compares the databases of every pair of nodes.
+func (runner *ChaosRunner) Run() {
+	fmt.Printf("ChaosRunner: Running with seed (%v)\n", runner.seed)
+	runner.t.Logf("ChaosRunner: seed (%v)", runner.seed)
+
+	deadline := time.Now().Add(runner.config.Duration)
+	numFaults := 0
+	for {
+		interval := runner.config.MinInterval
+		if spread := runner.config.MaxInterval - runner.config.MinInterval; spread > 0 {
+			interval += time.Duration(runner.rng.Int63n(int64(spread)))
+		}
+		if time.Now().Add(interval).After(deadline) {
+			break
+		}
+		time.Sleep(interval)
+		runner.injectFault()
+		numFaults++
+	}
+	fmt.Printf("ChaosRunner: Injected (%v) faults, healing the network\n", numFaults)
+
+	runner.heal()
+	for _, node := range runner.nodes {
+		waitForNodeToFullySync(runner.t, node)
+	}
+	waitForNodesToConverge(runner.t, runner.nodes, defaultSyncTimeout)
+	for ii := 0; ii < len(runner.nodes); ii++ {
+		for jj := ii + 1; jj < len(runner.nodes); jj++ {
+			compareNodesByDB(runner.t, runner.nodes[ii], runner.nodes[jj], Summary)
+		}
+	}
+	fmt.Printf("ChaosRunner: Databases match! Seed was (%v)\n", runner.seed)
+}
+
+// injectFault picks a random fault according to the configured weights, and injects it.
+func (runner *ChaosRunner) injectFault() {
+	totalWeight := runner.config.RestartWeight + runner.config.DisconnectWeight + runner.config.PauseWeight
+	if totalWeight <= 0 {
+		return
+	}
+
+	roll := runner.rng.Float64() * totalWeight
+	switch {
+	case roll < runner.config.RestartWeight:
+		runner.restartRandomNode()
+	case roll < runner.config.RestartWeight+runner.config.DisconnectWeight:
+		runner.toggleRandomDisconnect()
+	default:
+		runner.toggleRandomPause()
+	}
+}
+
+// restartRandomNode restarts a random node, and reconnects all of its bridges that the runner didn't disconnect.
+func (runner *ChaosRunner) restartRandomNode() {
+	index := runner.rng.Intn(len(runner.nodes))
+	node := runner.nodes[index]
+	fmt.Printf("ChaosRunner: Restarting node on port (%v)\n", node.ListeningPort())
+	runner.raiseLogLevel(node)
+
+	var nodeBridges []*ConnectionBridge
+	for _, bridge := range runner.bridges {
+		bridge.mtx.RLock()
+		isEnd := bridge.nodeA == node || bridge.nodeB == node
+		bridge.mtx.RUnlock()
+		if isEnd {
+			nodeBridges = append(nodeBridges, bridge)
+		}
+	}
+
+	// Tear down the bridges for the duration of the restart, so that they don't connect to the stopping node.
+	for _, bridge := range nodeBridges {
+		bridge.SetAutoReconnect(0, 0)
+		if !runner.disconnected[bridge] {
+			bridge.Disconnect()
+		}
+	}
+	newNode := restartNode(runner.t, node)
+	runner.nodes[index] = newNode
+	for _, bridge := range nodeBridges {
+		require.NoError(runner.t, bridge.ReplaceNode(node, newNode))
+		if !runner.disconnected[bridge] {
+			require.NoError(runner.t, bridge.Start())
+		}
+	}
+}
+
+// toggleRandomDisconnect disconnects a random bridge, or reconnects it if it's disconnected.
+func (runner *ChaosRunner) toggleRandomDisconnect() {
+	if len(runner.bridges) == 0 {
+		return
+	}
+	bridge := runner.bridges[runner.rng.Intn(len(runner.bridges))]
+	if runner.disconnected[bridge] {
+		fmt.Println("ChaosRunner: Reconnecting bridge")
+		require.NoError(runner.t, bridge.Start())
+		delete(runner.disconnected, bridge)
+		return
+	}
+
+	fmt.Println("ChaosRunner: Disconnecting bridge")
+	runner.raiseBridgeLogLevel(bridge)
+	bridge.SetAutoReconnect(0, 0)
+	bridge.Disconnect()
+	runner.disconnected[bridge] = true
+}
+
+// toggleRandomPause pauses a random bridge, or resumes it if it's paused.
+func (runner *ChaosRunner) toggleRandomPause() {
+	if len(runner.bridges) == 0 {
+		return
+	}
+	bridge := runner.bridges[runner.rng.Intn(len(runner.bridges))]
+	if runner.paused[bridge] {
+		fmt.Println("ChaosRunner: Resuming bridge")
+		bridge.Resume()
+		delete(runner.paused, bridge)
+		return
+	}
+
+	fmt.Println("ChaosRunner: Pausing bridge")
+	runner.raiseBridgeLogLevel(bridge)
+	bridge.Pause()
+	runner.paused[bridge] = true
+}
+
+// raiseLogLevel sets the node's log verbosity to the FaultLogLevel, if it's set.
+func (runner *ChaosRunner) raiseLogLevel(node *cmd.Node) {
+	if runner.config.FaultLogLevel <= 0 {
+		return
+	}
+	node.SetLogLevel("*", runner.config.FaultLogLevel)
+}
+
+// raiseBridgeLogLevel sets the log verbosity of both ends of the bridge to the FaultLogLevel, if it's set.
+func (runner *ChaosRunner) raiseBridgeLogLevel(bridge *ConnectionBridge) {
+	bridge.mtx.RLock()
+	nodeA, nodeB := bridge.nodeA, bridge.nodeB
+	bridge.mtx.RUnlock()
+	runner.raiseLogLevel(nodeA)
+	runner.raiseLogLevel(nodeB)
+}
+
+// heal resumes all paused bridges and reconnects all disconnected ones.
+func (runner *ChaosRunner) heal() {
+	for _, bridge := range runner.bridges {
+		if runner.paused[bridge] {
+			bridge.Resume()
+		}
+		if runner.disconnected[bridge] {
+			require.NoError(runner.t, bridge.Start())
+		}
+	}
+	runner.paused = make(map[*ConnectionBridge]bool)
+	runner.disconnected = make(map[*ConnectionBridge]bool)
+}
@@ -0,0 +1,46 @@
+package integration_testing
+
+import (
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// TestBlockSyncChaos test if nodes syncing blocks end up with the same state despite random faults:
+//  1. Spawn four nodes with max block height of MaxSyncBlockHeight blocks.
+//  2. The first node syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
+//  3. bridge all nodes together in a line, and the other nodes start syncing through the line.
+//  4. for two minutes, randomly restart nodes, and disconnect and pause bridges.
+//  5. once done, heal the network and compare the databases of all nodes.
+//
+// Set DESO_TEST_SEED to replay the faults of a failing run.
+func TestBlockSyncChaos(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	nodes := spawnNodeCluster(t, 4, WithSyncType(lib.NodeSyncTypeBlockSync),
+		ForNode(0, WithConnectIPs("deso-seed-2.io:17000"))).Nodes()
+
+	// wait for the first node to sync blocks
+	waitForNodeToFullySync(t, nodes[0])
+
+	// bridge the nodes together in a line.
+	topology, err := NewTopology(nodes, Line())
+	require.NoError(err)
+
+	runner := NewChaosRunner(t, nodes, topology.Bridges(), ChaosConfig{
+		Duration:         2 * time.Minute,
+		MinInterval:      2 * time.Second,
+		MaxInterval:      10 * time.Second,
+		RestartWeight:    1,
+		DisconnectWeight: 2,
+		PauseWeight:      2,
+		FaultLogLevel:    1,
+	})
+	runner.Run()
+	topology.Disconnect()
+	for _, node := range runner.Nodes() {
+		node.Stop()
+	}
+}
@@ -0,0 +1,83 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRegtestClockSkew test if nodes accept blocks from a miner whose clock is slightly ahead, and reject blocks from
+// a miner whose clock is too far in the future:
+//  1. Spawn two regtest nodes, an honest node and a miner whose clock is skewed by the test case's offset.
+//  2. bridge the honest node and the miner.
+//  3. mine a block on the miner.
+//  4. the honest node should either sync the block, or reject it because its timestamp is too far ahead, and keep
+//     its tip.
+func TestRegtestClockSkew(t *testing.T) {
+	testCases := []struct {
+		offset   time.Duration
+		accepted bool
+	}{
+		{-90 * time.Second, true},
+		{90 * time.Second, true},
+		{time.Hour, true},
+		{3 * time.Hour, false},
+		{24 * time.Hour, false},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(fmt.Sprintf("offset=%v", testCase.offset), func(t *testing.T) {
+			require := require.New(t)
+			_ = require
+
+			dbDir1 := getDirectory(t)
+			dbDir2 := getDirectory(t)
+			defer os.RemoveAll(dbDir1)
+			defer os.RemoveAll(dbDir2)
+
+			config1 := generateRegtestConfig(t, dbDir1, 10)
+			config2 := generateRegtestConfig(t, dbDir2, 10)
+			config2.TimeOffset = testCase.offset
+
+			node1 := cmd.NewNode(config1)
+			node2 := cmd.NewNode(config2)
+
+			node1 = startNode(t, node1)
+			node2 = startNode(t, node2)
+
+			// bridge the nodes together and mine a block with the skewed clock.
+			rejections, unsubscribe := node1.Subscribe(cmd.EventTypeBlockRejected)
+			defer unsubscribe()
+			bridge := NewConnectionBridge(node1, node2)
+			require.NoError(bridge.Start())
+			honestTip := node1.Server.GetBlockchain().BlockTip().Hash
+			blocks := mineBlocks(t, node2, 1)
+			blockHash, err := blocks[0].Hash()
+			require.NoError(err)
+
+			if testCase.accepted {
+				waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+				compareNodesByState(t, node1, node2, Summary)
+				require.Empty(rejections)
+			} else {
+				select {
+				case event := <-rejections:
+					require.Equal(blockHash.String(), event.BlockHash)
+					require.Contains(event.RejectReason, string(lib.HeaderErrorBlockTooFarInTheFuture))
+				case <-time.After(time.Minute):
+					t.Fatalf("Timed out waiting for node1 to reject the block mined with offset (%v)",
+						testCase.offset)
+				}
+				require.True(honestTip.IsEqual(node1.Server.GetBlockchain().BlockTip().Hash))
+				require.True(blockHash.IsEqual(node2.Server.GetBlockchain().BlockTip().Hash))
+			}
+			bridge.Disconnect()
+			node1.Stop()
+			node2.Stop()
+		})
+	}
+}
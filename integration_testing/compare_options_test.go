@@ -0,0 +1,280 @@
+package integration_testing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestRegtestCompareSkipOptions test if the compare helpers ignore the prefixes and keys skipped by their options:
+//  1. Spawn two regtest nodes node1, node2, bridge them together, and mine a few blocks on node1.
+//  2. once the nodes converge, write a post entry directly into node2's database, which node1 doesn't have.
+//  3. the nodes should match by DB and by checksum when the post entry prefix, or the post entry key, is skipped,
+//     and by DB when the post entry is an expected difference.
+//  4. the nodes should differ when nothing is skipped.
+func TestRegtestCompareSkipOptions(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	node1 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir1, 10)))
+	node2 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir2, 10)))
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+	mineBlocks(t, node1, 5)
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+	height := uint64(node2.Server.GetBlockchain().BlockTip().Height)
+
+	postHash := &lib.BlockHash{1, 2, 3}
+	key := append(append([]byte{}, lib.Prefixes.PrefixPostHashToPostEntry...), postHash[:]...)
+	value := lib.EncodeToBytes(height, &lib.PostEntry{PostHash: postHash})
+	require.NoError(node2.ChainDB.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	}))
+
+	skipPrefix := lib.DiffOptions{SkipPrefixes: [][]byte{lib.Prefixes.PrefixPostHashToPostEntry}}
+	compareNodesByDB(t, node1, node2, Summary, skipPrefix)
+	compareNodesByChecksum(t, node1, node2, skipPrefix)
+	skipKey := lib.DiffOptions{SkipKeyFunc: func(dbKey []byte) bool {
+		return bytes.Equal(dbKey, key)
+	}}
+	compareNodesByDB(t, node1, node2, Summary, skipKey)
+	compareNodesByChecksum(t, node1, node2, skipKey)
+	compareNodesByDB(t, node1, node2, Summary, lib.DiffOptions{ExpectedDifferences: []lib.ExpectedDifference{{
+		Prefix:      lib.Prefixes.PrefixPostHashToPostEntry,
+		KeyFunc:     skipKey.SkipKeyFunc,
+		Description: "node2 has the written post entry",
+	}}})
+
+	diff, err := lib.DiffNodeStates(node1.ChainDB, node2.ChainDB, comparableStatePrefixes(), lib.DiffOptions{})
+	require.NoError(err)
+	require.Equal([][]byte{lib.Prefixes.PrefixPostHashToPostEntry}, diff.BrokenPrefixes())
+	require.Equal([]string{hex.EncodeToString(key)}, diff.Prefixes[0].MissingInA)
+	require.NotEqual(computeNodeStateChecksum(t, node1, height), computeNodeStateChecksum(t, node2, height))
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestRegtestCorruptedBalanceDescription test if a state difference is described with decoded entries:
+//  1. Spawn two regtest nodes node1, node2, bridge them together, and mine a few blocks on node1.
+//  2. once the nodes converge, corrupt the miner's DeSo balance in node2's database.
+//  3. the description of the nodes' state diff should name the miner's public key and both balances.
+func TestRegtestCorruptedBalanceDescription(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	node1 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir1, 10)))
+	node2 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir2, 10)))
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+	mineBlocks(t, node1, 5)
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+
+	minerPublicKey, _, err := lib.Base58CheckDecode(regtestMinerPublicKey)
+	require.NoError(err)
+	balance, err := lib.DbGetDeSoBalanceNanosForPublicKey(node1.ChainDB, nil, minerPublicKey)
+	require.NoError(err)
+	require.NotZero(balance)
+	key := append(lib.DbGetPrefixForPublicKeyToDesoBalanceNanos(), minerPublicKey...)
+	require.NoError(node2.ChainDB.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, lib.EncodeUint64(balance+1))
+	}))
+
+	diff, err := lib.DiffNodeStates(node1.ChainDB, node2.ChainDB, comparableStatePrefixes(), lib.DiffOptions{})
+	require.NoError(err)
+	description := strings.Join(describeStateDiff(diff), "\n")
+	require.Contains(description, "PrefixPublicKeyToDeSoBalanceNanos")
+	require.Contains(description, regtestMinerPublicKey)
+	require.Contains(description, fmt.Sprintf("BalanceNanos (%v)", balance))
+	require.Contains(description, fmt.Sprintf("BalanceNanos (%v)", balance+1))
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestRegtestCompareBlockIndex test if two nodes that synced the same blocks have the same best chain:
+//  1. Spawn two regtest nodes node1, node2, bridge them together, and mine a few blocks on node1.
+//  2. once the nodes converge, their best chains should match block by block, in full and up to a lower height.
+func TestRegtestCompareBlockIndex(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	node1 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir1, 10)))
+	node2 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir2, 10)))
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+	mineBlocks(t, node1, 5)
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+
+	compareNodesByBlockIndex(t, node1, node2, 0)
+	compareNodesByBlockIndex(t, node1, node2, 2)
+	require.Len(getBestChainSnapshot(node2), len(getBestChainSnapshot(node1)))
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestRegtestCompareUtxoOperations test if two nodes that processed the same blocks stored the same utxo operations:
+//  1. Spawn two regtest nodes node1, node2, bridge them together, and mine a few blocks on node1.
+//  2. restart node2, submit a few transfers on node1, and mine the transfers into new blocks.
+//  3. once the nodes converge, the utxo operations of the blocks mined after the restart should match.
+//  4. a utxo operation with a different amount should be reported as a difference.
+func TestRegtestCompareUtxoOperations(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	node1 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir1, 10)))
+	node2 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir2, 10)))
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+	mineBlocks(t, node1, 3)
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+	restartHeight := node1.Server.GetBlockchain().BlockTip().Height
+
+	node2, bridge = replaceAndReconnectNode(t, node2, bridge, restartNode)
+	for ii := 0; ii < 3; ii++ {
+		submitBasicTransfer(t, node1, uint64(1000+ii))
+	}
+	mineBlocks(t, node1, 2)
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+	compareNodesByUtxoOperations(t, node1, node2, restartHeight+1, 0)
+
+	opA := &lib.UtxoOperation{Type: lib.OperationTypeAddUtxo, Entry: &lib.UtxoEntry{AmountNanos: 1}}
+	opB := &lib.UtxoOperation{Type: lib.OperationTypeAddUtxo, Entry: &lib.UtxoEntry{AmountNanos: 2}}
+	lines := diffUtxoOperations(1, [][]*lib.UtxoOperation{{opA}}, [][]*lib.UtxoOperation{{opB}, {}})
+	require.Len(lines, 2)
+	require.Contains(lines[1], "operation (0)")
+	require.Contains(lines[1], "UtxoOperation")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestRegtestChecksumMismatchPrefix test if a checksum mismatch is localized to the prefix that differs:
+//  1. Spawn two regtest nodes node1, node2, bridge them together, and mine a few blocks on node1.
+//  2. once the nodes converge, corrupt a post entry in node2's database.
+//  3. the per-prefix checksums of the nodes should only mismatch in the post entry prefix.
+func TestRegtestChecksumMismatchPrefix(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	node1 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir1, 10)))
+	node2 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir2, 10)))
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+	mineBlocks(t, node1, 5)
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+	height := uint64(node2.Server.GetBlockchain().BlockTip().Height)
+
+	postHash := &lib.BlockHash{1, 2, 3}
+	key := append(append([]byte{}, lib.Prefixes.PrefixPostHashToPostEntry...), postHash[:]...)
+	require.NoError(node2.ChainDB.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, lib.EncodeToBytes(height, &lib.PostEntry{PostHash: postHash}))
+	}))
+
+	checksum1, prefixChecksums1 := computeNodeStateChecksums(t, node1, height)
+	checksum2, _ := computeNodeStateChecksums(t, node2, height)
+	require.NotEqual(checksum1, checksum2)
+	require.Equal(checksum1, computeNodeStateChecksum(t, node1, height))
+	require.Len(prefixChecksums1, len(lib.StatePrefixes.StatePrefixesList))
+	require.Equal([]string{"PrefixPostHashToPostEntry"},
+		mismatchedChecksumPrefixes(t, node1, node2, height, lib.DiffOptions{}))
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestRegtestPrefixChecksums test if the single-prefix checksums add up to the full state checksum:
+//  1. Spawn a regtest node node1, and mine a few blocks with transfers on it.
+//  2. the combination of the checksums of every state prefix should equal the full state checksum.
+//  3. the checksum of a subset of prefixes should equal the combination of their single-prefix checksums.
+func TestRegtestPrefixChecksums(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+
+	node1 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir1, 10)))
+	mineBlocks(t, node1, 5)
+	for ii := 0; ii < 3; ii++ {
+		submitBasicTransfer(t, node1, 1000)
+	}
+	mineBlocks(t, node1, 1)
+	height := uint64(node1.Server.GetBlockchain().BlockTip().Height)
+
+	var prefixChecksums [][]byte
+	for _, prefix := range sortedStatePrefixes() {
+		prefixChecksums = append(prefixChecksums, computePrefixChecksum(t, node1, prefix, height))
+	}
+	require.Equal(computeNodeStateChecksum(t, node1, height), combineStateChecksums(t, prefixChecksums...))
+
+	subset := [][]byte{lib.Prefixes.PrefixPublicKeyToDeSoBalanceNanos, lib.Prefixes.PrefixUtxoKeyToUtxoEntry}
+	balanceChecksum := computePrefixChecksum(t, node1, subset[0], height)
+	require.NotEqual(combineStateChecksums(t), balanceChecksum)
+	require.Equal(combineStateChecksums(t, balanceChecksum, computePrefixChecksum(t, node1, subset[1], height)),
+		computePrefixesChecksum(t, node1, subset, height))
+	node1.Stop()
+}
+
+// BenchmarkNodeStateComparison measures comparing the states of two converged regtest nodes with the shared helpers:
+//  1. Spawn two regtest nodes node1, node2, bridge them together, and mine blocks with transfers on node1.
+//  2. once the nodes converge, compare them by state, by DB, and by checksum on every iteration.
+func BenchmarkNodeStateComparison(b *testing.B) {
+	require := require.New(b)
+
+	dbDir1 := getDirectory(b)
+	dbDir2 := getDirectory(b)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	node1 := startNode(b, cmd.NewNode(generateRegtestConfig(b, dbDir1, 10)))
+	node2 := startNode(b, cmd.NewNode(generateRegtestConfig(b, dbDir2, 10)))
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+
+	mineBlocks(b, node1, 3)
+	for ii := 0; ii < 5; ii++ {
+		submitBasicTransfer(b, node1, 1000)
+		mineBlocks(b, node1, 1)
+	}
+	waitForNodesToConverge(b, []*cmd.Node{node1, node2}, time.Minute)
+
+	b.ResetTimer()
+	for ii := 0; ii < b.N; ii++ {
+		compareNodesByState(b, node1, node2, Quiet)
+		compareNodesByDB(b, node1, node2, Quiet)
+		compareNodesByChecksum(b, node1, node2)
+	}
+	b.StopTimer()
+	node1.Stop()
+	node2.Stop()
+}
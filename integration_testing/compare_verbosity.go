@@ -0,0 +1,102 @@
+package integration_testing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/deso-protocol/core/lib"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Verbosity controls how much the state comparison helpers, such as compareNodesByDB, print while comparing.
+// Differences are always reported in the failure message, regardless of the verbosity.
+type Verbosity int
+
+const (
+	// Quiet prints nothing if the states match.
+	Quiet Verbosity = iota
+	// Summary prints a progress line every compareProgressEntries entries, and a summary line once the states were
+	// compared. It's the verbosity that tests should use by default.
+	Summary
+	// PerPrefix also prints a line for every prefix once it was compared.
+	PerPrefix
+	// PerKey also logs every differing key, rather than the first few of every prefix.
+	PerKey
+)
+
+func (verbosity Verbosity) String() string {
+	switch verbosity {
+	case Quiet:
+		return "Quiet"
+	case Summary:
+		return "Summary"
+	case PerPrefix:
+		return "PerPrefix"
+	case PerKey:
+		return "PerKey"
+	default:
+		return fmt.Sprintf("Verbosity(%d)", int(verbosity))
+	}
+}
+
+// compareOutput is where the state comparison helpers print their progress and summaries.
+var compareOutput io.Writer = os.Stdout
+
+// compareProgressEntries is the number of entries, read from either state, after which the state comparison helpers
+// print a progress line, so that comparing big prefixes doesn't look like a hang.
+var compareProgressEntries = 100000
+
+// compareProgress prints the progress of a state comparison according to the verbosity. It's passed to
+// lib.DiffStates as the lib.DiffOptions.ProgressFunc, and is safe for concurrent use.
+type compareProgress struct {
+	verbosity Verbosity
+	start     time.Time
+
+	mtx            sync.Mutex
+	numEntries     int
+	numPrefixes    int
+	prefixEntries  map[string]int
+	lastReportedAt int
+}
+
+func newCompareProgress(verbosity Verbosity) *compareProgress {
+	return &compareProgress{
+		verbosity:     verbosity,
+		start:         time.Now(),
+		prefixEntries: make(map[string]int),
+	}
+}
+
+func (progress *compareProgress) report(diffProgress lib.DiffProgress) {
+	progress.mtx.Lock()
+	defer progress.mtx.Unlock()
+
+	prefix := hex.EncodeToString(diffProgress.Prefix)
+	progress.numEntries += diffProgress.NumEntries
+	progress.prefixEntries[prefix] += diffProgress.NumEntries
+	if diffProgress.PrefixDone {
+		progress.numPrefixes++
+		if progress.verbosity >= PerPrefix {
+			fmt.Fprintf(compareOutput, "Compare: Prefix %v done, (%v) entries read, elapsed (%v)\n",
+				lib.StatePrefixName(diffProgress.Prefix), progress.prefixEntries[prefix], time.Since(progress.start))
+		}
+	}
+	if progress.verbosity >= Summary && progress.numEntries-progress.lastReportedAt >= compareProgressEntries {
+		progress.lastReportedAt = progress.numEntries
+		fmt.Fprintf(compareOutput, "Compare: (%v) entries read, (%v) prefixes done, elapsed (%v)\n",
+			progress.numEntries, progress.numPrefixes, time.Since(progress.start))
+	}
+}
+
+// summarize prints the summary line of a comparison whose states matched.
+func (progress *compareProgress) summarize() {
+	if progress.verbosity < Summary {
+		return
+	}
+	progress.mtx.Lock()
+	defer progress.mtx.Unlock()
+	fmt.Fprintf(compareOutput, "Compare: States match, (%v) entries read in (%v) prefixes, elapsed (%v)\n",
+		progress.numEntries, progress.numPrefixes, time.Since(progress.start))
+}
@@ -0,0 +1,112 @@
+package integration_testing
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// TestCompareVerbosity tests what the state comparison prints on success at every verbosity.
+func TestCompareVerbosity(t *testing.T) {
+	require := require.New(t)
+
+	prefixes := [][]byte{lib.Prefixes.PrefixPostHashToPostEntry, lib.Prefixes.PrefixPKIDToProfileEntry}
+	var entries []*lib.DBEntry
+	for _, prefix := range prefixes {
+		for ii := byte(0); ii < 5; ii++ {
+			entries = append(entries, lib.KeyValueToDBEntry(append(append([]byte{}, prefix...), ii), []byte{ii}))
+		}
+	}
+	read := newMemoryStateChunkReader(entries)
+
+	defaultOutput, defaultProgressEntries := compareOutput, compareProgressEntries
+	defer func() {
+		compareOutput, compareProgressEntries = defaultOutput, defaultProgressEntries
+	}()
+	// Print a progress line after every four entries read.
+	compareProgressEntries = 4
+	compareWithVerbosity := func(verbosity Verbosity) string {
+		output := &bytes.Buffer{}
+		compareOutput = output
+		compareStateWithPrefixList(t, read, read, prefixes, verbosity, lib.DiffOptions{Workers: 1})
+		return output.String()
+	}
+
+	require.Empty(compareWithVerbosity(Quiet))
+
+	output := compareWithVerbosity(Summary)
+	require.Contains(output, "Compare: States match, (20) entries read in (2) prefixes")
+	// Both states are read in chunks of five entries, each of which crosses the four entries between progress lines.
+	require.Equal(4, strings.Count(output, "entries read, "))
+	require.NotContains(output, "Compare: Prefix")
+
+	for _, verbosity := range []Verbosity{PerPrefix, PerKey} {
+		output = compareWithVerbosity(verbosity)
+		require.Contains(output, "Compare: States match")
+		require.Contains(output, "Compare: Prefix PrefixPostHashToPostEntry done, (10) entries read")
+		require.Contains(output, "Compare: Prefix PrefixPKIDToProfileEntry done, (10) entries read")
+	}
+}
+
+// TestReportStateDiffCapsLogs tests that a badly diverged prefix produces bounded logs, while the saved diff still has
+// every difference.
+func TestReportStateDiffCapsLogs(t *testing.T) {
+	require := require.New(t)
+	t.Setenv(diffArtifactDirEnvVar, t.TempDir())
+
+	// State B has none of the 10,000 post entries of state A.
+	const numEntries = 10000
+	prefix := lib.Prefixes.PrefixPostHashToPostEntry
+	var entries []*lib.DBEntry
+	for ii := 0; ii < numEntries; ii++ {
+		entries = append(entries, lib.KeyValueToDBEntry(append(append([]byte{}, prefix...),
+			lib.EncodeUint64(uint64(ii))...), []byte{1}))
+	}
+	diff, err := lib.DiffStates(newMemoryStateChunkReader(entries), newMemoryStateChunkReader(nil), [][]byte{prefix},
+		lib.DiffOptions{})
+	require.NoError(err)
+	require.Equal(numEntries, diff.NumDifferences())
+
+	for _, logKeys := range []int{0, 5} {
+		logLines, failure := reportStateDiff(t, diff, Summary, lib.DiffOptions{LogKeysPerPrefix: logKeys})
+		expectedKeys := logKeys
+		if logKeys == 0 {
+			expectedKeys = stateDiffLogKeysPerPrefix
+		}
+		// The summary line of the prefix, the logged keys, and the line with the remaining differences.
+		require.Len(logLines, expectedKeys+2)
+		require.Equal(fmt.Sprintf("and (%v) more differences in prefix PrefixPostHashToPostEntry",
+			numEntries-expectedKeys), logLines[len(logLines)-1])
+		require.Less(strings.Count(failure, "\n"), stateDiffFailureLines+2)
+
+		// The artifact has every difference.
+		path := failure[strings.Index(failure, "saved to (")+len("saved to (") : strings.Index(failure, ")\n")]
+		report, err := lib.ReadStateDiffReport(path)
+		require.NoError(err)
+		require.Len(report.Prefixes[0].MissingInB, numEntries)
+		require.Zero(report.Prefixes[0].NumOmitted)
+	}
+
+	logLines, _ := reportStateDiff(t, diff, PerKey, lib.DiffOptions{})
+	require.Len(logLines, numEntries+1)
+}
+
+// newMemoryStateChunkReader returns a lib.StateChunkReader over the entries, which reads every prefix in one chunk.
+func newMemoryStateChunkReader(entries []*lib.DBEntry) lib.StateChunkReader {
+	sort.Slice(entries, func(ii, jj int) bool {
+		return bytes.Compare(entries[ii].Key, entries[jj].Key) < 0
+	})
+	return func(prefix []byte, startKey []byte, targetBytes uint32) ([]*lib.DBEntry, bool, error) {
+		var chunk []*lib.DBEntry
+		for _, entry := range entries {
+			if bytes.HasPrefix(entry.Key, prefix) && bytes.Compare(entry.Key, startKey) >= 0 {
+				chunk = append(chunk, entry)
+			}
+		}
+		return chunk, false, nil
+	}
+}
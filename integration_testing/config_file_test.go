@@ -0,0 +1,111 @@
+package integration_testing
+
+import (
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestLoadConfigFile test if node configs are loaded from YAML and JSON files, with overrides on top:
+//  1. the regtest config in testdata should load into the same config as generateRegtestConfig, with its own copy of
+//     the testnet Params.
+//  2. the same fields in a JSON file should load into the same config.
+//  3. unknown fields, the Params, and unknown networks should be rejected, and the network is required.
+//  4. the environment and code overrides should be applied on top of the file, in order.
+func TestLoadConfigFile(t *testing.T) {
+	require := require.New(t)
+
+	dbDir := getDirectory(t)
+	defer os.RemoveAll(dbDir)
+
+	expected := generateRegtestConfig(t, dbDir, 10)
+	loaded := generateConfigFromFile(t, dbDir, "regtest_node.yaml")
+	require.Equal(lib.NetworkType_TESTNET, loaded.Params.NetworkType)
+	require.Equal(expected.Params.DNSSeeds, loaded.Params.DNSSeeds)
+	loaded.Params.DNSSeeds[0] = "modified"
+	require.NotEqual("modified", lib.DeSoTestnetParams.DNSSeeds[0])
+	expected.Params, loaded.Params = nil, nil
+	expected.LogOutput, loaded.LogOutput = nil, nil
+	require.Equal(expected, loaded)
+
+	writeConfigFile := func(name string, contents string) string {
+		path := filepath.Join(dbDir, name)
+		require.NoError(ioutil.WriteFile(path, []byte(contents), 0644))
+		return path
+	}
+	config, err := cmd.LoadConfigFile(writeConfigFile("node.json",
+		`{"Network": "mainnet", "HyperSync": true, "SyncType": "hypersync", "ConnectIPs": ["127.0.0.1:17000"]}`))
+	require.NoError(err)
+	require.Equal(lib.NetworkType_MAINNET, config.Params.NetworkType)
+	require.True(config.HyperSync)
+	require.Equal(lib.NodeSyncType(lib.NodeSyncTypeHyperSync), config.SyncType)
+	require.Equal([]string{"127.0.0.1:17000"}, config.ConnectIPs)
+
+	for contents, expectedError := range map[string]string{
+		"Network: testnet\nHyperSnyc: true\n":   `unknown field "HyperSnyc"`,
+		"Network: testnet\nParams: {}\n":        `unknown field "Params"`,
+		"Network: devnet\n":                     "unknown Network (devnet)",
+		"HyperSync: true\n":                     "must set the Network",
+		"Network: testnet\nMaxInboundPeers: -1": "cannot unmarshal number -1",
+	} {
+		_, err := cmd.LoadConfigFile(writeConfigFile("node.yaml", contents))
+		require.Error(err, contents)
+		require.True(strings.Contains(err.Error(), expectedError), "expected (%v), got (%v)", expectedError, err)
+	}
+
+	// The environment overrides the file, and the code overrides the environment.
+	t.Setenv("TEST_CONFIG_MaxSyncBlockHeight", "2000")
+	t.Setenv("TEST_CONFIG_ConnectIPs", "[127.0.0.1:18000, 127.0.0.1:18001]")
+	t.Setenv("TEST_CONFIG_TimeOffset", "1000")
+	config, err = cmd.LoadConfigFile(filepath.Join("testdata", "regtest_node.yaml"), cmd.EnvConfigOverride("TEST_CONFIG_"),
+		func(config *cmd.Config) error {
+			config.TimeOffset = time.Minute
+			return nil
+		})
+	require.NoError(err)
+	require.Equal(uint32(2000), config.MaxSyncBlockHeight)
+	require.Equal([]string{"127.0.0.1:18000", "127.0.0.1:18001"}, config.ConnectIPs)
+	require.Equal(time.Minute, config.TimeOffset)
+	require.True(config.Regtest)
+
+	t.Setenv("TEST_CONFIG_HyperSnyc", "true")
+	_, err = cmd.LoadConfigFile(filepath.Join("testdata", "regtest_node.yaml"), cmd.EnvConfigOverride("TEST_CONFIG_"))
+	require.Error(err)
+	require.True(strings.Contains(err.Error(), `unknown field "HyperSnyc"`), "unexpected error: %v", err)
+}
+
+// TestRegtestNodeFromConfigFile test if a node started from a config file works like a node from generateRegtestConfig:
+//  1. Spawn a regtest node node1 from the testdata config file, and a regtest node node2 from generateRegtestConfig.
+//  2. bridge them together, and mine blocks with a transfer on node1.
+//  3. the nodes should converge and have the same state.
+func TestRegtestNodeFromConfigFile(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	node1 := startNode(t, cmd.NewNode(generateConfigFromFile(t, dbDir1, "regtest_node.yaml",
+		func(config *cmd.Config) {
+			config.HyperSync = true
+		})))
+	node2 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir2, 10)))
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+
+	mineBlocks(t, node1, 3)
+	submitBasicTransfer(t, node1, 1000)
+	mineBlocks(t, node1, 1)
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+	compareNodesByState(t, node1, node2, Summary)
+	node1.Stop()
+	node2.Stop()
+}
@@ -0,0 +1,147 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestConfigValidate test if cmd.Config.Validate reports every violation of an invalid config:
+//  1. the configs generated for tests, and their usual variations, should be valid.
+//  2. every invalid combination of fields should fail with the expected messages, one per violation.
+func TestConfigValidate(t *testing.T) {
+	require := require.New(t)
+
+	dbDir := getDirectory(t)
+	defer os.RemoveAll(dbDir)
+
+	require.NoError(generateConfig(t, dbDir, 10).Validate())
+	require.NoError(generateRegtestConfig(t, dbDir, 10).Validate())
+	hyperSyncConfig := generateConfig(t, dbDir, 10)
+	hyperSyncConfig.HyperSync = true
+	require.NoError(hyperSyncConfig.Validate())
+	hyperSyncConfig.SyncType = lib.NodeSyncTypeHyperSyncArchival
+	require.NoError(hyperSyncConfig.Validate())
+	// A zero snapshot period defaults to lib.SnapshotBlockHeightPeriod.
+	hyperSyncConfig.SnapshotBlockHeightPeriod = 0
+	require.NoError(hyperSyncConfig.Validate())
+	// In-memory nodes don't need a data directory.
+	inMemoryConfig := generateConfig(t, dbDir, 10)
+	inMemoryConfig.InMemory = true
+	inMemoryConfig.DataDirectory = ""
+	require.NoError(inMemoryConfig.Validate())
+	// Pruned nodes either blocksync, or hypersync without downloading historical blocks.
+	prunedConfig := generateConfig(t, dbDir, 10)
+	prunedConfig.PruneBlocksOlderThan = 10
+	require.NoError(prunedConfig.Validate())
+	prunedConfig.HyperSync = true
+	prunedConfig.SyncType = lib.NodeSyncTypeHyperSync
+	prunedConfig.PruneBlocksOlderThan = 1000
+	require.NoError(prunedConfig.Validate())
+
+	testCases := []struct {
+		name     string
+		mutate   func(config *cmd.Config)
+		expected []string
+	}{
+		{"no params", func(config *cmd.Config) {
+			config.Params = nil
+		}, []string{"Params must be set"}},
+		{"regtest on mainnet", func(config *cmd.Config) {
+			config.Regtest = true
+		}, []string{"Regtest requires testnet Params"}},
+		{"no data directory", func(config *cmd.Config) {
+			config.DataDirectory = ""
+		}, []string{"DataDirectory must be set"}},
+		{"txindex without data directory", func(config *cmd.Config) {
+			config.TXIndex = true
+			config.DataDirectory = ""
+		}, []string{"DataDirectory must be set"}},
+		{"txindex with postgres", func(config *cmd.Config) {
+			config.TXIndex = true
+			config.PostgresURI = "postgres://localhost"
+		}, []string{"TXIndex isn't supported with Postgres"}},
+		{"pruning with txindex", func(config *cmd.Config) {
+			config.PruneBlocksOlderThan = 10
+			config.TXIndex = true
+		}, []string{"TXIndex needs all blocks, so it isn't supported with PruneBlocksOlderThan"}},
+		{"pruning with postgres", func(config *cmd.Config) {
+			config.PruneBlocksOlderThan = 10
+			config.PostgresURI = "postgres://localhost"
+		}, []string{"PruneBlocksOlderThan isn't supported with Postgres"}},
+		{"pruning with archival hypersync", func(config *cmd.Config) {
+			config.PruneBlocksOlderThan = 1000
+			config.HyperSync = true
+			config.SyncType = lib.NodeSyncTypeHyperSyncArchival
+		}, []string{"SyncType (hypersync-archival) downloads all historical blocks after HyperSync"}},
+		{"pruning below snapshot period", func(config *cmd.Config) {
+			config.PruneBlocksOlderThan = 500
+			config.HyperSync = true
+			config.SyncType = lib.NodeSyncTypeHyperSync
+		}, []string{"PruneBlocksOlderThan (500) is below SnapshotBlockHeightPeriod (1000)"}},
+		{"invalid listen addrs", func(config *cmd.Config) {
+			config.ListenAddrs = []string{"127.0.0.1", "localhost:0", "[::1]:70000"}
+		}, []string{"ListenAddrs has an invalid address (127.0.0.1)",
+			"ListenAddrs has an address (localhost:0) whose host isn't an IP",
+			"ListenAddrs has an address ([::1]:70000) with an invalid port"}},
+		{"connect ips without networking", func(config *cmd.Config) {
+			config.DisableNetworking = true
+			config.ConnectIPs = []string{"127.0.0.1:18000"}
+		}, []string{"can't be connected with DisableNetworking"}},
+		{"unknown sync type", func(config *cmd.Config) {
+			config.SyncType = "fastest"
+		}, []string{"Unrecognized --sync-type flag fastest"}},
+		{"hypersync sync type without hypersync", func(config *cmd.Config) {
+			config.SyncType = lib.NodeSyncTypeHyperSync
+		}, []string{"Cannot set --sync-type=hypersync without also setting --hypersync=true"}},
+		{"archival sync type without hypersync", func(config *cmd.Config) {
+			config.SyncType = lib.NodeSyncTypeHyperSyncArchival
+		}, []string{"Cannot set --sync-type=hypersync-archival without also setting --hypersync=true"}},
+		{"hypersync with postgres", func(config *cmd.Config) {
+			config.HyperSync = true
+			config.PostgresURI = "postgres://localhost"
+		}, []string{"HyperSync isn't supported with Postgres"}},
+		{"max sync height below snapshot period", func(config *cmd.Config) {
+			config.HyperSync = true
+			config.MaxSyncBlockHeight = 500
+		}, []string{"MaxSyncBlockHeight (500) is below SnapshotBlockHeightPeriod (1000)"}},
+		{"max sync height below default snapshot period", func(config *cmd.Config) {
+			config.HyperSync = true
+			config.SnapshotBlockHeightPeriod = 0
+			config.MaxSyncBlockHeight = 500
+		}, []string{fmt.Sprintf("MaxSyncBlockHeight (500) is below SnapshotBlockHeightPeriod (%v)",
+			lib.SnapshotBlockHeightPeriod)}},
+		{"invalid miner public key", func(config *cmd.Config) {
+			config.MinerPublicKeys = []string{"not-a-key"}
+		}, []string{"MinerPublicKeys has an invalid public key (not-a-key)"}},
+		{"invalid trusted block producer public key", func(config *cmd.Config) {
+			config.TrustedBlockProducerPublicKeys = []string{"not-a-key"}
+		}, []string{"TrustedBlockProducerPublicKeys has an invalid public key (not-a-key)"}},
+		{"fault injector without hypersync", func(config *cmd.Config) {
+			config.DBFaultInjector = &lib.DBFaultInjector{}
+		}, []string{"DBFaultInjector requires HyperSync"}},
+		{"several violations", func(config *cmd.Config) {
+			config.DataDirectory = ""
+			config.HyperSync = true
+			config.PostgresURI = "postgres://localhost"
+			config.MaxSyncBlockHeight = 500
+		}, []string{"DataDirectory must be set", "HyperSync isn't supported with Postgres",
+			"MaxSyncBlockHeight (500) is below SnapshotBlockHeightPeriod (1000)"}},
+	}
+	for _, testCase := range testCases {
+		config := generateConfig(t, dbDir, 10)
+		testCase.mutate(config)
+		err := config.Validate()
+		require.Error(err, testCase.name)
+		violations := strings.Split(err.Error(), "\n")
+		require.Len(violations, len(testCase.expected), "%v: %v", testCase.name, err)
+		for ii, expected := range testCase.expected {
+			require.True(strings.Contains(violations[ii], expected), "%v: expected (%v), got (%v)", testCase.name,
+				expected, violations[ii])
+		}
+	}
+}
@@ -3,10 +3,12 @@ package integration_testing
 import (
 	"fmt"
 	"github.com/btcsuite/btcd/addrmgr"
+	"github.com/btcsuite/btcd/wire"
 	"github.com/deso-protocol/core/cmd"
 	"github.com/deso-protocol/core/lib"
 	"github.com/golang/glog"
 	"math"
+	"math/rand"
 	"net"
 	"strconv"
 	"sync"
@@ -67,18 +69,380 @@ type ConnectionBridge struct {
 	// outboundListenerB is a listener that waits for outgoing connections from nodeB.
 	outboundListenerB net.Listener
 
-	paused   bool
 	disabled bool
 
 	waitGroup   sync.WaitGroup
 	newPeerChan chan *lib.Peer
 
 	connectionAttempt int
+
+	// mtx guards the link simulation settings below, which can be changed while the bridge is running.
+	mtx sync.RWMutex
+	// rng is the source of all random decisions made by the bridge, such as latencies, drops, or reordering.
+	// It is seeded from the test's TestRand, and can be re-seeded with SetRandomSeed.
+	rng *rand.Rand
+	// minLatency and maxLatency determine the range of the random delay applied to every relayed message.
+	minLatency time.Duration
+	maxLatency time.Duration
+	// dropRate is the fraction of relayed messages of dropMsgTypes that the bridge will silently drop.
+	dropRate     float64
+	dropMsgTypes messageTypeSet
+	// duplicateRate is the fraction of relayed messages of duplicateMsgTypes that the bridge will deliver a second
+	// time, duplicateDelay after the original message was read.
+	duplicateRate     float64
+	duplicateDelay    time.Duration
+	duplicateMsgTypes messageTypeSet
+	// bandwidthBuckets meter the serialized size of relayed messages in each direction, if bandwidth is limited.
+	bandwidthBuckets map[Direction]*tokenBucket
+	// messageTypeRates are the maximum numbers of messages of a given type relayed per second on each link.
+	messageTypeRates map[lib.MsgType]float64
+	// blockedMsgTypes are the message types that the bridge doesn't relay at all.
+	blockedMsgTypes map[lib.MsgType]bool
+	// reorderWindowSize is the number of messages of reorderMsgTypes that can be shuffled before delivery.
+	reorderWindowSize int
+	reorderMsgTypes   messageTypeSet
+	// disconnectedDirections are the directions in which the bridge stopped relaying messages.
+	disconnectedDirections map[Direction]bool
+
+	// messageHook is called on every relayed message, see SetMessageHook. hookMtx makes sure the hook is called
+	// by one link at a time.
+	messageHook MessageHook
+	hookMtx     sync.Mutex
+	// versionHook can modify the version messages the bridge introduces itself with during the handshakes. It is
+	// also guarded by mtx.
+	versionHook func(version *lib.MsgDeSoVersion, direction Direction)
+
+	// links are the links relaying traffic between the nodes since the bridge was last started. They are also
+	// guarded by mtx.
+	links []*bridgeLink
+
+	// observers are notified of every message sent by either node, including the handshake messages. They are
+	// also guarded by mtx.
+	observers []messageObserver
+
+	// stats keeps track of the traffic relayed through the bridge. It is also guarded by mtx.
+	stats BridgeStats
+
+	// recorder writes all relayed messages to a file while recording is enabled. It is also guarded by mtx.
+	recorder *bridgeRecorder
+
+	// pendingCorruptions are the corruptions scheduled with CorruptNextMessage that haven't been applied yet.
+	// They are also guarded by mtx.
+	pendingCorruptions []*messageCorruption
+	// disconnectTriggers are the counters armed with DisconnectAfterMessages. They are also guarded by mtx.
+	disconnectTriggers []*disconnectTrigger
+
+	// initialBackoff and maxBackoff determine the delays between reconnect attempts, see SetAutoReconnect. Automatic
+	// reconnects are disabled if initialBackoff is zero. They are also guarded by mtx.
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	// reconnecting is set while a reconnect loop is running, so that concurrent failures only trigger one loop.
+	reconnecting bool
+	// reconnectEvents receives an event every time the bridge automatically reconnects.
+	reconnectEvents chan ReconnectEvent
+
+	// handshakeDelay is how long the bridge waits before introducing itself to a node, see DelayHandshake. It is
+	// also guarded by mtx.
+	handshakeDelay time.Duration
+	// failHandshake makes the bridge never complete the version negotiation, see FailHandshake. It is also guarded
+	// by mtx.
+	failHandshake bool
+
+	// resumeChans are set for the directions in which the bridge is paused, and closed once they're resumed, see Pause
+	// and StallReads. They are also guarded by mtx.
+	resumeChans map[Direction]chan struct{}
+	// pauseBufferLimit and pauseOverflow determine how many messages each link holds while the bridge is paused,
+	// and what happens to the messages past the limit, see SetPauseBufferLimit. They are also guarded by mtx.
+	pauseBufferLimit int
+	pauseOverflow    PauseOverflow
+
+	// scheduler delivers the bridge's messages in a deterministic order, see DeliveryScheduler. It is also guarded
+	// by mtx.
+	scheduler *DeliveryScheduler
+}
+
+// PauseOverflow determines what a paused ConnectionBridge does once a link holds as many messages as it can.
+type PauseOverflow uint8
+
+const (
+	// PauseOverflowBlock makes the bridge stop reading from the sending node, which eventually blocks the node's
+	// writes to the connection, like a full TCP buffer would.
+	PauseOverflowBlock PauseOverflow = iota
+	// PauseOverflowDrop makes the bridge drop the messages past the limit, counting them in
+	// BridgeStats.PauseDroppedMessages.
+	PauseOverflowDrop
+)
+
+// defaultPauseBufferLimit is the number of messages each link holds while the bridge is paused, unless changed with
+// SetPauseBufferLimit.
+const defaultPauseBufferLimit = 10000
+
+// ReconnectEvent is emitted by a ConnectionBridge every time it automatically reconnects.
+type ReconnectEvent struct {
+	// Attempts is the number of connection attempts it took to reconnect.
+	Attempts int
+	// Downtime is how long the bridge was disconnected.
+	Downtime time.Duration
+}
+
+// reconnectEventsBufferSize is the number of reconnect events that can be emitted without anyone reading them.
+// Events are dropped once the buffer is full.
+const reconnectEventsBufferSize = 100
+
+// disconnectTrigger disconnects the bridge once the remaining number of messages of msgType were delivered.
+type disconnectTrigger struct {
+	msgType   lib.MsgType
+	remaining int
+	// firedChan is closed once the trigger fired and the bridge was disconnected.
+	firedChan chan struct{}
+}
+
+// messageCorruption is a mutation that should be applied to the next relayed message of msgType.
+type messageCorruption struct {
+	msgType lib.MsgType
+	mutate  MessageMutation
+}
+
+// BridgeStats is a snapshot of the traffic counters of a ConnectionBridge.
+type BridgeStats struct {
+	// DroppedMessages is the number of messages dropped by the bridge's simulated message loss.
+	DroppedMessages uint64
+	// CorruptedMessages is the number of messages corrupted with CorruptNextMessage.
+	CorruptedMessages uint64
+	// DuplicatedMessages is the number of messages scheduled to be delivered twice by the bridge's simulated
+	// message duplication.
+	DuplicatedMessages uint64
+	// InjectedMessages is the number of messages queued for delivery with InjectMessage. Once delivered, injected
+	// messages are also counted in Messages.
+	InjectedMessages uint64
+	// PauseDroppedMessages is the number of messages dropped because they didn't fit in the buffer of a paused
+	// bridge, see SetPauseBufferLimit.
+	PauseDroppedMessages uint64
+	// LinkFailures is the number of times one of the bridge's connections broke, e.g. because a node disconnected
+	// the peer on the other end of the bridge.
+	LinkFailures uint64
+	// QueueDepths is the number of messages of each type currently waiting in the queues of rate limited message
+	// types, see SetMessageTypeRate.
+	QueueDepths map[lib.MsgType]uint64
+	// BlockedMessages is the number of messages of each type that weren't relayed because the type was blocked
+	// with BlockMessageType.
+	BlockedMessages map[lib.MsgType]uint64
+	// Messages contains the counters of messages delivered by the bridge, per direction and message type.
+	Messages map[Direction]map[lib.MsgType]MessageStats
+}
+
+// MessageStats are the counters of messages of a single type delivered in a single direction. Sizes are measured
+// in payload bytes, which excludes the message header.
+type MessageStats struct {
+	Count      uint64
+	TotalBytes uint64
+	MinBytes   uint64
+	MaxBytes   uint64
+}
+
+// AvgBytes returns the average payload size of the messages.
+func (stats MessageStats) AvgBytes() float64 {
+	if stats.Count == 0 {
+		return 0
+	}
+	return float64(stats.TotalBytes) / float64(stats.Count)
+}
+
+// Get returns the counters of messages of msgType delivered in the provided direction.
+func (stats BridgeStats) Get(direction Direction, msgType lib.MsgType) MessageStats {
+	return stats.Messages[direction][msgType]
+}
+
+func (stats *BridgeStats) addMessage(direction Direction, msgType lib.MsgType, size uint64) {
+	if stats.Messages == nil {
+		stats.Messages = make(map[Direction]map[lib.MsgType]MessageStats)
+	}
+	if stats.Messages[direction] == nil {
+		stats.Messages[direction] = make(map[lib.MsgType]MessageStats)
+	}
+
+	msgStats := stats.Messages[direction][msgType]
+	if msgStats.Count == 0 || size < msgStats.MinBytes {
+		msgStats.MinBytes = size
+	}
+	if size > msgStats.MaxBytes {
+		msgStats.MaxBytes = size
+	}
+	msgStats.Count++
+	msgStats.TotalBytes += size
+	stats.Messages[direction][msgType] = msgStats
+}
+
+func (stats BridgeStats) copy() BridgeStats {
+	statsCopy := stats
+	statsCopy.QueueDepths = make(map[lib.MsgType]uint64)
+	for msgType, depth := range stats.QueueDepths {
+		statsCopy.QueueDepths[msgType] = depth
+	}
+	statsCopy.BlockedMessages = make(map[lib.MsgType]uint64)
+	for msgType, blocked := range stats.BlockedMessages {
+		statsCopy.BlockedMessages[msgType] = blocked
+	}
+	statsCopy.Messages = make(map[Direction]map[lib.MsgType]MessageStats)
+	for direction, directionStats := range stats.Messages {
+		statsCopy.Messages[direction] = make(map[lib.MsgType]MessageStats)
+		for msgType, msgStats := range directionStats {
+			statsCopy.Messages[direction][msgType] = msgStats
+		}
+	}
+	return statsCopy
+}
+
+// tokenBucket is a simple token bucket rate limiter. Tokens are replenished at a constant rate up to the bucket's
+// capacity. Reservations are allowed to overdraw the bucket, in which case the caller has to wait until the debt
+// is paid off, which means consecutive reservations queue up behind each other.
+type tokenBucket struct {
+	// rate is the number of tokens added to the bucket per second.
+	rate float64
+	// capacity is the maximum number of tokens the bucket can hold.
+	capacity float64
+
+	tokens      float64
+	lastRefresh time.Time
+}
+
+func newTokenBucket(rate float64, capacity float64) *tokenBucket {
+	return &tokenBucket{
+		rate:        rate,
+		capacity:    capacity,
+		tokens:      capacity,
+		lastRefresh: time.Now(),
+	}
+}
+
+// reserve takes the provided number of tokens from the bucket and returns how long the caller should wait before
+// the reserved tokens are actually available.
+func (bucket *tokenBucket) reserve(tokens float64, now time.Time) time.Duration {
+	if elapsed := now.Sub(bucket.lastRefresh); elapsed > 0 {
+		bucket.tokens = math.Min(bucket.capacity, bucket.tokens+elapsed.Seconds()*bucket.rate)
+		bucket.lastRefresh = now
+	}
+	bucket.tokens -= tokens
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+}
+
+// messageTypeSet is a set of message types used to restrict bridge behaviors to particular messages.
+// An empty set matches all message types.
+type messageTypeSet map[lib.MsgType]struct{}
+
+func newMessageTypeSet(msgTypes []lib.MsgType) messageTypeSet {
+	set := make(messageTypeSet)
+	for _, msgType := range msgTypes {
+		set[msgType] = struct{}{}
+	}
+	return set
+}
+
+func (set messageTypeSet) matches(msgType lib.MsgType) bool {
+	if len(set) == 0 {
+		return true
+	}
+	_, exists := set[msgType]
+	return exists
+}
+
+// Direction indicates which way a message travels through the ConnectionBridge.
+type Direction uint8
+
+const (
+	// DirectionAToB is the direction of messages sent by nodeA to nodeB.
+	DirectionAToB Direction = iota
+	// DirectionBToA is the direction of messages sent by nodeB to nodeA.
+	DirectionBToA
+)
+
+func (direction Direction) String() string {
+	switch direction {
+	case DirectionAToB:
+		return "A->B"
+	case DirectionBToA:
+		return "B->A"
+	default:
+		return fmt.Sprintf("Direction(%d)", uint8(direction))
+	}
+}
+
+// MessageHook is a function that intercepts messages relayed through a ConnectionBridge. It receives the message and
+// the direction in which it's traveling, and returns the message that should be forwarded in its place, which can be
+// the original message, a modified copy, or an entirely different message. If the hook returns false, the message
+// is dropped.
+type MessageHook func(msg lib.DeSoMessage, direction Direction) (lib.DeSoMessage, bool)
+
+// bridgeDeliveryQueueSize is the number of messages that can wait for delivery on a single bridge link before we
+// stop reading from the source connection.
+const bridgeDeliveryQueueSize = 1000
+
+// bridgeMessage is a message read from the source connection of a bridge link that awaits delivery to the destination.
+type bridgeMessage struct {
+	msg lib.DeSoMessage
+	// deliverAt is the earliest time at which the message can be written to the destination connection.
+	deliverAt time.Time
+	// mutate, if set, corrupts the serialized message before it's written to the destination connection.
+	mutate MessageMutation
+}
+
+// bridgeLink is a one-directional tunnel between two of the bridge's connections. Messages are read from the source
+// connection by routeTraffic, and written to the destination connection by deliverTraffic. Splitting the two lets
+// us delay delivery without blocking reads.
+type bridgeLink struct {
+	source      *lib.Peer
+	destination *lib.Peer
+	direction   Direction
+	// index is the position of the link among the bridge's links.
+	index int
+	// toOutbound indicates whether the destination connection is an outbound connection of its node.
+	toOutbound bool
+
+	deliveryQueue chan *bridgeMessage
+	// exitChan is closed once routeTraffic stops reading from the source connection.
+	exitChan chan struct{}
+	// deliveryDoneChan is closed once deliverTraffic stops writing to the destination connection.
+	deliveryDoneChan chan struct{}
+	// throttles are the queues of message types that were rate limited while the link was running. They are only
+	// accessed by routeTraffic.
+	throttles map[lib.MsgType]chan *bridgeMessage
+}
+
+func newBridgeLink(source *lib.Peer, destination *lib.Peer, direction Direction, toOutbound bool) *bridgeLink {
+	return &bridgeLink{
+		source:           source,
+		destination:      destination,
+		direction:        direction,
+		toOutbound:       toOutbound,
+		deliveryQueue:    make(chan *bridgeMessage, bridgeDeliveryQueueSize),
+		exitChan:         make(chan struct{}),
+		deliveryDoneChan: make(chan struct{}),
+		throttles:        make(map[lib.MsgType]chan *bridgeMessage),
+	}
+}
+
+func (link *bridgeLink) exited() bool {
+	select {
+	case <-link.exitChan:
+		return true
+	default:
+		return false
+	}
 }
 
 // NewConnectionBridge creates an instance of ConnectionBridge that's ready to be connected.
 // This function is usually followed by ConnectionBridge.Start()
 func NewConnectionBridge(nodeA *cmd.Node, nodeB *cmd.Node) *ConnectionBridge {
+	// Seed the bridge from the test's TestRand, so that its random decisions are reproducible with the test's seed.
+	// Nodes that were never started aren't tracked by any test, and fall back to a time-based seed.
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	t := testOfNode(nodeA)
+	if t != nil {
+		rng, _ = getTestRand(t).NewRand()
+	}
 
 	bridge := &ConnectionBridge{
 		nodeA:             nodeA,
@@ -86,28 +450,44 @@ func NewConnectionBridge(nodeA *cmd.Node, nodeB *cmd.Node) *ConnectionBridge {
 		disabled:          false,
 		newPeerChan:       make(chan *lib.Peer),
 		connectionAttempt: 0,
+		rng:               rng,
+		reconnectEvents:   make(chan ReconnectEvent, reconnectEventsBufferSize),
+		pauseBufferLimit:  defaultPauseBufferLimit,
+	}
+	// Tear down the bridge when the test finishes, so that its goroutines don't outlive the test. The cleanup runs
+	// before the nodes are stopped, since they were tracked first.
+	if t != nil {
+		t.Cleanup(func() {
+			bridge.SetAutoReconnect(0, 0)
+			bridge.Disconnect()
+		})
 	}
 	return bridge
 }
 
 // createInboundConnection will initialize the inbound connection (inbound peer) to the provided node.
 // It doesn't initiate a version/verack exchange yet, just creates the connection object.
-func (bridge *ConnectionBridge) createInboundConnection(node *cmd.Node) *lib.Peer {
-	// Get the localhost network address of to the provided node.
-	port := node.Config.ProtocolPort
-	addr := "127.0.0.1:" + strconv.Itoa(int(port))
-	netAddress, err := lib.IPToNetAddr(addr, addrmgr.New("", net.LookupIP), &lib.DeSoMainnetParams)
-	if err != nil {
-		panic(err)
-	}
-	netAddress2 := net.TCPAddr{
-		IP:   netAddress.IP,
-		Port: int(netAddress.Port),
+func (bridge *ConnectionBridge) createInboundConnection(node *cmd.Node) (*lib.Peer, error) {
+	// Dial/connect to the node, on the first of its addresses that accepts the connection.
+	var conn net.Conn
+	var netAddress *wire.NetAddress
+	var err error
+	for _, addr := range nodeDialAddrs(node) {
+		netAddress, err = lib.IPToNetAddr(addr, addrmgr.New("", net.LookupIP), &lib.DeSoMainnetParams)
+		if err != nil {
+			return nil, err
+		}
+		netAddress2 := net.TCPAddr{
+			IP:   netAddress.IP,
+			Port: int(netAddress.Port),
+		}
+		conn, err = net.DialTimeout(netAddress2.Network(), netAddress2.String(), 4*lib.DeSoMainnetParams.DialTimeout)
+		if err == nil {
+			break
+		}
 	}
-	// Dial/connect to the node.
-	conn, err := net.DialTimeout(netAddress2.Network(), netAddress2.String(), 4*lib.DeSoMainnetParams.DialTimeout)
-	if err != nil {
-		panic(err)
+	if conn == nil {
+		return nil, fmt.Errorf("createInboundConnection: Problem dialing node on (%v): %v", nodeDialAddrs(node), err)
 	}
 
 	// This channel is redundant in our setting.
@@ -116,9 +496,39 @@ func (bridge *ConnectionBridge) createInboundConnection(node *cmd.Node) *lib.Pee
 	// Hence, we will mark the _isOutbound parameter as "true" in NewPeer.
 	peer := lib.NewPeer(conn, true, netAddress, true,
 		10000, 0, &lib.DeSoMainnetParams,
-		messagesFromPeer, nil, nil, lib.NodeSyncTypeAny)
+		messagesFromPeer, nil, nil, lib.NodeSyncTypeAny, lib.NewOffsetTimeSource(0))
 	peer.ID = uint64(lib.RandInt64(math.MaxInt64))
-	return peer
+	return peer, nil
+}
+
+// nodeDialAddrs returns the addresses on which the node accepts connections, loopback addresses first. Unspecified
+// IPs, which the node listens on when Config.ListenAddrs has an address without a host, are dialed on the loopback
+// address of the same family. Before the node is started, the localhost address on its configured port is returned.
+func nodeDialAddrs(node *cmd.Node) []string {
+	var loopbackAddrs, otherAddrs []string
+	for _, addr := range node.ListenAddrs() {
+		tcpAddr, ok := addr.(*net.TCPAddr)
+		if !ok {
+			continue
+		}
+		ip := tcpAddr.IP
+		if ip.IsUnspecified() {
+			ip = net.IPv6loopback
+			if ip4 := tcpAddr.IP.To4(); ip4 != nil {
+				ip = net.IPv4(127, 0, 0, 1)
+			}
+		}
+		dialAddr := net.JoinHostPort(ip.String(), strconv.Itoa(tcpAddr.Port))
+		if ip.IsLoopback() {
+			loopbackAddrs = append(loopbackAddrs, dialAddr)
+		} else {
+			otherAddrs = append(otherAddrs, dialAddr)
+		}
+	}
+	if len(loopbackAddrs)+len(otherAddrs) == 0 {
+		return []string{"127.0.0.1:" + strconv.Itoa(int(node.ListeningPort()))}
+	}
+	return append(loopbackAddrs, otherAddrs...)
 }
 
 // createOutboundConnection will initialize an outbound connection from the provided node.
@@ -144,7 +554,7 @@ func (bridge *ConnectionBridge) createOutboundConnection(node *cmd.Node, otherNo
 		messagesFromPeer := make(chan *lib.ServerMessage)
 		peer := lib.NewPeer(conn, false, na, false,
 			10000, 0, bridge.nodeB.Params,
-			messagesFromPeer, nil, nil, lib.NodeSyncTypeAny)
+			messagesFromPeer, nil, nil, lib.NodeSyncTypeAny, lib.NewOffsetTimeSource(0))
 		peer.ID = uint64(lib.RandInt64(math.MaxInt64))
 		bridge.newPeerChan <- peer
 		//}
@@ -167,7 +577,9 @@ func (bridge *ConnectionBridge) getVersionMessage(node *cmd.Node) *lib.MsgDeSoVe
 	if node.Config.HyperSync {
 		ver.Services |= lib.SFHyperSync
 	}
-	if lib.IsNodeArchival(node.Config.SyncType) {
+	if node.Config.PruneBlocksOlderThan > 0 {
+		ver.Services |= lib.SFPrunedNode
+	} else if lib.IsNodeArchival(node.Config.SyncType) {
 		ver.Services |= lib.SFArchivalNode
 	}
 
@@ -183,6 +595,39 @@ func (bridge *ConnectionBridge) getVersionMessage(node *cmd.Node) *lib.MsgDeSoVe
 func (bridge *ConnectionBridge) startConnection(connection *lib.Peer, otherNode *cmd.Node) error {
 	// Prepare the version message.
 	versionMessage := bridge.getVersionMessage(otherNode)
+
+	// Record the version message so that the handshake can be replayed with the same node capabilities.
+	direction := DirectionAToB
+	if connection == bridge.connectionInboundA || connection == bridge.connectionOutboundA {
+		direction = DirectionBToA
+	}
+	isOutbound := connection == bridge.connectionOutboundA || connection == bridge.connectionOutboundB
+
+	bridge.mtx.RLock()
+	versionHook := bridge.versionHook
+	bridge.mtx.RUnlock()
+	if versionHook != nil {
+		versionHook(versionMessage, direction)
+	}
+	bridge.recordMessage(versionMessage, direction, isOutbound)
+
+	return bridge.startConnectionWithVersion(connection, versionMessage)
+}
+
+// startConnectionWithVersion performs the version and verack exchange with the provided connection, introducing
+// ourselves with the provided version message.
+func (bridge *ConnectionBridge) startConnectionWithVersion(connection *lib.Peer, versionMessage *lib.MsgDeSoVersion) error {
+	bridge.mtx.RLock()
+	handshakeDelay := bridge.handshakeDelay
+	failHandshake := bridge.failHandshake
+	bridge.mtx.RUnlock()
+	if failHandshake {
+		return bridge.stallHandshake(connection)
+	}
+	if handshakeDelay > 0 {
+		time.Sleep(handshakeDelay)
+	}
+
 	connection.VersionNonceSent = versionMessage.Nonce
 
 	// Send the version message.
@@ -203,6 +648,7 @@ func (bridge *ConnectionBridge) startConnection(connection *lib.Peer, otherNode
 			if !ok {
 				return err
 			}
+			bridge.observeMessage(verMsg, bridge.senderDirection(connection))
 
 			connection.VersionNonceReceived = verMsg.Nonce
 			connection.TimeConnected = time.Unix(verMsg.TstampSecs, 0)
@@ -233,6 +679,7 @@ func (bridge *ConnectionBridge) startConnection(connection *lib.Peer, otherNode
 			if msg.GetMsgType() != lib.MsgTypeVerack {
 				return fmt.Errorf("message is not verack! Type: %v", msg.GetMsgType())
 			}
+			bridge.observeMessage(msg, bridge.senderDirection(connection))
 			verackMsg := msg.(*lib.MsgDeSoVerack)
 			if verackMsg.Nonce != connection.VersionNonceSent {
 				return fmt.Errorf("verack message nonce doesn't match (received: %v, sent: %v)",
@@ -248,19 +695,721 @@ func (bridge *ConnectionBridge) startConnection(connection *lib.Peer, otherNode
 	return nil
 }
 
+// stallHandshake keeps the connection open without ever sending a version message, until the node gives up on the
+// version negotiation and closes the connection.
+func (bridge *ConnectionBridge) stallHandshake(connection *lib.Peer) error {
+	for {
+		if _, err := connection.ReadDeSoMessage(); err != nil {
+			return fmt.Errorf("stallHandshake: Node closed the connection during a failed handshake: %v", err)
+		}
+	}
+}
+
+// DelayHandshake makes the bridge wait for the provided duration before introducing itself to the nodes with a
+// version message, which simulates a slow version negotiation. Passing a zero duration disables the delay. The delay
+// applies to every handshake performed by Start, so it should be set before starting the bridge.
+func (bridge *ConnectionBridge) DelayHandshake(delay time.Duration) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	bridge.handshakeDelay = delay
+}
+
+// FailHandshake makes the bridge open connections to the nodes, but never complete the version negotiation. The
+// connections are kept open until the nodes time them out, at which point Start returns an error. This can be used to
+// check that a node doesn't leak connection slots to peers stuck in the handshake.
+func (bridge *ConnectionBridge) FailHandshake() {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	bridge.failHandshake = true
+}
+
+// ResetHandshake restores the normal version negotiation after DelayHandshake or FailHandshake.
+func (bridge *ConnectionBridge) ResetHandshake() {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	bridge.handshakeDelay = 0
+	bridge.failHandshake = false
+}
+
+// Pause makes the bridge stop delivering messages, without disconnecting the nodes. Messages sent by the nodes while
+// the bridge is paused are held, up to the limit set with SetPauseBufferLimit, and delivered in order once the bridge
+// is resumed. Pausing an already paused bridge does nothing.
+func (bridge *ConnectionBridge) Pause() {
+	bridge.pauseDirection(DirectionAToB)
+	bridge.pauseDirection(DirectionBToA)
+}
+
+// Resume makes a paused bridge deliver all held messages, as a single burst, and continue relaying messages as usual.
+// Resume also undoes StallReads.
+func (bridge *ConnectionBridge) Resume() {
+	bridge.resumeDirection(DirectionAToB)
+	bridge.resumeDirection(DirectionBToA)
+}
+
+// StallReads makes the bridge stop delivering messages to the node, while the connections stay open and messages
+// sent by the node are still delivered to the other end. This simulates a peer that accepted the connection, but
+// stopped responding, which the node should detect with its stall timeout. Messages to the node are held like on a
+// paused bridge, and delivered once ResumeReads is called.
+func (bridge *ConnectionBridge) StallReads(node *cmd.Node) error {
+	direction, err := bridge.getDirectionTo(node)
+	if err != nil {
+		return err
+	}
+	bridge.pauseDirection(direction)
+	return nil
+}
+
+// ResumeReads makes the bridge deliver all messages held by StallReads to the node, and continue relaying messages
+// to the node as usual.
+func (bridge *ConnectionBridge) ResumeReads(node *cmd.Node) error {
+	direction, err := bridge.getDirectionTo(node)
+	if err != nil {
+		return err
+	}
+	bridge.resumeDirection(direction)
+	return nil
+}
+
+func (bridge *ConnectionBridge) pauseDirection(direction Direction) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	if bridge.resumeChans == nil {
+		bridge.resumeChans = make(map[Direction]chan struct{})
+	}
+	if bridge.resumeChans[direction] == nil {
+		bridge.resumeChans[direction] = make(chan struct{})
+	}
+}
+
+func (bridge *ConnectionBridge) resumeDirection(direction Direction) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	if resumeChan := bridge.resumeChans[direction]; resumeChan != nil {
+		close(resumeChan)
+		delete(bridge.resumeChans, direction)
+	}
+}
+
+// getResumeChan returns the channel that will be closed once the direction is resumed, or nil if the direction
+// isn't paused.
+func (bridge *ConnectionBridge) getResumeChan(direction Direction) chan struct{} {
+	bridge.mtx.RLock()
+	defer bridge.mtx.RUnlock()
+	return bridge.resumeChans[direction]
+}
+
+// SetPauseBufferLimit sets how many messages each direction of each connection holds while the bridge is paused, and
+// what happens once the limit is reached. By default, the bridge holds up to 10000 messages per link and then blocks.
+func (bridge *ConnectionBridge) SetPauseBufferLimit(limit int, overflow PauseOverflow) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	bridge.pauseBufferLimit = limit
+	bridge.pauseOverflow = overflow
+}
+
+// SetLatency makes the bridge delay every relayed message by a random duration between minLatency and maxLatency.
+// Delays are drawn independently for each message in each direction, but messages are still delivered in order.
+// It is safe to call SetLatency while the bridge is running. Passing zero durations disables the latency.
+func (bridge *ConnectionBridge) SetLatency(minLatency time.Duration, maxLatency time.Duration) {
+	if maxLatency < minLatency {
+		maxLatency = minLatency
+	}
+
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	bridge.minLatency = minLatency
+	bridge.maxLatency = maxLatency
+}
+
+// SetBandwidthLimit limits the throughput of the bridge to bytesPerSecond in each direction. The limit is enforced
+// with a token bucket metering the serialized size of relayed messages, so large messages such as snapshot chunks
+// are delayed proportionally to their size rather than dropped. It is safe to call SetBandwidthLimit while the bridge
+// is running. Passing zero removes the limit.
+func (bridge *ConnectionBridge) SetBandwidthLimit(bytesPerSecond uint64) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+
+	if bytesPerSecond == 0 {
+		bridge.bandwidthBuckets = nil
+		return
+	}
+	// We allow bursts of up to one second worth of traffic.
+	bridge.bandwidthBuckets = map[Direction]*tokenBucket{
+		DirectionAToB: newTokenBucket(float64(bytesPerSecond), float64(bytesPerSecond)),
+		DirectionBToA: newTokenBucket(float64(bytesPerSecond), float64(bytesPerSecond)),
+	}
+}
+
+// SetMessageTypeRate limits the number of messages of msgType relayed by the bridge to perSecond messages per second
+// on each connection, while all other message types remain unthrottled. Messages over the limit are queued rather
+// than dropped, and the current queue depths are reported in Stats().QueueDepths. It is safe to call
+// SetMessageTypeRate while the bridge is running. Passing a zero rate removes the limit.
+func (bridge *ConnectionBridge) SetMessageTypeRate(msgType lib.MsgType, perSecond float64) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+
+	if bridge.messageTypeRates == nil {
+		bridge.messageTypeRates = make(map[lib.MsgType]float64)
+	}
+	if perSecond <= 0 {
+		delete(bridge.messageTypeRates, msgType)
+		return
+	}
+	bridge.messageTypeRates[msgType] = perSecond
+}
+
+func (bridge *ConnectionBridge) getMessageTypeRate(msgType lib.MsgType) float64 {
+	bridge.mtx.RLock()
+	defer bridge.mtx.RUnlock()
+	return bridge.messageTypeRates[msgType]
+}
+
+func (bridge *ConnectionBridge) adjustQueueDepth(msgType lib.MsgType, delta int) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+
+	if bridge.stats.QueueDepths == nil {
+		bridge.stats.QueueDepths = make(map[lib.MsgType]uint64)
+	}
+	bridge.stats.QueueDepths[msgType] = uint64(int(bridge.stats.QueueDepths[msgType]) + delta)
+}
+
+// getThrottle returns the queue of the message's type on the link, if the message type is rate limited. Once a
+// message type was rate limited on a link, its messages keep going through the queue even after the limit is
+// removed, so that they aren't delivered out of order.
+func (bridge *ConnectionBridge) getThrottle(link *bridgeLink, msgType lib.MsgType) chan *bridgeMessage {
+	if throttle, exists := link.throttles[msgType]; exists {
+		return throttle
+	}
+	if bridge.getMessageTypeRate(msgType) == 0 {
+		return nil
+	}
+
+	throttle := make(chan *bridgeMessage, bridgeDeliveryQueueSize)
+	link.throttles[msgType] = throttle
+	go bridge.paceTraffic(link, msgType, throttle)
+	return throttle
+}
+
+// paceTraffic moves the messages from the throttle queue to the link's delivery queue, no faster than the rate limit
+// of the message type.
+func (bridge *ConnectionBridge) paceTraffic(link *bridgeLink, msgType lib.MsgType, throttle chan *bridgeMessage) {
+	// Once the link exits, the queued messages will never be delivered.
+	defer func() {
+		for {
+			select {
+			case <-throttle:
+				bridge.adjustQueueDepth(msgType, -1)
+			default:
+				return
+			}
+		}
+	}()
+
+	var lastRelease time.Time
+	for {
+		var bridgeMsg *bridgeMessage
+		select {
+		case <-link.exitChan:
+			return
+		case bridgeMsg = <-throttle:
+		}
+
+		if rate := bridge.getMessageTypeRate(msgType); rate > 0 {
+			interval := time.Duration(float64(time.Second) / rate)
+			if delay := time.Until(lastRelease.Add(interval)); delay > 0 {
+				select {
+				case <-link.exitChan:
+					bridge.adjustQueueDepth(msgType, -1)
+					return
+				case <-time.After(delay):
+				}
+			}
+		}
+		lastRelease = time.Now()
+		bridge.adjustQueueDepth(msgType, -1)
+
+		// The latency and bandwidth limits apply once the message leaves the queue.
+		bridgeMsg.deliverAt = bridge.getDeliveryTime(bridgeMsg.msg, link.direction)
+		// If the delivery stopped, we drop the message, but keep draining the queue until routeTraffic exits.
+		select {
+		case link.deliveryQueue <- bridgeMsg:
+		case <-link.deliveryDoneChan:
+		case <-link.exitChan:
+			return
+		}
+	}
+}
+
+// SetRandomSeed re-seeds the random number generator used by the bridge, so that random decisions such as
+// latencies, drops, and reorderings are reproducible.
+func (bridge *ConnectionBridge) SetRandomSeed(seed int64) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	bridge.rng = rand.New(rand.NewSource(seed))
+}
+
+// EnableReordering makes the bridge buffer up to windowSize consecutive messages and deliver them in a random order.
+// If any msgTypes are passed, only messages of these types are reordered, and all other messages are delivered in
+// order, with every buffered message delivered before them. Reordering only shuffles messages within the same
+// connection, so messages are never moved across the ordering guarantees of the transport. It is safe to call
+// EnableReordering while the bridge is running.
+func (bridge *ConnectionBridge) EnableReordering(windowSize int, msgTypes ...lib.MsgType) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	bridge.reorderWindowSize = windowSize
+	bridge.reorderMsgTypes = newMessageTypeSet(msgTypes)
+}
+
+// DisableReordering makes the bridge deliver all messages in order again.
+func (bridge *ConnectionBridge) DisableReordering() {
+	bridge.EnableReordering(0)
+}
+
+// getReorderWindow returns the current reordering window size, or zero if the message shouldn't be reordered.
+func (bridge *ConnectionBridge) getReorderWindow(msg lib.DeSoMessage) int {
+	bridge.mtx.RLock()
+	defer bridge.mtx.RUnlock()
+
+	if bridge.reorderWindowSize <= 1 || !bridge.reorderMsgTypes.matches(msg.GetMsgType()) {
+		return 0
+	}
+	return bridge.reorderWindowSize
+}
+
+// randomInt63n returns a random number in [0, n) from the bridge's random number generator.
+func (bridge *ConnectionBridge) randomInt63n(n int64) int64 {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	return bridge.rng.Int63n(n)
+}
+
+// getLatency returns a random delay within the bridge's latency range.
+func (bridge *ConnectionBridge) getLatency() time.Duration {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+
+	latency := bridge.minLatency
+	if bridge.maxLatency > bridge.minLatency {
+		latency += time.Duration(bridge.rng.Int63n(int64(bridge.maxLatency - bridge.minLatency)))
+	}
+	return latency
+}
+
+// getBandwidthDelay returns how long the message has to wait before it fits in the bandwidth limit of the provided
+// direction.
+func (bridge *ConnectionBridge) getBandwidthDelay(msg lib.DeSoMessage, direction Direction, now time.Time) time.Duration {
+	bridge.mtx.RLock()
+	limited := bridge.bandwidthBuckets != nil
+	bridge.mtx.RUnlock()
+	if !limited {
+		return 0
+	}
+
+	payload, err := msg.ToBytes(false)
+	if err != nil {
+		glog.Errorf("getBandwidthDelay: Problem serializing message of type (%v): %v", msg.GetMsgType(), err)
+		return 0
+	}
+
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	bucket, exists := bridge.bandwidthBuckets[direction]
+	if !exists {
+		return 0
+	}
+	return bucket.reserve(float64(len(payload)), now)
+}
+
+// getDeliveryTime computes when the message read from a link with the provided direction should be delivered.
+func (bridge *ConnectionBridge) getDeliveryTime(msg lib.DeSoMessage, direction Direction) time.Time {
+	now := time.Now()
+	return now.Add(bridge.getLatency() + bridge.getBandwidthDelay(msg, direction, now))
+}
+
+// BlockMessageType makes the bridge stop relaying messages of msgType in both directions, as if they were sent into
+// a black hole. Blocked messages are counted in Stats().BlockedMessages. It is safe to call BlockMessageType while
+// the bridge is running.
+func (bridge *ConnectionBridge) BlockMessageType(msgType lib.MsgType) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+
+	if bridge.blockedMsgTypes == nil {
+		bridge.blockedMsgTypes = make(map[lib.MsgType]bool)
+	}
+	bridge.blockedMsgTypes[msgType] = true
+}
+
+// UnblockMessageType makes the bridge relay messages of msgType again. Messages blocked in the meantime are not
+// redelivered.
+func (bridge *ConnectionBridge) UnblockMessageType(msgType lib.MsgType) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	delete(bridge.blockedMsgTypes, msgType)
+}
+
+// isBlocked decides whether the message should be blackholed, and counts it if so.
+func (bridge *ConnectionBridge) isBlocked(msg lib.DeSoMessage) bool {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+
+	if !bridge.blockedMsgTypes[msg.GetMsgType()] {
+		return false
+	}
+	if bridge.stats.BlockedMessages == nil {
+		bridge.stats.BlockedMessages = make(map[lib.MsgType]uint64)
+	}
+	bridge.stats.BlockedMessages[msg.GetMsgType()]++
+	return true
+}
+
+// SetDropRate makes the bridge silently drop the provided fraction of relayed messages. If any msgTypes are passed,
+// only messages of these types will be dropped, e.g. SetDropRate(0.05, lib.MsgTypeBlock) drops 5% of blocks. It is
+// safe to call SetDropRate while the bridge is running. Passing a zero rate disables message loss.
+func (bridge *ConnectionBridge) SetDropRate(rate float64, msgTypes ...lib.MsgType) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	bridge.dropRate = rate
+	bridge.dropMsgTypes = newMessageTypeSet(msgTypes)
+}
+
+// shouldDrop decides whether the message should be dropped due to the simulated message loss.
+func (bridge *ConnectionBridge) shouldDrop(msg lib.DeSoMessage) bool {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+
+	if bridge.dropRate <= 0 || !bridge.dropMsgTypes.matches(msg.GetMsgType()) {
+		return false
+	}
+	if bridge.rng.Float64() >= bridge.dropRate {
+		return false
+	}
+	bridge.stats.DroppedMessages++
+	return true
+}
+
+// SetDuplicateRate makes the bridge deliver the provided fraction of relayed messages twice. The copy is delivered
+// delay after the original message was received by the bridge, so other messages can be delivered in between. If any
+// msgTypes are passed, only messages of these types will be duplicated, e.g. SetDuplicateRate(0.1, time.Second,
+// lib.MsgTypeSnapshotData) duplicates 10% of snapshot chunks. It is safe to call SetDuplicateRate while the bridge
+// is running. Passing a zero rate disables message duplication.
+func (bridge *ConnectionBridge) SetDuplicateRate(rate float64, delay time.Duration, msgTypes ...lib.MsgType) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	bridge.duplicateRate = rate
+	bridge.duplicateDelay = delay
+	bridge.duplicateMsgTypes = newMessageTypeSet(msgTypes)
+}
+
+// shouldDuplicate decides whether the message should be duplicated, and if so, returns the delay of the copy.
+func (bridge *ConnectionBridge) shouldDuplicate(msg lib.DeSoMessage) (time.Duration, bool) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+
+	if bridge.duplicateRate <= 0 || !bridge.duplicateMsgTypes.matches(msg.GetMsgType()) {
+		return 0, false
+	}
+	if bridge.rng.Float64() >= bridge.duplicateRate {
+		return 0, false
+	}
+	bridge.stats.DuplicatedMessages++
+	return bridge.duplicateDelay, true
+}
+
+// scheduleDuplicate queues a copy of the message for delivery on the link after the delay. The copy is
+// dropped if the link exits in the meantime.
+func (bridge *ConnectionBridge) scheduleDuplicate(link *bridgeLink, msg lib.DeSoMessage, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		if link.exited() {
+			return
+		}
+		select {
+		case link.deliveryQueue <- &bridgeMessage{
+			msg:       msg,
+			deliverAt: bridge.getDeliveryTime(msg, link.direction),
+		}:
+		case <-link.deliveryDoneChan:
+		case <-link.exitChan:
+		}
+	})
+}
+
+// InjectMessage delivers a hand-crafted message to the node, as if it was sent by the node on the other end of the
+// bridge. The message arrives on the node's outbound connection, i.e. from a peer that the node connected to itself.
+// Injected messages go through the same delivery queue as relayed messages, so they are subject to the bridge's
+// latency and reordering settings, and they show up in Stats(). Injected messages are never dropped, blocked or
+// passed to the message hook.
+func (bridge *ConnectionBridge) InjectMessage(to *cmd.Node, msg lib.DeSoMessage) error {
+	var direction Direction
+	switch to {
+	case bridge.nodeA:
+		direction = DirectionBToA
+	case bridge.nodeB:
+		direction = DirectionAToB
+	default:
+		return fmt.Errorf("InjectMessage: Node on port (%v) isn't bridged", to.ListeningPort())
+	}
+
+	bridge.mtx.Lock()
+	var link *bridgeLink
+	for _, bridgeLink := range bridge.links {
+		if bridgeLink.direction == direction && bridgeLink.toOutbound {
+			link = bridgeLink
+		}
+	}
+	if link == nil || bridge.disabled || link.exited() {
+		bridge.mtx.Unlock()
+		return fmt.Errorf("InjectMessage: Bridge is not running")
+	}
+	bridge.stats.InjectedMessages++
+	scheduler := bridge.scheduler
+	bridge.mtx.Unlock()
+
+	if scheduler != nil {
+		scheduler.enqueue(bridge, link, &bridgeMessage{msg: msg})
+		return nil
+	}
+	select {
+	case link.deliveryQueue <- &bridgeMessage{
+		msg:       msg,
+		deliverAt: bridge.getDeliveryTime(msg, direction),
+	}:
+		return nil
+	case <-link.deliveryDoneChan:
+		return fmt.Errorf("InjectMessage: Bridge stopped before the message could be queued")
+	}
+}
+
+// SetMessageHook installs a hook that can inspect, modify, or drop every message relayed by the bridge. Hooks are
+// called synchronously, one message at a time, in the order in which messages are relayed. There can be only one
+// hook installed at a time. The hook can be replaced or removed, by passing nil, while the bridge is running.
+func (bridge *ConnectionBridge) SetMessageHook(hook MessageHook) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	bridge.messageHook = hook
+}
+
+// messageObserver is notified of a message sent by one of the bridged nodes, before the bridge decides what to do
+// with the message.
+type messageObserver func(msg lib.DeSoMessage, direction Direction)
+
+// addObserver registers an observer of all messages sent by the bridged nodes.
+func (bridge *ConnectionBridge) addObserver(observer messageObserver) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	bridge.observers = append(bridge.observers, observer)
+}
+
+// observeMessage notifies all observers of a message sent by one of the bridged nodes.
+func (bridge *ConnectionBridge) observeMessage(msg lib.DeSoMessage, direction Direction) {
+	bridge.mtx.RLock()
+	observers := bridge.observers
+	bridge.mtx.RUnlock()
+	for _, observer := range observers {
+		observer(msg, direction)
+	}
+}
+
+// senderDirection returns the direction of the messages that a node sends to the provided connection.
+func (bridge *ConnectionBridge) senderDirection(connection *lib.Peer) Direction {
+	if connection == bridge.connectionInboundA || connection == bridge.connectionOutboundA {
+		return DirectionAToB
+	}
+	return DirectionBToA
+}
+
+// applyMessageHook runs the message through the bridge's message hook, if one is installed.
+func (bridge *ConnectionBridge) applyMessageHook(msg lib.DeSoMessage, direction Direction) (lib.DeSoMessage, bool) {
+	bridge.mtx.RLock()
+	hook := bridge.messageHook
+	bridge.mtx.RUnlock()
+	if hook == nil {
+		return msg, true
+	}
+
+	bridge.hookMtx.Lock()
+	defer bridge.hookMtx.Unlock()
+	return hook(msg, direction)
+}
+
+// StartRecording makes the bridge append every relayed message to the recording file at the provided path. The
+// recording can be read with ReadBridgeRecording, or fed back into a node with a ReplayBridge. Recording should
+// normally be started before the bridge is started, so that the handshakes are recorded as well.
+func (bridge *ConnectionBridge) StartRecording(path string) error {
+	recorder, err := newBridgeRecorder(path)
+	if err != nil {
+		return err
+	}
+
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	if bridge.recorder != nil {
+		recorder.close()
+		return fmt.Errorf("StartRecording: Bridge is already recording")
+	}
+	bridge.recorder = recorder
+	return nil
+}
+
+// StopRecording stops recording relayed messages and closes the recording file.
+func (bridge *ConnectionBridge) StopRecording() error {
+	bridge.mtx.Lock()
+	recorder := bridge.recorder
+	bridge.recorder = nil
+	bridge.mtx.Unlock()
+
+	if recorder == nil {
+		return nil
+	}
+	return recorder.close()
+}
+
+// recordMessage appends the message to the bridge's recording, if recording is enabled.
+func (bridge *ConnectionBridge) recordMessage(msg lib.DeSoMessage, direction Direction, toOutbound bool) {
+	bridge.mtx.RLock()
+	recorder := bridge.recorder
+	bridge.mtx.RUnlock()
+	if recorder == nil {
+		return
+	}
+
+	if err := recorder.record(msg, direction, toOutbound); err != nil {
+		glog.Errorf("recordMessage: %v", err)
+	}
+}
+
+// CorruptNextMessage makes the bridge corrupt the next relayed message of msgType, in either direction. The message
+// is serialized in the wire format and passed to mutate, and the returned bytes are sent instead of the message.
+// TruncateMessage, FlipRandomBits, and InflateLengthField provide common mutations. Multiple corruptions of the same
+// message type are applied to consecutive messages in the order in which they were scheduled.
+func (bridge *ConnectionBridge) CorruptNextMessage(msgType lib.MsgType, mutate MessageMutation) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	bridge.pendingCorruptions = append(bridge.pendingCorruptions, &messageCorruption{
+		msgType: msgType,
+		mutate:  mutate,
+	})
+}
+
+// takeCorruption returns the mutation scheduled for the message, if any, and removes it from the pending corruptions.
+func (bridge *ConnectionBridge) takeCorruption(msg lib.DeSoMessage) MessageMutation {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+
+	for ii, corruption := range bridge.pendingCorruptions {
+		if corruption.msgType != msg.GetMsgType() {
+			continue
+		}
+		bridge.pendingCorruptions = append(bridge.pendingCorruptions[:ii], bridge.pendingCorruptions[ii+1:]...)
+		bridge.stats.CorruptedMessages++
+		return corruption.mutate
+	}
+	return nil
+}
+
+// writeCorruptedMessage serializes the message, corrupts it with mutate, and writes the result to the connection.
+// Returns the number of bytes written.
+func writeCorruptedMessage(connection *lib.Peer, msg lib.DeSoMessage, mutate MessageMutation) (uint64, error) {
+	frame, err := serializeMessage(msg, connection.Params.NetworkType)
+	if err != nil {
+		return 0, err
+	}
+	written, err := connection.Conn.Write(mutate(frame))
+	return uint64(written), err
+}
+
+// DisconnectAfterMessages arms a counter that disconnects the bridge once it delivered count more messages of msgType,
+// in either direction. No messages are delivered after the last counted message. The returned channel is closed
+// once the bridge was disconnected, so the test can proceed, e.g. with restartAndReconnectNode. If automatic
+// reconnects are enabled, the bridge will then reconnect, as after any other Disconnect.
+func (bridge *ConnectionBridge) DisconnectAfterMessages(msgType lib.MsgType, count int) <-chan struct{} {
+	trigger := &disconnectTrigger{
+		msgType:   msgType,
+		remaining: count,
+		firedChan: make(chan struct{}),
+	}
+	if count <= 0 {
+		go bridge.fireDisconnectTrigger(trigger)
+		return trigger.firedChan
+	}
+
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	bridge.disconnectTriggers = append(bridge.disconnectTriggers, trigger)
+	return trigger.firedChan
+}
+
+// countTowardsDisconnect counts the delivered message towards the armed disconnect triggers, and fires the triggers
+// that reached their count.
+func (bridge *ConnectionBridge) countTowardsDisconnect(msgType lib.MsgType) {
+	bridge.mtx.Lock()
+	var firedTriggers []*disconnectTrigger
+	var armedTriggers []*disconnectTrigger
+	for _, trigger := range bridge.disconnectTriggers {
+		if trigger.msgType == msgType {
+			trigger.remaining--
+		}
+		if trigger.remaining <= 0 {
+			firedTriggers = append(firedTriggers, trigger)
+		} else {
+			armedTriggers = append(armedTriggers, trigger)
+		}
+	}
+	bridge.disconnectTriggers = armedTriggers
+	bridge.mtx.Unlock()
+
+	if len(firedTriggers) == 0 {
+		return
+	}
+	// Stop relaying right away, so that no more messages are delivered, and tear down the connections in the
+	// background, since Disconnect waits for the links, including the one we're called from.
+	bridge.disconnectDirection(DirectionAToB)
+	bridge.disconnectDirection(DirectionBToA)
+	for _, trigger := range firedTriggers {
+		go bridge.fireDisconnectTrigger(trigger)
+	}
+}
+
+func (bridge *ConnectionBridge) fireDisconnectTrigger(trigger *disconnectTrigger) {
+	bridge.Disconnect()
+	close(trigger.firedChan)
+}
+
+// Stats returns a snapshot of the bridge's traffic counters. The counters are updated as messages are delivered,
+// and the snapshot is consistent, i.e. it never contains a partially counted message.
+func (bridge *ConnectionBridge) Stats() BridgeStats {
+	bridge.mtx.RLock()
+	defer bridge.mtx.RUnlock()
+	return bridge.stats.copy()
+}
+
+// ResetStats zeroes all of the bridge's traffic counters, which is useful to measure individual phases of a test.
+func (bridge *ConnectionBridge) ResetStats() {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	bridge.stats = BridgeStats{}
+}
+
+// countDeliveredMessage adds the message to the bridge's traffic counters.
+func (bridge *ConnectionBridge) countDeliveredMessage(direction Direction, msgType lib.MsgType, size uint64) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	bridge.stats.addMessage(direction, msgType, size)
+}
+
 // routeTraffic routes all messages sent to the source connection and redirects it to the destination connection.
-// This communication tunnel is one-directional, so normally we would also call routeTraffic(destination, source)
+// This communication tunnel is one-directional, so normally we would also call routeTraffic on the reverse link
 // to make it bidirectional.
-func (bridge *ConnectionBridge) routeTraffic(source *lib.Peer, destination *lib.Peer) {
+func (bridge *ConnectionBridge) routeTraffic(link *bridgeLink) {
+	source := link.source
+	destination := link.destination
+
 	bridge.waitGroup.Add(1)
+	go bridge.deliverTraffic(link)
 	for {
 		if bridge.disabled {
 			break
 		}
-		if bridge.paused {
-			time.Sleep(100 * time.Millisecond)
-			continue
-		}
 
 		// Retrieve a message from the source connection.
 		inMsg, err := source.ReadDeSoMessage()
@@ -270,9 +1419,12 @@ func (bridge *ConnectionBridge) routeTraffic(source *lib.Peer, destination *lib.
 		if err != nil {
 			fmt.Printf("routeTraffic: Peer disconnected with source: (%v), destination: (%v)",
 				source.Conn.LocalAddr().String(), destination.Conn.LocalAddr().String())
-			bridge.Restart()
+			close(link.exitChan)
+			bridge.waitGroup.Done()
+			bridge.handleLinkFailure()
 			return
 		}
+		bridge.observeMessage(inMsg, link.direction)
 		//fmt.Printf("Reading message: type: (%v) at source with local addr: (%v) and remote addr: (%v)\n",
 		//	/*inMsg, */ inMsg.GetMsgType(), source.Conn.LocalAddr().String(), source.Conn.RemoteAddr().String())
 		switch inMsg.(type) {
@@ -280,52 +1432,310 @@ func (bridge *ConnectionBridge) routeTraffic(source *lib.Peer, destination *lib.
 		case *lib.MsgDeSoGetAddr:
 			continue
 		default:
-			// Send the message to the destination connection.
-			//fmt.Printf("Redirecting the message: type: (%v) to destination with local addr: (%v) and remote addr: (%v)\n",
-			//	/*inMsg, */ inMsg.GetMsgType(), destination.Conn.LocalAddr().String(), destination.Conn.RemoteAddr().String())
-			if err := destination.WriteDeSoMessage(inMsg); err != nil {
-				fmt.Printf("routeTraffic: Problem writing message to peer with source: (%v), destination: (%v), "+
-					"error: (%v), msg: (%v)", source.Conn.LocalAddr().String(), destination.Conn.LocalAddr().String(),
-					err, inMsg)
-				bridge.Restart()
-				return
+			// If the bridge was disconnected in this direction, the message will never arrive.
+			if bridge.isDirectionDisconnected(link.direction) {
+				continue
+			}
+			if bridge.isBlocked(inMsg) {
+				continue
+			}
+			var forward bool
+			if inMsg, forward = bridge.applyMessageHook(inMsg, link.direction); !forward || inMsg == nil {
+				continue
+			}
+			if bridge.shouldDrop(inMsg) {
+				continue
+			}
+			bridgeMsg := &bridgeMessage{
+				msg:    inMsg,
+				mutate: bridge.takeCorruption(inMsg),
+			}
+			// A scheduler takes care of the timing of the message on its own.
+			if scheduler := bridge.getScheduler(); scheduler != nil {
+				scheduler.enqueue(bridge, link, bridgeMsg)
+				continue
+			}
+			// Queue the message for delivery to the destination connection. Messages of rate limited types wait in
+			// their own queue first.
+			if throttle := bridge.getThrottle(link, inMsg.GetMsgType()); throttle != nil {
+				bridge.adjustQueueDepth(inMsg.GetMsgType(), 1)
+				select {
+				case throttle <- bridgeMsg:
+				case <-link.deliveryDoneChan:
+					bridge.adjustQueueDepth(inMsg.GetMsgType(), -1)
+				}
+			} else {
+				bridgeMsg.deliverAt = bridge.getDeliveryTime(inMsg, link.direction)
+				select {
+				case link.deliveryQueue <- bridgeMsg:
+				case <-link.deliveryDoneChan:
+				}
+			}
+			if delay, duplicate := bridge.shouldDuplicate(inMsg); duplicate {
+				bridge.scheduleDuplicate(link, inMsg, delay)
 			}
 		}
 	}
+	close(link.exitChan)
 	bridge.waitGroup.Done()
 }
 
+// reorderCollectTimeout is how long the bridge waits for more messages to fill the reordering window.
+const reorderCollectTimeout = 10 * time.Millisecond
+
+// deliverTraffic writes the messages queued by routeTraffic to the destination connection of the link. Messages are
+// delivered in the order in which they were read, each no earlier than its deliverAt time, unless reordering is
+// enabled on the bridge.
+func (bridge *ConnectionBridge) deliverTraffic(link *bridgeLink) {
+	source := link.source
+	destination := link.destination
+
+	defer close(link.deliveryDoneChan)
+	// window contains the messages taken off the delivery queue that haven't been delivered yet.
+	var window []*bridgeMessage
+	// held contains the messages taken off the delivery queue while the bridge was paused, in the order in which
+	// they were read. They are delivered before any other messages once the bridge is resumed.
+	var held []*bridgeMessage
+	for {
+		if resumeChan := bridge.getResumeChan(link.direction); resumeChan != nil && !bridge.disabled {
+			// Messages waiting in the reordering window are held as well, behind the messages held so far.
+			held = append(held, window...)
+			window = nil
+			var running bool
+			if held, running = bridge.holdPausedTraffic(link, held, resumeChan); !running {
+				return
+			}
+			continue
+		}
+
+		var bridgeMsg *bridgeMessage
+		if len(held) > 0 {
+			bridgeMsg, held = held[0], held[1:]
+		} else if window, bridgeMsg = bridge.nextMessage(link, window); bridgeMsg == nil {
+			return
+		}
+		// The bridge might have been paused while we were waiting for the message.
+		if bridge.getResumeChan(link.direction) != nil && !bridge.disabled {
+			held = append([]*bridgeMessage{bridgeMsg}, held...)
+			continue
+		}
+
+		if delay := time.Until(bridgeMsg.deliverAt); delay > 0 {
+			select {
+			case <-link.exitChan:
+				return
+			case <-time.After(delay):
+			}
+		}
+		// If the bridge was disconnected, drop the message. We keep draining the queue so that routeTraffic
+		// doesn't get stuck on a full delivery queue.
+		if bridge.disabled || link.exited() || bridge.isDirectionDisconnected(link.direction) {
+			continue
+		}
+
+		if err := bridge.writeMessage(link, bridgeMsg); err != nil {
+			if bridge.disabled || link.exited() {
+				continue
+			}
+			fmt.Printf("routeTraffic: Problem writing message to peer with source: (%v), destination: (%v), "+
+				"error: (%v), msg: (%v)", source.Conn.LocalAddr().String(), destination.Conn.LocalAddr().String(),
+				err, bridgeMsg.msg)
+			bridge.handleLinkFailure()
+			return
+		}
+	}
+}
+
+func (bridge *ConnectionBridge) getScheduler() *DeliveryScheduler {
+	bridge.mtx.RLock()
+	defer bridge.mtx.RUnlock()
+	return bridge.scheduler
+}
+
+// deliverScheduledMessage writes a message delivered by the bridge's scheduler to the destination connection of the
+// link, unless the bridge stopped relaying messages on the link in the meantime.
+func (bridge *ConnectionBridge) deliverScheduledMessage(link *bridgeLink, bridgeMsg *bridgeMessage) {
+	if bridge.disabled || link.exited() || bridge.isDirectionDisconnected(link.direction) {
+		return
+	}
+	if err := bridge.writeMessage(link, bridgeMsg); err != nil {
+		if bridge.disabled || link.exited() {
+			return
+		}
+		fmt.Printf("deliverScheduledMessage: Problem writing message to peer with destination: (%v), "+
+			"error: (%v), msg: (%v)", link.destination.Conn.LocalAddr().String(), err, bridgeMsg.msg)
+		bridge.handleLinkFailure()
+	}
+}
+
+// writeMessage sends the message to the destination connection of the link, and updates the traffic counters.
+func (bridge *ConnectionBridge) writeMessage(link *bridgeLink, bridgeMsg *bridgeMessage) error {
+	bridge.recordMessage(bridgeMsg.msg, link.direction, link.toOutbound)
+
+	// Send the message to the destination connection.
+	//fmt.Printf("Redirecting the message: type: (%v) to destination with local addr: (%v) and remote addr: (%v)\n",
+	//	/*inMsg, */ inMsg.GetMsgType(), destination.Conn.LocalAddr().String(), destination.Conn.RemoteAddr().String())
+	// We write the message with lib.WriteMessage rather than Peer.WriteDeSoMessage, because we need the payload
+	// for the traffic counters and we don't want to serialize large messages, e.g. snapshot chunks, twice.
+	destination := link.destination
+	var size uint64
+	var err error
+	if bridgeMsg.mutate != nil {
+		size, err = writeCorruptedMessage(destination, bridgeMsg.msg, bridgeMsg.mutate)
+	} else {
+		var payload []byte
+		payload, err = lib.WriteMessage(destination.Conn, bridgeMsg.msg, destination.Params.NetworkType)
+		size = uint64(len(payload))
+	}
+	if err != nil {
+		return err
+	}
+	bridge.countDeliveredMessage(link.direction, bridgeMsg.msg.GetMsgType(), size)
+	bridge.countTowardsDisconnect(bridgeMsg.msg.GetMsgType())
+	return nil
+}
+
+// holdPausedTraffic keeps taking messages off the link's delivery queue while the bridge is paused, appending them
+// to the held messages, until the bridge is resumed or disconnected. Returns false if the link has exited.
+func (bridge *ConnectionBridge) holdPausedTraffic(link *bridgeLink, held []*bridgeMessage,
+	resumeChan chan struct{}) (_held []*bridgeMessage, _running bool) {
+
+	// We periodically check whether the bridge was disconnected in the meantime, in which case we have to let the
+	// messages through, so that they are dropped and routeTraffic doesn't get stuck on a full delivery queue.
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		bridge.mtx.RLock()
+		limit := bridge.pauseBufferLimit
+		overflow := bridge.pauseOverflow
+		bridge.mtx.RUnlock()
+
+		deliveryQueue := link.deliveryQueue
+		if len(held) >= limit && overflow == PauseOverflowBlock {
+			deliveryQueue = nil
+		}
+		select {
+		case <-resumeChan:
+			return held, true
+		case <-link.exitChan:
+			return held, false
+		case <-ticker.C:
+			if bridge.disabled {
+				return held, true
+			}
+		case bridgeMsg := <-deliveryQueue:
+			if len(held) >= limit {
+				bridge.mtx.Lock()
+				bridge.stats.PauseDroppedMessages++
+				bridge.mtx.Unlock()
+				continue
+			}
+			held = append(held, bridgeMsg)
+		}
+	}
+}
+
+// nextMessage picks the next message that should be delivered on the link. Messages are taken off the link's delivery
+// queue into the window. If the oldest message in the window can be reordered, we try to fill the window with
+// more messages and then deliver a random message among the reorderable messages at the front of the window.
+// Otherwise, the oldest message is delivered. Returns a nil message if the link has exited.
+func (bridge *ConnectionBridge) nextMessage(link *bridgeLink, window []*bridgeMessage) (
+	_window []*bridgeMessage, _msg *bridgeMessage) {
+
+	if len(window) == 0 {
+		select {
+		case <-link.exitChan:
+			return nil, nil
+		case bridgeMsg := <-link.deliveryQueue:
+			window = append(window, bridgeMsg)
+		}
+	}
+
+	windowSize := bridge.getReorderWindow(window[0].msg)
+	if windowSize == 0 {
+		return window[1:], window[0]
+	}
+
+	// Collect more messages while they keep coming and can be reordered.
+	collectTimeout := time.After(reorderCollectTimeout)
+	for len(window) < windowSize && bridge.getReorderWindow(window[len(window)-1].msg) > 0 {
+		collected := false
+		select {
+		case <-link.exitChan:
+			return nil, nil
+		case bridgeMsg := <-link.deliveryQueue:
+			window = append(window, bridgeMsg)
+			collected = true
+		case <-collectTimeout:
+		}
+		if !collected {
+			break
+		}
+	}
+
+	// Find the reorderable messages at the front of the window and pick a random one.
+	reorderable := 0
+	for reorderable < len(window) && reorderable < windowSize && bridge.getReorderWindow(window[reorderable].msg) > 0 {
+		reorderable++
+	}
+	// Reordering might have been disabled in the meantime.
+	if reorderable == 0 {
+		return window[1:], window[0]
+	}
+	index := int(bridge.randomInt63n(int64(reorderable)))
+	bridgeMsg := window[index]
+	window = append(window[:index], window[index+1:]...)
+	return window, bridgeMsg
+}
+
 // waitForConnection will wait for 30 seconds to get a new connection, otherwise it will return an error.
 func (bridge *ConnectionBridge) waitForConnection() (*lib.Peer, error) {
-	timeoutTicker := time.NewTicker(30 * time.Second)
+	timeout := time.NewTimer(30 * time.Second)
+	defer timeout.Stop()
 	select {
-	case <-timeoutTicker.C:
+	case <-timeout.C:
 		return nil, fmt.Errorf("Timed out")
 	case peer := <-bridge.newPeerChan:
 		return peer, nil
 	}
 }
 
+// Start connects the two nodes through the bridge. If the bridge fails to connect the nodes, all connections that
+// were already established are closed, and the bridge can be started again.
 func (bridge *ConnectionBridge) Start() error {
+	if err := bridge.start(); err != nil {
+		bridge.disabled = true
+		bridge.closeConnections()
+		return err
+	}
+	return nil
+}
+
+func (bridge *ConnectionBridge) start() error {
 	var err error
 	bridge.disabled = false
+	bridge.mtx.Lock()
+	bridge.disconnectedDirections = make(map[Direction]bool)
+	bridge.mtx.Unlock()
+	bridge.connectionInboundA, bridge.connectionInboundB = nil, nil
+	bridge.connectionOutboundA, bridge.connectionOutboundB = nil, nil
 
 	// Start the outbound listener for A. The 127.0.0.1:0 pattern selects a random port.
-	listenerA, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		panic(err)
+	if bridge.outboundListenerA, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return err
 	}
 	// Start the outbound listener for B. The 127.0.0.1:0 pattern selects a random port.
-	listenerB, err := net.Listen("tcp", "127.0.0.1:0")
-	if err != nil {
-		panic(err)
+	if bridge.outboundListenerB, err = net.Listen("tcp", "127.0.0.1:0"); err != nil {
+		return err
 	}
-	bridge.outboundListenerA = listenerA
-	bridge.outboundListenerB = listenerB
 
 	// Initialize inbound connections to nodes.
-	bridge.connectionInboundA = bridge.createInboundConnection(bridge.nodeA)
-	bridge.connectionInboundB = bridge.createInboundConnection(bridge.nodeB)
+	if bridge.connectionInboundA, err = bridge.createInboundConnection(bridge.nodeA); err != nil {
+		return err
+	}
+	if bridge.connectionInboundB, err = bridge.createInboundConnection(bridge.nodeB); err != nil {
+		return err
+	}
 
 	// Start the inbound connections.
 	if err := bridge.startConnection(bridge.connectionInboundA, bridge.nodeB); err != nil {
@@ -365,34 +1775,237 @@ func (bridge *ConnectionBridge) Start() error {
 
 	// Start the communication routing between the two nodes. Basically we tunnel all the
 	// node communication to happen through the bridge.
-	go bridge.routeTraffic(bridge.connectionOutboundA, bridge.connectionInboundB)
-	go bridge.routeTraffic(bridge.connectionInboundB, bridge.connectionOutboundA)
-	go bridge.routeTraffic(bridge.connectionOutboundB, bridge.connectionInboundA)
-	go bridge.routeTraffic(bridge.connectionInboundA, bridge.connectionOutboundB)
+	links := []*bridgeLink{
+		newBridgeLink(bridge.connectionOutboundA, bridge.connectionInboundB, DirectionAToB, false),
+		newBridgeLink(bridge.connectionInboundB, bridge.connectionOutboundA, DirectionBToA, true),
+		newBridgeLink(bridge.connectionOutboundB, bridge.connectionInboundA, DirectionBToA, false),
+		newBridgeLink(bridge.connectionInboundA, bridge.connectionOutboundB, DirectionAToB, true),
+	}
+	bridge.mtx.Lock()
+	bridge.links = links
+	bridge.mtx.Unlock()
+	for ii, link := range links {
+		link.index = ii
+		go bridge.routeTraffic(link)
+	}
 
 	return nil
 }
 
 // Stop and start the connection bridge.
 func (bridge *ConnectionBridge) Restart() {
-	bridge.Disconnect()
+	bridge.disconnect()
 	bridge.Start()
 }
 
-// Disconnect stops the connection bridge.
+// SetAutoReconnect makes the bridge automatically re-establish the connection between the nodes after it's
+// disconnected, either with Disconnect or due to a connection failure. Reconnects are attempted with an exponential
+// backoff, starting at initialBackoff and doubling after every failed attempt, up to maxBackoff. Every successful
+// reconnect emits an event on the ReconnectEvents channel. Passing a zero initialBackoff disables automatic
+// reconnects, in which case a connection failure restarts the bridge immediately and Disconnect is permanent.
+// If automatic reconnects are enabled while the bridge is disconnected, the bridge starts reconnecting right away.
+func (bridge *ConnectionBridge) SetAutoReconnect(initialBackoff time.Duration, maxBackoff time.Duration) {
+	if maxBackoff < initialBackoff {
+		maxBackoff = initialBackoff
+	}
+
+	bridge.mtx.Lock()
+	bridge.initialBackoff = initialBackoff
+	bridge.maxBackoff = maxBackoff
+	bridge.mtx.Unlock()
+
+	if initialBackoff > 0 && bridge.disabled {
+		go bridge.reconnect()
+	}
+}
+
+// ReconnectEvents returns the channel on which the bridge emits an event every time it automatically reconnects.
+func (bridge *ConnectionBridge) ReconnectEvents() <-chan ReconnectEvent {
+	return bridge.reconnectEvents
+}
+
+// ReplaceNode swaps the oldNode end of the bridge for newNode. This is useful when a node is restarted, which creates
+// a new cmd.Node, so that the bridge reconnects to the restarted node.
+func (bridge *ConnectionBridge) ReplaceNode(oldNode *cmd.Node, newNode *cmd.Node) error {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+
+	switch oldNode {
+	case bridge.nodeA:
+		bridge.nodeA = newNode
+	case bridge.nodeB:
+		bridge.nodeB = newNode
+	default:
+		return fmt.Errorf("ReplaceNode: provided node is not an end of the bridge")
+	}
+	return nil
+}
+
+func (bridge *ConnectionBridge) isAutoReconnectEnabled() bool {
+	bridge.mtx.RLock()
+	defer bridge.mtx.RUnlock()
+	return bridge.initialBackoff > 0
+}
+
+// handleLinkFailure is called whenever relaying traffic fails due to a broken connection.
+func (bridge *ConnectionBridge) handleLinkFailure() {
+	bridge.mtx.Lock()
+	bridge.stats.LinkFailures++
+	bridge.mtx.Unlock()
+
+	if !bridge.isAutoReconnectEnabled() {
+		bridge.Restart()
+		return
+	}
+	bridge.disconnect()
+	go bridge.reconnect()
+}
+
+// reconnect keeps trying to start the bridge, with exponential backoff, until it succeeds or automatic reconnects
+// are disabled.
+func (bridge *ConnectionBridge) reconnect() {
+	bridge.mtx.Lock()
+	if bridge.reconnecting || bridge.initialBackoff == 0 {
+		bridge.mtx.Unlock()
+		return
+	}
+	bridge.reconnecting = true
+	backoff := bridge.initialBackoff
+	bridge.mtx.Unlock()
+
+	defer func() {
+		bridge.mtx.Lock()
+		bridge.reconnecting = false
+		bridge.mtx.Unlock()
+	}()
+
+	disconnectedAt := time.Now()
+	for attempt := 1; ; attempt++ {
+		time.Sleep(backoff)
+
+		bridge.mtx.RLock()
+		initialBackoff, maxBackoff := bridge.initialBackoff, bridge.maxBackoff
+		nodeA, nodeB := bridge.nodeA, bridge.nodeB
+		bridge.mtx.RUnlock()
+		if initialBackoff == 0 {
+			return
+		}
+
+		// We don't want to connect to nodes that are shutting down, or that haven't started yet.
+		var err error
+		if !nodeA.IsRunning() || !nodeB.IsRunning() {
+			err = fmt.Errorf("node is not running")
+		} else {
+			err = bridge.Start()
+		}
+		if err == nil {
+			event := ReconnectEvent{
+				Attempts: attempt,
+				Downtime: time.Since(disconnectedAt),
+			}
+			select {
+			case bridge.reconnectEvents <- event:
+			default:
+			}
+			return
+		}
+
+		glog.Infof("ConnectionBridge.reconnect: Reconnect attempt (%v) failed, retrying in (%v): %v",
+			attempt, backoff, err)
+		backoff = time.Duration(math.Min(float64(2*backoff), float64(maxBackoff)))
+	}
+}
+
+// getDirection returns the direction of traffic sent by the from node to the to node.
+func (bridge *ConnectionBridge) getDirection(from *cmd.Node, to *cmd.Node) (Direction, error) {
+	if from == bridge.nodeA && to == bridge.nodeB {
+		return DirectionAToB, nil
+	}
+	if from == bridge.nodeB && to == bridge.nodeA {
+		return DirectionBToA, nil
+	}
+	return 0, fmt.Errorf("getDirection: provided nodes are not the two ends of the bridge")
+}
+
+// getDirectionTo returns the direction of traffic sent to the provided node.
+func (bridge *ConnectionBridge) getDirectionTo(node *cmd.Node) (Direction, error) {
+	switch node {
+	case bridge.nodeB:
+		return DirectionAToB, nil
+	case bridge.nodeA:
+		return DirectionBToA, nil
+	}
+	return 0, fmt.Errorf("getDirectionTo: provided node is not an end of the bridge")
+}
+
+// DisconnectDirection stops relaying messages sent by the from node to the to node, while traffic in the other
+// direction keeps flowing. This simulates a half-open connection, where one side can still send messages, but they
+// never arrive. The connections themselves are kept open, so neither node is notified of the disconnect.
+func (bridge *ConnectionBridge) DisconnectDirection(from *cmd.Node, to *cmd.Node) error {
+	direction, err := bridge.getDirection(from, to)
+	if err != nil {
+		return err
+	}
+	bridge.disconnectDirection(direction)
+	return nil
+}
+
+func (bridge *ConnectionBridge) disconnectDirection(direction Direction) {
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+
+	if bridge.disconnectedDirections == nil {
+		bridge.disconnectedDirections = make(map[Direction]bool)
+	}
+	bridge.disconnectedDirections[direction] = true
+}
+
+func (bridge *ConnectionBridge) isDirectionDisconnected(direction Direction) bool {
+	bridge.mtx.RLock()
+	defer bridge.mtx.RUnlock()
+	return bridge.disconnectedDirections[direction]
+}
+
+// Disconnect stops the connection bridge. If automatic reconnects are enabled, the bridge will then try to reconnect
+// the nodes, which simulates a temporary network failure. To permanently stop such a bridge, first disable automatic
+// reconnects with SetAutoReconnect(0, 0).
 func (bridge *ConnectionBridge) Disconnect() {
+	if !bridge.disconnect() {
+		return
+	}
+	if bridge.isAutoReconnectEnabled() {
+		go bridge.reconnect()
+	}
+}
+
+// disconnect tears down the bridge's connections. Returns false if the bridge was already disconnected.
+func (bridge *ConnectionBridge) disconnect() bool {
 	if bridge.disabled {
 		fmt.Println("ConnectionBridge.Disconnect: Doing nothing, bridge is already disconnected.")
-		return
+		return false
 	}
 
+	// Stop relaying in both directions, and once no traffic is flowing, tear down the connections.
+	bridge.disconnectDirection(DirectionAToB)
+	bridge.disconnectDirection(DirectionBToA)
 	bridge.disabled = true
-	bridge.connectionInboundA.Disconnect()
-	bridge.connectionInboundB.Disconnect()
-	bridge.connectionOutboundA.Disconnect()
-	bridge.connectionOutboundB.Disconnect()
-	bridge.outboundListenerA.Close()
-	bridge.outboundListenerB.Close()
+	bridge.closeConnections()
 
 	bridge.waitGroup.Wait()
+	return true
+}
+
+// closeConnections closes all of the bridge's connections and listeners that were established.
+func (bridge *ConnectionBridge) closeConnections() {
+	for _, connection := range []*lib.Peer{bridge.connectionInboundA, bridge.connectionInboundB,
+		bridge.connectionOutboundA, bridge.connectionOutboundB} {
+		if connection != nil {
+			connection.Disconnect()
+		}
+	}
+	for _, listener := range []net.Listener{bridge.outboundListenerA, bridge.outboundListenerB} {
+		if listener != nil {
+			listener.Close()
+		}
+	}
 }
@@ -0,0 +1,1083 @@
+package integration_testing
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestHyperSyncWithLatency test if a node can successfully hyper sync from another node over a slow link:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks, and snapshot period of HyperSyncSnapshotPeriod.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator and builds ancestral records.
+//  3. bridge node1 and node2, and degrade the link to a 200-500ms latency.
+//  4. node2 hypersyncs from node1.
+//  5. once done, compare node1 db matches node2 db.
+func TestHyperSyncWithLatency(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeHyperSync
+
+	config1.HyperSync = true
+	config2.HyperSync = true
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge the nodes together and slow down the link once it's running.
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+	bridge.SetLatency(200*time.Millisecond, 500*time.Millisecond)
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+
+	compareNodesByDB(t, node1, node2, Summary)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestBlockSyncWithMessageLoss test if a node can successfully sync from another node over a lossy link:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
+//  3. bridge node1 and node2 with a bridge that drops 5% of the messages.
+//  4. node2 syncs MaxSyncBlockHeight blocks from node1.
+//  5. make sure the bridge dropped some messages, and compare node1 checksum matches node2.
+func TestBlockSyncWithMessageLoss(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeBlockSync
+
+	config1.HyperSync = true
+	config2.HyperSync = true
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge the nodes together over a lossy link.
+	bridge := NewConnectionBridge(node1, node2)
+	bridge.SetDropRate(0.05)
+	require.NoError(bridge.Start())
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+
+	require.Greater(bridge.Stats().DroppedMessages, uint64(0))
+	compareNodesByChecksum(t, node1, node2)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestHyperSyncWithLimitedBandwidth test if a node can successfully hyper sync from another node over a 1 MB/s link:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks, and snapshot period of HyperSyncSnapshotPeriod.
+//     node2 has a short stall timeout so that we can verify a slow link isn't mistaken for a stalled peer.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator and builds ancestral records.
+//  3. bridge node1 and node2 with a bandwidth limit of 1 MB/s.
+//  4. node2 hypersyncs from node1.
+//  5. once done, compare node1 db matches node2 db.
+func TestHyperSyncWithLimitedBandwidth(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeHyperSync
+	config2.StallTimeoutSeconds = 30
+
+	config1.HyperSync = true
+	config2.HyperSync = true
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge the nodes together over a 1 MB/s link.
+	bridge := NewConnectionBridge(node1, node2)
+	bridge.SetBandwidthLimit(1 << 20)
+	require.NoError(bridge.Start())
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+
+	require.Equal(lib.SyncStateFullyCurrent, node2.Server.GetBlockchain().ChainState())
+	compareNodesByDB(t, node1, node2, Summary)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestHyperSyncWithReordering test if a node can successfully hyper sync from another node when inv and getdata
+// messages are delivered out of order:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks, and snapshot period of HyperSyncSnapshotPeriod.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator and builds ancestral records.
+//  3. bridge node1 and node2 with reordering enabled for inv and getdata messages.
+//  4. node2 hypersyncs from node1.
+//  5. once done, compare node1 state matches node2 state.
+func TestHyperSyncWithReordering(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeHyperSyncArchival
+
+	config1.HyperSync = true
+	config2.HyperSync = true
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge the nodes together and shuffle inv and getdata messages.
+	bridge := NewConnectionBridge(node1, node2)
+	bridge.SetRandomSeed(1377)
+	bridge.EnableReordering(5, lib.MsgTypeInv, lib.MsgTypeGetBlocks, lib.MsgTypeGetTransactions)
+	require.NoError(bridge.Start())
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+
+	compareNodesByState(t, node1, node2, Summary)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestBlockSyncHalfOpenConnection tests if a syncing node detects a half-open connection to its sync peer:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks. node2 has a short stall timeout.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
+//  3. bridge node1 and node2
+//  4. node2 syncs between 10 and MaxSyncBlockHeight blocks from node1.
+//  5. stop relaying traffic from node1 to node2, while node2 can still send messages to node1.
+//  6. node2 should hit its stall timeout and disconnect node1, after which the bridge reconnects the nodes.
+//  7. compare node1 db matches node2 db.
+func TestBlockSyncHalfOpenConnection(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeBlockSync
+	config2.StallTimeoutSeconds = 10
+
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge the nodes together.
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+
+	// wait for node2 to sync some blocks and then break the node1 -> node2 direction.
+	randomHeight := randomUint32Between(t, 10, config2.MaxSyncBlockHeight)
+	waitForBlockHeight(t, node2, randomHeight)
+	require.NoError(bridge.DisconnectDirection(node1, node2))
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+
+	compareNodesByDB(t, node1, node2, Summary)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestHyperSyncDroppedSnapshotChunk tests if a hypersyncing node re-requests a snapshot chunk that never arrived:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks, and snapshot period of HyperSyncSnapshotPeriod.
+//     node2 has a short stall timeout.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator and builds ancestral records.
+//  3. bridge node1 and node2 with a message hook that drops the first snapshot chunk of the post entry prefix.
+//  4. node2 hypersyncs from node1.
+//  5. once done, make sure node2 requested the dropped chunk again, and compare node1 db matches node2 db.
+func TestHyperSyncDroppedSnapshotChunk(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeHyperSync
+	config2.StallTimeoutSeconds = 10
+
+	config1.HyperSync = true
+	config2.HyperSync = true
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge the nodes together and drop the first chunk of the post entry prefix.
+	syncPrefix := lib.Prefixes.PrefixPostHashToPostEntry
+	droppedChunk := false
+	prefixRequests := 0
+	bridge := NewConnectionBridge(node1, node2)
+	bridge.SetMessageHook(func(msg lib.DeSoMessage, direction Direction) (lib.DeSoMessage, bool) {
+		switch typedMsg := msg.(type) {
+		case *lib.MsgDeSoGetSnapshot:
+			if bytes.Equal(typedMsg.SnapshotStartKey, syncPrefix) {
+				prefixRequests++
+			}
+		case *lib.MsgDeSoSnapshotData:
+			if !droppedChunk && bytes.Equal(typedMsg.Prefix, syncPrefix) {
+				droppedChunk = true
+				return msg, false
+			}
+		}
+		return msg, true
+	})
+	require.NoError(bridge.Start())
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+	bridge.SetMessageHook(nil)
+
+	require.True(droppedChunk)
+	require.GreaterOrEqual(prefixRequests, 2)
+	compareNodesByDB(t, node1, node2, Summary)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestBlockSyncReplay test if a recorded sync can be replayed into a fresh node without a live peer:
+//  1. Spawn three nodes node1, node2, node3 with max block height of MaxSyncBlockHeight blocks.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
+//  3. bridge node1 and node2, and record all traffic relayed by the bridge.
+//  4. node2 syncs MaxSyncBlockHeight blocks from node1.
+//  5. replay the traffic received by node2 into node3.
+//  6. once done, compare node2 checksum matches node3.
+func TestBlockSyncReplay(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	dbDir3 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+	defer os.RemoveAll(dbDir3)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeBlockSync
+	config3 := generateConfig(t, dbDir3, 10)
+	config3.SyncType = lib.NodeSyncTypeBlockSync
+
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+	node3 := cmd.NewNode(config3)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+	node3 = startNode(t, node3)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge node1 and node2 and record the traffic, including the handshakes.
+	recordingPath := filepath.Join(dbDir2, "bridge.rec")
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.StartRecording(recordingPath))
+	require.NoError(bridge.Start())
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+	bridge.Disconnect()
+	require.NoError(bridge.StopRecording())
+
+	recorded, err := ReadBridgeRecording(recordingPath)
+	require.NoError(err)
+	require.NotEmpty(recorded)
+	for _, msg := range recorded {
+		_, err = msg.Message()
+		require.NoError(err)
+	}
+
+	// replay everything node2 received into node3.
+	replay, err := NewReplayBridge(node3, recordingPath, DirectionAToB)
+	require.NoError(err)
+	require.NoError(replay.Start())
+	require.NoError(replay.Wait())
+
+	// wait for node3 to process the replayed blocks.
+	waitForNodeToFullySync(t, node3)
+	replay.Disconnect()
+
+	compareNodesByChecksum(t, node2, node3)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+	node3.Stop()
+}
+
+// TestBlockSyncWithCorruptedMessages test if a node survives a peer sending corrupted messages:
+//  1. Spawn three nodes node1, node2, node3 with max block height of MaxSyncBlockHeight blocks.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
+//  3. bridge node1 and node3 with a regular bridge, node3 will be our uncorrupted control node.
+//  4. bridge node1 and node2 with a bridge that corrupts a header bundle and two blocks.
+//  5. node2 rejects the corrupted messages, reconnects, and eventually syncs MaxSyncBlockHeight blocks from node1.
+//  6. once done, compare node3 state matches node2.
+func TestBlockSyncWithCorruptedMessages(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	dbDir3 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+	defer os.RemoveAll(dbDir3)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeBlockSync
+	config3 := generateConfig(t, dbDir3, 10)
+	config3.SyncType = lib.NodeSyncTypeBlockSync
+
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+	// A truncated message leaves node2 waiting for the rest of the payload, so we want it to give up quickly.
+	config2.StallTimeoutSeconds = 10
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+	node3 := cmd.NewNode(config3)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+	node3 = startNode(t, node3)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// sync the control node over a regular bridge.
+	controlBridge := NewConnectionBridge(node1, node3)
+	require.NoError(controlBridge.Start())
+	waitForNodeToFullySync(t, node3)
+
+	// bridge node1 and node2 and corrupt some of the traffic.
+	bridge := NewConnectionBridge(node1, node2)
+	bridge.CorruptNextMessage(lib.MsgTypeHeaderBundle, InflateLengthField(lib.MaxMessagePayload))
+	bridge.CorruptNextMessage(lib.MsgTypeBlock, FlipRandomBits(t, 8))
+	bridge.CorruptNextMessage(lib.MsgTypeBlock, TruncateMessage(100))
+	require.NoError(bridge.Start())
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+	require.Equal(uint64(3), bridge.Stats().CorruptedMessages)
+
+	compareNodesByState(t, node3, node2, Summary)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+	node3.Stop()
+}
+
+// TestHyperSyncTrafficStats test if the bridge traffic counters reflect a hypersync:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks, and snapshot period of HyperSyncSnapshotPeriod.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator and builds ancestral records.
+//  3. bridge node1 and node2.
+//  4. node2 hypersyncs from node1.
+//  5. make sure every snapshot request was answered with exactly one snapshot chunk.
+//  6. reset the counters and make sure no snapshot chunks are sent once node2 is synced.
+func TestHyperSyncTrafficStats(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeHyperSync
+
+	config1.HyperSync = true
+	config2.HyperSync = true
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge the nodes together.
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+
+	stats := bridge.Stats()
+	snapshotRequests := stats.Get(DirectionBToA, lib.MsgTypeGetSnapshot)
+	snapshotChunks := stats.Get(DirectionAToB, lib.MsgTypeSnapshotData)
+	require.Greater(snapshotRequests.Count, uint64(0))
+	require.Equal(snapshotRequests.Count, snapshotChunks.Count)
+	require.LessOrEqual(snapshotChunks.MinBytes, snapshotChunks.MaxBytes)
+	fmt.Printf("Snapshot chunks: count (%v), total bytes (%v), average bytes (%v)\n",
+		snapshotChunks.Count, snapshotChunks.TotalBytes, snapshotChunks.AvgBytes())
+
+	// once node2 is synced, there should be no more snapshot traffic.
+	bridge.ResetStats()
+	time.Sleep(5 * time.Second)
+	require.Zero(bridge.Stats().Get(DirectionAToB, lib.MsgTypeSnapshotData).Count)
+
+	compareNodesByDB(t, node1, node2, Summary)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestHyperSyncWithDuplicateMessages test if a node can successfully hyper sync from another node that sends some
+// snapshot chunks and blocks twice:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks, and snapshot period of HyperSyncSnapshotPeriod.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator and builds ancestral records.
+//  3. bridge node1 and node2 with a bridge that duplicates 20% of snapshot chunks and blocks.
+//  4. node2 hypersyncs from node1.
+//  5. once done, compare node1 checksum and db match node2, and that no records were written twice.
+func TestHyperSyncWithDuplicateMessages(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeHyperSync
+
+	config1.HyperSync = true
+	config2.HyperSync = true
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge the nodes together and duplicate some of the snapshot chunks and blocks.
+	bridge := NewConnectionBridge(node1, node2)
+	bridge.SetDuplicateRate(0.2, 500*time.Millisecond, lib.MsgTypeSnapshotData, lib.MsgTypeBlock)
+	require.NoError(bridge.Start())
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+	require.Greater(bridge.Stats().DuplicatedMessages, uint64(0))
+
+	compareNodesByChecksum(t, node1, node2)
+	compareNodesByDB(t, node1, node2, Summary)
+	for _, prefix := range lib.StatePrefixes.StatePrefixesList {
+		if bytes.Equal(prefix, lib.Prefixes.PrefixBlockHashToUtxoOperations) {
+			continue
+		}
+		require.Equal(countPrefixRecords(t, node1.ChainDB, prefix), countPrefixRecords(t, node2.ChainDB, prefix),
+			"Record counts differ for prefix (%v)", prefix)
+	}
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestHyperSyncAutoReconnect test if a node can successfully hyper sync from its only peer when it keeps losing the
+// connection to it:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks, and snapshot period of HyperSyncSnapshotPeriod.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator and builds ancestral records.
+//  3. bridge node1 and node2 with a bridge that automatically reconnects.
+//  4. node2 starts hypersyncing from node1, and we disconnect the bridge five times at different sync prefixes.
+//  5. once done, compare node1 db matches node2 db.
+func TestHyperSyncAutoReconnect(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeHyperSync
+
+	config1.HyperSync = true
+	config2.HyperSync = true
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge the nodes together.
+	bridge := NewConnectionBridge(node1, node2)
+	bridge.SetAutoReconnect(100*time.Millisecond, 2*time.Second)
+	require.NoError(bridge.Start())
+
+	// disconnect the bridge at five sync prefixes spread over the snapshot.
+	const numDisconnects = 5
+	statePrefixes := lib.StatePrefixes.StatePrefixesList
+	for ii := 0; ii < numDisconnects; ii++ {
+		syncPrefix := statePrefixes[ii*len(statePrefixes)/numDisconnects]
+		disconnectAtSyncPrefix(t, node2, bridge, syncPrefix)
+		event := waitForBridgeReconnect(t, bridge, 30*time.Second)
+		require.GreaterOrEqual(event.Attempts, 1)
+	}
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+	bridge.SetAutoReconnect(0, 0)
+
+	compareNodesByDB(t, node1, node2, Summary)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestBlockSyncWithThrottledBlocks test if a node can successfully sync from a peer that serves blocks very slowly:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
+//  3. bridge node1 and node2 with a bridge that relays at most 2 blocks per second.
+//  4. node2 syncs MaxSyncBlockHeight blocks from node1, while blocks queue up on the bridge.
+//  5. once done, make sure node2 never disconnected node1, and compare node1 state matches node2.
+func TestBlockSyncWithThrottledBlocks(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeBlockSync
+
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge the nodes together and throttle the blocks.
+	bridge := NewConnectionBridge(node1, node2)
+	bridge.SetMessageTypeRate(lib.MsgTypeBlock, 2)
+	require.NoError(bridge.Start())
+
+	// make sure the blocks are actually queued up on the bridge.
+	queued := false
+	for ii := 0; ii < 100 && !queued; ii++ {
+		queued = bridge.Stats().QueueDepths[lib.MsgTypeBlock] > 0
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.True(queued)
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+	require.Zero(bridge.Stats().LinkFailures)
+
+	compareNodesByState(t, node1, node2, Summary)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestHyperSyncRestartAfterSnapshotChunks test if a node can successfully hyper sync from another node after being
+// restarted in the middle of downloading the snapshot:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks, and snapshot period of HyperSyncSnapshotPeriod.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator and builds ancestral records.
+//  3. bridge node1 and node2.
+//  4. node2 hypersyncs from node1, and the bridge disconnects right after the 3rd snapshot chunk.
+//  5. restart node2 and reconnect it to node1.
+//  6. once done, compare node1 state and checksum match node2.
+func TestHyperSyncRestartAfterSnapshotChunks(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeHyperSync
+
+	config1.HyperSync = true
+	config2.HyperSync = true
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge the nodes together.
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+
+	// restart node2 after the 3rd snapshot chunk and reconnect it with node1.
+	node2, bridge = restartAfterMessagesAndReconnectNode(t, node2, bridge, lib.MsgTypeSnapshotData, 3)
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+
+	compareNodesByState(t, node1, node2, Summary)
+	compareNodesByChecksum(t, node1, node2)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestHyperSyncBlockedSnapshotData test if hypersync stalls while snapshot chunks are blackholed, and resumes once
+// they get through again:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
+//  3. bridge node1 and node2, and block all snapshot chunks once node2 receives the first one.
+//  4. verify that node2's hypersync progress doesn't move while the chunks are blocked.
+//  5. unblock the snapshot chunks, and node2 hypersyncs from node1.
+//  6. once done, compare node1 checksum matches node2.
+func TestHyperSyncBlockedSnapshotData(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeHyperSync
+	config2.StallTimeoutSeconds = 30
+
+	config1.HyperSync = true
+	config2.HyperSync = true
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge the nodes together.
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+
+	// block the snapshot chunks as soon as the first one gets through.
+	deadline := time.After(2 * time.Minute)
+	for bridge.Stats().Get(DirectionAToB, lib.MsgTypeSnapshotData).Count == 0 {
+		select {
+		case <-deadline:
+			t.Fatalf("node2 didn't receive any snapshot chunks")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	bridge.BlockMessageType(lib.MsgTypeSnapshotData)
+
+	// give in-flight chunks time to be processed, then make sure the progress is frozen.
+	time.Sleep(2 * time.Second)
+	progress := hyperSyncProgressString(node2)
+	time.Sleep(10 * time.Second)
+	require.Equal(progress, hyperSyncProgressString(node2))
+	require.Greater(bridge.Stats().BlockedMessages[lib.MsgTypeSnapshotData], uint64(0))
+
+	// wait for node2 to sync blocks.
+	bridge.UnblockMessageType(lib.MsgTypeSnapshotData)
+	waitForNodeToFullySync(t, node2)
+
+	compareNodesByChecksum(t, node1, node2)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestBlockSyncWithInjectedAddrFlood test if a node keeps syncing from an honest peer that floods it with addresses:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
+//  3. bridge node1 and node2, and inject 10,000 addresses into node2, in the largest addr messages it accepts.
+//  4. node2 syncs MaxSyncBlockHeight blocks from node1 without disconnecting it.
+//  5. once done, compare node1 checksum matches node2.
+func TestBlockSyncWithInjectedAddrFlood(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeBlockSync
+
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge the nodes together and flood node2 with addresses.
+	bridge := NewConnectionBridge(node1, node2)
+	bridge.SetLatency(10*time.Millisecond, 50*time.Millisecond)
+	require.NoError(bridge.Start())
+	const numAddrMsgs = 10
+	for ii := 0; ii < numAddrMsgs; ii++ {
+		addrMsg := &lib.MsgDeSoAddr{}
+		for jj := 0; jj < lib.MaxAddrsPerAddrMsg; jj++ {
+			addrMsg.AddrList = append(addrMsg.AddrList, &lib.SingleAddr{
+				Timestamp: time.Now(),
+				Services:  lib.SFFullNodeDeprecated,
+				IP:        net.IPv4(10, byte(ii), byte(jj>>8), byte(jj)),
+				Port:      17000,
+			})
+		}
+		require.NoError(bridge.InjectMessage(node2, addrMsg))
+	}
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+
+	stats := bridge.Stats()
+	require.Equal(uint64(numAddrMsgs), stats.InjectedMessages)
+	require.Equal(uint64(numAddrMsgs), stats.Get(DirectionAToB, lib.MsgTypeAddr).Count)
+	require.Zero(stats.LinkFailures)
+	compareNodesByChecksum(t, node1, node2)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestBlockSyncAfterStuckHandshakes test if peers stuck in the version negotiation don't leak inbound peer slots:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks, and five inbound slots on node2.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
+//  3. open five bridges to node2 that never complete the handshake, and wait for node2 to time them out.
+//  4. bridge node1 and node2 with a slow, but honest handshake.
+//  5. node2 syncs MaxSyncBlockHeight blocks from node1.
+//  6. once done, compare node1 checksum matches node2.
+func TestBlockSyncAfterStuckHandshakes(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	const maxInboundPeers = 5
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeBlockSync
+	config2.MaxInboundPeers = maxInboundPeers
+
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// fill all inbound slots of node2 with peers that never complete the handshake.
+	errChan := make(chan error, maxInboundPeers)
+	for ii := 0; ii < maxInboundPeers; ii++ {
+		stuckBridge := NewConnectionBridge(node2, node1)
+		stuckBridge.FailHandshake()
+		go func() {
+			errChan <- stuckBridge.Start()
+		}()
+	}
+	for ii := 0; ii < maxInboundPeers; ii++ {
+		select {
+		case err := <-errChan:
+			require.Error(err)
+		case <-time.After(2 * node2.Config.Params.VersionNegotiationTimeout):
+			t.Fatalf("node2 didn't time out the stuck handshakes")
+		}
+	}
+
+	// bridge node1 and node2 with a slow handshake, node2 should still have room for it.
+	bridge := NewConnectionBridge(node1, node2)
+	bridge.DelayHandshake(2 * time.Second)
+	require.NoError(bridge.Start())
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+
+	compareNodesByChecksum(t, node1, node2)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestRegtestPausedBridge test if a node catches up in a single burst once a paused bridge is resumed:
+//  1. Spawn two regtest nodes node1, node2 and bridge them together.
+//  2. mine a few blocks on node1 and wait for node2 to sync them.
+//  3. pause the bridge and mine three more blocks on node1.
+//  4. node2 shouldn't receive any of the blocks while the bridge is paused.
+//  5. resume the bridge, node2 should sync all three blocks without a new handshake.
+//  6. once done, compare node1 state matches node2.
+func TestRegtestPausedBridge(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateRegtestConfig(t, dbDir1, 10)
+	config2 := generateRegtestConfig(t, dbDir2, 10)
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// bridge the nodes together and sync the common chain.
+	bridge := NewConnectionBridge(node1, node2)
+	recorder := NewMessageSequenceRecorder(bridge)
+	require.NoError(bridge.Start())
+	mineBlocks(t, node1, 2)
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+
+	// mine blocks while the bridge is paused, node2 shouldn't see them.
+	bridge.Pause()
+	pausedHeight := node2.Server.GetBlockchain().BlockTip().Height
+	blocksBefore := bridge.Stats().Get(DirectionAToB, lib.MsgTypeBlock).Count
+	recorder.Reset()
+	mineBlocks(t, node1, 3)
+	time.Sleep(2 * time.Second)
+	require.Equal(pausedHeight, node2.Server.GetBlockchain().BlockTip().Height)
+	require.Equal(blocksBefore, bridge.Stats().Get(DirectionAToB, lib.MsgTypeBlock).Count)
+
+	// resume the bridge and wait for node2 to catch up.
+	bridge.Resume()
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+	require.Equal(pausedHeight+3, node2.Server.GetBlockchain().BlockTip().Height)
+	recorder.AssertNeverSeen(t, MessageEvent{DirectionAToB, lib.MsgTypeVersion})
+	require.Zero(bridge.Stats().PauseDroppedMessages)
+
+	compareNodesByState(t, node1, node2, Summary)
+	fmt.Println("Databases match!")
+	bridge.Disconnect()
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestBlockSyncStalledPeer test if a node detects a peer that stops responding mid-sync and finishes syncing from
+// another peer:
+//  1. Spawn three nodes node1, node2, node3 with max block height of MaxSyncBlockHeight blocks, and a short stall
+//     timeout on node2.
+//  2. node1 and node3 sync MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
+//  3. bridge node1 and node2, and wait for node2 to start downloading blocks from node1.
+//  4. stall the bridge, so that node1 never answers node2's requests, and bridge node2 with node3.
+//  5. node2 should disconnect node1 after the stall timeout, and sync MaxSyncBlockHeight blocks from node3.
+//  6. once done, compare node3 db matches node2 db.
+func TestBlockSyncStalledPeer(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	dbDir3 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+	defer os.RemoveAll(dbDir3)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeBlockSync
+	config2.StallTimeoutSeconds = 5
+	config3 := generateConfig(t, dbDir3, 10)
+	config3.SyncType = lib.NodeSyncTypeBlockSync
+
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+	config3.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+	node3 := cmd.NewNode(config3)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+	node3 = startNode(t, node3)
+
+	// wait for node1 and node3 to sync blocks
+	waitForNodeToFullySync(t, node1)
+	waitForNodeToFullySync(t, node3)
+
+	// bridge node1 and node2, and stall the bridge once node2 is in the middle of downloading blocks.
+	deadBridge := NewConnectionBridge(node1, node2)
+	require.NoError(deadBridge.Start())
+	deadline := time.After(2 * time.Minute)
+	for deadBridge.Stats().Get(DirectionAToB, lib.MsgTypeBlock).Count < 10 {
+		select {
+		case <-deadline:
+			t.Fatalf("node2 didn't start downloading blocks from node1")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	require.NoError(deadBridge.StallReads(node2))
+	stalledHeight := node2.Server.GetBlockchain().BlockTip().Height
+
+	// bridge node2 with node3, and wait for node2 to give up on node1.
+	bridge := NewConnectionBridge(node3, node2)
+	require.NoError(bridge.Start())
+	deadline = time.After(time.Duration(5*config2.StallTimeoutSeconds) * time.Second)
+	for deadBridge.Stats().LinkFailures == 0 {
+		select {
+		case <-deadline:
+			t.Fatalf("node2 didn't disconnect the stalled peer")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	// a dead peer doesn't come back.
+	deadBridge.Disconnect()
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+	require.Greater(node2.Server.GetBlockchain().BlockTip().Height, stalledHeight)
+	require.Greater(bridge.Stats().Get(DirectionAToB, lib.MsgTypeBlock).Count, uint64(0))
+
+	compareNodesByDB(t, node3, node2, Summary)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+	node3.Stop()
+}
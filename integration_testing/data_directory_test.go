@@ -0,0 +1,146 @@
+package integration_testing
+
+import (
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+)
+
+// TestRegtestDataDirectoryVersionRefusal test if a node refuses to start on a data directory it can't read:
+//  1. Spawn a regtest node, mine a block on it, and stop it. Its data directory should be stamped with the current
+//     version.
+//  2. stamp the data directory with a newer schema version, a newer encoder version, other params, and an older schema
+//     version that no migration is registered from. The node should fail to start with a clear error every time.
+//  3. restoring the original stamp should let the node start again, with its block tip intact.
+func TestRegtestDataDirectoryVersionRefusal(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	node := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest())))
+	mineBlocks(t, node, 1)
+	height := node.Server.GetBlockchain().BlockTip().Height
+	node = shutdownNode(t, node)
+	dataDirectory := node.Config.DataDirectory
+
+	stamp, err := cmd.ReadDataDirectoryVersion(dataDirectory)
+	require.NoError(err)
+	require.NotNil(stamp)
+	require.Equal(cmd.CurrentSchemaVersion(), stamp.SchemaVersion)
+	require.Equal(*cmd.NewDataDirectoryVersion(node.Params), *stamp)
+
+	testCases := []struct {
+		name     string
+		mutate   func(stamp *cmd.DataDirectoryVersion)
+		expected string
+	}{
+		{"newer schema version", func(stamp *cmd.DataDirectoryVersion) {
+			stamp.SchemaVersion++
+		}, "was written by a newer version with schema version"},
+		{"newer encoder version", func(stamp *cmd.DataDirectoryVersion) {
+			stamp.EncoderVersion++
+		}, "was written by a newer version with encoder version"},
+		{"other params", func(stamp *cmd.DataDirectoryVersion) {
+			stamp.ParamsHash = "other"
+		}, "was written for different network params"},
+		{"older schema version without migration", func(stamp *cmd.DataDirectoryVersion) {
+			stamp.SchemaVersion = 0
+		}, "no migration is registered from version (0)"},
+	}
+	for _, testCase := range testCases {
+		refusedStamp := *stamp
+		testCase.mutate(&refusedStamp)
+		require.NoError(cmd.WriteDataDirectoryVersion(dataDirectory, &refusedStamp))
+		err := node.Start()
+		require.Error(err, testCase.name)
+		require.Contains(err.Error(), testCase.expected, testCase.name)
+		require.False(node.IsRunning())
+	}
+
+	require.NoError(cmd.WriteDataDirectoryVersion(dataDirectory, stamp))
+	node = startNode(t, node)
+	require.Equal(height, node.Server.GetBlockchain().BlockTip().Height)
+}
+
+// TestRegtestDataDirectoryUpgradedParams test if a node upgraded with a new encoder migration starts on a data
+// directory written before the upgrade:
+//  1. Spawn a regtest node, mine a block on it, and stop it.
+//  2. start the node again with the same params, plus a new encoder migration that isn't scheduled yet, like a node
+//     upgraded ahead of a fork. The node should start with its block tip intact.
+//  3. the data directory should be stamped with the new encoder version, and the same params hash.
+func TestRegtestDataDirectoryUpgradedParams(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	node := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest())))
+	mineBlocks(t, node, 1)
+	height := node.Server.GetBlockchain().BlockTip().Height
+	node = shutdownNode(t, node)
+	stamp, err := cmd.ReadDataDirectoryVersion(node.Config.DataDirectory)
+	require.NoError(err)
+
+	// Regtest is already enabled in the upgraded params, and enabling it again on start would reset the migrations.
+	upgradedParams := lib.NewDeSoRegtestParams()
+	upgradedParams.EncoderMigrationHeightsList = append([]*lib.MigrationHeight{},
+		upgradedParams.EncoderMigrationHeightsList...)
+	upgradedParams.EncoderMigrationHeightsList = append(upgradedParams.EncoderMigrationHeightsList,
+		&lib.MigrationHeight{
+			Height:  math.MaxUint32,
+			Version: stamp.EncoderVersion + 1,
+			Name:    "TestUpgradeMigration",
+		})
+	upgradedConfig := *node.Config
+	upgradedConfig.Params = upgradedParams
+	upgradedConfig.Regtest = false
+
+	upgradedNode := startNode(t, cmd.NewNode(&upgradedConfig))
+	require.Equal(height, upgradedNode.Server.GetBlockchain().BlockTip().Height)
+	shutdownNode(t, upgradedNode)
+
+	upgradedStamp, err := cmd.ReadDataDirectoryVersion(node.Config.DataDirectory)
+	require.NoError(err)
+	require.Equal(stamp.SchemaVersion, upgradedStamp.SchemaVersion)
+	require.Equal(stamp.EncoderVersion+1, upgradedStamp.EncoderVersion)
+	require.Equal(stamp.ParamsHash, upgradedStamp.ParamsHash)
+}
+
+// TestRegtestDataDirectoryMigration test if a registered migration runs exactly once on an older data directory:
+//  1. Spawn a regtest node, mine a block on it, and stop it.
+//  2. register a migration from the current schema version to the next one, which counts its runs.
+//  3. starting the node should run the migration once, with the chain db open, and stamp the new schema version.
+//  4. restarting the node shouldn't run the migration again.
+func TestRegtestDataDirectoryMigration(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	node := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest())))
+	mineBlocks(t, node, 1)
+	height := node.Server.GetBlockchain().BlockTip().Height
+	node = shutdownNode(t, node)
+
+	fromVersion := cmd.CurrentSchemaVersion()
+	numRuns := 0
+	unregister := cmd.RegisterMigration(fromVersion, fromVersion+1, func(chainDB *badger.DB,
+		config *cmd.Config) error {
+
+		numRuns++
+		require.False(chainDB.IsClosed())
+		require.Equal(node.Config.DataDirectory, config.DataDirectory)
+		return nil
+	})
+	defer unregister()
+	require.Equal(fromVersion+1, cmd.CurrentSchemaVersion())
+
+	node = startNode(t, node)
+	require.Equal(1, numRuns)
+	require.Equal(height, node.Server.GetBlockchain().BlockTip().Height)
+	stamp, err := cmd.ReadDataDirectoryVersion(node.Config.DataDirectory)
+	require.NoError(err)
+	require.Equal(fromVersion+1, stamp.SchemaVersion)
+
+	node = restartNode(t, node)
+	require.Equal(1, numRuns)
+	node = shutdownNode(t, node)
+}
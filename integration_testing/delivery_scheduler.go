@@ -0,0 +1,251 @@
+package integration_testing
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// defaultSchedulerMaxJitter is the maximum number of ticks a message can be delayed by, unless changed with
+// DeliveryScheduler.SetMaxJitter.
+const defaultSchedulerMaxJitter = 10
+
+// defaultSchedulerSettleTime is how long the nodes have to stay quiet before the scheduler takes the next step,
+// unless changed with DeliveryScheduler.SetSettleTime.
+const defaultSchedulerSettleTime = 100 * time.Millisecond
+
+// DeliveryScheduler delivers the messages of one or more ConnectionBridges in a deterministic order. Bridges attached
+// to the scheduler don't deliver messages on their own. Instead, every message sent by a node is assigned a delivery
+// tick drawn from the scheduler's seeded random number generator, and the messages are delivered one by one, in the
+// order of their ticks, whenever the test calls Step() or Run(). Messages on the same connection are always
+// delivered in the order in which they were sent, like they would be over TCP.
+//
+// Before every step, the scheduler waits for the nodes to settle, i.e. to stop sending messages for the settle time,
+// and only then assigns ticks to the newly sent messages, in a fixed order. This way, the same seed always produces the
+// same interleaving of messages, as long as the nodes react to every message within the settle time. The seed is
+// logged if the test fails, so that the failure can be reproduced.
+//
+// The scheduler replaces the bridges' timing simulation, so latencies, bandwidth and rate limits, reordering, and
+// duplication are ignored for bridges attached to a scheduler.
+type DeliveryScheduler struct {
+	seed int64
+
+	mtx sync.Mutex
+	rng *rand.Rand
+	// bridges are the attached bridges, in the order in which they were attached.
+	bridges []*ConnectionBridge
+	// incoming are the messages sent since the last step, in the order in which they arrived.
+	incoming []*scheduledMessage
+	// pending are the messages that were assigned a tick, but weren't delivered yet.
+	pending []*scheduledMessage
+	// lastTicks are the ticks of the most recently scheduled message on each link.
+	lastTicks   map[*bridgeLink]uint64
+	lastArrival time.Time
+	currentTick uint64
+	sequence    uint64
+	history     []MessageEvent
+
+	maxJitter  int64
+	settleTime time.Duration
+}
+
+// scheduledMessage is a message waiting for its turn in a DeliveryScheduler.
+type scheduledMessage struct {
+	bridge     *ConnectionBridge
+	link       *bridgeLink
+	bridgeMsg  *bridgeMessage
+	tick       uint64
+	arrivalSeq uint64
+}
+
+// NewDeliveryScheduler creates a scheduler with the provided seed. Passing a zero seed derives one from the test's
+// TestRand. In either case, the seed is logged if the test fails.
+func NewDeliveryScheduler(t testing.TB, seed int64) *DeliveryScheduler {
+	if seed == 0 {
+		seed = getTestRand(t).Int63()
+	}
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("DeliveryScheduler: Test failed with seed (%v)", seed)
+		}
+	})
+
+	return &DeliveryScheduler{
+		seed:       seed,
+		rng:        rand.New(rand.NewSource(seed)),
+		lastTicks:  make(map[*bridgeLink]uint64),
+		maxJitter:  defaultSchedulerMaxJitter,
+		settleTime: defaultSchedulerSettleTime,
+	}
+}
+
+// Seed returns the seed of the scheduler's random number generator.
+func (scheduler *DeliveryScheduler) Seed() int64 {
+	return scheduler.seed
+}
+
+// SetMaxJitter sets the maximum number of ticks a message can be delayed by. With a zero jitter, the messages sent
+// between two steps are delivered in a fixed order, by bridge and by link.
+func (scheduler *DeliveryScheduler) SetMaxJitter(maxJitter int64) {
+	scheduler.mtx.Lock()
+	defer scheduler.mtx.Unlock()
+	scheduler.maxJitter = maxJitter
+}
+
+// SetSettleTime sets how long the nodes have to stay quiet before the scheduler takes the next step.
+func (scheduler *DeliveryScheduler) SetSettleTime(settleTime time.Duration) {
+	scheduler.mtx.Lock()
+	defer scheduler.mtx.Unlock()
+	scheduler.settleTime = settleTime
+}
+
+// AddBridge attaches the bridge to the scheduler, after which all messages relayed by the bridge are delivered by
+// the scheduler. Bridges should be attached before they are started.
+func (scheduler *DeliveryScheduler) AddBridge(bridge *ConnectionBridge) {
+	scheduler.mtx.Lock()
+	scheduler.bridges = append(scheduler.bridges, bridge)
+	scheduler.mtx.Unlock()
+
+	bridge.mtx.Lock()
+	defer bridge.mtx.Unlock()
+	bridge.scheduler = scheduler
+}
+
+// enqueue adds a message sent over the bridge's link to the scheduler's incoming messages.
+func (scheduler *DeliveryScheduler) enqueue(bridge *ConnectionBridge, link *bridgeLink, bridgeMsg *bridgeMessage) {
+	scheduler.mtx.Lock()
+	defer scheduler.mtx.Unlock()
+
+	scheduler.sequence++
+	scheduler.incoming = append(scheduler.incoming, &scheduledMessage{
+		bridge:     bridge,
+		link:       link,
+		bridgeMsg:  bridgeMsg,
+		arrivalSeq: scheduler.sequence,
+	})
+	scheduler.lastArrival = time.Now()
+}
+
+// waitToSettle waits until no messages arrived for the settle time.
+func (scheduler *DeliveryScheduler) waitToSettle() {
+	for {
+		scheduler.mtx.Lock()
+		remaining := scheduler.settleTime - time.Since(scheduler.lastArrival)
+		scheduler.mtx.Unlock()
+		if remaining <= 0 {
+			return
+		}
+		time.Sleep(remaining)
+	}
+}
+
+// bridgeIndex returns the position of the bridge among the attached bridges. The caller must hold mtx.
+func (scheduler *DeliveryScheduler) bridgeIndex(bridge *ConnectionBridge) int {
+	for ii, attachedBridge := range scheduler.bridges {
+		if attachedBridge == bridge {
+			return ii
+		}
+	}
+	return len(scheduler.bridges)
+}
+
+// admitIncoming assigns ticks to the incoming messages. Messages are sorted by bridge and link first, so that the
+// random ticks are drawn in the same order regardless of the order in which the messages arrived. Messages on the
+// same link keep the order in which they were sent. The caller must hold mtx.
+func (scheduler *DeliveryScheduler) admitIncoming() {
+	incoming := scheduler.incoming
+	scheduler.incoming = nil
+	sort.SliceStable(incoming, func(ii, jj int) bool {
+		bridgeII, bridgeJJ := scheduler.bridgeIndex(incoming[ii].bridge), scheduler.bridgeIndex(incoming[jj].bridge)
+		if bridgeII != bridgeJJ {
+			return bridgeII < bridgeJJ
+		}
+		if incoming[ii].link.index != incoming[jj].link.index {
+			return incoming[ii].link.index < incoming[jj].link.index
+		}
+		return incoming[ii].arrivalSeq < incoming[jj].arrivalSeq
+	})
+
+	for _, msg := range incoming {
+		tick := scheduler.currentTick + 1
+		if scheduler.maxJitter > 0 {
+			tick += uint64(scheduler.rng.Int63n(scheduler.maxJitter + 1))
+		}
+		if lastTick := scheduler.lastTicks[msg.link]; tick < lastTick {
+			tick = lastTick
+		}
+		scheduler.lastTicks[msg.link] = tick
+		msg.tick = tick
+		scheduler.pending = append(scheduler.pending, msg)
+	}
+}
+
+// popNext removes the next message to deliver from the pending messages. Ties between ticks are broken by the
+// bridge, the link, and finally the order of the messages on the link. The caller must hold mtx.
+func (scheduler *DeliveryScheduler) popNext() *scheduledMessage {
+	if len(scheduler.pending) == 0 {
+		return nil
+	}
+	nextIndex := 0
+	for ii, msg := range scheduler.pending[1:] {
+		if scheduler.deliversBefore(msg, scheduler.pending[nextIndex]) {
+			nextIndex = ii + 1
+		}
+	}
+	next := scheduler.pending[nextIndex]
+	scheduler.pending = append(scheduler.pending[:nextIndex], scheduler.pending[nextIndex+1:]...)
+	return next
+}
+
+func (scheduler *DeliveryScheduler) deliversBefore(a *scheduledMessage, b *scheduledMessage) bool {
+	if a.tick != b.tick {
+		return a.tick < b.tick
+	}
+	if bridgeA, bridgeB := scheduler.bridgeIndex(a.bridge), scheduler.bridgeIndex(b.bridge); bridgeA != bridgeB {
+		return bridgeA < bridgeB
+	}
+	if a.link.index != b.link.index {
+		return a.link.index < b.link.index
+	}
+	return a.arrivalSeq < b.arrivalSeq
+}
+
+// Step waits for the nodes to settle, and delivers the next message. Returns false if there were no messages to
+// deliver.
+func (scheduler *DeliveryScheduler) Step() bool {
+	scheduler.waitToSettle()
+
+	scheduler.mtx.Lock()
+	scheduler.admitIncoming()
+	next := scheduler.popNext()
+	if next == nil {
+		scheduler.mtx.Unlock()
+		return false
+	}
+	if next.tick > scheduler.currentTick {
+		scheduler.currentTick = next.tick
+	}
+	scheduler.history = append(scheduler.history, MessageEvent{next.link.direction, next.bridgeMsg.msg.GetMsgType()})
+	scheduler.mtx.Unlock()
+
+	next.bridge.deliverScheduledMessage(next.link, next.bridgeMsg)
+	return true
+}
+
+// Run steps through the messages until the nodes stop sending them, and returns the number of delivered messages.
+func (scheduler *DeliveryScheduler) Run() int {
+	steps := 0
+	for scheduler.Step() {
+		steps++
+	}
+	return steps
+}
+
+// History returns the messages delivered by the scheduler so far, in the order of delivery.
+func (scheduler *DeliveryScheduler) History() []MessageEvent {
+	scheduler.mtx.Lock()
+	defer scheduler.mtx.Unlock()
+	return append([]MessageEvent{}, scheduler.history...)
+}
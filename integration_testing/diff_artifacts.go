@@ -0,0 +1,34 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/lib"
+	"os"
+	"strings"
+	"testing"
+)
+
+// diffArtifactDirEnvVar can be set to a directory, to which the state comparison helpers write the diffs of failed
+// comparisons, e.g. a directory that CI keeps as a build artifact. By default, diffs are written to the system's
+// temporary directory.
+const diffArtifactDirEnvVar = "DESO_DIFF_ARTIFACT_DIR"
+
+// writeStateDiffArtifact saves the diff, with all keys and values decoded, as a JSON lib.StateDiffReport named after
+// the test, and returns the path of the file. Saved diffs can be printed with scripts/state_diff. Failing to write the
+// diff doesn't fail the test, since it's only called once the comparison already failed, so the problem is returned
+// in place of the path.
+func writeStateDiffArtifact(t testing.TB, diff *lib.StateDiff) string {
+	dir := os.Getenv(diffArtifactDirEnvVar)
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	file, err := os.CreateTemp(dir, strings.ReplaceAll(t.Name(), "/", "_")+"_state_diff_*.json")
+	if err != nil {
+		return fmt.Sprintf("failed to create file in (%v): %v", dir, err)
+	}
+	file.Close()
+	if err := lib.WriteStateDiffReport(file.Name(), lib.NewStateDiffReport(diff)); err != nil {
+		return fmt.Sprintf("failed to write (%v): %v", file.Name(), err)
+	}
+	return file.Name()
+}
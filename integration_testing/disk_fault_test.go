@@ -0,0 +1,84 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestBlockSyncDiskFullRecovery test if a node recovers from its disk filling up while syncing blocks:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks, node2 with a db fault injector.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
+//  3. bridge node1 and node2
+//  4. node2 syncs between 10 and MaxSyncBlockHeight blocks from node1.
+//  5. node2's db writes fail with ENOSPC, and node2 crashes without a graceful shutdown.
+//  6. node2 starts again from the same data directory with a working disk, reconnects with node1, and syncs remaining
+//     blocks.
+//  7. compare node1 checksum matches node2.
+func TestBlockSyncDiskFullRecovery(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.HyperSync = true
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.HyperSync = true
+	config2.SyncType = lib.NodeSyncTypeBlockSync
+	injector := &lib.DBFaultInjector{}
+	config2.DBFaultInjector = injector
+
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge the nodes together.
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+
+	randomHeight := randomUint32Between(t, 10, config2.MaxSyncBlockHeight)
+	fmt.Println("Random height for a disk fault (re-use if test failed):", randomHeight)
+	waitForBlockHeight(t, node2, randomHeight)
+
+	// fill up node2's disk, and wait for its writes to start failing.
+	injector.FailWritesFor(time.Hour, syscall.ENOSPC)
+	deadline := time.After(2 * time.Minute)
+	for injector.NumFailedWrites() == 0 {
+		select {
+		case <-deadline:
+			t.Fatalf("node2 didn't attempt any writes after its disk filled up")
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	// the node is killed with a full disk, and restarted once the disk is fixed.
+	node2, bridge = replaceAndReconnectNode(t, node2, bridge, func(t testing.TB, node *cmd.Node) *cmd.Node {
+		newNode := crashNode(t, node)
+		injector.Disarm()
+		return startNode(t, newNode)
+	})
+	waitForNodeToFullySync(t, node2)
+
+	compareNodesByChecksum(t, node1, node2)
+	fmt.Println("Disk fault recovery successful! Random height was", randomHeight)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
@@ -0,0 +1,280 @@
+package integration_testing
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// A golden state file freezes a known-good node state, so that future test runs can be compared against it rather
+// than only against another live node, which catches consensus changes that affect all nodes alike. The file is a gzip
+// stream of:
+//
+//	magic goldenStateMagic
+//	version, block tip height, and number of prefixes, all uvarints
+//	the state prefixes, each as a byte array
+//	the entries of all prefixes in increasing key order, each as a one byte followed by the key and value byte arrays
+//	a zero byte marking the end of the entries
+//
+// Byte arrays are encoded with lib.EncodeByteArray. Entries are written and read one at a time, so files with
+// gigabytes of state never have to fit in memory.
+var goldenStateMagic = []byte("DESO-GOLDEN-STATE")
+
+// goldenStateVersion is the version of the golden state file format. Bump it whenever the format changes.
+const goldenStateVersion = 1
+
+// saveNodeStateGolden writes all state entries of the node under comparableStatePrefixes to a golden state file at
+// path, replacing any existing file.
+func saveNodeStateGolden(t testing.TB, node *cmd.Node, path string) {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		t.Fatalf("saveNodeStateGolden: Problem creating directory for (%v): %v", path, err)
+	}
+	// Write to a temporary file first, so that a failed save doesn't leave a truncated golden file behind.
+	tempPath := path + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		t.Fatalf("saveNodeStateGolden: Problem creating file (%v): %v", tempPath, err)
+	}
+	defer os.Remove(tempPath)
+
+	if err := writeGoldenState(node, file); err != nil {
+		file.Close()
+		t.Fatalf("saveNodeStateGolden: Problem writing golden state to (%v): %v", tempPath, err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatalf("saveNodeStateGolden: Problem closing file (%v): %v", tempPath, err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		t.Fatalf("saveNodeStateGolden: Problem renaming (%v) to (%v): %v", tempPath, path, err)
+	}
+	fmt.Printf("Saved golden state at height (%v) to (%v)\n", node.Server.GetBlockchain().BlockTip().Height, path)
+}
+
+func writeGoldenState(node *cmd.Node, writer io.Writer) error {
+	gzipWriter := gzip.NewWriter(writer)
+	bufWriter := bufio.NewWriter(gzipWriter)
+
+	prefixList := sortedPrefixes(comparableStatePrefixes())
+	header := append([]byte{}, goldenStateMagic...)
+	header = append(header, lib.UintToBuf(goldenStateVersion)...)
+	header = append(header, lib.UintToBuf(uint64(node.Server.GetBlockchain().BlockTip().Height))...)
+	header = append(header, lib.UintToBuf(uint64(len(prefixList)))...)
+	for _, prefix := range prefixList {
+		header = append(header, lib.EncodeByteArray(prefix)...)
+	}
+	if _, err := bufWriter.Write(header); err != nil {
+		return errors.Wrapf(err, "writeGoldenState: Problem writing header")
+	}
+
+	for _, prefix := range prefixList {
+		startKey := prefix
+		var lastKey []byte
+		for {
+			dbEntries, isChunkFull, err := lib.DBIteratePrefixKeys(node.ChainDB, prefix, startKey,
+				lib.SnapshotBatchSize)
+			if err != nil {
+				return errors.Wrapf(err, "writeGoldenState: Problem reading prefix (%v)", prefix)
+			}
+			for _, entry := range dbEntries {
+				// Every chunk starts at the last key of the previous chunk, which was already written.
+				if lastKey != nil && bytes.Equal(entry.Key, lastKey) {
+					continue
+				}
+				record := append([]byte{1}, lib.EncodeByteArray(entry.Key)...)
+				record = append(record, lib.EncodeByteArray(entry.Value)...)
+				if _, err := bufWriter.Write(record); err != nil {
+					return errors.Wrapf(err, "writeGoldenState: Problem writing entry")
+				}
+				lastKey = entry.Key
+			}
+			if !isChunkFull || len(dbEntries) == 0 {
+				break
+			}
+			startKey = dbEntries[len(dbEntries)-1].Key
+		}
+	}
+
+	if err := bufWriter.WriteByte(0); err != nil {
+		return errors.Wrapf(err, "writeGoldenState: Problem writing end marker")
+	}
+	if err := bufWriter.Flush(); err != nil {
+		return errors.Wrapf(err, "writeGoldenState: Problem flushing entries")
+	}
+	return gzipWriter.Close()
+}
+
+// compareNodeToGolden compares the node's state to the golden state file at path, and fails the test if they differ.
+// The node's block tip has to be at the height at which the golden state was saved.
+func compareNodeToGolden(t testing.TB, node *cmd.Node, path string, verbosity Verbosity) {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("compareNodeToGolden: Problem opening golden state file (%v): %v", path, err)
+	}
+	defer file.Close()
+
+	golden, err := newGoldenStateReader(file)
+	if err != nil {
+		t.Fatalf("compareNodeToGolden: Problem reading golden state file (%v): %v", path, err)
+	}
+	defer golden.Close()
+
+	if height := uint64(node.Server.GetBlockchain().BlockTip().Height); height != golden.height {
+		t.Fatalf("compareNodeToGolden: Node is at height (%v), but golden state (%v) was saved at height (%v)",
+			height, path, golden.height)
+	}
+	prefixList := sortedPrefixes(comparableStatePrefixes())
+	if !reflect.DeepEqual(prefixList, golden.prefixes) {
+		t.Fatalf("compareNodeToGolden: State prefixes changed since golden state (%v) was saved, regenerate it; "+
+			"current prefixes (%v), golden prefixes (%v)", path, prefixList, golden.prefixes)
+	}
+
+	// The golden state is a single stream, so its prefixes have to be compared one after another.
+	compareStateWithPrefixList(t, lib.NewDBStateChunkReader(node.ChainDB), golden.readChunk, prefixList, verbosity,
+		lib.DiffOptions{Workers: 1})
+}
+
+// goldenStateReader streams the entries of a golden state file. It serves chunks in the same way as
+// lib.DBIteratePrefixKeys, as long as the chunks are requested in increasing key order, which is how
+// lib.DiffStates reads them with a single worker. It isn't safe for concurrent use.
+type goldenStateReader struct {
+	gzipReader *gzip.Reader
+	reader     *bufio.Reader
+
+	height   uint64
+	prefixes [][]byte
+
+	// peeked is the next entry in the file, which was read but not yet returned in a chunk.
+	peeked *lib.DBEntry
+	// last is the last entry returned in a chunk. The next chunk starts at its key, so it can be returned again.
+	last *lib.DBEntry
+	done bool
+}
+
+func newGoldenStateReader(file io.Reader) (*goldenStateReader, error) {
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "newGoldenStateReader: Problem opening gzip stream")
+	}
+	golden := &goldenStateReader{
+		gzipReader: gzipReader,
+		reader:     bufio.NewReader(gzipReader),
+	}
+
+	magic := make([]byte, len(goldenStateMagic))
+	if _, err := io.ReadFull(golden.reader, magic); err != nil || !bytes.Equal(magic, goldenStateMagic) {
+		return nil, fmt.Errorf("newGoldenStateReader: Not a golden state file")
+	}
+	version, err := lib.ReadUvarint(golden.reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "newGoldenStateReader: Problem reading version")
+	}
+	if version != goldenStateVersion {
+		return nil, fmt.Errorf("newGoldenStateReader: Unsupported version (%v), expected (%v)", version,
+			goldenStateVersion)
+	}
+	if golden.height, err = lib.ReadUvarint(golden.reader); err != nil {
+		return nil, errors.Wrapf(err, "newGoldenStateReader: Problem reading height")
+	}
+	numPrefixes, err := lib.ReadUvarint(golden.reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "newGoldenStateReader: Problem reading number of prefixes")
+	}
+	for ii := uint64(0); ii < numPrefixes; ii++ {
+		prefix, err := lib.DecodeByteArray(golden.reader)
+		if err != nil {
+			return nil, errors.Wrapf(err, "newGoldenStateReader: Problem reading prefix")
+		}
+		golden.prefixes = append(golden.prefixes, prefix)
+	}
+	return golden, nil
+}
+
+// Close releases the gzip stream. It doesn't close the underlying file.
+func (golden *goldenStateReader) Close() error {
+	return golden.gzipReader.Close()
+}
+
+// peek returns the next entry in the file without consuming it, or nil once all entries were read.
+func (golden *goldenStateReader) peek() (*lib.DBEntry, error) {
+	if golden.peeked != nil || golden.done {
+		return golden.peeked, nil
+	}
+
+	marker, err := golden.reader.ReadByte()
+	if err != nil {
+		return nil, errors.Wrapf(err, "goldenStateReader.peek: Problem reading entry marker")
+	}
+	if marker == 0 {
+		golden.done = true
+		return nil, nil
+	}
+	key, err := lib.DecodeByteArray(golden.reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "goldenStateReader.peek: Problem reading key")
+	}
+	value, err := lib.DecodeByteArray(golden.reader)
+	if err != nil {
+		return nil, errors.Wrapf(err, "goldenStateReader.peek: Problem reading value")
+	}
+	// KeyValueToDBEntry turns empty values into empty slices rather than nil, like entries read from a db.
+	golden.peeked = lib.KeyValueToDBEntry(key, value)
+	return golden.peeked, nil
+}
+
+// readChunk is a lib.StateChunkReader over the golden state file.
+func (golden *goldenStateReader) readChunk(prefix []byte, startKey []byte, targetBytes uint32) (
+	[]*lib.DBEntry, bool, error) {
+
+	var dbEntries []*lib.DBEntry
+	var totalBytes int
+	isChunkFull := false
+	addEntry := func(entry *lib.DBEntry) {
+		dbEntries = append(dbEntries, entry)
+		totalBytes += len(entry.Key) + len(entry.Value)
+		if totalBytes > int(targetBytes) && len(dbEntries) > 1 {
+			isChunkFull = true
+		}
+	}
+
+	if golden.last != nil && bytes.HasPrefix(golden.last.Key, prefix) && bytes.Compare(golden.last.Key, startKey) >= 0 {
+		addEntry(golden.last)
+	}
+	for !isChunkFull {
+		entry, err := golden.peek()
+		if err != nil {
+			return nil, false, err
+		}
+		if entry == nil || (!bytes.HasPrefix(entry.Key, prefix) && bytes.Compare(entry.Key, prefix) > 0) {
+			break
+		}
+		golden.peeked = nil
+		// Skip the entries before the start key, including entries of prefixes that weren't requested.
+		if bytes.Compare(entry.Key, startKey) < 0 {
+			continue
+		}
+		addEntry(entry)
+	}
+
+	if len(dbEntries) > 0 {
+		golden.last = dbEntries[len(dbEntries)-1]
+	}
+	return dbEntries, isChunkFull, nil
+}
+
+// sortedPrefixes sorts the prefixes by their first byte, in the same order as lib.DiffStates.
+func sortedPrefixes(prefixList [][]byte) [][]byte {
+	sort.Slice(prefixList, func(ii, jj int) bool {
+		return prefixList[ii][0] < prefixList[jj][0]
+	})
+	return prefixList
+}
@@ -0,0 +1,32 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRegtestGoldenStateRoundTrip test if a node's state matches the golden state saved from it:
+//  1. Spawn a regtest node, and mine a few blocks on it.
+//  2. save the node's state to a golden state file.
+//  3. compare the node to the golden state file, which should match.
+func TestRegtestGoldenStateRoundTrip(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir := getDirectory(t)
+	defer os.RemoveAll(dbDir)
+
+	node := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir, 10)))
+	mineBlocks(t, node, 3)
+
+	goldenPath := filepath.Join(getDirectory(t), "regtest.golden")
+	defer os.RemoveAll(filepath.Dir(goldenPath))
+	saveNodeStateGolden(t, node, goldenPath)
+	compareNodeToGolden(t, node, goldenPath, Summary)
+	fmt.Println("Databases match!")
+	node.Stop()
+}
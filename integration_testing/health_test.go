@@ -0,0 +1,75 @@
+package integration_testing
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestRegtestHealthz tests if the health checks follow the node's lifecycle:
+//  1. Spawn a regtest node1 that isn't standalone, with a metrics port. It should be live but not ready, since it
+//     has no peers.
+//  2. bridge node1 to a standalone regtest node2. node1 should become ready, and /readyz should respond 200.
+//  3. flag node1's snapshot as broken. node1 should neither be live nor ready, with the reason in the report.
+//  4. stop node1. It should report that it isn't running.
+func TestRegtestHealthz(t *testing.T) {
+	require := require.New(t)
+
+	config1 := NewTestConfig(t, WithRegtest(), WithMetricsPort(getFreePort(t)))
+	config1.Standalone = false
+	node1 := cmd.NewNode(config1)
+	// startNode waits for readiness, which node1 won't reach without a peer.
+	trackNode(t, node1)
+	require.NoError(node1.Start())
+	node2 := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest())))
+
+	report := node1.Healthz()
+	require.True(report.Live)
+	require.False(report.Ready)
+	require.Len(report.Failing(), 1)
+	require.Equal("peers", report.Failing()[0].Name)
+	require.Equal(cmd.HealthProbeReadiness, report.Failing()[0].Probe)
+	require.Equal(http.StatusServiceUnavailable, getProbeStatus(t, node1, "readyz"))
+	require.Equal(http.StatusOK, getProbeStatus(t, node1, "healthz"))
+
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+	require.Eventually(func() bool {
+		return node1.Healthz().Ready
+	}, time.Minute, 10*time.Millisecond)
+	require.Equal(http.StatusOK, getProbeStatus(t, node1, "readyz"))
+
+	node1.Server.MarkFatal("snapshot", fmt.Errorf("checksum mismatch"))
+	report = node1.Healthz()
+	require.False(report.Live)
+	require.False(report.Ready)
+	require.Len(report.Failing(), 1)
+	require.Equal("subsystems", report.Failing()[0].Name)
+	require.Contains(report.Failing()[0].Reason, "The snapshot is broken: checksum mismatch")
+	require.Equal(http.StatusServiceUnavailable, getProbeStatus(t, node1, "healthz"))
+
+	bridge.Disconnect()
+	require.NoError(node1.Stop())
+	report = node1.Healthz()
+	require.False(report.Live)
+	require.Equal("running", report.Failing()[0].Name)
+}
+
+// getProbeStatus requests the node's health probe, healthz or readyz, and returns the status code of the response.
+func getProbeStatus(t testing.TB, node *cmd.Node, probe string) int {
+	response, err := http.Get(fmt.Sprintf("http://%v/%v", node.MetricsAddr(), probe))
+	if err != nil {
+		t.Fatalf("getProbeStatus: Problem requesting (%v): %v", probe, err)
+	}
+	defer response.Body.Close()
+
+	var report cmd.HealthReport
+	if err := json.NewDecoder(response.Body).Decode(&report); err != nil {
+		t.Fatalf("getProbeStatus: Problem decoding (%v) report: %v", probe, err)
+	}
+	return response.StatusCode
+}
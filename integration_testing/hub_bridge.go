@@ -0,0 +1,203 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"sync"
+	"time"
+)
+
+// HubBridge relays traffic between any number of nodes, as if all of them were connected to a single relay. Every
+// node that joins the hub is bridged with every other node through its own link, which is a regular ConnectionBridge.
+// The hub keeps default link settings, such as the latency and drop rate, which are applied to all links, including
+// the ones created later when more nodes join. Each link can still be tuned individually through HubBridge.Link().
+//
+// Disconnecting a node from the hub only tears down the links of that node, which is useful for simulating a single
+// leaf node going offline while the rest of the network keeps running.
+type HubBridge struct {
+	mtx sync.Mutex
+	// nodes are the nodes connected to the hub, in the order in which they joined.
+	nodes []*cmd.Node
+	// links maps each pair of nodes, in both orders, to the bridge between them.
+	links map[TopologyEdge]*ConnectionBridge
+	// edges are the linked pairs of nodes, in the order in which the links were started.
+	edges   []TopologyEdge
+	started bool
+
+	// Default link settings, see SetLatency and SetDropRate.
+	minLatency   time.Duration
+	maxLatency   time.Duration
+	dropRate     float64
+	dropMsgTypes []lib.MsgType
+}
+
+// NewHubBridge creates a hub for the provided nodes. This function is usually followed by HubBridge.Start().
+func NewHubBridge(nodes ...*cmd.Node) *HubBridge {
+	return &HubBridge{
+		nodes: append([]*cmd.Node{}, nodes...),
+		links: make(map[TopologyEdge]*ConnectionBridge),
+	}
+}
+
+// SetLatency sets the latency of all links in the hub, see ConnectionBridge.SetLatency.
+func (hub *HubBridge) SetLatency(minLatency time.Duration, maxLatency time.Duration) {
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	hub.minLatency = minLatency
+	hub.maxLatency = maxLatency
+	for _, edge := range hub.edges {
+		hub.links[edge].SetLatency(minLatency, maxLatency)
+	}
+}
+
+// SetDropRate sets the drop rate of all links in the hub, see ConnectionBridge.SetDropRate.
+func (hub *HubBridge) SetDropRate(rate float64, msgTypes ...lib.MsgType) {
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	hub.dropRate = rate
+	hub.dropMsgTypes = msgTypes
+	for _, edge := range hub.edges {
+		hub.links[edge].SetDropRate(rate, msgTypes...)
+	}
+}
+
+// Start links together all nodes connected to the hub. If any of the links fails to start, the links that were
+// already started are disconnected.
+func (hub *HubBridge) Start() error {
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	if hub.started {
+		return fmt.Errorf("HubBridge.Start: Hub is already started")
+	}
+	for ii := 0; ii < len(hub.nodes); ii++ {
+		for jj := ii + 1; jj < len(hub.nodes); jj++ {
+			if err := hub.startLink(hub.nodes[ii], hub.nodes[jj]); err != nil {
+				hub.disconnectLinks()
+				return fmt.Errorf("HubBridge.Start: %v", err)
+			}
+		}
+	}
+	hub.started = true
+	return nil
+}
+
+// AddNode connects a new node to the hub. If the hub is already started, the node is linked with all other nodes
+// right away.
+func (hub *HubBridge) AddNode(node *cmd.Node) error {
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	for _, hubNode := range hub.nodes {
+		if hubNode == node {
+			return fmt.Errorf("HubBridge.AddNode: Node on port (%v) is already connected to the hub",
+				node.ListeningPort())
+		}
+	}
+	if hub.started {
+		for _, hubNode := range hub.nodes {
+			if err := hub.startLink(hubNode, node); err != nil {
+				hub.disconnectNodeLinks(node)
+				return fmt.Errorf("HubBridge.AddNode: %v", err)
+			}
+		}
+	}
+	hub.nodes = append(hub.nodes, node)
+	return nil
+}
+
+// startLink bridges the two nodes together with the hub's default link settings.
+func (hub *HubBridge) startLink(nodeA *cmd.Node, nodeB *cmd.Node) error {
+	link := NewConnectionBridge(nodeA, nodeB)
+	link.SetLatency(hub.minLatency, hub.maxLatency)
+	link.SetDropRate(hub.dropRate, hub.dropMsgTypes...)
+	if err := link.Start(); err != nil {
+		return fmt.Errorf("Problem starting link between nodes on ports (%v) and (%v): %v",
+			nodeA.ListeningPort(), nodeB.ListeningPort(), err)
+	}
+
+	edge := TopologyEdge{nodeA, nodeB}
+	hub.edges = append(hub.edges, edge)
+	hub.links[edge] = link
+	hub.links[TopologyEdge{nodeB, nodeA}] = link
+	return nil
+}
+
+// Nodes returns the nodes connected to the hub.
+func (hub *HubBridge) Nodes() []*cmd.Node {
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+	return append([]*cmd.Node{}, hub.nodes...)
+}
+
+// Link returns the bridge between the two nodes, in either order, or nil if the nodes aren't linked. The link can be
+// used to override the hub's default settings for this pair of nodes.
+func (hub *HubBridge) Link(nodeA *cmd.Node, nodeB *cmd.Node) *ConnectionBridge {
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+	return hub.links[TopologyEdge{nodeA, nodeB}]
+}
+
+// Stats returns the traffic statistics of the link between the two nodes. Directions are relative to the order in
+// which the nodes joined the hub, i.e. DirectionAToB is from the node that joined first.
+func (hub *HubBridge) Stats(nodeA *cmd.Node, nodeB *cmd.Node) (BridgeStats, error) {
+	link := hub.Link(nodeA, nodeB)
+	if link == nil {
+		return BridgeStats{}, fmt.Errorf("HubBridge.Stats: Nodes on ports (%v) and (%v) aren't linked",
+			nodeA.ListeningPort(), nodeB.ListeningPort())
+	}
+	return link.Stats(), nil
+}
+
+// DisconnectNode tears down all links of the node and removes it from the hub, without affecting links between
+// other nodes.
+func (hub *HubBridge) DisconnectNode(node *cmd.Node) error {
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	for ii, hubNode := range hub.nodes {
+		if hubNode == node {
+			hub.disconnectNodeLinks(node)
+			hub.nodes = append(hub.nodes[:ii], hub.nodes[ii+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("HubBridge.DisconnectNode: Node on port (%v) isn't connected to the hub",
+		node.ListeningPort())
+}
+
+// disconnectNodeLinks tears down all links of the node.
+func (hub *HubBridge) disconnectNodeLinks(node *cmd.Node) {
+	var remainingEdges []TopologyEdge
+	for _, edge := range hub.edges {
+		if edge.NodeA != node && edge.NodeB != node {
+			remainingEdges = append(remainingEdges, edge)
+			continue
+		}
+		hub.links[edge].Disconnect()
+		delete(hub.links, edge)
+		delete(hub.links, TopologyEdge{edge.NodeB, edge.NodeA})
+	}
+	hub.edges = remainingEdges
+}
+
+// disconnectLinks tears down all links in the hub.
+func (hub *HubBridge) disconnectLinks() {
+	for _, edge := range hub.edges {
+		hub.links[edge].Disconnect()
+	}
+	hub.links = make(map[TopologyEdge]*ConnectionBridge)
+	hub.edges = nil
+}
+
+// Disconnect tears down all links in the hub.
+func (hub *HubBridge) Disconnect() {
+	hub.mtx.Lock()
+	defer hub.mtx.Unlock()
+
+	hub.disconnectLinks()
+	hub.started = false
+}
@@ -0,0 +1,69 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRegtestHubBlockPropagation test if blocks propagate through a hub, and if a node can leave the hub without
+// affecting the others:
+//  1. Spawn five regtest nodes and connect all of them to a hub with a simulated latency.
+//  2. mine a few blocks on the first node and wait for all nodes to sync them.
+//  3. disconnect the last node from the hub.
+//  4. mine a few more blocks on the first node, all remaining nodes should sync them.
+//  5. the disconnected node should stay behind, while the remaining nodes have matching state.
+func TestRegtestHubBlockPropagation(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	const numNodes = 5
+	var nodes []*cmd.Node
+	for ii := 0; ii < numNodes; ii++ {
+		dbDir := getDirectory(t)
+		defer os.RemoveAll(dbDir)
+
+		config := generateRegtestConfig(t, dbDir, 10)
+		nodes = append(nodes, startNode(t, cmd.NewNode(config)))
+	}
+
+	// connect all nodes to the hub and measure how long it takes for the blocks to propagate.
+	hub := NewHubBridge(nodes...)
+	hub.SetLatency(20*time.Millisecond, 50*time.Millisecond)
+	require.NoError(hub.Start())
+	startTime := time.Now()
+	mineBlocks(t, nodes[0], 5)
+	waitForNodesToConverge(t, nodes, time.Minute)
+	fmt.Printf("Blocks propagated to %v nodes in %v\n", numNodes, time.Since(startTime))
+
+	stats, err := hub.Stats(nodes[0], nodes[1])
+	require.NoError(err)
+	require.Greater(stats.Get(DirectionAToB, lib.MsgTypeBlock).Count, uint64(0))
+
+	// disconnect the last node, the others should keep syncing.
+	leaver := nodes[numNodes-1]
+	require.NoError(hub.DisconnectNode(leaver))
+	require.Nil(hub.Link(nodes[0], leaver))
+	require.Error(hub.DisconnectNode(leaver))
+	leaverHeight := leaver.Server.GetBlockchain().BlockTip().Height
+
+	remaining := nodes[:numNodes-1]
+	mineBlocks(t, nodes[0], 3)
+	waitForNodesToConverge(t, remaining, time.Minute)
+	require.Equal(leaverHeight, leaver.Server.GetBlockchain().BlockTip().Height)
+	for _, node := range remaining[1:] {
+		stats, err := hub.Stats(nodes[0], node)
+		require.NoError(err)
+		require.Zero(stats.LinkFailures)
+		compareNodesByState(t, nodes[0], node, Summary)
+	}
+	fmt.Println("Databases match!")
+	hub.Disconnect()
+	for _, node := range nodes {
+		node.Stop()
+	}
+}
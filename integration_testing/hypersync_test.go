@@ -1,12 +1,13 @@
 package integration_testing
 
 import (
+	"context"
 	"fmt"
 	"github.com/deso-protocol/core/cmd"
 	"github.com/deso-protocol/core/lib"
 	"github.com/stretchr/testify/require"
-	"os"
 	"testing"
+	"time"
 )
 
 // TestSimpleHyperSync test if a node can successfully hyper sync from another node:
@@ -19,39 +20,32 @@ func TestSimpleHyperSync(t *testing.T) {
 	require := require.New(t)
 	_ = require
 
-	dbDir1 := getDirectory(t)
-	dbDir2 := getDirectory(t)
-	defer os.RemoveAll(dbDir1)
-	defer os.RemoveAll(dbDir2)
-
-	config1 := generateConfig(t, 18000, dbDir1, 10)
-	config1.SyncType = lib.NodeSyncTypeBlockSync
-	config2 := generateConfig(t, 18001, dbDir2, 10)
-	config2.SyncType = lib.NodeSyncTypeHyperSync
-
-	config1.HyperSync = true
-	config2.HyperSync = true
-	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+	config1 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithConnectIPs("deso-seed-2.io:17000"))
+	config2 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithSyncType(lib.NodeSyncTypeHyperSync))
 
 	node1 := cmd.NewNode(config1)
 	node2 := cmd.NewNode(config2)
 
 	node1 = startNode(t, node1)
 	node2 = startNode(t, node2)
+	metrics2 := NewSyncMetrics(t, node2)
 
 	// wait for node1 to sync blocks
-	waitForNodeToFullySync(node1)
+	waitForNodeToFullySync(t, node1)
 
 	// bridge the nodes together.
 	bridge := NewConnectionBridge(node1, node2)
 	require.NoError(bridge.Start())
 
-	// wait for node2 to sync blocks.
-	waitForNodeToFullySync(node2)
+	// wait for node2 to sync blocks, without rewinding its tip after hypersync.
+	waitForNodeToFullySyncStrict(t, node2)
+	metrics2.AssertSyncFasterThan(defaultSyncTimeout)
+	require.NotZero(metrics2.Summary().HyperSyncBytes)
 
-	compareNodesByState(t, node1, node2, 0)
-	//compareNodesByDB(t, node1, node2, 0)
+	compareNodesByState(t, node1, node2, Summary)
+	//compareNodesByDB(t, node1, node2, Summary)
 	compareNodesByChecksum(t, node1, node2)
+	verifyNodeChecksumSelfConsistent(t, node2, uint64(node2.Server.GetBlockchain().BlockTip().Height))
 	fmt.Println("Databases match!")
 	node1.Stop()
 	node2.Stop()
@@ -68,24 +62,9 @@ func TestHyperSyncFromHyperSyncedNode(t *testing.T) {
 	require := require.New(t)
 	_ = require
 
-	dbDir1 := getDirectory(t)
-	dbDir2 := getDirectory(t)
-	dbDir3 := getDirectory(t)
-	defer os.RemoveAll(dbDir1)
-	defer os.RemoveAll(dbDir2)
-	defer os.RemoveAll(dbDir3)
-
-	config1 := generateConfig(t, 18000, dbDir1, 10)
-	config1.SyncType = lib.NodeSyncTypeBlockSync
-	config2 := generateConfig(t, 18001, dbDir2, 10)
-	config2.SyncType = lib.NodeSyncTypeHyperSyncArchival
-	config3 := generateConfig(t, 18002, dbDir3, 10)
-	config3.SyncType = lib.NodeSyncTypeHyperSyncArchival
-
-	config1.HyperSync = true
-	config2.HyperSync = true
-	config3.HyperSync = true
-	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+	config1 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithConnectIPs("deso-seed-2.io:17000"))
+	config2 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithArchivalMode())
+	config3 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithArchivalMode())
 
 	node1 := cmd.NewNode(config1)
 	node2 := cmd.NewNode(config2)
@@ -96,30 +75,32 @@ func TestHyperSyncFromHyperSyncedNode(t *testing.T) {
 	node3 = startNode(t, node3)
 
 	// wait for node1 to sync blocks
-	waitForNodeToFullySync(node1)
+	waitForNodeToFullySync(t, node1)
 
 	// bridge the nodes together.
 	bridge12 := NewConnectionBridge(node1, node2)
 	require.NoError(bridge12.Start())
 
 	// wait for node2 to sync blocks.
-	waitForNodeToFullySync(node2)
+	waitForNodeToFullySync(t, node2)
 
 	// bridge node3 to node2 to kick off hyper sync from a hyper synced node
 	bridge23 := NewConnectionBridge(node2, node3)
 	require.NoError(bridge23.Start())
 
 	// wait for node2 to sync blocks.
-	waitForNodeToFullySync(node3)
+	waitForNodeToFullySync(t, node3)
 
 	// Make sure node1 has the same database as node2
-	compareNodesByState(t, node1, node2, 0)
-	//compareNodesByDB(t, node1, node2, 0)
+	compareNodesByState(t, node1, node2, Summary)
+	//compareNodesByDB(t, node1, node2, Summary)
 	compareNodesByChecksum(t, node1, node2)
 	// Make sure node2 has the same database as node3
-	compareNodesByState(t, node2, node3, 0)
-	//compareNodesByDB(t, node2, node3, 0)
+	compareNodesByState(t, node2, node3, Summary)
+	//compareNodesByDB(t, node2, node3, Summary)
 	compareNodesByChecksum(t, node2, node3)
+	verifyNodeChecksumSelfConsistent(t, node2, uint64(node2.Server.GetBlockchain().BlockTip().Height))
+	verifyNodeChecksumSelfConsistent(t, node3, uint64(node3.Server.GetBlockchain().BlockTip().Height))
 
 	fmt.Println("Databases match!")
 	node1.Stop()
@@ -135,22 +116,34 @@ func TestHyperSyncFromHyperSyncedNode(t *testing.T) {
 //  5. node2 reconnects to node1 and hypersyncs again.
 //  6. Once node2 finishes sync, compare node1 state, db, and checksum matches node2.
 func TestSimpleHyperSyncRestart(t *testing.T) {
+	RunSyncScenario(t, SyncScenario{
+		SourceConfig: func(config *cmd.Config) {
+			config.HyperSync = true
+		},
+		SyncingConfig: func(config *cmd.Config) {
+			config.HyperSync = true
+			config.SyncType = lib.NodeSyncTypeHyperSyncArchival
+		},
+		Steps:      []SyncStep{{Trigger: AtRandomPrefix(), Action: Restart}},
+		Assertions: []SyncAssertion{ByState, ByChecksum},
+	})
+}
+
+// TestSimpleHyperSyncRestartAtPrefixCompletion tests if a node can successfully restart right after finishing a prefix
+// while hypersyncing.
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
+//  3. bridge node1 and node2
+//  4. node2 hypersyncs a random number of prefixes from node1
+//  5. once node2 has fully received a prefix, restart node2, before it gets far into the next prefix.
+//  6. node2 reconnects with node1 and hypersyncs the remaining prefixes.
+//  7. compare node1 state matches node2 state.
+func TestSimpleHyperSyncRestartAtPrefixCompletion(t *testing.T) {
 	require := require.New(t)
 	_ = require
 
-	dbDir1 := getDirectory(t)
-	dbDir2 := getDirectory(t)
-	defer os.RemoveAll(dbDir1)
-	defer os.RemoveAll(dbDir2)
-
-	config1 := generateConfig(t, 18000, dbDir1, 10)
-	config2 := generateConfig(t, 18001, dbDir2, 10)
-
-	config1.HyperSync = true
-	config1.SyncType = lib.NodeSyncTypeBlockSync
-	config2.HyperSync = true
-	config2.SyncType = lib.NodeSyncTypeHyperSyncArchival
-	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+	config1 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithConnectIPs("deso-seed-2.io:17000"))
+	config2 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithArchivalMode())
 
 	node1 := cmd.NewNode(config1)
 	node2 := cmd.NewNode(config2)
@@ -159,29 +152,78 @@ func TestSimpleHyperSyncRestart(t *testing.T) {
 	node2 = startNode(t, node2)
 
 	// wait for node1 to sync blocks
-	waitForNodeToFullySync(node1)
+	waitForNodeToFullySync(t, node1)
 
 	// bridge the nodes together.
 	bridge := NewConnectionBridge(node1, node2)
 	require.NoError(bridge.Start())
 
-	syncIndex := randomUint32Between(t, 0, uint32(len(lib.StatePrefixes.StatePrefixesList)))
+	// Skip the last prefix, so that there's always a next prefix to resume with.
+	syncIndex := randomUint32Between(t, 0, uint32(len(lib.StatePrefixes.StatePrefixesList)-1))
 	syncPrefix := lib.StatePrefixes.StatePrefixesList[syncIndex]
-	fmt.Println("Random sync prefix for a restart (re-use if test failed):", syncPrefix)
-	// Reboot node2 at a specific sync prefix and reconnect it with node1
-	node2, bridge = restartAtSyncPrefixAndReconnectNode(t, node2, node1, bridge, syncPrefix)
+	fmt.Println("Random completed sync prefix for a restart (re-use if test failed):", syncPrefix)
+	// Reboot node2 right after it completes a specific sync prefix and reconnect it with node1
+	node2, bridge = restartAtSyncPrefixCompletionAndReconnectNode(t, node2, bridge, syncPrefix)
 	// wait for node2 to sync blocks.
-	waitForNodeToFullySync(node2)
+	waitForNodeToFullySync(t, node2)
 
-	compareNodesByState(t, node1, node2, 0)
-	//compareNodesByDB(t, node1, node2, 0)
+	compareNodesByState(t, node1, node2, Summary)
 	compareNodesByChecksum(t, node1, node2)
-	fmt.Println("Random restart successful! Random sync prefix was", syncPrefix)
+	fmt.Println("Random restart successful! Random completed sync prefix was", syncPrefix)
 	fmt.Println("Databases match!")
 	node1.Stop()
 	node2.Stop()
 }
 
+// TestHyperSyncRestartAtSnapshotKeys tests if a node can successfully restart in the middle of a prefix while
+// hypersyncing.
+//  1. Spawn a node node1 with max block height of MaxSyncBlockHeight blocks.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
+//  3. for each of three keys within the DeSo balance prefix, spawn a new node node2 and bridge it with node1.
+//  4. node2 hypersyncs from node1 until it receives the key, and then restarts.
+//  5. node2 reconnects with node1 and hypersyncs the remaining state.
+//  6. compare node1 db matches node2 db.
+func TestHyperSyncRestartAtSnapshotKeys(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	config1 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithConnectIPs("deso-seed-2.io:17000"))
+
+	node1 := cmd.NewNode(config1)
+	node1 = startNode(t, node1)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// Balance keys are the prefix followed by a compressed public key, which starts with either 0x02 or 0x03.
+	balancePrefix := lib.Prefixes.PrefixPublicKeyToDeSoBalanceNanos
+	keys := [][]byte{
+		append(append([]byte{}, balancePrefix...), 0x02, 0x80),
+		append(append([]byte{}, balancePrefix...), 0x03, 0x00),
+		append(append([]byte{}, balancePrefix...), 0x03, 0x80),
+	}
+	for ii, key := range keys {
+		config2 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithArchivalMode())
+		node2 := startNode(t, cmd.NewNode(config2))
+
+		// bridge the nodes together.
+		bridge := NewConnectionBridge(node1, node2)
+		require.NoError(bridge.Start())
+
+		// Reboot node2 once it receives the key and reconnect it with node1
+		node2, bridge = restartAtSnapshotKeyAndReconnectNode(t, node2, bridge, balancePrefix, key)
+		// wait for node2 to sync blocks.
+		waitForNodeToFullySync(t, node2)
+
+		compareNodesByDB(t, node1, node2, Summary)
+		fmt.Println("Restart successful at snapshot key", key)
+		bridge.Disconnect()
+		node2.Stop()
+	}
+	fmt.Println("Databases match!")
+	node1.Stop()
+}
+
 // TestSimpleHyperSyncDisconnectWithSwitchingToNewPeer tests if a node can successfully restart while hypersyncing.
 //  1. Spawn three nodes node1, node2, and node3 with max block height of MaxSyncBlockHeight blocks.
 //  2. node1, node3 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
@@ -193,25 +235,9 @@ func TestSimpleHyperSyncDisconnectWithSwitchingToNewPeer(t *testing.T) {
 	require := require.New(t)
 	_ = require
 
-	dbDir1 := getDirectory(t)
-	dbDir2 := getDirectory(t)
-	dbDir3 := getDirectory(t)
-	defer os.RemoveAll(dbDir1)
-	defer os.RemoveAll(dbDir2)
-	defer os.RemoveAll(dbDir3)
-
-	config1 := generateConfig(t, 18000, dbDir1, 10)
-	config1.SyncType = lib.NodeSyncTypeBlockSync
-	config2 := generateConfig(t, 18001, dbDir2, 10)
-	config2.SyncType = lib.NodeSyncTypeHyperSyncArchival
-	config3 := generateConfig(t, 18002, dbDir3, 10)
-	config3.SyncType = lib.NodeSyncTypeBlockSync
-
-	config1.HyperSync = true
-	config2.HyperSync = true
-	config3.HyperSync = true
-	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
-	config3.ConnectIPs = []string{"deso-seed-2.io:17000"}
+	config1 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithConnectIPs("deso-seed-2.io:17000"))
+	config2 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithArchivalMode())
+	config3 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithConnectIPs("deso-seed-2.io:17000"))
 
 	node1 := cmd.NewNode(config1)
 	node2 := cmd.NewNode(config2)
@@ -222,9 +248,9 @@ func TestSimpleHyperSyncDisconnectWithSwitchingToNewPeer(t *testing.T) {
 	node3 = startNode(t, node3)
 
 	// wait for node1 to sync blocks
-	waitForNodeToFullySync(node1)
+	waitForNodeToFullySync(t, node1)
 	// wait for node3 to sync blocks
-	waitForNodeToFullySync(node3)
+	waitForNodeToFullySync(t, node3)
 
 	// bridge the nodes together.
 	bridge12 := NewConnectionBridge(node1, node2)
@@ -240,18 +266,18 @@ func TestSimpleHyperSyncDisconnectWithSwitchingToNewPeer(t *testing.T) {
 	require.NoError(bridge23.Start())
 
 	// Reboot node2 at a specific height and reconnect it with node1
-	//node2, bridge12 = restartAtHeightAndReconnectNode(t, node2, node1, bridge12, randomHeight)
+	//node2, bridge12 = restartAtHeightAndReconnectNode(t, node2, bridge12, randomHeight)
 	// wait for node2 to sync blocks.
-	waitForNodeToFullySync(node2)
+	waitForNodeToFullySync(t, node2)
 
 	// Compare node2 with node3.
-	compareNodesByState(t, node2, node3, 0)
-	//compareNodesByDB(t, node2, node3, 0)
+	compareNodesByState(t, node2, node3, Summary)
+	//compareNodesByDB(t, node2, node3, Summary)
 	compareNodesByChecksum(t, node2, node3)
 
 	// Compare node1 with node2.
-	compareNodesByState(t, node1, node2, 0)
-	//compareNodesByDB(t, node1, node2, 0)
+	compareNodesByState(t, node1, node2, Summary)
+	//compareNodesByDB(t, node1, node2, Summary)
 	compareNodesByChecksum(t, node1, node2)
 	fmt.Println("Random restart successful! Random sync prefix was", syncPrefix)
 	fmt.Println("Databases match!")
@@ -270,8 +296,8 @@ func TestSimpleHyperSyncDisconnectWithSwitchingToNewPeer(t *testing.T) {
 //	defer os.RemoveAll(dbDir1)
 //	defer os.RemoveAll(dbDir2)
 //
-//	config1 := generateConfig(t, 18000, dbDir1, 10)
-//	config2 := generateConfig(t, 18001, dbDir2, 10)
+//	config1 := generateConfig(t, dbDir1, 10)
+//	config2 := generateConfig(t, dbDir2, 10)
 //
 //	config1.HyperSync = true
 //	config2.HyperSync = true
@@ -284,7 +310,7 @@ func TestSimpleHyperSyncDisconnectWithSwitchingToNewPeer(t *testing.T) {
 //	node2 = startNode(t, node2)
 //
 //	// wait for node1 to sync blocks
-//	waitForNodeToFullySync(node1)
+//	waitForNodeToFullySync(t, node1)
 //
 //	// bridge the nodes together.
 //	bridge := NewConnectionBridge(node1, node2)
@@ -292,18 +318,16 @@ func TestSimpleHyperSyncDisconnectWithSwitchingToNewPeer(t *testing.T) {
 //
 //	syncIndex := randomUint32Between(t, 0, uint32(len(lib.StatePrefixes.StatePrefixesList)))
 //	lastPrefix := lib.StatePrefixes.StatePrefixesList[syncIndex]
-//	listener := make(chan bool)
-//	listenForSyncPrefix(t, node2, lastPrefix, listener)
-//	<-listener
+//	waitForSyncPrefix(t, node2, lastPrefix)
 //	bridge.Disconnect()
 //	node1 = restartNode(t, node1)
 //	bridge = NewConnectionBridge(node1, node2)
 //	require.NoError(bridge.Start())
 //	// wait for node2 to sync blocks.
-//	waitForNodeToFullySync(node2)
+//	waitForNodeToFullySync(t, node2)
 //
-//	compareNodesByState(t, node1, node2, 0)
-//	//compareNodesByDB(t, node1, node2, 0)
+//	compareNodesByState(t, node1, node2, Summary)
+//	//compareNodesByDB(t, node1, node2, Summary)
 //	compareNodesByChecksum(t, node1, node2)
 //	fmt.Println("Databases match!")
 //	node1.Stop()
@@ -314,19 +338,8 @@ func TestArchivalMode(t *testing.T) {
 	require := require.New(t)
 	_ = require
 
-	dbDir1 := getDirectory(t)
-	dbDir2 := getDirectory(t)
-	defer os.RemoveAll(dbDir1)
-	defer os.RemoveAll(dbDir2)
-
-	config1 := generateConfig(t, 18000, dbDir1, 10)
-	config2 := generateConfig(t, 18001, dbDir2, 10)
-
-	config1.HyperSync = true
-	config2.HyperSync = true
-	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
-	config1.SyncType = lib.NodeSyncTypeBlockSync
-	config2.SyncType = lib.NodeSyncTypeHyperSyncArchival
+	config1 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithConnectIPs("deso-seed-2.io:17000"))
+	config2 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithArchivalMode())
 
 	node1 := cmd.NewNode(config1)
 	node2 := cmd.NewNode(config2)
@@ -335,46 +348,71 @@ func TestArchivalMode(t *testing.T) {
 	node2 = startNode(t, node2)
 
 	// wait for node1 to sync blocks
-	waitForNodeToFullySync(node1)
+	waitForNodeToFullySync(t, node1)
 
 	// bridge the nodes together.
 	bridge := NewConnectionBridge(node1, node2)
 	require.NoError(bridge.Start())
 
 	// wait for node2 to sync blocks.
-	waitForNodeToFullySync(node2)
+	waitForNodeToFullySync(t, node2)
 
-	compareNodesByDB(t, node1, node2, 0)
+	compareNodesByDB(t, node1, node2, Summary)
 
-	//compareNodesByDB(t, node1, node2, 0)
+	//compareNodesByDB(t, node1, node2, Summary)
 	compareNodesByChecksum(t, node1, node2)
 	fmt.Println("Databases match!")
 	node1.Stop()
 	node2.Stop()
 }
 
+// TestRegtestArchivalModeResumeAfterHyperSync test if an archival node that restarts before downloading all the
+// historical blocks resumes downloading them:
+//  1. Spawn a regtest hypersync node1, and mine blocks past a snapshot epoch on it.
+//  2. bridge node1 to an archival hypersync node2, with blocks throttled so that the historical blocks take a while.
+//  3. once node2 completes the snapshot and starts syncing historical blocks, disconnect the bridge and restart node2.
+//  4. bridge the nodes again, node2 should download the remaining historical blocks.
+//  5. compare node1 and node2 dbs match.
+func TestRegtestArchivalModeResumeAfterHyperSync(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	const snapshotPeriod = 5
+	node1 := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest(), WithHyperSync(snapshotPeriod))))
+	node2 := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest(), WithHyperSync(snapshotPeriod),
+		WithArchivalMode())))
+	mineBlocks(t, node1, 4*snapshotPeriod+1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSyncTimeout)
+	defer cancel()
+	syncingHistoricalBlocks := listenForChainState(ctx, t, node2, lib.SyncStateSyncingHistoricalBlocks)
+	bridge := NewConnectionBridge(node1, node2)
+	bridge.SetMessageTypeRate(lib.MsgTypeBlock, 1)
+	require.NoError(bridge.Start())
+	waitForSignal(ctx, t, node2, syncingHistoricalBlocks, "syncing historical blocks")
+	bridge.Disconnect()
+	require.False(node2.Server.GetBlockchain().IsFullyStored())
+
+	node2 = restartNode(t, node2)
+	bridge = NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+	waitForNodeToFullySyncAndStoreAllBlocks(t, node2)
+
+	compareNodesByDB(t, node1, node2, Summary)
+	fmt.Println("Databases match!")
+	bridge.Disconnect()
+	node1.Stop()
+	node2.Stop()
+}
+
 func TestBlockSyncFromArchivalModeHyperSync(t *testing.T) {
 	require := require.New(t)
 	_ = require
 
-	dbDir1 := getDirectory(t)
-	dbDir2 := getDirectory(t)
-	dbDir3 := getDirectory(t)
-	defer os.RemoveAll(dbDir1)
-	defer os.RemoveAll(dbDir2)
-	defer os.RemoveAll(dbDir3)
-
-	config1 := generateConfig(t, 18000, dbDir1, 10)
-	config2 := generateConfig(t, 18001, dbDir2, 10)
-	config3 := generateConfig(t, 18002, dbDir3, 10)
-
-	config1.HyperSync = true
-	config1.SyncType = lib.NodeSyncTypeBlockSync
-	config2.HyperSync = true
-	config2.SyncType = lib.NodeSyncTypeHyperSyncArchival
-	config3.HyperSync = false
-	config3.SyncType = lib.NodeSyncTypeBlockSync
-	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+	config1 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithConnectIPs("deso-seed-2.io:17000"))
+	config2 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithArchivalMode())
+	config3 := NewTestConfig(t)
 
 	node1 := cmd.NewNode(config1)
 	node2 := cmd.NewNode(config2)
@@ -385,27 +423,184 @@ func TestBlockSyncFromArchivalModeHyperSync(t *testing.T) {
 	node3 = startNode(t, node3)
 
 	// wait for node1 to sync blocks
-	waitForNodeToFullySync(node1)
+	waitForNodeToFullySync(t, node1)
 
 	// bridge the nodes together.
 	bridge12 := NewConnectionBridge(node1, node2)
 	require.NoError(bridge12.Start())
 
 	// wait for node2 to sync blocks.
-	waitForNodeToFullySync(node2)
+	waitForNodeToFullySync(t, node2)
 
 	bridge23 := NewConnectionBridge(node2, node3)
 	require.NoError(bridge23.Start())
 
 	// wait for node3 to sync blocks.
-	waitForNodeToFullySync(node3)
+	waitForNodeToFullySync(t, node3)
+
+	compareNodesByDB(t, node1, node2, Summary)
+	compareNodesByDB(t, node2, node3, Summary)
+
+	//compareNodesByDB(t, node1, node2, Summary)
+	compareNodesByChecksum(t, node1, node2)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestHyperSyncChainStateSequence test if a hypersyncing node goes through the expected chain states:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks, and snapshot period of HyperSyncSnapshotPeriod.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator and builds ancestral records.
+//  3. start recording node2's chain states, and bridge node1 and node2.
+//  4. node2 hypersyncs from node1.
+//  5. node2 should have synced headers, then the snapshot, before becoming fully current, and it should never go back
+//     to syncing once fully current.
+func TestHyperSyncChainStateSequence(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	config1 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithConnectIPs("deso-seed-2.io:17000"))
+	config2 := NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithSyncType(lib.NodeSyncTypeHyperSync))
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
 
-	compareNodesByDB(t, node1, node2, 0)
-	compareNodesByDB(t, node2, node3, 0)
+	// bridge the nodes together, while recording node2's chain states.
+	recorder := recordChainStates(t, node2)
+	snapshotStarted := listenForChainState(context.Background(), t, node2, lib.SyncStateSyncingSnapshot)
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+
+	// wait for node2 to sync blocks.
+	<-snapshotStarted
+	waitForChainState(t, node2, lib.SyncStateFullyCurrent)
+	waitForNodeToFullySync(t, node2)
+	fmt.Printf("Recorded chain states: %v\n", recorder.States())
 
-	//compareNodesByDB(t, node1, node2, 0)
+	recorder.AssertSequence(t, lib.SyncStateSyncingHeaders, lib.SyncStateSyncingSnapshot, lib.SyncStateFullyCurrent)
+	recorder.AssertNoSyncingAfter(t, lib.SyncStateFullyCurrent)
 	compareNodesByChecksum(t, node1, node2)
 	fmt.Println("Databases match!")
 	node1.Stop()
 	node2.Stop()
 }
+
+// TestHyperSyncNodeEvents test if a hypersyncing node fires the expected events, in the expected order:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks, and snapshot period of
+//     HyperSyncSnapshotPeriod, and subscribe to their events before they start.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator, and completes the snapshot epoch at
+//     HyperSyncSnapshotPeriod.
+//  3. bridge node1 and node2, and node2 hypersyncs from node1.
+//  4. node2 should transition from syncing headers, to syncing the snapshot, to syncing the blocks after the snapshot,
+//     to fully current, in exactly that order, and connect the blocks after the snapshot in order.
+func TestHyperSyncNodeEvents(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	node1 := cmd.NewNode(NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod),
+		WithConnectIPs("deso-seed-2.io:17000")))
+	node2 := cmd.NewNode(NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod),
+		WithSyncType(lib.NodeSyncTypeHyperSync)))
+	epochs1, unsubscribe1 := node1.Subscribe(cmd.EventTypeSnapshotEpochCompleted)
+	defer unsubscribe1()
+	events2, unsubscribe2 := node2.Subscribe(cmd.EventTypeSyncStateChanged, cmd.EventTypeBlockConnected)
+	defer unsubscribe2()
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks, and complete the snapshot epoch.
+	waitForNodeToFullySync(t, node1)
+	select {
+	case epoch := <-epochs1:
+		require.Equal(uint64(HyperSyncSnapshotPeriod), epoch.BlockHeight)
+		require.NotEmpty(epoch.BlockHash)
+		require.NotEmpty(epoch.SnapshotChecksum)
+	case <-time.After(time.Minute):
+		t.Fatalf("node1 didn't complete the snapshot epoch at height (%v)", HyperSyncSnapshotPeriod)
+	}
+
+	// bridge the nodes together.
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+	waitForNodeToFullySync(t, node2)
+
+	// The events are forwarded asynchronously, so read them until node2 becomes fully current.
+	var transitions [][2]lib.SyncState
+	var blockHeights []uint64
+	for len(transitions) == 0 || transitions[len(transitions)-1][1] != lib.SyncStateFullyCurrent {
+		select {
+		case event := <-events2:
+			require.Zero(event.Dropped)
+			switch event.Type {
+			case cmd.EventTypeSyncStateChanged:
+				transitions = append(transitions, [2]lib.SyncState{event.OldState, event.NewState})
+			case cmd.EventTypeBlockConnected:
+				blockHeights = append(blockHeights, event.BlockHeight)
+			}
+		case <-time.After(time.Minute):
+			t.Fatalf("node2 didn't fire the event for becoming fully current, got transitions: %v", transitions)
+		}
+	}
+	fmt.Printf("Sync state transitions: %v\n", transitions)
+	require.Equal([][2]lib.SyncState{
+		{lib.SyncStateSyncingHeaders, lib.SyncStateSyncingSnapshot},
+		{lib.SyncStateSyncingSnapshot, lib.SyncStateSyncingBlocks},
+		{lib.SyncStateSyncingBlocks, lib.SyncStateFullyCurrent},
+	}, transitions)
+	// node2 connects the blocks after the snapshot one by one, up to MaxSyncBlockHeight.
+	require.GreaterOrEqual(len(blockHeights), MaxSyncBlockHeight-HyperSyncSnapshotPeriod)
+	for ii := 1; ii < len(blockHeights); ii++ {
+		require.Equal(blockHeights[ii-1]+1, blockHeights[ii])
+	}
+	require.Equal(uint64(MaxSyncBlockHeight), blockHeights[len(blockHeights)-1])
+
+	compareNodesByChecksum(t, node1, node2)
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestHyperSyncSnapshotPeriodMismatch test if a hypersyncing node refuses the snapshot of a peer with a different
+// snapshot period, instead of waiting for a snapshot the peer will never serve:
+//  1. Spawn node1 with a snapshot period of 1000, and node2 with a snapshot period of 500, both with max block height
+//     of MaxSyncBlockHeight blocks.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator, so its snapshot is at height 1000.
+//  3. bridge node1 and node2. node2 syncs the headers, and expects the snapshot at height 1500.
+//  4. node2 should refuse node1's snapshot with ErrSnapshotBehindEpoch, and not complete the hypersync.
+func TestHyperSyncSnapshotPeriodMismatch(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	config1 := NewTestConfig(t, WithHyperSync(1000), WithConnectIPs("deso-seed-2.io:17000"))
+	config2 := NewTestConfig(t, WithHyperSync(500), WithSyncType(lib.NodeSyncTypeHyperSync))
+
+	node1 := startNode(t, cmd.NewNode(config1))
+	node2 := startNode(t, cmd.NewNode(config2))
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+	require.Equal(uint64(1000), node1.Server.GetBlockchain().Snapshot().CurrentEpochSnapshotMetadata.SnapshotBlockHeight)
+
+	// bridge the nodes together.
+	snapshotStarted := listenForChainState(context.Background(), t, node2, lib.SyncStateSyncingSnapshot)
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+	<-snapshotStarted
+
+	require.Eventually(func() bool {
+		return node2.Server.HyperSyncError() != nil
+	}, time.Minute, 10*time.Millisecond)
+	err := node2.Server.HyperSyncError()
+	require.ErrorIs(err, lib.ErrSnapshotBehindEpoch)
+	require.Contains(err.Error(), fmt.Sprintf("expected height (%v)", MaxSyncBlockHeight))
+	require.Equal(lib.SyncStateSyncingSnapshot, node2.Server.GetBlockchain().ChainState())
+	require.False(node2.Server.HyperSyncProgress.Completed)
+	node1.Stop()
+	node2.Stop()
+}
@@ -0,0 +1,164 @@
+package integration_testing
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// leakCheckEnvVar controls how VerifyNoLeaks reports leaked goroutines. By default, leaks fail the test. Setting it to
+// "warn" only logs the leaks, and setting it to "off" disables the check.
+const leakCheckEnvVar = "DESO_LEAK_CHECK"
+
+// leakCheckTimeout is how long VerifyNoLeaks waits for goroutines to exit after the test finishes. Nodes and bridges
+// stop their goroutines asynchronously, so some of them are still winding down when the cleanups return.
+const leakCheckTimeout = 10 * time.Second
+
+// longLivedGoroutines are substrings of the stacks of goroutines that legitimately run for the lifetime of the
+// process, e.g. because a library starts them on first use and never stops them. They're never reported as leaks.
+var (
+	longLivedGoroutinesMtx sync.Mutex
+	longLivedGoroutines    = []string{
+		"github.com/golang/glog.(*loggingT).flushDaemon",
+		"github.com/dgraph-io/ristretto/z.(*AllocatorPool).freeupAllocators",
+		"go.opencensus.io/stats/view.(*worker).start",
+		"os/signal.signal_recv",
+		"os/signal.loop",
+		"runtime.ensureSigM",
+	}
+)
+
+// RegisterLongLivedGoroutine adds a goroutine that legitimately runs for the lifetime of the process to the allowlist
+// of VerifyNoLeaks. Goroutines whose stack contains stackSubstring, e.g. the name of their function, are never
+// reported as leaks.
+func RegisterLongLivedGoroutine(stackSubstring string) {
+	longLivedGoroutinesMtx.Lock()
+	defer longLivedGoroutinesMtx.Unlock()
+	longLivedGoroutines = append(longLivedGoroutines, stackSubstring)
+}
+
+// VerifyNoLeaks checks that the goroutines started during the test, e.g. by nodes, bridges, and listeners, exit by the
+// time the test finishes. It records the running goroutines, and registers a cleanup that reports every goroutine
+// started since then that's still running after leakCheckTimeout, unless it's allowlisted with
+// RegisterLongLivedGoroutine. Cleanups run in reverse order, so VerifyNoLeaks should be called before the nodes are
+// started, which makes the check run after the nodes are stopped. startNode calls it when it starts the first node of
+// every test.
+func VerifyNoLeaks(t testing.TB) {
+	mode := os.Getenv(leakCheckEnvVar)
+	if mode == "off" {
+		return
+	}
+	before := make(map[int]bool)
+	for _, goroutine := range runningGoroutines() {
+		before[goroutine.id] = true
+	}
+
+	t.Cleanup(func() {
+		var leaked []goroutineStack
+		deadline := time.Now().Add(leakCheckTimeout)
+		for {
+			leaked = leaked[:0]
+			for _, goroutine := range runningGoroutines() {
+				if !before[goroutine.id] && !isLongLivedGoroutine(goroutine) {
+					leaked = append(leaked, goroutine)
+				}
+			}
+			if len(leaked) == 0 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if len(leaked) == 0 {
+			return
+		}
+
+		var report strings.Builder
+		for _, goroutine := range leaked {
+			report.WriteString("\n")
+			report.WriteString(goroutine.stack)
+		}
+		if mode == "warn" {
+			t.Logf("VerifyNoLeaks: (%v) goroutines are still running after the test finished:%v", len(leaked),
+				report.String())
+			return
+		}
+		t.Errorf("VerifyNoLeaks: (%v) goroutines are still running after the test finished, set %v=warn to only "+
+			"log leaks:%v", len(leaked), leakCheckEnvVar, report.String())
+	})
+}
+
+// goroutineStack is a goroutine from a dump of all goroutines.
+type goroutineStack struct {
+	id    int
+	stack string
+}
+
+// runningGoroutines returns all running goroutines, except for the calling goroutine.
+func runningGoroutines() []goroutineStack {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+
+	var goroutines []goroutineStack
+	// The first goroutine in the dump is the calling goroutine.
+	for _, stack := range bytes.Split(buf, []byte("\n\n"))[1:] {
+		// Every stack starts with a header like "goroutine 42 [select]:".
+		var id int
+		if _, err := fmt.Sscanf(string(stack), "goroutine %d ", &id); err != nil {
+			continue
+		}
+		goroutines = append(goroutines, goroutineStack{
+			id:    id,
+			stack: string(stack),
+		})
+	}
+	return goroutines
+}
+
+func isLongLivedGoroutine(goroutine goroutineStack) bool {
+	longLivedGoroutinesMtx.Lock()
+	defer longLivedGoroutinesMtx.Unlock()
+	for _, stackSubstring := range longLivedGoroutines {
+		if strings.Contains(goroutine.stack, stackSubstring) {
+			return true
+		}
+	}
+	return false
+}
+
+// openFileDescriptors returns the number of file descriptors the process has open, e.g. for db files and sockets. It
+// returns false on platforms without /proc/self/fd.
+func openFileDescriptors() (int, bool) {
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, false
+	}
+	return len(fds), true
+}
+
+// waitForResourceCountAtMost waits until count returns at most max, e.g. until the goroutines of a stopped node exit,
+// and fails the test if it doesn't within leakCheckTimeout.
+func waitForResourceCountAtMost(t testing.TB, resource string, count func() int, max int) {
+	deadline := time.Now().Add(leakCheckTimeout)
+	current := count()
+	for current > max {
+		if time.Now().After(deadline) {
+			t.Fatalf("waitForResourceCountAtMost: (%v) %v are still open after (%v), expected at most (%v)",
+				current, resource, leakCheckTimeout, max)
+		}
+		time.Sleep(100 * time.Millisecond)
+		current = count()
+	}
+}
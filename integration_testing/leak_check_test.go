@@ -0,0 +1,41 @@
+package integration_testing
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// TestRunningGoroutines test if the goroutine dump used by VerifyNoLeaks finds a started goroutine, and if allowlisted
+// goroutines are ignored.
+func TestRunningGoroutines(t *testing.T) {
+	require := require.New(t)
+
+	before := make(map[int]bool)
+	for _, goroutine := range runningGoroutines() {
+		before[goroutine.id] = true
+	}
+
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go leakCheckTestGoroutine(started, done)
+	<-started
+	defer close(done)
+
+	var newGoroutines []goroutineStack
+	for _, goroutine := range runningGoroutines() {
+		if !before[goroutine.id] {
+			newGoroutines = append(newGoroutines, goroutine)
+		}
+	}
+	require.Len(newGoroutines, 1)
+	require.Contains(newGoroutines[0].stack, "leakCheckTestGoroutine")
+	require.False(isLongLivedGoroutine(newGoroutines[0]))
+
+	RegisterLongLivedGoroutine("integration_testing.leakCheckTestGoroutine")
+	require.True(isLongLivedGoroutine(newGoroutines[0]))
+}
+
+func leakCheckTestGoroutine(started chan<- struct{}, done <-chan struct{}) {
+	close(started)
+	<-done
+}
@@ -0,0 +1,549 @@
+package integration_testing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestRegtestRepeatedRestarts test if a node can be restarted many times back to back:
+//  1. Spawn a regtest node, and mine a block on it.
+//  2. pin the node's port, so every restart binds the port the previous instance just released.
+//  3. restart the node fifty times without any sleeps, checking the block tip survives every restart.
+//  4. only the current instance of the node should be tracked for cleanup, so the test tears down cleanly.
+func TestRegtestRepeatedRestarts(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir := getDirectory(t)
+	defer os.RemoveAll(dbDir)
+
+	node := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir, 10)))
+	mineBlocks(t, node, 1)
+	height := node.Server.GetBlockchain().BlockTip().Height
+	node.Config.ProtocolPort = node.ListeningPort()
+
+	for ii := 0; ii < 50; ii++ {
+		node = restartNode(t, node)
+		require.Equal(height, node.Server.GetBlockchain().BlockTip().Height)
+		require.Equal(1, numTrackedNodes(t))
+	}
+	node.Stop()
+}
+
+// restartLeakSlack is how many more goroutines or file descriptors TestRegtestRepeatedRestartsDontLeak tolerates after
+// the restarts, e.g. for a glog file rotation or a goroutine that's started lazily. A leak in every restart exceeds it.
+const restartLeakSlack = 10
+
+// TestRegtestRepeatedRestartsDontLeak test if restarting a node releases everything the previous instance held:
+//  1. Spawn a regtest node, mine a block on it, pin its port, and restart it once so that lazily started goroutines
+//     are running. Record the number of goroutines and open file descriptors.
+//  2. restart the node fifty times back to back.
+//  3. the number of goroutines and file descriptors should settle back to the recorded ones.
+//  4. stop the node, its port should be free to bind again.
+func TestRegtestRepeatedRestartsDontLeak(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir := getDirectory(t)
+	defer os.RemoveAll(dbDir)
+
+	node := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir, 10)))
+	mineBlocks(t, node, 1)
+	node.Config.ProtocolPort = node.ListeningPort()
+	node = restartNode(t, node)
+
+	goroutinesBefore := runtime.NumGoroutine()
+	fdsBefore, hasFds := openFileDescriptors()
+	for ii := 0; ii < 50; ii++ {
+		node = restartNode(t, node)
+	}
+	waitForResourceCountAtMost(t, "goroutines", runtime.NumGoroutine, goroutinesBefore+restartLeakSlack)
+	if hasFds {
+		waitForResourceCountAtMost(t, "file descriptors", func() int {
+			fds, _ := openFileDescriptors()
+			return fds
+		}, fdsBefore+restartLeakSlack)
+	} else {
+		t.Logf("Can't count open file descriptors on this platform, skipping the file descriptor check")
+	}
+
+	port := node.ListeningPort()
+	node = shutdownNode(t, node)
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%v", port))
+	require.NoError(err, "port (%v) wasn't released", port)
+	require.NoError(listener.Close())
+}
+
+// TestRegtestStartErrorOnUsedPort test if starting a node on a port that's already in use fails right away:
+//  1. Spawn a regtest node node1.
+//  2. try to start node2 on the same port as node1.
+//  3. node2 should fail to start with the bind error, and shouldn't be running.
+func TestRegtestStartErrorOnUsedPort(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	node1 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir1, 10)))
+	config2 := generateRegtestConfig(t, dbDir2, 10)
+	require.NotZero(node1.ListeningPort())
+	config2.ProtocolPort = node1.ListeningPort()
+	node2 := cmd.NewNode(config2)
+
+	err := node2.Start()
+	require.Error(err)
+	require.True(strings.Contains(err.Error(), syscall.EADDRINUSE.Error()), "unexpected error: %v", err)
+	require.False(node2.IsRunning())
+	require.Equal(cmd.NodeStateStopped, node2.State())
+	node1.Stop()
+}
+
+// TestRegtestStartErrorOnUnwritableDataDirectory test if starting a node whose data directory can't be created fails
+// with a descriptive error, instead of terminating the test binary:
+//  1. Create a regular file, and point a regtest node's DataDirectory below it, where no directory can be created
+//     even when running as root.
+//  2. starting the node should return an error naming the chain db and the data directory.
+//  3. the node shouldn't be running.
+func TestRegtestStartErrorOnUnwritableDataDirectory(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir := getDirectory(t)
+	defer os.RemoveAll(dbDir)
+	blockingFile := filepath.Join(dbDir, "file")
+	require.NoError(ioutil.WriteFile(blockingFile, []byte{}, 0644))
+
+	config := generateRegtestConfig(t, dbDir, 10)
+	config.DataDirectory = filepath.Join(blockingFile, "datadir")
+	node := cmd.NewNode(config)
+
+	err := node.Start()
+	require.Error(err)
+	require.True(strings.Contains(err.Error(), "Problem opening chain db"), "unexpected error: %v", err)
+	require.True(strings.Contains(err.Error(), config.DataDirectory), "unexpected error: %v", err)
+	require.False(node.IsRunning())
+}
+
+// TestRegtestStartErrorOnBrokenSnapshotDirectory test if starting a node whose snapshot db can't be opened fails with
+// ErrNodeNeedsResync, instead of terminating the test binary:
+//  1. Spawn a regtest hypersync node, mine a block on it, and stop it.
+//  2. replace the node's snapshot db directory with a regular file, so that the snapshot db can't be opened.
+//  3. starting the node should fail with ErrNodeNeedsResync, naming the snapshot, and leave the node stopped.
+//  4. erasing the data directory should let the node start again.
+func TestRegtestStartErrorOnBrokenSnapshotDirectory(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	node := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest(), WithHyperSync(5))))
+	mineBlocks(t, node, 1)
+	node = shutdownNode(t, node)
+
+	snapshotDir := filepath.Join(lib.GetBadgerDbPath(node.Config.DataDirectory), "snapshot")
+	require.NoError(os.RemoveAll(snapshotDir))
+	require.NoError(ioutil.WriteFile(snapshotDir, []byte{}, 0644))
+
+	err := node.Start()
+	require.Error(err)
+	require.True(errors.Is(err, cmd.ErrNodeNeedsResync), "unexpected error: %v", err)
+	require.True(strings.Contains(err.Error(), "Problem initializing snapshot"), "unexpected error: %v", err)
+	require.Equal(cmd.NodeStateStopped, node.State())
+
+	require.NoError(node.EraseDataDirectory())
+	node = startNode(t, node)
+	require.Equal(uint32(0), node.Server.GetBlockchain().BlockTip().Height)
+}
+
+// TestRegtestStartErrorOnBrokenTXIndexDirectory test if starting a node whose txindex db can't be opened fails with a
+// descriptive error, instead of terminating the test binary:
+//  1. Spawn a regtest node with TXIndex, mine a block on it, and stop it.
+//  2. replace the node's txindex db directory with a regular file, so that the txindex db can't be opened.
+//  3. starting the node should fail with an error naming the TXIndex, and leave the node stopped.
+//  4. once the txindex directory is removed, the node should start again with its chain, which means that the failed
+//     start released the chain db.
+func TestRegtestStartErrorOnBrokenTXIndexDirectory(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	node := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest(), WithTXIndex())))
+	mineBlocks(t, node, 1)
+	height := node.Server.GetBlockchain().BlockTip().Height
+	node = shutdownNode(t, node)
+
+	txIndexDir := filepath.Join(lib.GetBadgerDbPath(node.Config.DataDirectory), "txindex")
+	require.NoError(os.RemoveAll(txIndexDir))
+	require.NoError(ioutil.WriteFile(txIndexDir, []byte{}, 0644))
+
+	err := node.Start()
+	require.Error(err)
+	require.True(strings.Contains(err.Error(), "Problem initializing TXIndex"), "unexpected error: %v", err)
+	require.True(strings.Contains(err.Error(), "Problem opening TxIndex db"), "unexpected error: %v", err)
+	require.Equal(cmd.NodeStateStopped, node.State())
+
+	require.NoError(os.Remove(txIndexDir))
+	node = startNode(t, node)
+	require.Equal(height, node.Server.GetBlockchain().BlockTip().Height)
+	require.NotNil(node.TXIndex)
+}
+
+// TestRegtestStopWithContext test if a node's shutdown can be bounded by a context:
+//  1. Spawn a regtest node, and mine a block on it.
+//  2. stop the node with an expired context, which should fail with the context's error and the stuck subsystem.
+//  3. the shutdown should carry on in the background, so the node can be started again on the same port once it's done.
+//  4. stopping the restarted node with a live context should succeed.
+func TestRegtestStopWithContext(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir := getDirectory(t)
+	defer os.RemoveAll(dbDir)
+
+	node := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir, 10)))
+	mineBlocks(t, node, 1)
+	height := node.Server.GetBlockchain().BlockTip().Height
+	node.Config.ProtocolPort = node.ListeningPort()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := node.StopWithContext(ctx)
+	require.Error(err)
+	require.True(errors.Is(err, context.Canceled), "unexpected error: %v", err)
+	require.True(strings.Contains(err.Error(), "Shutdown is stuck stopping the"), "unexpected error: %v", err)
+	require.False(node.IsRunning())
+
+	// Stop waits for the shutdown in the background to finish.
+	require.NoError(node.Stop())
+	node = startNode(t, cmd.NewNode(node.Config))
+	require.Equal(height, node.Server.GetBlockchain().BlockTip().Height)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	require.NoError(node.StopWithContext(ctx))
+}
+
+// TestRegtestNodeStatus test if a node's status reflects the node, and can be read while the node runs:
+//  1. Spawn two regtest nodes node1, node2, bridge them together, and poll node2's status in the background.
+//  2. mine blocks with a transfer on node1, and wait for node2 to converge.
+//  3. node2's status should have node1's block tip, a peer, and marshal to JSON with a readable chain state.
+//  4. once node2 is stopped, its status should report that it isn't running.
+func TestRegtestNodeStatus(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	node1 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir1, 10)))
+	node2 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir2, 10)))
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+
+	done := make(chan struct{})
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				node2.Status()
+			}
+		}
+	}()
+
+	mineBlocks(t, node1, 3)
+	submitBasicTransfer(t, node1, 1000)
+	mineBlocks(t, node1, 1)
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+	close(done)
+	<-polled
+
+	status1 := node1.Status()
+	status2 := node2.Status()
+	require.True(status2.Running)
+	require.Equal(status1.BlockTipHash, status2.BlockTipHash)
+	require.Equal(node2.Server.GetBlockchain().BlockTip().Height, status2.BlockTipHeight)
+	require.GreaterOrEqual(status2.HeaderTipHeight, status2.BlockTipHeight)
+	require.Equal(1, status2.NumPeers)
+	require.False(status2.TXIndex.Enabled)
+	require.Positive(status2.Uptime)
+	statusJSON, err := json.Marshal(status2)
+	require.NoError(err)
+	require.True(strings.Contains(string(statusJSON), fmt.Sprintf(`"ChainState":"%v"`, status2.ChainState)),
+		"unexpected JSON: %s", statusJSON)
+
+	node2.Stop()
+	require.Equal(cmd.NodeStatus{}, node2.Status())
+	node1.Stop()
+}
+
+// TestRegtestNodeLifecycleState test if a node goes through its lifecycle states, and if Start and Stop are idempotent:
+//  1. a new regtest node should be created, and running once it's started.
+//  2. starting the running node again should fail with ErrNodeAlreadyRunning, and leave the node running.
+//  3. the node should be stopped after Stop, and stopping it again should have no effect.
+func TestRegtestNodeLifecycleState(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir := getDirectory(t)
+	defer os.RemoveAll(dbDir)
+
+	node := cmd.NewNode(generateRegtestConfig(t, dbDir, 10))
+	require.Equal(cmd.NodeStateCreated, node.State())
+	require.False(node.IsRunning())
+	node = startNode(t, node)
+	require.Equal(cmd.NodeStateRunning, node.State())
+
+	require.True(errors.Is(node.Start(), cmd.ErrNodeAlreadyRunning))
+	require.True(node.IsRunning())
+	mineBlocks(t, node, 1)
+
+	require.NoError(node.Stop())
+	require.Equal(cmd.NodeStateStopped, node.State())
+	require.NoError(node.Stop())
+	require.Equal(cmd.NodeStateStopped, node.State())
+}
+
+// TestRegtestConcurrentRestarts test if a node can be stopped and started from several goroutines at once, while its
+// state is read, which should pass under the race detector:
+//  1. Spawn a regtest node.
+//  2. stop and start the node from four goroutines at once, while polling its state from another goroutine. Every
+//     Start should either start the node, or fail with ErrNodeAlreadyRunning, and every Stop should succeed.
+//  3. since every goroutine starts the node last, the node should be running at the end.
+func TestRegtestConcurrentRestarts(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir := getDirectory(t)
+	defer os.RemoveAll(dbDir)
+
+	node := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir, 10)))
+
+	done := make(chan struct{})
+	polled := make(chan struct{})
+	go func() {
+		defer close(polled)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				node.State()
+				node.IsRunning()
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for ii := 0; ii < 4; ii++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for jj := 0; jj < 5; jj++ {
+				if err := node.Stop(); err != nil {
+					t.Errorf("Problem stopping node: %v", err)
+				}
+				if err := node.Start(); err != nil && !errors.Is(err, cmd.ErrNodeAlreadyRunning) {
+					t.Errorf("Problem starting node: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	<-polled
+
+	require.Equal(cmd.NodeStateRunning, node.State())
+	require.NoError(node.Stop())
+	require.Equal(cmd.NodeStateStopped, node.State())
+}
+
+// TestRegtestRestartInPlace test if a node can be restarted in place, keeping the references to it valid:
+//  1. Spawn a regtest node on a port picked by the OS, and mine a block on it.
+//  2. restart the node. It should be the same node, with a new server, on the same port, with the same block tip.
+//  3. restarting the node from within a restart should fail with ErrNodeRestarting, and leave the outer restart be.
+//  4. restarting the node with a new data directory should fail, and leave the node running.
+//  5. restarting a stopped node should fail with ErrNodeNotRunning.
+func TestRegtestRestartInPlace(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir := getDirectory(t)
+	defer os.RemoveAll(dbDir)
+
+	node := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir, 10)))
+	mineBlocks(t, node, 1)
+	height := node.Server.GetBlockchain().BlockTip().Height
+	port := node.ListeningPort()
+	server := node.Server
+
+	restartedNode := restartNode(t, node)
+	require.True(restartedNode == node)
+	require.True(node.IsRunning())
+	require.False(node.Server == server)
+	require.Equal(port, node.ListeningPort())
+	require.Equal(height, node.Server.GetBlockchain().BlockTip().Height)
+
+	var nestedErr error
+	node = restartNodeWithConfig(t, node, func(config *cmd.Config) {
+		nestedErr = node.Restart()
+		config.LogName = "restarted"
+	})
+	require.True(errors.Is(nestedErr, cmd.ErrNodeRestarting), "unexpected error: %v", nestedErr)
+	require.Equal("restarted", node.Config.LogName)
+	require.Equal(port, node.ListeningPort())
+
+	err := node.RestartWithConfig(func(config *cmd.Config) {
+		config.DataDirectory = filepath.Join(dbDir, "other")
+	})
+	require.Error(err)
+	require.True(strings.Contains(err.Error(), "DataDirectory can't change"), "unexpected error: %v", err)
+	require.True(node.IsRunning())
+
+	require.NoError(node.Stop())
+	require.True(errors.Is(node.Restart(), cmd.ErrNodeNotRunning))
+}
+
+// TestRegtestInMemoryRestart test if an in-memory node keeps its state across a restart, but not across a stop:
+//  1. Spawn an in-memory regtest node with the txindex and hypersync, so that all of its dbs are in memory, and mine a
+//     few blocks on it. Its data directory should never be created.
+//  2. restart the node. It should come back with the same block tip, in the chain db and in the txindex db.
+//  3. stop the node and start it again. It should start over from the genesis block, since its dbs were discarded, and
+//     mine blocks as before.
+func TestRegtestInMemoryRestart(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	node := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest(), WithInMemory(), WithTXIndex(),
+		WithHyperSync(HyperSyncSnapshotPeriod))))
+	mineBlocks(t, node, 3)
+	tip := node.Server.GetBlockchain().BlockTip()
+	txIndexHeight := func() uint32 {
+		return node.TXIndex.TXIndexChain.BlockTip().Height
+	}
+	require.Eventually(func() bool {
+		return txIndexHeight() == tip.Height
+	}, time.Minute, 10*time.Millisecond)
+
+	node = restartNode(t, node)
+	require.True(tip.Hash.IsEqual(node.Server.GetBlockchain().BlockTip().Hash))
+	require.Equal(tip.Height, txIndexHeight())
+	_, err := os.Stat(node.Config.DataDirectory)
+	require.True(os.IsNotExist(err), "unexpected error: %v", err)
+
+	node = shutdownNode(t, node)
+	node = startNode(t, node)
+	require.Equal(uint32(0), node.Server.GetBlockchain().BlockTip().Height)
+	require.Equal(uint32(0), txIndexHeight())
+	mineBlocks(t, node, 1)
+	_, err = os.Stat(node.Config.DataDirectory)
+	require.True(os.IsNotExist(err), "unexpected error: %v", err)
+}
+
+// TestRegtestInMemoryRestartByEngine test if an in-memory node keeps its state when the engine restarts it, like it does
+// across a restart:
+//  1. Spawn an in-memory regtest hypersync node, and mine blocks past a snapshot epoch on it.
+//  2. mark the snapshot as interrupted in the middle of a flush, and restart the node. The node should roll back to
+//     the last snapshot epoch when it starts, and the engine should restart it again to finish the recovery.
+//  3. once the node is back up, it should still be at the last snapshot epoch rather than at the genesis block, and
+//     mine blocks as before.
+func TestRegtestInMemoryRestartByEngine(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	const snapshotPeriod = 5
+	node := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest(), WithInMemory(), WithHyperSync(snapshotPeriod))))
+	mineBlocks(t, node, 7)
+
+	snap := node.Server.GetBlockchain().Snapshot()
+	snap.WaitForAllOperationsToFinish()
+	snap.Status.MemoryLock.Lock()
+	snap.Status.IncrementMainDbSemaphoreMemoryLockRequired()
+	snap.Status.MemoryLock.Unlock()
+	server := node.Server
+
+	node = restartNode(t, node)
+	require.Eventually(func() bool {
+		return node.Server != server && node.Healthz().Ready
+	}, time.Minute, 10*time.Millisecond)
+	require.NoError(node.RestartError())
+	require.Equal(uint32(snapshotPeriod), node.Server.GetBlockchain().BlockTip().Height)
+	mineBlocks(t, node, 1)
+	require.Equal(uint32(snapshotPeriod+1), node.Server.GetBlockchain().BlockTip().Height)
+	_, err := os.Stat(node.Config.DataDirectory)
+	require.True(os.IsNotExist(err), "unexpected error: %v", err)
+}
+
+// TestRegtestStartErrorOnUnrecoverableDataDirectory test if a node whose data directory can't be recovered fails to
+// start with ErrNodeNeedsResync, instead of crashing, and can be resynced by erasing its data directory:
+//  1. Spawn a regtest hypersync node, mine blocks past a snapshot epoch on it, and stop it. Pin its port.
+//  2. mark the snapshot as interrupted in the middle of a flush, and delete the body of the tip block, so that the
+//     node can't roll back to the last snapshot epoch when it starts.
+//  3. starting the node should fail with ErrNodeNeedsResync, leave the node stopped, and keep the data directory.
+//  4. erasing the data directory should let the node start again on the same port, from the genesis block.
+func TestRegtestStartErrorOnUnrecoverableDataDirectory(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	node := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest(), WithHyperSync(5))))
+	mineBlocks(t, node, 7)
+	tipHash := node.Server.GetBlockchain().BlockTip().Hash
+	node.Config.ProtocolPort = node.ListeningPort()
+	node = shutdownNode(t, node)
+
+	dbDir := lib.GetBadgerDbPath(node.Config.DataDirectory)
+	chainOpts := lib.PerformanceBadgerOptions(dbDir)
+	chainOpts.ValueDir = dbDir
+	chainDB, err := badger.Open(chainOpts)
+	require.NoError(err)
+	require.NoError(chainDB.Update(func(txn *badger.Txn) error {
+		return txn.Delete(lib.BlockHashToBlockKey(tipHash))
+	}))
+	require.NoError(chainDB.Close())
+	snapshotDir := filepath.Join(dbDir, "snapshot")
+	snapshotOpts := lib.PerformanceBadgerOptions(snapshotDir)
+	snapshotOpts.ValueDir = lib.GetBadgerDbPath(snapshotDir)
+	snapshotDB, err := badger.Open(snapshotOpts)
+	require.NoError(err)
+	var snapshotDBMutex sync.Mutex
+	status := &lib.SnapshotStatus{}
+	require.NoError(status.Initialize(snapshotDB, &snapshotDBMutex))
+	status.MainDBSemaphore++
+	status.SaveStatus()
+	require.NoError(snapshotDB.Close())
+
+	err = node.Start()
+	require.Error(err)
+	require.True(errors.Is(err, cmd.ErrNodeNeedsResync), "unexpected error: %v", err)
+	require.Equal(cmd.NodeStateStopped, node.State())
+	stamp, err := cmd.ReadDataDirectoryVersion(node.Config.DataDirectory)
+	require.NoError(err)
+	require.NotNil(stamp)
+
+	require.NoError(node.EraseDataDirectory())
+	node = startNode(t, node)
+	require.Equal(node.Config.ProtocolPort, node.ListeningPort())
+	require.Equal(uint32(0), node.Server.GetBlockchain().BlockTip().Height)
+	mineBlocks(t, node, 1)
+}
@@ -0,0 +1,53 @@
+package integration_testing
+
+import (
+	"github.com/deso-protocol/core/cmd"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestRegtestListenAddrs test if nodes that listen on different address families can connect and sync:
+//  1. Spawn a regtest node1 listening on [::1] only, and mine blocks on it.
+//  2. spawn a regtest node2 listening on 127.0.0.1 only, which connects to node1's bound address through ConnectIPs.
+//  3. both nodes should report their concrete bound address, and node2 should sync node1's blocks.
+//  4. bridge node2 to a regtest node3, which should reach node2 on its IPv4 address and sync the blocks as well.
+//  5. restarting node1 should bind the same address, so that node2 reconnects to it.
+func TestRegtestListenAddrs(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	if listener, err := net.Listen("tcp", "[::1]:0"); err != nil {
+		t.Skipf("IPv6 loopback isn't available: %v", err)
+	} else {
+		listener.Close()
+	}
+
+	node1 := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest(), WithListenAddrs("[::1]:0"))))
+	require.Len(node1.ListenAddrs(), 1)
+	addr1 := node1.ListenAddrs()[0].(*net.TCPAddr)
+	require.True(addr1.IP.Equal(net.IPv6loopback))
+	require.NotZero(addr1.Port)
+	require.Equal(uint16(addr1.Port), node1.ListeningPort())
+	mineBlocks(t, node1, 5)
+
+	node2 := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest(), WithListenAddrs("127.0.0.1:0"),
+		WithConnectIPs(addr1.String()))))
+	require.Len(node2.ListenAddrs(), 1)
+	addr2 := node2.ListenAddrs()[0].(*net.TCPAddr)
+	require.NotNil(addr2.IP.To4())
+	require.True(addr2.IP.IsLoopback())
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+
+	node3 := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest())))
+	bridge := NewConnectionBridge(node2, node3)
+	require.NoError(bridge.Start())
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2, node3}, time.Minute)
+
+	bridge.Disconnect()
+	node1 = restartNode(t, node1)
+	require.Equal(addr1.String(), node1.ListenAddrs()[0].String())
+	mineBlocks(t, node1, 1)
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+}
@@ -0,0 +1,112 @@
+package integration_testing
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/deso-protocol/core/lib"
+	"io"
+	"testing"
+)
+
+// MessageMutation transforms a message serialized in the wire format, i.e. the network type, message type, payload
+// checksum, payload length, and the payload itself, into the bytes that should be sent in its place.
+type MessageMutation func(frame []byte) []byte
+
+// messageFrame describes where the parts of a serialized message are located within its wire format.
+type messageFrame struct {
+	// lengthOffset is the offset of the uvarint payload length.
+	lengthOffset int
+	// payloadOffset is the offset of the payload, which also marks the end of the header.
+	payloadOffset int
+	payloadLength uint64
+}
+
+// parseMessageFrame locates the payload length and the payload within a serialized message.
+func parseMessageFrame(frame []byte) (*messageFrame, error) {
+	rr := bytes.NewReader(frame)
+	// Skip the network type and the message type.
+	if _, err := lib.ReadUvarint(rr); err != nil {
+		return nil, fmt.Errorf("parseMessageFrame: Problem reading network type: %v", err)
+	}
+	if _, err := lib.ReadUvarint(rr); err != nil {
+		return nil, fmt.Errorf("parseMessageFrame: Problem reading message type: %v", err)
+	}
+	// Skip the eight-byte checksum.
+	if _, err := io.CopyN(io.Discard, rr, 8); err != nil {
+		return nil, fmt.Errorf("parseMessageFrame: Problem reading checksum: %v", err)
+	}
+
+	lengthOffset := len(frame) - rr.Len()
+	payloadLength, err := lib.ReadUvarint(rr)
+	if err != nil {
+		return nil, fmt.Errorf("parseMessageFrame: Problem reading payload length: %v", err)
+	}
+	return &messageFrame{
+		lengthOffset:  lengthOffset,
+		payloadOffset: len(frame) - rr.Len(),
+		payloadLength: payloadLength,
+	}, nil
+}
+
+// serializeMessage serializes the message in the wire format of the provided network.
+func serializeMessage(msg lib.DeSoMessage, networkType lib.NetworkType) ([]byte, error) {
+	var frame bytes.Buffer
+	if _, err := lib.WriteMessage(&frame, msg, networkType); err != nil {
+		return nil, err
+	}
+	return frame.Bytes(), nil
+}
+
+// TruncateMessage returns a mutation that cuts the serialized message down to its first length bytes. Since the
+// header announces the original payload length, the receiver will treat the beginning of whatever is sent next as
+// the rest of the payload.
+func TruncateMessage(length int) MessageMutation {
+	return func(frame []byte) []byte {
+		if length >= len(frame) {
+			return frame
+		}
+		if length < 0 {
+			length = 0
+		}
+		return frame[:length]
+	}
+}
+
+// FlipRandomBits returns a mutation that flips numBits random bits in the payload of the serialized message, leaving
+// the header intact. The payload checksum is not updated, so the receiver should detect the corruption. The bits are
+// picked with the test's TestRand.
+func FlipRandomBits(t testing.TB, numBits int) MessageMutation {
+	testRand := getTestRand(t)
+	return func(frame []byte) []byte {
+		parsedFrame, err := parseMessageFrame(frame)
+		if err != nil || parsedFrame.payloadOffset >= len(frame) {
+			return frame
+		}
+
+		mutated := append([]byte{}, frame...)
+		payload := mutated[parsedFrame.payloadOffset:]
+		for ii := 0; ii < numBits; ii++ {
+			bit := testRand.Intn(len(payload) * 8)
+			payload[bit/8] ^= 1 << (bit % 8)
+		}
+		return mutated
+	}
+}
+
+// InflateLengthField returns a mutation that increases the payload length announced in the header of the serialized
+// message by extraBytes, without changing the payload. The receiver will either reject the message outright, if the
+// length exceeds lib.MaxMessagePayload, or wait for payload bytes that never come.
+func InflateLengthField(extraBytes uint64) MessageMutation {
+	return func(frame []byte) []byte {
+		parsedFrame, err := parseMessageFrame(frame)
+		if err != nil {
+			return frame
+		}
+
+		var mutated []byte
+		mutated = append(mutated, frame[:parsedFrame.lengthOffset]...)
+		mutated = append(mutated, lib.UintToBuf(parsedFrame.payloadLength+extraBytes)...)
+		mutated = append(mutated, frame[parsedFrame.payloadOffset:]...)
+		return mutated
+	}
+}
@@ -0,0 +1,117 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/lib"
+	"sync"
+	"testing"
+	"time"
+)
+
+// MessageEvent is a message of type MsgType sent across a bridge in the given direction.
+type MessageEvent struct {
+	Direction Direction
+	MsgType   lib.MsgType
+}
+
+func (event MessageEvent) String() string {
+	return fmt.Sprintf("%v %v", event.Direction, event.MsgType)
+}
+
+// MessageSequenceRecorder records the conversation between the two nodes of a ConnectionBridge as an ordered list of
+// MessageEvents. Messages are recorded as they are sent by the nodes, including the version handshakes, and before the
+// bridge drops, delays or blocks them. This allows writing tests as declarative assertions about the protocol, e.g.
+//
+//	recorder.AssertOrdered(t, MessageEvent{DirectionBToA, lib.MsgTypeVersion}, MessageEvent{DirectionBToA, lib.MsgTypeGetHeaders})
+//	recorder.AssertNeverSeen(t, MessageEvent{DirectionBToA, lib.MsgTypeGetSnapshot})
+type MessageSequenceRecorder struct {
+	mtx     sync.Mutex
+	events  []MessageEvent
+	stopped bool
+}
+
+// NewMessageSequenceRecorder attaches a new recorder to the bridge. The recorder should be attached before the bridge
+// is started, so that it can see the handshakes.
+func NewMessageSequenceRecorder(bridge *ConnectionBridge) *MessageSequenceRecorder {
+	recorder := &MessageSequenceRecorder{}
+	bridge.addObserver(recorder.observe)
+	return recorder
+}
+
+func (recorder *MessageSequenceRecorder) observe(msg lib.DeSoMessage, direction Direction) {
+	recorder.mtx.Lock()
+	defer recorder.mtx.Unlock()
+	if recorder.stopped {
+		return
+	}
+	recorder.events = append(recorder.events, MessageEvent{direction, msg.GetMsgType()})
+}
+
+// Stop makes the recorder ignore all further messages.
+func (recorder *MessageSequenceRecorder) Stop() {
+	recorder.mtx.Lock()
+	defer recorder.mtx.Unlock()
+	recorder.stopped = true
+}
+
+// Reset clears the recorded events.
+func (recorder *MessageSequenceRecorder) Reset() {
+	recorder.mtx.Lock()
+	defer recorder.mtx.Unlock()
+	recorder.events = nil
+}
+
+// Events returns the events recorded so far, in the order in which the messages were sent.
+func (recorder *MessageSequenceRecorder) Events() []MessageEvent {
+	recorder.mtx.Lock()
+	defer recorder.mtx.Unlock()
+	return append([]MessageEvent{}, recorder.events...)
+}
+
+// indexOf returns the index of the first occurrence of the event, or -1 if the event wasn't recorded.
+func (recorder *MessageSequenceRecorder) indexOf(event MessageEvent) int {
+	recorder.mtx.Lock()
+	defer recorder.mtx.Unlock()
+	for ii, recordedEvent := range recorder.events {
+		if recordedEvent == event {
+			return ii
+		}
+	}
+	return -1
+}
+
+// AssertEventuallySeen fails the test if the event isn't recorded within the timeout.
+func (recorder *MessageSequenceRecorder) AssertEventuallySeen(t testing.TB, event MessageEvent, timeout time.Duration) {
+	deadline := time.After(timeout)
+	for recorder.indexOf(event) < 0 {
+		select {
+		case <-deadline:
+			t.Fatalf("AssertEventuallySeen: Event (%v) wasn't seen within (%v)", event, timeout)
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// AssertNeverSeen fails the test if the event was recorded.
+func (recorder *MessageSequenceRecorder) AssertNeverSeen(t testing.TB, event MessageEvent) {
+	if index := recorder.indexOf(event); index >= 0 {
+		t.Fatalf("AssertNeverSeen: Event (%v) was seen at position (%v)", event, index)
+	}
+}
+
+// AssertOrdered fails the test unless both events were recorded, and the first occurrence of event a precedes the
+// first occurrence of event b.
+func (recorder *MessageSequenceRecorder) AssertOrdered(t testing.TB, a MessageEvent, b MessageEvent) {
+	indexA := recorder.indexOf(a)
+	indexB := recorder.indexOf(b)
+	if indexA < 0 {
+		t.Fatalf("AssertOrdered: Event (%v) wasn't seen", a)
+	}
+	if indexB < 0 {
+		t.Fatalf("AssertOrdered: Event (%v) wasn't seen", b)
+	}
+	if indexA > indexB {
+		t.Fatalf("AssertOrdered: Event (%v) at position (%v) was seen after event (%v) at position (%v)",
+			a, indexA, b, indexB)
+	}
+}
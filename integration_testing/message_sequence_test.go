@@ -0,0 +1,80 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestBlockSyncMessageSequence test if a block syncing node follows the expected protocol conversation:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks, and hypersync disabled on node2.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
+//  3. bridge node1 and node2, and record the messages sent by both nodes.
+//  4. node2 syncs MaxSyncBlockHeight blocks from node1.
+//  5. node2 should introduce itself first, download headers before blocks, and never ask for a snapshot.
+//  6. once done, compare node1 checksum matches node2.
+func TestBlockSyncMessageSequence(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeBlockSync
+	config2.HyperSync = false
+
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge the nodes together and record the conversation.
+	bridge := NewConnectionBridge(node1, node2)
+	recorder := NewMessageSequenceRecorder(bridge)
+	require.NoError(bridge.Start())
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+	recorder.AssertEventuallySeen(t, MessageEvent{DirectionBToA, lib.MsgTypeGetBlocks}, time.Minute)
+	recorder.Stop()
+
+	require.Equal(MessageEvent{DirectionBToA, lib.MsgTypeVersion}, firstEventInDirection(recorder, DirectionBToA))
+	recorder.AssertOrdered(t, MessageEvent{DirectionBToA, lib.MsgTypeVerack},
+		MessageEvent{DirectionBToA, lib.MsgTypeGetHeaders})
+	recorder.AssertOrdered(t, MessageEvent{DirectionAToB, lib.MsgTypeHeaderBundle},
+		MessageEvent{DirectionBToA, lib.MsgTypeGetBlocks})
+	recorder.AssertOrdered(t, MessageEvent{DirectionBToA, lib.MsgTypeGetBlocks},
+		MessageEvent{DirectionAToB, lib.MsgTypeBlock})
+	recorder.AssertNeverSeen(t, MessageEvent{DirectionBToA, lib.MsgTypeGetSnapshot})
+
+	compareNodesByChecksum(t, node1, node2)
+	fmt.Println("Databases match!")
+	bridge.Disconnect()
+	node1.Stop()
+	node2.Stop()
+}
+
+// firstEventInDirection returns the first event recorded in the direction.
+func firstEventInDirection(recorder *MessageSequenceRecorder, direction Direction) MessageEvent {
+	for _, event := range recorder.Events() {
+		if event.Direction == direction {
+			return event
+		}
+	}
+	return MessageEvent{}
+}
@@ -0,0 +1,84 @@
+package integration_testing
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMetricsEndpoint tests if the metrics endpoint follows the node's sync:
+//  1. Spawn node1 with a metrics port, which blocksyncs from the "deso-seed-2.io" generator.
+//  2. Scrape the endpoint until the block tip height advances.
+//  3. Once node1 is fully synced, the scraped tip height should match the node's tip, and the sync metrics should
+//     report the same tip from the registry.
+func TestMetricsEndpoint(t *testing.T) {
+	require := require.New(t)
+
+	config1 := NewTestConfig(t, WithConnectIPs("deso-seed-2.io:17000"), WithMetricsPort(getFreePort(t)))
+	node1 := cmd.NewNode(config1)
+	node1 = startNode(t, node1)
+	metrics1 := NewSyncMetrics(t, node1)
+	require.NotNil(node1.MetricsAddr())
+	metricsURL := fmt.Sprintf("http://%v/metrics", node1.MetricsAddr())
+
+	firstHeight := scrapeMetric(t, metricsURL, lib.MetricBlockTipHeight)
+	require.Eventually(func() bool {
+		return scrapeMetric(t, metricsURL, lib.MetricBlockTipHeight) > firstHeight
+	}, defaultSyncTimeout, time.Second)
+
+	waitForNodeToFullySync(t, node1)
+	blockTipHeight := node1.Server.GetBlockchain().BlockTip().Height
+	require.Equal(float64(blockTipHeight), scrapeMetric(t, metricsURL, lib.MetricBlockTipHeight))
+	require.Equal(blockTipHeight, metrics1.Summary().BlockTipHeight)
+	received, exists := node1.Metrics().Value(lib.MetricMessages, "direction", "received", "type",
+		lib.MsgTypeBlock.String())
+	require.True(exists)
+	require.NotZero(received)
+
+	// The registry keeps the final values after the node stops.
+	require.NoError(node1.Stop())
+	require.Nil(node1.MetricsAddr())
+	require.Equal(blockTipHeight, metrics1.Summary().BlockTipHeight)
+}
+
+// getFreePort returns a port that's free at the time of the call.
+func getFreePort(t testing.TB) uint16 {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("getFreePort: Problem finding a free port: %v", err)
+	}
+	defer listener.Close()
+	return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+// scrapeMetric scrapes the metrics endpoint and returns the value of the metric without labels, or 0 if the metric
+// isn't reported yet.
+func scrapeMetric(t testing.TB, url string, name string) float64 {
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("scrapeMetric: Problem scraping (%v): %v", url, err)
+	}
+	defer response.Body.Close()
+
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != name {
+			continue
+		}
+		value, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			t.Fatalf("scrapeMetric: Problem parsing (%v): %v", scanner.Text(), err)
+		}
+		return value
+	}
+	return 0
+}
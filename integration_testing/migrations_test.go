@@ -20,9 +20,9 @@ func TestEncoderMigrations(t *testing.T) {
 	defer os.RemoveAll(dbDir1)
 	defer os.RemoveAll(dbDir2)
 
-	config1 := generateConfig(t, 18000, dbDir1, 10)
+	config1 := generateConfig(t, dbDir1, 10)
 	config1.SyncType = lib.NodeSyncTypeBlockSync
-	config2 := generateConfig(t, 18001, dbDir2, 10)
+	config2 := generateConfig(t, dbDir2, 10)
 	config2.SyncType = lib.NodeSyncTypeHyperSync
 
 	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
@@ -36,18 +36,18 @@ func TestEncoderMigrations(t *testing.T) {
 	node2 = startNode(t, node2)
 
 	// wait for node1 to sync blocks
-	waitForNodeToFullySync(node1)
+	waitForNodeToFullySync(t, node1)
 
 	// bridge the nodes together.
 	bridge := NewConnectionBridge(node1, node2)
 	require.NoError(bridge.Start())
 
 	// wait for node2 to sync blocks.
-	waitForNodeToFullySync(node2)
+	waitForNodeToFullySync(t, node2)
 	fmt.Println("Chain state and operation channel", node2.Server.GetBlockchain().ChainState(),
 		len(node2.Server.GetBlockchain().Snapshot().OperationChannel.OperationChannel))
 
-	compareNodesByState(t, node1, node2, 0)
+	compareNodesByState(t, node1, node2, Summary)
 	fmt.Println("node1 checksum:", computeNodeStateChecksum(t, node1, 1500))
 	fmt.Println("node2 checksum:", computeNodeStateChecksum(t, node2, 1500))
 	checksum1, err := node1.Server.GetBlockchain().Snapshot().Checksum.ToBytes()
@@ -1,11 +1,13 @@
 package integration_testing
 
 import (
+	"encoding/hex"
 	"github.com/deso-protocol/core/cmd"
 	"github.com/deso-protocol/core/lib"
 	"github.com/stretchr/testify/require"
 	"os"
 	"testing"
+	"time"
 )
 
 // TestSimpleBlockSync test if a node can mine blocks on regtest
@@ -16,7 +18,7 @@ func TestRegtestMiner(t *testing.T) {
 	dbDir1 := getDirectory(t)
 	defer os.RemoveAll(dbDir1)
 
-	config1 := generateConfig(t, 18000, dbDir1, 10)
+	config1 := generateConfig(t, dbDir1, 10)
 	config1.SyncType = lib.NodeSyncTypeBlockSync
 	config1.Params = &lib.DeSoTestnetParams
 	config1.MaxSyncBlockHeight = 0
@@ -29,9 +31,36 @@ func TestRegtestMiner(t *testing.T) {
 
 	// wait for node1 to sync blocks
 	mineHeight := uint32(40)
-	listener := make(chan bool)
-	listenForBlockHeight(t, node1, mineHeight, listener)
-	<-listener
+	waitForBlockHeight(t, node1, mineHeight)
 
 	node1.Stop()
 }
+
+// TestRegtestMineFromGenesis test if regtest nodes can mine a chain from genesis in a few seconds:
+//  1. Spawn two regtest nodes node1, node2, and bridge them together.
+//  2. node1 mines 50 blocks from genesis, which should take a few seconds, at the minimum difficulty.
+//  3. node2 syncs the blocks from node1.
+//  4. compare node1 db matches node2 db.
+func TestRegtestMineFromGenesis(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	node1 := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest())))
+	node2 := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest())))
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+
+	startTime := time.Now()
+	blocks := mineBlocks(t, node1, 50)
+	require.Less(time.Since(startTime), 10*time.Second, "mining 50 regtest blocks took too long")
+	minDifficultyTarget, err := hex.DecodeString(node1.Params.MinDifficultyTargetHex)
+	require.NoError(err)
+	tip := node1.Server.GetBlockchain().BlockTip()
+	require.Equal(uint32(len(blocks)), tip.Height)
+	require.Equal(minDifficultyTarget, tip.DifficultyTarget[:])
+
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+	compareNodesByDB(t, node1, node2, Summary)
+	node1.Stop()
+	node2.Stop()
+}
@@ -0,0 +1,58 @@
+package integration_testing
+
+import (
+	"github.com/deso-protocol/core/cmd"
+	"os"
+	"testing"
+)
+
+// ForNode applies the options only to the node with the provided index, e.g. to let only the first node of a cluster
+// sync from the seed with ForNode(0, WithConnectIPs("deso-seed-2.io:17000")).
+func ForNode(nodeIndex int, opts ...NodeOption) NodeOption {
+	return func(index int, config *cmd.Config) {
+		if index != nodeIndex {
+			return
+		}
+		for _, opt := range opts {
+			opt(index, config)
+		}
+	}
+}
+
+// NodeCluster is a group of nodes spawned by spawnNodeCluster.
+type NodeCluster struct {
+	nodes []*cmd.Node
+}
+
+// spawnNodeCluster creates n nodes, each with a free port and its own temporary data directory, and starts them. The
+// nodes use the default config from NewTestConfig, modified by the options in order. The nodes are stopped, and their
+// data directories removed, when the test finishes.
+func spawnNodeCluster(t testing.TB, n int, opts ...NodeOption) *NodeCluster {
+	cluster := &NodeCluster{}
+	for ii := 0; ii < n; ii++ {
+		dbDir := getDirectory(t)
+		// Cleanups run in reverse order, so the directory is removed after the node is stopped.
+		t.Cleanup(func() {
+			os.RemoveAll(dbDir)
+		})
+
+		config := newTestConfig(t, dbDir, ii, opts...)
+		cluster.nodes = append(cluster.nodes, startNode(t, cmd.NewNode(config)))
+	}
+	return cluster
+}
+
+// Nodes returns the nodes in the cluster, in the order in which they were spawned.
+func (cluster *NodeCluster) Nodes() []*cmd.Node {
+	return cluster.nodes
+}
+
+// Node returns the node with the provided index.
+func (cluster *NodeCluster) Node(index int) *cmd.Node {
+	return cluster.nodes[index]
+}
+
+// Replace updates the node with the provided index, e.g. after it was restarted with restartNode.
+func (cluster *NodeCluster) Replace(index int, node *cmd.Node) {
+	cluster.nodes[index] = node
+}
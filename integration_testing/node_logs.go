@@ -0,0 +1,169 @@
+package integration_testing
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// nodeLogBufferLines is the number of recent log lines kept for every node, which are dumped if the test fails.
+const nodeLogBufferLines = 200
+
+// nodeLogFileName is the name of the log file that WithLogFile writes under the node's data directory.
+const nodeLogFileName = "node.log"
+
+// nodeLogCapture buffers the recent log lines of a node, i.e. the lines the node writes to its cmd.Config.LogOutput,
+// and the lifecycle events logged by the framework with logNodeEvent. The capture belongs to the node's config, so
+// restarted instances of the node share it. If the test fails, the buffered lines of every node are dumped, which
+// makes it possible to attribute a failure to a node, unlike the interleaved glog output of all nodes.
+type nodeLogCapture struct {
+	mtx  sync.Mutex
+	name string
+	// lines is a ring buffer of the most recent lines, with next pointing to the oldest line once it's full.
+	lines []string
+	next  int
+	// partial is an incomplete line, waiting for the rest of the line.
+	partial []byte
+	// file receives all lines if the node logs to a file, see WithLogFile.
+	file *os.File
+}
+
+var (
+	nodeLogCapturesMtx sync.Mutex
+	// nodeLogCaptures maps the data directories of the nodes to their captures.
+	nodeLogCaptures = make(map[string]*nodeLogCapture)
+	// nodeLogNames counts the nodes of every test, to name them node1, node2, etc. in the order they're configured.
+	nodeLogNames = make(map[testing.TB]int)
+)
+
+// captureNodeLogs makes the node with the config write its logs to a capture named after the order in which the
+// test configured its nodes, i.e. node1 for the first node. generateConfig captures the logs of every node.
+func captureNodeLogs(t testing.TB, config *cmd.Config) {
+	nodeLogCapturesMtx.Lock()
+	nodeLogNames[t]++
+	capture := &nodeLogCapture{
+		name:  fmt.Sprintf("node%d", nodeLogNames[t]),
+		lines: make([]string, 0, nodeLogBufferLines),
+	}
+	if nodeLogNames[t] == 1 {
+		t.Cleanup(func() {
+			nodeLogCapturesMtx.Lock()
+			delete(nodeLogNames, t)
+			nodeLogCapturesMtx.Unlock()
+		})
+	}
+	nodeLogCaptures[config.DataDirectory] = capture
+	nodeLogCapturesMtx.Unlock()
+
+	config.LogName = capture.name
+	config.LogOutput = capture
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("Recent logs of %v (%v):\n%v", capture.name, config.DataDirectory, capture.String())
+		}
+		capture.close()
+		nodeLogCapturesMtx.Lock()
+		delete(nodeLogCaptures, config.DataDirectory)
+		nodeLogCapturesMtx.Unlock()
+	})
+}
+
+// getNodeLogCapture returns the log capture of the node, or nil if its logs aren't captured.
+func getNodeLogCapture(node *cmd.Node) *nodeLogCapture {
+	nodeLogCapturesMtx.Lock()
+	defer nodeLogCapturesMtx.Unlock()
+	return nodeLogCaptures[node.Config.DataDirectory]
+}
+
+// logNodeEvent logs a framework event, such as a restart, to the node's log capture, so that the event shows up
+// among the node's own log lines.
+func logNodeEvent(node *cmd.Node, format string, args ...interface{}) {
+	capture := getNodeLogCapture(node)
+	if capture == nil {
+		return
+	}
+	fmt.Fprintf(capture, "T %s [%s] %s\n", time.Now().Format("15:04:05.000000"), capture.name,
+		fmt.Sprintf(format, args...))
+}
+
+// nodeLogName returns the name of the node in the logs, e.g. node1, or its port if its logs aren't captured.
+func nodeLogName(node *cmd.Node) string {
+	if capture := getNodeLogCapture(node); capture != nil {
+		return capture.name
+	}
+	return fmt.Sprintf("node on port (%v)", node.ListeningPort())
+}
+
+// WithLogFile makes the nodes also write their logs to a node.log file under their data directory, for post-mortem
+// inspection. The file has every line, not only the recent lines that are dumped when the test fails. Note that the
+// file is removed with the data directory, unless the test keeps it.
+func WithLogFile() NodeOption {
+	return func(index int, config *cmd.Config) {
+		capture, ok := config.LogOutput.(*nodeLogCapture)
+		if !ok {
+			return
+		}
+		file, err := os.OpenFile(filepath.Join(config.DataDirectory, nodeLogFileName),
+			os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Printf("WithLogFile: Problem opening log file for %v: %v\n", capture.name, err)
+			return
+		}
+		capture.mtx.Lock()
+		capture.file = file
+		capture.mtx.Unlock()
+	}
+}
+
+func (capture *nodeLogCapture) Write(p []byte) (int, error) {
+	capture.mtx.Lock()
+	defer capture.mtx.Unlock()
+
+	if capture.file != nil {
+		capture.file.Write(p)
+	}
+	data := append(capture.partial, p...)
+	for {
+		index := bytes.IndexByte(data, '\n')
+		if index < 0 {
+			break
+		}
+		capture.appendLine(string(data[:index]))
+		data = data[index+1:]
+	}
+	capture.partial = append([]byte{}, data...)
+	return len(p), nil
+}
+
+func (capture *nodeLogCapture) appendLine(line string) {
+	if len(capture.lines) < nodeLogBufferLines {
+		capture.lines = append(capture.lines, line)
+		return
+	}
+	capture.lines[capture.next] = line
+	capture.next = (capture.next + 1) % nodeLogBufferLines
+}
+
+// String returns the buffered lines, oldest first.
+func (capture *nodeLogCapture) String() string {
+	capture.mtx.Lock()
+	defer capture.mtx.Unlock()
+
+	lines := append(append([]string{}, capture.lines[capture.next:]...), capture.lines[:capture.next]...)
+	return strings.Join(lines, "\n")
+}
+
+func (capture *nodeLogCapture) close() {
+	capture.mtx.Lock()
+	defer capture.mtx.Unlock()
+	if capture.file != nil {
+		capture.file.Close()
+		capture.file = nil
+	}
+}
@@ -0,0 +1,90 @@
+package integration_testing
+
+import (
+	"flag"
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestNodeLogCaptureRingBuffer tests if the log capture keeps only the most recent lines, oldest first, and if it
+// joins lines that are written in several parts.
+func TestNodeLogCaptureRingBuffer(t *testing.T) {
+	require := require.New(t)
+
+	capture := &nodeLogCapture{name: "node1"}
+	for ii := 0; ii < nodeLogBufferLines+10; ii++ {
+		fmt.Fprintf(capture, "line %d\n", ii)
+	}
+	lines := strings.Split(capture.String(), "\n")
+	require.Len(lines, nodeLogBufferLines)
+	require.Equal("line 10", lines[0])
+	require.Equal(fmt.Sprintf("line %d", nodeLogBufferLines+9), lines[len(lines)-1])
+
+	capture.Write([]byte("partial "))
+	capture.Write([]byte("line\n"))
+	lines = strings.Split(capture.String(), "\n")
+	require.Equal("partial line", lines[len(lines)-1])
+}
+
+// TestRegtestNodeLogs tests if the logs of two nodes are captured separately.
+// Step 1. Create two regtest nodes, which are named node1 and node2 in their logs.
+// Step 2. Start and stop both nodes.
+// Step 3. Verify that each capture only has the lines of its own node, and that node1 logs to a file.
+func TestRegtestNodeLogs(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateRegtestConfig(t, dbDir1, 10)
+	config2 := generateRegtestConfig(t, dbDir2, 10)
+	WithLogFile()(0, config1)
+	require.Equal("node1", config1.LogName)
+	require.Equal("node2", config2.LogName)
+
+	node1 := startNode(t, cmd.NewNode(config1))
+	node2 := startNode(t, cmd.NewNode(config2))
+	shutdownNode(t, node1)
+	shutdownNode(t, node2)
+
+	logs1 := getNodeLogCapture(node1).String()
+	logs2 := getNodeLogCapture(node2).String()
+	require.Contains(logs1, "[node1] startNode: Started")
+	require.Contains(logs1, "[node1] shutdownNode: Stopped")
+	require.NotContains(logs1, "[node2]")
+	require.Contains(logs2, "[node2] startNode: Started")
+	require.NotContains(logs2, "[node1]")
+
+	logFile, err := os.ReadFile(filepath.Join(config1.DataDirectory, nodeLogFileName))
+	require.NoError(err)
+	require.Contains(string(logFile), "[node1] shutdownNode: Stopped")
+}
+
+// TestRegtestSetLogLevel tests if a node's log verbosity can be raised while it runs.
+// Step 1. Create two regtest nodes node1 and node2, and raise the verbosity of node1's server module.
+// Step 2. Verify that only node1's config has the level, and that glog's vmodule has it.
+// Step 3. Restart node1, and verify that the level carries over to the new node.
+func TestRegtestSetLogLevel(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	node1 := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest())))
+	node2 := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest())))
+
+	node1.SetLogLevel("server", 3)
+	require.Equal(map[string]int{"server": 3}, node1.Config.LogLevels)
+	require.Empty(node2.Config.LogLevels)
+	require.Contains(flag.Lookup("vmodule").Value.String(), "server=3,")
+
+	node1 = restartNode(t, node1)
+	require.Equal(map[string]int{"server": 3}, node1.Config.LogLevels)
+	require.Contains(flag.Lookup("vmodule").Value.String(), "server=3,")
+}
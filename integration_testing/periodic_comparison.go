@@ -0,0 +1,285 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// comparisonMonitorPollInterval is how often the ComparisonMonitor checks whether the nodes advanced far enough for
+// the next comparison.
+const comparisonMonitorPollInterval = 1 * time.Second
+
+// ComparisonResult is the outcome of one of the comparisons of a ComparisonMonitor.
+type ComparisonResult struct {
+	// Height is the lower of the nodes' block tip heights when the comparison ran, and HeightA and HeightB are the
+	// nodes' block tip heights.
+	Height  uint32
+	HeightA uint32
+	HeightB uint32
+	// NumDifferences is the number of differing state keys, and BrokenPrefixes the names of the prefixes under which
+	// the states differ. A node that's behind is expected to differ from one that's ahead.
+	NumDifferences int
+	BrokenPrefixes []string
+	// ChainDivergence describes the first height up to Height at which the nodes' best chains have different blocks,
+	// or is empty. Unlike the state differences, this is never expected, since both nodes passed those heights.
+	ChainDivergence string
+}
+
+func (result ComparisonResult) String() string {
+	description := fmt.Sprintf("height (%v), tips (%v) and (%v): (%v) differences", result.Height, result.HeightA,
+		result.HeightB, result.NumDifferences)
+	if len(result.BrokenPrefixes) > 0 {
+		description += fmt.Sprintf(" in %v", strings.Join(result.BrokenPrefixes, ", "))
+	}
+	if result.ChainDivergence != "" {
+		description += fmt.Sprintf(", best chains diverge: %v", result.ChainDivergence)
+	}
+	return description
+}
+
+// ComparisonMonitor compares the states of two nodes while one of them is still syncing from the other, without
+// failing the test on the differences that are expected until the syncing node catches up. It's started with
+// startPeriodicComparison, which runs a comparison every time the lower of the nodes' block tips advanced by the
+// interval, and records the results. At the end of the test, AssertConverged checks that the differences only ever
+// shrank and reached zero, which catches bugs where the intermediate state is wrong, but the final state happens to
+// converge.
+//
+// The monitor follows restarts of the nodes, and skips comparisons while either node is down. A comparison that's
+// running while a node is stopped is discarded, but tests should still Pause the monitor around restarts, so that the
+// nodes aren't stopped under a running comparison.
+type ComparisonMonitor struct {
+	t        testing.TB
+	nodeA    *cmd.Node
+	nodeB    *cmd.Node
+	interval uint32
+	opts     lib.DiffOptions
+
+	// compareMtx is held while a comparison runs, so that Pause can wait for it.
+	compareMtx sync.Mutex
+
+	mtx         sync.Mutex
+	paused      bool
+	results     []ComparisonResult
+	lastHeight  uint32
+	numCompared int
+
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+// startPeriodicComparison starts comparing the states of nodeA and nodeB, e.g. a source node and a node syncing from
+// it, every interval blocks. Every comparison is restricted to the heights both nodes have passed: it runs once the
+// lower of the block tips advanced by the interval, and the best chains are only compared up to the lower tip. The
+// states are compared under the same prefixes as compareNodesByDB, and the options skip keys like in the other compare
+// helpers. The monitor is stopped when the test finishes.
+func startPeriodicComparison(t testing.TB, nodeA *cmd.Node, nodeB *cmd.Node, interval uint32,
+	opts ...lib.DiffOptions) *ComparisonMonitor {
+
+	if interval == 0 {
+		t.Fatalf("startPeriodicComparison: Interval must be at least one block")
+	}
+	compareOpts := compareOptions(t, opts)
+	if compareOpts.MaxKeysPerPrefix == 0 {
+		compareOpts.MaxKeysPerPrefix = stateDiffLogKeysPerPrefix
+	}
+	monitor := &ComparisonMonitor{
+		t:        t,
+		nodeA:    nodeA,
+		nodeB:    nodeB,
+		interval: interval,
+		opts:     compareOpts,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	go monitor.run()
+	t.Cleanup(monitor.Stop)
+	return monitor
+}
+
+func (monitor *ComparisonMonitor) run() {
+	defer close(monitor.stopped)
+	ticker := time.NewTicker(comparisonMonitorPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-monitor.done:
+			return
+		case <-ticker.C:
+			monitor.compareIfDue()
+		}
+	}
+}
+
+// compareIfDue runs a comparison if the monitor isn't paused, both nodes are running, and the lower of their block tips
+// advanced by the interval since the last comparison.
+func (monitor *ComparisonMonitor) compareIfDue() {
+	monitor.compareMtx.Lock()
+	defer monitor.compareMtx.Unlock()
+
+	monitor.mtx.Lock()
+	paused, lastHeight, numCompared := monitor.paused, monitor.lastHeight, monitor.numCompared
+	monitor.mtx.Unlock()
+	if paused {
+		return
+	}
+	nodeA, nodeB, running := monitor.currentNodes()
+	if !running {
+		return
+	}
+	height := lowerTipHeight(nodeA, nodeB)
+	if numCompared > 0 && height < lastHeight+monitor.interval {
+		return
+	}
+	result, err := monitor.compare(nodeA, nodeB)
+	if err != nil {
+		return
+	}
+	monitor.record(result)
+}
+
+// currentNodes returns the current instances of the monitored nodes, and whether they're both running.
+func (monitor *ComparisonMonitor) currentNodes() (_nodeA *cmd.Node, _nodeB *cmd.Node, _running bool) {
+	nodeA := currentNodeInstance(monitor.t, monitor.nodeA)
+	nodeB := currentNodeInstance(monitor.t, monitor.nodeB)
+	return nodeA, nodeB, nodeA.IsRunning() && nodeB.IsRunning()
+}
+
+// compare compares the nodes once. Errors are only returned if a node was stopped or restarted during the comparison,
+// since the comparison can't be trusted then. Other errors are recorded in the result, like the differences.
+func (monitor *ComparisonMonitor) compare(nodeA *cmd.Node, nodeB *cmd.Node) (ComparisonResult, error) {
+	result := ComparisonResult{
+		HeightA: nodeA.Server.GetBlockchain().BlockTip().Height,
+		HeightB: nodeB.Server.GetBlockchain().BlockTip().Height,
+	}
+	result.Height = result.HeightA
+	if result.HeightB < result.Height {
+		result.Height = result.HeightB
+	}
+	result.ChainDivergence = describeChainDivergence(nodeA, nodeB, result.Height)
+
+	diff, err := lib.DiffNodeStates(nodeA.ChainDB, nodeB.ChainDB, comparableStatePrefixes(), monitor.opts)
+	if currentA, currentB, running := monitor.currentNodes(); !running || currentA != nodeA || currentB != nodeB {
+		return ComparisonResult{}, fmt.Errorf("a node was stopped during the comparison")
+	}
+	if err != nil {
+		result.BrokenPrefixes = []string{fmt.Sprintf("<problem comparing states: %v>", err)}
+		return result, nil
+	}
+	result.NumDifferences = diff.NumDifferences()
+	for _, prefix := range diff.BrokenPrefixes() {
+		result.BrokenPrefixes = append(result.BrokenPrefixes, lib.StatePrefixName(prefix))
+	}
+	result.BrokenPrefixes = append(result.BrokenPrefixes, diff.MissingExpectedDifferences...)
+	return result, nil
+}
+
+func (monitor *ComparisonMonitor) record(result ComparisonResult) {
+	monitor.mtx.Lock()
+	defer monitor.mtx.Unlock()
+	monitor.results = append(monitor.results, result)
+	monitor.lastHeight = result.Height
+	monitor.numCompared++
+	logNodeEvent(monitor.nodeB, "ComparisonMonitor: Compared with %v at %v", nodeLogName(monitor.nodeA), result)
+}
+
+// lowerTipHeight returns the lower of the nodes' block tip heights.
+func lowerTipHeight(nodeA *cmd.Node, nodeB *cmd.Node) uint32 {
+	heightA := nodeA.Server.GetBlockchain().BlockTip().Height
+	heightB := nodeB.Server.GetBlockchain().BlockTip().Height
+	if heightA < heightB {
+		return heightA
+	}
+	return heightB
+}
+
+// describeChainDivergence describes the blocks of both nodes' best chains at the first height up to maxHeight at which
+// they have different blocks, or returns an empty string if the chains have the same blocks up to maxHeight.
+func describeChainDivergence(nodeA *cmd.Node, nodeB *cmd.Node, maxHeight uint32) string {
+	chainA := getBestChainSnapshot(nodeA)
+	chainB := getBestChainSnapshot(nodeB)
+	for height := 0; height <= int(maxHeight); height++ {
+		if height >= len(chainA) || height >= len(chainB) || !chainA[height].Hash.IsEqual(chainB[height].Hash) {
+			return fmt.Sprintf("at height (%v), A %v, B %v", height, describeBlockNodeAt(chainA, height),
+				describeBlockNodeAt(chainB, height))
+		}
+	}
+	return ""
+}
+
+// Pause stops the monitor from comparing the nodes, and waits for a running comparison to finish, so that the nodes
+// can be restarted. Comparisons continue with the restarted nodes after Resume.
+func (monitor *ComparisonMonitor) Pause() {
+	monitor.mtx.Lock()
+	monitor.paused = true
+	monitor.mtx.Unlock()
+
+	monitor.compareMtx.Lock()
+	monitor.compareMtx.Unlock()
+}
+
+// Resume continues comparing the nodes after Pause.
+func (monitor *ComparisonMonitor) Resume() {
+	monitor.mtx.Lock()
+	defer monitor.mtx.Unlock()
+	monitor.paused = false
+}
+
+// Stop stops the monitor, and waits for a running comparison to finish. It is safe to call Stop more than once.
+func (monitor *ComparisonMonitor) Stop() {
+	monitor.stopOnce.Do(func() {
+		close(monitor.done)
+	})
+	<-monitor.stopped
+}
+
+// Results returns the results of the comparisons so far, in the order in which they ran.
+func (monitor *ComparisonMonitor) Results() []ComparisonResult {
+	monitor.mtx.Lock()
+	defer monitor.mtx.Unlock()
+	return append([]ComparisonResult{}, monitor.results...)
+}
+
+// AssertConverged stops the monitor, compares the nodes one last time, and fails the test unless the best chains never
+// diverged, the number of differences never grew from one comparison to the next, and the last comparison found no
+// differences. The nodes must be running, and should have converged, e.g. with waitForNodesToConverge.
+func (monitor *ComparisonMonitor) AssertConverged(t testing.TB) {
+	monitor.Stop()
+	nodeA, nodeB, running := monitor.currentNodes()
+	if !running {
+		t.Fatalf("AssertConverged: Both nodes must be running for the final comparison")
+	}
+	result, err := monitor.compare(nodeA, nodeB)
+	if err != nil {
+		t.Fatalf("AssertConverged: Problem with the final comparison (%v)", err)
+	}
+	monitor.record(result)
+
+	results := monitor.Results()
+	var lines []string
+	for ii, result := range results {
+		lines = append(lines, fmt.Sprintf("comparison (%v): %v", ii, result))
+	}
+	describeResults := strings.Join(lines, "\n")
+	for ii, result := range results {
+		if result.ChainDivergence != "" {
+			t.Fatalf("AssertConverged: Best chains of %v and %v diverged below both tips in comparison (%v):\n%v",
+				nodeLogName(nodeA), nodeLogName(nodeB), ii, describeResults)
+		}
+		if ii > 0 && result.NumDifferences > results[ii-1].NumDifferences {
+			t.Fatalf("AssertConverged: Differences between %v and %v grew in comparison (%v):\n%v",
+				nodeLogName(nodeA), nodeLogName(nodeB), ii, describeResults)
+		}
+	}
+	if result.NumDifferences > 0 || len(result.BrokenPrefixes) > 0 {
+		t.Fatalf("AssertConverged: States of %v and %v didn't converge:\n%v", nodeLogName(nodeA), nodeLogName(nodeB),
+			describeResults)
+	}
+	fmt.Printf("AssertConverged: States of %v and %v converged after (%v) comparisons\n", nodeLogName(nodeA),
+		nodeLogName(nodeB), len(results))
+}
@@ -0,0 +1,48 @@
+package integration_testing
+
+import (
+	"github.com/deso-protocol/core/cmd"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRegtestPeriodicComparison test if the differences between a syncing node and its source shrink to zero:
+//  1. Spawn two regtest nodes node1, node2, and mine blocks with transfers on node1 before bridging the nodes.
+//  2. start comparing node2 with node1 every few blocks, and bridge them together so that node2 syncs from node1.
+//  3. pause the comparisons, restart node2 in the middle of the sync, and resume them.
+//  4. once the nodes converge, the comparisons should show differences that only shrank and reached zero.
+func TestRegtestPeriodicComparison(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	node1 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir1, 10)))
+	node2 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir2, 10)))
+	mineBlocks(t, node1, 5)
+	for ii := 0; ii < 20; ii++ {
+		submitBasicTransfer(t, node1, 1000)
+		mineBlocks(t, node1, 1)
+	}
+
+	monitor := startPeriodicComparison(t, node1, node2, 5)
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+	waitForBlockHeight(t, node2, 10)
+	monitor.Pause()
+	node2, bridge = restartAndReconnectNode(t, node2, bridge)
+	monitor.Resume()
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+
+	monitor.AssertConverged(t)
+	results := monitor.Results()
+	require.Zero(results[len(results)-1].NumDifferences)
+	require.Equal(node1.Server.GetBlockchain().BlockTip().Height, results[len(results)-1].Height)
+	node1.Stop()
+	node2.Stop()
+}
@@ -0,0 +1,149 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRegtestPrunedNode test if a pruned node validates the chain while only keeping its most recent block bodies:
+//  1. Spawn an archival regtest node1, and a regtest node2 that keeps its last 5 block bodies only.
+//  2. node1 mines 20 blocks, and node2 syncs them through a bridge.
+//  3. node2 should have the same state as node1, but only the bodies of its last 5 blocks and the genesis block, and
+//     it shouldn't report that it's fully stored.
+//  4. a GetBlocks for a pruned block and the tip should be refused with a BlocksNotFound message listing both, and
+//     a GetBlocks for a stored block should still get the block back, as the peer isn't disconnected.
+//  5. Spawn a fresh node3 and bridge it to node2 only, node3 shouldn't pick node2 as its sync peer.
+func TestRegtestPrunedNode(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	dbDir3 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+	defer os.RemoveAll(dbDir3)
+
+	const pruneBlocksOlderThan = 5
+	node1 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir1, 10)))
+	node2 := startNode(t, cmd.NewNode(newTestConfig(t, dbDir2, 0, WithMaxPeers(10), WithRegtest(),
+		WithPruneBlocksOlderThan(pruneBlocksOlderThan))))
+	mineBlocks(t, node1, 20)
+
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+	compareNodesByState(t, node1, node2, Summary)
+	fmt.Println("Databases match!")
+
+	chain2 := node2.Server.GetBlockchain()
+	bestChain := chain2.BestChain()
+	tipHeight := chain2.BlockTip().Height
+	require.Equal(uint32(20), tipHeight)
+	require.NotNil(chain2.GetBlock(bestChain[0].Hash))
+	for _, blockNode := range bestChain[1:] {
+		if blockNode.Height <= tipHeight-pruneBlocksOlderThan {
+			require.Nil(chain2.GetBlock(blockNode.Hash), "height (%v)", blockNode.Height)
+			require.True(chain2.IsPrunedBlock(blockNode.Hash), "height (%v)", blockNode.Height)
+		} else {
+			require.NotNil(chain2.GetBlock(blockNode.Hash), "height (%v)", blockNode.Height)
+			require.False(chain2.IsPrunedBlock(blockNode.Hash), "height (%v)", blockNode.Height)
+		}
+	}
+	require.False(chain2.IsFullyStored())
+
+	// Catch the blocks and refusals that node2 sends, so that node1 doesn't get replies to requests it didn't send.
+	replies := make(chan lib.DeSoMessage, 10)
+	bridge.SetMessageHook(func(msg lib.DeSoMessage, direction Direction) (lib.DeSoMessage, bool) {
+		msgType := msg.GetMsgType()
+		if direction != DirectionBToA || (msgType != lib.MsgTypeBlock && msgType != lib.MsgTypeBlocksNotFound) {
+			return msg, true
+		}
+		replies <- msg
+		return nil, false
+	})
+	receiveReply := func() lib.DeSoMessage {
+		select {
+		case msg := <-replies:
+			return msg
+		case <-time.After(10 * time.Second):
+			t.Fatalf("Timed out waiting for node2 to reply to GetBlocks")
+			return nil
+		}
+	}
+	prunedHash := bestChain[1].Hash
+	tipHash := bestChain[tipHeight].Hash
+	require.NoError(bridge.InjectMessage(node2, &lib.MsgDeSoGetBlocks{HashList: []*lib.BlockHash{prunedHash, tipHash}}))
+	notFound, isNotFound := receiveReply().(*lib.MsgDeSoBlocksNotFound)
+	require.True(isNotFound, "node2 should refuse the pruned block")
+	require.Equal([]*lib.BlockHash{prunedHash, tipHash}, notFound.HashList)
+
+	// node2 still serves the peer, and it didn't send any of the refused blocks in the meantime.
+	recentHash := bestChain[tipHeight-1].Hash
+	require.NoError(bridge.InjectMessage(node2, &lib.MsgDeSoGetBlocks{HashList: []*lib.BlockHash{recentHash}}))
+	block, isBlock := receiveReply().(*lib.MsgDeSoBlock)
+	require.True(isBlock, "node2 should send the stored block")
+	blockHash, err := block.Header.Hash()
+	require.NoError(err)
+	require.True(recentHash.IsEqual(blockHash))
+	require.Empty(replies)
+
+	node3 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir3, 10)))
+	bridge3 := NewConnectionBridge(node2, node3)
+	require.NoError(bridge3.Start())
+	time.Sleep(2 * time.Second)
+	require.Equal(uint32(0), node3.Server.GetBlockchain().BlockTip().Height)
+
+	bridge3.Disconnect()
+	bridge.Disconnect()
+	node1.Stop()
+	node2.Stop()
+	node3.Stop()
+}
+
+// TestRegtestEnablePruningOnSyncedNode test if pruning can be turned on for a node that already stores all its blocks:
+//  1. Spawn a regtest node that keeps all its blocks, and mine 30 blocks on it.
+//  2. restart the node with PruneBlocksOlderThan set to 5, and mine a block, which should prune all stored blocks
+//     except for the last 5 and the genesis block.
+//  3. restart the node again, and mine another block, which should prune one more block, picking up where the
+//     pruning of the previous run stopped.
+func TestRegtestEnablePruningOnSyncedNode(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	const pruneBlocksOlderThan = 5
+	node := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest())))
+	mineBlocks(t, node, 30)
+
+	requirePruned := func() {
+		chain := node.Server.GetBlockchain()
+		tipHeight := chain.BlockTip().Height
+		bestChain := chain.BestChain()
+		require.NotNil(chain.GetBlock(bestChain[0].Hash))
+		for _, blockNode := range bestChain[1:] {
+			if blockNode.Height <= tipHeight-pruneBlocksOlderThan {
+				require.True(chain.IsPrunedBlock(blockNode.Hash), "height (%v)", blockNode.Height)
+			} else {
+				require.NotNil(chain.GetBlock(blockNode.Hash), "height (%v)", blockNode.Height)
+			}
+		}
+	}
+
+	node = restartNodeWithConfig(t, node, func(config *cmd.Config) {
+		config.PruneBlocksOlderThan = pruneBlocksOlderThan
+	})
+	mineBlocks(t, node, 1)
+	require.Equal(uint32(31), node.Server.GetBlockchain().BlockTip().Height)
+	requirePruned()
+
+	node = restartNode(t, node)
+	mineBlocks(t, node, 1)
+	require.Equal(uint32(32), node.Server.GetBlockchain().BlockTip().Height)
+	requirePruned()
+	node.Stop()
+}
@@ -19,9 +19,9 @@ func TestStateRollback(t *testing.T) {
 	defer os.RemoveAll(dbDir1)
 	defer os.RemoveAll(dbDir2)
 
-	config1 := generateConfig(t, 18000, dbDir1, 10)
+	config1 := generateConfig(t, dbDir1, 10)
 	config1.SyncType = lib.NodeSyncTypeBlockSync
-	config2 := generateConfig(t, 18001, dbDir2, 10)
+	config2 := generateConfig(t, dbDir2, 10)
 	config2.SyncType = lib.NodeSyncTypeBlockSync
 
 	config1.MaxSyncBlockHeight = 5000
@@ -38,8 +38,8 @@ func TestStateRollback(t *testing.T) {
 	node2 = startNode(t, node2)
 
 	// wait for node1, node2 to sync blocks
-	waitForNodeToFullySync(node1)
-	waitForNodeToFullySync(node2)
+	waitForNodeToFullySync(t, node1)
+	waitForNodeToFullySync(t, node2)
 
 	/* This code is no longer needed, but it was really useful in testing disconnect. Basically it goes transaction by
 	transaction and compares that connecting/disconnecting the transaction gives the same state at the end. The check
@@ -108,7 +108,7 @@ func TestStateRollback(t *testing.T) {
 	//}
 
 	require.NoError(node2.Server.GetBlockchain().DisconnectBlocksToHeight(5000, nil))
-	//compareNodesByState(t, node1, node2, 0)
+	//compareNodesByState(t, node1, node2, Summary)
 
 	node1Bytes := computeNodeStateChecksum(t, node1, 5000)
 	node2Bytes := computeNodeStateChecksum(t, node2, 5000)
@@ -0,0 +1,127 @@
+package integration_testing
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"strings"
+	"testing"
+)
+
+// ancestralRecordPrefix is the prefix of the ancestral records in the snapshot database, which are keyed by the
+// snapshot height followed by the main database key.
+var ancestralRecordPrefix = []byte{0}
+
+// compareNodesBySnapshotDB compares the ancestral records in the snapshot databases of the two provided nodes, which
+// hold the values of the state records before they were modified during every snapshot epoch, and which hypersync
+// relies on to serve snapshots. The ancestral records of the current epoch keep changing as the nodes connect blocks,
+// so only the completed epochs are compared, i.e. the epochs whose snapshot height is below the current snapshot
+// height of both nodes. That way, nodes that are at different points of the same epoch can still be compared. The
+// ancestral records are diffed, and reported, in the same way as compareNodesByStateWithPrefixList. Both nodes must
+// have hypersync enabled, and have snapshot records for the same epochs, e.g. because they both synced with blocks.
+func compareNodesBySnapshotDB(t testing.TB, nodeA *cmd.Node, nodeB *cmd.Node, verbosity Verbosity,
+	opts ...lib.DiffOptions) {
+
+	snapshotA := getNodeSnapshot(t, nodeA, "compareNodesBySnapshotDB")
+	snapshotB := getNodeSnapshot(t, nodeB, "compareNodesBySnapshotDB")
+	if snapshotA.SnapshotBlockHeightPeriod != snapshotB.SnapshotBlockHeightPeriod {
+		t.Fatalf("compareNodesBySnapshotDB: Nodes have different snapshot periods (%v) and (%v)",
+			snapshotA.SnapshotBlockHeightPeriod, snapshotB.SnapshotBlockHeightPeriod)
+	}
+	// Ancestral records are flushed to the snapshot database asynchronously.
+	snapshotA.WaitForAllOperationsToFinish()
+	snapshotB.WaitForAllOperationsToFinish()
+
+	epochPrefixes := completedEpochPrefixes(snapshotA.SnapshotBlockHeightPeriod,
+		snapshotA.CurrentEpochSnapshotMetadata.SnapshotBlockHeight,
+		snapshotB.CurrentEpochSnapshotMetadata.SnapshotBlockHeight)
+	if len(epochPrefixes) == 0 {
+		t.Fatalf("compareNodesBySnapshotDB: Nodes have no completed snapshot epoch in common, snapshot heights (%v) "+
+			"and (%v)", snapshotA.CurrentEpochSnapshotMetadata.SnapshotBlockHeight,
+			snapshotB.CurrentEpochSnapshotMetadata.SnapshotBlockHeight)
+	}
+	if verbosity >= Summary {
+		fmt.Fprintf(compareOutput, "compareNodesBySnapshotDB: Comparing ancestral records of (%v) completed epochs\n",
+			len(epochPrefixes))
+	}
+	compareNodesByStateWithPrefixList(t, snapshotA.SnapshotDb, snapshotB.SnapshotDb, epochPrefixes, verbosity,
+		opts...)
+}
+
+// completedEpochPrefixes returns the prefixes of the ancestral records of every snapshot epoch that both nodes
+// completed, given the nodes' current snapshot heights.
+func completedEpochPrefixes(period uint64, snapshotHeightA uint64, snapshotHeightB uint64) [][]byte {
+	currentHeight := snapshotHeightA
+	if snapshotHeightB < currentHeight {
+		currentHeight = snapshotHeightB
+	}
+	var prefixes [][]byte
+	for height := uint64(0); height < currentHeight; height += period {
+		prefixes = append(prefixes, append(append([]byte{}, ancestralRecordPrefix...), lib.EncodeUint64(height)...))
+	}
+	return prefixes
+}
+
+// compareNodesBySnapshotMetadata checks if the two provided nodes agree on the metadata of their current snapshot
+// epoch: the epoch number, the snapshot block height, the first snapshot block height, the block hash that identifies
+// the snapshot, and the checksum bytes of the snapshot. Nodes with identical states can still disagree on the
+// metadata, which breaks them as hypersync servers. Every differing field is reported. Both nodes must have hypersync
+// enabled.
+func compareNodesBySnapshotMetadata(t testing.TB, nodeA *cmd.Node, nodeB *cmd.Node) {
+	snapshotA := getNodeSnapshot(t, nodeA, "compareNodesBySnapshotMetadata")
+	snapshotB := getNodeSnapshot(t, nodeB, "compareNodesBySnapshotMetadata")
+	// The metadata is updated by the snapshot operations, which run asynchronously.
+	snapshotA.WaitForAllOperationsToFinish()
+	snapshotB.WaitForAllOperationsToFinish()
+
+	fieldsA := describeSnapshotMetadata(snapshotA)
+	fieldsB := describeSnapshotMetadata(snapshotB)
+	var lines []string
+	for ii := range fieldsA {
+		if fieldsA[ii].value != fieldsB[ii].value {
+			lines = append(lines, fmt.Sprintf("%v: A (%v), B (%v)", fieldsA[ii].name, fieldsA[ii].value,
+				fieldsB[ii].value))
+		}
+	}
+	if len(lines) > 0 {
+		t.Fatalf("compareNodesBySnapshotMetadata: Snapshot metadata of %v and %v differs:\n%v", nodeLogName(nodeA),
+			nodeLogName(nodeB), strings.Join(lines, "\n"))
+	}
+	fmt.Printf("compareNodesBySnapshotMetadata: Snapshot metadata matches at snapshot height (%v)\n",
+		snapshotA.CurrentEpochSnapshotMetadata.SnapshotBlockHeight)
+}
+
+// snapshotMetadataField is a field of the snapshot metadata compared by compareNodesBySnapshotMetadata.
+type snapshotMetadataField struct {
+	name  string
+	value string
+}
+
+// describeSnapshotMetadata returns the fields of the snapshot's current epoch metadata, in the order in which they're
+// reported.
+func describeSnapshotMetadata(snapshot *lib.Snapshot) []snapshotMetadataField {
+	metadata := snapshot.CurrentEpochSnapshotMetadata
+	blockHash := "<none>"
+	if metadata.CurrentEpochBlockHash != nil {
+		blockHash = metadata.CurrentEpochBlockHash.String()
+	}
+	return []snapshotMetadataField{
+		{"SnapshotBlockHeightPeriod", fmt.Sprintf("%v", snapshot.SnapshotBlockHeightPeriod)},
+		{"Epoch", fmt.Sprintf("%v", metadata.SnapshotBlockHeight/snapshot.SnapshotBlockHeightPeriod)},
+		{"SnapshotBlockHeight", fmt.Sprintf("%v", metadata.SnapshotBlockHeight)},
+		{"FirstSnapshotBlockHeight", fmt.Sprintf("%v", metadata.FirstSnapshotBlockHeight)},
+		{"CurrentEpochBlockHash", blockHash},
+		{"CurrentEpochChecksumBytes", hex.EncodeToString(metadata.CurrentEpochChecksumBytes)},
+	}
+}
+
+// getNodeSnapshot returns the node's snapshot, and fails the test on behalf of the caller if the node has none,
+// because it doesn't have hypersync enabled.
+func getNodeSnapshot(t testing.TB, node *cmd.Node, caller string) *lib.Snapshot {
+	snapshot := node.Server.GetBlockchain().Snapshot()
+	if snapshot == nil {
+		t.Fatalf("%v: %v has no snapshot, because HyperSync is disabled", caller, nodeLogName(node))
+	}
+	return snapshot
+}
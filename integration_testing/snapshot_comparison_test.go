@@ -0,0 +1,107 @@
+package integration_testing
+
+import (
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRegtestCompareSnapshotDB test if serving nodes that processed the same blocks have the same ancestral records:
+//  1. Spawn two regtest hypersync nodes node1, node2 with a short snapshot period, and bridge them together.
+//  2. mine blocks with transfers on node1 over a few snapshot epochs, which node2 syncs with blocks.
+//  3. once the nodes converge, the ancestral records of their completed epochs should match.
+//  4. after node1 mines into a new epoch without node2, the common completed epochs should still match.
+func TestRegtestCompareSnapshotDB(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	var nodes []*cmd.Node
+	for _, dbDir := range []string{dbDir1, dbDir2} {
+		config := generateRegtestConfig(t, dbDir, 10)
+		config.HyperSync = true
+		config.SyncType = lib.NodeSyncTypeBlockSync
+		config.SnapshotBlockHeightPeriod = 5
+		nodes = append(nodes, startNode(t, cmd.NewNode(config)))
+	}
+	node1, node2 := nodes[0], nodes[1]
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+
+	mineBlocks(t, node1, 3)
+	for ii := 0; ii < 9; ii++ {
+		submitBasicTransfer(t, node1, 1000)
+		mineBlocks(t, node1, 1)
+	}
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+	compareNodesBySnapshotDB(t, node1, node2, Summary)
+
+	bridge.Disconnect()
+	for ii := 0; ii < 5; ii++ {
+		submitBasicTransfer(t, node1, 1000)
+		mineBlocks(t, node1, 1)
+	}
+	compareNodesBySnapshotDB(t, node1, node2, Summary)
+	require.Len(completedEpochPrefixes(5, 15, 10), 2)
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestRegtestCompareSnapshotMetadata test if nodes that processed the same blocks agree on their snapshot metadata:
+//  1. Spawn two regtest hypersync nodes node1, node2 with a short snapshot period, and bridge them together.
+//  2. mine blocks with transfers on node1 over a few snapshot epochs, which node2 syncs with blocks.
+//  3. once the nodes converge, the snapshot metadata of both nodes should match.
+//  4. after node1 mines into a new epoch without node2, the epoch and the snapshot height should differ.
+func TestRegtestCompareSnapshotMetadata(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	var nodes []*cmd.Node
+	for _, dbDir := range []string{dbDir1, dbDir2} {
+		config := generateRegtestConfig(t, dbDir, 10)
+		config.HyperSync = true
+		config.SyncType = lib.NodeSyncTypeBlockSync
+		config.SnapshotBlockHeightPeriod = 5
+		nodes = append(nodes, startNode(t, cmd.NewNode(config)))
+	}
+	node1, node2 := nodes[0], nodes[1]
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+
+	mineBlocks(t, node1, 3)
+	for ii := 0; ii < 9; ii++ {
+		submitBasicTransfer(t, node1, 1000)
+		mineBlocks(t, node1, 1)
+	}
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+	compareNodesBySnapshotMetadata(t, node1, node2)
+
+	bridge.Disconnect()
+	for ii := 0; ii < 5; ii++ {
+		submitBasicTransfer(t, node1, 1000)
+		mineBlocks(t, node1, 1)
+	}
+	snapshot1 := getNodeSnapshot(t, node1, "TestRegtestCompareSnapshotMetadata")
+	snapshot2 := getNodeSnapshot(t, node2, "TestRegtestCompareSnapshotMetadata")
+	snapshot1.WaitForAllOperationsToFinish()
+	snapshot2.WaitForAllOperationsToFinish()
+	fields1 := describeSnapshotMetadata(snapshot1)
+	fields2 := describeSnapshotMetadata(snapshot2)
+	require.Equal("Epoch", fields1[1].name)
+	require.NotEqual(fields1[1].value, fields2[1].value)
+	require.NotEqual(fields1[2].value, fields2[2].value)
+	node1.Stop()
+	node2.Stop()
+}
@@ -0,0 +1,110 @@
+package integration_testing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestRegtestSnapshotExportImport test if a node bootstrapped from an exported snapshot syncs to the node it was
+// exported from:
+//  1. Spawn a regtest hypersync node1, mine blocks past a snapshot epoch, and stop it.
+//  2. Export node1's snapshot to a file, the snapshot should be at node1's last snapshot epoch.
+//  3. Tamper with the checksum in the file's manifest, importing it should fail without writing a chain db.
+//  4. Import the snapshot into a fresh data directory, and start an archival hypersync node2 on it, which should be at
+//     the snapshot block without syncing.
+//  5. Start node1 again and bridge it to node2, node2 should sync the remaining blocks, and download the blocks up to
+//     the snapshot height.
+//  6. compare node1 and node2 dbs match.
+func TestRegtestSnapshotExportImport(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	snapshotDir := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+	defer os.RemoveAll(snapshotDir)
+
+	const snapshotPeriod = 5
+	node1 := startNode(t, cmd.NewNode(newTestConfig(t, dbDir1, 0, WithMaxPeers(10), WithRegtest(),
+		WithHyperSync(snapshotPeriod))))
+	mineBlocks(t, node1, 2*snapshotPeriod+1)
+	tipHeight := node1.Server.GetBlockchain().BlockTip().Height
+	node1 = shutdownNode(t, node1)
+
+	snapshotPath := filepath.Join(snapshotDir, "regtest.snapshot")
+	manifest, err := cmd.ExportSnapshot(node1.Config.DataDirectory, snapshotPath)
+	require.NoError(err)
+	require.Equal(uint64(tipHeight-tipHeight%snapshotPeriod), manifest.SnapshotBlockHeight)
+	fmt.Printf("Exported snapshot at height (%v) with checksum (%v)\n", manifest.SnapshotBlockHeight,
+		manifest.Checksum)
+
+	tamperedPath := filepath.Join(snapshotDir, "tampered.snapshot")
+	tamperedChecksum := "00" + manifest.Checksum[2:]
+	if tamperedChecksum == manifest.Checksum {
+		tamperedChecksum = "ff" + manifest.Checksum[2:]
+	}
+	writeSnapshotWithChecksum(t, snapshotPath, tamperedPath, tamperedChecksum)
+	_, err = cmd.ImportSnapshot(dbDir2, tamperedPath)
+	require.Error(err)
+	require.Contains(err.Error(), "checksum")
+	require.NoDirExists(lib.GetBadgerDbPath(dbDir2))
+
+	importedManifest, err := cmd.ImportSnapshot(dbDir2, snapshotPath)
+	require.NoError(err)
+	require.Equal(manifest, importedManifest)
+	node2 := startNode(t, cmd.NewNode(newTestConfig(t, dbDir2, 0, WithMaxPeers(10), WithRegtest(),
+		WithHyperSync(snapshotPeriod), WithArchivalMode())))
+	blockTip := node2.Server.GetBlockchain().BlockTip()
+	require.Equal(manifest.SnapshotBlockHeight, uint64(blockTip.Height))
+	require.Equal(manifest.SnapshotBlockHash, hex.EncodeToString(blockTip.Hash[:]))
+
+	node1 = startNode(t, node1)
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+	waitForNodeToFullySyncAndStoreAllBlocks(t, node2)
+
+	compareNodesByDB(t, node1, node2, Summary)
+	fmt.Println("Databases match!")
+	bridge.Disconnect()
+	node1.Stop()
+	node2.Stop()
+}
+
+// writeSnapshotWithChecksum copies the snapshot file at path to tamperedPath, with the checksum in its manifest
+// replaced by checksum. The manifest is the file's first record, see cmd.ExportSnapshot.
+func writeSnapshotWithChecksum(t *testing.T, path string, tamperedPath string, checksum string) {
+	require := require.New(t)
+
+	snapshotBytes, err := os.ReadFile(path)
+	require.NoError(err)
+	reader := bytes.NewReader(snapshotBytes)
+	recordType, err := reader.ReadByte()
+	require.NoError(err)
+	manifestLen, err := lib.ReadUvarint(reader)
+	require.NoError(err)
+	manifestStart := len(snapshotBytes) - reader.Len()
+	manifestEnd := manifestStart + int(manifestLen)
+
+	manifest := &cmd.SnapshotManifest{}
+	require.NoError(json.Unmarshal(snapshotBytes[manifestStart:manifestEnd], manifest))
+	manifest.Checksum = checksum
+	manifestBytes, err := json.Marshal(manifest)
+	require.NoError(err)
+
+	tamperedBytes := append([]byte{recordType}, lib.UintToBuf(uint64(len(manifestBytes)))...)
+	tamperedBytes = append(tamperedBytes, manifestBytes...)
+	tamperedBytes = append(tamperedBytes, snapshotBytes[manifestEnd:]...)
+	require.NoError(os.WriteFile(tamperedPath, tamperedBytes, 0644))
+}
@@ -0,0 +1,343 @@
+package integration_testing
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// syncMetricsDirEnvVar can be set to a directory, to which every SyncMetrics collector writes its summary as a JSON
+// file named after the test and the node, e.g. for comparing sync performance across commits.
+const syncMetricsDirEnvVar = "DESO_SYNC_METRICS_DIR"
+
+// SyncMetrics records how long a node took to get through each phase of its sync: header sync, hypersync of every
+// state prefix, block sync, and the total time until the node first became fully current. It also records how long
+// the test blocked in every wait helper on the node. The collector follows the node's chain state and hypersync
+// progress events, and keeps following the node across restarts, since startNode and restartNode re-attach it. When
+// the test finishes, the collector prints a JSON summary, which turns sync tests into a coarse performance regression
+// net.
+// The collector works for both blocksync and hypersync nodes, the hypersync fields are just empty for the former.
+type SyncMetrics struct {
+	t    testing.TB
+	name string
+
+	mtx   sync.Mutex
+	start time.Time
+	// stateTimes are the times at which the node first entered each chain state.
+	stateTimes map[lib.SyncState]time.Time
+	// prefixes are the hypersync metrics of each state prefix, in the order the node started downloading them.
+	prefixes []*prefixSyncMetrics
+	waits    []SyncWaitMetrics
+
+	// node is the node instance the collector is attached to, server is the node's server at the time, which is
+	// replaced when the node restarts, and detach releases the subscriptions.
+	node   *cmd.Node
+	server *lib.Server
+	detach func()
+}
+
+// prefixSyncMetrics are the hypersync metrics of a single state prefix.
+type prefixSyncMetrics struct {
+	prefix    []byte
+	bytes     uint64
+	started   time.Time
+	completed time.Time
+	// attachedBytes is the ReceivedBytes of the prefix on the current node instance. ReceivedBytes starts over when
+	// the node restarts, so only increases of it are added to bytes.
+	attachedBytes uint64
+}
+
+// SyncWaitMetrics is how long the test blocked in a wait helper on the node.
+type SyncWaitMetrics struct {
+	Helper  string  `json:"helper"`
+	Seconds float64 `json:"seconds"`
+}
+
+// PrefixSyncMetrics is the summary of a state prefix's hypersync.
+type PrefixSyncMetrics struct {
+	Prefix  string  `json:"prefix"`
+	Bytes   uint64  `json:"bytes"`
+	Seconds float64 `json:"seconds"`
+	// Completed is false if the node never finished downloading the prefix, in which case Seconds is the time
+	// until the last progress.
+	Completed bool `json:"completed"`
+}
+
+// SyncMetricsSummary is the structured summary of a SyncMetrics collector. Durations are in seconds, and phases that
+// the node didn't go through, or didn't finish, are zero.
+type SyncMetricsSummary struct {
+	Test     string `json:"test"`
+	Node     string `json:"node"`
+	SyncType string `json:"syncType"`
+	// HeaderSyncSeconds is the time from the start of the collector until the node's header chain became current.
+	HeaderSyncSeconds float64 `json:"headerSyncSeconds"`
+	// HyperSyncSeconds is the time the node spent syncing the snapshot, and HyperSyncBytes the size of the state it
+	// downloaded.
+	HyperSyncSeconds float64             `json:"hyperSyncSeconds"`
+	HyperSyncBytes   uint64              `json:"hyperSyncBytes"`
+	Prefixes         []PrefixSyncMetrics `json:"prefixes,omitempty"`
+	// BlockSyncSeconds is the time from the end of header sync, or of hypersync, until the node became fully current.
+	BlockSyncSeconds float64 `json:"blockSyncSeconds"`
+	// FullyCurrentSeconds is the total time from the start of the collector until the node became fully current.
+	FullyCurrentSeconds float64           `json:"fullyCurrentSeconds"`
+	BlockTipHeight      uint32            `json:"blockTipHeight"`
+	HeaderTipHeight     uint32            `json:"headerTipHeight"`
+	Waits               []SyncWaitMetrics `json:"waits,omitempty"`
+}
+
+var (
+	syncMetricsMtx sync.Mutex
+	// syncMetrics maps the data directories of the nodes to their collectors.
+	syncMetrics = make(map[string]*SyncMetrics)
+)
+
+// NewSyncMetrics starts collecting the sync metrics of the node. The timings are measured from now, so the collector
+// should be created right after the node is started, before it's connected to its sync peer.
+func NewSyncMetrics(t testing.TB, node *cmd.Node) *SyncMetrics {
+	metrics := &SyncMetrics{
+		t:          t,
+		name:       nodeLogName(node),
+		start:      time.Now(),
+		stateTimes: make(map[lib.SyncState]time.Time),
+	}
+	syncMetricsMtx.Lock()
+	syncMetrics[node.Config.DataDirectory] = metrics
+	syncMetricsMtx.Unlock()
+	if node.IsRunning() {
+		metrics.attach(node)
+	}
+
+	t.Cleanup(func() {
+		syncMetricsMtx.Lock()
+		delete(syncMetrics, node.Config.DataDirectory)
+		syncMetricsMtx.Unlock()
+		metrics.mtx.Lock()
+		if metrics.detach != nil {
+			metrics.detach()
+			metrics.detach = nil
+		}
+		metrics.mtx.Unlock()
+		metrics.report()
+	})
+	return metrics
+}
+
+// getSyncMetrics returns the collector of the node, or nil if the node's sync metrics aren't collected.
+func getSyncMetrics(node *cmd.Node) *SyncMetrics {
+	syncMetricsMtx.Lock()
+	defer syncMetricsMtx.Unlock()
+	return syncMetrics[node.Config.DataDirectory]
+}
+
+// attachSyncMetrics attaches the node's collector, if it has one, to the started node instance. startNode calls it
+// for every node, so that the collector follows the node across restarts.
+func attachSyncMetrics(node *cmd.Node) {
+	if metrics := getSyncMetrics(node); metrics != nil {
+		metrics.attach(node)
+	}
+}
+
+// recordSyncWait records how long the test blocked in the wait helper on the node, if the node's sync metrics are
+// collected. Wait helpers call it with defer, with the time at which they started waiting.
+func recordSyncWait(node *cmd.Node, helper string, start time.Time) {
+	metrics := getSyncMetrics(node)
+	if metrics == nil {
+		return
+	}
+	metrics.mtx.Lock()
+	defer metrics.mtx.Unlock()
+	metrics.waits = append(metrics.waits, SyncWaitMetrics{
+		Helper:  helper,
+		Seconds: time.Since(start).Seconds(),
+	})
+}
+
+// attach subscribes to the chain state and hypersync progress events of the node instance's server, and releases the
+// subscriptions to the previous server.
+func (metrics *SyncMetrics) attach(node *cmd.Node) {
+	metrics.mtx.Lock()
+	defer metrics.mtx.Unlock()
+	if metrics.node == node && metrics.server == node.Server {
+		return
+	}
+	if metrics.detach != nil {
+		metrics.detach()
+	}
+	metrics.node = node
+	metrics.server = node.Server
+	for _, prefix := range metrics.prefixes {
+		prefix.attachedBytes = 0
+	}
+
+	// Subscribe before recording the current state, so that no change is missed in between.
+	states, unsubscribeStates := node.Server.GetBlockchain().SubscribeChainState()
+	progress, unsubscribeProgress := node.Server.SubscribeHyperSyncProgress()
+	done := make(chan struct{})
+	metrics.recordChainState(node.Server.GetBlockchain().ChainState())
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case state := <-states:
+				metrics.mtx.Lock()
+				metrics.recordChainState(state)
+				metrics.mtx.Unlock()
+			case prefixProgress := <-progress:
+				metrics.mtx.Lock()
+				metrics.recordPrefixProgress(prefixProgress)
+				metrics.mtx.Unlock()
+			}
+		}
+	}()
+	metrics.detach = func() {
+		close(done)
+		unsubscribeStates()
+		unsubscribeProgress()
+	}
+}
+
+// recordChainState records the time at which the node first entered the state. It must be called with the lock held.
+func (metrics *SyncMetrics) recordChainState(state lib.SyncState) {
+	if _, exists := metrics.stateTimes[state]; !exists {
+		metrics.stateTimes[state] = time.Now()
+	}
+}
+
+// recordPrefixProgress adds the progress to the metrics of its prefix. It must be called with the lock held.
+func (metrics *SyncMetrics) recordPrefixProgress(progress lib.SyncPrefixProgress) {
+	var prefix *prefixSyncMetrics
+	for _, existingPrefix := range metrics.prefixes {
+		if string(existingPrefix.prefix) == string(progress.Prefix) {
+			prefix = existingPrefix
+			break
+		}
+	}
+	if prefix == nil {
+		prefix = &prefixSyncMetrics{
+			prefix:  progress.Prefix,
+			started: time.Now(),
+		}
+		metrics.prefixes = append(metrics.prefixes, prefix)
+	}
+	if progress.ReceivedBytes > prefix.attachedBytes {
+		prefix.bytes += progress.ReceivedBytes - prefix.attachedBytes
+		prefix.attachedBytes = progress.ReceivedBytes
+	}
+	if progress.Completed && prefix.completed.IsZero() {
+		prefix.completed = time.Now()
+	}
+}
+
+// Summary returns the metrics collected so far.
+func (metrics *SyncMetrics) Summary() SyncMetricsSummary {
+	metrics.mtx.Lock()
+	defer metrics.mtx.Unlock()
+
+	summary := SyncMetricsSummary{
+		Test:  metrics.t.Name(),
+		Node:  metrics.name,
+		Waits: append([]SyncWaitMetrics{}, metrics.waits...),
+	}
+	if metrics.node != nil {
+		// The tip heights are read from the node's metrics registry, which keeps the values of the node's last
+		// instance after it stops.
+		summary.SyncType = string(metrics.node.Config.SyncType)
+		blockTipHeight, _ := metrics.node.Metrics().Value(lib.MetricBlockTipHeight)
+		headerTipHeight, _ := metrics.node.Metrics().Value(lib.MetricHeaderTipHeight)
+		summary.BlockTipHeight = uint32(blockTipHeight)
+		summary.HeaderTipHeight = uint32(headerTipHeight)
+	}
+
+	// Header sync ends when the node first enters any later state, and hypersync ends when the node first enters a
+	// state after SyncStateSyncingSnapshot.
+	headersEnd := metrics.firstStateTime(lib.SyncStateSyncingSnapshot, lib.SyncStateSyncingBlocks,
+		lib.SyncStateNeedBlocksss, lib.SyncStateSyncingHistoricalBlocks, lib.SyncStateFullyCurrent)
+	blocksStart := headersEnd
+	if !headersEnd.IsZero() {
+		summary.HeaderSyncSeconds = headersEnd.Sub(metrics.start).Seconds()
+	}
+	if snapshotStart, exists := metrics.stateTimes[lib.SyncStateSyncingSnapshot]; exists {
+		snapshotEnd := metrics.firstStateTime(lib.SyncStateSyncingBlocks, lib.SyncStateNeedBlocksss,
+			lib.SyncStateSyncingHistoricalBlocks, lib.SyncStateFullyCurrent)
+		blocksStart = snapshotEnd
+		if !snapshotEnd.IsZero() {
+			summary.HyperSyncSeconds = snapshotEnd.Sub(snapshotStart).Seconds()
+		}
+	}
+	if fullyCurrent, exists := metrics.stateTimes[lib.SyncStateFullyCurrent]; exists {
+		summary.FullyCurrentSeconds = fullyCurrent.Sub(metrics.start).Seconds()
+		if !blocksStart.IsZero() {
+			summary.BlockSyncSeconds = fullyCurrent.Sub(blocksStart).Seconds()
+		}
+	}
+
+	for _, prefix := range metrics.prefixes {
+		end := prefix.completed
+		if end.IsZero() {
+			end = time.Now()
+		}
+		summary.HyperSyncBytes += prefix.bytes
+		summary.Prefixes = append(summary.Prefixes, PrefixSyncMetrics{
+			Prefix:    hex.EncodeToString(prefix.prefix),
+			Bytes:     prefix.bytes,
+			Seconds:   end.Sub(prefix.started).Seconds(),
+			Completed: !prefix.completed.IsZero(),
+		})
+	}
+	return summary
+}
+
+// firstStateTime returns the earliest time at which the node entered one of the states, or the zero time if it
+// didn't enter any of them. It must be called with the lock held.
+func (metrics *SyncMetrics) firstStateTime(states ...lib.SyncState) time.Time {
+	var first time.Time
+	for _, state := range states {
+		if stateTime, exists := metrics.stateTimes[state]; exists && (first.IsZero() || stateTime.Before(first)) {
+			first = stateTime
+		}
+	}
+	return first
+}
+
+// AssertSyncFasterThan fails the test if the node didn't become fully current within the duration from the start of
+// the collector.
+func (metrics *SyncMetrics) AssertSyncFasterThan(duration time.Duration) {
+	metrics.mtx.Lock()
+	fullyCurrent, exists := metrics.stateTimes[lib.SyncStateFullyCurrent]
+	metrics.mtx.Unlock()
+	if !exists {
+		metrics.t.Fatalf("AssertSyncFasterThan: %v isn't fully current yet", metrics.name)
+	}
+	if elapsed := fullyCurrent.Sub(metrics.start); elapsed > duration {
+		metrics.t.Fatalf("AssertSyncFasterThan: %v took (%v) to become fully current, expected less than (%v)",
+			metrics.name, elapsed, duration)
+	}
+}
+
+// report prints the summary as JSON, and also writes it to syncMetricsDirEnvVar if it's set.
+func (metrics *SyncMetrics) report() {
+	summaryBytes, err := json.Marshal(metrics.Summary())
+	if err != nil {
+		metrics.t.Errorf("SyncMetrics: Problem encoding summary: %v", err)
+		return
+	}
+	fmt.Printf("SyncMetrics: %s\n", summaryBytes)
+
+	dir := os.Getenv(syncMetricsDirEnvVar)
+	if dir == "" {
+		return
+	}
+	fileName := strings.ReplaceAll(metrics.t.Name(), "/", "_") + "_" + metrics.name + ".json"
+	if err := os.WriteFile(filepath.Join(dir, fileName), summaryBytes, 0644); err != nil {
+		metrics.t.Errorf("SyncMetrics: Problem writing summary to (%v): %v", dir, err)
+	}
+}
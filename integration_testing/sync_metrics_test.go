@@ -0,0 +1,45 @@
+package integration_testing
+
+import (
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// TestSyncMetricsSummary tests if the sync phases are derived from the chain state times, and if the hypersync bytes
+// of a prefix keep adding up after the node restarts.
+func TestSyncMetricsSummary(t *testing.T) {
+	require := require.New(t)
+
+	start := time.Now()
+	metrics := &SyncMetrics{
+		t:     t,
+		name:  "node1",
+		start: start,
+		stateTimes: map[lib.SyncState]time.Time{
+			lib.SyncStateSyncingHeaders:  start,
+			lib.SyncStateSyncingSnapshot: start.Add(2 * time.Second),
+			lib.SyncStateSyncingBlocks:   start.Add(5 * time.Second),
+			lib.SyncStateFullyCurrent:    start.Add(9 * time.Second),
+		},
+	}
+	prefix := []byte{1}
+	metrics.recordPrefixProgress(lib.SyncPrefixProgress{Prefix: prefix, ReceivedBytes: 100})
+	metrics.recordPrefixProgress(lib.SyncPrefixProgress{Prefix: prefix, ReceivedBytes: 150})
+	// A restarted node starts counting the prefix's bytes from zero.
+	metrics.prefixes[0].attachedBytes = 0
+	metrics.recordPrefixProgress(lib.SyncPrefixProgress{Prefix: prefix, ReceivedBytes: 50, Completed: true})
+
+	summary := metrics.Summary()
+	require.Equal(float64(2), summary.HeaderSyncSeconds)
+	require.Equal(float64(3), summary.HyperSyncSeconds)
+	require.Equal(float64(4), summary.BlockSyncSeconds)
+	require.Equal(float64(9), summary.FullyCurrentSeconds)
+	require.Equal(uint64(200), summary.HyperSyncBytes)
+	require.Len(summary.Prefixes, 1)
+	require.Equal("01", summary.Prefixes[0].Prefix)
+	require.True(summary.Prefixes[0].Completed)
+
+	metrics.AssertSyncFasterThan(10 * time.Second)
+}
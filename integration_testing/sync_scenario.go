@@ -0,0 +1,251 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"os"
+	"testing"
+	"time"
+)
+
+// SyncScenario describes a sync test declaratively: a source node syncs MaxSyncBlockHeight blocks from the
+// "deso-seed-2.io" generator, a syncing node is bridged with it, the syncing node is interrupted according to Steps,
+// and once it's fully synced, the nodes are compared with Assertions. Scenarios are run with RunSyncScenario, which
+// makes it easy to define sync tests as tables, e.g. with a scenario per interruption point.
+type SyncScenario struct {
+	// SourceConfig and SyncingConfig modify the default configs of the source and syncing node, which both sync
+	// blocks. The source node always connects to the generator. Either can be nil.
+	SourceConfig  func(config *cmd.Config)
+	SyncingConfig func(config *cmd.Config)
+	// Steps are the interruptions applied to the syncing node, in order.
+	Steps []SyncStep
+	// Assertions are the comparisons between the source and the syncing node once the syncing node is fully synced.
+	Assertions []SyncAssertion
+}
+
+// SyncStep is an interruption applied to the syncing node once its trigger fires.
+type SyncStep struct {
+	Trigger SyncTrigger
+	Action  SyncAction
+}
+
+// SyncTrigger is the point during the sync at which a SyncStep is applied. Triggers are created with AtHeight,
+// AtRandomHeight, AtPrefix, AtRandomPrefix, and AtTime.
+type SyncTrigger struct {
+	kind syncTriggerKind
+	// minHeight and maxHeight bound the height of a height trigger. Equal bounds pick that height.
+	minHeight uint32
+	maxHeight uint32
+	// prefix is the prefix of a prefix trigger, or nil to pick a random prefix.
+	prefix []byte
+	delay  time.Duration
+}
+
+type syncTriggerKind int
+
+const (
+	syncTriggerHeight syncTriggerKind = iota
+	syncTriggerPrefix
+	syncTriggerTime
+)
+
+// AtHeight fires once the syncing node's block tip reaches height.
+func AtHeight(height uint32) SyncTrigger {
+	return SyncTrigger{kind: syncTriggerHeight, minHeight: height, maxHeight: height}
+}
+
+// AtRandomHeight fires once the syncing node's block tip reaches a random height in [minHeight, maxHeight). A zero
+// maxHeight stands for the syncing node's MaxSyncBlockHeight.
+func AtRandomHeight(minHeight uint32, maxHeight uint32) SyncTrigger {
+	return SyncTrigger{kind: syncTriggerHeight, minHeight: minHeight, maxHeight: maxHeight}
+}
+
+// AtPrefix fires once the syncing node starts downloading prefix in hypersync.
+func AtPrefix(prefix []byte) SyncTrigger {
+	return SyncTrigger{kind: syncTriggerPrefix, prefix: prefix}
+}
+
+// AtRandomPrefix fires once the syncing node starts downloading a random state prefix in hypersync.
+func AtRandomPrefix() SyncTrigger {
+	return SyncTrigger{kind: syncTriggerPrefix}
+}
+
+// AtTime fires once delay has passed since the previous step, or since the nodes were bridged for the first step.
+func AtTime(delay time.Duration) SyncTrigger {
+	return SyncTrigger{kind: syncTriggerTime, delay: delay}
+}
+
+// SyncAction is what happens to the syncing node when a SyncStep is applied.
+type SyncAction int
+
+const (
+	// Restart gracefully restarts the syncing node. If the bridge is connected, it reconnects to the restarted node.
+	Restart SyncAction = iota
+	// Crash terminates the syncing node without a graceful shutdown, and starts it again from the same data
+	// directory. If the bridge is connected, it reconnects to the restarted node.
+	Crash
+	// Disconnect disconnects the bridge between the nodes.
+	Disconnect
+	// Reconnect reconnects the bridge between the nodes.
+	Reconnect
+)
+
+func (action SyncAction) String() string {
+	switch action {
+	case Restart:
+		return "Restart"
+	case Crash:
+		return "Crash"
+	case Disconnect:
+		return "Disconnect"
+	case Reconnect:
+		return "Reconnect"
+	default:
+		return fmt.Sprintf("SyncAction(%d)", int(action))
+	}
+}
+
+// SyncAssertion is a comparison between the source and the syncing node.
+type SyncAssertion int
+
+const (
+	// ByDB compares the nodes with compareNodesByDB.
+	ByDB SyncAssertion = iota
+	// ByState compares the nodes with compareNodesByState.
+	ByState
+	// ByChecksum compares the nodes with compareNodesByChecksum.
+	ByChecksum
+	// ByTxIndex waits for both nodes to sync their txindex, and compares them with compareNodesByTxIndexSemantic and
+	// compareNodesByTxIndex.
+	ByTxIndex
+	// ByBlockIndex compares the nodes' best chains with compareNodesByBlockIndex.
+	ByBlockIndex
+)
+
+// RunSyncScenario runs the scenario, and fails the test if the syncing node doesn't sync or doesn't match the source
+// node. Random triggers are resolved with the test's TestRand, and printed, so a failing scenario can be replayed.
+func RunSyncScenario(t testing.TB, scenario SyncScenario) {
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	if scenario.SourceConfig != nil {
+		scenario.SourceConfig(config1)
+	}
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.SyncType = lib.NodeSyncTypeBlockSync
+	if scenario.SyncingConfig != nil {
+		scenario.SyncingConfig(config2)
+	}
+
+	node1 := startNode(t, cmd.NewNode(config1))
+	node2 := startNode(t, cmd.NewNode(config2))
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge the nodes together.
+	bridge := NewConnectionBridge(node1, node2)
+	if err := bridge.Start(); err != nil {
+		t.Fatalf("RunSyncScenario: Problem starting bridge: %v", err)
+	}
+	connected := true
+
+	for ii, step := range scenario.Steps {
+		description := waitForSyncTrigger(t, node2, step.Trigger)
+		fmt.Printf("Step (%v): %v at %v (re-use if test failed)\n", ii, step.Action, description)
+
+		switch step.Action {
+		case Restart, Crash:
+			replace := restartNode
+			if step.Action == Crash {
+				replace = func(t testing.TB, node *cmd.Node) *cmd.Node {
+					return startNode(t, crashNode(t, node))
+				}
+			}
+			if connected {
+				node2, bridge = replaceAndReconnectNode(t, node2, bridge, replace)
+				break
+			}
+			newNode := replace(t, node2)
+			if err := bridge.ReplaceNode(node2, newNode); err != nil {
+				t.Fatalf("RunSyncScenario: Problem replacing node in bridge: %v", err)
+			}
+			node2 = newNode
+		case Disconnect:
+			bridge.Disconnect()
+			connected = false
+		case Reconnect:
+			if err := bridge.Start(); err != nil {
+				t.Fatalf("RunSyncScenario: Problem reconnecting bridge: %v", err)
+			}
+			connected = true
+		default:
+			t.Fatalf("RunSyncScenario: Unknown action (%v)", step.Action)
+		}
+	}
+	if !connected {
+		t.Fatalf("RunSyncScenario: The bridge must be reconnected before the last step, or the node can't sync")
+	}
+	waitForNodeToFullySync(t, node2)
+
+	for _, assertion := range scenario.Assertions {
+		switch assertion {
+		case ByDB:
+			compareNodesByDB(t, node1, node2, Summary)
+		case ByState:
+			compareNodesByState(t, node1, node2, Summary)
+		case ByChecksum:
+			compareNodesByChecksum(t, node1, node2)
+		case ByTxIndex:
+			waitForNodeToFullySyncTxIndex(t, node1)
+			waitForNodeToFullySyncTxIndex(t, node2)
+			compareNodesByTxIndexSemantic(t, node1, node2)
+			compareNodesByTxIndex(t, node1, node2, Summary)
+		case ByBlockIndex:
+			compareNodesByBlockIndex(t, node1, node2, 0)
+		default:
+			t.Fatalf("RunSyncScenario: Unknown assertion (%v)", assertion)
+		}
+	}
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// waitForSyncTrigger waits until the trigger fires on the node, and returns a description of the point at which it
+// fired, with random triggers resolved.
+func waitForSyncTrigger(t testing.TB, node *cmd.Node, trigger SyncTrigger) string {
+	switch trigger.kind {
+	case syncTriggerHeight:
+		height := trigger.minHeight
+		if trigger.maxHeight != trigger.minHeight {
+			maxHeight := trigger.maxHeight
+			if maxHeight == 0 {
+				maxHeight = node.Config.MaxSyncBlockHeight
+			}
+			height = randomUint32Between(t, trigger.minHeight, maxHeight)
+		}
+		waitForBlockHeight(t, node, height)
+		return fmt.Sprintf("height (%v)", height)
+	case syncTriggerPrefix:
+		prefix := trigger.prefix
+		if prefix == nil {
+			prefixes := lib.StatePrefixes.StatePrefixesList
+			prefix = prefixes[randomUint32Between(t, 0, uint32(len(prefixes)))]
+		}
+		waitForSyncPrefix(t, node, prefix)
+		return fmt.Sprintf("sync prefix (%v)", prefix)
+	case syncTriggerTime:
+		time.Sleep(trigger.delay)
+		return fmt.Sprintf("delay (%v)", trigger.delay)
+	default:
+		t.Fatalf("waitForSyncTrigger: Unknown trigger (%v)", trigger.kind)
+		return ""
+	}
+}
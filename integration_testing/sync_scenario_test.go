@@ -0,0 +1,57 @@
+package integration_testing
+
+import (
+	"github.com/deso-protocol/core/cmd"
+	"testing"
+	"time"
+)
+
+// TestBlockSyncScenarios test if a node syncing blocks recovers from various interruptions. Every scenario spawns a
+// source node that syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator, and a node that syncs from the
+// source while being interrupted, and then compares the nodes.
+func TestBlockSyncScenarios(t *testing.T) {
+	scenarios := map[string]SyncScenario{
+		// the node loses its peer mid-sync, and syncs the remaining blocks once the peer is back.
+		"DisconnectAndReconnect": {
+			Steps: []SyncStep{
+				{Trigger: AtRandomHeight(10, 0), Action: Disconnect},
+				{Trigger: AtTime(5 * time.Second), Action: Reconnect},
+			},
+			Assertions: []SyncAssertion{ByBlockIndex, ByDB, ByChecksum},
+		},
+		// the node restarts while its peer is gone, and only then gets the peer back.
+		"RestartWhileDisconnected": {
+			Steps: []SyncStep{
+				{Trigger: AtRandomHeight(10, 0), Action: Disconnect},
+				{Trigger: AtTime(0), Action: Restart},
+				{Trigger: AtTime(0), Action: Reconnect},
+			},
+			Assertions: []SyncAssertion{ByDB},
+		},
+		// the node restarts twice, the second time by crashing.
+		"RestartThenCrash": {
+			Steps: []SyncStep{
+				{Trigger: AtHeight(10), Action: Restart},
+				{Trigger: AtRandomHeight(20, 0), Action: Crash},
+			},
+			Assertions: []SyncAssertion{ByBlockIndex, ByChecksum},
+		},
+		// both nodes build a txindex, which should match after the restart.
+		"RestartWithTxIndex": {
+			SourceConfig: func(config *cmd.Config) {
+				config.TXIndex = true
+			},
+			SyncingConfig: func(config *cmd.Config) {
+				config.TXIndex = true
+			},
+			Steps:      []SyncStep{{Trigger: AtRandomHeight(10, 0), Action: Restart}},
+			Assertions: []SyncAssertion{ByDB, ByTxIndex},
+		},
+	}
+	for name, scenario := range scenarios {
+		scenario := scenario
+		t.Run(name, func(t *testing.T) {
+			RunSyncScenario(t, scenario)
+		})
+	}
+}
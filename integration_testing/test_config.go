@@ -0,0 +1,178 @@
+package integration_testing
+
+import (
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"os"
+	"testing"
+)
+
+// NodeOption modifies the config of a test node. The index is the index of the node in a cluster spawned by
+// spawnNodeCluster, and 0 for a single node config from NewTestConfig.
+type NodeOption func(index int, config *cmd.Config)
+
+// NewTestConfig creates a config for a test node in its own temporary data directory, which is removed when the test
+// finishes. The default config is a private blocksync node on mainnet params, with no DNS seeds, 10 peers, captured
+// logs, and a free port picked when it's started, that syncs up to MaxSyncBlockHeight blocks. The options are applied
+// in order on top of the default, e.g. NewTestConfig(t, WithHyperSync(HyperSyncSnapshotPeriod), WithArchivalMode()),
+// and the resulting config must be valid.
+func NewTestConfig(t testing.TB, opts ...NodeOption) *cmd.Config {
+	dataDir := getDirectory(t)
+	t.Cleanup(func() {
+		os.RemoveAll(dataDir)
+	})
+	return newTestConfig(t, dataDir, 0, opts...)
+}
+
+// newTestConfig creates the config of NewTestConfig in the provided data directory, for the node with the provided
+// index.
+func newTestConfig(t testing.TB, dataDir string, index int, opts ...NodeOption) *cmd.Config {
+	config := &cmd.Config{}
+	params := lib.DeSoMainnetParams
+	params.DNSSeeds = []string{}
+	config.Params = &params
+	config.ProtocolPort = 0
+	config.DataDirectory = dataDir
+	if err := os.MkdirAll(config.DataDirectory, os.ModePerm); err != nil {
+		t.Fatalf("newTestConfig: Could not create data directories (%s): %v", config.DataDirectory, err)
+	}
+	captureNodeLogs(t, config)
+	config.ConnectIPs = []string{}
+	config.PrivateMode = true
+	config.GlogV = 0
+	config.GlogVmodule = "*bitcoin_manager*=0,*balance*=0,*view*=0,*frontend*=0,*peer*=0,*addr*=0,*network*=0," +
+		"*utils*=0,*connection*=0,*main*=0,*server*=0,*mempool*=0,*miner*=0,*blockchain*=0"
+	config.MaxInboundPeers = 10
+	config.TargetOutboundPeers = 10
+	// The long stall timeout keeps slow links from being disconnected. Tests of stall handling should lower it to
+	// a few seconds, since peers check for stalled requests every second.
+	config.StallTimeoutSeconds = 900
+	config.MinFeerate = 1000
+	config.OneInboundPerIp = false
+	// Test nodes are usually connected to their peers after they're started, so they're ready without any.
+	config.Standalone = true
+	config.MaxBlockTemplatesCache = 100
+	config.MinBlockUpdateInterval = 10
+	config.SyncType = lib.NodeSyncTypeBlockSync
+	config.MaxSyncBlockHeight = MaxSyncBlockHeight
+	// The snapshot period only matters once hypersync is turned on, but it's set so that tests which turn on
+	// config.HyperSync directly get the usual period.
+	config.SnapshotBlockHeightPeriod = HyperSyncSnapshotPeriod
+
+	for _, opt := range opts {
+		opt(index, config)
+	}
+	// An in-memory node never touches its data directory, whose path only identifies the node in the test, e.g. in its
+	// logs. The directory is only kept if an option wrote to it, e.g. WithLogFile.
+	if config.InMemory {
+		os.Remove(config.DataDirectory)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("newTestConfig: Config is invalid: %v", err)
+	}
+	return config
+}
+
+// WithHyperSync turns on hypersync, with snapshots taken every snapshotPeriod blocks. The node still syncs with the
+// default blocksync sync type, which serves snapshots to other nodes, unless the sync type is set with WithSyncType or
+// WithArchivalMode.
+func WithHyperSync(snapshotPeriod uint64) NodeOption {
+	return func(index int, config *cmd.Config) {
+		config.HyperSync = true
+		config.SnapshotBlockHeightPeriod = snapshotPeriod
+	}
+}
+
+// WithSyncType sets the sync type, e.g. lib.NodeSyncTypeHyperSync.
+func WithSyncType(syncType lib.NodeSyncType) NodeOption {
+	return func(index int, config *cmd.Config) {
+		config.SyncType = syncType
+	}
+}
+
+// WithArchivalMode makes a hypersync node download all the blocks after it hypersyncs the state, i.e. the
+// hypersync-archival sync type. It requires WithHyperSync.
+func WithArchivalMode() NodeOption {
+	return WithSyncType(lib.NodeSyncTypeHyperSyncArchival)
+}
+
+// WithTXIndex turns on the txindex.
+func WithTXIndex() NodeOption {
+	return func(index int, config *cmd.Config) {
+		config.TXIndex = true
+	}
+}
+
+// WithMaxSyncBlockHeight sets the height at which the nodes stop syncing blocks, or 0 to sync all blocks.
+func WithMaxSyncBlockHeight(height uint32) NodeOption {
+	return func(index int, config *cmd.Config) {
+		config.MaxSyncBlockHeight = height
+	}
+}
+
+// WithPruneBlocksOlderThan makes the nodes keep the bodies of their most recent pruneBlocksOlderThan blocks only, see
+// cmd.Config.PruneBlocksOlderThan.
+func WithPruneBlocksOlderThan(pruneBlocksOlderThan uint32) NodeOption {
+	return func(index int, config *cmd.Config) {
+		config.PruneBlocksOlderThan = pruneBlocksOlderThan
+	}
+}
+
+// WithMaxPeers sets the number of inbound and outbound peers of the nodes.
+func WithMaxPeers(maxPeers uint32) NodeOption {
+	return func(index int, config *cmd.Config) {
+		config.MaxInboundPeers = maxPeers
+		config.TargetOutboundPeers = maxPeers
+	}
+}
+
+// WithConnectIPs makes the nodes connect to the provided addresses, e.g. "deso-seed-2.io:17000".
+func WithConnectIPs(connectIPs ...string) NodeOption {
+	return func(index int, config *cmd.Config) {
+		config.ConnectIPs = connectIPs
+	}
+}
+
+// WithParams makes the nodes use a copy of the provided params, e.g. &lib.DeSoTestnetParams, without DNS seeds.
+func WithParams(params *lib.DeSoParams) NodeOption {
+	return func(index int, config *cmd.Config) {
+		// Nodes modify their params, so every node needs its own copy.
+		paramsCopy := params.Copy()
+		paramsCopy.DNSSeeds = []string{}
+		config.Params = paramsCopy
+	}
+}
+
+// WithRegtest turns the nodes into regtest nodes, with lib.NewDeSoRegtestParams, which can quickly mine their own
+// blocks from the testnet genesis block with mineBlocks.
+func WithRegtest() NodeOption {
+	return func(index int, config *cmd.Config) {
+		config.Params = lib.NewDeSoRegtestParams()
+		config.Regtest = true
+	}
+}
+
+// WithMetricsPort makes the nodes serve their metrics on the provided port, which is incremented by the index of the
+// node in a cluster, so that every node gets its own port.
+func WithMetricsPort(port uint16) NodeOption {
+	return func(index int, config *cmd.Config) {
+		config.MetricsPort = port + uint16(index)
+	}
+}
+
+// WithInMemory makes the nodes keep their dbs in memory rather than in their data directories, see
+// cmd.Config.InMemory, so that they start faster and leave nothing behind. A node keeps its state when it's restarted
+// with restartNode, but not when it's stopped with shutdownNode and started again.
+func WithInMemory() NodeOption {
+	return func(index int, config *cmd.Config) {
+		config.InMemory = true
+	}
+}
+
+// WithListenAddrs makes the nodes listen on the provided "host:port" addresses, e.g. "[::1]:0", rather than on a free
+// port on every interface, see cmd.Config.ListenAddrs.
+func WithListenAddrs(addrs ...string) NodeOption {
+	return func(index int, config *cmd.Config) {
+		config.ListenAddrs = addrs
+	}
+}
@@ -0,0 +1,44 @@
+package integration_testing
+
+import (
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+)
+
+// TestNewTestConfig test if the options of NewTestConfig compose over the default config:
+//  1. the default config should be a blocksync node without hypersync, that syncs up to MaxSyncBlockHeight blocks.
+//  2. the options should override the default, and later options should override earlier options.
+//  3. generateConfig should keep returning the default config.
+func TestNewTestConfig(t *testing.T) {
+	require := require.New(t)
+
+	config := NewTestConfig(t)
+	require.False(config.HyperSync)
+	require.False(config.TXIndex)
+	require.Equal(lib.NodeSyncType(lib.NodeSyncTypeBlockSync), config.SyncType)
+	require.Equal(uint32(MaxSyncBlockHeight), config.MaxSyncBlockHeight)
+	require.Equal(uint32(10), config.MaxInboundPeers)
+	require.DirExists(config.DataDirectory)
+
+	config = NewTestConfig(t, WithHyperSync(500), WithArchivalMode(), WithTXIndex(), WithMaxPeers(3),
+		WithMaxSyncBlockHeight(2000), WithMaxSyncBlockHeight(0), WithConnectIPs("127.0.0.1:18000"))
+	require.True(config.HyperSync)
+	require.Equal(uint64(500), config.SnapshotBlockHeightPeriod)
+	require.Equal(lib.NodeSyncType(lib.NodeSyncTypeHyperSyncArchival), config.SyncType)
+	require.True(config.TXIndex)
+	require.Equal(uint32(3), config.MaxInboundPeers)
+	require.Equal(uint32(3), config.TargetOutboundPeers)
+	require.Zero(config.MaxSyncBlockHeight)
+	require.Equal([]string{"127.0.0.1:18000"}, config.ConnectIPs)
+
+	dbDir := getDirectory(t)
+	defer os.RemoveAll(dbDir)
+	config = generateConfig(t, dbDir, 10)
+	expected := NewTestConfig(t)
+	require.Equal(expected.MaxSyncBlockHeight, config.MaxSyncBlockHeight)
+	require.Equal(expected.SnapshotBlockHeightPeriod, config.SnapshotBlockHeightPeriod)
+	require.Equal(expected.SyncType, config.SyncType)
+	require.Equal(dbDir, config.DataDirectory)
+}
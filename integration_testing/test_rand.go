@@ -0,0 +1,104 @@
+package integration_testing
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSeedEnvVar can be set to reproduce a test run with the seed printed by a failing test, e.g.
+// `DESO_TEST_SEED=1234 go test ./integration_testing -run TestBlockSyncChaos`.
+const testSeedEnvVar = "DESO_TEST_SEED"
+
+// TestRand is the source of all randomness in a test, such as random heights, chaos faults, bridge latencies, drops
+// and reorderings, and generated transactions. Every test gets its own TestRand through getTestRand, which is seeded
+// from testSeedEnvVar, or from the time if it isn't set. The seed is printed when the test first uses randomness, and
+// again if the test fails, so that the random decisions of a failing run can be replayed. Components with their own
+// random number generator, such as ConnectionBridge, are seeded from the test's TestRand, so that their decisions are
+// reproducible too, as long as they're created in the same order.
+type TestRand struct {
+	mtx  sync.Mutex
+	seed int64
+	rng  *rand.Rand
+}
+
+var (
+	testRandsMtx sync.Mutex
+	testRands    = make(map[testing.TB]*TestRand)
+)
+
+// getTestRand returns the TestRand of the test, and creates it when the test first uses randomness.
+func getTestRand(t testing.TB) *TestRand {
+	testRandsMtx.Lock()
+	defer testRandsMtx.Unlock()
+	if testRand, exists := testRands[t]; exists {
+		return testRand
+	}
+
+	seed := time.Now().UnixNano()
+	if seedStr := os.Getenv(testSeedEnvVar); seedStr != "" {
+		var err error
+		if seed, err = strconv.ParseInt(seedStr, 10, 64); err != nil {
+			t.Fatalf("getTestRand: Problem parsing %v (%v): %v", testSeedEnvVar, seedStr, err)
+		}
+	}
+	fmt.Printf("%v: Running with seed (%v), set %v=%v to reproduce\n", t.Name(), seed, testSeedEnvVar, seed)
+
+	testRand := &TestRand{
+		seed: seed,
+		rng:  rand.New(rand.NewSource(seed)),
+	}
+	testRands[t] = testRand
+	t.Cleanup(func() {
+		if t.Failed() {
+			t.Logf("%v: Test failed with seed (%v), run with %v=%v to reproduce", t.Name(), seed, testSeedEnvVar,
+				seed)
+		}
+		testRandsMtx.Lock()
+		delete(testRands, t)
+		testRandsMtx.Unlock()
+	})
+	return testRand
+}
+
+// Seed returns the seed of the test.
+func (testRand *TestRand) Seed() int64 {
+	return testRand.seed
+}
+
+// Int63 returns a random non-negative int64.
+func (testRand *TestRand) Int63() int64 {
+	testRand.mtx.Lock()
+	defer testRand.mtx.Unlock()
+	return testRand.rng.Int63()
+}
+
+// Int63n returns a random number in [0, n).
+func (testRand *TestRand) Int63n(n int64) int64 {
+	testRand.mtx.Lock()
+	defer testRand.mtx.Unlock()
+	return testRand.rng.Int63n(n)
+}
+
+// Intn returns a random number in [0, n).
+func (testRand *TestRand) Intn(n int) int {
+	testRand.mtx.Lock()
+	defer testRand.mtx.Unlock()
+	return testRand.rng.Intn(n)
+}
+
+// Uint32Between returns a random number in [min, max).
+func (testRand *TestRand) Uint32Between(min, max uint32) uint32 {
+	return min + uint32(testRand.Int63n(int64(max-min)))
+}
+
+// NewRand returns a random number generator seeded from the TestRand, for components that need their own generator.
+// The seed is also returned, so that components can report it.
+func (testRand *TestRand) NewRand() (*rand.Rand, int64) {
+	seed := testRand.Int63()
+	return rand.New(rand.NewSource(seed)), seed
+}
@@ -0,0 +1,25 @@
+package integration_testing
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+// TestTestRandReproducible test if tests with the same seed make the same random decisions.
+func TestTestRandReproducible(t *testing.T) {
+	require := require.New(t)
+
+	t.Setenv(testSeedEnvVar, "1234")
+	var runs [][]uint32
+	for ii := 0; ii < 2; ii++ {
+		t.Run("run", func(t *testing.T) {
+			require.Equal(int64(1234), getTestRand(t).Seed())
+			var values []uint32
+			for jj := 0; jj < 10; jj++ {
+				values = append(values, randomUint32Between(t, 10, 1000))
+			}
+			runs = append(runs, values)
+		})
+	}
+	require.Equal(runs[0], runs[1])
+}
@@ -1,19 +1,24 @@
 package integration_testing
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
 	"fmt"
-	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcd/btcec"
 	"github.com/deso-protocol/core/cmd"
 	"github.com/deso-protocol/core/lib"
 	"github.com/dgraph-io/badger/v3"
 	"github.com/golang/glog"
-	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -28,8 +33,9 @@ import (
 // compare nodes by database checksums via compareNodesByChecksum. It is a good practice to verify both states and checksums.
 //
 // Finally, we have wrappers around general node behavior, such as startNode, restartNode, etc. We can also wait until
-// a node is synced to a certain height with listenForBlockHeight, or until hypersync has begun syncing a certain prefix
-// via listenForSyncPrefix.
+// a node is synced to a certain height with waitForBlockHeight, or until hypersync has begun syncing a certain prefix
+// via waitForSyncPrefix. The underlying listenForBlockHeight and listenForSyncPrefix return channels that are closed
+// once the condition is met, and stop polling when their context is canceled or the test finishes.
 //
 // Summarizing, the node testing framework is intentionally lightweight and general so that we can test a wide range of
 // node behaviors. Check out
@@ -37,11 +43,12 @@ import (
 // Global variable that determines the max tip blockheight of syncing nodes throughout test cases.
 const MaxSyncBlockHeight = 1500
 
-// Global variable that allows setting node configuration hypersync snapshot period.
+// HyperSyncSnapshotPeriod is the usual snapshot period of test nodes. Every node's period is set on its own with
+// WithHyperSync, so tests can pair nodes with different periods.
 const HyperSyncSnapshotPeriod = 1000
 
 // get a random temporary directory.
-func getDirectory(t *testing.T) string {
+func getDirectory(t testing.TB) string {
 	require := require.New(t)
 	dbDir, err := ioutil.TempDir("", "badgerdb")
 	if err != nil {
@@ -50,112 +57,552 @@ func getDirectory(t *testing.T) string {
 	return dbDir
 }
 
-// generateConfig creates a default config for a node, with provided port, db directory, and number of max peers.
-// It's usually the first step to starting a node.
-func generateConfig(t *testing.T, port uint32, dataDir string, maxPeers uint32) *cmd.Config {
-	config := &cmd.Config{}
-	params := lib.DeSoMainnetParams
+// generateConfig creates a default config for a node, with provided db directory, and number of max peers. The node
+// listens on a free port picked when it's started, see cmd.Node.ListeningPort. It's usually the first step to starting
+// a node. It's the NewTestConfig default config, see NewTestConfig for composing the config of a node with options.
+func generateConfig(t testing.TB, dataDir string, maxPeers uint32) *cmd.Config {
+	return newTestConfig(t, dataDir, 0, WithMaxPeers(maxPeers))
+}
+
+// generateRegtestConfig returns a config for a regtest node, which starts from the testnet genesis block and can
+// quickly mine its own blocks with mineBlocks.
+func generateRegtestConfig(t testing.TB, dataDir string, maxPeers uint32) *cmd.Config {
+	return newTestConfig(t, dataDir, 0, WithMaxPeers(maxPeers), WithRegtest())
+}
+
+// generateConfigFromFile loads a node config from a config file in testdata, e.g. "regtest_node.yaml", see
+// cmd.LoadConfigFile, so that the config of a scenario can be reviewed as data. Like generateConfig, the node gets the
+// provided data directory, captured logs, and no DNS seeds. The per-test mutations are applied last, and the resulting
+// config must be valid.
+func generateConfigFromFile(t testing.TB, dataDir string, fileName string,
+	mutations ...func(config *cmd.Config)) *cmd.Config {
 
-	params.DNSSeeds = []string{}
-	config.Params = &params
-	config.ProtocolPort = uint16(port)
-	// "/Users/piotr/data_dirs/n98_1"
+	config, err := cmd.LoadConfigFile(filepath.Join("testdata", fileName))
+	if err != nil {
+		t.Fatalf("generateConfigFromFile: %v", err)
+	}
+	// Regtest nodes in tests mine at the minimum difficulty, so they all need the same params as WithRegtest.
+	if config.Regtest {
+		config.Params = lib.NewDeSoRegtestParams()
+	}
+	config.Params.DNSSeeds = []string{}
 	config.DataDirectory = dataDir
 	if err := os.MkdirAll(config.DataDirectory, os.ModePerm); err != nil {
-		t.Fatalf("Could not create data directories (%s): %v", config.DataDirectory, err)
-	}
-	config.TXIndex = false
-	config.HyperSync = false
-	config.MaxSyncBlockHeight = 0
-	config.ConnectIPs = []string{}
-	config.PrivateMode = true
-	config.GlogV = 0
-	config.GlogVmodule = "*bitcoin_manager*=0,*balance*=0,*view*=0,*frontend*=0,*peer*=0,*addr*=0,*network*=0,*utils*=0,*connection*=0,*main*=0,*server*=0,*mempool*=0,*miner*=0,*blockchain*=0"
-	config.MaxInboundPeers = maxPeers
-	config.TargetOutboundPeers = maxPeers
-	config.StallTimeoutSeconds = 900
-	config.MinFeerate = 1000
-	config.OneInboundPerIp = false
-	config.MaxBlockTemplatesCache = 100
-	config.MaxSyncBlockHeight = 100
-	config.MinBlockUpdateInterval = 10
-	config.SnapshotBlockHeightPeriod = HyperSyncSnapshotPeriod
-	config.MaxSyncBlockHeight = MaxSyncBlockHeight
-	config.SyncType = lib.NodeSyncTypeBlockSync
-	//config.ArchivalMode = true
-
+		t.Fatalf("generateConfigFromFile: Could not create data directories (%s): %v", config.DataDirectory, err)
+	}
+	captureNodeLogs(t, config)
+	for _, mutate := range mutations {
+		mutate(config)
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("generateConfigFromFile: Config from (%v) is invalid: %v", fileName, err)
+	}
 	return config
 }
 
-// waitForNodeToFullySync will busy-wait until provided node is fully current.
-func waitForNodeToFullySync(node *cmd.Node) {
-	ticker := time.NewTicker(5 * time.Millisecond)
-	for {
-		<-ticker.C
+// regtestMinerPublicKey is the public key that receives the block rewards of blocks mined with mineBlocks. Regtest
+// block rewards can be spent right away, so tests can spend them with submitBasicTransfer, which signs with
+// regtestMinerPrivateKey.
+const (
+	regtestMinerPublicKey  = "tBCKXFJEDSF7Thcc6BUBcB6kicE5qzmLbAtvFf9LfKSXN4LwFt36oX"
+	regtestMinerPrivateKey = "tbc31669t2YuZ2mi1VLtK6a17RXFPdsuBDcenPLc1eU1ZVRHF9Zv4"
+)
+
+// regtestRecipientPublicKey is the public key that receives the transfers made with submitBasicTransfer.
+const regtestRecipientPublicKey = "tBCKXU8pf7nkn8M38sYJeAwiBP7HbSJWy9Zmn4sHNL6gA6ahkriymq"
+
+// mineBlocks mines numBlocks blocks on top of the node's block tip, and returns them in the order they were mined.
+// Every block includes the transactions from the node's mempool that fit into it, so the mempool is drained into the
+// mined blocks. The blocks are relayed to the node's peers. Only regtest nodes can mine, since the difficulty on other
+// networks is too high for tests.
+func mineBlocks(t testing.TB, node *cmd.Node, numBlocks uint32) []*lib.MsgDeSoBlock {
+	require := require.New(t)
+	if !node.Config.Regtest {
+		t.Fatalf("mineBlocks: Node must be in regtest to mine blocks")
+	}
+	miner, err := lib.NewDeSoMiner([]string{regtestMinerPublicKey}, 1,
+		node.Server.GetBlockProducer(), node.Params)
+	require.NoError(err)
+
+	var blocks []*lib.MsgDeSoBlock
+	for ii := uint32(0); ii < numBlocks; ii++ {
+		block, err := miner.MineAndProcessSingleBlock(0, node.Server.GetMempool())
+		require.NoError(err)
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
 
-		if node.Server.GetBlockchain().ChainState() == lib.SyncStateFullyCurrent {
-			if node.Server.GetBlockchain().Snapshot() != nil {
-				node.Server.GetBlockchain().Snapshot().WaitForAllOperationsToFinish()
+// submitBasicTransfer sends amountNanos from the regtest miner to regtestRecipientPublicKey through the node, which
+// adds the transaction to its mempool and relays it to its peers. The miner must have enough funds, e.g. from blocks
+// mined with mineBlocks.
+func submitBasicTransfer(t testing.TB, node *cmd.Node, amountNanos uint64) *lib.MsgDeSoTxn {
+	require := require.New(t)
+
+	senderPkBytes, _, err := lib.Base58CheckDecode(regtestMinerPublicKey)
+	require.NoError(err)
+	recipientPkBytes, _, err := lib.Base58CheckDecode(regtestRecipientPublicKey)
+	require.NoError(err)
+	txn := &lib.MsgDeSoTxn{
+		TxInputs: []*lib.DeSoInput{},
+		TxOutputs: []*lib.DeSoOutput{{
+			PublicKey:   recipientPkBytes,
+			AmountNanos: amountNanos,
+		}},
+		PublicKey: senderPkBytes,
+		TxnMeta:   &lib.BasicTransferMetadata{},
+	}
+	_, _, _, _, err = node.Server.GetBlockchain().AddInputsAndChangeToTransaction(txn, node.Config.MinFeerate,
+		node.Server.GetMempool())
+	require.NoError(err)
+
+	privKeyBytes, _, err := lib.Base58CheckDecode(regtestMinerPrivateKey)
+	require.NoError(err)
+	privKey, _ := btcec.PrivKeyFromBytes(btcec.S256(), privKeyBytes)
+	require.NoError(signAndBroadcastTxn(node, txn, privKey))
+	return txn
+}
+
+// waitForNodesToConverge waits until all provided nodes have the same block tip, and fails the test if they don't
+// converge within the timeout.
+func waitForNodesToConverge(t testing.TB, nodes []*cmd.Node, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	events := subscribeToNodeEvents(nodes...)
+	defer events.unsubscribe()
+
+	for {
+		tipHash := nodes[0].Status().BlockTipHash
+		converged := true
+		for _, node := range nodes[1:] {
+			if tipHash != node.Status().BlockTipHash {
+				converged = false
+				break
 			}
+		}
+		if converged {
 			return
 		}
+		if !events.wait(ctx) {
+			t.Fatalf("waitForNodesToConverge: nodes didn't converge on the same block tip within (%v)", timeout)
+		}
 	}
 }
 
-// waitForNodeToFullySyncAndStoreAllBlocks will busy-wait until node is fully current and all blocks have been stored.
-func waitForNodeToFullySyncAndStoreAllBlocks(node *cmd.Node) {
-	ticker := time.NewTicker(5 * time.Millisecond)
-	for {
-		<-ticker.C
+// syncEventFallbackInterval is how often the wait helpers re-check their condition when the nodes don't fire any
+// events. This covers conditions without an event source, such as txindex progress or the tip getting stale.
+const syncEventFallbackInterval = 1 * time.Second
 
-		if node.Server.GetBlockchain().IsFullyStored() {
-			if node.Server.GetBlockchain().Snapshot() != nil {
-				node.Server.GetBlockchain().Snapshot().WaitForAllOperationsToFinish()
-			}
+// nodeEventSubscription merges the events of one or more nodes into a single channel, so that the wait helpers can
+// block until something happens on the nodes, instead of busy polling. A node fires an event whenever it connects a
+// header or a block, its chain state changes, it makes hypersync progress, or a peer connects or disconnects, among
+// others, see cmd.Node.Subscribe.
+type nodeEventSubscription struct {
+	events       chan struct{}
+	done         chan struct{}
+	unsubscribes []func()
+}
+
+// subscribeToNodeEvents subscribes to the events of the provided nodes. The subscription must be released with
+// unsubscribe once the caller is done waiting.
+func subscribeToNodeEvents(nodes ...*cmd.Node) *nodeEventSubscription {
+	subscription := &nodeEventSubscription{
+		events: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	for _, node := range nodes {
+		events, unsubscribe := node.Subscribe()
+		subscription.unsubscribes = append(subscription.unsubscribes, unsubscribe)
+		go subscription.forward(events)
+	}
+	return subscription
+}
+
+// forward notifies the subscription about the events of a single node until the subscription is released.
+func (subscription *nodeEventSubscription) forward(events <-chan cmd.NodeEvent) {
+	for {
+		select {
+		case <-subscription.done:
 			return
+		case <-events:
+		}
+		// The events channel only needs to tell the waiter that something happened, so there is no need to queue
+		// more than one notification.
+		select {
+		case subscription.events <- struct{}{}:
+		default:
 		}
 	}
 }
 
-// waitForNodeToFullySyncTxIndex will busy-wait until node is fully current and txindex has finished syncing.
-func waitForNodeToFullySyncTxIndex(node *cmd.Node) {
-	ticker := time.NewTicker(5 * time.Millisecond)
-	for {
-		<-ticker.C
+// wait blocks until one of the nodes fires an event, or until syncEventFallbackInterval passes. It returns false if
+// the context is done first.
+func (subscription *nodeEventSubscription) wait(ctx context.Context) bool {
+	fallback := time.NewTimer(syncEventFallbackInterval)
+	defer fallback.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-subscription.events:
+	case <-fallback.C:
+	}
+	return true
+}
+
+// unsubscribe releases the subscription.
+func (subscription *nodeEventSubscription) unsubscribe() {
+	close(subscription.done)
+	for _, unsubscribe := range subscription.unsubscribes {
+		unsubscribe()
+	}
+}
+
+// defaultSyncTimeout is how long the wait helpers wait for a node to sync before failing the test.
+const defaultSyncTimeout = 15 * time.Minute
+
+// waitForNodeToFullySync waits until provided node is fully current, and fails the test if the node doesn't sync
+// within defaultSyncTimeout.
+func waitForNodeToFullySync(t testing.TB, node *cmd.Node) {
+	waitForNodeToFullySyncWithTimeout(t, node, defaultSyncTimeout)
+}
+
+// waitForNodeToFullySyncWithTimeout waits until provided node is fully current, and fails the test if the node
+// doesn't sync within the timeout.
+func waitForNodeToFullySyncWithTimeout(t testing.TB, node *cmd.Node, timeout time.Duration) {
+	defer recordSyncWait(node, "waitForNodeToFullySync", time.Now())
+	if err := waitForSyncCondition(node, timeout, func() bool {
+		return node.Status().ChainState == lib.SyncStateFullyCurrent
+	}); err != nil {
+		t.Fatalf("waitForNodeToFullySync: %v", err)
+	}
+}
 
-		if node.TXIndex.FinishedSyncing() && node.Server.GetBlockchain().ChainState() == lib.SyncStateFullyCurrent {
-			if node.Server.GetBlockchain().Snapshot() != nil {
-				node.Server.GetBlockchain().Snapshot().WaitForAllOperationsToFinish()
+// waitForNodeToFullySyncAndStoreAllBlocks waits until node is fully current and all blocks have been stored, and
+// fails the test if that doesn't happen within defaultSyncTimeout.
+func waitForNodeToFullySyncAndStoreAllBlocks(t testing.TB, node *cmd.Node) {
+	defer recordSyncWait(node, "waitForNodeToFullySyncAndStoreAllBlocks", time.Now())
+	if err := waitForSyncCondition(node, defaultSyncTimeout, func() bool {
+		return node.Server.GetBlockchain().IsFullyStored()
+	}); err != nil {
+		t.Fatalf("waitForNodeToFullySyncAndStoreAllBlocks: %v", err)
+	}
+}
+
+// waitForNodeToFullySyncTxIndex waits until node is fully current and txindex has finished syncing, and fails the
+// test if that doesn't happen within defaultSyncTimeout.
+func waitForNodeToFullySyncTxIndex(t testing.TB, node *cmd.Node) {
+	defer recordSyncWait(node, "waitForNodeToFullySyncTxIndex", time.Now())
+	if err := waitForSyncCondition(node, defaultSyncTimeout, func() bool {
+		status := node.Status()
+		return status.TXIndex.Synced && status.ChainState == lib.SyncStateFullyCurrent
+	}); err != nil {
+		t.Fatalf("waitForNodeToFullySyncTxIndex: %v", err)
+	}
+}
+
+// StrictSyncOption relaxes the checks of waitForNodeToFullySyncStrict.
+type StrictSyncOption func(options *strictSyncOptions)
+
+type strictSyncOptions struct {
+	maxReorgDepth uint32
+}
+
+// AllowReorgs is the escape hatch of waitForNodeToFullySyncStrict for tests that intentionally cause reorgs. It allows
+// blocks to be disconnected, as long as the block tip never rewinds more than maxDepth blocks below the highest tip the
+// node had.
+func AllowReorgs(maxDepth uint32) StrictSyncOption {
+	return func(options *strictSyncOptions) {
+		options.maxReorgDepth = maxDepth
+	}
+}
+
+// waitForNodeToFullySyncStrict waits until provided node is fully current, like waitForNodeToFullySync, but also fails
+// the test if any block is disconnected, or if the block tip height ever decreases, before the node is fully current.
+// Some bugs only make the node connect and disconnect the same blocks over and over, or rewind its tip, while the node
+// still ends up fully current, which waitForNodeToFullySync doesn't catch.
+func waitForNodeToFullySyncStrict(t testing.TB, node *cmd.Node, options ...StrictSyncOption) {
+	defer recordSyncWait(node, "waitForNodeToFullySyncStrict", time.Now())
+	monitor := newStrictSyncMonitor(node, options...)
+	defer monitor.stop()
+
+	var violation error
+	if err := waitForSyncCondition(node, defaultSyncTimeout, func() bool {
+		if violation = monitor.check(); violation != nil {
+			return true
+		}
+		return node.Status().ChainState == lib.SyncStateFullyCurrent
+	}); err != nil {
+		t.Fatalf("waitForNodeToFullySyncStrict: %v", err)
+	}
+	if violation != nil {
+		t.Fatalf("waitForNodeToFullySyncStrict: %v rewound during sync: %v; %v", nodeLogName(node), violation,
+			describeSyncState(node))
+	}
+}
+
+// strictSyncMonitor follows the block disconnects and the block tip height of a node, for
+// waitForNodeToFullySyncStrict.
+type strictSyncMonitor struct {
+	chain        *lib.Blockchain
+	options      strictSyncOptions
+	disconnects  <-chan *lib.BlockEvent
+	unsubscribe  func()
+	maxTipHeight uint32
+}
+
+func newStrictSyncMonitor(node *cmd.Node, options ...StrictSyncOption) *strictSyncMonitor {
+	monitor := &strictSyncMonitor{
+		chain: node.Server.GetBlockchain(),
+	}
+	for _, option := range options {
+		option(&monitor.options)
+	}
+	// Subscribe before reading the tip, so that no disconnect is missed in between.
+	monitor.disconnects, monitor.unsubscribe = monitor.chain.SubscribeBlockDisconnected()
+	monitor.maxTipHeight = monitor.chain.BlockTip().Height
+	return monitor
+}
+
+// check returns an error if a block was disconnected, or the block tip height decreased, by more than the allowed
+// reorg depth since the last check.
+func (monitor *strictSyncMonitor) check() error {
+	for drained := false; !drained; {
+		select {
+		case event := <-monitor.disconnects:
+			// A disconnected block at the highest tip height is a reorg of depth 1.
+			height := uint32(event.Block.Header.Height)
+			if height > monitor.maxTipHeight || monitor.maxTipHeight-height+1 > monitor.options.maxReorgDepth {
+				return fmt.Errorf("block at height (%v) was disconnected, with the highest block tip at height (%v)",
+					height, monitor.maxTipHeight)
 			}
-			return
+		default:
+			drained = true
 		}
 	}
+
+	tipHeight := monitor.chain.BlockTip().Height
+	if tipHeight > monitor.maxTipHeight {
+		monitor.maxTipHeight = tipHeight
+	}
+	if monitor.maxTipHeight-tipHeight > monitor.options.maxReorgDepth {
+		return fmt.Errorf("block tip height decreased from (%v) to (%v)", monitor.maxTipHeight, tipHeight)
+	}
+	return nil
 }
 
-// compareNodesByChecksum checks if the two provided nodes have identical checksums.
-func compareNodesByChecksum(t *testing.T, nodeA *cmd.Node, nodeB *cmd.Node) {
-	require := require.New(t)
-	checksumA, err := nodeA.Server.GetBlockchain().Snapshot().Checksum.ToBytes()
-	require.NoError(err)
-	checksumB, err := nodeB.Server.GetBlockchain().Snapshot().Checksum.ToBytes()
-	require.NoError(err)
+func (monitor *strictSyncMonitor) stop() {
+	monitor.unsubscribe()
+}
+
+// waitForSyncCondition re-checks the condition whenever the node fires an event, until the condition holds, and then
+// waits for the node's snapshot operations to finish. If the condition doesn't hold within the timeout, it returns an
+// error describing how far the node got.
+func waitForSyncCondition(node *cmd.Node, timeout time.Duration, condition func() bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	events := subscribeToNodeEvents(node)
+	defer events.unsubscribe()
+
+	for !condition() {
+		if !events.wait(ctx) {
+			return fmt.Errorf("node on port (%v) didn't sync within (%v): %v",
+				node.ListeningPort(), timeout, describeSyncState(node))
+		}
+	}
+	if node.Server.GetBlockchain().Snapshot() != nil {
+		node.Server.GetBlockchain().Snapshot().WaitForAllOperationsToFinish()
+	}
+	return nil
+}
+
+// describeSyncState summarizes the node's sync status, for reporting sync failures.
+func describeSyncState(node *cmd.Node) string {
+	status := node.Status()
+	return fmt.Sprintf("ChainState (%v), block tip height (%v), header tip height (%v), hypersync progress (%v)",
+		status.ChainState, status.BlockTipHeight, status.HeaderTipHeight, hyperSyncProgressString(node))
+}
+
+// hyperSyncProgressString summarizes how far the node got in downloading each snapshot prefix.
+func hyperSyncProgressString(node *cmd.Node) string {
+	var progress string
+	for _, prefix := range node.Status().HyperSync.Prefixes {
+		progress += fmt.Sprintf("%v:%v:%v ", prefix.Prefix, prefix.LastReceivedKey, prefix.Completed)
+	}
+	return progress
+}
+
+// hyperSyncPrefixStatus returns the node's hypersync progress on the provided prefix, and whether the node started
+// downloading the prefix.
+func hyperSyncPrefixStatus(node *cmd.Node, syncPrefix []byte) (cmd.HyperSyncPrefixStatus, bool) {
+	for _, prefix := range node.Status().HyperSync.Prefixes {
+		if prefix.Prefix == hex.EncodeToString(syncPrefix) {
+			return prefix, true
+		}
+	}
+	return cmd.HyperSyncPrefixStatus{}, false
+}
+
+// compareNodesByChecksum checks if the two provided nodes have identical checksums. If the compare options skip any
+// keys, the checksums are recomputed without the skipped keys with computeNodeStateChecksum, rather than taken from
+// the nodes' snapshots, which always cover the whole state.
+func compareNodesByChecksum(t testing.TB, nodeA *cmd.Node, nodeB *cmd.Node, opts ...lib.DiffOptions) {
+	compareOpts := compareOptions(t, opts)
+	if len(compareOpts.ExpectedDifferences) > 0 {
+		t.Fatalf("compareNodesByChecksum: Checksums can't verify expected differences, use compareNodesByDB instead")
+	}
+	var checksumA, checksumB []byte
+	if compareOpts.SkipsAnything() {
+		heightA := nodeA.Server.GetBlockchain().BlockTip().Height
+		heightB := nodeB.Server.GetBlockchain().BlockTip().Height
+		if heightA != heightB {
+			t.Fatalf("compareNodesByChecksum: Nodes are at different heights (%v) and (%v)", heightA, heightB)
+		}
+		checksumA = computeNodeStateChecksum(t, nodeA, uint64(heightA), compareOpts)
+		checksumB = computeNodeStateChecksum(t, nodeB, uint64(heightB), compareOpts)
+	} else {
+		checksumA = waitForChecksumStabilization(t, nodeA)
+		checksumB = waitForChecksumStabilization(t, nodeB)
+	}
 
 	if !reflect.DeepEqual(checksumA, checksumB) {
-		t.Fatalf("compareNodesByChecksum: error checksums not equal checksumA (%v), "+
-			"checksumB (%v)", checksumA, checksumB)
+		// Localize the mismatch with per-prefix checksums, which can only be compared if the nodes are at the same
+		// height, since the checksums of some entries depend on the height.
+		heightA := nodeA.Server.GetBlockchain().BlockTip().Height
+		heightB := nodeB.Server.GetBlockchain().BlockTip().Height
+		if heightA != heightB {
+			t.Fatalf("compareNodesByChecksum: error checksums not equal checksumA (%v), checksumB (%v), and nodes are "+
+				"at different heights (%v) and (%v)", checksumA, checksumB, heightA, heightB)
+		}
+		prefixNames := mismatchedChecksumPrefixes(t, nodeA, nodeB, uint64(heightA), compareOpts)
+		t.Fatalf("compareNodesByChecksum: error checksums not equal checksumA (%v), checksumB (%v), mismatch in "+
+			"prefix %v", checksumA, checksumB, strings.Join(prefixNames, ", "))
 	}
 	fmt.Printf("Identical checksums: nodeA (%v)\n nodeB (%v)\n", checksumA, checksumB)
 }
 
+// checksumStabilizationTimeout is how long waitForChecksumStabilization waits for the checksum to stop changing.
+const checksumStabilizationTimeout = 1 * time.Minute
+
+// waitForChecksumStabilization waits until the node's state checksum stops changing, and returns it. Ancestral record
+// flushes can still be in flight after the node becomes fully current, and they move the checksum, so the checksum is
+// re-read after waiting for the outstanding snapshot operations, until two consecutive reads are equal. Fails the test
+// if the checksum doesn't stabilize within checksumStabilizationTimeout.
+func waitForChecksumStabilization(t testing.TB, node *cmd.Node) []byte {
+	require := require.New(t)
+	snapshot := getNodeSnapshot(t, node, "waitForChecksumStabilization")
+
+	deadline := time.Now().Add(checksumStabilizationTimeout)
+	checksum, err := snapshot.Checksum.ToBytes()
+	require.NoError(err)
+	for {
+		snapshot.WaitForAllOperationsToFinish()
+		nextChecksum, err := snapshot.Checksum.ToBytes()
+		require.NoError(err)
+		if reflect.DeepEqual(checksum, nextChecksum) {
+			return checksum
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("waitForChecksumStabilization: checksum of node on port (%v) didn't stabilize within (%v), "+
+				"last reads (%v) and (%v)", node.ListeningPort(), checksumStabilizationTimeout, checksum, nextChecksum)
+		}
+		checksum = nextChecksum
+	}
+}
+
+// verifyNodeChecksumSelfConsistent checks if the state checksum maintained by the node's snapshot equals the checksum
+// recomputed from scratch over the node's own state, which catches checksum bugs that compareNodesByChecksum misses
+// because they corrupt the checksums of both nodes identically. The maintained checksum encodes the records at the
+// snapshot's current block height, which is the last snapshot epoch height rather than the tip, e.g. right after the
+// node hypersynced, so the checksum is recomputed at the snapshot's height when it differs from blockHeight.
+func verifyNodeChecksumSelfConsistent(t testing.TB, node *cmd.Node, blockHeight uint64) {
+	snapshot := getNodeSnapshot(t, node, "verifyNodeChecksumSelfConsistent")
+	liveChecksum := waitForChecksumStabilization(t, node)
+
+	checksumHeight := snapshot.Status.CurrentBlockHeight
+	if checksumHeight != blockHeight {
+		fmt.Printf("verifyNodeChecksumSelfConsistent: Checksum of %v reflects snapshot height (%v) instead of (%v)\n",
+			nodeLogName(node), checksumHeight, blockHeight)
+	}
+	recomputedChecksum := computeNodeStateChecksum(t, node, checksumHeight)
+	if !reflect.DeepEqual(liveChecksum, recomputedChecksum) {
+		t.Fatalf("verifyNodeChecksumSelfConsistent: Maintained checksum of %v (%v) doesn't match the checksum "+
+			"recomputed from its state (%v) at height (%v)", nodeLogName(node), liveChecksum, recomputedChecksum,
+			checksumHeight)
+	}
+	fmt.Printf("verifyNodeChecksumSelfConsistent: Checksum of %v is self-consistent at height (%v)\n",
+		nodeLogName(node), checksumHeight)
+}
+
+// compareOptions returns the options passed to a compare helper, which accept at most one lib.DiffOptions, e.g. to
+// skip prefixes or keys that are expected to differ between the nodes.
+func compareOptions(t testing.TB, opts []lib.DiffOptions) lib.DiffOptions {
+	if len(opts) > 1 {
+		t.Fatalf("compareOptions: Expected at most one lib.DiffOptions, got (%v)", len(opts))
+	}
+	if len(opts) == 0 {
+		return lib.DiffOptions{}
+	}
+	return opts[0]
+}
+
 // compareNodesByState will look through all state records in nodeA and nodeB databases and will compare them.
-// The nodes pass this comparison iff they have identical states.
-func compareNodesByState(t *testing.T, nodeA *cmd.Node, nodeB *cmd.Node, verbose int) {
-	compareNodesByStateWithPrefixList(t, nodeA.ChainDB, nodeB.ChainDB, lib.StatePrefixes.StatePrefixesList, verbose)
+// The nodes pass this comparison iff they have identical states, except for the keys skipped by the options.
+func compareNodesByState(t testing.TB, nodeA *cmd.Node, nodeB *cmd.Node, verbosity Verbosity, opts ...lib.DiffOptions) {
+	compareNodesByStateWithPrefixList(t, nodeA.ChainDB, nodeB.ChainDB, lib.StatePrefixes.StatePrefixesList,
+		verbosity, opts...)
+}
+
+// compareAllNodesByState compares the state of every node against the first node, which serves as the reference, in
+// the same way as compareNodesByState. Every node is compared, and reported as matching or not, before the test fails
+// with a summary of the nodes that diverged from the reference and their broken prefixes. At least two nodes are
+// needed for a comparison.
+func compareAllNodesByState(t testing.TB, nodes []*cmd.Node, opts ...lib.DiffOptions) {
+	if len(nodes) < 2 {
+		t.Fatalf("compareAllNodesByState: Need at least two nodes to compare, got (%v)", len(nodes))
+	}
+	compareOpts := compareOptions(t, opts)
+	reference := nodes[0]
+
+	var summary []string
+	for _, node := range nodes[1:] {
+		diff, err := lib.DiffNodeStates(reference.ChainDB, node.ChainDB, lib.StatePrefixes.StatePrefixesList,
+			compareOpts)
+		if err != nil {
+			t.Fatalf("compareAllNodesByState: Problem comparing %v with %v (%v)", nodeLogName(node),
+				nodeLogName(reference), err)
+		}
+		if diff.IsEmpty() {
+			fmt.Printf("compareAllNodesByState: %v matches %v\n", nodeLogName(node), nodeLogName(reference))
+			continue
+		}
+
+		fmt.Printf("compareAllNodesByState: %v differs from %v\n", nodeLogName(node), nodeLogName(reference))
+		for _, line := range describeStateDiff(truncateStateDiff(diff, logKeysPerPrefix(compareOpts, Summary))) {
+			glog.Errorf("compareAllNodesByState: %v differs from %v on %v", nodeLogName(node),
+				nodeLogName(reference), line)
+		}
+		var prefixNames []string
+		for _, prefix := range diff.BrokenPrefixes() {
+			prefixNames = append(prefixNames, lib.StatePrefixName(prefix))
+		}
+		prefixNames = append(prefixNames, diff.MissingExpectedDifferences...)
+		summary = append(summary, fmt.Sprintf("%v: %v, full diff saved to (%v)", nodeLogName(node),
+			strings.Join(prefixNames, ", "), writeStateDiffArtifact(t, diff)))
+	}
+	if len(summary) > 0 {
+		t.Fatalf("compareAllNodesByState: (%v) of (%v) nodes differ from %v, broken prefixes by node:\n%v",
+			len(summary), len(nodes)-1, nodeLogName(reference), strings.Join(summary, "\n"))
+	}
 }
 
 // compareNodesByDB will look through all records in nodeA and nodeB databases and will compare them.
-// The nodes pass this comparison iff they have identical states.
-func compareNodesByDB(t *testing.T, nodeA *cmd.Node, nodeB *cmd.Node, verbose int) {
+// The nodes pass this comparison iff they have identical states, except for the keys skipped by the options.
+func compareNodesByDB(t testing.TB, nodeA *cmd.Node, nodeB *cmd.Node, verbosity Verbosity, opts ...lib.DiffOptions) {
+	compareNodesByStateWithPrefixList(t, nodeA.ChainDB, nodeB.ChainDB, comparableStatePrefixes(), verbosity, opts...)
+}
+
+// comparableStatePrefixes returns the state prefixes that should be identical on nodes with the same state.
+func comparableStatePrefixes() [][]byte {
 	var prefixList [][]byte
 	for prefix := range lib.StatePrefixes.StatePrefixesMap {
 		// We skip utxooperations because we actually can't sync them in hypersync.
@@ -164,153 +611,418 @@ func compareNodesByDB(t *testing.T, nodeA *cmd.Node, nodeB *cmd.Node, verbose in
 		}
 		prefixList = append(prefixList, []byte{prefix})
 	}
-	compareNodesByStateWithPrefixList(t, nodeA.ChainDB, nodeB.ChainDB, prefixList, verbose)
+	return prefixList
 }
 
 // compareNodesByDB will look through all records in nodeA and nodeB txindex databases and will compare them.
 // The nodes pass this comparison iff they have identical states.
-func compareNodesByTxIndex(t *testing.T, nodeA *cmd.Node, nodeB *cmd.Node, verbose int) {
-	var prefixList [][]byte
-	for prefix := range lib.StatePrefixes.StatePrefixesMap {
-		// We skip utxooperations because we actually can't sync them in hypersync.
-		if reflect.DeepEqual([]byte{prefix}, lib.Prefixes.PrefixBlockHashToUtxoOperations) {
+func compareNodesByTxIndex(t testing.TB, nodeA *cmd.Node, nodeB *cmd.Node, verbosity Verbosity,
+	opts ...lib.DiffOptions) {
+
+	compareNodesByStateWithPrefixList(t, nodeA.TXIndex.TXIndexChain.DB(), nodeB.TXIndex.TXIndexChain.DB(),
+		comparableStatePrefixes(), verbosity, opts...)
+}
+
+// blockIndexDivergenceWindow is how many blocks of both best chains are reported before and after the first height at
+// which the chains diverge.
+const blockIndexDivergenceWindow = 3
+
+// compareNodesByBlockIndex checks if the two provided nodes have the same best chain from genesis up to maxHeight, with
+// the same hashes, heights, statuses, and cumulative work at every height. Zero maxHeight compares the whole best
+// chains. Unlike the state comparisons, this catches nodes that reach the same final state through different blocks,
+// and it's much faster. On failure, the blocks of both chains around the first divergence are reported.
+func compareNodesByBlockIndex(t testing.TB, nodeA *cmd.Node, nodeB *cmd.Node, maxHeight uint32) {
+	chainA := getBestChainSnapshot(nodeA)
+	chainB := getBestChainSnapshot(nodeB)
+
+	numHeights := len(chainA)
+	if len(chainB) > numHeights {
+		numHeights = len(chainB)
+	}
+	if maxHeight != 0 && int(maxHeight)+1 < numHeights {
+		numHeights = int(maxHeight) + 1
+	}
+	for height := 0; height < numHeights; height++ {
+		if height < len(chainA) && height < len(chainB) && blockNodesEqual(chainA[height], chainB[height]) {
 			continue
 		}
-		prefixList = append(prefixList, []byte{prefix})
+		var lines []string
+		for ii := height - blockIndexDivergenceWindow; ii <= height+blockIndexDivergenceWindow; ii++ {
+			if ii < 0 {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("height (%v): A %v, B %v", ii, describeBlockNodeAt(chainA, ii),
+				describeBlockNodeAt(chainB, ii)))
+		}
+		t.Fatalf("compareNodesByBlockIndex: Best chains of %v and %v diverge at height (%v):\n%v",
+			nodeLogName(nodeA), nodeLogName(nodeB), height, strings.Join(lines, "\n"))
 	}
-	compareNodesByStateWithPrefixList(t, nodeA.TXIndex.TXIndexChain.DB(), nodeB.TXIndex.TXIndexChain.DB(), prefixList, verbose)
+	fmt.Printf("compareNodesByBlockIndex: Best chains match up to height (%v)\n", numHeights-1)
 }
 
-// compareNodesByDB will look through all records in provided prefixList in nodeA and nodeB databases and will compare them.
-// The nodes pass this comparison iff they have identical states.
-func compareNodesByStateWithPrefixList(t *testing.T, dbA *badger.DB, dbB *badger.DB, prefixList [][]byte, verbose int) {
-	maxBytes := lib.SnapshotBatchSize
-	var brokenPrefixes [][]byte
-	var broken bool
-	sort.Slice(prefixList, func(ii, jj int) bool {
-		return prefixList[ii][0] < prefixList[jj][0]
-	})
-	for _, prefix := range prefixList {
-		lastPrefix := prefix
-		invalidLengths := false
-		invalidKeys := false
-		invalidValues := false
-		invalidFull := false
-		existingEntriesDb0 := make(map[string][]byte)
-		for {
-			// Fetch a state chunk from nodeA database.
-			dbEntriesA, isChunkFullA, err := lib.DBIteratePrefixKeys(dbA, prefix, lastPrefix, maxBytes)
-			if err != nil {
-				t.Fatal(errors.Wrapf(err, "problem reading nodeA database for prefix (%v) last prefix (%v)",
-					prefix, lastPrefix))
-			}
-			for _, entry := range dbEntriesA {
-				existingEntriesDb0[hex.EncodeToString(entry.Key)] = entry.Value
-			}
+// getBestChainSnapshot copies the node's best chain under the chain lock, so that it can be walked while the node
+// keeps processing blocks.
+func getBestChainSnapshot(node *cmd.Node) []*lib.BlockNode {
+	chain := node.Server.GetBlockchain()
+	chain.ChainLock.RLock()
+	defer chain.ChainLock.RUnlock()
 
-			// Fetch a state chunk from nodeB database.
-			dbEntriesB, isChunkFullB, err := lib.DBIteratePrefixKeys(dbB, prefix, lastPrefix, maxBytes)
-			if err != nil {
-				t.Fatal(errors.Wrapf(err, "problem reading nodeB database for prefix (%v) last prefix (%v",
-					prefix, lastPrefix))
-			}
-			for _, entry := range dbEntriesB {
-				key := hex.EncodeToString(entry.Key)
-				if _, exists := existingEntriesDb0[key]; exists {
-					if !reflect.DeepEqual(entry.Value, existingEntriesDb0[key]) {
-						if !invalidValues || verbose >= 1 {
-							glog.Errorf("Databases not equal on prefix: %v, the key is (%v); "+
-								"unequal values (db0, db1) : (%v, %v)\n", prefix, entry.Key,
-								entry.Value, existingEntriesDb0[key])
-							invalidValues = true
-						}
-					}
-					delete(existingEntriesDb0, key)
-				} else {
-					glog.Errorf("Databases not equal on prefix: %v, and key: %v; the entry in database B "+
-						"was not found in the existingEntriesMap, and has value: %v\n", prefix, key, entry.Value)
-				}
-			}
+	return append([]*lib.BlockNode{}, chain.BestChain()...)
+}
 
-			// Make sure we've fetched the same number of entries for nodeA and nodeB.
-			if len(dbEntriesA) != len(dbEntriesB) {
-				invalidLengths = true
-				glog.Errorf("Databases not equal on prefix: %v, and lastPrefix: %v;"+
-					"varying lengths (nodeA, nodeB) : (%v, %v)\n", prefix, lastPrefix, len(dbEntriesA), len(dbEntriesB))
-			}
+func blockNodesEqual(nodeA *lib.BlockNode, nodeB *lib.BlockNode) bool {
+	return nodeA.Hash.IsEqual(nodeB.Hash) && nodeA.Height == nodeB.Height && nodeA.Status == nodeB.Status &&
+		nodeA.CumWork.Cmp(nodeB.CumWork) == 0
+}
 
-			// It doesn't matter which map we iterate through, since if we got here it means they have
-			// an identical number of unique keys. So we will choose dbEntriesA for convenience.
-			for ii, entry := range dbEntriesA {
-				if ii >= len(dbEntriesB) {
-					break
-				}
-				if !reflect.DeepEqual(entry.Key, dbEntriesB[ii].Key) {
-					if !invalidKeys || verbose >= 1 {
-						glog.Errorf("Databases not equal on prefix: %v, and lastPrefix: %v; unequal keys "+
-							"(nodeA, nodeB) : (%v, %v)\n", prefix, lastPrefix, entry.Key, dbEntriesB[ii].Key)
-						invalidKeys = true
-					}
-				}
-			}
-			//for ii, entry := range dbEntriesA {
-			//	if ii >= len(dbEntriesB) {
-			//		break
-			//	}
-			//	if !reflect.DeepEqual(entry.Value, dbEntriesB[ii].Value) {
-			//		if !invalidValues || verbose >= 1 {
-			//			glog.Errorf("Databases not equal on prefix: %v, and key: %v; the key is (%v); "+
-			//				"unequal values len (db0, db1) : (%v, %v)\n", prefix, entry.Key, entry.Key,
-			//				len(entry.Value), len(dbEntriesB[ii].Value))
-			//			glog.Errorf("Databases not equal on prefix: %v, and lastPrefix: %v; unequal values "+
-			//				"(nodeA, nodeB) : (%v, %v)\n", prefix, lastPrefix, entry.Value, dbEntriesB[ii].Value)
-			//			invalidValues = true
-			//		}
-			//	}
-			//}
-
-			// Make sure the isChunkFull match for both chunks.
-			if isChunkFullA != isChunkFullB {
-				if !invalidFull || verbose >= 1 {
-					glog.Errorf("Databases not equal on prefix: %v, and lastPrefix: %v;"+
-						"unequal fulls (nodeA, nodeB) : (%v, %v)\n", prefix, lastPrefix, isChunkFullA, isChunkFullB)
-					invalidFull = true
-				}
-			}
+// describeBlockNodeAt describes the block node at height in the chain, or reports that the chain is too short.
+func describeBlockNodeAt(chain []*lib.BlockNode, height int) string {
+	if height >= len(chain) {
+		return "<none>"
+	}
+	blockNode := chain[height]
+	return fmt.Sprintf("< Hash: %v, Height: %v, Status: %v, CumWork: %v >", blockNode.Hash, blockNode.Height,
+		blockNode.Status, blockNode.CumWork)
+}
 
-			if len(dbEntriesA) > 0 {
-				lastPrefix = dbEntriesA[len(dbEntriesA)-1].Key
-			} else {
-				break
+// compareNodesByUtxoOperations checks if the two provided nodes stored the same utxo operations for the blocks of their
+// best chains from startHeight to endHeight inclusive, comparing the decoded operations transaction by transaction.
+// Zero endHeight compares up to the lower of the nodes' block tips. compareNodesByDB skips the utxo operations, because
+// hypersync can't transfer them, so this should only be used for nodes that both synced the range with blocks, e.g. to
+// check the blocks that a node processed after an interruption.
+func compareNodesByUtxoOperations(t testing.TB, nodeA *cmd.Node, nodeB *cmd.Node, startHeight uint32,
+	endHeight uint32) {
+
+	chainA := getBestChainSnapshot(nodeA)
+	chainB := getBestChainSnapshot(nodeB)
+	if endHeight == 0 {
+		endHeight = uint32(len(chainA)) - 1
+		if uint32(len(chainB))-1 < endHeight {
+			endHeight = uint32(len(chainB)) - 1
+		}
+	}
+	if int(endHeight) >= len(chainA) || int(endHeight) >= len(chainB) {
+		t.Fatalf("compareNodesByUtxoOperations: End height (%v) is above the block tip of %v (%v) or %v (%v)",
+			endHeight, nodeLogName(nodeA), len(chainA)-1, nodeLogName(nodeB), len(chainB)-1)
+	}
+
+	var lines []string
+	for height := startHeight; height <= endHeight; height++ {
+		blockHash := chainA[height].Hash
+		if !blockHash.IsEqual(chainB[height].Hash) {
+			t.Fatalf("compareNodesByUtxoOperations: Nodes have different blocks at height (%v), A (%v), B (%v), "+
+				"compare them with compareNodesByBlockIndex", height, blockHash, chainB[height].Hash)
+		}
+		utxoOpsA := getUtxoOperationsForBlock(t, nodeA, blockHash)
+		utxoOpsB := getUtxoOperationsForBlock(t, nodeB, blockHash)
+		lines = append(lines, diffUtxoOperations(uint64(height), utxoOpsA, utxoOpsB)...)
+	}
+	if len(lines) == 0 {
+		fmt.Printf("compareNodesByUtxoOperations: Utxo operations match from height (%v) to (%v)\n",
+			startHeight, endHeight)
+		return
+	}
+	for _, line := range lines {
+		glog.Errorf("compareNodesByUtxoOperations: %v", line)
+	}
+	if len(lines) > stateDiffFailureLines {
+		lines = append(lines[:stateDiffFailureLines], fmt.Sprintf("... (%v more)", len(lines)-stateDiffFailureLines))
+	}
+	t.Fatalf("compareNodesByUtxoOperations: Utxo operations of %v and %v differ:\n%v", nodeLogName(nodeA),
+		nodeLogName(nodeB), strings.Join(lines, "\n"))
+}
+
+// getUtxoOperationsForBlock returns the utxo operations that the node stored for the block, or nil if it didn't store
+// any, e.g. for the genesis block.
+func getUtxoOperationsForBlock(t testing.TB, node *cmd.Node, blockHash *lib.BlockHash) [][]*lib.UtxoOperation {
+	utxoOps, err := lib.GetUtxoOperationsForBlock(node.ChainDB, nil, blockHash)
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("getUtxoOperationsForBlock: Problem reading utxo operations of block (%v) from %v (%v)",
+			blockHash, nodeLogName(node), err)
+	}
+	return utxoOps
+}
+
+// diffUtxoOperations compares the utxo operations of a block in node A and node B, and describes every transaction with
+// a different number of operations, and every differing operation, on a line.
+func diffUtxoOperations(height uint64, utxoOpsA [][]*lib.UtxoOperation, utxoOpsB [][]*lib.UtxoOperation) []string {
+	var lines []string
+	if len(utxoOpsA) != len(utxoOpsB) {
+		lines = append(lines, fmt.Sprintf("height (%v): A has operations for (%v) txns, B for (%v) txns", height,
+			len(utxoOpsA), len(utxoOpsB)))
+	}
+	for txnIndex := 0; txnIndex < len(utxoOpsA) && txnIndex < len(utxoOpsB); txnIndex++ {
+		txnOpsA, txnOpsB := utxoOpsA[txnIndex], utxoOpsB[txnIndex]
+		if len(txnOpsA) != len(txnOpsB) {
+			lines = append(lines, fmt.Sprintf("height (%v), txn (%v): A has (%v) operations, B has (%v) operations",
+				height, txnIndex, len(txnOpsA), len(txnOpsB)))
+		}
+		for opIndex := 0; opIndex < len(txnOpsA) && opIndex < len(txnOpsB); opIndex++ {
+			opA, opB := txnOpsA[opIndex], txnOpsB[opIndex]
+			if bytes.Equal(lib.EncodeToBytes(height, opA), lib.EncodeToBytes(height, opB)) {
+				continue
 			}
+			lines = append(lines, fmt.Sprintf("height (%v), txn (%v), operation (%v): A %v, B %v", height,
+				txnIndex, opIndex, lib.DescribeEncoder(opA), lib.DescribeEncoder(opB)))
+		}
+	}
+	return lines
+}
 
-			if !isChunkFullA {
-				break
+// compareNodesByMempool checks if the two provided nodes have the same transactions in their mempools, and fails the
+// test with the missing, extra, and differing transactions otherwise.
+func compareNodesByMempool(t testing.TB, nodeA *cmd.Node, nodeB *cmd.Node) {
+	compareNodesByMempoolWithTolerance(t, nodeA, nodeB, 0)
+}
+
+// compareNodesByMempoolWithTolerance is compareNodesByMempool, but gives the nodes up to tolerance to converge on the
+// same mempool, e.g. while transactions are still being relayed, before failing the test.
+func compareNodesByMempoolWithTolerance(t testing.TB, nodeA *cmd.Node, nodeB *cmd.Node, tolerance time.Duration) {
+	deadline := time.Now().Add(tolerance)
+	for {
+		lines := diffMempools(nodeA.Server.GetMempoolSnapshot(), nodeB.Server.GetMempoolSnapshot())
+		if len(lines) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			if len(lines) > stateDiffFailureLines {
+				lines = append(lines[:stateDiffFailureLines], fmt.Sprintf("... (%v more)",
+					len(lines)-stateDiffFailureLines))
 			}
+			t.Fatalf("compareNodesByMempool: Mempools of %v and %v differ after (%v):\n%v", nodeLogName(nodeA),
+				nodeLogName(nodeB), tolerance, strings.Join(lines, "\n"))
 		}
-		status := "PASS"
-		if invalidLengths || invalidKeys || invalidValues || invalidFull {
-			status = "FAIL"
-			brokenPrefixes = append(brokenPrefixes, prefix)
-			broken = true
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// diffMempools compares the mempool transactions of node A and node B as sets, and describes every transaction that
+// is missing in either mempool, or that has the same hash but different bytes, on a line.
+func diffMempools(txnsA []*lib.MempoolTx, txnsB []*lib.MempoolTx) []string {
+	txnsByHashB := make(map[lib.BlockHash]*lib.MempoolTx)
+	for _, txnB := range txnsB {
+		txnsByHashB[*txnB.Hash] = txnB
+	}
+
+	var lines []string
+	for _, txnA := range txnsA {
+		txnB, exists := txnsByHashB[*txnA.Hash]
+		if !exists {
+			lines = append(lines, fmt.Sprintf("missing in B: %v", describeMempoolTxn(txnA)))
+			continue
 		}
-		glog.Infof("The number of entries in existsMap for prefix (%v) is (%v)\n", prefix, len(existingEntriesDb0))
-		for key, entry := range existingEntriesDb0 {
-			glog.Infof("ExistingMape entry: (key, len(value) : (%v, %v)\n", key, len(entry))
+		delete(txnsByHashB, *txnA.Hash)
+		bytesA, errA := txnA.Tx.ToBytes(false)
+		bytesB, errB := txnB.Tx.ToBytes(false)
+		if errA != nil || errB != nil || !bytes.Equal(bytesA, bytesB) {
+			lines = append(lines, fmt.Sprintf("differing: A %v, B %v", describeMempoolTxn(txnA),
+				describeMempoolTxn(txnB)))
 		}
-		glog.Infof("Status for prefix (%v): (%s)\n invalidLengths: (%v); invalidKeys: (%v); invalidValues: "+
-			"(%v); invalidFull: (%v)\n\n", prefix, status, invalidLengths, invalidKeys, invalidValues, invalidFull)
 	}
-	if broken {
-		t.Fatalf("Databases differ! Broken prefixes: %v", brokenPrefixes)
+	// Report the transactions that are only in B in the order they were added to B.
+	for _, txnB := range txnsB {
+		if _, exists := txnsByHashB[*txnB.Hash]; exists {
+			lines = append(lines, fmt.Sprintf("missing in A: %v", describeMempoolTxn(txnB)))
+		}
 	}
+	return lines
 }
 
-// computeNodeStateChecksum goes through node's state records and computes the checksum.
-func computeNodeStateChecksum(t *testing.T, node *cmd.Node, blockHeight uint64) []byte {
+// describeMempoolTxn summarizes a mempool transaction, for reporting mempool differences.
+func describeMempoolTxn(txn *lib.MempoolTx) string {
+	return fmt.Sprintf("txn (%v) of type (%v) from (%v), fee (%v), size (%v), added at height (%v)", txn.Hash,
+		txn.Tx.TxnMeta.GetTxnType(), lib.PkToStringBoth(txn.Tx.PublicKey), txn.Fee, txn.TxSizeBytes, txn.Height)
+}
+
+// compareNodesByDB will look through all records in provided prefixList in nodeA and nodeB databases and will compare them.
+// The nodes pass this comparison iff they have identical states.
+func compareNodesByStateWithPrefixList(t testing.TB, dbA *badger.DB, dbB *badger.DB, prefixList [][]byte,
+	verbosity Verbosity, opts ...lib.DiffOptions) {
+
+	compareStateWithPrefixList(t, lib.NewDBStateChunkReader(dbA), lib.NewDBStateChunkReader(dbB), prefixList,
+		verbosity, compareOptions(t, opts))
+}
+
+// compareStateWithPrefixList compares the state read by readA and readB under the prefixes in prefixList with
+// lib.DiffStates, logs the differences, and fails the test if there are any. The progress and the summary of the
+// comparison are printed according to the verbosity. The differences are reported by reportStateDiff.
+func compareStateWithPrefixList(t testing.TB, readA lib.StateChunkReader, readB lib.StateChunkReader,
+	prefixList [][]byte, verbosity Verbosity, opts lib.DiffOptions) {
+
+	progress := newCompareProgress(verbosity)
+	progressFunc := opts.ProgressFunc
+	opts.ProgressFunc = func(diffProgress lib.DiffProgress) {
+		progress.report(diffProgress)
+		if progressFunc != nil {
+			progressFunc(diffProgress)
+		}
+	}
+	diff, err := lib.DiffStates(readA, readB, prefixList, opts)
+	if err != nil {
+		t.Fatalf("compareStateWithPrefixList: Problem comparing states: %v", err)
+	}
+	if diff.IsEmpty() {
+		progress.summarize()
+		return
+	}
+
+	logLines, failure := reportStateDiff(t, diff, verbosity, opts)
+	for _, line := range logLines {
+		glog.Errorf("Databases not equal on %v", line)
+	}
+	t.Fatalf("%v", failure)
+}
+
+// reportStateDiff returns the lines to log for a non-empty diff, and the failure message. Only the first
+// lib.DiffOptions.LogKeysPerPrefix differing keys of every prefix are logged, stateDiffLogKeysPerPrefix by default, or
+// every differing key with PerKey verbosity, and the remaining differences are summarized by a line per prefix, so that
+// a badly diverged prefix doesn't flood the logs. The whole diff is saved as a JSON artifact with
+// writeStateDiffArtifact, whose path is included in the failure message.
+func reportStateDiff(t testing.TB, diff *lib.StateDiff, verbosity Verbosity, opts lib.DiffOptions) (
+	_logLines []string, _failure string) {
+
+	logLines := describeStateDiff(truncateStateDiff(diff, logKeysPerPrefix(opts, verbosity)))
+	failureLines := logLines
+	if len(failureLines) > stateDiffFailureLines {
+		failureLines = append(append([]string{}, failureLines[:stateDiffFailureLines]...),
+			fmt.Sprintf("... (%v more)", len(failureLines)-stateDiffFailureLines))
+	}
+	failure := fmt.Sprintf("Databases differ! Broken prefixes: %v, missing expected differences: %v, full diff "+
+		"saved to (%v)\n%v", diff.BrokenPrefixes(), diff.MissingExpectedDifferences, writeStateDiffArtifact(t, diff),
+		strings.Join(failureLines, "\n"))
+	return logLines, failure
+}
+
+// stateDiffFailureLines is the number of logged differences that compareStateWithPrefixList also includes in the
+// failure message.
+const stateDiffFailureLines = 10
+
+// stateDiffLogKeysPerPrefix is the number of differing keys of every prefix that the compare helpers log by default.
+const stateDiffLogKeysPerPrefix = 20
+
+// logKeysPerPrefix returns the number of differing keys of every prefix that the compare helpers log, or a negative
+// number to log every key.
+func logKeysPerPrefix(opts lib.DiffOptions, verbosity Verbosity) int {
+	if verbosity >= PerKey {
+		return -1
+	}
+	if opts.LogKeysPerPrefix == 0 {
+		return stateDiffLogKeysPerPrefix
+	}
+	return opts.LogKeysPerPrefix
+}
+
+// describeStateDiff describes every difference listed in the diff on a line, with the keys and values decoded by
+// lib.DescribeStateKey and lib.DescribeStateValue, e.g. the public key and the balances of a differing balance entry.
+func describeStateDiff(diff *lib.StateDiff) []string {
+	return lib.NewStateDiffReport(diff).Lines()
+}
+
+// truncateStateDiff returns a copy of the diff that lists at most maxKeys keys of every prefix, the keys missing in A
+// first, then the keys missing in B, the unequal values, and the byte-level-only differences. The counts are kept, so
+// the omitted keys are still reported. A negative maxKeys returns the diff as is.
+func truncateStateDiff(diff *lib.StateDiff, maxKeys int) *lib.StateDiff {
+	if maxKeys < 0 {
+		return diff
+	}
+	truncated := &lib.StateDiff{MissingExpectedDifferences: diff.MissingExpectedDifferences}
+	for _, prefixDiff := range diff.Prefixes {
+		truncatedPrefixDiff := *prefixDiff
+		remaining := maxKeys
+		truncatedPrefixDiff.MissingInA = prefixDiff.MissingInA[:lib.MinInt(remaining, len(prefixDiff.MissingInA))]
+		remaining -= len(truncatedPrefixDiff.MissingInA)
+		truncatedPrefixDiff.MissingInB = prefixDiff.MissingInB[:lib.MinInt(remaining, len(prefixDiff.MissingInB))]
+		remaining -= len(truncatedPrefixDiff.MissingInB)
+		truncatedPrefixDiff.ValueMismatch = prefixDiff.ValueMismatch[:lib.MinInt(remaining,
+			len(prefixDiff.ValueMismatch))]
+		remaining -= len(truncatedPrefixDiff.ValueMismatch)
+		truncatedPrefixDiff.ByteLevelOnly = prefixDiff.ByteLevelOnly[:lib.MinInt(remaining,
+			len(prefixDiff.ByteLevelOnly))]
+		truncated.Prefixes = append(truncated.Prefixes, &truncatedPrefixDiff)
+	}
+	return truncated
+}
+
+// computeNodeStateChecksum goes through node's state records and computes the checksum. Keys skipped by the options
+// aren't included in the checksum.
+func computeNodeStateChecksum(t testing.TB, node *cmd.Node, blockHeight uint64, opts ...lib.DiffOptions) []byte {
+	return computePrefixesChecksum(t, node, sortedStatePrefixes(), blockHeight, opts...)
+}
+
+// computeNodeStateChecksums is computeNodeStateChecksum, but also computes a separate checksum of every state prefix,
+// keyed by the prefix byte, which localizes a checksum mismatch to the prefixes that differ. The full checksum is the
+// combination of the prefix checksums, so the state is only walked once.
+func computeNodeStateChecksums(t testing.TB, node *cmd.Node, blockHeight uint64, opts ...lib.DiffOptions) (
+	_checksum []byte, _prefixChecksums map[byte][]byte) {
+
+	prefixChecksums := make(map[byte][]byte)
+	var checksums [][]byte
+	for _, prefix := range sortedStatePrefixes() {
+		prefixChecksums[prefix[0]] = computePrefixChecksum(t, node, prefix, blockHeight, opts...)
+		checksums = append(checksums, prefixChecksums[prefix[0]])
+	}
+	return combineStateChecksums(t, checksums...), prefixChecksums
+}
+
+// computePrefixChecksum computes the checksum of the node's state records under a single prefix, in the same way as
+// computeNodeStateChecksum does for the whole state. It's much faster than the full checksum when debugging a single
+// prefix. Keys skipped by the options aren't included in the checksum.
+func computePrefixChecksum(t testing.TB, node *cmd.Node, prefix []byte, blockHeight uint64,
+	opts ...lib.DiffOptions) []byte {
+
+	return computePrefixesChecksum(t, node, [][]byte{prefix}, blockHeight, opts...)
+}
+
+// computePrefixesChecksum computes a single checksum of the node's state records under all the provided prefixes,
+// e.g. to checksum a subset of the state. Keys skipped by the options aren't included in the checksum. The prefixes are
+// checksummed concurrently by a worker per CPU, see computePrefixesChecksumWithWorkers.
+func computePrefixesChecksum(t testing.TB, node *cmd.Node, prefixes [][]byte, blockHeight uint64,
+	opts ...lib.DiffOptions) []byte {
+
+	return computePrefixesChecksumWithWorkers(t, node, prefixes, blockHeight, 0, opts...)
+}
+
+// computePrefixesChecksumWithWorkers is computePrefixesChecksum with the number of workers that checksum the prefixes,
+// and the key ranges of large prefixes, concurrently. Zero workers uses GOMAXPROCS workers, and one worker checksums
+// the records serially. The checksum is the same regardless of the number of workers.
+func computePrefixesChecksumWithWorkers(t testing.TB, node *cmd.Node, prefixes [][]byte, blockHeight uint64,
+	workers int, opts ...lib.DiffOptions) []byte {
+
+	compareOpts := compareOptions(t, opts)
+	checksum, err := lib.ComputeStateChecksum(node.Server.GetBlockchain().DB(), prefixes, blockHeight, workers,
+		compareOpts.SkipsKey)
+	if err != nil {
+		t.Fatalf("computePrefixesChecksum: Problem computing checksum of %v (%v)", nodeLogName(node), err)
+	}
+	return checksum
+}
+
+// combineStateChecksums returns the checksum of the union of the records whose checksums are provided. A checksum is
+// the sum of the curve points of its records, so the checksums of disjoint sets of records, e.g. of different
+// prefixes, add up to the checksum of all their records.
+func combineStateChecksums(t testing.TB, checksums ...[]byte) []byte {
 	require := require.New(t)
 
-	// Get all state prefixes and sort them.
+	combined := &lib.StateChecksum{}
+	combined.Initialize(nil, nil)
+	for _, checksumBytes := range checksums {
+		checksum := &lib.StateChecksum{}
+		checksum.Initialize(nil, nil)
+		require.NoError(checksum.FromBytes(checksumBytes))
+		element, err := checksum.GetChecksum()
+		require.NoError(err)
+		combined.AddToChecksum(element)
+	}
+	combinedBytes, err := combined.ToBytes()
+	require.NoError(err)
+	return combinedBytes
+}
+
+// sortedStatePrefixes returns all state prefixes in increasing order.
+func sortedStatePrefixes() [][]byte {
 	var prefixes [][]byte
 	for prefix, isState := range lib.StatePrefixes.StatePrefixesMap {
 		if !isState {
@@ -321,156 +1033,695 @@ func computeNodeStateChecksum(t *testing.T, node *cmd.Node, blockHeight uint64)
 	sort.Slice(prefixes, func(ii, jj int) bool {
 		return prefixes[ii][0] < prefixes[jj][0]
 	})
+	return prefixes
+}
 
-	carrierChecksum := &lib.StateChecksum{}
-	carrierChecksum.Initialize(nil, nil)
+// mismatchedChecksumPrefixes computes the checksum of every state prefix on both nodes at blockHeight, and returns the
+// names of the prefixes whose checksums differ, in prefix order.
+func mismatchedChecksumPrefixes(t testing.TB, nodeA *cmd.Node, nodeB *cmd.Node, blockHeight uint64,
+	opts lib.DiffOptions) []string {
 
-	err := node.Server.GetBlockchain().DB().View(func(txn *badger.Txn) error {
+	_, prefixChecksumsA := computeNodeStateChecksums(t, nodeA, blockHeight, opts)
+	_, prefixChecksumsB := computeNodeStateChecksums(t, nodeB, blockHeight, opts)
+	var prefixes []byte
+	for prefix, checksumA := range prefixChecksumsA {
+		if !bytes.Equal(checksumA, prefixChecksumsB[prefix]) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Slice(prefixes, func(ii, jj int) bool {
+		return prefixes[ii] < prefixes[jj]
+	})
+
+	var prefixNames []string
+	for _, prefix := range prefixes {
+		prefixNames = append(prefixNames, lib.StatePrefixName([]byte{prefix}))
+	}
+	return prefixNames
+}
+
+// dumpNodePrefix writes a line for every state entry of the node under the prefix to w, with the hex key, the length
+// of the value, and the decoded value if a decoder is registered for the prefix. The options can restrict the dump to
+// a key range, and limit the number of entries, so that huge prefixes don't flood the output. It's meant for eyeballing
+// a prefix when a comparison fails, e.g. dumpNodePrefix(t, node, lib.Prefixes.PrefixPostHashToPostEntry, os.Stdout).
+// The state of a stopped node can be dumped with scripts/state_dump.
+func dumpNodePrefix(t testing.TB, node *cmd.Node, prefix []byte, w io.Writer, opts ...lib.StateDumpOptions) {
+	if len(opts) > 1 {
+		t.Fatalf("dumpNodePrefix: Expected at most one lib.StateDumpOptions, got (%v)", len(opts))
+	}
+	dumpOpts := lib.StateDumpOptions{}
+	if len(opts) == 1 {
+		dumpOpts = opts[0]
+	}
+	if _, err := lib.DumpStatePrefix(node.ChainDB, prefix, w, dumpOpts); err != nil {
+		t.Fatalf("dumpNodePrefix: Problem dumping prefix %v of %v (%v)", lib.StatePrefixName(prefix),
+			nodeLogName(node), err)
+	}
+}
+
+// countPrefixRecords returns the number of records stored under the provided prefix in the database.
+func countPrefixRecords(t testing.TB, db *badger.DB, prefix []byte) int {
+	require := require.New(t)
+
+	count := 0
+	err := db.View(func(txn *badger.Txn) error {
 		opts := badger.DefaultIteratorOptions
-		for _, prefix := range prefixes {
-			it := txn.NewIterator(opts)
-			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-				item := it.Item()
-				key := item.Key()
-				err := item.Value(func(value []byte) error {
-					return carrierChecksum.AddOrRemoveBytesWithMigrations(key, value, blockHeight,
-						nil, true)
-				})
-				if err != nil {
-					return err
-				}
-			}
-			it.Close()
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			count++
 		}
 		return nil
 	})
 	require.NoError(err)
-	require.NoError(carrierChecksum.Wait())
-	checksumBytes, err := carrierChecksum.ToBytes()
-	require.NoError(err)
-	return checksumBytes
+	return count
+}
+
+// nodeStopTimeout bounds how long the helpers wait for a node to stop, so that a hung shutdown fails the test with the
+// subsystem it's stuck on, instead of hanging until the test binary times out.
+const nodeStopTimeout = 2 * time.Minute
+
+// stopNodeWithTimeout stops the node, and returns an error naming the stuck subsystem if the node doesn't stop within
+// nodeStopTimeout.
+func stopNodeWithTimeout(node *cmd.Node) error {
+	ctx, cancel := context.WithTimeout(context.Background(), nodeStopTimeout)
+	defer cancel()
+	return node.StopWithContext(ctx)
 }
 
-// Stop the provided node.
-func shutdownNode(t *testing.T, node *cmd.Node) *cmd.Node {
-	if !node.IsRunning {
+// Stop the provided node. The returned node is the same node, which can be started again right away, since Stop
+// returns once the node released its port and data directory.
+func shutdownNode(t testing.TB, node *cmd.Node) *cmd.Node {
+	if !node.IsRunning() {
 		t.Fatalf("shutdownNode: can't shutdown, node is already down")
 	}
+	if err := stopNodeWithTimeout(node); err != nil {
+		t.Fatalf("shutdownNode: Problem stopping %v: %v", nodeLogName(node), err)
+	}
+	logNodeEvent(node, "shutdownNode: Stopped")
+	return node
+}
 
-	node.Stop()
-	config := node.Config
-	return cmd.NewNode(config)
+// testNodeKey identifies a logical node in a test. A node keeps its cmd.Node instance across restarts, but a test can
+// also create a new instance on the data directory of a stopped node, which makes them the same logical node.
+type testNodeKey struct {
+	t       testing.TB
+	dataDir string
 }
 
-// Start the provided node.
-func startNode(t *testing.T, node *cmd.Node) *cmd.Node {
-	if node.IsRunning {
-		t.Fatalf("startNode: node is already running")
+// currentTestNodes maps every logical node of the running tests to its current cmd.Node instance.
+var (
+	currentTestNodesMtx sync.Mutex
+	currentTestNodes    = make(map[testNodeKey]*cmd.Node)
+)
+
+// trackNode makes node the current instance of its logical node. The first time a logical node is tracked, a single
+// cleanup is registered that stops whichever instance is current when the test finishes, so restarting a node many
+// times doesn't stack up cleanups for the replaced instances.
+func trackNode(t testing.TB, node *cmd.Node) {
+	// Check for leaks from the first node of the test on, so that the check runs after all nodes are stopped.
+	if numTrackedNodes(t) == 0 {
+		VerifyNoLeaks(t)
 	}
-	// Start the node.
-	node.Start()
+
+	key := testNodeKey{t, node.Config.DataDirectory}
+	currentTestNodesMtx.Lock()
+	_, tracked := currentTestNodes[key]
+	currentTestNodes[key] = node
+	currentTestNodesMtx.Unlock()
+	if tracked {
+		return
+	}
+
 	t.Cleanup(func() {
-		node.Stop()
+		currentTestNodesMtx.Lock()
+		currentNode := currentTestNodes[key]
+		delete(currentTestNodes, key)
+		currentTestNodesMtx.Unlock()
+		if err := stopNodeWithTimeout(currentNode); err != nil {
+			t.Errorf("trackNode: Problem stopping %v: %v", nodeLogName(currentNode), err)
+		}
 	})
+}
+
+// testOfNode returns the test that the node is tracked for, or nil if the node isn't tracked.
+func testOfNode(node *cmd.Node) testing.TB {
+	currentTestNodesMtx.Lock()
+	defer currentTestNodesMtx.Unlock()
+	for key, currentNode := range currentTestNodes {
+		if currentNode == node {
+			return key.t
+		}
+	}
+	return nil
+}
+
+// currentNodeInstance returns the current instance of the node's logical node in the test, which differs from the node
+// once it was restarted, or the node itself if it isn't tracked.
+func currentNodeInstance(t testing.TB, node *cmd.Node) *cmd.Node {
+	currentTestNodesMtx.Lock()
+	defer currentTestNodesMtx.Unlock()
+	if currentNode, tracked := currentTestNodes[testNodeKey{t, node.Config.DataDirectory}]; tracked {
+		return currentNode
+	}
 	return node
 }
 
-// Restart the provided node.A
-func restartNode(t *testing.T, node *cmd.Node) *cmd.Node {
-	if !node.IsRunning {
-		t.Fatalf("shutdownNode: can't restart, node already down")
+// numTrackedNodes returns the number of logical nodes tracked for the test.
+func numTrackedNodes(t testing.TB) int {
+	currentTestNodesMtx.Lock()
+	defer currentTestNodesMtx.Unlock()
+	count := 0
+	for key := range currentTestNodes {
+		if key.t == t {
+			count++
+		}
+	}
+	return count
+}
+
+// crashNode terminates the node without a graceful shutdown, see cmd.Node.Crash. The returned node is the same node,
+// which isn't running, and starting it reopens the crashed node's data directory.
+func crashNode(t testing.TB, node *cmd.Node) *cmd.Node {
+	if !node.IsRunning() {
+		t.Fatalf("crashNode: can't crash, node is already down")
 	}
 
-	newNode := shutdownNode(t, node)
-	return startNode(t, newNode)
+	node.Crash()
+	logNodeEvent(node, "crashNode: Crashed")
+	return node
 }
 
-// listenForBlockHeight busy-waits until the node's block tip reaches provided height.
-func listenForBlockHeight(t *testing.T, node *cmd.Node, height uint32, signal chan<- bool) {
-	ticker := time.NewTicker(1 * time.Millisecond)
+// nodeStartTimeout is how long startNode waits for a node to start serving. Starting can take a while when the node
+// has to recover from an unclean shutdown, or run migrations on an existing data directory.
+const nodeStartTimeout = 5 * time.Minute
+
+// Start the provided node. startNode returns once the node is ready, see cmd.Node.Healthz, and fails the test with the
+// underlying error if the node couldn't start, e.g. because its port is already in use.
+func startNode(t testing.TB, node *cmd.Node) *cmd.Node {
+	if node.IsRunning() {
+		t.Fatalf("startNode: node is already running")
+	}
+	// Track the node first, so that it's stopped when the test finishes even if it starts after the timeout.
+	trackNode(t, node)
+
+	// Start the node.
+	startErr := make(chan error, 1)
 	go func() {
-		for {
-			<-ticker.C
-			if node.Server.GetBlockchain().BlockTip().Height >= height {
-				signal <- true
-				break
+		startErr <- node.Start()
+	}()
+	timeout := time.NewTimer(nodeStartTimeout)
+	defer timeout.Stop()
+	select {
+	case err := <-startErr:
+		if err != nil {
+			t.Fatalf("startNode: Problem starting node on port (%v): %v", node.Config.ProtocolPort, err)
+		}
+	case <-timeout.C:
+		t.Fatalf("startNode: node on port (%v) didn't start within (%v)", node.Config.ProtocolPort, nodeStartTimeout)
+	}
+
+	waitForNodeReady(t, node, timeout.C)
+	logNodeEvent(node, "startNode: Started on port (%v)", node.ListeningPort())
+	attachSyncMetrics(node)
+	return node
+}
+
+// waitForNodeReady waits for the node to be ready, so that the test doesn't race the node's startup, and fails the
+// test with the failing health checks if the timeout fires first.
+func waitForNodeReady(t testing.TB, node *cmd.Node, timeout <-chan time.Time) {
+	for report := node.Healthz(); !report.Ready; report = node.Healthz() {
+		select {
+		case <-timeout:
+			t.Fatalf("waitForNodeReady: node on port (%v) didn't become ready within (%v), failing checks: %v",
+				node.ListeningPort(), nodeStartTimeout, report.Failing())
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+// Restart the provided node in place, see cmd.Node.Restart. The returned node is the same node.
+func restartNode(t testing.TB, node *cmd.Node) *cmd.Node {
+	return restartNodeWithConfig(t, node, nil)
+}
+
+// restartNodeWithConfig restarts the node in place with mutate applied to a copy of its config, see
+// cmd.Node.RestartWithConfig. This is useful for testing nodes that change their settings across a restart, e.g.
+// enabling TXIndex or switching the SyncType. The returned node is the same node.
+func restartNodeWithConfig(t testing.TB, node *cmd.Node, mutate func(config *cmd.Config)) *cmd.Node {
+	timeout := time.NewTimer(nodeStartTimeout)
+	defer timeout.Stop()
+	if err := node.RestartWithConfig(mutate); err != nil {
+		t.Fatalf("restartNodeWithConfig: Problem restarting %v: %v", nodeLogName(node), err)
+	}
+	waitForNodeReady(t, node, timeout.C)
+	logNodeEvent(node, "restartNode: Restarted on port (%v)", node.ListeningPort())
+	attachSyncMetrics(node)
+	return node
+}
+
+// listenForCondition checks the condition whenever the node fires an event, and closes the returned channel once the
+// condition holds. Closing, rather than sending to, the channel lets any number of goroutines wait on it. The listener
+// stops when the context is canceled or when the test finishes, whichever comes first, in which case the channel is
+// never closed.
+func listenForCondition(ctx context.Context, t testing.TB, node *cmd.Node, condition func() bool) <-chan struct{} {
+	ctx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+
+	// Subscribe before checking the condition for the first time, so that no event is missed in between.
+	events := subscribeToNodeEvents(node)
+	signal := make(chan struct{})
+	go func() {
+		defer events.unsubscribe()
+		for !condition() {
+			if !events.wait(ctx) {
+				return
+			}
+		}
+		close(signal)
+	}()
+	return signal
+}
+
+// waitForSignal waits until the signal channel is closed, and fails the test with the node's sync state if the
+// context is done first. The target describes what the node was supposed to reach, for the failure message.
+func waitForSignal(ctx context.Context, t testing.TB, node *cmd.Node, signal <-chan struct{}, target string) {
+	defer recordSyncWait(node, target, time.Now())
+	select {
+	case <-signal:
+	case <-ctx.Done():
+		t.Fatalf("waitForSignal: %v on port (%v) didn't reach %v: %v; %v",
+			nodeLogName(node), node.ListeningPort(), target, ctx.Err(), describeSyncState(node))
+	}
+}
+
+// listenForChainState returns a channel that is closed the first time the node's chain state equals the provided
+// state. The listener follows the node's chain state events, so it fires even if the node only passes through the
+// state briefly.
+func listenForChainState(ctx context.Context, t testing.TB, node *cmd.Node, state lib.SyncState) <-chan struct{} {
+	ctx, cancel := context.WithCancel(ctx)
+	t.Cleanup(cancel)
+
+	// Subscribe before checking the current state, so that no change is missed in between.
+	events, unsubscribe := node.Subscribe(cmd.EventTypeSyncStateChanged)
+	signal := make(chan struct{})
+	go func() {
+		defer unsubscribe()
+		currentState := node.Status().ChainState
+		for currentState != state {
+			select {
+			case <-ctx.Done():
+				return
+			case event := <-events:
+				currentState = event.NewState
 			}
 		}
+		close(signal)
 	}()
+	return signal
 }
 
-// listenForBlockHeight busy-waits until the node's block tip reaches provided height.
-func disconnectAtBlockHeight(t *testing.T, syncingNode *cmd.Node, bridge *ConnectionBridge, height uint32) {
-	listener := make(chan bool)
-	listenForBlockHeight(t, syncingNode, height, listener)
-	<-listener
+// waitForChainState waits until the node's chain state equals the provided state, and fails the test if that doesn't
+// happen within defaultSyncTimeout.
+func waitForChainState(t testing.TB, node *cmd.Node, state lib.SyncState) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSyncTimeout)
+	defer cancel()
+	waitForSignal(ctx, t, node, listenForChainState(ctx, t, node, state), fmt.Sprintf("chain state (%v)", state))
+}
+
+// listenForBlockHeight returns a channel that is closed once the node's block tip reaches provided height.
+func listenForBlockHeight(ctx context.Context, t testing.TB, node *cmd.Node, height uint32) <-chan struct{} {
+	return listenForCondition(ctx, t, node, func() bool {
+		return node.Status().BlockTipHeight >= height
+	})
+}
+
+// waitForBlockHeight waits until the node's block tip reaches provided height, and fails the test if that doesn't
+// happen within defaultSyncTimeout.
+func waitForBlockHeight(t testing.TB, node *cmd.Node, height uint32) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSyncTimeout)
+	defer cancel()
+	waitForSignal(ctx, t, node, listenForBlockHeight(ctx, t, node, height), fmt.Sprintf("block height (%v)", height))
+}
+
+// disconnectAtBlockHeight waits until the node's block tip reaches provided height, and then disconnects the bridge.
+func disconnectAtBlockHeight(t testing.TB, syncingNode *cmd.Node, bridge *ConnectionBridge, height uint32) {
+	waitForBlockHeight(t, syncingNode, height)
+	bridge.Disconnect()
+}
+
+// listenForHeaderHeight returns a channel that is closed once the node's header tip reaches provided height. Since
+// headers are synced before blocks, this can happen long before the block tip gets there.
+func listenForHeaderHeight(ctx context.Context, t testing.TB, node *cmd.Node, height uint32) <-chan struct{} {
+	return listenForCondition(ctx, t, node, func() bool {
+		return node.Status().HeaderTipHeight >= height
+	})
+}
+
+// waitForHeaderHeight waits until the node's header tip reaches provided height, and fails the test if that doesn't
+// happen within defaultSyncTimeout.
+func waitForHeaderHeight(t testing.TB, node *cmd.Node, height uint32) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSyncTimeout)
+	defer cancel()
+	waitForSignal(ctx, t, node, listenForHeaderHeight(ctx, t, node, height), fmt.Sprintf("header height (%v)", height))
+}
+
+// disconnectAtHeaderHeight waits until the node's header tip reaches provided height, and then disconnects the bridge.
+func disconnectAtHeaderHeight(t testing.TB, syncingNode *cmd.Node, bridge *ConnectionBridge, height uint32) {
+	waitForHeaderHeight(t, syncingNode, height)
 	bridge.Disconnect()
 }
 
 // restartAtHeightAndReconnectNode will restart the node once it syncs to the provided height, and then reconnects
-// the node to the source.
-func restartAtHeightAndReconnectNode(t *testing.T, node *cmd.Node, source *cmd.Node, currentBridge *ConnectionBridge,
+// the restarted node through the current bridge.
+func restartAtHeightAndReconnectNode(t testing.TB, node *cmd.Node, currentBridge *ConnectionBridge,
 	height uint32) (_node *cmd.Node, _bridge *ConnectionBridge) {
 
+	waitForBlockHeight(t, node, height)
+	return restartAndReconnectNode(t, node, currentBridge)
+}
+
+// restartAfterMessagesAndReconnectNode will restart the node once the bridge delivers count more messages of msgType,
+// and then reconnects the restarted node through the bridge.
+func restartAfterMessagesAndReconnectNode(t testing.TB, node *cmd.Node, currentBridge *ConnectionBridge,
+	msgType lib.MsgType, count int) (_node *cmd.Node, _bridge *ConnectionBridge) {
+
+	<-currentBridge.DisconnectAfterMessages(msgType, count)
+	return restartAndReconnectNode(t, node, currentBridge)
+}
+
+// restartAndReconnectNode restarts the node, and lets the bridge automatically reconnect the restarted node.
+func restartAndReconnectNode(t testing.TB, node *cmd.Node, bridge *ConnectionBridge) (
+	_node *cmd.Node, _bridge *ConnectionBridge) {
+
+	return replaceAndReconnectNode(t, node, bridge, restartNode)
+}
+
+// crashAtHeightAndReconnectNode will crash the node once its block tip reaches provided height, start it again from
+// the same data directory, and then reconnect the restarted node through the bridge.
+func crashAtHeightAndReconnectNode(t testing.TB, node *cmd.Node, currentBridge *ConnectionBridge,
+	height uint32) (_node *cmd.Node, _bridge *ConnectionBridge) {
+
+	waitForBlockHeight(t, node, height)
+	return replaceAndReconnectNode(t, node, currentBridge, func(t testing.TB, node *cmd.Node) *cmd.Node {
+		return startNode(t, crashNode(t, node))
+	})
+}
+
+// replaceAndReconnectNode replaces the node with the new instance returned by replace, e.g. a restarted node, and
+// lets the bridge automatically reconnect the new instance.
+func replaceAndReconnectNode(t testing.TB, node *cmd.Node, bridge *ConnectionBridge,
+	replace func(t testing.TB, node *cmd.Node) *cmd.Node) (_node *cmd.Node, _bridge *ConnectionBridge) {
+
 	require := require.New(t)
-	disconnectAtBlockHeight(t, node, currentBridge, height)
-	newNode := restartNode(t, node)
-	// Wait after the restart.
-	time.Sleep(1 * time.Second)
+	// Tear down the bridge for the duration of the restart, so that it doesn't connect to the stopping node.
+	bridge.SetAutoReconnect(0, 0)
+	bridge.Disconnect()
+	newNode := replace(t, node)
+	require.NoError(bridge.ReplaceNode(node, newNode))
 	fmt.Println("Restarted")
-	// bridge the nodes together.
-	bridge := NewConnectionBridge(newNode, source)
-	require.NoError(bridge.Start())
+
+	// Enabling automatic reconnects on the disconnected bridge makes it reconnect to the restarted node.
+	bridge.SetAutoReconnect(100*time.Millisecond, 1*time.Second)
+	waitForBridgeReconnect(t, bridge, 30*time.Second)
+	bridge.SetAutoReconnect(0, 0)
+	// The bridge completes the handshake before the node registers the peer, so wait for the node to catch up.
+	waitForPeerCount(t, newNode, 1)
 	return newNode, bridge
 }
 
-// listenForSyncPrefix will wait until the node starts downloading the provided syncPrefix in hypersync, and then sends
-// a message to the provided signal channel.
-func listenForSyncPrefix(t *testing.T, node *cmd.Node, syncPrefix []byte, signal chan<- bool) {
-	ticker := time.NewTicker(1 * time.Millisecond)
-	go func() {
-		for {
-			<-ticker.C
-			for _, prefix := range node.Server.HyperSyncProgress.PrefixProgress {
-				if reflect.DeepEqual(prefix.Prefix, syncPrefix) {
-					//if reflect.DeepEqual(prefix.LastReceivedKey, syncPrefix) {
-					//	break
-					//}
-					signal <- true
-					return
-				}
-			}
-		}
-	}()
+// waitForBridgeReconnect waits until the bridge automatically reconnects, and fails the test if it doesn't reconnect
+// within the timeout.
+func waitForBridgeReconnect(t testing.TB, bridge *ConnectionBridge, timeout time.Duration) ReconnectEvent {
+	select {
+	case event := <-bridge.ReconnectEvents():
+		fmt.Printf("Bridge reconnected after (%v) attempts and (%v) downtime\n", event.Attempts, event.Downtime)
+		return event
+	case <-time.After(timeout):
+		t.Fatalf("waitForBridgeReconnect: bridge didn't reconnect within (%v)", timeout)
+		return ReconnectEvent{}
+	}
 }
 
-// disconnectAtSyncPrefix will busy-wait until node starts downloading the provided syncPrefix in hypersync, and then
+// listenForSyncPrefix returns a channel that is closed once the node starts downloading the provided syncPrefix in
+// hypersync.
+func listenForSyncPrefix(ctx context.Context, t testing.TB, node *cmd.Node, syncPrefix []byte) <-chan struct{} {
+	return listenForCondition(ctx, t, node, func() bool {
+		_, started := hyperSyncPrefixStatus(node, syncPrefix)
+		return started
+	})
+}
+
+// waitForSyncPrefix waits until the node starts downloading the provided syncPrefix in hypersync, and fails the test
+// if that doesn't happen within defaultSyncTimeout.
+func waitForSyncPrefix(t testing.TB, node *cmd.Node, syncPrefix []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSyncTimeout)
+	defer cancel()
+	waitForSignal(ctx, t, node, listenForSyncPrefix(ctx, t, node, syncPrefix),
+		fmt.Sprintf("sync prefix (%v)", syncPrefix))
+}
+
+// disconnectAtSyncPrefix will wait until node starts downloading the provided syncPrefix in hypersync, and then
 // it will disconnect the node from the provided bridge.
-func disconnectAtSyncPrefix(t *testing.T, syncingNode *cmd.Node, bridge *ConnectionBridge, syncPrefix []byte) {
-	listener := make(chan bool)
-	listenForSyncPrefix(t, syncingNode, syncPrefix, listener)
-	<-listener
+func disconnectAtSyncPrefix(t testing.TB, syncingNode *cmd.Node, bridge *ConnectionBridge, syncPrefix []byte) {
+	waitForSyncPrefix(t, syncingNode, syncPrefix)
 	bridge.Disconnect()
 }
 
-// restartAtSyncPrefixAndReconnectNode will
-func restartAtSyncPrefixAndReconnectNode(t *testing.T, node *cmd.Node, source *cmd.Node, currentBridge *ConnectionBridge,
+// restartAtSyncPrefixAndReconnectNode will restart the node once it starts downloading the provided syncPrefix in
+// hypersync, and then reconnects the restarted node through the current bridge.
+func restartAtSyncPrefixAndReconnectNode(t testing.TB, node *cmd.Node, currentBridge *ConnectionBridge,
 	syncPrefix []byte) (_node *cmd.Node, _bridge *ConnectionBridge) {
 
-	require := require.New(t)
-	disconnectAtSyncPrefix(t, node, currentBridge, syncPrefix)
-	newNode := restartNode(t, node)
+	waitForSyncPrefix(t, node, syncPrefix)
+	return restartAndReconnectNode(t, node, currentBridge)
+}
 
-	// bridge the nodes together.
-	bridge := NewConnectionBridge(newNode, source)
-	require.NoError(bridge.Start())
-	return newNode, bridge
+// listenForSyncPrefixCompletion returns a channel that is closed once the node has received the whole provided
+// syncPrefix in hypersync, as opposed to listenForSyncPrefix, which fires as soon as the download starts.
+func listenForSyncPrefixCompletion(ctx context.Context, t testing.TB, node *cmd.Node,
+	syncPrefix []byte) <-chan struct{} {
+
+	return listenForCondition(ctx, t, node, func() bool {
+		prefix, _ := hyperSyncPrefixStatus(node, syncPrefix)
+		return prefix.Completed
+	})
 }
 
-func randomUint32Between(t *testing.T, min, max uint32) uint32 {
-	require := require.New(t)
-	randomNumber, err := wire.RandomUint64()
-	require.NoError(err)
-	randomHeight := uint32(randomNumber) % (max - min)
-	return randomHeight + min
+// waitForSyncPrefixCompletion waits until the node has received the whole provided syncPrefix in hypersync and
+// flushed it to the db, and fails the test if that doesn't happen within defaultSyncTimeout.
+func waitForSyncPrefixCompletion(t testing.TB, node *cmd.Node, syncPrefix []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSyncTimeout)
+	defer cancel()
+	waitForSignal(ctx, t, node, listenForSyncPrefixCompletion(ctx, t, node, syncPrefix),
+		fmt.Sprintf("completion of sync prefix (%v)", syncPrefix))
+	node.Server.GetBlockchain().Snapshot().WaitForAllOperationsToFinish()
+}
+
+// disconnectAtSyncPrefixCompletion will wait until node has received the whole provided syncPrefix in hypersync, and
+// then it will disconnect the node from the provided bridge. Note that the node requests the next prefix right after
+// completing one, so the first chunk of the next prefix can already be in flight.
+func disconnectAtSyncPrefixCompletion(t testing.TB, syncingNode *cmd.Node, bridge *ConnectionBridge,
+	syncPrefix []byte) {
+
+	waitForSyncPrefixCompletion(t, syncingNode, syncPrefix)
+	bridge.Disconnect()
+}
+
+// restartAtSyncPrefixCompletionAndReconnectNode will restart the node once it has received the whole provided
+// syncPrefix in hypersync, and then reconnects the restarted node through the current bridge.
+func restartAtSyncPrefixCompletionAndReconnectNode(t testing.TB, node *cmd.Node, currentBridge *ConnectionBridge,
+	syncPrefix []byte) (_node *cmd.Node, _bridge *ConnectionBridge) {
+
+	waitForSyncPrefixCompletion(t, node, syncPrefix)
+	return restartAndReconnectNode(t, node, currentBridge)
+}
+
+// listenForPeerCount returns a channel that is closed once the node has at least count connected peers that have
+// completed version negotiation.
+func listenForPeerCount(ctx context.Context, t testing.TB, node *cmd.Node, count int) <-chan struct{} {
+	return listenForCondition(ctx, t, node, func() bool {
+		return node.Status().NumPeers >= count
+	})
+}
+
+// waitForPeerCount waits until the node has at least count connected peers, and fails the test if that doesn't
+// happen within defaultSyncTimeout.
+func waitForPeerCount(t testing.TB, node *cmd.Node, count int) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSyncTimeout)
+	defer cancel()
+	waitForSignal(ctx, t, node, listenForPeerCount(ctx, t, node, count), fmt.Sprintf("peer count (%v)", count))
+}
+
+// listenForPeerDisconnect returns a channel that is closed once the node has no peer with the provided address,
+// e.g. after the node dropped the peer.
+func listenForPeerDisconnect(ctx context.Context, t testing.TB, node *cmd.Node, peerAddr string) <-chan struct{} {
+	return listenForCondition(ctx, t, node, func() bool {
+		for _, peer := range node.Server.GetConnectionManager().GetAllPeers() {
+			if peer.Address() == peerAddr {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// waitForPeerDisconnect waits until the node has no peer with the provided address, and fails the test if that doesn't
+// happen within defaultSyncTimeout.
+func waitForPeerDisconnect(t testing.TB, node *cmd.Node, peerAddr string) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSyncTimeout)
+	defer cancel()
+	waitForSignal(ctx, t, node, listenForPeerDisconnect(ctx, t, node, peerAddr),
+		fmt.Sprintf("disconnect of peer (%v)", peerAddr))
+}
+
+// listenForSnapshotKey returns a channel that is closed once the last key the node received for the provided
+// syncPrefix in hypersync is greater than or equal to the provided key. Snapshot chunks contain many keys, so the
+// node can be well past the key once this fires. The channel is also closed if the prefix is completed without ever
+// reaching the key, e.g. because the key is greater than all keys in the prefix.
+func listenForSnapshotKey(ctx context.Context, t testing.TB, node *cmd.Node, syncPrefix []byte,
+	key []byte) <-chan struct{} {
+
+	return listenForCondition(ctx, t, node, func() bool {
+		prefix, started := hyperSyncPrefixStatus(node, syncPrefix)
+		return started && (prefix.Completed || prefix.LastReceivedKey >= hex.EncodeToString(key))
+	})
+}
+
+// waitForSnapshotKey waits until the node receives the provided key of syncPrefix in hypersync, and fails the test if
+// that doesn't happen within defaultSyncTimeout.
+func waitForSnapshotKey(t testing.TB, node *cmd.Node, syncPrefix []byte, key []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSyncTimeout)
+	defer cancel()
+	waitForSignal(ctx, t, node, listenForSnapshotKey(ctx, t, node, syncPrefix, key),
+		fmt.Sprintf("snapshot key (%v) of sync prefix (%v)", key, syncPrefix))
+}
+
+// disconnectAtSnapshotKey will wait until node receives the provided key of syncPrefix in hypersync, and then it
+// will disconnect the node from the provided bridge. This allows interrupting hypersync in the middle of a prefix.
+func disconnectAtSnapshotKey(t testing.TB, syncingNode *cmd.Node, bridge *ConnectionBridge, syncPrefix []byte,
+	key []byte) {
+
+	waitForSnapshotKey(t, syncingNode, syncPrefix, key)
+	bridge.Disconnect()
+}
+
+// restartAtSnapshotKeyAndReconnectNode will restart the node once it receives the provided key of syncPrefix in
+// hypersync, and then reconnects the restarted node through the current bridge.
+func restartAtSnapshotKeyAndReconnectNode(t testing.TB, node *cmd.Node, currentBridge *ConnectionBridge,
+	syncPrefix []byte, key []byte) (_node *cmd.Node, _bridge *ConnectionBridge) {
+
+	waitForSnapshotKey(t, node, syncPrefix, key)
+	return restartAndReconnectNode(t, node, currentBridge)
+}
+
+// listenForMempoolTxnCount returns a channel that is closed once the node's mempool contains at least count
+// transactions. The mempool is read through its read-only view, which the node regenerates about every second, and
+// which doesn't fire events, so the count is only re-checked at syncEventFallbackInterval or on chain events.
+func listenForMempoolTxnCount(ctx context.Context, t testing.TB, node *cmd.Node, count int) <-chan struct{} {
+	return listenForCondition(ctx, t, node, func() bool {
+		return node.Status().NumMempoolTxns >= count
+	})
+}
+
+// waitForMempoolTxnCount waits until the node's mempool contains at least count transactions, and fails the test if
+// that doesn't happen within defaultSyncTimeout.
+func waitForMempoolTxnCount(t testing.TB, node *cmd.Node, count int) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSyncTimeout)
+	defer cancel()
+	waitForSignal(ctx, t, node, listenForMempoolTxnCount(ctx, t, node, count),
+		fmt.Sprintf("mempool txn count (%v)", count))
+}
+
+// listenForTxnInMempool returns a channel that is closed once the transaction with the provided hash is in the
+// node's mempool.
+func listenForTxnInMempool(ctx context.Context, t testing.TB, node *cmd.Node, txnHash *lib.BlockHash) <-chan struct{} {
+	return listenForCondition(ctx, t, node, func() bool {
+		return node.Server.GetMempool().IsTransactionInPool(txnHash)
+	})
+}
+
+// waitForTxnInMempool waits until the transaction with the provided hash is in the node's mempool, and fails the test
+// if that doesn't happen within defaultSyncTimeout.
+func waitForTxnInMempool(t testing.TB, node *cmd.Node, txnHash *lib.BlockHash) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSyncTimeout)
+	defer cancel()
+	waitForSignal(ctx, t, node, listenForTxnInMempool(ctx, t, node, txnHash),
+		fmt.Sprintf("txn (%v) in mempool", txnHash))
+}
+
+// txnConfirmationScanDepth is how many blocks below the tip findTxnBlockHeight scans for a transaction that isn't in
+// the node's txindex.
+const txnConfirmationScanDepth = 100
+
+// findTxnBlockHeight returns the height of the block on the node's best chain that contains the transaction. The
+// transaction is looked up in the txindex if the node has it enabled, and otherwise found by scanning the most recent
+// txnConfirmationScanDepth blocks. Returns false if the transaction isn't in any of these blocks.
+func findTxnBlockHeight(node *cmd.Node, txnHash *lib.BlockHash) (_height uint32, _exists bool) {
+	chain := node.Server.GetBlockchain()
+	if node.TXIndex != nil {
+		txnMeta := lib.DbGetTxindexTransactionRefByTxID(node.TXIndex.TXIndexChain.DB(), nil, txnHash)
+		if txnMeta != nil {
+			blockHashBytes, err := hex.DecodeString(txnMeta.BlockHashHex)
+			if err == nil {
+				if blockNode := chain.GetBlockNodeWithHash(lib.NewBlockHash(blockHashBytes)); blockNode != nil {
+					return blockNode.Height, true
+				}
+			}
+		}
+		// The txindex lags behind the chain, so fall back to scanning the most recent blocks.
+	}
+
+	tipHeight := chain.BlockTip().Height
+	for height := tipHeight; height > 0 && tipHeight-height < txnConfirmationScanDepth; height-- {
+		block := chain.GetBlockAtHeight(height)
+		if block == nil {
+			continue
+		}
+		for _, txn := range block.Txns {
+			if txn.Hash().IsEqual(txnHash) {
+				return height, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// waitForTxnConfirmed waits until the transaction is in a block on the node's best chain with at least the provided
+// number of confirmations, where the block containing the transaction counts as the first confirmation. Fails the
+// test if that doesn't happen within defaultSyncTimeout, or right away if the transaction leaves the node's mempool
+// without being mined, e.g. because it was evicted.
+func waitForTxnConfirmed(t testing.TB, node *cmd.Node, txnHash *lib.BlockHash, confirmations uint32) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultSyncTimeout)
+	defer cancel()
+	events := subscribeToNodeEvents(node)
+	defer events.unsubscribe()
+
+	seenInMempool := false
+	for {
+		// Check the mempool before the chain, since mined transactions are only removed from the mempool after the
+		// block is connected.
+		inMempool := node.Server.GetMempool().IsTransactionInPool(txnHash)
+		seenInMempool = seenInMempool || inMempool
+		if height, exists := findTxnBlockHeight(node, txnHash); exists {
+			if node.Server.GetBlockchain().BlockTip().Height-height+1 >= confirmations {
+				return
+			}
+		} else if seenInMempool && !inMempool {
+			t.Fatalf("waitForTxnConfirmed: txn (%v) left the mempool of node on port (%v) without being mined",
+				txnHash, node.ListeningPort())
+		}
+
+		if !events.wait(ctx) {
+			t.Fatalf("waitForTxnConfirmed: txn (%v) didn't get (%v) confirmations on node on port (%v) within "+
+				"(%v): %v", txnHash, confirmations, node.ListeningPort(), defaultSyncTimeout, describeSyncState(node))
+		}
+	}
+}
+
+// randomUint32Between returns a random number in [min, max) from the test's TestRand, so that it's reproducible with
+// the test's seed.
+func randomUint32Between(t testing.TB, min, max uint32) uint32 {
+	return getTestRand(t).Uint32Between(min, max)
 }
@@ -0,0 +1,199 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+)
+
+// TopologyEdge is a pair of nodes that should be bridged together in a Topology.
+type TopologyEdge struct {
+	NodeA *cmd.Node
+	NodeB *cmd.Node
+}
+
+// TopologyShape determines which of the provided nodes should be bridged together.
+type TopologyShape func(nodes []*cmd.Node) []TopologyEdge
+
+// Star connects the node at the center index to every other node.
+func Star(center int) TopologyShape {
+	return func(nodes []*cmd.Node) []TopologyEdge {
+		var edges []TopologyEdge
+		for ii, node := range nodes {
+			if ii == center {
+				continue
+			}
+			edges = append(edges, TopologyEdge{nodes[center], node})
+		}
+		return edges
+	}
+}
+
+// Line connects every node to the next node in the list.
+func Line() TopologyShape {
+	return func(nodes []*cmd.Node) []TopologyEdge {
+		var edges []TopologyEdge
+		for ii := 1; ii < len(nodes); ii++ {
+			edges = append(edges, TopologyEdge{nodes[ii-1], nodes[ii]})
+		}
+		return edges
+	}
+}
+
+// Ring connects every node to the next node in the list, and the last node to the first node.
+func Ring() TopologyShape {
+	return func(nodes []*cmd.Node) []TopologyEdge {
+		edges := Line()(nodes)
+		if len(nodes) > 2 {
+			edges = append(edges, TopologyEdge{nodes[len(nodes)-1], nodes[0]})
+		}
+		return edges
+	}
+}
+
+// FullMesh connects every pair of nodes.
+func FullMesh() TopologyShape {
+	return func(nodes []*cmd.Node) []TopologyEdge {
+		var edges []TopologyEdge
+		for ii := 0; ii < len(nodes); ii++ {
+			for jj := ii + 1; jj < len(nodes); jj++ {
+				edges = append(edges, TopologyEdge{nodes[ii], nodes[jj]})
+			}
+		}
+		return edges
+	}
+}
+
+// Edges connects exactly the provided pairs of nodes, which allows building topologies of any shape.
+func Edges(edges ...TopologyEdge) TopologyShape {
+	return func(nodes []*cmd.Node) []TopologyEdge {
+		return edges
+	}
+}
+
+// Topology is a network of nodes connected with ConnectionBridges. It's a convenient way of wiring together tests
+// with many nodes, e.g. a ten-node ring can be built with NewTopology(nodes, Ring()).
+type Topology struct {
+	nodes []*cmd.Node
+	// edges are the bridged pairs of nodes, in the order in which the bridges were started.
+	edges []TopologyEdge
+	// bridges maps each pair of bridged nodes, in both orders, to the bridge between them.
+	bridges map[TopologyEdge]*ConnectionBridge
+	// partitionedBridges are the bridges disconnected by PartitionNetwork, which will be restored by HealPartition.
+	partitionedBridges []*ConnectionBridge
+}
+
+// NewTopology bridges the nodes together in the provided shape, and starts all the bridges. Duplicate edges are
+// bridged only once. If any of the bridges fails to start, the bridges that were already started are disconnected.
+func NewTopology(nodes []*cmd.Node, shape TopologyShape) (*Topology, error) {
+	topology := &Topology{
+		nodes:   nodes,
+		bridges: make(map[TopologyEdge]*ConnectionBridge),
+	}
+
+	for _, edge := range shape(nodes) {
+		if edge.NodeA == edge.NodeB {
+			topology.Disconnect()
+			return nil, fmt.Errorf("NewTopology: Can't bridge a node with itself")
+		}
+		if _, exists := topology.bridges[edge]; exists {
+			continue
+		}
+
+		bridge := NewConnectionBridge(edge.NodeA, edge.NodeB)
+		if err := bridge.Start(); err != nil {
+			topology.Disconnect()
+			return nil, fmt.Errorf("NewTopology: Problem starting bridge between nodes on ports (%v) and (%v): %v",
+				edge.NodeA.ListeningPort(), edge.NodeB.ListeningPort(), err)
+		}
+		topology.edges = append(topology.edges, edge)
+		topology.bridges[edge] = bridge
+		topology.bridges[TopologyEdge{edge.NodeB, edge.NodeA}] = bridge
+	}
+	return topology, nil
+}
+
+// Nodes returns the nodes in the topology.
+func (topology *Topology) Nodes() []*cmd.Node {
+	return topology.nodes
+}
+
+// Edges returns the bridged pairs of nodes, in the order in which the bridges were started.
+func (topology *Topology) Edges() []TopologyEdge {
+	return topology.edges
+}
+
+// Bridge returns the bridge between the two nodes, in either order, or nil if the nodes aren't bridged together.
+func (topology *Topology) Bridge(nodeA *cmd.Node, nodeB *cmd.Node) *ConnectionBridge {
+	return topology.bridges[TopologyEdge{nodeA, nodeB}]
+}
+
+// Bridges returns all bridges in the topology, in the order in which they were started.
+func (topology *Topology) Bridges() []*ConnectionBridge {
+	var bridges []*ConnectionBridge
+	for _, edge := range topology.edges {
+		bridges = append(bridges, topology.bridges[edge])
+	}
+	return bridges
+}
+
+// Disconnect tears down all bridges in the topology.
+func (topology *Topology) Disconnect() {
+	for _, bridge := range topology.Bridges() {
+		bridge.Disconnect()
+	}
+}
+
+// PartitionNetwork splits the network into the provided groups of nodes, by disconnecting all bridges between nodes
+// in different groups. Every node in the topology has to belong to exactly one group. The disconnected bridges are
+// tracked, so that HealPartition can restore exactly the topology from before the partition.
+func (topology *Topology) PartitionNetwork(groups [][]*cmd.Node) error {
+	if topology.partitionedBridges != nil {
+		return fmt.Errorf("PartitionNetwork: Network is already partitioned, heal it first")
+	}
+
+	nodeToGroup := make(map[*cmd.Node]int)
+	for groupIndex, group := range groups {
+		for _, node := range group {
+			if _, exists := nodeToGroup[node]; exists {
+				return fmt.Errorf("PartitionNetwork: Node on port (%v) belongs to more than one group",
+					node.ListeningPort())
+			}
+			nodeToGroup[node] = groupIndex
+		}
+	}
+	for _, node := range topology.nodes {
+		if _, exists := nodeToGroup[node]; !exists {
+			return fmt.Errorf("PartitionNetwork: Node on port (%v) doesn't belong to any group",
+				node.ListeningPort())
+		}
+	}
+
+	partitionedBridges := []*ConnectionBridge{}
+	for _, edge := range topology.edges {
+		if nodeToGroup[edge.NodeA] == nodeToGroup[edge.NodeB] {
+			continue
+		}
+		bridge := topology.bridges[edge]
+		// We don't want bridges with automatic reconnects to cross the partition.
+		bridge.disconnect()
+		partitionedBridges = append(partitionedBridges, bridge)
+	}
+	topology.partitionedBridges = partitionedBridges
+	return nil
+}
+
+// HealPartition reconnects all bridges disconnected by PartitionNetwork.
+func (topology *Topology) HealPartition() error {
+	if topology.partitionedBridges == nil {
+		return fmt.Errorf("HealPartition: Network is not partitioned")
+	}
+
+	for len(topology.partitionedBridges) > 0 {
+		if err := topology.partitionedBridges[0].Start(); err != nil {
+			return fmt.Errorf("HealPartition: Problem reconnecting bridge: %v", err)
+		}
+		topology.partitionedBridges = topology.partitionedBridges[1:]
+	}
+	topology.partitionedBridges = nil
+	return nil
+}
@@ -0,0 +1,92 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// TestBlockSyncRingTopology test if blocks propagate through a ring of nodes:
+//  1. Spawn ten in-memory nodes with max block height of MaxSyncBlockHeight blocks.
+//  2. The first node syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator.
+//  3. bridge all nodes together in a ring.
+//  4. all other nodes sync MaxSyncBlockHeight blocks through the ring.
+//  5. once done, compare the first node state matches all other nodes.
+func TestBlockSyncRingTopology(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	const numNodes = 10
+	nodes := spawnNodeCluster(t, numNodes, WithSyncType(lib.NodeSyncTypeBlockSync), WithInMemory(),
+		ForNode(0, WithConnectIPs("deso-seed-2.io:17000"))).Nodes()
+
+	// wait for the first node to sync blocks
+	waitForNodeToFullySync(t, nodes[0])
+
+	// bridge the nodes together in a ring.
+	topology, err := NewTopology(nodes, Ring())
+	require.NoError(err)
+	require.Len(topology.Bridges(), numNodes)
+	require.NotNil(topology.Bridge(nodes[0], nodes[numNodes-1]))
+	require.Nil(topology.Bridge(nodes[0], nodes[numNodes/2]))
+
+	// wait for all nodes to sync blocks.
+	for _, node := range nodes[1:] {
+		waitForNodeToFullySync(t, node)
+	}
+
+	compareAllNodesByState(t, nodes)
+	fmt.Println("Databases match!")
+	topology.Disconnect()
+	for _, node := range nodes {
+		node.Stop()
+	}
+}
+
+// TestRegtestPartitionReorg test if a partitioned network converges on the heavier chain once the partition heals:
+//  1. Spawn six in-memory regtest nodes and bridge them together in a ring.
+//  2. mine a few blocks on the first node and wait for all nodes to sync them.
+//  3. partition the network into two groups of three nodes.
+//  4. mine three blocks in the first group and six blocks in the second group.
+//  5. heal the partition, the first group should reorg onto the heavier chain of the second group.
+//  6. once done, compare the state of all nodes.
+func TestRegtestPartitionReorg(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	const numNodes = 6
+	nodes := spawnNodeCluster(t, numNodes, WithRegtest(), WithInMemory()).Nodes()
+
+	// bridge the nodes together in a ring and mine the common chain.
+	topology, err := NewTopology(nodes, Ring())
+	require.NoError(err)
+	mineBlocks(t, nodes[0], 5)
+	waitForNodesToConverge(t, nodes, time.Minute)
+
+	// split the network in two, both groups keep mining on their side of the partition.
+	groupA := nodes[:numNodes/2]
+	groupB := nodes[numNodes/2:]
+	require.NoError(topology.PartitionNetwork([][]*cmd.Node{groupA, groupB}))
+	mineBlocks(t, groupA[0], 3)
+	mineBlocks(t, groupB[0], 6)
+	waitForNodesToConverge(t, groupA, time.Minute)
+	waitForNodesToConverge(t, groupB, time.Minute)
+	require.False(groupA[0].Server.GetBlockchain().BlockTip().Hash.IsEqual(
+		groupB[0].Server.GetBlockchain().BlockTip().Hash))
+
+	// once the partition heals, everyone should follow the heavier chain.
+	require.NoError(topology.HealPartition())
+	heavierTip := groupB[0].Server.GetBlockchain().BlockTip().Hash
+	waitForNodesToConverge(t, nodes, time.Minute)
+	require.True(heavierTip.IsEqual(nodes[0].Server.GetBlockchain().BlockTip().Hash))
+
+	compareAllNodesByState(t, nodes)
+	fmt.Println("Databases match!")
+	topology.Disconnect()
+	for _, node := range nodes {
+		node.Stop()
+	}
+}
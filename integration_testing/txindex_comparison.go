@@ -0,0 +1,184 @@
+package integration_testing
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/golang/glog"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// compareNodesByTxIndexSemantic compares the txindex of the two provided nodes by decoding the TransactionMetadata of
+// every indexed transaction, rather than by comparing bytes like compareNodesByTxIndex. Differences are reported by
+// transaction hash, block height, and metadata field. It also cross-checks each node's txindex with its best chain:
+// every transaction in the blocks up to the txindex tip has to be indexed, and every indexed transaction has to be in
+// one of those blocks. Both nodes should have fully synced their txindex, e.g. with waitForNodeToFullySyncTxIndex.
+func compareNodesByTxIndexSemantic(t testing.TB, nodeA *cmd.Node, nodeB *cmd.Node) {
+	txnsA := getTxIndexTransactions(t, nodeA)
+	txnsB := getTxIndexTransactions(t, nodeB)
+	heightsA := getBlockHeightsByHash(nodeA)
+
+	var lines []string
+	lines = append(lines, crossCheckTxIndex(nodeA, txnsA)...)
+	lines = append(lines, crossCheckTxIndex(nodeB, txnsB)...)
+	for _, txnHash := range sortedTxnHashes(txnsA, txnsB) {
+		txnA, txnB := txnsA[txnHash], txnsB[txnHash]
+		switch {
+		case txnB == nil:
+			lines = append(lines, fmt.Sprintf("txn (%v) at height (%v): missing in B", &txnHash,
+				describeTxnHeight(heightsA, txnA)))
+		case txnA == nil:
+			lines = append(lines, fmt.Sprintf("txn (%v) at height (%v): missing in A", &txnHash,
+				describeTxnHeight(getBlockHeightsByHash(nodeB), txnB)))
+		default:
+			for _, field := range diffTransactionMetadata(txnA, txnB) {
+				lines = append(lines, fmt.Sprintf("txn (%v) at height (%v): field %v", &txnHash,
+					describeTxnHeight(heightsA, txnA), field))
+			}
+		}
+	}
+	if len(lines) == 0 {
+		fmt.Printf("compareNodesByTxIndexSemantic: Txindex of (%v) transactions matches\n", len(txnsA))
+		return
+	}
+	for _, line := range lines {
+		glog.Errorf("compareNodesByTxIndexSemantic: %v", line)
+	}
+	if len(lines) > stateDiffFailureLines {
+		lines = append(lines[:stateDiffFailureLines], fmt.Sprintf("... (%v more)", len(lines)-stateDiffFailureLines))
+	}
+	t.Fatalf("compareNodesByTxIndexSemantic: Txindex of %v and %v differ:\n%v", nodeLogName(nodeA),
+		nodeLogName(nodeB), strings.Join(lines, "\n"))
+}
+
+// getTxIndexTransactions decodes the metadata of every transaction in the node's txindex, keyed by transaction hash.
+func getTxIndexTransactions(t testing.TB, node *cmd.Node) map[lib.BlockHash]*lib.TransactionMetadata {
+	txns := make(map[lib.BlockHash]*lib.TransactionMetadata)
+	prefix := lib.Prefixes.PrefixTransactionIDToMetadata
+	err := node.TXIndex.TXIndexChain.DB().View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			txnHash := lib.NewBlockHash(it.Item().KeyCopy(nil)[len(prefix):])
+			value, err := it.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			txnMeta := &lib.TransactionMetadata{}
+			if exists, err := lib.DecodeFromBytes(txnMeta, bytes.NewReader(value)); !exists || err != nil {
+				return fmt.Errorf("problem decoding metadata of txn (%v): %v", txnHash, err)
+			}
+			txns[*txnHash] = txnMeta
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("getTxIndexTransactions: Problem reading txindex of %v (%v)", nodeLogName(node), err)
+	}
+	return txns
+}
+
+// crossCheckTxIndex checks that the node's txindex has exactly the transactions of the node's best chain blocks, from
+// the first block after genesis up to the txindex tip, and that they're indexed in the right block. The txindex is
+// also seeded with the genesis balances and seed transactions, which aren't in any block, so transactions indexed in
+// the genesis block aren't checked.
+func crossCheckTxIndex(node *cmd.Node, txns map[lib.BlockHash]*lib.TransactionMetadata) []string {
+	chain := getBestChainSnapshot(node)
+	txIndexTip := node.TXIndex.TXIndexChain.BlockTip()
+	if int(txIndexTip.Height) >= len(chain) || !chain[txIndexTip.Height].Hash.IsEqual(txIndexTip.Hash) {
+		return []string{fmt.Sprintf("%v: txindex tip (%v) at height (%v) isn't on the best chain", nodeLogName(node),
+			txIndexTip.Hash, txIndexTip.Height)}
+	}
+
+	var lines []string
+	indexedInBlocks := make(map[lib.BlockHash]bool)
+	for _, blockNode := range chain[1 : txIndexTip.Height+1] {
+		block := node.Server.GetBlockchain().GetBlock(blockNode.Hash)
+		if block == nil {
+			lines = append(lines, fmt.Sprintf("%v: block (%v) at height (%v) isn't stored, so its txns can't be "+
+				"cross-checked", nodeLogName(node), blockNode.Hash, blockNode.Height))
+			continue
+		}
+		for txnIndex, txn := range block.Txns {
+			txnHash := txn.Hash()
+			indexedInBlocks[*txnHash] = true
+			txnMeta, exists := txns[*txnHash]
+			if !exists {
+				lines = append(lines, fmt.Sprintf("%v: txn (%v) at height (%v) isn't in the txindex",
+					nodeLogName(node), txnHash, blockNode.Height))
+				continue
+			}
+			if txnMeta.BlockHashHex != blockNode.Hash.String() || txnMeta.TxnIndexInBlock != uint64(txnIndex) {
+				lines = append(lines, fmt.Sprintf("%v: txn (%v) is txn (%v) of block (%v), but indexed as txn (%v) "+
+					"of block (%v)", nodeLogName(node), txnHash, txnIndex, blockNode.Hash, txnMeta.TxnIndexInBlock,
+					txnMeta.BlockHashHex))
+			}
+		}
+	}
+	for _, txnHash := range sortedTxnHashes(txns) {
+		if !indexedInBlocks[txnHash] && txns[txnHash].BlockHashHex != lib.GenesisBlockHashHex {
+			lines = append(lines, fmt.Sprintf("%v: txn (%v) is in the txindex, but not in the best chain blocks up to "+
+				"the txindex tip, indexed in block (%v)", nodeLogName(node), &txnHash, txns[txnHash].BlockHashHex))
+		}
+	}
+	return lines
+}
+
+// diffTransactionMetadata compares the metadata field by field, and describes every differing field.
+func diffTransactionMetadata(txnA *lib.TransactionMetadata, txnB *lib.TransactionMetadata) []string {
+	var fields []string
+	valueA := reflect.ValueOf(txnA).Elem()
+	valueB := reflect.ValueOf(txnB).Elem()
+	for ii := 0; ii < valueA.NumField(); ii++ {
+		fieldA := valueA.Field(ii).Interface()
+		fieldB := valueB.Field(ii).Interface()
+		if reflect.DeepEqual(fieldA, fieldB) {
+			continue
+		}
+		fields = append(fields, fmt.Sprintf("%v: A (%v), B (%v)", valueA.Type().Field(ii).Name,
+			lib.DescribeValue(fieldA), lib.DescribeValue(fieldB)))
+	}
+	return fields
+}
+
+// getBlockHeightsByHash returns the heights of the node's best chain blocks, keyed by hex block hash.
+func getBlockHeightsByHash(node *cmd.Node) map[string]uint32 {
+	heights := make(map[string]uint32)
+	for _, blockNode := range getBestChainSnapshot(node) {
+		heights[blockNode.Hash.String()] = blockNode.Height
+	}
+	return heights
+}
+
+// describeTxnHeight returns the height of the block that the transaction is indexed in, or its block hash if the block
+// isn't on the best chain.
+func describeTxnHeight(heights map[string]uint32, txnMeta *lib.TransactionMetadata) string {
+	if height, exists := heights[txnMeta.BlockHashHex]; exists {
+		return fmt.Sprintf("%v", height)
+	}
+	return fmt.Sprintf("unknown, block %v", txnMeta.BlockHashHex)
+}
+
+// sortedTxnHashes returns the union of the transaction hashes of the maps in increasing order, so that differences
+// are reported deterministically.
+func sortedTxnHashes(txnMaps ...map[lib.BlockHash]*lib.TransactionMetadata) []lib.BlockHash {
+	seen := make(map[lib.BlockHash]bool)
+	var txnHashes []lib.BlockHash
+	for _, txns := range txnMaps {
+		for txnHash := range txns {
+			if !seen[txnHash] {
+				seen[txnHash] = true
+				txnHashes = append(txnHashes, txnHash)
+			}
+		}
+	}
+	sort.Slice(txnHashes, func(ii, jj int) bool {
+		return bytes.Compare(txnHashes[ii][:], txnHashes[jj][:]) < 0
+	})
+	return txnHashes
+}
@@ -24,10 +24,10 @@ func TestSimpleTxIndex(t *testing.T) {
 	defer os.RemoveAll(dbDir1)
 	defer os.RemoveAll(dbDir2)
 
-	config1 := generateConfig(t, 18000, dbDir1, 10)
+	config1 := generateConfig(t, dbDir1, 10)
 	config1.HyperSync = true
 	config1.SyncType = lib.NodeSyncTypeBlockSync
-	config2 := generateConfig(t, 18001, dbDir2, 10)
+	config2 := generateConfig(t, dbDir2, 10)
 	config2.HyperSync = true
 	config2.SyncType = lib.NodeSyncTypeHyperSyncArchival
 
@@ -42,21 +42,104 @@ func TestSimpleTxIndex(t *testing.T) {
 	node2 = startNode(t, node2)
 
 	// wait for node1 to sync blocks
-	waitForNodeToFullySync(node1)
+	waitForNodeToFullySync(t, node1)
 
 	// bridge the nodes together.
 	bridge := NewConnectionBridge(node1, node2)
 	require.NoError(bridge.Start())
 
 	// wait for node2 to sync blocks.
-	waitForNodeToFullySync(node2)
+	waitForNodeToFullySync(t, node2)
 
-	waitForNodeToFullySyncTxIndex(node1)
-	waitForNodeToFullySyncTxIndex(node2)
+	waitForNodeToFullySyncTxIndex(t, node1)
+	waitForNodeToFullySyncTxIndex(t, node2)
 
-	compareNodesByDB(t, node1, node2, 0)
-	compareNodesByTxIndex(t, node1, node2, 0)
+	compareNodesByDB(t, node1, node2, Summary)
+	compareNodesByTxIndexSemantic(t, node1, node2)
+	compareNodesByTxIndex(t, node1, node2, Summary)
 	fmt.Println("Databases match!")
 	node1.Stop()
 	node2.Stop()
 }
+
+// TestTxIndexEnabledAfterRestart test if a node can build txindex on an existing data directory:
+//  1. Spawn two nodes node1, node2 with max block height of MaxSyncBlockHeight blocks, only node1 has txindex enabled.
+//  2. node1 syncs MaxSyncBlockHeight blocks from the "deso-seed-2.io" generator, and builds txindex afterwards.
+//  3. bridge node1 and node2
+//  4. node2 syncs MaxSyncBlockHeight blocks from node1 without building txindex.
+//  5. node2 restarts with txindex enabled, reconnects with node1, and builds txindex from its existing blocks.
+//  6. compare node1 db and txindex matches node2.
+func TestTxIndexEnabledAfterRestart(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	config1 := generateConfig(t, dbDir1, 10)
+	config1.HyperSync = true
+	config1.SyncType = lib.NodeSyncTypeBlockSync
+	config2 := generateConfig(t, dbDir2, 10)
+	config2.HyperSync = true
+	config2.SyncType = lib.NodeSyncTypeHyperSyncArchival
+
+	config1.TXIndex = true
+	config2.TXIndex = false
+	config1.ConnectIPs = []string{"deso-seed-2.io:17000"}
+
+	node1 := cmd.NewNode(config1)
+	node2 := cmd.NewNode(config2)
+
+	node1 = startNode(t, node1)
+	node2 = startNode(t, node2)
+
+	// wait for node1 to sync blocks
+	waitForNodeToFullySync(t, node1)
+
+	// bridge the nodes together.
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+
+	// wait for node2 to sync blocks.
+	waitForNodeToFullySync(t, node2)
+	require.Nil(node2.TXIndex)
+
+	// restart node2 with txindex enabled.
+	node2, bridge = replaceAndReconnectNode(t, node2, bridge, func(t testing.TB, node *cmd.Node) *cmd.Node {
+		return restartNodeWithConfig(t, node, func(config *cmd.Config) {
+			config.TXIndex = true
+		})
+	})
+
+	waitForNodeToFullySyncTxIndex(t, node1)
+	waitForNodeToFullySyncTxIndex(t, node2)
+
+	compareNodesByDB(t, node1, node2, Summary)
+	compareNodesByTxIndexSemantic(t, node1, node2)
+	compareNodesByTxIndex(t, node1, node2, Summary)
+	fmt.Println("Databases match!")
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestDiffTransactionMetadata tests if differing txindex metadata is reported field by field.
+func TestDiffTransactionMetadata(t *testing.T) {
+	require := require.New(t)
+
+	txnA := &lib.TransactionMetadata{
+		TxnType:                      "BASIC_TRANSFER",
+		BasicTransferTxindexMetadata: &lib.BasicTransferTxindexMetadata{FeeNanos: 1},
+	}
+	txnB := &lib.TransactionMetadata{
+		TxnType:                      "BASIC_TRANSFER",
+		BasicTransferTxindexMetadata: &lib.BasicTransferTxindexMetadata{FeeNanos: 2},
+	}
+	require.Empty(diffTransactionMetadata(txnA, txnA))
+	fields := diffTransactionMetadata(txnA, txnB)
+	require.Len(fields, 1)
+	require.Contains(fields[0], "BasicTransferTxindexMetadata")
+	require.Contains(fields[0], "FeeNanos: 1")
+	require.Contains(fields[0], "FeeNanos: 2")
+}
@@ -0,0 +1,258 @@
+package integration_testing
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// TxnGeneratorType is a type of transaction that a TxnGenerator can generate.
+type TxnGeneratorType int
+
+const (
+	TxnGeneratorBasicTransfer TxnGeneratorType = iota
+	TxnGeneratorFollow
+	TxnGeneratorPost
+	TxnGeneratorCreatorCoinBuy
+)
+
+// txnGeneratorTypes are the types that GenerateRandomTxns picks from.
+var txnGeneratorTypes = []TxnGeneratorType{
+	TxnGeneratorBasicTransfer,
+	TxnGeneratorFollow,
+	TxnGeneratorPost,
+	TxnGeneratorCreatorCoinBuy,
+}
+
+func (txnType TxnGeneratorType) String() string {
+	switch txnType {
+	case TxnGeneratorBasicTransfer:
+		return "BasicTransfer"
+	case TxnGeneratorFollow:
+		return "Follow"
+	case TxnGeneratorPost:
+		return "Post"
+	case TxnGeneratorCreatorCoinBuy:
+		return "CreatorCoinBuy"
+	default:
+		return fmt.Sprintf("TxnGeneratorType(%d)", int(txnType))
+	}
+}
+
+// TxnGeneratorConfig configures a TxnGenerator.
+type TxnGeneratorConfig struct {
+	// NumKeys is the number of keys that the generated transactions are sent from.
+	NumKeys int
+	// FundingNanos is the amount that the regtest miner sends to each key, which pays for the transactions sent from
+	// the key. The miner must have NumKeys * FundingNanos, plus fees, e.g. from blocks mined with mineBlocks.
+	FundingNanos uint64
+	// Seed seeds the keys and the generated transactions, so that a run can be replayed. A zero Seed derives the seed
+	// from the test's TestRand.
+	Seed int64
+}
+
+// txnGeneratorKey is a keypair that the TxnGenerator sends transactions from. Every key has a profile, so that other
+// keys can follow it and buy its creator coin.
+type txnGeneratorKey struct {
+	privateKey     *btcec.PrivateKey
+	publicKeyBytes []byte
+}
+
+// TxnGenerator generates valid, signed transactions, and submits them to a regtest node's mempool, from where they
+// are relayed to the node's peers. This fills the mempools and blocks of test nodes with a mix of transaction types,
+// rather than with basic transfers only. The transactions are sent from keys derived from the seed, which are funded
+// by the regtest miner when the generator is created. The same seed generates the same sequence of transactions,
+// although their hashes differ between runs, since the nonces of balance model transactions are random.
+type TxnGenerator struct {
+	t    testing.TB
+	node *cmd.Node
+
+	seed int64
+	rng  *rand.Rand
+	keys []*txnGeneratorKey
+	// follows tracks which keys follow which, since a key can't follow another key twice.
+	follows map[[2]int]bool
+}
+
+// NewTxnGenerator creates a generator for the regtest node, and submits the transactions that fund its keys and
+// create their profiles. These transactions need to be mined with the generated transactions, or before them.
+func NewTxnGenerator(t testing.TB, node *cmd.Node, config TxnGeneratorConfig) *TxnGenerator {
+	if !node.Config.Regtest {
+		t.Fatalf("NewTxnGenerator: Node must be in regtest")
+	}
+	if config.NumKeys < 2 {
+		t.Fatalf("NewTxnGenerator: NumKeys must be at least 2, got (%v)", config.NumKeys)
+	}
+
+	seed := config.Seed
+	if seed == 0 {
+		seed = getTestRand(t).Int63()
+	}
+	fmt.Printf("TxnGenerator: Generating with seed (%v)\n", seed)
+
+	generator := &TxnGenerator{
+		t:       t,
+		node:    node,
+		seed:    seed,
+		rng:     rand.New(rand.NewSource(seed)),
+		follows: make(map[[2]int]bool),
+	}
+	for ii := 0; ii < config.NumKeys; ii++ {
+		privateKeyBytes := make([]byte, btcec.PrivKeyBytesLen)
+		generator.rng.Read(privateKeyBytes)
+		privateKey, publicKey := btcec.PrivKeyFromBytes(btcec.S256(), privateKeyBytes)
+		generator.keys = append(generator.keys, &txnGeneratorKey{
+			privateKey:     privateKey,
+			publicKeyBytes: publicKey.SerializeCompressed(),
+		})
+	}
+
+	minerPrivateKeyBytes, _, err := lib.Base58CheckDecode(regtestMinerPrivateKey)
+	if err != nil {
+		t.Fatalf("NewTxnGenerator: Problem decoding miner private key: %v", err)
+	}
+	minerPrivateKey, minerPublicKey := btcec.PrivKeyFromBytes(btcec.S256(), minerPrivateKeyBytes)
+	for ii, key := range generator.keys {
+		txn := &lib.MsgDeSoTxn{
+			TxInputs: []*lib.DeSoInput{},
+			TxOutputs: []*lib.DeSoOutput{{
+				PublicKey:   key.publicKeyBytes,
+				AmountNanos: config.FundingNanos,
+			}},
+			PublicKey: minerPublicKey.SerializeCompressed(),
+			TxnMeta:   &lib.BasicTransferMetadata{},
+		}
+		_, _, _, _, err := node.Server.GetBlockchain().AddInputsAndChangeToTransaction(txn, node.Config.MinFeerate,
+			node.Server.GetMempool())
+		if err != nil {
+			t.Fatalf("NewTxnGenerator: Problem funding key (%v): %v", ii, err)
+		}
+		if err := signAndBroadcastTxn(node, txn, minerPrivateKey); err != nil {
+			t.Fatalf("NewTxnGenerator: Problem funding key (%v): %v", ii, err)
+		}
+	}
+
+	// Usernames must be unique, so they include the seed.
+	for ii, key := range generator.keys {
+		username := fmt.Sprintf("gen_%x_%d", uint32(seed), ii)
+		txn, _, _, _, err := node.Server.GetBlockchain().CreateUpdateProfileTxn(key.publicKeyBytes, nil, username,
+			"", "", 1000, 12500, false, 0, nil, node.Config.MinFeerate, node.Server.GetMempool(), nil)
+		if err != nil {
+			t.Fatalf("NewTxnGenerator: Problem creating profile for key (%v): %v", ii, err)
+		}
+		if err := signAndBroadcastTxn(node, txn, key.privateKey); err != nil {
+			t.Fatalf("NewTxnGenerator: Problem creating profile for key (%v): %v", ii, err)
+		}
+	}
+	return generator
+}
+
+// Seed returns the seed of the generator.
+func (generator *TxnGenerator) Seed() int64 {
+	return generator.seed
+}
+
+// GenerateRandomTxns generates n transactions of random types, submits them to the node, and returns their hashes in
+// the order they were submitted.
+func (generator *TxnGenerator) GenerateRandomTxns(n int) []*lib.BlockHash {
+	var hashes []*lib.BlockHash
+	for ii := 0; ii < n; ii++ {
+		txnType := txnGeneratorTypes[generator.rng.Intn(len(txnGeneratorTypes))]
+		hashes = append(hashes, generator.generateTxn(txnType))
+	}
+	return hashes
+}
+
+// GenerateTxns generates n transactions of the provided type, submits them to the node, and returns their hashes in
+// the order they were submitted.
+func (generator *TxnGenerator) GenerateTxns(txnType TxnGeneratorType, n int) []*lib.BlockHash {
+	var hashes []*lib.BlockHash
+	for ii := 0; ii < n; ii++ {
+		hashes = append(hashes, generator.generateTxn(txnType))
+	}
+	return hashes
+}
+
+func (generator *TxnGenerator) generateTxn(txnType TxnGeneratorType) *lib.BlockHash {
+	bc := generator.node.Server.GetBlockchain()
+	mempool := generator.node.Server.GetMempool()
+	minFeerate := generator.node.Config.MinFeerate
+
+	senderIndex := generator.rng.Intn(len(generator.keys))
+	sender := generator.keys[senderIndex]
+	otherIndex := generator.randomOtherKeyIndex(senderIndex)
+	other := generator.keys[otherIndex]
+
+	var txn *lib.MsgDeSoTxn
+	var err error
+	switch txnType {
+	case TxnGeneratorBasicTransfer:
+		txn = &lib.MsgDeSoTxn{
+			TxInputs: []*lib.DeSoInput{},
+			TxOutputs: []*lib.DeSoOutput{{
+				PublicKey:   other.publicKeyBytes,
+				AmountNanos: uint64(1 + generator.rng.Intn(1000)),
+			}},
+			PublicKey: sender.publicKeyBytes,
+			TxnMeta:   &lib.BasicTransferMetadata{},
+		}
+		_, _, _, _, err = bc.AddInputsAndChangeToTransaction(txn, minFeerate, mempool)
+	case TxnGeneratorFollow:
+		// Unfollow if the sender already follows the other key.
+		follow := [2]int{senderIndex, otherIndex}
+		isUnfollow := generator.follows[follow]
+		txn, _, _, _, err = bc.CreateFollowTxn(sender.publicKeyBytes, other.publicKeyBytes, isUnfollow, minFeerate,
+			mempool, nil)
+		if err == nil {
+			generator.follows[follow] = !isUnfollow
+		}
+	case TxnGeneratorPost:
+		var body []byte
+		body, err = json.Marshal(&lib.DeSoBodySchema{Body: fmt.Sprintf("Post %d", generator.rng.Int63())})
+		if err != nil {
+			break
+		}
+		txn, _, _, _, err = bc.CreateSubmitPostTxn(sender.publicKeyBytes, nil, nil, body, nil, false,
+			uint64(time.Now().UnixNano()), nil, false, minFeerate, mempool, nil)
+	case TxnGeneratorCreatorCoinBuy:
+		txn, _, _, _, err = bc.CreateCreatorCoinTxn(sender.publicKeyBytes, other.publicKeyBytes,
+			lib.CreatorCoinOperationTypeBuy, uint64(1000+generator.rng.Intn(10000)), 0, 0, 0, 0, minFeerate,
+			mempool, nil)
+	default:
+		generator.t.Fatalf("TxnGenerator: Unknown transaction type (%v)", txnType)
+	}
+	if err != nil {
+		generator.t.Fatalf("TxnGenerator: Problem creating (%v) transaction with seed (%v): %v", txnType,
+			generator.seed, err)
+	}
+	if err := signAndBroadcastTxn(generator.node, txn, sender.privateKey); err != nil {
+		generator.t.Fatalf("TxnGenerator: Problem submitting (%v) transaction with seed (%v): %v", txnType,
+			generator.seed, err)
+	}
+	return txn.Hash()
+}
+
+// randomOtherKeyIndex returns the index of a random key other than the key at index.
+func (generator *TxnGenerator) randomOtherKeyIndex(index int) int {
+	otherIndex := generator.rng.Intn(len(generator.keys) - 1)
+	if otherIndex >= index {
+		otherIndex++
+	}
+	return otherIndex
+}
+
+// signAndBroadcastTxn signs the transaction with the private key, and submits it to the node, which adds it to its
+// mempool and relays it to its peers.
+func signAndBroadcastTxn(node *cmd.Node, txn *lib.MsgDeSoTxn, privateKey *btcec.PrivateKey) error {
+	signature, err := txn.Sign(privateKey)
+	if err != nil {
+		return err
+	}
+	txn.Signature.SetSignature(signature)
+	return node.Server.VerifyAndBroadcastTransaction(txn)
+}
@@ -0,0 +1,44 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+// TestRegtestTxnGenerator test if two nodes converge on the same state after mining generated transactions:
+//  1. Spawn two regtest nodes node1, node2, and bridge them together.
+//  2. mine a few blocks on node1 to fund the miner, and create a TxnGenerator on node1.
+//  3. generate 500 random transactions, and mine blocks on node1 until they're all confirmed.
+//  4. wait for node2 to sync the blocks, and compare node1 and node2 dbs match.
+func TestRegtestTxnGenerator(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	nodes := spawnNodeCluster(t, 2, WithRegtest()).Nodes()
+	node1, node2 := nodes[0], nodes[1]
+
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+
+	mineBlocks(t, node1, 3)
+	generator := NewTxnGenerator(t, node1, TxnGeneratorConfig{
+		NumKeys:      10,
+		FundingNanos: lib.NanosPerUnit / 100,
+	})
+	hashes := generator.GenerateRandomTxns(500)
+	for node1.Server.GetMempool().Count() > 0 {
+		mineBlocks(t, node1, 1)
+	}
+	for _, hash := range hashes {
+		waitForTxnConfirmed(t, node1, hash, 1)
+	}
+
+	waitForNodesToConverge(t, nodes, time.Minute)
+	compareNodesByDB(t, node1, node2, Summary)
+	fmt.Println("Databases match! Seed was", generator.Seed())
+	node1.Stop()
+	node2.Stop()
+}
@@ -0,0 +1,112 @@
+package integration_testing
+
+import (
+	"fmt"
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestRegtestTxnRelay test if a transaction is relayed across a line of nodes:
+//  1. Spawn three in-memory regtest nodes node1, node2, node3, and bridge them in a line node1 - node2 - node3.
+//  2. mine a few blocks on node1 to fund the miner, and wait for all nodes to sync them.
+//  3. submit a transfer on node1, and wait for it to arrive in node3's mempool.
+//  4. the transaction should have been relayed over both bridges, i.e. it took two hops, since node1 and node3 aren't
+//     connected.
+func TestRegtestTxnRelay(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	nodes := spawnNodeCluster(t, 3, WithRegtest(), WithInMemory()).Nodes()
+	node1, node2, node3 := nodes[0], nodes[1], nodes[2]
+
+	bridge12 := NewConnectionBridge(node1, node2)
+	require.NoError(bridge12.Start())
+	bridge23 := NewConnectionBridge(node2, node3)
+	require.NoError(bridge23.Start())
+
+	mineBlocks(t, node1, 3)
+	waitForNodesToConverge(t, nodes, time.Minute)
+
+	txn := submitBasicTransfer(t, node1, 1000)
+	waitForTxnInMempool(t, node3, txn.Hash())
+	waitForMempoolTxnCount(t, node2, 1)
+	fmt.Println("Transaction relayed!")
+
+	require.Greater(transactionBundlesSent(bridge12.Stats(), DirectionAToB), uint64(0))
+	require.Greater(transactionBundlesSent(bridge23.Stats(), DirectionAToB), uint64(0))
+	node1.Stop()
+	node2.Stop()
+	node3.Stop()
+}
+
+// transactionBundlesSent returns the number of transaction bundles, of either version, sent in the direction.
+func transactionBundlesSent(stats BridgeStats, direction Direction) uint64 {
+	return stats.Get(direction, lib.MsgTypeTransactionBundle).Count +
+		stats.Get(direction, lib.MsgTypeTransactionBundleV2).Count
+}
+
+// TestRegtestTxnConfirmation test if a submitted transaction gets mined and updates the state:
+//  1. Spawn a regtest node, and mine a few blocks to fund the miner.
+//  2. submit a transfer to the recipient, and mine three more blocks, the first of which should include the transfer.
+//  3. wait for the transfer to get three confirmations.
+//  4. the recipient's balance in the state db should equal the transferred amount.
+func TestRegtestTxnConfirmation(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir := getDirectory(t)
+	defer os.RemoveAll(dbDir)
+
+	node := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir, 10)))
+	mineBlocks(t, node, 3)
+
+	const amountNanos = 1000
+	txn := submitBasicTransfer(t, node, amountNanos)
+	blocks := mineBlocks(t, node, 3)
+	// The transfer is drained from the mempool into the first mined block, after the block reward.
+	require.Len(blocks, 3)
+	require.Len(blocks[0].Txns, 2)
+	require.Equal(*txn.Hash(), *blocks[0].Txns[1].Hash())
+	require.Equal(0, node.Server.GetMempool().Count())
+	waitForTxnConfirmed(t, node, txn.Hash(), 3)
+
+	utxoView, err := lib.NewUtxoView(node.Server.GetBlockchain().DB(), node.Params, nil,
+		node.Server.GetBlockchain().Snapshot())
+	require.NoError(err)
+	recipientPkBytes, _, err := lib.Base58CheckDecode(regtestRecipientPublicKey)
+	require.NoError(err)
+	balance, err := utxoView.GetDeSoBalanceNanosForPublicKey(recipientPkBytes)
+	require.NoError(err)
+	require.Equal(uint64(amountNanos), balance)
+	node.Stop()
+}
+
+// TestRegtestMempoolRelay test if a batch of transactions relays into an identical mempool:
+//  1. Spawn two in-memory regtest nodes node1, node2, bridge them together, and mine a few blocks on node1 to fund the
+//     miner.
+//  2. submit 100 transfers on node1.
+//  3. node2's mempool should eventually hold the same 100 transactions as node1's mempool.
+func TestRegtestMempoolRelay(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	node1 := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest(), WithInMemory())))
+	node2 := startNode(t, cmd.NewNode(NewTestConfig(t, WithRegtest(), WithInMemory())))
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+	mineBlocks(t, node1, 3)
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+
+	const numTxns = 100
+	for ii := 0; ii < numTxns; ii++ {
+		submitBasicTransfer(t, node1, uint64(1000+ii))
+	}
+	compareNodesByMempoolWithTolerance(t, node1, node2, time.Minute)
+	require.Len(node2.Server.GetMempoolSnapshot(), numTxns)
+	node1.Stop()
+	node2.Stop()
+}
@@ -0,0 +1,139 @@
+package integration_testing
+
+import (
+	"github.com/deso-protocol/core/cmd"
+	"github.com/deso-protocol/core/lib"
+	"github.com/stretchr/testify/require"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestWaitForSyncTimesOut test if waiting for a node that can't sync fails with a useful error instead of hanging:
+//  1. Spawn a node without any peers, so that it can never become fully current.
+//  2. wait for the node to sync with a short timeout.
+//  3. the wait should time out, and the error should describe the node's sync state.
+func TestWaitForSyncTimesOut(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir := getDirectory(t)
+	defer os.RemoveAll(dbDir)
+
+	config := generateConfig(t, dbDir, 10)
+	node := startNode(t, cmd.NewNode(config))
+
+	startTime := time.Now()
+	err := waitForSyncCondition(node, 3*time.Second, func() bool {
+		return node.Server.GetBlockchain().ChainState() == lib.SyncStateFullyCurrent
+	})
+	require.Error(err)
+	require.Less(time.Since(startTime), 10*time.Second)
+	require.Contains(err.Error(), "ChainState")
+	require.Contains(err.Error(), "block tip height (0)")
+	node.Stop()
+}
+
+// TestRegtestBlockConnectedEvents test if tests can wait on chain events directly:
+//  1. Spawn two regtest nodes, and subscribe to the blocks connected by node2.
+//  2. bridge the nodes together, and mine a few blocks on node1.
+//  3. node2 should fire a block connected event for every mined block, in order.
+func TestRegtestBlockConnectedEvents(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	node1 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir1, 10)))
+	node2 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir2, 10)))
+
+	blocks, unsubscribe := node2.Server.GetBlockchain().SubscribeBlockConnected()
+	defer unsubscribe()
+	startHeight := node2.Server.GetBlockchain().BlockTip().Height
+
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+	const numBlocks = 5
+	mineBlocks(t, node1, numBlocks)
+
+	for ii := uint32(1); ii <= numBlocks; ii++ {
+		select {
+		case event := <-blocks:
+			require.Equal(uint64(startHeight+ii), event.Block.Header.Height)
+		case <-time.After(time.Minute):
+			t.Fatalf("node2 didn't connect block at height (%v)", startHeight+ii)
+		}
+	}
+	waitForNodesToConverge(t, []*cmd.Node{node1, node2}, time.Minute)
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestRegtestPeerCountListeners test if the peer listeners follow bridges connecting and disconnecting:
+//  1. Spawn two regtest nodes node1, node2, and bridge them together.
+//  2. wait for both nodes to register the other as a peer.
+//  3. disconnect the bridge, and wait for node2 to drop the peer.
+func TestRegtestPeerCountListeners(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir1 := getDirectory(t)
+	dbDir2 := getDirectory(t)
+	defer os.RemoveAll(dbDir1)
+	defer os.RemoveAll(dbDir2)
+
+	node1 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir1, 10)))
+	node2 := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir2, 10)))
+
+	bridge := NewConnectionBridge(node1, node2)
+	require.NoError(bridge.Start())
+	waitForPeerCount(t, node1, 1)
+	waitForPeerCount(t, node2, 1)
+
+	peers := node2.Server.GetConnectionManager().GetAllPeers()
+	require.Len(peers, 1)
+	peerAddr := peers[0].Address()
+
+	bridge.Disconnect()
+	waitForPeerDisconnect(t, node2, peerAddr)
+	require.Equal(0, node2.Server.GetConnectionManager().NumConnectedPeers())
+	node1.Stop()
+	node2.Stop()
+}
+
+// TestRegtestStrictSyncMonitor test if the checks of waitForNodeToFullySyncStrict catch a rewound block tip:
+//  1. Spawn a regtest node, and mine a few blocks on it.
+//  2. start a strict monitor, and a monitor that allows reorgs of 2 blocks.
+//  3. disconnect the 2 most recent blocks.
+//  4. the strict monitor should report the disconnect, while the other monitor should accept it.
+func TestRegtestStrictSyncMonitor(t *testing.T) {
+	require := require.New(t)
+	_ = require
+
+	dbDir := getDirectory(t)
+	defer os.RemoveAll(dbDir)
+
+	node := startNode(t, cmd.NewNode(generateRegtestConfig(t, dbDir, 10)))
+	mineBlocks(t, node, 5)
+
+	strictMonitor := newStrictSyncMonitor(node)
+	defer strictMonitor.stop()
+	reorgMonitor := newStrictSyncMonitor(node, AllowReorgs(2))
+	defer reorgMonitor.stop()
+	require.NoError(strictMonitor.check())
+	require.NoError(reorgMonitor.check())
+
+	chain := node.Server.GetBlockchain()
+	tipHeight := chain.BlockTip().Height
+	require.NoError(chain.DisconnectBlocksToHeight(uint64(tipHeight-2), chain.Snapshot()))
+	require.Equal(tipHeight-2, chain.BlockTip().Height)
+
+	err := strictMonitor.check()
+	require.Error(err)
+	require.Contains(err.Error(), "was disconnected")
+	require.NoError(reorgMonitor.check())
+	node.Stop()
+}
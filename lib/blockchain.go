@@ -12,6 +12,7 @@ import (
 	"runtime/debug"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	btcdchain "github.com/btcsuite/btcd/blockchain"
@@ -419,6 +420,11 @@ type Blockchain struct {
 	// syncing node to re-run hypersync, which is a tiny overhead. Moreover, we are moving away from
 	// utxoops overall. They'll only be needed close to the tip to handle reorgs and nowhere else.
 	archivalMode bool
+	// pruneBlocksOlderThan is the number of most recent main chain blocks whose bodies are kept, or 0 if all blocks are
+	// kept, see SetPruneBlocksOlderThan. prunedBlocksHeight is the height up to which no bodies are stored, either
+	// because they were pruned, or because the node hypersynced past them.
+	pruneBlocksOlderThan uint32
+	prunedBlocksHeight   uint32
 	// Returns true once all of the housekeeping in creating the
 	// blockchain is complete. This includes setting up the genesis block.
 	isInitialized bool
@@ -455,6 +461,17 @@ type Blockchain struct {
 	syncingState                bool
 	downloadingHistoricalBlocks bool
 
+	// Channels handed out by SubscribeHeaderConnected, SubscribeBlockConnected, SubscribeBlockDisconnected and
+	// SubscribeChainState. The last published chain state is tracked so that chain state subscribers are only notified
+	// about changes.
+	headerConnectedSubscriptions   subscriptionList[*MsgDeSoHeader]
+	blockConnectedSubscriptions    subscriptionList[*BlockEvent]
+	blockDisconnectedSubscriptions subscriptionList[*BlockEvent]
+	chainStateSubscriptions        subscriptionList[SyncState]
+	chainStateLock                 sync.Mutex
+	lastChainState                 SyncState
+	chainStatePublished            bool
+
 	timer *Timer
 }
 
@@ -501,6 +518,106 @@ func (bc *Blockchain) IsFullyStored() bool {
 	return false
 }
 
+// SetPruneBlocksOlderThan makes the blockchain keep the bodies of the last pruneBlocksOlderThan main chain blocks
+// only, and delete the bodies of older blocks as new blocks bury them, or keep all bodies if it's 0. The headers, the
+// block nodes, and the state of pruned blocks are kept, so the node validates and serves new blocks as before, but
+// pruned blocks lose StatusBlockStored, so IsFullyStored reports false, and the node can't serve them to its peers.
+// The node can't disconnect pruned blocks either, so it can't follow a reorg deeper than pruneBlocksOlderThan
+// blocks. The genesis block is never pruned. It must be called before the blockchain processes blocks, and it isn't
+// compatible with archival mode, or with Postgres. Pruning can be turned on for a blockchain that already stores many
+// blocks, in which case they're pruned in batches along with the next block.
+func (bc *Blockchain) SetPruneBlocksOlderThan(pruneBlocksOlderThan uint32) {
+	bc.ChainLock.Lock()
+	defer bc.ChainLock.Unlock()
+	bc.pruneBlocksOlderThan = pruneBlocksOlderThan
+	// The pruned blocks lose StatusBlockStored in the db, so the height up to which they were pruned on a previous run
+	// is the height of the last main chain block before the first stored one.
+	bc.prunedBlocksHeight = 0
+	for height := 1; height < len(bc.bestChain); height++ {
+		if bc.bestChain[height].Status&StatusBlockStored != 0 {
+			break
+		}
+		bc.prunedBlocksHeight = uint32(height)
+	}
+}
+
+// IsPrunedBlock returns true if the block is on the main chain of a pruning blockchain, but its body was pruned,
+// see SetPruneBlocksOlderThan.
+func (bc *Blockchain) IsPrunedBlock(blockHash *BlockHash) bool {
+	bc.ChainLock.RLock()
+	defer bc.ChainLock.RUnlock()
+	blockNode, exists := bc.bestChainMap[*blockHash]
+	return exists && bc.isPrunedBlockNode(blockNode)
+}
+
+// isPrunedBlockNode returns true if the blockchain is pruning, and the block was validated but its body isn't stored.
+// This function MUST be called with the ChainLock held (for reads).
+func (bc *Blockchain) isPrunedBlockNode(blockNode *BlockNode) bool {
+	return bc.pruneBlocksOlderThan > 0 &&
+		blockNode.Status&StatusBlockValidated != 0 &&
+		blockNode.Status&StatusBlockStored == 0
+}
+
+// pruneBlocksBatchSize is the maximum number of blocks that pruneBlocks deletes in a single transaction, which keeps
+// the transaction below badger's size limit when pruning is turned on for a blockchain that stores many blocks.
+const pruneBlocksBatchSize = 1000
+
+// pruneBlocks deletes the bodies of the main chain blocks that are more than pruneBlocksOlderThan blocks below the
+// tip, and clears their StatusBlockStored. Blocks are pruned in batches of pruneBlocksBatchSize blocks, one
+// transaction per batch, so that a failure leaves the blocks of the failed batch stored, to be pruned along with the
+// next block.
+// This function MUST be called with the ChainLock held.
+func (bc *Blockchain) pruneBlocks() error {
+	tipHeight := bc.blockTip().Height
+	if tipHeight <= bc.pruneBlocksOlderThan {
+		return nil
+	}
+	pruneHeight := tipHeight - bc.pruneBlocksOlderThan
+	for bc.prunedBlocksHeight < pruneHeight {
+		batchHeight := pruneHeight
+		if batchHeight-bc.prunedBlocksHeight > pruneBlocksBatchSize {
+			batchHeight = bc.prunedBlocksHeight + pruneBlocksBatchSize
+		}
+		if err := bc.pruneBlocksBatch(batchHeight); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneBlocksBatch prunes the main chain blocks above prunedBlocksHeight, up to batchHeight, in a single transaction.
+// This function MUST be called with the ChainLock held.
+func (bc *Blockchain) pruneBlocksBatch(batchHeight uint32) error {
+	var prunedNodes []*BlockNode
+	err := bc.db.Update(func(txn *badger.Txn) error {
+		for height := bc.prunedBlocksHeight + 1; height <= batchHeight; height++ {
+			blockNode := bc.bestChain[height]
+			if blockNode.Status&StatusBlockStored == 0 {
+				continue
+			}
+			if err := DBDeleteWithTxn(txn, bc.snapshot, BlockHashToBlockKey(blockNode.Hash)); err != nil {
+				return errors.Wrapf(err, "pruneBlocks: Problem deleting block at height (%v)", height)
+			}
+			prunedNode := *blockNode
+			prunedNode.Status &^= StatusBlockStored
+			if err := PutHeightHashToNodeInfoWithTxn(txn, bc.snapshot, &prunedNode, false /*bitcoinNodes*/); err != nil {
+				return errors.Wrapf(err, "pruneBlocks: Problem putting node info at height (%v)", height)
+			}
+			prunedNodes = append(prunedNodes, blockNode)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, blockNode := range prunedNodes {
+		blockNode.Status &^= StatusBlockStored
+	}
+	glog.V(1).Infof("pruneBlocks: Pruned (%v) blocks up to height (%v)", len(prunedNodes), batchHeight)
+	bc.prunedBlocksHeight = batchHeight
+	return nil
+}
+
 // _initChain initializes the in-memory data structures for the Blockchain object
 // by reading from the database. If the database has never been initialized before
 // then _initChain will initialize it to contain only the genesis block before
@@ -1121,6 +1238,11 @@ func (ss SyncState) String() string {
 	}
 }
 
+// MarshalText encodes the sync state as its name, e.g. so that it's readable in JSON.
+func (ss SyncState) MarshalText() ([]byte, error) {
+	return []byte(ss.String()), nil
+}
+
 //   - Latest block height is after the latest checkpoint (if enabled)
 //   - Latest block has a timestamp newer than 24 hours ago
 //
@@ -1171,6 +1293,58 @@ func (bc *Blockchain) ChainState() SyncState {
 	return bc.chainState()
 }
 
+// SubscribeHeaderConnected returns a channel that receives every header that extends the best header chain from now
+// on. The channel is buffered, but publishing never blocks header processing, so a subscriber that falls far behind
+// misses the oldest headers. The returned function unsubscribes.
+func (bc *Blockchain) SubscribeHeaderConnected() (_headers <-chan *MsgDeSoHeader, _unsubscribe func()) {
+	return bc.headerConnectedSubscriptions.subscribe(1000)
+}
+
+// SubscribeBlockConnected returns a channel that receives an event for every block connected to the main chain from
+// now on, including blocks connected during reorgs. The channel is buffered, but publishing never blocks block
+// processing, so a subscriber that falls far behind misses the oldest events. The returned function unsubscribes.
+func (bc *Blockchain) SubscribeBlockConnected() (_events <-chan *BlockEvent, _unsubscribe func()) {
+	return bc.blockConnectedSubscriptions.subscribe(1000)
+}
+
+// SubscribeBlockDisconnected returns a channel that receives an event for every block disconnected from the main
+// chain from now on, either during a reorg or by DisconnectBlocksToHeight. Like SubscribeBlockConnected, a subscriber
+// that falls far behind misses the oldest events. The returned function unsubscribes.
+func (bc *Blockchain) SubscribeBlockDisconnected() (_events <-chan *BlockEvent, _unsubscribe func()) {
+	return bc.blockDisconnectedSubscriptions.subscribe(1000)
+}
+
+// SubscribeChainState returns a channel that receives the chain state whenever it changes, in order, so that even
+// short-lived states can be observed. A subscriber that falls far behind misses the oldest changes, but always
+// receives the most recent state. The returned function unsubscribes.
+func (bc *Blockchain) SubscribeChainState() (_states <-chan SyncState, _unsubscribe func()) {
+	return bc.chainStateSubscriptions.subscribe(100)
+}
+
+// publishBlockConnected notifies the block connected subscribers.
+func (bc *Blockchain) publishBlockConnected(event *BlockEvent) {
+	bc.blockConnectedSubscriptions.publish(event)
+}
+
+// publishBlockDisconnected notifies the block disconnected subscribers.
+func (bc *Blockchain) publishBlockDisconnected(event *BlockEvent) {
+	bc.blockDisconnectedSubscriptions.publish(event)
+}
+
+// publishChainState notifies the chain state subscribers if the chain state changed since the last notification.
+func (bc *Blockchain) publishChainState() {
+	chainState := bc.chainState()
+
+	bc.chainStateLock.Lock()
+	defer bc.chainStateLock.Unlock()
+	if bc.chainStatePublished && bc.lastChainState == chainState {
+		return
+	}
+	bc.lastChainState = chainState
+	bc.chainStatePublished = true
+	bc.chainStateSubscriptions.publish(chainState)
+}
+
 func (bc *Blockchain) isSyncing() bool {
 	syncState := bc.chainState()
 	return syncState == SyncStateSyncingHeaders || syncState == SyncStateSyncingBlocks ||
@@ -1198,8 +1372,8 @@ func (bc *Blockchain) checkArchivalMode() bool {
 
 		// Check if we have blocks that have been processed and validated but not stored. This would indicate that there
 		// are historical blocks that we are yet to download.
-		if (blockNode.Status&StatusBlockProcessed) == 1 &&
-			(blockNode.Status&StatusBlockValidated) == 1 &&
+		if (blockNode.Status&StatusBlockProcessed) != 0 &&
+			(blockNode.Status&StatusBlockValidated) != 0 &&
 			(blockNode.Status&StatusBlockStored) == 0 {
 
 			return true
@@ -1210,8 +1384,9 @@ func (bc *Blockchain) checkArchivalMode() bool {
 	return false
 }
 
-// isHyperSyncCondition checks if the block tip is more than a snapshot period away from the header tip. If that's the case
-// then it is likely faster to delete the entire database and HyperSync the state from scratch, rather than syncing blocks.
+// isHyperSyncCondition checks if the block tip is more than the node's snapshot period away from the header tip. If
+// that's the case then it is likely faster to delete the entire database and HyperSync the state from scratch, rather
+// than syncing blocks.
 func (bc *Blockchain) isHyperSyncCondition() bool {
 	// If HyperSync is turned off then there's nothing to do.
 	if bc.snapshot == nil {
@@ -1220,7 +1395,7 @@ func (bc *Blockchain) isHyperSyncCondition() bool {
 
 	blockTip := bc.blockTip()
 	headerTip := bc.headerTip()
-	if uint64(headerTip.Height-blockTip.Height) >= SnapshotBlockHeightPeriod {
+	if uint64(headerTip.Height-blockTip.Height) >= bc.snapshot.SnapshotBlockHeightPeriod {
 		return true
 	}
 	return false
@@ -1780,14 +1955,20 @@ func (bc *Blockchain) processHeader(blockHeader *MsgDeSoHeader, headerHash *Bloc
 func (bc *Blockchain) ProcessHeader(blockHeader *MsgDeSoHeader, headerHash *BlockHash) (_isMainChain bool, _isOrphan bool, _err error) {
 	bc.ChainLock.Lock()
 	defer bc.ChainLock.Unlock()
+	defer bc.publishChainState()
 
-	return bc.processHeader(blockHeader, headerHash)
+	isMainChain, isOrphan, err := bc.processHeader(blockHeader, headerHash)
+	if err == nil && isMainChain {
+		bc.headerConnectedSubscriptions.publish(blockHeader)
+	}
+	return isMainChain, isOrphan, err
 }
 
 func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures bool) (_isMainChain bool, _isOrphan bool, _err error) {
 	// TODO: Move this to be more isolated.
 	bc.ChainLock.Lock()
 	defer bc.ChainLock.Unlock()
+	defer bc.publishChainState()
 
 	blockHeight := uint64(bc.BlockTip().Height + 1)
 
@@ -1918,6 +2099,10 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 		glog.Infof("Node is not fully processed - current statuses are: %+v", nodeToValidate.Status)
 		return false, false, RuleErrorBlockAlreadyExists
 	}
+	// We had a pruned block already, and we don't want its body back.
+	if bc.isPrunedBlockNode(nodeToValidate) {
+		return false, false, RuleErrorBlockAlreadyExists
+	}
 
 	// At this point, because we know the block isn't an orphan, go ahead and mark
 	// it as processed. This flag is basically used to avoid situations in which we
@@ -2245,6 +2430,7 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 				UtxoOps:  utxoOpsForBlock,
 			})
 		}
+		bc.publishBlockConnected(&BlockEvent{Block: desoBlock})
 
 		bc.blockView = nil
 		bc.timer.End("Blockchain.ProcessBlock: Transactions Db end")
@@ -2503,6 +2689,7 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 				// For now it's fine because reorgs are virtually impossible.
 				bc.eventManager.blockDisconnected(&BlockEvent{Block: blockToDetach})
 			}
+			bc.publishBlockDisconnected(&BlockEvent{Block: blockToDetach})
 		}
 		for ii, attachNode := range attachBlocks {
 
@@ -2519,12 +2706,20 @@ func (bc *Blockchain) ProcessBlock(desoBlock *MsgDeSoBlock, verifySignatures boo
 				// For now it's fine because reorgs are virtually impossible.
 				bc.eventManager.blockConnected(&BlockEvent{Block: blockToAttach})
 			}
+			bc.publishBlockConnected(&BlockEvent{Block: blockToAttach})
 		}
 	}
 
 	if bc.snapshot != nil {
 		bc.snapshot.FinishProcessBlock(bc.blockTip())
 	}
+	// The new tip may have buried blocks beyond the retention window. Failing to prune them isn't fatal, they're pruned
+	// along with the next block instead.
+	if isMainChain && bc.pruneBlocksOlderThan > 0 {
+		if err := bc.pruneBlocks(); err != nil {
+			glog.Errorf("ProcessBlock: Problem pruning blocks: %v", err)
+		}
+	}
 	// If we've made it this far, the block has been validated and we have either added
 	// the block to the tip, done nothing with it (because its cumwork isn't high enough)
 	// or added it via a reorg and the db and our in-memory data structures reflect this
@@ -2596,6 +2791,7 @@ func (bc *Blockchain) DisconnectBlocksToHeight(blockHeight uint64, snap *Snapsho
 		prevHash := *bc.bestChain[ii-1].Hash
 		hash := *bc.bestChain[ii].Hash
 		height := uint64(bc.bestChain[ii].Height)
+		var blockToDetach *MsgDeSoBlock
 		err := bc.db.Update(func(txn *badger.Txn) error {
 
 			utxoView, err := NewUtxoView(bc.db, bc.params, bc.postgres, snap)
@@ -2614,7 +2810,7 @@ func (bc *Blockchain) DisconnectBlocksToHeight(blockHeight uint64, snap *Snapsho
 			}
 
 			// Compute the hashes for all the transactions.
-			blockToDetach, err := GetBlock(&hash, bc.db, snap)
+			blockToDetach, err = GetBlock(&hash, bc.db, snap)
 			if err != nil {
 				return err
 			}
@@ -2685,6 +2881,7 @@ func (bc *Blockchain) DisconnectBlocksToHeight(blockHeight uint64, snap *Snapsho
 
 		bc.bestChain = bc.bestChain[:len(bc.bestChain)-1]
 		delete(bc.bestChainMap, hash)
+		bc.publishBlockDisconnected(&BlockEvent{Block: blockToDetach})
 	}
 
 	// Remove blocks we've disconnected from the bestHeaderChain.
@@ -5,11 +5,11 @@ import (
 	"math"
 	"net"
 	"strconv"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/btcsuite/btcd/addrmgr"
-	chainlib "github.com/btcsuite/btcd/blockchain"
 	"github.com/btcsuite/btcd/wire"
 	"github.com/decred/dcrd/lru"
 	"github.com/deso-protocol/go-deadlock"
@@ -112,12 +112,15 @@ type ConnectionManager struct {
 
 	// Keeps track of the network time, which is the median of all of our
 	// peers' time.
-	timeSource chainlib.MedianTimeSource
+	timeSource TimeSource
 
 	// Events that can happen to a peer.
 	newPeerChan  chan *Peer
 	donePeerChan chan *Peer
 
+	// peerEventSubscriptions are the channels handed out by SubscribePeerEvents.
+	peerEventSubscriptions subscriptionList[PeerEvent]
+
 	// stallTimeoutSeconds is how long we wait to receive responses from Peers
 	// for certain types of messages.
 	stallTimeoutSeconds uint64
@@ -126,11 +129,14 @@ type ConnectionManager struct {
 
 	// More chans we might want.	modifyRebroadcastInv chan interface{}
 	shutdown int32
+	// listenersWaitGroup tracks the goroutines accepting inbound connections, which exit once the listeners are
+	// closed in Stop.
+	listenersWaitGroup sync.WaitGroup
 }
 
 func NewConnectionManager(
 	_params *DeSoParams, _addrMgr *addrmgr.AddrManager, _listeners []net.Listener,
-	_connectIps []string, _timeSource chainlib.MedianTimeSource,
+	_connectIps []string, _timeSource TimeSource,
 	_targetOutboundPeers uint32, _maxInboundPeers uint32,
 	_limitOneInboundConnectionPerIP bool,
 	_hyperSync bool,
@@ -138,9 +144,11 @@ func NewConnectionManager(
 	_stallTimeoutSeconds uint64,
 	_minFeeRateNanosPerKB uint64,
 	_serverMessageQueue chan *ServerMessage,
-	_srv *Server) *ConnectionManager {
+	_srv *Server) (*ConnectionManager, error) {
 
-	ValidateHyperSyncFlags(_hyperSync, _syncType)
+	if err := ValidateHyperSyncFlags(_hyperSync, _syncType); err != nil {
+		return nil, fmt.Errorf("NewConnectionManager: %v", err)
+	}
 
 	return &ConnectionManager{
 		srv:        _srv,
@@ -174,7 +182,7 @@ func NewConnectionManager(
 		serverMessageQueue:             _serverMessageQueue,
 		stallTimeoutSeconds:            _stallTimeoutSeconds,
 		minFeeRateNanosPerKB:           _minFeeRateNanosPerKB,
-	}
+	}, nil
 }
 
 func (cmgr *ConnectionManager) GetAddrManager() *addrmgr.AddrManager {
@@ -441,7 +449,7 @@ func (cmgr *ConnectionManager) ConnectPeer(conn net.Conn, persistentAddr *wire.N
 			cmgr.stallTimeoutSeconds,
 			cmgr.minFeeRateNanosPerKB,
 			cmgr.params,
-			cmgr.srv.incomingMessages, cmgr, cmgr.srv, cmgr.SyncType)
+			cmgr.srv.incomingMessages, cmgr, cmgr.srv, cmgr.SyncType, cmgr.timeSource)
 
 		if err := peer.NegotiateVersion(cmgr.params.VersionNegotiationTimeout); err != nil {
 			glog.Errorf("ConnectPeer: Problem negotiating version with peer with addr: (%s) err: (%v)", conn.RemoteAddr().String(), err)
@@ -561,7 +569,9 @@ func (cmgr *ConnectionManager) _isFromRedundantInboundIPAddress(addrToCheck net.
 
 func (cmgr *ConnectionManager) _handleInboundConnections() {
 	for _, outerListener := range cmgr.listeners {
+		cmgr.listenersWaitGroup.Add(1)
 		go func(ll net.Listener) {
+			defer cmgr.listenersWaitGroup.Done()
 			for {
 				conn, err := ll.Accept()
 				if conn == nil {
@@ -700,6 +710,7 @@ func (cmgr *ConnectionManager) addPeer(pp *Peer) {
 	}
 
 	peerList[pp.ID] = pp
+	cmgr.peerEventSubscriptions.publish(PeerEvent{Peer: pp, Connected: true})
 }
 
 // Update our data structures to remove this peer.
@@ -741,6 +752,27 @@ func (cmgr *ConnectionManager) RemovePeer(pp *Peer) {
 
 	// Remove the peer from our data structure.
 	delete(peerList, pp.ID)
+	cmgr.peerEventSubscriptions.publish(PeerEvent{Peer: pp, Connected: false})
+}
+
+// PeerEvent describes a peer being added to, or removed from, the ConnectionManager. Peers are only added once they
+// have completed version negotiation.
+type PeerEvent struct {
+	Peer      *Peer
+	Connected bool
+}
+
+// SubscribePeerEvents returns a channel that receives an event whenever a peer is added or removed from now on. A
+// subscriber that falls far behind misses the oldest events. The returned function unsubscribes.
+func (cmgr *ConnectionManager) SubscribePeerEvents() (_events <-chan PeerEvent, _unsubscribe func()) {
+	return cmgr.peerEventSubscriptions.subscribe(1000)
+}
+
+// NumConnectedPeers returns the number of peers that have completed version negotiation and haven't disconnected yet.
+func (cmgr *ConnectionManager) NumConnectedPeers() int {
+	cmgr.mtxPeerMaps.RLock()
+	defer cmgr.mtxPeerMaps.RUnlock()
+	return len(cmgr.persistentPeers) + len(cmgr.outboundPeers) + len(cmgr.inboundPeers)
 }
 
 func (cmgr *ConnectionManager) _maybeReplacePeer(pp *Peer) {
@@ -803,6 +835,22 @@ func (cmgr *ConnectionManager) Stop() {
 	}
 }
 
+// WaitForShutdown waits until the goroutines stopped by Stop have exited, i.e. the goroutines accepting inbound
+// connections, and the handlers of all peers. Once it returns, the listening ports are free, and no peer touches the
+// Server anymore. It returns an error if that takes longer than the timeout.
+func (cmgr *ConnectionManager) WaitForShutdown(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	if !WaitWithTimeout(&cmgr.listenersWaitGroup, timeout) {
+		return fmt.Errorf("ConnectionManager.WaitForShutdown: Listeners didn't close within (%v)", timeout)
+	}
+	for _, peer := range cmgr.GetAllPeers() {
+		if !peer.WaitForHandlers(time.Until(deadline)) {
+			return fmt.Errorf("ConnectionManager.WaitForShutdown: Peer (%v) didn't exit within (%v)", peer, timeout)
+		}
+	}
+	return nil
+}
+
 func (cmgr *ConnectionManager) Start() {
 	// Below is a basic description of the ConnectionManager's main loop:
 	//
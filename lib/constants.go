@@ -660,6 +660,115 @@ func (params *DeSoParams) EnableRegtest() {
 	params.EncoderMigrationHeightsList = GetEncoderMigrationHeightsList(&params.ForkHeights)
 }
 
+// NewDeSoRegtestParams returns a copy of the testnet params with regtest enabled, see EnableRegtest, for nodes that mine
+// their own blocks in tests. Regtest keeps the testnet genesis block, so a regtest chain uses the same genesis and
+// state prefixes as testnet. Unlike EnableRegtest, it also keeps the difficulty at the minimum, so all the nodes of a
+// regtest network must use these params. Every call returns a new deep copy, since a node modifies its params.
+func NewDeSoRegtestParams() *DeSoParams {
+	params := DeSoTestnetParams.Copy()
+	params.EnableRegtest()
+	// Blocks mined back to back get timestamps one second apart, which would otherwise make every retarget double the
+	// difficulty, until mining a block takes minutes.
+	params.MaxDifficultyRetargetFactor = 1
+	return params
+}
+
+// Copy returns a deep copy of the params, which doesn't share any slice, map, or pointer with them, so that modifying
+// the copy, e.g. with EnableRegtest, doesn't modify the params.
+func (params *DeSoParams) Copy() *DeSoParams {
+	paramsCopy := *params
+
+	if params.ExtraRegtestParamUpdaterKeys != nil {
+		paramsCopy.ExtraRegtestParamUpdaterKeys = make(map[PkMapKey]bool, len(params.ExtraRegtestParamUpdaterKeys))
+		for pkMapKey, isParamUpdater := range params.ExtraRegtestParamUpdaterKeys {
+			paramsCopy.ExtraRegtestParamUpdaterKeys[pkMapKey] = isParamUpdater
+		}
+	}
+	if params.DNSSeeds != nil {
+		paramsCopy.DNSSeeds = append([]string{}, params.DNSSeeds...)
+	}
+	if params.DNSSeedGenerators != nil {
+		paramsCopy.DNSSeedGenerators = make([][]string, len(params.DNSSeedGenerators))
+		for ii, generator := range params.DNSSeedGenerators {
+			paramsCopy.DNSSeedGenerators[ii] = append([]string{}, generator...)
+		}
+	}
+	if params.BitcoinBtcdParams != nil {
+		btcdParams := *params.BitcoinBtcdParams
+		paramsCopy.BitcoinBtcdParams = &btcdParams
+	}
+	if params.BitcoinStartBlockNode != nil {
+		paramsCopy.BitcoinStartBlockNode = copyBlockNode(params.BitcoinStartBlockNode)
+	}
+	if params.GenesisBlock != nil {
+		// The genesis block is copied through its encoding, which covers its header and transactions.
+		genesisBytes, err := params.GenesisBlock.ToBytes(false)
+		if err != nil {
+			panic(any(fmt.Sprintf("DeSoParams.Copy: Problem encoding genesis block: %v", err)))
+		}
+		genesisBlock := &MsgDeSoBlock{}
+		if err := genesisBlock.FromBytes(genesisBytes); err != nil {
+			panic(any(fmt.Sprintf("DeSoParams.Copy: Problem decoding genesis block: %v", err)))
+		}
+		paramsCopy.GenesisBlock = genesisBlock
+	}
+	if params.SeedTxns != nil {
+		paramsCopy.SeedTxns = append([]string{}, params.SeedTxns...)
+	}
+	if params.SeedBalances != nil {
+		paramsCopy.SeedBalances = make([]*DeSoOutput, len(params.SeedBalances))
+		for ii, seedBalance := range params.SeedBalances {
+			paramsCopy.SeedBalances[ii] = &DeSoOutput{
+				PublicKey:   append([]byte{}, seedBalance.PublicKey...),
+				AmountNanos: seedBalance.AmountNanos,
+			}
+		}
+	}
+	if params.CreatorCoinSlope != nil {
+		paramsCopy.CreatorCoinSlope = NewFloat().Copy(params.CreatorCoinSlope)
+	}
+	if params.CreatorCoinReserveRatio != nil {
+		paramsCopy.CreatorCoinReserveRatio = NewFloat().Copy(params.CreatorCoinReserveRatio)
+	}
+	if params.EncoderMigrationHeights != nil {
+		encoderMigrationHeights := *params.EncoderMigrationHeights
+		paramsCopy.EncoderMigrationHeights = &encoderMigrationHeights
+	}
+	if params.EncoderMigrationHeightsList != nil {
+		paramsCopy.EncoderMigrationHeightsList = make([]*MigrationHeight, len(params.EncoderMigrationHeightsList))
+		for ii, migrationHeight := range params.EncoderMigrationHeightsList {
+			migrationHeightCopy := *migrationHeight
+			paramsCopy.EncoderMigrationHeightsList[ii] = &migrationHeightCopy
+		}
+	}
+	return &paramsCopy
+}
+
+// copyBlockNode returns a copy of the block node with its own hashes, work, and header. The parent isn't copied.
+func copyBlockNode(blockNode *BlockNode) *BlockNode {
+	blockNodeCopy := *blockNode
+	if blockNode.Hash != nil {
+		blockNodeCopy.Hash = blockNode.Hash.NewBlockHash()
+	}
+	if blockNode.DifficultyTarget != nil {
+		blockNodeCopy.DifficultyTarget = blockNode.DifficultyTarget.NewBlockHash()
+	}
+	if blockNode.CumWork != nil {
+		blockNodeCopy.CumWork = new(big.Int).Set(blockNode.CumWork)
+	}
+	if blockNode.Header != nil {
+		header := *blockNode.Header
+		if header.PrevBlockHash != nil {
+			header.PrevBlockHash = header.PrevBlockHash.NewBlockHash()
+		}
+		if header.TransactionMerkleRoot != nil {
+			header.TransactionMerkleRoot = header.TransactionMerkleRoot.NewBlockHash()
+		}
+		blockNodeCopy.Header = &header
+	}
+	return &blockNodeCopy
+}
+
 // GenesisBlock defines the genesis block used for the DeSo mainnet and testnet
 var (
 	ArchitectPubKeyBase58Check = "BC1YLg3oh6Boj8e2boCo1vQCYHLk1rjsHF6jthBdvSw79bixQvKK6Qa"
@@ -2,6 +2,7 @@ package lib
 
 import (
 	"fmt"
+	"github.com/stretchr/testify/require"
 	"reflect"
 	"testing"
 )
@@ -47,3 +48,51 @@ func _verifyEncoderMigrationHeights(t *testing.T, migrationHeights *EncoderMigra
 		}
 	}
 }
+
+// regtestMaxForkHeight is the highest fork height of the regtest params, so that regtest chains mined in tests reach
+// every fork, and every encoder migration, within a few blocks.
+const regtestMaxForkHeight = 1
+
+func TestRegtestParams(t *testing.T) {
+	require := require.New(t)
+
+	params := NewDeSoRegtestParams()
+	require.Equal(RegtestForkHeights, params.ForkHeights)
+	forkHeights := reflect.ValueOf(params.ForkHeights)
+	for ii := 0; ii < forkHeights.NumField(); ii++ {
+		require.LessOrEqual(forkHeights.Field(ii).Convert(reflect.TypeOf(uint64(0))).Uint(),
+			uint64(regtestMaxForkHeight), forkHeights.Type().Field(ii).Name)
+	}
+	_verifyEncoderMigrationHeights(t, params.EncoderMigrationHeights)
+	require.Equal(GetEncoderMigrationHeightsList(&RegtestForkHeights), params.EncoderMigrationHeightsList)
+	for _, migration := range params.EncoderMigrationHeightsList {
+		require.LessOrEqual(migration.Height, uint64(regtestMaxForkHeight), migration.Name)
+	}
+	require.Equal(int64(1), params.MaxDifficultyRetargetFactor)
+	require.Equal(DeSoTestnetParams.GenesisBlockHashHex, params.GenesisBlockHashHex)
+
+	// Enabling regtest on the testnet params keeps the testnet difficulty rules.
+	enabledParams := DeSoTestnetParams.Copy()
+	enabledParams.EnableRegtest()
+	require.Equal(DeSoTestnetParams.MaxDifficultyRetargetFactor, enabledParams.MaxDifficultyRetargetFactor)
+	require.Equal(params.ForkHeights, enabledParams.ForkHeights)
+
+	// The regtest params don't share anything with the testnet params.
+	require.NotSame(DeSoTestnetParams.GenesisBlock, params.GenesisBlock)
+	require.NotSame(DeSoTestnetParams.GenesisBlock.Header, params.GenesisBlock.Header)
+	require.NotSame(DeSoTestnetParams.BitcoinStartBlockNode, params.BitcoinStartBlockNode)
+	require.NotSame(DeSoTestnetParams.CreatorCoinSlope, params.CreatorCoinSlope)
+	require.NotSame(DeSoTestnetParams.EncoderMigrationHeights, params.EncoderMigrationHeights)
+	genesisBlockHash, err := params.GenesisBlock.Hash()
+	require.NoError(err)
+	require.Equal(params.GenesisBlockHashHex, genesisBlockHash.String())
+
+	testnetSeedBalance := DeSoTestnetParams.SeedBalances[0].AmountNanos
+	testnetMigrationHeight := DeSoTestnetParams.EncoderMigrationHeightsList[0].Height
+	params.SeedBalances[0].AmountNanos++
+	params.EncoderMigrationHeightsList[0].Height++
+	params.GenesisBlock.Header.Nonce++
+	require.Equal(testnetSeedBalance, DeSoTestnetParams.SeedBalances[0].AmountNanos)
+	require.Equal(testnetMigrationHeight, DeSoTestnetParams.EncoderMigrationHeightsList[0].Height)
+	require.Equal(uint64(0), DeSoTestnetParams.GenesisBlock.Header.Nonce)
+}
@@ -0,0 +1,88 @@
+package lib
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DBFaultInjector makes the node's db writes fail on demand, which simulates I/O errors such as a full disk. It's
+// attached to the node's snapshot with Snapshot.SetFaultInjector, and checked before every write to the main db made
+// through DBSetWithTxn and DBDeleteWithTxn, and before every ancestral record write. A failed write aborts the badger
+// transaction it belongs to, so none of the transaction's writes are committed. Mainly used in testing.
+//
+// The zero value is a disarmed injector that's ready to use.
+type DBFaultInjector struct {
+	mtx sync.Mutex
+
+	// writesUntilFault counts down the writes until the next fault. Zero means no write fault is armed.
+	writesUntilFault uint64
+	// faultUntil is the end of the window during which all writes fail.
+	faultUntil time.Time
+	// faultErr is the error returned by the failed writes.
+	faultErr error
+
+	numFailedWrites uint64
+}
+
+// FailNthWrite makes the nth write from now fail with err. Only that write fails, later writes succeed again.
+func (injector *DBFaultInjector) FailNthWrite(n uint64, err error) {
+	injector.mtx.Lock()
+	defer injector.mtx.Unlock()
+
+	injector.writesUntilFault = n
+	injector.faultErr = err
+}
+
+// FailWritesFor makes all writes fail with err for the provided duration, e.g. syscall.ENOSPC to simulate a disk that
+// is full until some space is freed.
+func (injector *DBFaultInjector) FailWritesFor(duration time.Duration, err error) {
+	injector.mtx.Lock()
+	defer injector.mtx.Unlock()
+
+	injector.faultUntil = time.Now().Add(duration)
+	injector.faultErr = err
+}
+
+// Disarm cancels all armed faults.
+func (injector *DBFaultInjector) Disarm() {
+	injector.mtx.Lock()
+	defer injector.mtx.Unlock()
+
+	injector.writesUntilFault = 0
+	injector.faultUntil = time.Time{}
+}
+
+// NumFailedWrites returns the number of writes that failed due to injected faults.
+func (injector *DBFaultInjector) NumFailedWrites() uint64 {
+	injector.mtx.Lock()
+	defer injector.mtx.Unlock()
+
+	return injector.numFailedWrites
+}
+
+// checkWrite is called before every write, and returns an error if the write should fail.
+func (injector *DBFaultInjector) checkWrite() error {
+	injector.mtx.Lock()
+	defer injector.mtx.Unlock()
+
+	fail := false
+	if injector.writesUntilFault > 0 {
+		injector.writesUntilFault--
+		fail = injector.writesUntilFault == 0
+	}
+	if time.Now().Before(injector.faultUntil) {
+		fail = true
+	}
+	if !fail {
+		return nil
+	}
+
+	injector.numFailedWrites++
+	if injector.faultErr == nil {
+		return fmt.Errorf("DBFaultInjector: Injected fault on write")
+	}
+	return errors.Wrapf(injector.faultErr, "DBFaultInjector: Injected fault on write")
+}
@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDBFaultInjector(t *testing.T) {
+	require := require.New(t)
+
+	var injector DBFaultInjector
+	require.NoError(injector.checkWrite())
+
+	// Only the nth write fails.
+	injector.FailNthWrite(3, syscall.EIO)
+	require.NoError(injector.checkWrite())
+	require.NoError(injector.checkWrite())
+	require.True(errors.Is(injector.checkWrite(), syscall.EIO))
+	require.NoError(injector.checkWrite())
+
+	// All writes fail during the window, until disarmed.
+	injector.FailWritesFor(time.Hour, syscall.ENOSPC)
+	require.True(errors.Is(injector.checkWrite(), syscall.ENOSPC))
+	require.True(errors.Is(injector.checkWrite(), syscall.ENOSPC))
+	injector.Disarm()
+	require.NoError(injector.checkWrite())
+	require.Equal(uint64(3), injector.NumFailedWrites())
+}
+
+func TestDBFaultInjectorAbortsTransaction(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	injector := &DBFaultInjector{}
+	snap := &Snapshot{}
+	snap.SetFaultInjector(injector)
+	keyA := append(append([]byte{}, Prefixes.PrefixBlockHashToBlock...), 1)
+	keyB := append(append([]byte{}, Prefixes.PrefixBlockHashToBlock...), 2)
+
+	// The second write fails, so the first write shouldn't be committed either.
+	injector.FailNthWrite(2, syscall.ENOSPC)
+	err := db.Update(func(txn *badger.Txn) error {
+		if err := DBSetWithTxn(txn, snap, keyA, []byte{1}); err != nil {
+			return err
+		}
+		return DBSetWithTxn(txn, snap, keyB, []byte{2})
+	})
+	require.True(errors.Is(err, syscall.ENOSPC))
+	err = db.View(func(txn *badger.Txn) error {
+		_, err := txn.Get(keyA)
+		return err
+	})
+	require.Equal(badger.ErrKeyNotFound, err)
+}
@@ -871,6 +871,10 @@ func DBSetWithTxn(txn *badger.Txn, snap *Snapshot, key []byte, value []byte) err
 		}
 	}
 
+	if err := snap.checkWriteFault(); err != nil {
+		return errors.Wrapf(err, "DBSetWithTxn: Problem setting record in DB with key: %v", key)
+	}
+
 	// We update the DB record with the intended value.
 	err := txn.Set(key, value)
 	if err != nil {
@@ -965,6 +969,10 @@ func DBDeleteWithTxn(txn *badger.Txn, snap *Snapshot, key []byte) error {
 		}
 	}
 
+	if err := snap.checkWriteFault(); err != nil {
+		return errors.Wrapf(err, "DBDeleteWithTxn: Problem deleting record from DB with key: %v", key)
+	}
+
 	err := txn.Delete(key)
 	if err != nil {
 		return errors.Wrapf(err, "DBDeleteWithTxn: Problem deleting record "+
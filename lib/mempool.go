@@ -255,6 +255,16 @@ type DeSoMempool struct {
 	// We pass a copy of the data dir flag to the tx pool so that we can instantiate
 	// temp badger db instances and dump mempool txns to them.
 	dataDir string
+
+	// transactionAcceptedSubscriptions are the channels handed out by SubscribeTransactionAccepted.
+	transactionAcceptedSubscriptions subscriptionList[*MempoolTx]
+}
+
+// SubscribeTransactionAccepted returns a channel that receives every transaction accepted into the pool from now on,
+// but not the transactions that are re-added while the pool is updated after a block. A subscriber that falls far
+// behind misses the oldest transactions. The returned function unsubscribes.
+func (mp *DeSoMempool) SubscribeTransactionAccepted() (_txns <-chan *MempoolTx, _unsubscribe func()) {
+	return mp.transactionAcceptedSubscriptions.subscribe(1000)
 }
 
 // See comment on RemoveUnconnectedTxn. The mempool lock must be called for writing
@@ -1126,6 +1136,7 @@ func (mp *DeSoMempool) tryAcceptTransaction(
 
 	glog.V(2).Infof("tryAcceptTransaction: Accepted transaction %v (pool size: %v)", txHash,
 		len(mp.poolMap))
+	mp.transactionAcceptedSubscriptions.publish(mempoolTx)
 
 	return nil, mempoolTx, nil
 }
@@ -2299,6 +2310,14 @@ func (mp *DeSoMempool) Count() int {
 	return len(mp.readOnlyUniversalTransactionList)
 }
 
+// TotalTxSizeBytes returns the total size of the transactions in the pool.
+func (mp *DeSoMempool) TotalTxSizeBytes() uint64 {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	return mp.totalTxSizeBytes
+}
+
 // Returns the hashes of all the txns in the pool using the readOnly view, which could be
 // slightly out of date.
 func (mp *DeSoMempool) TxHashes() []*BlockHash {
@@ -2327,6 +2346,17 @@ func (mp *DeSoMempool) MempoolTxs() []*MempoolTx {
 	return descs
 }
 
+// PoolTxnsSnapshot returns the txns in the pool, ordered by when they were added. Unlike MempoolTxs, which reads the
+// readOnly view, it reads the pool under the read lock, so it includes every txn the pool has accepted so far. This
+// makes it suitable for comparing the mempools of nodes. Safe for concurrent access.
+func (mp *DeSoMempool) PoolTxnsSnapshot() []*MempoolTx {
+	mp.mtx.RLock()
+	defer mp.mtx.RUnlock()
+
+	poolTxns, _, _ := mp._getTransactionsOrderedByTimeAdded()
+	return poolTxns
+}
+
 func (mp *DeSoMempool) GetMempoolSummaryStats() (_summaryStatsMap map[string]*SummaryStats) {
 	allTxns := mp.readOnlyUniversalTransactionList
 
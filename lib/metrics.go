@@ -0,0 +1,254 @@
+package lib
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The node's metrics. Counters only go up while the node runs, gauges are set to the current value, and histograms
+// count observations into nodeMetricBuckets.
+const (
+	MetricBlockTipHeight          = "deso_block_tip_height"
+	MetricHeaderTipHeight         = "deso_header_tip_height"
+	MetricPeers                   = "deso_peers"
+	MetricMempoolTxns             = "deso_mempool_transactions"
+	MetricMempoolBytes            = "deso_mempool_bytes"
+	MetricHyperSyncReceivedBytes  = "deso_hypersync_received_bytes_total"
+	MetricBadgerLSMSizeBytes      = "deso_badger_lsm_size_bytes"
+	MetricBadgerValueLogSizeBytes = "deso_badger_vlog_size_bytes"
+	MetricBlockValidationSeconds  = "deso_block_validation_duration_seconds"
+	MetricMessages                = "deso_messages_total"
+)
+
+// MetricType is the Prometheus type of a metric.
+type MetricType string
+
+const (
+	MetricTypeCounter   MetricType = "counter"
+	MetricTypeGauge     MetricType = "gauge"
+	MetricTypeHistogram MetricType = "histogram"
+)
+
+type metricDescription struct {
+	metricType MetricType
+	help       string
+}
+
+var nodeMetricDescriptions = map[string]metricDescription{
+	MetricBlockTipHeight:  {MetricTypeGauge, "Height of the block tip."},
+	MetricHeaderTipHeight: {MetricTypeGauge, "Height of the header tip."},
+	MetricPeers:           {MetricTypeGauge, "Number of connected peers that completed version negotiation."},
+	MetricMempoolTxns:     {MetricTypeGauge, "Number of transactions in the mempool."},
+	MetricMempoolBytes:    {MetricTypeGauge, "Total size of the transactions in the mempool."},
+	MetricHyperSyncReceivedBytes: {MetricTypeCounter,
+		"Size of the keys and values received by hypersync, by hex encoded state prefix."},
+	MetricBadgerLSMSizeBytes:      {MetricTypeGauge, "Size of the LSM tree of the chain db."},
+	MetricBadgerValueLogSizeBytes: {MetricTypeGauge, "Size of the value log of the chain db."},
+	MetricBlockValidationSeconds:  {MetricTypeHistogram, "Time it took to validate and process a block from a peer."},
+	MetricMessages:                {MetricTypeCounter, "Number of messages sent to and received from peers, by type."},
+}
+
+// nodeMetricBuckets are the upper bounds of the histogram buckets, in seconds for durations.
+var nodeMetricBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// MetricsRecorder records metrics. The server's components record their metrics through it, so that a registry can
+// export them to Prometheus, and tests can read them. The labels are name and value pairs, e.g. "type", "BLOCK".
+type MetricsRecorder interface {
+	AddCounter(name string, delta float64, labels ...string)
+	SetGauge(name string, value float64, labels ...string)
+	ObserveHistogram(name string, value float64, labels ...string)
+}
+
+// noopMetricsRecorder drops the metrics. It's used until a server gets a recorder with SetMetricsRecorder.
+type noopMetricsRecorder struct{}
+
+func (noopMetricsRecorder) AddCounter(name string, delta float64, labels ...string)       {}
+func (noopMetricsRecorder) SetGauge(name string, value float64, labels ...string)         {}
+func (noopMetricsRecorder) ObserveHistogram(name string, value float64, labels ...string) {}
+
+// MetricsRegistry is a MetricsRecorder that keeps the metrics in memory, and writes them in the Prometheus text format
+// with WriteText. Gauges that are cheaper to read when scraped than to keep up to date, e.g. the tip height, can be
+// set by collectors, which run before the metrics are read.
+type MetricsRegistry struct {
+	mtx        sync.Mutex
+	series     map[string]*metricSeries
+	collectors []func(recorder MetricsRecorder)
+}
+
+type metricSeries struct {
+	name   string
+	labels string
+	value  float64
+	// bucketCounts and count are only used by histograms, in which case the value is the sum of the observations.
+	bucketCounts []uint64
+	count        uint64
+}
+
+// NewMetricsRegistry returns an empty registry.
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{series: make(map[string]*metricSeries)}
+}
+
+// AddCollector adds a collector, which sets gauges through the recorder whenever the metrics are read.
+func (registry *MetricsRegistry) AddCollector(collector func(recorder MetricsRecorder)) {
+	registry.mtx.Lock()
+	defer registry.mtx.Unlock()
+	registry.collectors = append(registry.collectors, collector)
+}
+
+func (registry *MetricsRegistry) AddCounter(name string, delta float64, labels ...string) {
+	registry.mtx.Lock()
+	defer registry.mtx.Unlock()
+	registry.getSeries(name, labels).value += delta
+}
+
+func (registry *MetricsRegistry) SetGauge(name string, value float64, labels ...string) {
+	registry.mtx.Lock()
+	defer registry.mtx.Unlock()
+	registry.getSeries(name, labels).value = value
+}
+
+func (registry *MetricsRegistry) ObserveHistogram(name string, value float64, labels ...string) {
+	registry.mtx.Lock()
+	defer registry.mtx.Unlock()
+	series := registry.getSeries(name, labels)
+	if series.bucketCounts == nil {
+		series.bucketCounts = make([]uint64, len(nodeMetricBuckets))
+	}
+	for ii, upperBound := range nodeMetricBuckets {
+		if value <= upperBound {
+			series.bucketCounts[ii]++
+		}
+	}
+	series.value += value
+	series.count++
+}
+
+// getSeries returns the series with the name and labels, and creates it if it doesn't exist. It must be called with
+// the lock held.
+func (registry *MetricsRegistry) getSeries(name string, labels []string) *metricSeries {
+	encodedLabels := encodeMetricLabels(labels)
+	key := name + encodedLabels
+	series, exists := registry.series[key]
+	if !exists {
+		series = &metricSeries{name: name, labels: encodedLabels}
+		registry.series[key] = series
+	}
+	return series
+}
+
+// collect runs the collectors.
+func (registry *MetricsRegistry) collect() {
+	registry.mtx.Lock()
+	collectors := append([]func(recorder MetricsRecorder){}, registry.collectors...)
+	registry.mtx.Unlock()
+	for _, collector := range collectors {
+		collector(registry)
+	}
+}
+
+// Value returns the value of the metric with the labels after running the collectors, which is the sum of the
+// observations for histograms, and whether the metric was recorded.
+func (registry *MetricsRegistry) Value(name string, labels ...string) (_value float64, _exists bool) {
+	registry.collect()
+	registry.mtx.Lock()
+	defer registry.mtx.Unlock()
+	series, exists := registry.series[name+encodeMetricLabels(labels)]
+	if !exists {
+		return 0, false
+	}
+	return series.value, true
+}
+
+// Sum returns the sum of the values of the metric over all its labels, e.g. the hypersync bytes of all prefixes.
+func (registry *MetricsRegistry) Sum(name string) float64 {
+	registry.collect()
+	registry.mtx.Lock()
+	defer registry.mtx.Unlock()
+	sum := 0.0
+	for _, series := range registry.series {
+		if series.name == name {
+			sum += series.value
+		}
+	}
+	return sum
+}
+
+// WriteText runs the collectors and writes the metrics in the Prometheus text exposition format.
+func (registry *MetricsRegistry) WriteText(writer io.Writer) error {
+	registry.collect()
+	registry.mtx.Lock()
+	defer registry.mtx.Unlock()
+
+	keys := make([]string, 0, len(registry.series))
+	for key := range registry.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	buffered := bufio.NewWriter(writer)
+	lastName := ""
+	for _, key := range keys {
+		series := registry.series[key]
+		if series.name != lastName {
+			lastName = series.name
+			description, exists := nodeMetricDescriptions[series.name]
+			if !exists {
+				description = metricDescription{metricType: "untyped"}
+			}
+			if description.help != "" {
+				fmt.Fprintf(buffered, "# HELP %v %v\n", series.name, description.help)
+			}
+			fmt.Fprintf(buffered, "# TYPE %v %v\n", series.name, description.metricType)
+		}
+		if series.bucketCounts == nil {
+			fmt.Fprintf(buffered, "%v%v %v\n", series.name, series.labels, formatMetricValue(series.value))
+			continue
+		}
+		for ii, upperBound := range nodeMetricBuckets {
+			fmt.Fprintf(buffered, "%v_bucket%v %v\n", series.name,
+				withMetricLabel(series.labels, "le", formatMetricValue(upperBound)), series.bucketCounts[ii])
+		}
+		fmt.Fprintf(buffered, "%v_bucket%v %v\n", series.name, withMetricLabel(series.labels, "le", "+Inf"),
+			series.count)
+		fmt.Fprintf(buffered, "%v_sum%v %v\n", series.name, series.labels, formatMetricValue(series.value))
+		fmt.Fprintf(buffered, "%v_count%v %v\n", series.name, series.labels, series.count)
+	}
+	return buffered.Flush()
+}
+
+// encodeMetricLabels encodes the label pairs as {name="value",...}, sorted by name, or as an empty string if there are
+// no labels. A trailing name without a value is ignored.
+func encodeMetricLabels(labels []string) string {
+	if len(labels) < 2 {
+		return ""
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for ii := 0; ii+1 < len(labels); ii += 2 {
+		pairs = append(pairs, fmt.Sprintf("%v=%v", labels[ii], strconv.Quote(labels[ii+1])))
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// withMetricLabel adds a label to the encoded labels.
+func withMetricLabel(encodedLabels string, name string, value string) string {
+	label := fmt.Sprintf("%v=%v", name, strconv.Quote(value))
+	if encodedLabels == "" {
+		return "{" + label + "}"
+	}
+	return strings.TrimSuffix(encodedLabels, "}") + "," + label + "}"
+}
+
+func formatMetricValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,54 @@
+package lib
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetricsRegistry(t *testing.T) {
+	require := require.New(t)
+
+	registry := NewMetricsRegistry()
+	tipHeight := 0.0
+	registry.AddCollector(func(recorder MetricsRecorder) {
+		recorder.SetGauge(MetricBlockTipHeight, tipHeight)
+	})
+
+	// Counters add up per label set, and Sum adds up all the label sets.
+	registry.AddCounter(MetricHyperSyncReceivedBytes, 100, "prefix", "01")
+	registry.AddCounter(MetricHyperSyncReceivedBytes, 50, "prefix", "01")
+	registry.AddCounter(MetricHyperSyncReceivedBytes, 25, "prefix", "02")
+	value, exists := registry.Value(MetricHyperSyncReceivedBytes, "prefix", "01")
+	require.True(exists)
+	require.Equal(float64(150), value)
+	require.Equal(float64(175), registry.Sum(MetricHyperSyncReceivedBytes))
+	_, exists = registry.Value(MetricHyperSyncReceivedBytes, "prefix", "03")
+	require.False(exists)
+
+	// The collectors run whenever the metrics are read.
+	tipHeight = 10
+	value, _ = registry.Value(MetricBlockTipHeight)
+	require.Equal(float64(10), value)
+	tipHeight = 12
+	value, _ = registry.Value(MetricBlockTipHeight)
+	require.Equal(float64(12), value)
+
+	// Histograms count the observations into cumulative buckets.
+	registry.ObserveHistogram(MetricBlockValidationSeconds, 0.02)
+	registry.ObserveHistogram(MetricBlockValidationSeconds, 3)
+	registry.AddCounter(MetricMessages, 1, "type", "BLOCK", "direction", "received")
+
+	var text bytes.Buffer
+	require.NoError(registry.WriteText(&text))
+	require.Contains(text.String(), "# TYPE deso_block_tip_height gauge\ndeso_block_tip_height 12\n")
+	require.Contains(text.String(), `deso_hypersync_received_bytes_total{prefix="01"} 150`)
+	require.Contains(text.String(), `deso_block_validation_duration_seconds_bucket{le="0.01"} 0`)
+	require.Contains(text.String(), `deso_block_validation_duration_seconds_bucket{le="0.025"} 1`)
+	require.Contains(text.String(), `deso_block_validation_duration_seconds_bucket{le="+Inf"} 2`)
+	require.Contains(text.String(), "deso_block_validation_duration_seconds_sum 3.02\n")
+	require.Contains(text.String(), "deso_block_validation_duration_seconds_count 2\n")
+	// The labels are sorted by name.
+	require.Contains(text.String(), `deso_messages_total{direction="received",type="BLOCK"} 1`)
+}
@@ -91,8 +91,10 @@ const (
 	MsgTypeSnapshotData MsgType = 18
 	// MsgTypeTransactionBundleV2 contains transactions after the balance model block height from a peer.
 	MsgTypeTransactionBundleV2 MsgType = 19
+	// MsgTypeBlocksNotFound is the reply to a GetBlocks message for blocks that a pruned peer doesn't store anymore.
+	MsgTypeBlocksNotFound MsgType = 20
 
-	// NEXT_TAG = 20
+	// NEXT_TAG = 21
 
 	// Below are control messages used to signal to the Server from other parts of
 	// the code but not actually sent among peers.
@@ -150,6 +152,8 @@ func (msgType MsgType) String() string {
 		return "TRANSACTION_BUNDLE"
 	case MsgTypeTransactionBundleV2:
 		return "TRANSACTION_BUNDLE_V2"
+	case MsgTypeBlocksNotFound:
+		return "BLOCKS_NOT_FOUND"
 	case MsgTypeMempool:
 		return "MEMPOOL"
 	case MsgTypeAddr:
@@ -688,6 +692,8 @@ func NewMessage(msgType MsgType) DeSoMessage {
 		return &MsgDeSoInv{}
 	case MsgTypeGetBlocks:
 		return &MsgDeSoGetBlocks{}
+	case MsgTypeBlocksNotFound:
+		return &MsgDeSoBlocksNotFound{}
 	case MsgTypeGetTransactions:
 		return &MsgDeSoGetTransactions{}
 	case MsgTypeTransactionBundle:
@@ -977,6 +983,41 @@ func (msg *MsgDeSoGetBlocks) String() string {
 	return fmt.Sprintf("%v", msg.HashList)
 }
 
+// MsgDeSoBlocksNotFound is sent in reply to a GetBlocks message by a pruned node that doesn't store the bodies of some
+// of the requested blocks anymore, see Blockchain.SetPruneBlocksOlderThan. HashList holds the requested blocks that
+// the node won't send, so that the requester can fetch them from another peer rather than wait for them.
+type MsgDeSoBlocksNotFound struct {
+	HashList []*BlockHash
+}
+
+func (msg *MsgDeSoBlocksNotFound) GetMsgType() MsgType {
+	return MsgTypeBlocksNotFound
+}
+
+// ToBytes encodes the HashList like MsgDeSoGetBlocks does.
+func (msg *MsgDeSoBlocksNotFound) ToBytes(preSignature bool) ([]byte, error) {
+	data, err := (&MsgDeSoGetBlocks{HashList: msg.HashList}).ToBytes(preSignature)
+	if err != nil {
+		return nil, errors.Wrapf(err, "MsgDeSoBlocksNotFound.ToBytes: ")
+	}
+	return data, nil
+}
+
+func (msg *MsgDeSoBlocksNotFound) FromBytes(data []byte) error {
+	getBlocks := &MsgDeSoGetBlocks{}
+	if err := getBlocks.FromBytes(data); err != nil {
+		return errors.Wrapf(err, "MsgDeSoBlocksNotFound.FromBytes: ")
+	}
+	*msg = MsgDeSoBlocksNotFound{
+		HashList: getBlocks.HashList,
+	}
+	return nil
+}
+
+func (msg *MsgDeSoBlocksNotFound) String() string {
+	return fmt.Sprintf("%v", msg.HashList)
+}
+
 // DeSoBodySchema Within a post, the body typically has a particular
 // schema defined below.
 type DeSoBodySchema struct {
@@ -1416,6 +1457,9 @@ const (
 	// SFArchivalNode is a flag complementary to SFHyperSync. If node is a hypersync node then
 	// it might not be able to support block sync anymore, unless it has archival mode turned on.
 	SFArchivalNode
+	// SFPrunedNode is a flag used to indicate that the peer only keeps the bodies of its most recent blocks, so it
+	// can't serve a syncing node, although it relays new blocks. See Blockchain.SetPruneBlocksOlderThan.
+	SFPrunedNode
 )
 
 type MsgDeSoVersion struct {
@@ -555,6 +555,24 @@ func TestSerializeGetBlocks(t *testing.T) {
 	require.Equal(msg, parsedMsg)
 }
 
+func TestSerializeBlocksNotFound(t *testing.T) {
+	require := require.New(t)
+
+	msg := &MsgDeSoBlocksNotFound{
+		HashList: []*BlockHash{
+			{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0},
+			{2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2, 0},
+		},
+	}
+
+	bb, err := msg.ToBytes(false)
+	require.NoError(err)
+	parsedMsg := NewMessage(MsgTypeBlocksNotFound).(*MsgDeSoBlocksNotFound)
+	err = parsedMsg.FromBytes(bb)
+	require.NoError(err)
+	require.Equal(msg, parsedMsg)
+}
+
 func TestSerializePingPong(t *testing.T) {
 	require := require.New(t)
 
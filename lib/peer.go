@@ -6,6 +6,7 @@ import (
 	"math"
 	"net"
 	"sort"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -82,6 +83,9 @@ type Peer struct {
 
 	// A pointer to the Server
 	srv *Server
+	// timeSource is the clock of the node, which the Peer uses to timestamp its version message, and to compute
+	// the peer's clock offset.
+	timeSource TimeSource
 
 	// Basic state.
 	PeerInfoMtx               deadlock.Mutex
@@ -110,6 +114,8 @@ type Peer struct {
 	disconnected int32
 	// Signals that the peer is now in the stopped state.
 	quit chan interface{}
+	// handlersWaitGroup tracks the goroutines started by Start, which all exit once the peer is disconnected.
+	handlersWaitGroup sync.WaitGroup
 
 	// Each Peer is only allowed to have certain number of blocks being sent
 	// to them at any gven time. We use
@@ -417,8 +423,21 @@ func (pp *Peer) HandleGetBlocks(msg *MsgDeSoGetBlocks) {
 	// With HyperSync there is a potential that a node will request blocks that we haven't yet stored, although we're
 	// fully synced. This can happen to archival nodes that haven't yet downloaded all historical blocks. If a GetBlock
 	// is sent to a non-archival node for blocks that we don't have, then the peer is misbehaving and should be disconnected.
-	for _, hashToSend := range msg.HashList {
+	// A pruned node can't tell its peers which blocks it pruned, so it refuses them with a BlocksNotFound message rather
+	// than disconnect the peer. The blocks after a pruned block are refused too, as the peer couldn't connect them.
+	for ii, hashToSend := range msg.HashList {
 		blockToSend := pp.srv.blockchain.GetBlock(hashToSend)
+		if blockToSend == nil && pp.srv.blockchain.IsPrunedBlock(hashToSend) {
+			glog.V(1).Infof("Server._handleGetBlocks: Refusing (%v) blocks starting with hash %v to peer %v "+
+				"because it was pruned", len(msg.HashList)-ii, hashToSend, pp)
+			pp.blocksToSendMtx.Lock()
+			for _, hash := range msg.HashList[ii:] {
+				delete(pp.blocksToSend, *hash)
+			}
+			pp.blocksToSendMtx.Unlock()
+			pp.AddDeSoMessage(&MsgDeSoBlocksNotFound{HashList: msg.HashList[ii:]}, false)
+			return
+		}
 		if blockToSend == nil {
 			// Don't ask us for blocks before verifying that we have them with a
 			// GetHeaders request.
@@ -617,11 +636,12 @@ func NewPeer(_conn net.Conn, _isOutbound bool, _netAddr *wire.NetAddress,
 	params *DeSoParams,
 	messageChan chan *ServerMessage,
 	_cmgr *ConnectionManager, _srv *Server,
-	_syncType NodeSyncType) *Peer {
+	_syncType NodeSyncType, _timeSource TimeSource) *Peer {
 
 	pp := Peer{
 		cmgr:                   _cmgr,
 		srv:                    _srv,
+		timeSource:             _timeSource,
 		Conn:                   _conn,
 		addrStr:                _conn.RemoteAddr().String(),
 		netAddr:                _netAddr,
@@ -1068,6 +1088,12 @@ func (pp *Peer) _handleInExpectedResponse(rmsg DeSoMessage) error {
 		// If we get here then we managed to dequeue a message we were
 		// expecting, which is good.
 	}
+	// A BlocksNotFound message stands in for one block per hash it lists.
+	if notFound, ok := rmsg.(*MsgDeSoBlocksNotFound); ok {
+		for range notFound.HashList {
+			pp._removeEarliestExpectedResponse(MsgTypeBlock)
+		}
+	}
 
 	return nil
 }
@@ -1186,10 +1212,13 @@ func (pp *Peer) Start() {
 	glog.Infof("Peer.Start: Starting peer %v", pp)
 	// The protocol has been negotiated successfully so start processing input
 	// and output messages.
-	go pp.PingHandler()
-	go pp.outHandler()
-	go pp.inHandler()
-	go pp.StartDeSoMessageProcessor()
+	for _, handler := range []func(){pp.PingHandler, pp.outHandler, pp.inHandler, pp.StartDeSoMessageProcessor} {
+		pp.handlersWaitGroup.Add(1)
+		go func(handler func()) {
+			defer pp.handlersWaitGroup.Done()
+			handler()
+		}(handler)
+	}
 
 	// If the address manager needs more addresses, then send a GetAddr message
 	// to the peer. This is best-effort.
@@ -1204,6 +1233,12 @@ func (pp *Peer) Start() {
 	// Send our verack message now that the IO processing machinery has started.
 }
 
+// WaitForHandlers waits until the goroutines started by Start have exited after the peer was disconnected. It returns
+// false if they're still running after the timeout.
+func (pp *Peer) WaitForHandlers(timeout time.Duration) bool {
+	return WaitWithTimeout(&pp.handlersWaitGroup, timeout)
+}
+
 func (pp *Peer) IsSyncCandidate() bool {
 	isFullNode := (pp.serviceFlags & SFFullNodeDeprecated) != 0
 	// TODO: This is a bit of a messy way to determine whether the node was run with --hypersync
@@ -1238,6 +1273,15 @@ func (pp *Peer) IsSyncCandidate() bool {
 		return false
 	}
 
+	// A pruned node may not have the blocks we're missing, so we only take the new blocks it relays once we're synced.
+	nodeIsPruned := (pp.serviceFlags & SFPrunedNode) != 0
+	if nodeIsPruned {
+		glog.Infof("IsSyncCandidate: Rejecting node as sync candidate "+
+			"because nodeIsPruned=true localAddr (%v), is outbound (%v)",
+			pp.Conn.LocalAddr().String(), pp.isOutbound)
+		return false
+	}
+
 	return isFullNode && pp.isOutbound
 }
 
@@ -1256,6 +1300,7 @@ func (pp *Peer) WriteDeSoMessage(msg DeSoMessage) error {
 	messageSeq := atomic.AddUint64(&pp.totalMessages, 1)
 	glog.V(3).Infof("SENDING( seq=%d ) message of type: %v to peer %v: %v",
 		messageSeq, msg.GetMsgType(), pp, msg)
+	pp.metrics().AddCounter(MetricMessages, 1, "direction", "sent", "type", msg.GetMsgType().String())
 
 	return nil
 }
@@ -1277,15 +1322,24 @@ func (pp *Peer) ReadDeSoMessage() (DeSoMessage, error) {
 	messageSeq := atomic.AddUint64(&pp.totalMessages, 1)
 	glog.V(3).Infof("RECEIVED( seq=%d ) message of type: %v from peer %v: %v",
 		messageSeq, msg.GetMsgType(), pp, msg)
+	pp.metrics().AddCounter(MetricMessages, 1, "direction", "received", "type", msg.GetMsgType().String())
 
 	return msg, nil
 }
 
+// metrics returns the recorder of the peer's server, or a recorder that drops the metrics if the peer has no server.
+func (pp *Peer) metrics() MetricsRecorder {
+	if pp.srv == nil || pp.srv.metrics == nil {
+		return noopMetricsRecorder{}
+	}
+	return pp.srv.metrics
+}
+
 func (pp *Peer) NewVersionMessage(params *DeSoParams) *MsgDeSoVersion {
 	ver := NewMessage(MsgTypeVersion).(*MsgDeSoVersion)
 
 	ver.Version = params.ProtocolVersion
-	ver.TstampSecs = time.Now().Unix()
+	ver.TstampSecs = pp.timeSource.Now().Unix()
 	// We use an int64 instead of a uint64 for convenience but
 	// this should be fine since we're just looking to generate a
 	// unique value.
@@ -1297,7 +1351,9 @@ func (pp *Peer) NewVersionMessage(params *DeSoParams) *MsgDeSoVersion {
 	if pp.cmgr != nil && pp.cmgr.HyperSync {
 		ver.Services |= SFHyperSync
 	}
-	if pp.srv.blockchain.archivalMode {
+	if pp.srv.blockchain.pruneBlocksOlderThan > 0 {
+		ver.Services |= SFPrunedNode
+	} else if pp.srv.blockchain.archivalMode {
 		ver.Services |= SFArchivalNode
 	}
 
@@ -1418,14 +1474,12 @@ func (pp *Peer) readVersion() error {
 	pp.startingHeight = verMsg.StartBlockHeight
 	pp.minTxFeeRateNanosPerKB = verMsg.MinFeeRateNanosPerKB
 	pp.TimeConnected = time.Unix(verMsg.TstampSecs, 0)
-	pp.TimeOffsetSecs = verMsg.TstampSecs - time.Now().Unix()
+	pp.TimeOffsetSecs = verMsg.TstampSecs - pp.timeSource.Now().Unix()
 	pp.StatsMtx.Unlock()
 
 	// Update the timeSource now that we've gotten a version message from the
 	// peer.
-	if pp.cmgr != nil {
-		pp.cmgr.timeSource.AddTimeSample(pp.addrStr, pp.TimeConnected)
-	}
+	pp.timeSource.AddTimeSample(pp.addrStr, pp.TimeConnected)
 
 	return nil
 }
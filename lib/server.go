@@ -102,6 +102,10 @@ type Server struct {
 	// to different peers. Whenever we assign a prefix to a peer, we would append a SyncProgressPrefix
 	// struct to the HyperSyncProgress.PrefixProgress array.
 	HyperSyncProgress SyncProgress
+	// hyperSyncProgressSubscriptions are the channels handed out by SubscribeHyperSyncProgress.
+	hyperSyncProgressSubscriptions subscriptionList[SyncPrefixProgress]
+	// blockRejectedSubscriptions are the channels handed out by SubscribeBlockRejected.
+	blockRejectedSubscriptions subscriptionList[BlockRejectedEvent]
 	// How long we wait on a transaction we're fetching before giving
 	// up on it. Note this doesn't apply to blocks because they have their own
 	// process for retrying that differs from transactions, which are
@@ -148,8 +152,26 @@ type Server struct {
 	// timer is a helper variable that allows timing events for development purposes.
 	// It can be used to find computational bottlenecks.
 	timer *Timer
+
+	// metrics records the server's metrics, see SetMetricsRecorder.
+	metrics MetricsRecorder
+
+	// lastMessageHandlerTick is the unix time in nanoseconds at which the messageHandler last went through its loop,
+	// see LastMessageHandlerTick.
+	lastMessageHandlerTick int64
+	// fatalErrors are the errors of the subsystems that broke beyond what the server can recover from on its own,
+	// by subsystem, see MarkFatal.
+	fatalErrorsLock deadlock.RWMutex
+	fatalErrors     map[string]error
+	// hyperSyncError is the error of the last snapshot that we refused to hypersync from, see HyperSyncError.
+	hyperSyncErrorLock deadlock.RWMutex
+	hyperSyncError     error
 }
 
+// messageHandlerTickInterval is how often the messageHandler goes through its loop when there are no messages to
+// handle, so that LastMessageHandlerTick shows the loop isn't stuck.
+const messageHandlerTickInterval = 5 * time.Second
+
 func (srv *Server) HasProcessedFirstTransactionBundle() bool {
 	return srv.hasProcessedFirstTransactionBundle
 }
@@ -212,6 +234,114 @@ func (srv *Server) GetMempool() *DeSoMempool {
 	return srv.mempool
 }
 
+// SubscribeHyperSyncProgress returns a channel that receives a copy of a prefix's progress whenever hypersync starts
+// downloading a prefix or receives a snapshot chunk for it. A subscriber that falls far behind misses the oldest
+// updates. The returned function unsubscribes.
+func (srv *Server) SubscribeHyperSyncProgress() (_progress <-chan SyncPrefixProgress, _unsubscribe func()) {
+	return srv.hyperSyncProgressSubscriptions.subscribe(1000)
+}
+
+// publishHyperSyncProgress notifies the hypersync progress subscribers about the prefix's progress.
+func (srv *Server) publishHyperSyncProgress(prefixProgress *SyncPrefixProgress) {
+	srv.hyperSyncProgressSubscriptions.publish(*prefixProgress)
+}
+
+// BlockRejectedEvent describes a header or a block from a peer that the chain rejected, after which the peer is
+// disconnected.
+type BlockRejectedEvent struct {
+	BlockHash *BlockHash
+	Height    uint64
+	Peer      *Peer
+	Err       error
+}
+
+// SubscribeBlockRejected returns a channel that receives an event whenever the chain rejects a header or a block from
+// a peer from now on, e.g. because its timestamp is too far in the future. A subscriber that falls far behind misses
+// the oldest events. The returned function unsubscribes.
+func (srv *Server) SubscribeBlockRejected() (_events <-chan BlockRejectedEvent, _unsubscribe func()) {
+	return srv.blockRejectedSubscriptions.subscribe(100)
+}
+
+// publishBlockRejected notifies the block rejection subscribers that the header or block was rejected with err.
+func (srv *Server) publishBlockRejected(pp *Peer, blockHash *BlockHash, height uint64, err error) {
+	srv.blockRejectedSubscriptions.publish(BlockRejectedEvent{
+		BlockHash: blockHash,
+		Height:    height,
+		Peer:      pp,
+		Err:       err,
+	})
+}
+
+// SetMetricsRecorder makes the server record its metrics, i.e. the messages exchanged with peers, the hypersync
+// progress, and the block validation times, through the recorder. It must be called before the server is started.
+func (srv *Server) SetMetricsRecorder(recorder MetricsRecorder) {
+	srv.metrics = recorder
+}
+
+// CollectMetrics sets the server's gauges, which are read from its subsystems rather than kept up to date, through
+// the recorder. It's meant to be run as a collector of a MetricsRegistry, see MetricsRegistry.AddCollector.
+func (srv *Server) CollectMetrics(recorder MetricsRecorder) {
+	srv.blockchain.ChainLock.RLock()
+	if blockTip := srv.blockchain.blockTip(); blockTip != nil {
+		recorder.SetGauge(MetricBlockTipHeight, float64(blockTip.Height))
+	}
+	if headerTip := srv.blockchain.headerTip(); headerTip != nil {
+		recorder.SetGauge(MetricHeaderTipHeight, float64(headerTip.Height))
+	}
+	srv.blockchain.ChainLock.RUnlock()
+
+	recorder.SetGauge(MetricPeers, float64(srv.cmgr.NumConnectedPeers()))
+	if srv.mempool != nil {
+		recorder.SetGauge(MetricMempoolTxns, float64(srv.mempool.Count()))
+		recorder.SetGauge(MetricMempoolBytes, float64(srv.mempool.TotalTxSizeBytes()))
+	}
+	if srv.blockchain.db != nil {
+		lsmSize, vlogSize := srv.blockchain.db.Size()
+		recorder.SetGauge(MetricBadgerLSMSizeBytes, float64(lsmSize))
+		recorder.SetGauge(MetricBadgerValueLogSizeBytes, float64(vlogSize))
+	}
+}
+
+// LastMessageHandlerTick returns when the server's main loop last handled a message, or ticked while it waited for
+// one, which it does every messageHandlerTickInterval. A tick far in the past means the loop is stuck.
+func (srv *Server) LastMessageHandlerTick() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&srv.lastMessageHandlerTick))
+}
+
+// MarkFatal flags the subsystem as broken beyond what the server can recover from on its own, e.g. a snapshot whose
+// checksum doesn't match the network's. The node then needs to be restarted, or its data directory erased.
+func (srv *Server) MarkFatal(subsystem string, err error) {
+	srv.fatalErrorsLock.Lock()
+	defer srv.fatalErrorsLock.Unlock()
+	srv.fatalErrors[subsystem] = err
+}
+
+// FatalErrors returns the errors of the subsystems flagged with MarkFatal, by subsystem.
+func (srv *Server) FatalErrors() map[string]error {
+	srv.fatalErrorsLock.RLock()
+	defer srv.fatalErrorsLock.RUnlock()
+	fatalErrors := make(map[string]error, len(srv.fatalErrors))
+	for subsystem, err := range srv.fatalErrors {
+		fatalErrors[subsystem] = err
+	}
+	return fatalErrors
+}
+
+// HyperSyncError returns the error of the last snapshot that we refused to hypersync from, e.g. because the peer's
+// snapshot period differs from ours, see ValidateSnapshotEpochHeight, or nil if we didn't refuse any. The peer that
+// served the snapshot is disconnected, and we keep waiting for a peer whose snapshot we can use.
+func (srv *Server) HyperSyncError() error {
+	srv.hyperSyncErrorLock.RLock()
+	defer srv.hyperSyncErrorLock.RUnlock()
+	return srv.hyperSyncError
+}
+
+// GetMempoolSnapshot returns the txns currently in the mempool, ordered by when they were added. See
+// DeSoMempool.PoolTxnsSnapshot.
+func (srv *Server) GetMempoolSnapshot() []*MempoolTx {
+	return srv.mempool.PoolTxnsSnapshot()
+}
+
 // TODO: The hallmark of a messy non-law-of-demeter-following interface...
 func (srv *Server) GetBlockProducer() *DeSoBlockProducer {
 	return srv.blockProducer
@@ -289,21 +419,22 @@ func NodeCanHypersyncState(syncType NodeSyncType) bool {
 	return syncType != NodeSyncTypeBlockSync
 }
 
-func ValidateHyperSyncFlags(isHypersync bool, syncType NodeSyncType) {
+func ValidateHyperSyncFlags(isHypersync bool, syncType NodeSyncType) error {
 	if syncType != NodeSyncTypeAny &&
 		syncType != NodeSyncTypeBlockSync &&
 		syncType != NodeSyncTypeHyperSyncArchival &&
 		syncType != NodeSyncTypeHyperSync {
-		glog.Fatalf("Unrecognized --sync-type flag %v", syncType)
+		return fmt.Errorf("Unrecognized --sync-type flag %v", syncType)
 	}
 	if !isHypersync &&
 		syncType == NodeSyncTypeHyperSync {
-		glog.Fatal("Cannot set --sync-type=hypersync without also setting --hypersync=true")
+		return fmt.Errorf("Cannot set --sync-type=hypersync without also setting --hypersync=true")
 	}
 	if !isHypersync &&
 		syncType == NodeSyncTypeHyperSyncArchival {
-		glog.Fatal("Cannot set --sync-type=hypersync-archival without also setting --hypersync=true")
+		return fmt.Errorf("Cannot set --sync-type=hypersync-archival without also setting --hypersync=true")
 	}
+	return nil
 }
 
 // NewServer initializes all of the internal data structures. Right now this basically
@@ -329,6 +460,8 @@ func ValidateHyperSyncFlags(isHypersync bool, syncType NodeSyncType) {
 //     a particular peer, which could be the case in initial block download where a single
 //     sync peer is used.
 //
+// The snapshot db is opened under the data directory, unless _snapshotDb is passed, see NewSnapshot.
+//
 // TODO: Refactor all these arguments into a config object or something.
 func NewServer(
 	_params *DeSoParams,
@@ -365,7 +498,9 @@ func NewServer(
 	_trustedBlockProducerStartHeight uint64,
 	eventManager *EventManager,
 	_nodeMessageChan chan NodeMessage,
-	_forceChecksum bool) (
+	_forceChecksum bool,
+	_timeOffset time.Duration,
+	_snapshotDb *badger.DB) (
 	_srv *Server, _err error, _shouldRestart bool) {
 
 	var err error
@@ -376,10 +511,20 @@ func NewServer(
 	archivalMode := false
 	if _hyperSync {
 		_snapshot, err, shouldRestart = NewSnapshot(_db, _dataDir, _snapshotBlockHeightPeriod,
-			false, false, _params, _disableEncoderMigrations)
+			false, false, _params, _disableEncoderMigrations, _snapshotDb)
 		if err != nil {
-			panic(err)
-		}
+			return nil, errors.Wrapf(err, "NewServer: Problem initializing snapshot"), shouldRestart
+		}
+		// If the server fails to initialize, stop the snapshot, so that the node can close its db, or erase it along
+		// with the data directory. The snapshot db is only closed here if the snapshot opened it.
+		defer func() {
+			if _err != nil {
+				_snapshot.Stop()
+				if _snapshotDb == nil {
+					_snapshot.SnapshotDb.Close()
+				}
+			}
+		}()
 	}
 
 	// We only set archival mode true if we're a hypersync node.
@@ -396,20 +541,26 @@ func NewServer(
 		snapshot:                     _snapshot,
 		nodeMessageChannel:           _nodeMessageChan,
 		forceChecksum:                _forceChecksum,
+		metrics:                      noopMetricsRecorder{},
+		fatalErrors:                  make(map[string]error),
 	}
 
 	// The same timesource is used in the chain data structure and in the connection
 	// manager. It just takes and keeps track of the median time among our peers so
-	// we can keep a consistent clock.
-	timesource := chainlib.NewMedianTime()
+	// we can keep a consistent clock. The time offset skews the node's clock, which is
+	// only ever non-zero in tests.
+	timesource := NewOffsetTimeSource(_timeOffset)
 
 	// Create a new connection manager but note that it won't be initialized until Start().
 	_incomingMessages := make(chan *ServerMessage, (_targetOutboundPeers+_maxInboundPeers)*3)
-	_cmgr := NewConnectionManager(
+	_cmgr, err := NewConnectionManager(
 		_params, _desoAddrMgr, _listeners, _connectIps, timesource,
 		_targetOutboundPeers, _maxInboundPeers, _limitOneInboundConnectionPerIP,
 		_hyperSync, _syncType, _stallTimeoutSeconds, _minFeeRateNanosPerKB,
 		_incomingMessages, srv)
+	if err != nil {
+		return nil, errors.Wrapf(err, "NewServer: Problem initializing connection manager"), false
+	}
 
 	// Set up the blockchain data structure. This is responsible for accepting new
 	// blocks, keeping track of the best chain, and keeping all of that state up
@@ -494,7 +645,11 @@ func NewServer(
 	}
 	_miner, err := NewDeSoMiner(_minerPublicKeys, uint32(_numMiningThreads), _blockProducer, _params)
 	if err != nil {
-		return nil, errors.Wrapf(err, "NewServer: "), true
+		if _blockProducer != nil {
+			_blockProducer.Stop()
+		}
+		// Invalid miner public keys aren't fixed by resyncing, so the node shouldn't restart.
+		return nil, errors.Wrapf(err, "NewServer: "), false
 	}
 	// If we only want to sync to a specific block height, we would disable the miner.
 	// _maxSyncBlockHeight is used for development.
@@ -639,12 +794,14 @@ func (srv *Server) GetSnapshot(pp *Peer) {
 			// If prefix doesn't exist in our prefix progress struct, append new progress tracker
 			// and assign it to the current peer.
 			if !exists {
-				srv.HyperSyncProgress.PrefixProgress = append(srv.HyperSyncProgress.PrefixProgress, &SyncPrefixProgress{
+				prefixProgress := &SyncPrefixProgress{
 					PrefixSyncPeer:  pp,
 					Prefix:          prefix,
 					LastReceivedKey: prefix,
 					Completed:       false,
-				})
+				}
+				srv.HyperSyncProgress.PrefixProgress = append(srv.HyperSyncProgress.PrefixProgress, prefixProgress)
+				srv.publishHyperSyncProgress(prefixProgress)
 				lastReceivedKey = prefix
 				syncingPrefix = true
 				break
@@ -727,6 +884,7 @@ func (srv *Server) GetBlocksToStore(pp *Peer) {
 
 	// If we get here then it means that we've downloaded all blocks so we can update
 	srv.blockchain.downloadingHistoricalBlocks = false
+	srv.blockchain.publishChainState()
 }
 
 // GetBlocks computes what blocks we need to fetch and asks for them from the
@@ -827,6 +985,9 @@ func (srv *Server) _handleHeaderBundle(pp *Peer, msg *MsgDeSoHeaderBundle) {
 				"because error occurred processing header: %v, isOrphan: %v",
 				pp, srv.blockchain.chainState(), err, isOrphan)
 
+			if err != nil {
+				srv.publishBlockRejected(pp, headerHash, headerReceived.Height, err)
+			}
 			pp.Disconnect()
 			return
 		}
@@ -871,6 +1032,7 @@ func (srv *Server) _handleHeaderBundle(pp *Peer, msg *MsgDeSoHeaderBundle) {
 			// If hypersync conditions are satisfied, we will be syncing state. This assignment results
 			// in srv.blockchain.chainState() to be equal to SyncStateSyncingSnapshot
 			srv.blockchain.syncingState = true
+			srv.blockchain.publishChainState()
 		}
 
 		if srv.blockchain.chainState() == SyncStateSyncingSnapshot {
@@ -903,6 +1065,8 @@ func (srv *Server) _handleHeaderBundle(pp *Peer, msg *MsgDeSoHeaderBundle) {
 						"records, error: %v", err)))
 				}
 				if shouldErase {
+					srv.MarkFatal("snapshot", fmt.Errorf("Server._handleHeaderBundle: State records were found "+
+						"while trying to resync"))
 					if srv.nodeMessageChannel != nil {
 						srv.nodeMessageChannel <- NodeErase
 					}
@@ -955,6 +1119,7 @@ func (srv *Server) _handleHeaderBundle(pp *Peer, msg *MsgDeSoHeaderBundle) {
 			glog.V(1).Infof("Server._handleHeaderBundle: Syncing historical blocks because node is in " +
 				"archival mode.")
 			srv.blockchain.downloadingHistoricalBlocks = true
+			srv.blockchain.publishChainState()
 			srv.GetBlocksToStore(pp)
 			if srv.blockchain.downloadingHistoricalBlocks {
 				return
@@ -1099,6 +1264,21 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 		msg.SnapshotChunk[0].Key, msg.SnapshotChunk[len(msg.SnapshotChunk)-1].Key, len(msg.SnapshotChunk),
 		msg.SnapshotMetadata, msg.SnapshotChunk[0].IsEmpty(), pp)))
 
+	// Make sure that the peer's snapshot is at an epoch height we can use, judging by the snapshot metadata rather than
+	// the peer's tip from the handshake, which may be stale. Otherwise, the peer might never serve the snapshot we
+	// expect, and we would keep retrying it, or restarting for what looks like a new epoch.
+	if err := ValidateSnapshotEpochHeight(msg.SnapshotMetadata.SnapshotBlockHeight,
+		srv.HyperSyncProgress.SnapshotMetadata.SnapshotBlockHeight, srv.snapshot.SnapshotBlockHeightPeriod); err != nil {
+
+		err = fmt.Errorf("srv._handleSnapshot: Refusing to hypersync from peer (%v), disconnecting: %w", pp, err)
+		glog.Errorf(CLog(Red, err.Error()))
+		srv.hyperSyncErrorLock.Lock()
+		srv.hyperSyncError = err
+		srv.hyperSyncErrorLock.Unlock()
+		pp.Disconnect()
+		return
+	}
+
 	// There is a possibility that during hypersync the network entered a new snapshot epoch. We handle this case by
 	// restarting the node and starting hypersync from scratch.
 	if msg.SnapshotMetadata.SnapshotBlockHeight > srv.HyperSyncProgress.SnapshotMetadata.SnapshotBlockHeight &&
@@ -1238,6 +1418,13 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 			// We found the hyper sync progress corresponding to this snapshot chunk so update the key.
 			lastKey := msg.SnapshotChunk[len(msg.SnapshotChunk)-1].Key
 			srv.HyperSyncProgress.PrefixProgress[ii].LastReceivedKey = lastKey
+			chunkBytes := uint64(0)
+			for _, dbEntry := range dbChunk {
+				chunkBytes += uint64(len(dbEntry.Key) + len(dbEntry.Value))
+			}
+			srv.HyperSyncProgress.PrefixProgress[ii].ReceivedBytes += chunkBytes
+			srv.metrics.AddCounter(MetricHyperSyncReceivedBytes, float64(chunkBytes),
+				"prefix", hex.EncodeToString(msg.Prefix))
 
 			// If the snapshot chunk is not full, it means that we've completed this prefix. In such case,
 			// there is a possibility we've finished hyper sync altogether. We will break out of the loop
@@ -1246,9 +1433,11 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 			//		We'll do this when we want to implement multi-peer sync.
 			if !msg.SnapshotChunkFull {
 				srv.HyperSyncProgress.PrefixProgress[ii].Completed = true
+				srv.publishHyperSyncProgress(srv.HyperSyncProgress.PrefixProgress[ii])
 				break
 			} else {
 				// If chunk is full it means there's more work to do, so we will resume snapshot sync.
+				srv.publishHyperSyncProgress(srv.HyperSyncProgress.PrefixProgress[ii])
 				srv.GetSnapshot(pp)
 				return
 			}
@@ -1316,6 +1505,9 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 		if srv.forceChecksum {
 			// If forceChecksum is true we signal an erasure of the state and return here,
 			// which will cut off the sync.
+			srv.MarkFatal("snapshot", fmt.Errorf("Server._handleSnapshot: The final db checksum (%v) doesn't "+
+				"match the peer's snapshot checksum (%v)", checksumBytes,
+				srv.HyperSyncProgress.SnapshotMetadata.CurrentEpochChecksumBytes))
 			if srv.nodeMessageChannel != nil {
 				srv.nodeMessageChannel <- NodeErase
 			}
@@ -1359,6 +1551,7 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 
 	// If we got here then we finished the snapshot sync so set appropriate flags.
 	srv.blockchain.syncingState = false
+	srv.blockchain.publishChainState()
 	srv.blockchain.snapshot.CurrentEpochSnapshotMetadata = srv.HyperSyncProgress.SnapshotMetadata
 
 	// Update the snapshot epoch metadata in the snapshot DB.
@@ -1390,6 +1583,7 @@ func (srv *Server) _handleSnapshot(pp *Peer, msg *MsgDeSoSnapshotData) {
 	// Now sync the remaining blocks.
 	if srv.blockchain.archivalMode {
 		srv.blockchain.downloadingHistoricalBlocks = true
+		srv.blockchain.publishChainState()
 		srv.GetBlocksToStore(pp)
 		return
 	}
@@ -1745,6 +1939,22 @@ func (srv *Server) _logAndDisconnectPeer(pp *Peer, blockMsg *MsgDeSoBlock, suffi
 	pp.Disconnect()
 }
 
+// _handleBlocksNotFound is called when a pruned peer refuses to send blocks that we requested from it, see
+// MsgDeSoBlocksNotFound. The blocks are no longer requested from the peer, and we disconnect it, so that they're
+// requested from another peer, like when a peer doesn't send a block in time, but without waiting for the stall timeout.
+func (srv *Server) _handleBlocksNotFound(pp *Peer, msg *MsgDeSoBlocksNotFound) {
+	if len(msg.HashList) == 0 {
+		return
+	}
+	for _, blockHash := range msg.HashList {
+		delete(pp.requestedBlocks, *blockHash)
+	}
+	glog.Infof(CLog(Yellow, fmt.Sprintf("Server._handleBlocksNotFound: Peer %v doesn't store (%v) blocks "+
+		"we requested, starting with %v. Disconnecting it to request them from another peer.", pp,
+		len(msg.HashList), msg.HashList[0])))
+	pp.Disconnect()
+}
+
 func (srv *Server) _handleBlock(pp *Peer, blk *MsgDeSoBlock) {
 	glog.Infof(CLog(Cyan, fmt.Sprintf("Server._handleBlock: Received block ( %v / %v ) from Peer %v",
 		blk.Header.Height, srv.blockchain.headerTip().Height, pp)))
@@ -1807,6 +2017,7 @@ func (srv *Server) _handleBlock(pp *Peer, blk *MsgDeSoBlock) {
 
 	// Only verify signatures for recent blocks.
 	var isOrphan bool
+	processStart := time.Now()
 	if srv.blockchain.isSyncing() {
 		glog.V(1).Infof(CLog(Cyan, fmt.Sprintf("Server._handleBlock: Processing block %v WITHOUT "+
 			"signature checking because SyncState=%v for peer %v",
@@ -1822,6 +2033,7 @@ func (srv *Server) _handleBlock(pp *Peer, blk *MsgDeSoBlock) {
 			blk, srv.blockchain.chainState(), pp)))
 		_, isOrphan, err = srv.blockchain.ProcessBlock(blk, true)
 	}
+	srv.metrics.ObserveHistogram(MetricBlockValidationSeconds, time.Since(processStart).Seconds())
 
 	// If we hit an error then abort mission entirely. We should generally never
 	// see an error with a block from a peer.
@@ -1833,6 +2045,7 @@ func (srv *Server) _handleBlock(pp *Peer, blk *MsgDeSoBlock) {
 			// out a way to be more strict about things.
 			glog.Warningf("Got duplicate block %v from peer %v", blk, pp)
 		} else {
+			srv.publishBlockRejected(pp, blockHash, blockHeader.Height, err)
 			srv._logAndDisconnectPeer(
 				pp, blk,
 				errors.Wrapf(err, "Error while processing block: ").Error())
@@ -2140,6 +2353,8 @@ func (srv *Server) _handlePeerMessages(serverMessage *ServerMessage) {
 		srv._handleGetBlocks(serverMessage.Peer, msg)
 	case *MsgDeSoBlock:
 		srv._handleBlock(serverMessage.Peer, msg)
+	case *MsgDeSoBlocksNotFound:
+		srv._handleBlocksNotFound(serverMessage.Peer, msg)
 	case *MsgDeSoGetSnapshot:
 		srv._handleGetSnapshot(serverMessage.Peer, msg)
 	case *MsgDeSoSnapshotData:
@@ -2161,13 +2376,22 @@ func (srv *Server) _handlePeerMessages(serverMessage *ServerMessage) {
 // it calls can assume they can access the Server's variables without concurrency
 // issues.
 func (srv *Server) messageHandler() {
+	ticker := time.NewTicker(messageHandlerTickInterval)
+	defer ticker.Stop()
 	for {
 		// This is used instead of the shouldQuit control message exist mechanism below. shouldQuit will be true only
 		// when all incoming messages have been processed, on the other hand this shutdown will quit immediately.
 		if atomic.LoadInt32(&srv.shutdown) >= 1 {
 			break
 		}
-		serverMessage := <-srv.incomingMessages
+		var serverMessage *ServerMessage
+		select {
+		case serverMessage = <-srv.incomingMessages:
+		case <-ticker.C:
+			atomic.StoreInt64(&srv.lastMessageHandlerTick, time.Now().UnixNano())
+			continue
+		}
+		atomic.StoreInt64(&srv.lastMessageHandlerTick, time.Now().UnixNano())
 		glog.V(2).Infof("Server.messageHandler: Handling message of type %v from Peer %v",
 			serverMessage.Msg.GetMsgType(), serverMessage.Peer)
 
@@ -2371,6 +2595,7 @@ func (srv *Server) Start() {
 	// Start the Server so that it will be ready to process messages once the ConnectionManager
 	// finds some Peers.
 	glog.Info("Server.Start: Starting Server")
+	atomic.StoreInt64(&srv.lastMessageHandlerTick, time.Now().UnixNano())
 	srv.waitGroup.Add(1)
 	go srv.messageHandler()
 
@@ -2400,6 +2625,8 @@ type SyncPrefixProgress struct {
 	Prefix []byte
 	// LastReceivedKey is the last key that we've received from this peer.
 	LastReceivedKey []byte
+	// ReceivedBytes is the total size of the keys and values that we've received for this prefix.
+	ReceivedBytes uint64
 
 	// Completed indicates whether we've finished syncing this prefix.
 	Completed bool
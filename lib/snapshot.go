@@ -20,6 +20,7 @@ import (
 	"runtime"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -301,24 +302,56 @@ type Snapshot struct {
 	// updateWaitGroup is used to wait for snapshot loop to finish.
 	updateWaitGroup sync.WaitGroup
 	stopped         bool
+	// killed is set by Kill, and makes the snapshot loop drop the remaining operations instead of processing them.
+	killed int32
+	// faultInjector, if set, makes db writes fail on demand. Mainly used in testing.
+	faultInjector *DBFaultInjector
+	// epochCompletedSubscriptions are the channels handed out by SubscribeEpochCompleted.
+	epochCompletedSubscriptions subscriptionList[SnapshotEpochEvent]
 
 	timer *Timer
 }
 
-// NewSnapshot creates a new snapshot instance.
+// SnapshotEpochEvent describes a snapshot epoch whose metadata was persisted, after which the snapshot at the epoch's
+// block height can be served to hypersyncing peers.
+type SnapshotEpochEvent struct {
+	SnapshotBlockHeight uint64
+	BlockHash           *BlockHash
+	ChecksumBytes       []byte
+}
+
+// SubscribeEpochCompleted returns a channel that receives an event whenever a snapshot epoch completes from now on. A
+// subscriber that falls far behind misses the oldest events. The returned function unsubscribes.
+func (snap *Snapshot) SubscribeEpochCompleted() (_events <-chan SnapshotEpochEvent, _unsubscribe func()) {
+	return snap.epochCompletedSubscriptions.subscribe(100)
+}
+
+// NewSnapshot creates a new snapshot instance. The ancestral records are kept in snapshotDb, or, if it's nil, in a db
+// opened under mainDbDirectory. Passing snapshotDb lets the caller own the db, e.g. an in-memory db that outlives the
+// snapshot.
 func NewSnapshot(mainDb *badger.DB, mainDbDirectory string, snapshotBlockHeightPeriod uint64, isTxIndex bool,
-	disableChecksum bool, params *DeSoParams, disableMigrations bool) (_snap *Snapshot, _err error, _shouldRestart bool) {
+	disableChecksum bool, params *DeSoParams, disableMigrations bool, snapshotDb *badger.DB) (_snap *Snapshot,
+	_err error, _shouldRestart bool) {
 
 	// Initialize the ancestral records database
-	snapshotDirectory := filepath.Join(GetBadgerDbPath(mainDbDirectory), "snapshot")
-	snapshotOpts := PerformanceBadgerOptions(snapshotDirectory)
-	snapshotOpts.ValueDir = GetBadgerDbPath(snapshotDirectory)
-	snapshotDb, err := badger.Open(snapshotOpts)
-	if err != nil {
-		return nil, errors.Wrapf(err, "NewSnapshot: Problem creating SnapshotDb"), true
+	if snapshotDb == nil {
+		snapshotDirectory := filepath.Join(GetBadgerDbPath(mainDbDirectory), "snapshot")
+		snapshotOpts := PerformanceBadgerOptions(snapshotDirectory)
+		snapshotOpts.ValueDir = GetBadgerDbPath(snapshotDirectory)
+		var err error
+		snapshotDb, err = badger.Open(snapshotOpts)
+		if err != nil {
+			return nil, errors.Wrapf(err, "NewSnapshot: Problem creating SnapshotDb"), true
+		}
+		glog.Infof("Snapshot BadgerDB Dir: %v", snapshotOpts.Dir)
+		glog.Infof("Snapshot BadgerDB ValueDir: %v", snapshotOpts.ValueDir)
+		// Close the db we've just opened if the snapshot can't be initialized, so that it can be opened again.
+		defer func() {
+			if _err != nil {
+				snapshotDb.Close()
+			}
+		}()
 	}
-	glog.Infof("Snapshot BadgerDB Dir: %v", snapshotOpts.Dir)
-	glog.Infof("Snapshot BadgerDB ValueDir: %v", snapshotOpts.ValueDir)
 	if snapshotBlockHeightPeriod == 0 {
 		snapshotBlockHeightPeriod = SnapshotBlockHeightPeriod
 	}
@@ -426,6 +459,16 @@ func (snap *Snapshot) Run() {
 	snap.updateWaitGroup.Add(1)
 	for {
 		operation := snap.OperationChannel.DequeueOperationStateless()
+		if atomic.LoadInt32(&snap.killed) != 0 {
+			// The snapshot was killed, so drop the operation without finishing it, as if the node's process had died
+			// before getting to it.
+			if operation.operationType == SnapshotOperationExit {
+				glog.V(2).Infof("Snapshot.Run: Exiting the operation loop after kill")
+				snap.updateWaitGroup.Done()
+				return
+			}
+			continue
+		}
 		switch operation.operationType {
 		case SnapshotOperationFlush:
 			glog.V(2).Infof("Snapshot.Run: Flushing ancestral records with counter")
@@ -494,6 +537,25 @@ func (snap *Snapshot) Stop() {
 	// It's important!!!
 }
 
+// Kill stops the run loop without processing the operations that are still in the operation channel, simulating a
+// node that died abruptly. The dropped operations are never finished, so the operation channel's status saved in the
+// snapshot db keeps counting them, which is how the node detects the unclean exit on the next start. Like Stop, Kill
+// doesn't close the snapshot db. Mainly used in testing.
+func (snap *Snapshot) Kill() {
+	glog.Infof("Snapshot.Kill: Killing the run loop")
+	if snap.stopped {
+		return
+	}
+	snap.stopped = true
+	atomic.StoreInt32(&snap.killed, 1)
+
+	// Bypass EnqueueOperation, so that the exit operation isn't counted in the saved operation channel status.
+	snap.OperationChannel.OperationChannel <- &SnapshotOperation{
+		operationType: SnapshotOperationExit,
+	}
+	snap.updateWaitGroup.Wait()
+}
+
 // ForceResetToLastSnapshot is a doomsday scenario recovery mode. It will be triggered if the node was shutdown midway,
 // resulting in a corrupted ancestral records or checksum. To recover from this situation, we will revert to the beginning
 // of the current snapshot epoch. We do this by disconnecting blocks from the tip to the epoch's start and resetting the checksum.
@@ -628,6 +690,22 @@ func (snap *Snapshot) PersistChecksumAndMigration() error {
 	return nil
 }
 
+// SetCurrentEpochSnapshotMetadata sets the metadata of the snapshot's current epoch, and saves it in the snapshot db,
+// like at the end of hypersync. It's used to bootstrap the snapshot of a node from a snapshot that was taken elsewhere.
+func (snap *Snapshot) SetCurrentEpochSnapshotMetadata(metadata *SnapshotEpochMetadata) error {
+	snap.SnapshotDbMutex.Lock()
+	defer snap.SnapshotDbMutex.Unlock()
+
+	snap.CurrentEpochSnapshotMetadata = metadata
+	err := snap.SnapshotDb.Update(func(txn *badger.Txn) error {
+		return txn.Set(_prefixLastEpochMetadata, metadata.ToBytes())
+	})
+	if err != nil {
+		return errors.Wrapf(err, "Snapshot.SetCurrentEpochSnapshotMetadata: Problem saving epoch metadata")
+	}
+	return nil
+}
+
 func (snap *Snapshot) PrintChecksum(text string) {
 	snap.OperationChannel.EnqueueOperation(&SnapshotOperation{
 		operationType: SnapshotOperationChecksumPrint,
@@ -979,6 +1057,9 @@ func (snap *Snapshot) GetAncestralRecordsKeyWithTxn(txn *badger.Txn, key []byte,
 func (snap *Snapshot) DBSetAncestralRecordWithTxn(
 	txn *badger.Txn, blockHeight uint64, keyBytes []byte, value *AncestralRecordValue) error {
 
+	if err := snap.checkWriteFault(); err != nil {
+		return err
+	}
 	if value.Existed {
 		return txn.Set(snap.GetAncestralRecordsKey(keyBytes, blockHeight), append(value.Value, byte(1)))
 	} else {
@@ -1083,9 +1164,31 @@ func (snap *Snapshot) SnapshotProcessBlock(blockNode *BlockNode) {
 
 		glog.V(1).Infof("Snapshot.SnapshotProcessBlock: snapshot checksum is (%v)",
 			snap.CurrentEpochSnapshotMetadata.CurrentEpochChecksumBytes)
+		if err == nil {
+			snap.epochCompletedSubscriptions.publish(SnapshotEpochEvent{
+				SnapshotBlockHeight: snap.CurrentEpochSnapshotMetadata.SnapshotBlockHeight,
+				BlockHash:           snap.CurrentEpochSnapshotMetadata.CurrentEpochBlockHash,
+				ChecksumBytes:       snap.CurrentEpochSnapshotMetadata.CurrentEpochChecksumBytes,
+			})
+		}
 	}
 }
 
+// SetFaultInjector attaches the fault injector, which makes writes to the main db and ancestral records fail on demand.
+// It should be set before the node starts syncing. Mainly used in testing.
+func (snap *Snapshot) SetFaultInjector(injector *DBFaultInjector) {
+	snap.faultInjector = injector
+}
+
+// checkWriteFault returns an error if the fault injector wants the next write to fail. It's safe to call on a nil
+// snapshot, since writes are made without a snapshot when hypersync is disabled.
+func (snap *Snapshot) checkWriteFault() error {
+	if snap == nil || snap.faultInjector == nil {
+		return nil
+	}
+	return snap.faultInjector.checkWrite()
+}
+
 // isState determines if a key is a state-related record.
 func (snap *Snapshot) isState(key []byte) bool {
 	if !snap.isTxIndex {
@@ -1807,6 +1910,38 @@ func (metadata *SnapshotEpochMetadata) FromBytes(rr *bytes.Reader) error {
 	return nil
 }
 
+// ErrSnapshotPeriodMismatch is wrapped by the error of ValidateSnapshotEpochHeight when a peer serves a snapshot that
+// the node can't hypersync from, because the peer's snapshot period differs from the node's.
+var ErrSnapshotPeriodMismatch = errors.New("snapshot period mismatch")
+
+// ErrSnapshotBehindEpoch is wrapped by the error of ValidateSnapshotEpochHeight when a peer serves a snapshot that's
+// older than the one the node expects. The peer is either behind, or its snapshot period doesn't divide the expected
+// height, which the snapshot metadata alone can't tell apart.
+var ErrSnapshotBehindEpoch = errors.New("snapshot behind the expected epoch")
+
+// ValidateSnapshotEpochHeight checks if a node with the snapshot period can hypersync from a snapshot at
+// snapshotHeight, which is the epoch height in the snapshot metadata the peer served. The node expects the snapshot at
+// expectedHeight, which is the last multiple of its period at or below its header tip. If the snapshot height isn't a
+// multiple of the node's period, the node would never take a snapshot at it, and the error wraps
+// ErrSnapshotPeriodMismatch. If the snapshot is older than the expected one, the error wraps ErrSnapshotBehindEpoch. A
+// snapshot at a later multiple of the period is a new epoch, which isn't an error. The peer's tip from the handshake
+// isn't used, because the peer's tip can reach the epoch height before its snapshot metadata does.
+func ValidateSnapshotEpochHeight(snapshotHeight uint64, expectedHeight uint64, period uint64) error {
+	if snapshotHeight == expectedHeight {
+		return nil
+	}
+	if snapshotHeight%period != 0 {
+		return fmt.Errorf("ValidateSnapshotEpochHeight: Snapshot height (%v) isn't a multiple of our snapshot "+
+			"period (%v), so we'd never take a snapshot at it: %w", snapshotHeight, period, ErrSnapshotPeriodMismatch)
+	}
+	if snapshotHeight < expectedHeight {
+		return fmt.Errorf("ValidateSnapshotEpochHeight: Snapshot height (%v) is below the expected height (%v), "+
+			"so the peer is either behind, or its snapshot period doesn't divide (%v) like ours (%v) does: %w",
+			snapshotHeight, expectedHeight, expectedHeight, period, ErrSnapshotBehindEpoch)
+	}
+	return nil
+}
+
 // -------------------------------------------------------------------------------------
 // AncestralCache
 // -------------------------------------------------------------------------------------
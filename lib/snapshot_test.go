@@ -350,3 +350,49 @@ func TestStateChecksumBirthdayParadox(t *testing.T) {
 	}
 	fmt.Println(totalElappsed)
 }
+
+// TestValidateSnapshotEpochHeight checks that a syncing node refuses the snapshots of peers whose snapshot period
+// doesn't match its own, and the snapshots that are older than the one it expects.
+func TestValidateSnapshotEpochHeight(t *testing.T) {
+	require := require.New(t)
+
+	// The peer's snapshot is at the expected height.
+	require.NoError(ValidateSnapshotEpochHeight(1000, 1000, 1000))
+	require.NoError(ValidateSnapshotEpochHeight(1000, 1000, 500))
+	// The peer entered a new epoch that's also one of ours.
+	require.NoError(ValidateSnapshotEpochHeight(2000, 1000, 500))
+
+	// A period-500 peer's snapshot is at 1500, which a period-1000 node would never take.
+	err := ValidateSnapshotEpochHeight(1500, 1000, 1000)
+	require.ErrorIs(err, ErrSnapshotPeriodMismatch)
+	require.Contains(err.Error(), "isn't a multiple of our snapshot period (1000)")
+	// A period-1000 peer's snapshot is at 1000, but a period-500 node expects it at 1500.
+	err = ValidateSnapshotEpochHeight(1000, 1500, 500)
+	require.ErrorIs(err, ErrSnapshotBehindEpoch)
+	require.Contains(err.Error(), "expected height (1500)")
+	// The peer has no snapshot yet.
+	err = ValidateSnapshotEpochHeight(0, 1500, 500)
+	require.ErrorIs(err, ErrSnapshotBehindEpoch)
+}
+
+// TestValidateSnapshotEpochHeightAtEpochBoundary checks the boundary case of a peer whose block tip reached the expected
+// epoch height before its snapshot metadata did, e.g. because it reported its tip in the handshake while it was still
+// processing the block at the epoch height. Its snapshot of the previous epoch is only behind, whatever its tip is,
+// while a snapshot a block away from an epoch height is a period mismatch.
+func TestValidateSnapshotEpochHeightAtEpochBoundary(t *testing.T) {
+	require := require.New(t)
+
+	period := uint64(1000)
+	for _, expectedHeight := range []uint64{period, 2 * period, 5 * period} {
+		require.NoError(ValidateSnapshotEpochHeight(expectedHeight, expectedHeight, period))
+
+		err := ValidateSnapshotEpochHeight(expectedHeight-period, expectedHeight, period)
+		require.ErrorIs(err, ErrSnapshotBehindEpoch, "expected height (%v)", expectedHeight)
+		require.NotErrorIs(err, ErrSnapshotPeriodMismatch, "expected height (%v)", expectedHeight)
+
+		for _, snapshotHeight := range []uint64{expectedHeight - 1, expectedHeight + 1} {
+			err = ValidateSnapshotEpochHeight(snapshotHeight, expectedHeight, period)
+			require.ErrorIs(err, ErrSnapshotPeriodMismatch, "snapshot height (%v)", snapshotHeight)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"bytes"
+	"runtime"
+	"sync"
+
+	"github.com/cloudflare/circl/group"
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// stateChecksumRangeKeys is the number of keys in every key range of a prefix that ComputeStateChecksum checksums as
+// a unit of work, so that a single large prefix is still spread over all the workers.
+const stateChecksumRangeKeys = 10000
+
+// stateChecksumRange is the keys under prefix from start inclusive to end exclusive. A nil end goes up to the end of
+// the prefix.
+type stateChecksumRange struct {
+	prefix []byte
+	start  []byte
+	end    []byte
+}
+
+// ComputeStateChecksum computes the checksum of the db's records under the prefixes, in the same way the snapshot
+// computes its checksum at blockHeight. Records whose keys skipKey returns true for, if it's set, aren't included. The
+// checksum is a sum of curve points, which doesn't depend on the order in which the records are added, so the prefixes
+// are split into key ranges of stateChecksumRangeKeys keys, which are checksummed concurrently by the workers, and the
+// partial sums are combined at the end. Zero workers uses GOMAXPROCS workers. The checksum is the same regardless of
+// the number of workers.
+func ComputeStateChecksum(db *badger.DB, prefixes [][]byte, blockHeight uint64, workers int,
+	skipKey func(key []byte) bool) ([]byte, error) {
+
+	if workers == 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	ranges, err := splitStateChecksumRanges(db, prefixes)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ComputeStateChecksum: Problem splitting prefixes into key ranges")
+	}
+
+	rangeIndexes := make(chan int, len(ranges))
+	for ii := range ranges {
+		rangeIndexes <- ii
+	}
+	close(rangeIndexes)
+	partialChecksums := make([]group.Element, workers)
+	workerErrs := make([]error, workers)
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			checksum := &StateChecksum{}
+			checksum.Initialize(nil, nil)
+			for ii := range rangeIndexes {
+				if err := addStateChecksumRange(db, checksum, ranges[ii], blockHeight, skipKey); err != nil {
+					workerErrs[worker] = err
+					return
+				}
+			}
+			partialChecksums[worker], workerErrs[worker] = checksum.GetChecksum()
+		}(worker)
+	}
+	wg.Wait()
+
+	combined := &StateChecksum{}
+	combined.Initialize(nil, nil)
+	for worker := 0; worker < workers; worker++ {
+		if workerErrs[worker] != nil {
+			return nil, errors.Wrapf(workerErrs[worker], "ComputeStateChecksum: Problem computing checksum")
+		}
+		combined.AddToChecksum(partialChecksums[worker])
+	}
+	return combined.ToBytes()
+}
+
+// splitStateChecksumRanges splits every prefix into ranges of stateChecksumRangeKeys keys. Only the keys are read, so
+// it's much faster than checksumming the records.
+func splitStateChecksumRanges(db *badger.DB, prefixes [][]byte) ([]stateChecksumRange, error) {
+	var ranges []stateChecksumRange
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		for _, prefix := range prefixes {
+			it := txn.NewIterator(opts)
+			start := prefix
+			numKeys := 0
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				if numKeys == stateChecksumRangeKeys {
+					end := it.Item().KeyCopy(nil)
+					ranges = append(ranges, stateChecksumRange{prefix: prefix, start: start, end: end})
+					start, numKeys = end, 0
+				}
+				numKeys++
+			}
+			it.Close()
+			ranges = append(ranges, stateChecksumRange{prefix: prefix, start: start})
+		}
+		return nil
+	})
+	return ranges, err
+}
+
+// addStateChecksumRange adds the db's records in the key range to the checksum, and waits for them to be added.
+func addStateChecksumRange(db *badger.DB, checksum *StateChecksum, keyRange stateChecksumRange, blockHeight uint64,
+	skipKey func(key []byte) bool) error {
+
+	err := db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for it.Seek(keyRange.start); it.ValidForPrefix(keyRange.prefix); it.Next() {
+			item := it.Item()
+			key := item.Key()
+			if keyRange.end != nil && bytes.Compare(key, keyRange.end) >= 0 {
+				break
+			}
+			if skipKey != nil && skipKey(key) {
+				continue
+			}
+			err := item.Value(func(value []byte) error {
+				return checksum.AddOrRemoveBytesWithMigrations(key, value, blockHeight, nil, true)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "addStateChecksumRange: Problem reading prefix (%v)", StatePrefixName(keyRange.prefix))
+	}
+	return checksum.Wait()
+}
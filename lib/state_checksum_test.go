@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+// writeChecksumTestEntries writes entriesPerPrefix entries under each of the prefixes, whose values depend on the key.
+func writeChecksumTestEntries(t testing.TB, db *badger.DB, prefixes [][]byte, entriesPerPrefix int) {
+	for _, prefix := range prefixes {
+		writeBatch := db.NewWriteBatch()
+		for ii := 0; ii < entriesPerPrefix; ii++ {
+			key := append(append([]byte{}, prefix...), EncodeUint64(uint64(ii))...)
+			if err := writeBatch.Set(key, append(EncodeUint64(uint64(ii)), prefix...)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := writeBatch.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestComputeStateChecksum(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	// The second prefix is split into several key ranges.
+	prefixes := [][]byte{{1}, {2}, {3}}
+	writeChecksumTestEntries(t, db, prefixes[:1], 10)
+	writeChecksumTestEntries(t, db, prefixes[1:2], 2*stateChecksumRangeKeys+1)
+	const blockHeight = 10
+
+	// Compute the expected checksum by adding every entry serially to a single checksum.
+	expected := &StateChecksum{}
+	expected.Initialize(nil, nil)
+	require.NoError(db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+		for _, prefix := range prefixes {
+			for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+				value, err := it.Item().ValueCopy(nil)
+				require.NoError(err)
+				require.NoError(expected.AddOrRemoveBytesWithMigrations(it.Item().Key(), value, blockHeight, nil,
+					true))
+			}
+		}
+		return nil
+	}))
+	expectedBytes, err := expected.ToBytes()
+	require.NoError(err)
+
+	ranges, err := splitStateChecksumRanges(db, prefixes)
+	require.NoError(err)
+	require.Len(ranges, 5)
+
+	// The checksum is the same bit for bit, regardless of the number of workers.
+	for _, workers := range []int{1, 2, 7, 0} {
+		checksum, err := ComputeStateChecksum(db, prefixes, blockHeight, workers, nil)
+		require.NoError(err)
+		require.Equal(expectedBytes, checksum, "workers (%v)", workers)
+	}
+
+	// Skipping the first prefix gives the checksum of the second prefix alone.
+	skipFirst := func(key []byte) bool {
+		return key[0] == prefixes[0][0]
+	}
+	skipped, err := ComputeStateChecksum(db, prefixes, blockHeight, 0, skipFirst)
+	require.NoError(err)
+	secondOnly, err := ComputeStateChecksum(db, prefixes[1:2], blockHeight, 1, nil)
+	require.NoError(err)
+	require.Equal(secondOnly, skipped)
+	require.NotEqual(expectedBytes, skipped)
+}
+
+// BenchmarkComputeStateChecksum checksums a database with a few prefixes, one of which is much larger than the others,
+// serially and with a worker per CPU.
+func BenchmarkComputeStateChecksum(b *testing.B) {
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	prefixes := [][]byte{{1}, {2}, {3}, {4}}
+	writeChecksumTestEntries(b, db, prefixes[:3], 2000)
+	writeChecksumTestEntries(b, db, prefixes[3:], 50000)
+
+	for _, workers := range []int{1, runtime.GOMAXPROCS(0)} {
+		b.Run(fmt.Sprintf("Workers%v", workers), func(b *testing.B) {
+			for ii := 0; ii < b.N; ii++ {
+				if _, err := ComputeStateChecksum(db, prefixes, 0, workers, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
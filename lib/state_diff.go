@@ -0,0 +1,435 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"runtime"
+	"sort"
+	"sync"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// StateChunkReader reads a chunk of the state entries with the prefix, starting from startKey inclusive, in the same
+// way as DBIteratePrefixKeys. This lets DiffStates compare state that isn't in a live database, e.g. a state dump.
+type StateChunkReader func(prefix []byte, startKey []byte, targetBytes uint32) (
+	_dbEntries []*DBEntry, _isChunkFull bool, _err error)
+
+// NewDBStateChunkReader returns a StateChunkReader over the db.
+func NewDBStateChunkReader(db *badger.DB) StateChunkReader {
+	return func(prefix []byte, startKey []byte, targetBytes uint32) ([]*DBEntry, bool, error) {
+		return DBIteratePrefixKeys(db, prefix, startKey, targetBytes)
+	}
+}
+
+// DiffOptions configures DiffNodeStates and DiffStates.
+type DiffOptions struct {
+	// MaxKeysPerPrefix caps the number of keys listed for every kind of difference in a prefix, so that the diff of
+	// two very different databases stays small. The counts in PrefixDiff are always exact. Zero lists all keys.
+	MaxKeysPerPrefix int
+	// LogKeysPerPrefix caps the number of differing keys of every prefix that callers which log the diff, such as the
+	// integration test compare helpers, log in detail. The remaining differences are only counted, and summarized.
+	// It doesn't change the diff itself. Zero uses the caller's default, and a negative value logs every key.
+	LogKeysPerPrefix int
+	// ChunkBytes is the size of the chunks that the state is read in. Zero reads chunks of SnapshotBatchSize.
+	ChunkBytes uint32
+	// Workers is the number of prefixes that are compared concurrently. Zero uses a worker per CPU, and one compares
+	// the prefixes sequentially. The diff is the same regardless of the number of workers.
+	Workers int
+	// SkipPrefixes are prefixes whose keys aren't compared, e.g. node-local metadata that's expected to differ.
+	SkipPrefixes [][]byte
+	// SkipKeyFunc, if set, returns true for keys that aren't compared, e.g. specific keys that are expected to differ.
+	SkipKeyFunc func(key []byte) bool
+	// ExpectedDifferences are differences that must occur between the states. Unlike skipped keys, the keys of an
+	// expected difference are compared, and the diff reports every expected difference that didn't occur.
+	ExpectedDifferences []ExpectedDifference
+	// Semantic compares the values of prefixes that are stored as a DeSoEncoder by decoding them, rather than by their
+	// bytes. Values whose bytes differ, but that decode to equal entries, e.g. because they were encoded under
+	// different encoder migrations, are reported as byte-level-only differences, which don't make the states differ.
+	// Values of other prefixes are compared by their bytes.
+	Semantic bool
+	// ProgressFunc, if set, is called after every chunk read from either state, and once every prefix was compared, so
+	// that long comparisons can report their progress. It's called from all workers, so it must be safe for
+	// concurrent use.
+	ProgressFunc func(progress DiffProgress)
+}
+
+// DiffProgress is the progress of a comparison reported to DiffOptions.ProgressFunc.
+type DiffProgress struct {
+	Prefix []byte
+	// NumEntries is the number of entries read from either state under the prefix since the previous report.
+	NumEntries int
+	// PrefixDone is set on the last report of the prefix, once all of its entries were compared.
+	PrefixDone bool
+}
+
+// ExpectedDifference describes keys under Prefix that must differ between the states, by being missing in either
+// state or having different values. KeyFunc selects the keys, or all keys of the prefix if it's nil. The difference is
+// met if at least one selected key differs, and the differences of all selected keys are expected.
+type ExpectedDifference struct {
+	Prefix  []byte
+	KeyFunc func(key []byte) bool
+	// Description names the difference in the diff if it didn't occur, e.g. "archival node stores block 5".
+	Description string
+}
+
+// matches returns true if the key is selected by the expected difference.
+func (expected ExpectedDifference) matches(key []byte) bool {
+	return bytes.HasPrefix(key, expected.Prefix) && (expected.KeyFunc == nil || expected.KeyFunc(key))
+}
+
+// SkipsKey returns true if the key is excluded from the comparison by SkipPrefixes or SkipKeyFunc.
+func (opts DiffOptions) SkipsKey(key []byte) bool {
+	for _, skipPrefix := range opts.SkipPrefixes {
+		if bytes.HasPrefix(key, skipPrefix) {
+			return true
+		}
+	}
+	return opts.SkipKeyFunc != nil && opts.SkipKeyFunc(key)
+}
+
+// skipsWholePrefix returns true if all keys with the prefix are skipped by SkipPrefixes.
+func (opts DiffOptions) skipsWholePrefix(prefix []byte) bool {
+	for _, skipPrefix := range opts.SkipPrefixes {
+		if bytes.HasPrefix(prefix, skipPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// SkipsAnything returns true if the options exclude any keys from the comparison.
+func (opts DiffOptions) SkipsAnything() bool {
+	return len(opts.SkipPrefixes) > 0 || opts.SkipKeyFunc != nil
+}
+
+// StateDiff is the difference between two states, A and B, with an entry for every compared prefix that differs.
+// Keys and values are hex-encoded, so that the diff can be serialized to JSON as is.
+type StateDiff struct {
+	Prefixes []*PrefixDiff `json:"prefixes"`
+	// MissingExpectedDifferences are the descriptions of the DiffOptions.ExpectedDifferences that didn't occur.
+	MissingExpectedDifferences []string `json:"missingExpectedDifferences,omitempty"`
+	// Semantic is set if the values were compared with DiffOptions.Semantic, so that the value mismatches are all
+	// semantic differences.
+	Semantic bool `json:"semantic,omitempty"`
+}
+
+// PrefixDiff is the difference between two states under a single prefix.
+type PrefixDiff struct {
+	Prefix string `json:"prefix"`
+	// MissingInA are the keys that are only in B, and MissingInB the keys that are only in A.
+	MissingInA    []string        `json:"missingInA,omitempty"`
+	MissingInB    []string        `json:"missingInB,omitempty"`
+	ValueMismatch []ValueMismatch `json:"valueMismatch,omitempty"`
+	// ByteLevelOnly are the values whose bytes differ, but that decode to equal entries, with DiffOptions.Semantic.
+	// They aren't included in ValueMismatch, which then only has the semantic differences.
+	ByteLevelOnly []ValueMismatch `json:"byteLevelOnly,omitempty"`
+	// The counts include the keys that weren't listed because of DiffOptions.MaxKeysPerPrefix.
+	NumMissingInA    int `json:"numMissingInA"`
+	NumMissingInB    int `json:"numMissingInB"`
+	NumValueMismatch int `json:"numValueMismatch"`
+	// NumExpected is the number of differences that were expected by DiffOptions.ExpectedDifferences. They aren't
+	// listed or included in the other counts.
+	NumExpected int `json:"numExpected,omitempty"`
+	// NumByteLevelOnly is the number of byte-level-only differences. They aren't included in the other counts.
+	NumByteLevelOnly int `json:"numByteLevelOnly,omitempty"`
+}
+
+// numDifferences returns the number of differences under the prefix, excluding the expected and byte-level-only
+// differences.
+func (prefixDiff *PrefixDiff) numDifferences() int {
+	return prefixDiff.NumMissingInA + prefixDiff.NumMissingInB + prefixDiff.NumValueMismatch
+}
+
+// ValueMismatch is a key that is in both states, with different values.
+type ValueMismatch struct {
+	Key    string `json:"key"`
+	ValueA string `json:"valueA"`
+	ValueB string `json:"valueB"`
+}
+
+// IsEmpty returns true if the states are identical under all compared prefixes, except for the expected differences,
+// which all occurred, and the byte-level-only differences.
+func (diff *StateDiff) IsEmpty() bool {
+	return diff.NumDifferences() == 0 && len(diff.MissingExpectedDifferences) == 0
+}
+
+// BrokenPrefixes returns the prefixes under which the states differ, not counting byte-level-only differences.
+func (diff *StateDiff) BrokenPrefixes() [][]byte {
+	var prefixes [][]byte
+	for _, prefixDiff := range diff.Prefixes {
+		if prefixDiff.numDifferences() == 0 {
+			continue
+		}
+		prefix, _ := hex.DecodeString(prefixDiff.Prefix)
+		prefixes = append(prefixes, prefix)
+	}
+	return prefixes
+}
+
+// NumDifferences returns the number of differing keys under all compared prefixes, including the keys that weren't
+// listed because of DiffOptions.MaxKeysPerPrefix. Expected differences aren't counted.
+func (diff *StateDiff) NumDifferences() int {
+	numDifferences := 0
+	for _, prefixDiff := range diff.Prefixes {
+		numDifferences += prefixDiff.numDifferences()
+	}
+	return numDifferences
+}
+
+// DiffNodeStates compares the entries of dbA and dbB under the prefixes, and returns their differences.
+func DiffNodeStates(dbA *badger.DB, dbB *badger.DB, prefixes [][]byte, opts DiffOptions) (*StateDiff, error) {
+	return DiffStates(NewDBStateChunkReader(dbA), NewDBStateChunkReader(dbB), prefixes, opts)
+}
+
+// DiffStates compares the entries read by readA and readB under the prefixes, and returns their differences. The diff
+// lists every prefix with differences, including prefixes with only byte-level-only differences. Every
+// prefix is read chunk by chunk from both states, which are merged in key order, so that the states don't have to fit
+// into memory. Prefixes are compared concurrently by DiffOptions.Workers, so the readers must be safe for concurrent
+// use, but the diff always lists the prefixes in increasing order.
+func DiffStates(readA StateChunkReader, readB StateChunkReader, prefixes [][]byte, opts DiffOptions) (
+	*StateDiff, error) {
+
+	if opts.ChunkBytes == 0 {
+		opts.ChunkBytes = SnapshotBatchSize
+	}
+	if opts.Workers == 0 {
+		opts.Workers = runtime.NumCPU()
+	}
+	var sortedPrefixes [][]byte
+	for _, prefix := range prefixes {
+		// Prefixes that are skipped as a whole don't need to be read at all.
+		if !opts.skipsWholePrefix(prefix) {
+			sortedPrefixes = append(sortedPrefixes, prefix)
+		}
+	}
+	sort.Slice(sortedPrefixes, func(ii, jj int) bool {
+		return bytes.Compare(sortedPrefixes[ii], sortedPrefixes[jj]) < 0
+	})
+
+	// Every worker writes the results of the prefixes it compares to their index, so that the diff doesn't depend on
+	// the order in which the prefixes complete.
+	prefixDiffs := make([]*PrefixDiff, len(sortedPrefixes))
+	prefixExpected := make([][]bool, len(sortedPrefixes))
+	prefixErrs := make([]error, len(sortedPrefixes))
+	prefixIndexes := make(chan int, len(sortedPrefixes))
+	for ii := range sortedPrefixes {
+		prefixIndexes <- ii
+	}
+	close(prefixIndexes)
+	var wg sync.WaitGroup
+	for worker := 0; worker < opts.Workers && worker < len(sortedPrefixes); worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ii := range prefixIndexes {
+				prefixDiffs[ii], prefixExpected[ii], prefixErrs[ii] = diffPrefix(readA, readB, sortedPrefixes[ii], opts)
+			}
+		}()
+	}
+	wg.Wait()
+
+	diff := &StateDiff{Semantic: opts.Semantic}
+	occurred := make([]bool, len(opts.ExpectedDifferences))
+	for ii, prefixDiff := range prefixDiffs {
+		if prefixErrs[ii] != nil {
+			return nil, errors.Wrapf(prefixErrs[ii], "DiffStates: Problem comparing prefix (%v)", sortedPrefixes[ii])
+		}
+		if prefixDiff.numDifferences()+prefixDiff.NumByteLevelOnly > 0 {
+			diff.Prefixes = append(diff.Prefixes, prefixDiff)
+		}
+		for jj, prefixOccurred := range prefixExpected[ii] {
+			occurred[jj] = occurred[jj] || prefixOccurred
+		}
+	}
+	for ii, expected := range opts.ExpectedDifferences {
+		if !occurred[ii] {
+			diff.MissingExpectedDifferences = append(diff.MissingExpectedDifferences, expected.Description)
+		}
+	}
+	return diff, nil
+}
+
+// diffPrefix compares the entries of a prefix, and also returns which of the DiffOptions.ExpectedDifferences occurred
+// under the prefix.
+func diffPrefix(readA StateChunkReader, readB StateChunkReader, prefix []byte, opts DiffOptions) (
+	_prefixDiff *PrefixDiff, _expectedOccurred []bool, _err error) {
+
+	prefixDiff := &PrefixDiff{Prefix: hex.EncodeToString(prefix)}
+	canList := func(numListed int) bool {
+		return opts.MaxKeysPerPrefix == 0 || numListed < opts.MaxKeysPerPrefix
+	}
+	expectedOccurred := make([]bool, len(opts.ExpectedDifferences))
+	isExpected := func(key []byte) bool {
+		isExpected := false
+		for ii, expected := range opts.ExpectedDifferences {
+			if expected.matches(key) {
+				expectedOccurred[ii] = true
+				isExpected = true
+			}
+		}
+		if isExpected {
+			prefixDiff.NumExpected++
+		}
+		return isExpected
+	}
+
+	var onChunk func(numEntries int)
+	if opts.ProgressFunc != nil {
+		onChunk = func(numEntries int) {
+			opts.ProgressFunc(DiffProgress{Prefix: prefix, NumEntries: numEntries})
+		}
+		defer func() {
+			if _err == nil {
+				opts.ProgressFunc(DiffProgress{Prefix: prefix, PrefixDone: true})
+			}
+		}()
+	}
+	iterA := &stateEntryIterator{read: readA, prefix: prefix, chunkBytes: opts.ChunkBytes, skipKey: opts.SkipsKey,
+		onChunk: onChunk}
+	iterB := &stateEntryIterator{read: readB, prefix: prefix, chunkBytes: opts.ChunkBytes, skipKey: opts.SkipsKey,
+		onChunk: onChunk}
+	entryA, err := iterA.next()
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "diffPrefix: Problem reading state A")
+	}
+	entryB, err := iterB.next()
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "diffPrefix: Problem reading state B")
+	}
+	for entryA != nil || entryB != nil {
+		cmp := 0
+		if entryA == nil {
+			cmp = 1
+		} else if entryB == nil {
+			cmp = -1
+		} else {
+			cmp = bytes.Compare(entryA.Key, entryB.Key)
+		}
+
+		switch {
+		// Missing keys that are expected to differ are only counted as expected.
+		case cmp < 0 && isExpected(entryA.Key):
+		case cmp > 0 && isExpected(entryB.Key):
+		case cmp < 0:
+			prefixDiff.NumMissingInB++
+			if canList(len(prefixDiff.MissingInB)) {
+				prefixDiff.MissingInB = append(prefixDiff.MissingInB, hex.EncodeToString(entryA.Key))
+			}
+		case cmp > 0:
+			prefixDiff.NumMissingInA++
+			if canList(len(prefixDiff.MissingInA)) {
+				prefixDiff.MissingInA = append(prefixDiff.MissingInA, hex.EncodeToString(entryB.Key))
+			}
+		case bytes.Equal(entryA.Value, entryB.Value) || isExpected(entryA.Key):
+		case opts.Semantic && stateValuesSemanticallyEqual(entryA.Key, entryA.Value, entryB.Value):
+			prefixDiff.NumByteLevelOnly++
+			if canList(len(prefixDiff.ByteLevelOnly)) {
+				prefixDiff.ByteLevelOnly = append(prefixDiff.ByteLevelOnly, ValueMismatch{
+					Key:    hex.EncodeToString(entryA.Key),
+					ValueA: hex.EncodeToString(entryA.Value),
+					ValueB: hex.EncodeToString(entryB.Value),
+				})
+			}
+		default:
+			prefixDiff.NumValueMismatch++
+			if canList(len(prefixDiff.ValueMismatch)) {
+				prefixDiff.ValueMismatch = append(prefixDiff.ValueMismatch, ValueMismatch{
+					Key:    hex.EncodeToString(entryA.Key),
+					ValueA: hex.EncodeToString(entryA.Value),
+					ValueB: hex.EncodeToString(entryB.Value),
+				})
+			}
+		}
+
+		if cmp <= 0 {
+			if entryA, err = iterA.next(); err != nil {
+				return nil, nil, errors.Wrapf(err, "diffPrefix: Problem reading state A")
+			}
+		}
+		if cmp >= 0 {
+			if entryB, err = iterB.next(); err != nil {
+				return nil, nil, errors.Wrapf(err, "diffPrefix: Problem reading state B")
+			}
+		}
+	}
+	return prefixDiff, expectedOccurred, nil
+}
+
+// stateValuesSemanticallyEqual returns true if the key's prefix is stored as a DeSoEncoder, and both values decode to
+// equal entries.
+func stateValuesSemanticallyEqual(key []byte, valueA []byte, valueB []byte) bool {
+	isEncoder, encoderA := StateKeyToDeSoEncoder(key)
+	if !isEncoder || encoderA == nil {
+		return false
+	}
+	_, encoderB := StateKeyToDeSoEncoder(key)
+	if exists, err := DecodeFromBytes(encoderA, bytes.NewReader(valueA)); !exists || err != nil {
+		return false
+	}
+	if exists, err := DecodeFromBytes(encoderB, bytes.NewReader(valueB)); !exists || err != nil {
+		return false
+	}
+	return reflect.DeepEqual(encoderA, encoderB)
+}
+
+// stateEntryIterator iterates over the entries of a prefix in key order, reading them chunk by chunk.
+type stateEntryIterator struct {
+	read       StateChunkReader
+	prefix     []byte
+	chunkBytes uint32
+	// skipKey returns true for keys that the iterator skips.
+	skipKey func(key []byte) bool
+	// onChunk, if set, is called with the number of entries of every chunk read.
+	onChunk func(numEntries int)
+
+	chunk []*DBEntry
+	index int
+	// lastKey is the key of the last entry returned, which the next chunk starts with.
+	lastKey []byte
+	// exhausted is set once the last chunk of the prefix was read.
+	exhausted bool
+}
+
+// next returns the next entry that isn't skipped, or nil once all entries of the prefix were returned.
+func (iter *stateEntryIterator) next() (*DBEntry, error) {
+	for {
+		entry, err := iter.nextEntry()
+		if err != nil || entry == nil || iter.skipKey == nil || !iter.skipKey(entry.Key) {
+			return entry, err
+		}
+	}
+}
+
+// nextEntry returns the next entry, or nil once all entries of the prefix were returned.
+func (iter *stateEntryIterator) nextEntry() (*DBEntry, error) {
+	for iter.index >= len(iter.chunk) {
+		if iter.exhausted {
+			return nil, nil
+		}
+		startKey := iter.prefix
+		if iter.lastKey != nil {
+			startKey = iter.lastKey
+		}
+		chunk, isChunkFull, err := iter.read(iter.prefix, startKey, iter.chunkBytes)
+		if err != nil {
+			return nil, err
+		}
+		if iter.onChunk != nil {
+			iter.onChunk(len(chunk))
+		}
+		// Chunks start with the start key if it exists, which was already returned.
+		if iter.lastKey != nil && len(chunk) > 0 && bytes.Equal(chunk[0].Key, iter.lastKey) {
+			chunk = chunk[1:]
+		}
+		iter.chunk = chunk
+		iter.index = 0
+		iter.exhausted = !isChunkFull || len(chunk) == 0
+	}
+	entry := iter.chunk[iter.index]
+	iter.index++
+	iter.lastKey = entry.Key
+	return entry, nil
+}
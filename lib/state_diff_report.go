@@ -0,0 +1,169 @@
+package lib
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// StateDiffReport is a StateDiff with every key and value also described in a human-readable form, with
+// DescribeStateKey and DescribeStateValue. It's what gets saved when a state comparison fails, so that the divergence
+// can be inspected without re-running the comparison.
+type StateDiffReport struct {
+	Prefixes                   []*PrefixDiffReport `json:"prefixes"`
+	MissingExpectedDifferences []string            `json:"missingExpectedDifferences,omitempty"`
+	Semantic                   bool                `json:"semantic,omitempty"`
+}
+
+// PrefixDiffReport is a PrefixDiff with described keys and values.
+type PrefixDiffReport struct {
+	Prefix     string `json:"prefix"`
+	PrefixName string `json:"prefixName"`
+
+	MissingInA    []StateKeyReport      `json:"missingInA,omitempty"`
+	MissingInB    []StateKeyReport      `json:"missingInB,omitempty"`
+	ValueMismatch []ValueMismatchReport `json:"valueMismatch,omitempty"`
+	ByteLevelOnly []ValueMismatchReport `json:"byteLevelOnly,omitempty"`
+
+	NumMissingInA    int `json:"numMissingInA"`
+	NumMissingInB    int `json:"numMissingInB"`
+	NumValueMismatch int `json:"numValueMismatch"`
+	NumByteLevelOnly int `json:"numByteLevelOnly,omitempty"`
+	// NumOmitted is the number of differences that were counted, but not listed, e.g. because of
+	// DiffOptions.MaxKeysPerPrefix.
+	NumOmitted int `json:"numOmitted"`
+}
+
+// StateKeyReport is a hex-encoded key, and its description.
+type StateKeyReport struct {
+	Key            string `json:"key"`
+	KeyDescription string `json:"keyDescription"`
+}
+
+// ValueMismatchReport is a ValueMismatch with the key and both values described.
+type ValueMismatchReport struct {
+	StateKeyReport
+	ValueA            string `json:"valueA"`
+	ValueB            string `json:"valueB"`
+	ValueADescription string `json:"valueADescription"`
+	ValueBDescription string `json:"valueBDescription"`
+}
+
+// NewStateDiffReport describes every key and value listed in the diff.
+func NewStateDiffReport(diff *StateDiff) *StateDiffReport {
+	report := &StateDiffReport{MissingExpectedDifferences: diff.MissingExpectedDifferences, Semantic: diff.Semantic}
+	for _, prefixDiff := range diff.Prefixes {
+		prefix, _ := hex.DecodeString(prefixDiff.Prefix)
+		prefixReport := &PrefixDiffReport{
+			Prefix:           prefixDiff.Prefix,
+			PrefixName:       StatePrefixName(prefix),
+			NumMissingInA:    prefixDiff.NumMissingInA,
+			NumMissingInB:    prefixDiff.NumMissingInB,
+			NumValueMismatch: prefixDiff.NumValueMismatch,
+			NumByteLevelOnly: prefixDiff.NumByteLevelOnly,
+			NumOmitted: prefixDiff.NumMissingInA + prefixDiff.NumMissingInB + prefixDiff.NumValueMismatch +
+				prefixDiff.NumByteLevelOnly - len(prefixDiff.MissingInA) - len(prefixDiff.MissingInB) -
+				len(prefixDiff.ValueMismatch) - len(prefixDiff.ByteLevelOnly),
+		}
+		for _, keyHex := range prefixDiff.MissingInA {
+			prefixReport.MissingInA = append(prefixReport.MissingInA, newStateKeyReport(keyHex))
+		}
+		for _, keyHex := range prefixDiff.MissingInB {
+			prefixReport.MissingInB = append(prefixReport.MissingInB, newStateKeyReport(keyHex))
+		}
+		for _, mismatch := range prefixDiff.ValueMismatch {
+			prefixReport.ValueMismatch = append(prefixReport.ValueMismatch, newValueMismatchReport(mismatch))
+		}
+		for _, mismatch := range prefixDiff.ByteLevelOnly {
+			prefixReport.ByteLevelOnly = append(prefixReport.ByteLevelOnly, newValueMismatchReport(mismatch))
+		}
+		report.Prefixes = append(report.Prefixes, prefixReport)
+	}
+	return report
+}
+
+func newStateKeyReport(keyHex string) StateKeyReport {
+	key, _ := hex.DecodeString(keyHex)
+	return StateKeyReport{Key: keyHex, KeyDescription: DescribeStateKey(key)}
+}
+
+func newValueMismatchReport(mismatch ValueMismatch) ValueMismatchReport {
+	key, _ := hex.DecodeString(mismatch.Key)
+	valueA, _ := hex.DecodeString(mismatch.ValueA)
+	valueB, _ := hex.DecodeString(mismatch.ValueB)
+	return ValueMismatchReport{
+		StateKeyReport:    newStateKeyReport(mismatch.Key),
+		ValueA:            mismatch.ValueA,
+		ValueB:            mismatch.ValueB,
+		ValueADescription: DescribeStateValue(key, valueA),
+		ValueBDescription: DescribeStateValue(key, valueB),
+	}
+}
+
+// Lines describes every difference in the report on a line, starting with a summary line of every prefix.
+func (report *StateDiffReport) Lines() []string {
+	valueMismatch := "unequal values"
+	if report.Semantic {
+		valueMismatch = "semantically unequal values"
+	}
+	var lines []string
+	for _, prefixReport := range report.Prefixes {
+		summary := fmt.Sprintf("prefix %v: (%v) keys missing in A, (%v) keys missing in B, (%v) %v",
+			prefixReport.PrefixName, prefixReport.NumMissingInA, prefixReport.NumMissingInB,
+			prefixReport.NumValueMismatch, valueMismatch)
+		if prefixReport.NumByteLevelOnly > 0 {
+			summary += fmt.Sprintf(", (%v) byte-level-only differences", prefixReport.NumByteLevelOnly)
+		}
+		lines = append(lines, summary)
+		for _, keyReport := range prefixReport.MissingInA {
+			lines = append(lines, fmt.Sprintf("key %v: missing in A", keyReport.KeyDescription))
+		}
+		for _, keyReport := range prefixReport.MissingInB {
+			lines = append(lines, fmt.Sprintf("key %v: missing in B", keyReport.KeyDescription))
+		}
+		for _, mismatch := range prefixReport.ValueMismatch {
+			lines = append(lines, fmt.Sprintf("key %v: %v, A (%v), B (%v)", mismatch.KeyDescription, valueMismatch,
+				mismatch.ValueADescription, mismatch.ValueBDescription))
+		}
+		for _, mismatch := range prefixReport.ByteLevelOnly {
+			lines = append(lines, fmt.Sprintf("key %v: byte-level-only difference, A (%v), B (%v)",
+				mismatch.KeyDescription, mismatch.ValueA, mismatch.ValueB))
+		}
+		if prefixReport.NumOmitted > 0 {
+			lines = append(lines, fmt.Sprintf("and (%v) more differences in prefix %v", prefixReport.NumOmitted,
+				prefixReport.PrefixName))
+		}
+	}
+	for _, description := range report.MissingExpectedDifferences {
+		lines = append(lines, fmt.Sprintf("expected difference didn't occur: %v", description))
+	}
+	return lines
+}
+
+// WriteStateDiffReport writes the report to path as indented JSON.
+func WriteStateDiffReport(path string, report *StateDiffReport) error {
+	reportBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return errors.Wrapf(err, "WriteStateDiffReport: Problem encoding report")
+	}
+	if err := os.WriteFile(path, reportBytes, 0644); err != nil {
+		return errors.Wrapf(err, "WriteStateDiffReport: Problem writing report to (%v)", path)
+	}
+	return nil
+}
+
+// ReadStateDiffReport reads a report written by WriteStateDiffReport.
+func ReadStateDiffReport(path string) (*StateDiffReport, error) {
+	reportBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ReadStateDiffReport: Problem reading report from (%v)", path)
+	}
+	report := &StateDiffReport{}
+	if err := json.Unmarshal(reportBytes, report); err != nil {
+		return nil, errors.Wrapf(err, "ReadStateDiffReport: Problem decoding report from (%v)", path)
+	}
+	return report, nil
+}
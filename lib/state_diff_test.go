@@ -0,0 +1,460 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiffNodeStates(t *testing.T) {
+	require := require.New(t)
+
+	dbA, dirA := GetTestBadgerDb()
+	defer os.RemoveAll(dirA)
+	defer dbA.Close()
+	dbB, dirB := GetTestBadgerDb()
+	defer os.RemoveAll(dirB)
+	defer dbB.Close()
+
+	put := func(db *badger.DB, entries map[string]string) {
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			for key, value := range entries {
+				if err := txn.Set([]byte(key), []byte(value)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	// Prefix "a" differs in every way, prefix "b" is identical, and prefix "c" only exists in A.
+	put(dbA, map[string]string{"a1": "x", "a2": "y", "a3": "z", "b1": "x", "c1": "x", "c2": "x", "c3": "x"})
+	put(dbB, map[string]string{"a2": "y", "a3": "w", "a4": "x", "b1": "x"})
+
+	// Small chunks make sure that the diff is merged correctly across chunk boundaries.
+	diff, err := DiffNodeStates(dbA, dbB, [][]byte{[]byte("c"), []byte("b"), []byte("a")},
+		DiffOptions{ChunkBytes: 1})
+	require.NoError(err)
+	require.False(diff.IsEmpty())
+	require.Equal([][]byte{[]byte("a"), []byte("c")}, diff.BrokenPrefixes())
+
+	prefixA := diff.Prefixes[0]
+	require.Equal([]string{"6134"}, prefixA.MissingInA)
+	require.Equal([]string{"6131"}, prefixA.MissingInB)
+	require.Equal([]ValueMismatch{{Key: "6133", ValueA: "7a", ValueB: "77"}}, prefixA.ValueMismatch)
+	require.Equal(1, prefixA.NumMissingInA)
+	require.Equal(1, prefixA.NumMissingInB)
+	require.Equal(1, prefixA.NumValueMismatch)
+	require.Equal([]string{"6331", "6332", "6333"}, diff.Prefixes[1].MissingInB)
+
+	// The diff round-trips through JSON.
+	diffBytes, err := json.Marshal(diff)
+	require.NoError(err)
+	decodedDiff := &StateDiff{}
+	require.NoError(json.Unmarshal(diffBytes, decodedDiff))
+	require.Equal(diff, decodedDiff)
+
+	// MaxKeysPerPrefix caps the listed keys, but not the counts.
+	diff, err = DiffNodeStates(dbB, dbA, [][]byte{[]byte("a"), []byte("c")}, DiffOptions{MaxKeysPerPrefix: 1})
+	require.NoError(err)
+	require.Equal([]string{"6331"}, diff.Prefixes[1].MissingInA)
+	require.Equal(3, diff.Prefixes[1].NumMissingInA)
+
+	// Skipped prefixes and keys aren't compared.
+	diff, err = DiffNodeStates(dbA, dbB, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, DiffOptions{
+		SkipPrefixes: [][]byte{[]byte("c")},
+		SkipKeyFunc: func(key []byte) bool {
+			return string(key) == "a1" || string(key) == "a3" || string(key) == "a4"
+		},
+	})
+	require.NoError(err)
+	require.True(diff.IsEmpty())
+
+	// Concurrent workers produce the same diff as a single worker.
+	allPrefixes := [][]byte{[]byte("c"), []byte("b"), []byte("a")}
+	sequentialDiff, err := DiffNodeStates(dbA, dbB, allPrefixes, DiffOptions{Workers: 1})
+	require.NoError(err)
+	for _, workers := range []int{2, 3, 8} {
+		parallelDiff, err := DiffNodeStates(dbA, dbB, allPrefixes, DiffOptions{Workers: workers})
+		require.NoError(err)
+		require.Equal(sequentialDiff, parallelDiff)
+	}
+
+	// Identical states have an empty diff.
+	diff, err = DiffNodeStates(dbA, dbA, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, DiffOptions{})
+	require.NoError(err)
+	require.True(diff.IsEmpty())
+}
+
+func TestDiffStatesSingleDifference(t *testing.T) {
+	require := require.New(t)
+
+	// A single inserted, deleted, or changed entry among many is reported as exactly that difference, and the entries
+	// after it are still matched by key, regardless of where the chunks end.
+	baseEntries := make(map[string]string)
+	for ii := 0; ii < 100; ii++ {
+		baseEntries[fmt.Sprintf("a%03d", ii)] = "x"
+	}
+	testCases := map[string]struct {
+		mutate   func(entries map[string]string)
+		expected *PrefixDiff
+	}{
+		"Insertion": {
+			mutate: func(entries map[string]string) {
+				entries["a050i"] = "x"
+			},
+			expected: &PrefixDiff{MissingInA: []string{hex.EncodeToString([]byte("a050i"))}, NumMissingInA: 1},
+		},
+		"Deletion": {
+			mutate: func(entries map[string]string) {
+				delete(entries, "a050")
+			},
+			expected: &PrefixDiff{MissingInB: []string{hex.EncodeToString([]byte("a050"))}, NumMissingInB: 1},
+		},
+		"LastDeletion": {
+			mutate: func(entries map[string]string) {
+				delete(entries, "a099")
+			},
+			expected: &PrefixDiff{MissingInB: []string{hex.EncodeToString([]byte("a099"))}, NumMissingInB: 1},
+		},
+		"ValueChange": {
+			mutate: func(entries map[string]string) {
+				entries["a050"] = "y"
+			},
+			expected: &PrefixDiff{
+				ValueMismatch:    []ValueMismatch{{Key: hex.EncodeToString([]byte("a050")), ValueA: "78", ValueB: "79"}},
+				NumValueMismatch: 1,
+			},
+		},
+	}
+	for name, testCase := range testCases {
+		entriesB := make(map[string]string)
+		for key, value := range baseEntries {
+			entriesB[key] = value
+		}
+		testCase.mutate(entriesB)
+
+		for _, chunkBytes := range []uint32{1, 50, 0} {
+			diff, err := DiffStates(newMapStateChunkReader(baseEntries), newMapStateChunkReader(entriesB),
+				[][]byte{[]byte("a")}, DiffOptions{ChunkBytes: chunkBytes})
+			require.NoError(err)
+			require.Len(diff.Prefixes, 1, name)
+			testCase.expected.Prefix = hex.EncodeToString([]byte("a"))
+			require.Equal(testCase.expected, diff.Prefixes[0], name)
+		}
+	}
+}
+
+// newMapStateChunkReader returns a StateChunkReader over the entries, which serves chunks in the same way as
+// DBIteratePrefixKeys.
+func newMapStateChunkReader(entries map[string]string) StateChunkReader {
+	var keys []string
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return func(prefix []byte, startKey []byte, targetBytes uint32) ([]*DBEntry, bool, error) {
+		var chunk []*DBEntry
+		var totalBytes uint32
+		for _, key := range keys {
+			if !bytes.HasPrefix([]byte(key), prefix) || bytes.Compare([]byte(key), startKey) < 0 {
+				continue
+			}
+			chunk = append(chunk, KeyValueToDBEntry([]byte(key), []byte(entries[key])))
+			totalBytes += uint32(len(key) + len(entries[key]))
+			if totalBytes > targetBytes {
+				return chunk, true, nil
+			}
+		}
+		return chunk, false, nil
+	}
+}
+
+func TestDiffExpectedDifferences(t *testing.T) {
+	require := require.New(t)
+
+	dbA, dirA := GetTestBadgerDb()
+	defer os.RemoveAll(dirA)
+	defer dbA.Close()
+	dbB, dirB := GetTestBadgerDb()
+	defer os.RemoveAll(dirB)
+	defer dbB.Close()
+
+	put := func(db *badger.DB, entries map[string]string) {
+		require.NoError(db.Update(func(txn *badger.Txn) error {
+			for key, value := range entries {
+				if err := txn.Set([]byte(key), []byte(value)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}))
+	}
+	// B has an extra key and a different value under prefix "a", and prefix "b" is identical.
+	put(dbA, map[string]string{"a1": "x", "a2": "y", "b1": "x"})
+	put(dbB, map[string]string{"a1": "x", "a2": "z", "a3": "x", "b1": "x"})
+	prefixes := [][]byte{[]byte("a"), []byte("b")}
+	keyIs := func(expectedKey string) func(key []byte) bool {
+		return func(key []byte) bool {
+			return string(key) == expectedKey
+		}
+	}
+
+	// The diff is empty if exactly the expected differences occur.
+	diff, err := DiffNodeStates(dbA, dbB, prefixes, DiffOptions{ExpectedDifferences: []ExpectedDifference{
+		{Prefix: []byte("a"), KeyFunc: keyIs("a2"), Description: "a2 changed"},
+		{Prefix: []byte("a"), KeyFunc: keyIs("a3"), Description: "a3 added"},
+	}})
+	require.NoError(err)
+	require.True(diff.IsEmpty())
+
+	// A difference that isn't expected is still reported.
+	diff, err = DiffNodeStates(dbA, dbB, prefixes, DiffOptions{ExpectedDifferences: []ExpectedDifference{
+		{Prefix: []byte("a"), KeyFunc: keyIs("a3"), Description: "a3 added"},
+	}})
+	require.NoError(err)
+	require.False(diff.IsEmpty())
+	require.Empty(diff.MissingExpectedDifferences)
+	require.Equal([]ValueMismatch{{Key: "6132", ValueA: "79", ValueB: "7a"}}, diff.Prefixes[0].ValueMismatch)
+	require.Zero(diff.Prefixes[0].NumMissingInA)
+	require.Equal(1, diff.Prefixes[0].NumExpected)
+
+	// An expected difference that doesn't occur is reported, even if the prefix is identical.
+	diff, err = DiffNodeStates(dbA, dbB, prefixes, DiffOptions{ExpectedDifferences: []ExpectedDifference{
+		{Prefix: []byte("a"), Description: "anything in a"},
+		{Prefix: []byte("b"), Description: "anything in b"},
+	}})
+	require.NoError(err)
+	require.False(diff.IsEmpty())
+	require.Empty(diff.Prefixes)
+	require.Equal([]string{"anything in b"}, diff.MissingExpectedDifferences)
+	require.Contains(NewStateDiffReport(diff).Lines(), "expected difference didn't occur: anything in b")
+}
+
+func TestDiffStatesSemantic(t *testing.T) {
+	require := require.New(t)
+
+	prefix := Prefixes.PrefixPostHashToPostEntry
+	postKey := func(id byte) string {
+		return string(append(append([]byte{}, prefix...), bytes.Repeat([]byte{id}, HashSizeBytes)...))
+	}
+	encodePost := func(body string) string {
+		return string(EncodeToBytes(0, &PostEntry{PostHash: &BlockHash{1}, Body: []byte(body)}))
+	}
+	// Post 1 is encoded with a trailing byte in B, which the decoder ignores, so it only differs byte-level. Post 2
+	// has a different body in B.
+	readA := newMapStateChunkReader(map[string]string{postKey(1): encodePost("hello"), postKey(2): encodePost("a")})
+	readB := newMapStateChunkReader(map[string]string{postKey(1): encodePost("hello") + "\x00",
+		postKey(2): encodePost("b")})
+
+	// Byte-level comparison reports both posts.
+	diff, err := DiffStates(readA, readB, [][]byte{prefix}, DiffOptions{})
+	require.NoError(err)
+	require.Equal(2, diff.Prefixes[0].NumValueMismatch)
+	require.Zero(diff.Prefixes[0].NumByteLevelOnly)
+
+	// Semantic comparison only reports post 2, and reports post 1 as a byte-level-only difference.
+	diff, err = DiffStates(readA, readB, [][]byte{prefix}, DiffOptions{Semantic: true})
+	require.NoError(err)
+	require.False(diff.IsEmpty())
+	require.Equal(1, diff.Prefixes[0].NumValueMismatch)
+	require.Equal(hex.EncodeToString([]byte(postKey(2))), diff.Prefixes[0].ValueMismatch[0].Key)
+	require.Equal(1, diff.Prefixes[0].NumByteLevelOnly)
+	require.Equal(hex.EncodeToString([]byte(postKey(1))), diff.Prefixes[0].ByteLevelOnly[0].Key)
+	lines := NewStateDiffReport(diff).Lines()
+	require.Contains(lines[0], "(1) semantically unequal values, (1) byte-level-only differences")
+	require.Contains(lines[1], "semantically unequal values")
+	require.Contains(lines[2], "byte-level-only difference")
+
+	// States that only differ byte-level are semantically equal, but the prefix is still listed.
+	readB = newMapStateChunkReader(map[string]string{postKey(1): encodePost("hello") + "\x00",
+		postKey(2): encodePost("a")})
+	diff, err = DiffStates(readA, readB, [][]byte{prefix}, DiffOptions{Semantic: true})
+	require.NoError(err)
+	require.True(diff.IsEmpty())
+	require.Empty(diff.BrokenPrefixes())
+	require.Equal(1, diff.Prefixes[0].NumByteLevelOnly)
+
+	// Prefixes without a DeSoEncoder are compared by their bytes.
+	balancePrefix := Prefixes.PrefixPublicKeyToDeSoBalanceNanos
+	balanceKey := string(append(append([]byte{}, balancePrefix...), 1))
+	diff, err = DiffStates(newMapStateChunkReader(map[string]string{balanceKey: "\x01"}),
+		newMapStateChunkReader(map[string]string{balanceKey: "\x01\x00"}), [][]byte{balancePrefix},
+		DiffOptions{Semantic: true})
+	require.NoError(err)
+	require.Equal(1, diff.Prefixes[0].NumValueMismatch)
+}
+
+func TestDescribeStateEntry(t *testing.T) {
+	require := require.New(t)
+
+	publicKey := make([]byte, 33)
+	publicKey[0] = 2
+	key := append(DbGetPrefixForPublicKeyToDesoBalanceNanos(), publicKey...)
+	require.Equal("PrefixPublicKeyToDeSoBalanceNanos", StatePrefixName(key))
+	require.Contains(DescribeStateKey(key), PkToStringTestnet(publicKey))
+	require.Equal("BalanceNanos (5)", DescribeStateValue(key, EncodeUint64(5)))
+
+	// Entries with a DeSoEncoder are decoded.
+	postHash := &BlockHash{1}
+	postKey := append(append([]byte{}, Prefixes.PrefixPostHashToPostEntry...), postHash[:]...)
+	postValue := EncodeToBytes(0, &PostEntry{PostHash: postHash, Body: []byte("hello")})
+	require.Contains(DescribeStateValue(postKey, postValue), "PostEntry")
+
+	// Undecodable values fall back to truncated hex.
+	require.Equal("0102", DescribeStateValue(key, []byte{1, 2}))
+	description := DescribeStateValue(key, make([]byte, stateEntryDescriptionMaxLen))
+	require.Contains(description, fmt.Sprintf("(%v more bytes)", stateEntryDescriptionMaxLen))
+}
+
+// BenchmarkDiffNodeStates compares two identical databases with many prefixes, sequentially and with a worker per CPU.
+func BenchmarkDiffNodeStates(b *testing.B) {
+	const numPrefixes = 32
+	const entriesPerPrefix = 5000
+
+	dbA, dirA := GetTestBadgerDb()
+	defer os.RemoveAll(dirA)
+	defer dbA.Close()
+	dbB, dirB := GetTestBadgerDb()
+	defer os.RemoveAll(dirB)
+	defer dbB.Close()
+
+	var prefixes [][]byte
+	for prefix := byte(0); prefix < numPrefixes; prefix++ {
+		prefixes = append(prefixes, []byte{prefix})
+		for _, db := range []*badger.DB{dbA, dbB} {
+			writeBatch := db.NewWriteBatch()
+			for ii := 0; ii < entriesPerPrefix; ii++ {
+				key := append([]byte{prefix}, EncodeUint64(uint64(ii))...)
+				if err := writeBatch.Set(key, make([]byte, 100)); err != nil {
+					b.Fatal(err)
+				}
+			}
+			if err := writeBatch.Flush(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	for _, workers := range []int{1, runtime.NumCPU()} {
+		b.Run(fmt.Sprintf("Workers%v", workers), func(b *testing.B) {
+			for ii := 0; ii < b.N; ii++ {
+				diff, err := DiffNodeStates(dbA, dbB, prefixes, DiffOptions{Workers: workers})
+				if err != nil || !diff.IsEmpty() {
+					b.Fatalf("Expected identical states, got diff (%v) and error (%v)", diff, err)
+				}
+			}
+		})
+	}
+}
+
+func TestStateDiffReport(t *testing.T) {
+	require := require.New(t)
+
+	publicKey := make([]byte, 33)
+	publicKey[0] = 2
+	key := append(DbGetPrefixForPublicKeyToDesoBalanceNanos(), publicKey...)
+	diff := &StateDiff{Prefixes: []*PrefixDiff{{
+		Prefix:     hex.EncodeToString(Prefixes.PrefixPublicKeyToDeSoBalanceNanos),
+		MissingInA: []string{hex.EncodeToString(key)},
+		ValueMismatch: []ValueMismatch{{
+			Key:    hex.EncodeToString(key),
+			ValueA: hex.EncodeToString(EncodeUint64(1)),
+			ValueB: hex.EncodeToString(EncodeUint64(2)),
+		}},
+		// Two of the missing keys weren't listed.
+		NumMissingInA:    3,
+		NumValueMismatch: 1,
+	}}}
+
+	report := NewStateDiffReport(diff)
+	require.Len(report.Prefixes, 1)
+	prefixReport := report.Prefixes[0]
+	require.Equal("PrefixPublicKeyToDeSoBalanceNanos", prefixReport.PrefixName)
+	require.Equal(2, prefixReport.NumOmitted)
+	require.Contains(prefixReport.MissingInA[0].KeyDescription, PkToStringTestnet(publicKey))
+	require.Equal("BalanceNanos (1)", prefixReport.ValueMismatch[0].ValueADescription)
+	require.Equal("BalanceNanos (2)", prefixReport.ValueMismatch[0].ValueBDescription)
+	require.Contains(report.Lines()[len(report.Lines())-1], "and (2) more differences in prefix")
+
+	// The report round-trips through a file.
+	dir, err := os.MkdirTemp("", "state_diff_report")
+	require.NoError(err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "diff.json")
+	require.NoError(WriteStateDiffReport(path, report))
+	readReport, err := ReadStateDiffReport(path)
+	require.NoError(err)
+	require.Equal(report, readReport)
+}
+
+// BenchmarkDiffStatesLargePrefix compares two large prefixes whose keys are spread differently, so that their chunks
+// never align, and reports the peak heap in use during the comparison. The peak stays flat as the prefixes grow,
+// since the comparison only holds a chunk of each state at a time.
+func BenchmarkDiffStatesLargePrefix(b *testing.B) {
+	prefix := []byte{1}
+	// State A has the keys 0, 1, 2, ..., and state B only has every third key, with different values.
+	readA := newGeneratedStateChunkReader(prefix, 1, 8)
+	readB := newGeneratedStateChunkReader(prefix, 3, 64)
+
+	for _, numEntries := range []uint64{100000, 1000000} {
+		b.Run(fmt.Sprintf("Entries%v", numEntries), func(b *testing.B) {
+			var peakHeapInUse uint64
+			var memStats runtime.MemStats
+			opts := DiffOptions{
+				ChunkBytes:       1 << 20,
+				MaxKeysPerPrefix: 10,
+				ProgressFunc: func(progress DiffProgress) {
+					runtime.ReadMemStats(&memStats)
+					if memStats.HeapInuse > peakHeapInUse {
+						peakHeapInUse = memStats.HeapInuse
+					}
+				},
+			}
+			for ii := 0; ii < b.N; ii++ {
+				diff, err := DiffStates(readA(numEntries), readB(numEntries), [][]byte{prefix}, opts)
+				numKeysB := int((numEntries + 2) / 3)
+				if err != nil || diff.Prefixes[0].NumMissingInB != int(numEntries)-numKeysB ||
+					diff.Prefixes[0].NumValueMismatch != numKeysB {
+					b.Fatalf("Unexpected diff (%v) or error (%v)", diff, err)
+				}
+			}
+			b.ReportMetric(float64(peakHeapInUse)/(1<<20), "peak-heap-MB")
+		})
+	}
+}
+
+// newGeneratedStateChunkReader returns readers of the entries under the prefix whose keys are the multiples of keyStep
+// below numEntries. The entries are generated as they're read, so that they never have to be held in memory.
+func newGeneratedStateChunkReader(prefix []byte, keyStep uint64, valueSize int) func(
+	numEntries uint64) StateChunkReader {
+
+	return func(numEntries uint64) StateChunkReader {
+		return func(_ []byte, startKey []byte, targetBytes uint32) ([]*DBEntry, bool, error) {
+			nextKey := uint64(0)
+			if len(startKey) > len(prefix) {
+				nextKey = DecodeUint64(startKey[len(prefix):])
+				nextKey += (keyStep - nextKey%keyStep) % keyStep
+			}
+			var chunk []*DBEntry
+			var totalBytes uint32
+			for ; nextKey < numEntries; nextKey += keyStep {
+				key := append(append([]byte{}, prefix...), EncodeUint64(nextKey)...)
+				chunk = append(chunk, KeyValueToDBEntry(key, make([]byte, valueSize)))
+				totalBytes += uint32(len(key) + valueSize)
+				if totalBytes > targetBytes {
+					return chunk, true, nil
+				}
+			}
+			return chunk, false, nil
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/pkg/errors"
+)
+
+// StateDumpOptions restricts the entries written by DumpStatePrefix, so that dumping a huge prefix doesn't flood the
+// output.
+type StateDumpOptions struct {
+	// StartKey and EndKey restrict the dump to the keys from StartKey inclusive to EndKey exclusive. They're full keys,
+	// including the prefix. Empty keys don't restrict the start or the end of the prefix.
+	StartKey []byte
+	EndKey   []byte
+	// Limit is the maximum number of entries written. Zero writes all entries.
+	Limit int
+}
+
+// DumpStatePrefix writes a line for every entry of the db under the prefix to w, in key order, with the hex key, the
+// length of the value, and the decoded value if a decoder is registered for the prefix, see DecodeStateValue. The
+// entries are read chunk by chunk with DBIteratePrefixKeys, so the prefix doesn't have to fit into memory. It returns
+// the number of entries written.
+func DumpStatePrefix(db *badger.DB, prefix []byte, w io.Writer, opts StateDumpOptions) (_numEntries int, _err error) {
+	read := func(prefix []byte, startKey []byte, targetBytes uint32) ([]*DBEntry, bool, error) {
+		if bytes.Compare(startKey, opts.StartKey) < 0 {
+			startKey = opts.StartKey
+		}
+		return DBIteratePrefixKeys(db, prefix, startKey, targetBytes)
+	}
+	iter := &stateEntryIterator{read: read, prefix: prefix, chunkBytes: SnapshotBatchSize}
+
+	numEntries := 0
+	for opts.Limit == 0 || numEntries < opts.Limit {
+		entry, err := iter.next()
+		if err != nil {
+			return numEntries, errors.Wrapf(err, "DumpStatePrefix: Problem reading prefix (%v)",
+				StatePrefixName(prefix))
+		}
+		if entry == nil || (len(opts.EndKey) > 0 && bytes.Compare(entry.Key, opts.EndKey) >= 0) {
+			break
+		}
+		line := fmt.Sprintf("%v (%v bytes)", hex.EncodeToString(entry.Key), len(entry.Value))
+		if description, decoded := DecodeStateValue(entry.Key, entry.Value); decoded {
+			line += ": " + description
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return numEntries, errors.Wrapf(err, "DumpStatePrefix: Problem writing entry")
+		}
+		numEntries++
+	}
+	return numEntries, nil
+}
@@ -0,0 +1,64 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/hex"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger/v3"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpStatePrefix(t *testing.T) {
+	require := require.New(t)
+
+	db, dir := GetTestBadgerDb()
+	defer os.RemoveAll(dir)
+	defer db.Close()
+
+	// Balances are decoded, and the entries of other prefixes aren't dumped.
+	balancePrefix := Prefixes.PrefixPublicKeyToDeSoBalanceNanos
+	var keys [][]byte
+	require.NoError(db.Update(func(txn *badger.Txn) error {
+		for ii := byte(0); ii < 5; ii++ {
+			key := append(append([]byte{}, balancePrefix...), bytes.Repeat([]byte{ii}, 33)...)
+			keys = append(keys, key)
+			if err := txn.Set(key, EncodeUint64(uint64(ii)*100)); err != nil {
+				return err
+			}
+		}
+		return txn.Set(append(append([]byte{}, Prefixes.PrefixPostHashToPostEntry...), 1), []byte{0, 2})
+	}))
+
+	dump := func(prefix []byte, opts StateDumpOptions) []string {
+		output := &bytes.Buffer{}
+		numEntries, err := DumpStatePrefix(db, prefix, output, opts)
+		require.NoError(err)
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		require.Len(lines, numEntries)
+		return lines
+	}
+	lines := dump(balancePrefix, StateDumpOptions{})
+	require.Len(lines, 5)
+	require.Equal(hex.EncodeToString(keys[2])+" (8 bytes): BalanceNanos (200)", lines[2])
+
+	// Values that aren't decoded only have their length.
+	lines = dump(Prefixes.PrefixPostHashToPostEntry, StateDumpOptions{})
+	require.Equal([]string{hex.EncodeToString(Prefixes.PrefixPostHashToPostEntry) + "01 (2 bytes)"}, lines)
+
+	// The key range and the limit restrict the dump.
+	lines = dump(balancePrefix, StateDumpOptions{StartKey: keys[1], EndKey: keys[4]})
+	require.Len(lines, 3)
+	require.True(strings.HasPrefix(lines[0], hex.EncodeToString(keys[1])))
+	lines = dump(balancePrefix, StateDumpOptions{StartKey: keys[1], Limit: 2})
+	require.Len(lines, 2)
+	require.True(strings.HasPrefix(lines[1], hex.EncodeToString(keys[2])))
+
+	prefix, exists := StatePrefixByName("PrefixPublicKeyToDeSoBalanceNanos")
+	require.True(exists)
+	require.Equal(balancePrefix, prefix)
+	_, exists = StatePrefixByName("PrefixDoesNotExist")
+	require.False(exists)
+}
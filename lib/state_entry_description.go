@@ -0,0 +1,157 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+
+	"github.com/davecgh/go-spew/spew"
+)
+
+// stateEntryDescriptionMaxLen is the length at which descriptions of state keys and values are truncated, so that a
+// diff of large entries stays readable.
+const stateEntryDescriptionMaxLen = 1024
+
+// statePrefixNames maps the prefix bytes to the names of their DBPrefixes fields, e.g. "PrefixPostHashToPostEntry".
+// It's built from the DBPrefixes struct, in the same way as GetStatePrefixes, so it never gets out of sync with the
+// prefixes.
+var statePrefixNames = getStatePrefixNames()
+
+func getStatePrefixNames() map[byte]string {
+	names := make(map[byte]string)
+	prefixes := &DBPrefixes{}
+	prefixElements := reflect.ValueOf(prefixes).Elem()
+	structFields := prefixElements.Type()
+	for i := 0; i < structFields.NumField(); i++ {
+		prefixId := getPrefixIdValue(structFields.Field(i), prefixElements.Field(i).Type())
+		if prefixBytes := prefixId.Bytes(); len(prefixBytes) > 0 {
+			names[prefixBytes[0]] = structFields.Field(i).Name
+		}
+	}
+	return names
+}
+
+// stateEntryDecoder describes the key fields and the value of a state entry that isn't stored as a DeSoEncoder, or
+// whose key holds fields that the encoder doesn't have.
+type stateEntryDecoder struct {
+	describeKey   func(key []byte) string
+	describeValue func(value []byte) string
+}
+
+// stateEntryDecoders are the custom decoders of state prefixes. Entries of other prefixes are decoded with their
+// DeSoEncoder from StatePrefixToDeSoEncoder, if they have one, or described as hex otherwise.
+var stateEntryDecoders = map[byte]*stateEntryDecoder{
+	Prefixes.PrefixPublicKeyToDeSoBalanceNanos[0]: {
+		describeKey: func(key []byte) string {
+			return fmt.Sprintf("PublicKey (%v)", PkToStringBoth(key))
+		},
+		describeValue: func(value []byte) string {
+			if len(value) != 8 {
+				return ""
+			}
+			return fmt.Sprintf("BalanceNanos (%v)", DecodeUint64(value))
+		},
+	},
+	Prefixes.PrefixPKIDToProfileEntry[0]: {
+		describeKey: func(key []byte) string {
+			return fmt.Sprintf("PKID (%v)", PkToStringBoth(key))
+		},
+	},
+	Prefixes.PrefixPostHashToPostEntry[0]: {
+		describeKey: func(key []byte) string {
+			return fmt.Sprintf("PostHash (%v)", hex.EncodeToString(key))
+		},
+	},
+}
+
+// stateEntrySpewConfig prints decoded entries without pointer addresses, so that equal entries print equally.
+var stateEntrySpewConfig = spew.ConfigState{
+	DisablePointerAddresses: true,
+	DisableCapacities:       true,
+	SortKeys:                true,
+}
+
+// StatePrefixName returns the name of the prefix of the key, e.g. "PrefixPostHashToPostEntry", or the prefix byte if
+// it isn't a known prefix.
+func StatePrefixName(key []byte) string {
+	if len(key) == 0 {
+		return "EmptyKey"
+	}
+	if name, exists := statePrefixNames[key[0]]; exists {
+		return name
+	}
+	return fmt.Sprintf("Prefix(%v)", key[0])
+}
+
+// StatePrefixByName returns the prefix with the name of its DBPrefixes field, e.g. "PrefixPostHashToPostEntry", which
+// is the inverse of StatePrefixName.
+func StatePrefixByName(name string) (_prefix []byte, _exists bool) {
+	for prefix, prefixName := range statePrefixNames {
+		if prefixName == name {
+			return []byte{prefix}, true
+		}
+	}
+	return nil, false
+}
+
+// DescribeStateKey returns a human-readable description of a state key: the name of its prefix, and its key fields
+// for prefixes that have a custom decoder, or the rest of the key as hex otherwise.
+func DescribeStateKey(key []byte) string {
+	if len(key) == 0 {
+		return StatePrefixName(key)
+	}
+	if decoder, exists := stateEntryDecoders[key[0]]; exists && decoder.describeKey != nil {
+		return truncateStateEntryDescription(fmt.Sprintf("%v %v", StatePrefixName(key), decoder.describeKey(key[1:])))
+	}
+	return truncateStateEntryDescription(fmt.Sprintf("%v %v", StatePrefixName(key), hex.EncodeToString(key[1:])))
+}
+
+// DescribeStateValue returns a human-readable description of the value of a state entry, decoded with the custom
+// decoder or the DeSoEncoder of its prefix. Values that can't be decoded are described as hex.
+func DescribeStateValue(key []byte, value []byte) string {
+	if description, decoded := DecodeStateValue(key, value); decoded {
+		return description
+	}
+	return truncateStateEntryDescription(hex.EncodeToString(value))
+}
+
+// DecodeStateValue is DescribeStateValue, but returns false instead of describing the value as hex if no decoder is
+// registered for the prefix of the key, or the value can't be decoded.
+func DecodeStateValue(key []byte, value []byte) (_description string, _decoded bool) {
+	if len(key) == 0 {
+		return "", false
+	}
+	if decoder, exists := stateEntryDecoders[key[0]]; exists && decoder.describeValue != nil {
+		if description := decoder.describeValue(value); description != "" {
+			return truncateStateEntryDescription(description), true
+		}
+	}
+	if isEncoder, encoder := StateKeyToDeSoEncoder(key); isEncoder && encoder != nil {
+		if exists, err := DecodeFromBytes(encoder, bytes.NewReader(value)); exists && err == nil {
+			return DescribeEncoder(encoder), true
+		}
+	}
+	return "", false
+}
+
+// DescribeEncoder returns a human-readable description of a decoded DeSoEncoder, e.g. a UtxoOperation, with its type
+// name and its fields.
+func DescribeEncoder(encoder DeSoEncoder) string {
+	return truncateStateEntryDescription(fmt.Sprintf("%v %v", reflect.TypeOf(encoder).Elem().Name(),
+		stateEntrySpewConfig.Sprintf("%+v", encoder)))
+}
+
+// DescribeValue returns a human-readable description of any value, e.g. a field of a decoded entry, in the same form
+// as DescribeEncoder, but without the type name.
+func DescribeValue(value interface{}) string {
+	return truncateStateEntryDescription(stateEntrySpewConfig.Sprintf("%+v", value))
+}
+
+func truncateStateEntryDescription(description string) string {
+	if len(description) <= stateEntryDescriptionMaxLen {
+		return description
+	}
+	return fmt.Sprintf("%v... (%v more bytes)", description[:stateEntryDescriptionMaxLen],
+		len(description)-stateEntryDescriptionMaxLen)
+}
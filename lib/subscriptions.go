@@ -0,0 +1,57 @@
+package lib
+
+import "sync"
+
+// subscriptionList fans values out to subscriber channels. Values are usually published while holding the
+// ChainLock or the server's locks, so publishing never blocks: when a subscriber's channel is full, the oldest
+// undelivered value is dropped to make room for the new one. This means a slow subscriber always sees the most recent
+// values, but may miss some in between, so subscribers should treat values as hints and re-check whatever state they
+// are waiting on. The zero value is an empty list that's ready to use.
+type subscriptionList[T any] struct {
+	mtx         sync.Mutex
+	subscribers map[chan T]struct{}
+}
+
+// subscribe registers a new subscriber channel with the provided buffer size. The returned function unsubscribes the
+// channel. The channel is never closed, so that receivers don't mistake the closure for a published value.
+func (list *subscriptionList[T]) subscribe(bufferSize int) (_values <-chan T, _unsubscribe func()) {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	values := make(chan T, bufferSize)
+
+	list.mtx.Lock()
+	defer list.mtx.Unlock()
+	if list.subscribers == nil {
+		list.subscribers = make(map[chan T]struct{})
+	}
+	list.subscribers[values] = struct{}{}
+
+	return values, func() {
+		list.mtx.Lock()
+		defer list.mtx.Unlock()
+		delete(list.subscribers, values)
+	}
+}
+
+// publish sends the value to all subscribers, dropping the oldest buffered value of subscribers that fell behind.
+func (list *subscriptionList[T]) publish(value T) {
+	list.mtx.Lock()
+	defer list.mtx.Unlock()
+
+	for values := range list.subscribers {
+		for sent := false; !sent; {
+			select {
+			case values <- value:
+				sent = true
+			default:
+				// The channel is full, so drop the oldest value. The subscriber could have emptied the channel in
+				// the meantime, which is why this receive can't block either.
+				select {
+				case <-values:
+				default:
+				}
+			}
+		}
+	}
+}
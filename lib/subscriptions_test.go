@@ -0,0 +1,40 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubscriptionList(t *testing.T) {
+	require := require.New(t)
+
+	var list subscriptionList[int]
+	values, unsubscribe := list.subscribe(2)
+
+	// Publishing to a full channel shouldn't block, and should drop the oldest values.
+	for ii := 1; ii <= 5; ii++ {
+		list.publish(ii)
+	}
+	require.Equal(4, <-values)
+	require.Equal(5, <-values)
+
+	// Unsubscribed channels don't receive values anymore.
+	unsubscribe()
+	list.publish(6)
+	require.Len(values, 0)
+}
+
+func TestSubscribeChainState(t *testing.T) {
+	require := require.New(t)
+
+	bc := &Blockchain{}
+	states, unsubscribe := bc.SubscribeChainState()
+	defer unsubscribe()
+
+	// The chain state is only published when it changes.
+	bc.publishChainState()
+	bc.publishChainState()
+	require.Equal(SyncStateSyncingHeaders, <-states)
+	require.Len(states, 0)
+}
@@ -0,0 +1,48 @@
+package lib
+
+import (
+	"time"
+
+	chainlib "github.com/btcsuite/btcd/blockchain"
+)
+
+// TimeSource is the clock of a node. It's a MedianTimeSource that also tells the node's local time, which Now
+// returns, so that the node's subsystems share a clock that tests can skew, see OffsetTimeSource.
+type TimeSource interface {
+	chainlib.MedianTimeSource
+
+	// Now returns the node's local time, before it's adjusted by its peers' clocks.
+	Now() time.Time
+}
+
+// OffsetTimeSource is a TimeSource whose clock is skewed from the machine clock by a fixed offset. Nodes
+// normally run with a zero offset, but tests use a non-zero offset to simulate clock skew between nodes running on
+// the same machine, e.g. a miner whose clock runs a few hours ahead of its peers.
+type OffsetTimeSource struct {
+	chainlib.MedianTimeSource
+	offset time.Duration
+}
+
+// NewOffsetTimeSource returns a median time source whose local clock is offset from the machine clock.
+func NewOffsetTimeSource(offset time.Duration) *OffsetTimeSource {
+	return &OffsetTimeSource{
+		MedianTimeSource: chainlib.NewMedianTime(),
+		offset:           offset,
+	}
+}
+
+// Now returns the node's local time, i.e. the machine time shifted by the offset.
+func (timeSource *OffsetTimeSource) Now() time.Time {
+	return time.Now().Add(timeSource.offset)
+}
+
+// AdjustedTime returns the node's local time adjusted by the median offset of its peers' clocks.
+func (timeSource *OffsetTimeSource) AdjustedTime() time.Time {
+	return timeSource.MedianTimeSource.AdjustedTime().Add(timeSource.offset)
+}
+
+// AddTimeSample adds a peer's time sample. The underlying median time source compares samples with the machine
+// clock, so we shift them by our offset to get the peer's offset relative to our local clock.
+func (timeSource *OffsetTimeSource) AddTimeSample(sourceID string, timeVal time.Time) {
+	timeSource.MedianTimeSource.AddTimeSample(sourceID, timeVal.Add(-timeSource.offset))
+}
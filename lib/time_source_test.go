@@ -0,0 +1,24 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOffsetTimeSource(t *testing.T) {
+	require := require.New(t)
+
+	offset := 3 * time.Hour
+	timeSource := NewOffsetTimeSource(offset)
+	require.WithinDuration(time.Now().Add(offset), timeSource.Now(), time.Second)
+	require.WithinDuration(time.Now().Add(offset), timeSource.AdjustedTime(), time.Second)
+
+	// Peers whose clocks agree with our skewed clock shouldn't shift the adjusted time.
+	for ii := 0; ii < 10; ii++ {
+		timeSource.AddTimeSample(string(rune('a'+ii)), timeSource.Now())
+	}
+	require.Zero(timeSource.Offset())
+	require.WithinDuration(time.Now().Add(offset), timeSource.AdjustedTime(), time.Second)
+}
@@ -37,17 +37,28 @@ type TXIndex struct {
 	killed            bool
 }
 
-func NewTXIndex(coreChain *Blockchain, params *DeSoParams, dataDirectory string) (
+// NewTXIndex creates the txindex of the core chain. The transactions are indexed in txIndexDb, or, if it's nil, in a db
+// opened under the data directory.
+func NewTXIndex(coreChain *Blockchain, params *DeSoParams, dataDirectory string, txIndexDb *badger.DB) (
 	_txindex *TXIndex, _error error) {
 	// Initialize database
-	txIndexDir := filepath.Join(GetBadgerDbPath(dataDirectory), "txindex")
-	txIndexOpts := PerformanceBadgerOptions(txIndexDir)
-	txIndexOpts.ValueDir = GetBadgerDbPath(txIndexDir)
-	glog.Infof("TxIndex BadgerDB Dir: %v", txIndexOpts.Dir)
-	glog.Infof("TxIndex BadgerDB ValueDir: %v", txIndexOpts.ValueDir)
-	txIndexDb, err := badger.Open(txIndexOpts)
-	if err != nil {
-		glog.Fatal(err)
+	if txIndexDb == nil {
+		txIndexDir := filepath.Join(GetBadgerDbPath(dataDirectory), "txindex")
+		txIndexOpts := PerformanceBadgerOptions(txIndexDir)
+		txIndexOpts.ValueDir = GetBadgerDbPath(txIndexDir)
+		glog.Infof("TxIndex BadgerDB Dir: %v", txIndexOpts.Dir)
+		glog.Infof("TxIndex BadgerDB ValueDir: %v", txIndexOpts.ValueDir)
+		var err error
+		txIndexDb, err = badger.Open(txIndexOpts)
+		if err != nil {
+			return nil, fmt.Errorf("NewTXIndex: Problem opening TxIndex db: %v", err)
+		}
+		// Close the db we've just opened if the txindex can't be initialized, so that it can be opened again.
+		defer func() {
+			if _error != nil {
+				txIndexDb.Close()
+			}
+		}()
 	}
 
 	// See if we have a best chain hash stored in the txindex db.
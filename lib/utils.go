@@ -9,6 +9,8 @@ import (
 	"github.com/unrolled/secure"
 	"math/big"
 	"strings"
+	"sync"
+	"time"
 )
 
 const SECURE_MIDDLEWARE_RESTRICTIVE_CONTENT_SECURITY_POLICY = "default-src 'self'"
@@ -211,3 +213,22 @@ func MapKeysToNonDeterministicPointerSlice[K comparable, V any](inputMap map[K]V
 	}
 	return outputSlice
 }
+
+// WaitWithTimeout waits for the wait group like sync.WaitGroup.Wait, but gives up after the timeout. It returns true
+// if the wait group finished in time. When it gives up, the goroutine waiting on the wait group keeps running until
+// the wait group finishes.
+func WaitWithTimeout(waitGroup *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		waitGroup.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
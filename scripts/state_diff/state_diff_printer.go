@@ -0,0 +1,50 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/deso-protocol/core/lib"
+)
+
+var (
+	flagDiff = flag.String(
+		"diff", "",
+		"Path to a state diff saved by a failed state comparison in the integration tests, e.g. "+
+			"/tmp/TestSimpleHyperSync_state_diff_123.json")
+
+	flagPrefix = flag.String(
+		"prefix", "",
+		"When set, only the differences of this prefix are printed. Should be the prefix name, e.g. "+
+			"PrefixPostHashToPostEntry")
+)
+
+// This file prints a state diff saved by the integration tests, with a line for every difference. For example:
+//
+// go run scripts/state_diff/state_diff_printer.go --diff /tmp/TestSimpleHyperSync_state_diff_123.json
+func main() {
+	flag.Parse()
+
+	if *flagDiff == "" {
+		fmt.Fprintln(os.Stderr, "--diff is required")
+		os.Exit(1)
+	}
+	report, err := lib.ReadStateDiffReport(*flagDiff)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *flagPrefix != "" {
+		var prefixes []*lib.PrefixDiffReport
+		for _, prefixReport := range report.Prefixes {
+			if prefixReport.PrefixName == *flagPrefix {
+				prefixes = append(prefixes, prefixReport)
+			}
+		}
+		report.Prefixes = prefixes
+	}
+	for _, line := range report.Lines() {
+		fmt.Println(line)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/deso-protocol/core/lib"
+	"github.com/dgraph-io/badger/v3"
+)
+
+var (
+	flagDataDir = flag.String(
+		"datadir", "",
+		"Data directory of a stopped node, e.g. the data directory of a node from a failed integration test")
+
+	flagPrefix = flag.String(
+		"prefix", "",
+		"Prefix to dump. Should be the prefix name, e.g. PrefixPostHashToPostEntry, or the hex prefix byte, e.g. 11")
+
+	flagStartKey = flag.String(
+		"start_key", "",
+		"When set, only the entries with keys from this hex key inclusive are dumped. Includes the prefix byte")
+
+	flagEndKey = flag.String(
+		"end_key", "",
+		"When set, only the entries with keys up to this hex key exclusive are dumped. Includes the prefix byte")
+
+	flagLimit = flag.Int(
+		"limit", 100,
+		"Maximum number of entries to dump. Zero dumps every entry of the prefix")
+)
+
+// This file dumps the state entries of a single prefix from a stopped node's data directory, with a line for every
+// entry. For example:
+//
+// go run scripts/state_dump/state_dump.go --datadir /tmp/badgerdb123 --prefix PrefixPostHashToPostEntry --limit 10
+func main() {
+	flag.Parse()
+
+	if *flagDataDir == "" || *flagPrefix == "" {
+		exitWithError(fmt.Errorf("--datadir and --prefix are required"))
+	}
+	prefix, exists := lib.StatePrefixByName(*flagPrefix)
+	if !exists {
+		var err error
+		if prefix, err = hex.DecodeString(*flagPrefix); err != nil || len(prefix) == 0 {
+			exitWithError(fmt.Errorf("unknown prefix (%v)", *flagPrefix))
+		}
+	}
+	opts := lib.StateDumpOptions{Limit: *flagLimit}
+	var err error
+	if opts.StartKey, err = hex.DecodeString(*flagStartKey); err != nil {
+		exitWithError(fmt.Errorf("invalid --start_key: %v", err))
+	}
+	if opts.EndKey, err = hex.DecodeString(*flagEndKey); err != nil {
+		exitWithError(fmt.Errorf("invalid --end_key: %v", err))
+	}
+
+	dbOpts := lib.PerformanceBadgerOptions(lib.GetBadgerDbPath(*flagDataDir))
+	dbOpts.ReadOnly = true
+	dbOpts.Logger = nil
+	db, err := badger.Open(dbOpts)
+	if err != nil {
+		exitWithError(fmt.Errorf("problem opening the db of (%v), is the node still running? %v", *flagDataDir, err))
+	}
+	defer db.Close()
+
+	numEntries, err := lib.DumpStatePrefix(db, prefix, os.Stdout, opts)
+	if err != nil {
+		exitWithError(err)
+	}
+	fmt.Fprintf(os.Stderr, "Dumped (%v) entries of prefix %v\n", numEntries, lib.StatePrefixName(prefix))
+}
+
+func exitWithError(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}